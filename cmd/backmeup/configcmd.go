@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// newConfigCommand implements `backmeup config rollback`, which restores a
+// configuration that previously loaded successfully
+func newConfigCommand() *cobra.Command {
+	var configPath string
+	var list bool
+	var to int
+
+	rollback := &cobra.Command{
+		Use:   "rollback",
+		Short: "Restore a previously loaded configuration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigRollback(configPath, list, to)
+		},
+	}
+	rollback.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	rollback.Flags().BoolVar(&list, "list", false, "List the saved versions instead of rolling back")
+	rollback.Flags().IntVar(&to, "to", 0, "Number of the version to restore, as shown by --list (default: the one before the current file)")
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage saved configuration versions",
+	}
+	cmd.AddCommand(rollback)
+	return cmd
+}
+
+func runConfigRollback(configPath string, list bool, to int) error {
+	versions := config.NewVersionStore(configPath, 0)
+	saved, err := versions.List()
+	if err != nil {
+		return err
+	}
+	if len(saved) == 0 {
+		return fmt.Errorf("no saved versions of %s", configPath)
+	}
+
+	current, _ := os.ReadFile(configPath)
+	if list {
+		for i, version := range saved {
+			marker := ""
+			if data, err := os.ReadFile(version.Path); err == nil && bytes.Equal(data, current) {
+				marker = " (current)"
+			}
+			fmt.Printf("%d  %s  %s%s\n", i+1, version.SavedAt.Format("2006-01-02 15:04:05"), version.Path, marker)
+		}
+		return nil
+	}
+
+	index := to - 1
+	if to == 0 {
+		// The newest version is the running one unless the file was edited since
+		index = 0
+		if data, err := os.ReadFile(saved[0].Path); err == nil && bytes.Equal(data, current) {
+			index = 1
+		}
+	}
+	if index < 0 || index >= len(saved) {
+		return fmt.Errorf("no version to roll back to, %d versions are saved", len(saved))
+	}
+
+	if err := versions.Restore(saved[index], configPath); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s from the version saved at %s, the replaced file is kept as %s.rejected\n",
+		configPath, saved[index].SavedAt.Format("2006-01-02 15:04:05"), configPath)
+	fmt.Println("Send SIGHUP to a running backmeup or restart it to apply the change")
+	return nil
+}
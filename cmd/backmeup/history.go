@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// newHistoryCommand implements `backmeup history export`, which writes the
+// recorded runs as CSV or JSON for spreadsheets and BI tools
+func newHistoryCommand() *cobra.Command {
+	var configPath, format, since, job, output string
+
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Export recorded runs as CSV or JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistoryExport(configPath, format, since, job, output)
+		},
+	}
+	export.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	export.Flags().StringVar(&format, "format", history.FormatCSV, "Output format, csv or json")
+	export.Flags().StringVar(&since, "since", "30d", "Export runs started since this age (30d, 12h), date or RFC 3339 time")
+	export.Flags().StringVar(&job, "job", "", "Only export the runs of this job")
+	export.Flags().StringVar(&output, "output", "", "Write to this file instead of stdout")
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Work with the recorded run history",
+	}
+	cmd.AddCommand(export)
+	return cmd
+}
+
+func runHistoryExport(configPath, format, since, job, output string) error {
+	if format != history.FormatCSV && format != history.FormatJSON {
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+	from, err := history.ParseSince(since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	store, err := history.Open(historyPath(cfg))
+	if err != nil {
+		return err
+	}
+	runs, err := store.Since(from)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	return history.Export(w, format, history.Filter(runs, job))
+}
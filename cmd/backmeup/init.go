@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/goccy/go-yaml"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// envVarName matches the names allowed in ${ENV_VAR} references
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// initAnswers holds the values a generated configuration is built from
+type initAnswers struct {
+	JobName      string
+	Host         string
+	Port         string
+	User         string
+	Database     string
+	PasswordEnv  string
+	Schedule     string
+	Keep         int
+	Directory    string
+	ServerEnable bool
+	ServerPort   int
+	Discord      bool
+	DiscordEnv   string
+	DiscordWhen  []string
+}
+
+var initTemplate = template.Must(template.New("config").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(`# Generated by backmeup init
+version: "1.0"
+
+server:
+  enabled: {{.ServerEnable}}
+  port: {{.ServerPort}}
+
+storage:
+  type: local
+  local:
+    directory: {{quote .Directory}}
+
+jobs:
+  - name: {{quote .JobName}}
+    type: postgres
+    postgres_config:
+      host: {{quote .Host}}
+      port: {{quote .Port}}
+      user: {{quote .User}}
+      password: "${{"{"}}{{.PasswordEnv}}{{"}"}}"
+      database: {{quote .Database}}
+    schedule: {{quote .Schedule}}
+    retention_policy:
+      type: count
+      value: {{.Keep}}
+    notification:
+      enabled: {{.Discord}}
+{{- if .Discord}}
+      discord:
+        when:
+{{- range .DiscordWhen}}
+          - {{quote .}}
+{{- end}}
+        webhook_url: "${{"{"}}{{.DiscordEnv}}{{"}"}}"
+{{- end}}
+`))
+
+// newInitCommand implements `backmeup init`, which writes a configuration for
+// a single PostgreSQL database backed up to local storage, asking for each
+// value that was not given as a flag when run in a terminal
+func newInitCommand() *cobra.Command {
+	var output string
+	var force, nonInteractive bool
+	answers := initAnswers{}
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Generate a configuration for a PostgreSQL database backed up to local storage",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(output); err == nil && !force {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", output)
+			}
+
+			if !nonInteractive && isTerminal(os.Stdin) {
+				given := make(map[string]bool)
+				cmd.Flags().Visit(func(f *pflag.Flag) { given[f.Name] = true })
+				if err := askInitAnswers(&answers, given, bufio.NewReader(os.Stdin), os.Stdout); err != nil {
+					return err
+				}
+			}
+			return writeInitConfig(output, answers)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&output, "config", "config.yml", "Path of the configuration file to write")
+	flags.BoolVar(&force, "force", false, "Overwrite an existing configuration file")
+	flags.BoolVar(&nonInteractive, "non-interactive", false, "Use the flags and defaults without asking")
+	flags.StringVar(&answers.JobName, "name", "postgres", "Job name")
+	flags.StringVar(&answers.Host, "host", "localhost", "PostgreSQL host")
+	flags.StringVar(&answers.Port, "port", "5432", "PostgreSQL port")
+	flags.StringVar(&answers.User, "user", "postgres", "PostgreSQL user")
+	flags.StringVar(&answers.Database, "database", "postgres", "Database to back up")
+	flags.StringVar(&answers.PasswordEnv, "password-env", "POSTGRES_PASSWORD", "Environment variable holding the PostgreSQL password")
+	flags.StringVar(&answers.Schedule, "schedule", "0 2 * * *", "Cron schedule of the backup")
+	flags.IntVar(&answers.Keep, "keep", 7, "Number of backups to keep")
+	flags.StringVar(&answers.Directory, "directory", "./backups", "Local directory backups are written to")
+	flags.IntVar(&answers.ServerPort, "server-port", 8080, "Port of the health and metrics server, 0 disables it")
+	flags.BoolVar(&answers.Discord, "discord", false, "Send Discord notifications")
+	flags.StringVar(&answers.DiscordEnv, "discord-webhook-env", "DISCORD_WEBHOOK_URL", "Environment variable holding the Discord webhook URL")
+	return cmd
+}
+
+// writeInitConfig renders, checks and writes the configuration, then tells
+// the user what is left to do
+func writeInitConfig(output string, answers initAnswers) error {
+	data, err := renderInitConfig(answers)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(output, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+
+	fmt.Printf("Wrote %s\n\nBefore starting backmeup, set:\n", output)
+	fmt.Printf("  export %s=...\n", answers.PasswordEnv)
+	if answers.Discord {
+		fmt.Printf("  export %s=https://discord.com/api/webhooks/...\n", answers.DiscordEnv)
+	}
+	fmt.Printf("\nThen check and run it with:\n  backmeup validate --config %s\n  backmeup --config %s\n", output, output)
+	return nil
+}
+
+// askInitAnswers prompts for every value that was not given as a flag,
+// offering the current value as the default
+func askInitAnswers(a *initAnswers, given map[string]bool, in *bufio.Reader, out io.Writer) error {
+	prompts := []struct {
+		flag   string
+		prompt string
+		value  *string
+		check  func(string) error
+	}{
+		{"name", "Job name", &a.JobName, nil},
+		{"host", "PostgreSQL host", &a.Host, nil},
+		{"port", "PostgreSQL port", &a.Port, nil},
+		{"user", "PostgreSQL user", &a.User, nil},
+		{"database", "Database to back up", &a.Database, nil},
+		{"password-env", "Environment variable holding the password", &a.PasswordEnv, checkEnvVarName},
+		{"schedule", "Cron schedule", &a.Schedule, checkSchedule},
+		{"directory", "Backup directory", &a.Directory, nil},
+	}
+	for _, p := range prompts {
+		if given[p.flag] {
+			continue
+		}
+		for {
+			answer, err := ask(in, out, p.prompt, *p.value)
+			if err != nil {
+				return err
+			}
+			if p.check != nil {
+				if err := p.check(answer); err != nil {
+					fmt.Fprintln(out, err)
+					continue
+				}
+			}
+			*p.value = answer
+			break
+		}
+	}
+
+	if !given["keep"] {
+		for {
+			answer, err := ask(in, out, "Backups to keep", strconv.Itoa(a.Keep))
+			if err != nil {
+				return err
+			}
+			if keep, err := strconv.Atoi(answer); err == nil && keep > 0 {
+				a.Keep = keep
+				break
+			}
+			fmt.Fprintln(out, "Please enter a positive number")
+		}
+	}
+
+	if !given["discord"] {
+		answer, err := ask(in, out, "Send Discord notifications? (y/n)", "n")
+		if err != nil {
+			return err
+		}
+		a.Discord = strings.HasPrefix(strings.ToLower(answer), "y")
+	}
+	for a.Discord && !given["discord-webhook-env"] {
+		answer, err := ask(in, out, "Environment variable holding the webhook URL", a.DiscordEnv)
+		if err != nil {
+			return err
+		}
+		if err := checkEnvVarName(answer); err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		a.DiscordEnv = answer
+		break
+	}
+	return nil
+}
+
+func checkEnvVarName(name string) error {
+	if !envVarName.MatchString(name) {
+		return fmt.Errorf("invalid environment variable name: %s", name)
+	}
+	return nil
+}
+
+// checkSchedule parses a cron schedule, which Validate leaves to the scheduler
+func checkSchedule(schedule string) error {
+	if _, err := cron.ParseStandard(schedule); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", schedule, err)
+	}
+	return nil
+}
+
+// ask prints a prompt and returns the answer, or the default when it is empty
+func ask(in *bufio.Reader, out io.Writer, prompt, def string) (string, error) {
+	fmt.Fprintf(out, "%s [%s]: ", prompt, def)
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// renderInitConfig writes the configuration and checks that it validates, so
+// that init never produces a file backmeup refuses to start with
+func renderInitConfig(a initAnswers) ([]byte, error) {
+	if err := checkEnvVarName(a.PasswordEnv); err != nil {
+		return nil, err
+	}
+	if a.Discord {
+		if err := checkEnvVarName(a.DiscordEnv); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSchedule(a.Schedule); err != nil {
+		return nil, err
+	}
+	a.ServerEnable = a.ServerPort != 0
+	if !a.ServerEnable {
+		a.ServerPort = 8080
+	}
+	a.DiscordWhen = []string{"success", "failure"}
+
+	var buf bytes.Buffer
+	if err := initTemplate.Execute(&buf, a); err != nil {
+		return nil, fmt.Errorf("failed to render config: %w", err)
+	}
+
+	// ${ENV_VAR} references stay unresolved here, the variables are usually
+	// only set where backmeup runs
+	var cfg config.Config
+	if err := yaml.Unmarshal(buf.Bytes(), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse generated config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("generated config is invalid: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/storage/split"
+)
+
+// newJoinCommand implements `backmeup join`, which reassembles a split
+// artifact from its parts and verifies it against the manifest
+func newJoinCommand() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "join <dir" + split.DirSuffix + ">",
+		Short: "Reassemble a split backup and verify its checksums",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runJoin(args[0], output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file, defaults to the original artifact name in the current directory")
+	return cmd
+}
+
+func runJoin(dir, output string) error {
+	manifest, err := split.ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	target := output
+	if target == "" {
+		target = filepath.Base(manifest.Name)
+	}
+	if strings.HasSuffix(filepath.Clean(target), split.DirSuffix) {
+		return fmt.Errorf("refusing to write the output into a split directory name: %s", target)
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	if _, err := split.Join(dir, f); err != nil {
+		f.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	fmt.Printf("Reassembled %s (%d bytes, %d parts), checksum verified\n", target, manifest.Size, len(manifest.Parts))
+	return nil
+}
@@ -2,52 +2,102 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/thitiph0n/backmeup/internal/backup"
+	"github.com/thitiph0n/backmeup/internal/clock"
 	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/coordination"
+	"github.com/thitiph0n/backmeup/internal/discovery"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/logfile"
+	"github.com/thitiph0n/backmeup/internal/notify"
+	"github.com/thitiph0n/backmeup/internal/redact"
+	"github.com/thitiph0n/backmeup/internal/report"
 	"github.com/thitiph0n/backmeup/internal/scheduler"
 	"github.com/thitiph0n/backmeup/internal/server"
+	"github.com/thitiph0n/backmeup/internal/storage"
+	"github.com/thitiph0n/backmeup/internal/storage/rclone"
+	"github.com/thitiph0n/backmeup/internal/storage/webdav"
 )
 
 func main() {
-	// Define command-line flags
-	configPath := flag.String("config", "config.yml", "Path to configuration file")
-	flag.Parse()
+	if err := newRootCommand().Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+}
 
-	// Load configuration
-	cfg, err := config.LoadConfig(*configPath)
+// runDaemon schedules the configured jobs and serves the HTTP API until it
+// receives a termination signal
+func runDaemon(configPath string) {
+	// Load and validate the configuration, falling back to the last version
+	// that loaded successfully
+	cfg, err := loadConfig(configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	versions := config.NewVersionStore(configPath, cfg.Versions.Keep)
 
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
-		os.Exit(1)
+	// Mask credentials from the configuration in all log output, which goes
+	// to a rotating file when one is configured
+	redactor := redact.New(cfg.Secrets()...)
+	log.SetOutput(redactor.Writer(os.Stderr))
+	if cfg.Logging.File != "" {
+		logFile, err := logfile.New(cfg.Logging, clock.Real)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer logFile.Close()
+		log.SetOutput(redactor.Writer(logFile))
 	}
 
 	log.Printf("Configuration loaded successfully!")
 
-	// Create the job scheduler with storage configuration
-	jobScheduler := scheduler.NewJobScheduler(cfg.Storage)
+	// Warnings point at likely mistakes but never prevent startup
+	for _, warning := range cfg.Lint() {
+		log.Printf("Warning: %s", warning)
+	}
+
+	// Throughput and errors of the storage backends, served on /metrics/storage
+	storageMetrics := storage.NewMetrics()
+
+	// Create the job scheduler with storage, concurrency and history configuration
+	jobScheduler, runHistory, err := newScheduler(cfg, storageMetrics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	jobScheduler.SetRedactor(redactor)
+
+	newExecutor := func(jobConfig config.JobConfig) (scheduler.BackupExecutor, error) {
+		redactor.Add(jobConfig.Secrets()...)
+		return backup.CreateExecutor(jobConfig, cfg.Storage, storageMetrics, clock.Real)
+	}
+	reloader := newConfigReloader(configPath, versions, jobScheduler, newExecutor)
 
 	// Add each job from the configuration
+	scheduled := 0
 	for i, jobConfig := range cfg.Jobs {
 		log.Printf("Configuring job #%d: %s (%s)", i+1, jobConfig.Name, jobConfig.Type)
-		log.Printf("  Schedule: %s", jobConfig.Schedule)
+		log.Printf("  Schedule: %s", jobConfig.DescribeSchedule())
+		if jobConfig.ConcurrencyGroup != "" {
+			log.Printf("  Concurrency group: %s", jobConfig.ConcurrencyGroup)
+		}
 		log.Printf("  Retention policy: Keep %d %s", jobConfig.RetentionPolicy.Value,
 			jobConfig.RetentionPolicy.Type)
 
 		// Create the appropriate backup executor
-		executor, err := backup.CreateExecutor(jobConfig, cfg.Storage)
+		executor, err := backup.CreateExecutor(jobConfig, cfg.Storage, storageMetrics, clock.Real)
 		if err != nil {
 			log.Printf("Error creating executor for job %s: %v", jobConfig.Name, err)
 			continue
@@ -59,13 +109,93 @@ func main() {
 			continue
 		}
 
+		reloader.track(jobConfig, executor)
+		scheduled++
 		log.Printf("Job %s added to scheduler successfully", jobConfig.Name)
 	}
 
+	// Only a configuration that actually runs is worth rolling back to
+	if scheduled > 0 || len(cfg.Jobs) == 0 {
+		if err := versions.Save(cfg.Source()); err != nil {
+			log.Printf("Warning: failed to save config version: %v", err)
+		}
+	}
+
+	// Share jobs with other instances through the coordination backend
+	coordinationCtx, stopCoordination := context.WithCancel(context.Background())
+	defer stopCoordination()
+	coordinationDone := make(chan struct{})
+	if cfg.Coordination.Enabled {
+		coordinator, err := coordination.New(cfg.Coordination)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring coordination: %v\n", err)
+			os.Exit(1)
+		}
+		jobScheduler.SetCoordinator(coordinator)
+		go func() {
+			coordinator.Run(coordinationCtx)
+			close(coordinationDone)
+		}()
+	} else {
+		close(coordinationDone)
+	}
+
+	// Send finished runs to the channels of their jobs
+	notifyCtx, stopNotify := context.WithCancel(context.Background())
+	defer stopNotify()
+	notifier := notify.New(notifyCtx, jobScheduler, redactor)
+	jobScheduler.RegisterRunCallback(notifier.RunFinished)
+	jobScheduler.RegisterStatusCallback(func(jobName, status string, at time.Time) {
+		if status == scheduler.StatusRunning && jobName != "scheduler" {
+			notifier.RunStarted(jobName, at)
+		}
+	})
+
+	// Write daily and per-run reports
+	reportCtx, stopReports := context.WithCancel(context.Background())
+	defer stopReports()
+	if cfg.Report.Enabled {
+		reportDir := cfg.Report.Directory
+		if reportDir == "" {
+			reportDir = report.DefaultDirectory(cfg.Storage.Local.Directory)
+		}
+		reporter := report.New(cfg.Report, reportDir, runHistory, jobScheduler)
+		jobScheduler.RegisterStatusCallback(reporter.RunFinished)
+		go reporter.Run(reportCtx)
+	}
+
+	// Clean up after runs that were stopped by a crash or a restart
+	jobScheduler.PruneLeftovers()
+	if removed, reclaimed, err := backup.PruneTempFiles(time.Now().Add(-scheduler.RunTimeout)); err != nil {
+		log.Printf("Warning: failed to prune temporary files: %v", err)
+	} else if removed > 0 {
+		log.Printf("Pruned %d leftover temporary files and directories, reclaimed %d bytes", removed, reclaimed)
+	}
+
 	// Start the scheduler
 	jobScheduler.Start()
 	log.Printf("Backup scheduler started.")
 
+	// Register jobs from container labels and cluster resources while the scheduler runs
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	defer stopDiscovery()
+	if cfg.Discovery.Docker.Enabled {
+		dockerDiscovery, err := discovery.NewDocker(cfg, jobScheduler, newExecutor)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring Docker discovery: %v\n", err)
+			os.Exit(1)
+		}
+		go dockerDiscovery.Run(discoveryCtx)
+	}
+	if cfg.Discovery.Kubernetes.Enabled {
+		kubernetesDiscovery, err := discovery.NewKubernetes(cfg, jobScheduler, newExecutor)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring Kubernetes discovery: %v\n", err)
+			os.Exit(1)
+		}
+		go kubernetesDiscovery.Run(discoveryCtx)
+	}
+
 	// Variables for HTTP server
 	var httpServer *server.HTTPServer
 	var httpErrCh chan error
@@ -73,27 +203,32 @@ func main() {
 	// Check if HTTP server should be started
 	if cfg.Server.Enabled {
 		log.Printf("Starting HTTP server for health monitoring...")
-		httpServer, httpErrCh = startHTTPServer(cfg, jobScheduler)
+		httpServer, httpErrCh = startHTTPServer(cfg, jobScheduler, storageMetrics, runHistory, redactor)
 	} else {
 		log.Printf("HTTP server disabled in config. Skipping...")
 	}
 
-	// Wait for termination signal or HTTP server error
+	// Wait for termination signal or HTTP server error, reloading jobs on SIGHUP
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
 
-	// Block until we receive a signal or HTTP server error
-	if cfg.Server.Enabled {
+	// Block until we receive a signal or HTTP server error. httpErrCh is nil
+	// when the HTTP server is disabled, so it never fires.
+wait:
+	for {
 		select {
+		case <-hupCh:
+			log.Printf("Received SIGHUP, reloading jobs from %s...", configPath)
+			reloader.Reload()
 		case <-sigCh:
 			log.Printf("Received termination signal...")
+			break wait
 		case err := <-httpErrCh:
 			log.Printf("HTTP server error: %v", err)
+			break wait
 		}
-	} else {
-		// If HTTP server is disabled, just wait for the signal
-		<-sigCh
-		log.Printf("Received termination signal...")
 	}
 
 	log.Printf("Shutting down...")
@@ -109,16 +244,34 @@ func main() {
 		}
 	}
 
-	// Stop the scheduler
+	// Stop discovery, reports and the scheduler
+	stopDiscovery()
+	stopReports()
 	jobScheduler.Stop()
+
+	// Give the notifications in flight a few seconds before giving them up
+	time.AfterFunc(5*time.Second, stopNotify)
+	notifier.Wait()
+
+	// Leave the coordination group so other instances take over right away
+	stopCoordination()
+	<-coordinationDone
 	log.Printf("Shutdown complete.")
 }
 
 // startHTTPServer starts the HTTP server for health checks and metrics
 // It returns the server instance and an error channel that will receive any server errors
-func startHTTPServer(cfg *config.Config, jobScheduler *scheduler.JobScheduler) (*server.HTTPServer, chan error) {
+func startHTTPServer(cfg *config.Config, jobScheduler *scheduler.JobScheduler, storageMetrics *storage.Metrics, runHistory *history.Store, redactor *redact.Redactor) (*server.HTTPServer, chan error) {
 	// Create a new HTTP server
-	httpServer := server.NewHTTPServer(cfg.Server.Port, jobScheduler)
+	httpServer := server.NewHTTPServer(cfg.Server.Port, jobScheduler, storageMetrics)
+	httpServer.SetHistory(runHistory)
+	httpServer.SetBuildInfo(versionInfo())
+	httpServer.SetToken(cfg.Server.Token)
+	if cfg.Server.Debug.Enabled {
+		httpServer.EnableDebug(cfg.Server.Debug.Token)
+		log.Printf("Debug endpoints enabled under /debug")
+	}
+	httpServer.RedactResponses(redactor)
 
 	// Channel to receive errors from the HTTP server
 	errChan := make(chan error, 1)
@@ -134,3 +287,142 @@ func startHTTPServer(cfg *config.Config, jobScheduler *scheduler.JobScheduler) (
 	// Return the server and error channel
 	return httpServer, errChan
 }
+
+// loadConfig loads and validates the configuration file. When it is broken
+// and earlier versions were saved, the newest one is restored so that an
+// unattended host keeps running its backups.
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.LoadConfig(path)
+	if err == nil {
+		if err = cfg.Validate(); err != nil {
+			err = fmt.Errorf("invalid configuration: %w", err)
+		}
+	} else {
+		err = fmt.Errorf("error loading config: %w", err)
+	}
+	if err == nil {
+		return cfg, nil
+	}
+
+	versions := config.NewVersionStore(path, 0)
+	saved, listErr := versions.List()
+	if listErr != nil || len(saved) == 0 {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	if restoreErr := versions.Restore(saved[0], path); restoreErr != nil {
+		return nil, fmt.Errorf("%w (rollback failed: %v)", err, restoreErr)
+	}
+	fmt.Fprintf(os.Stderr, "Rolled back %s to the version saved at %s, the rejected file is kept as %s.rejected\n",
+		path, saved[0].SavedAt.Format("2006-01-02 15:04:05"), path)
+
+	cfg, err = config.LoadConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// newScheduler creates the job scheduler, uploading to remote storage when
+// configured and recording every run in the history
+func newScheduler(cfg *config.Config, storageMetrics *storage.Metrics) (*scheduler.JobScheduler, *history.Store, error) {
+	jobScheduler := scheduler.NewJobScheduler(cfg.Storage, cfg.Scheduler)
+
+	// Upload backups from the local staging directory to remote storage
+	remote, settings, err := newRemoteStorage(cfg.Storage, storageMetrics)
+	if err != nil {
+		return nil, nil, err
+	}
+	if remote != nil {
+		jobScheduler.SetRemoteStorage(remote, settings.KeepLocal)
+		if settings.Cache != nil {
+			jobScheduler.SetCache(*settings.Cache)
+		}
+		if window := settings.UploadWindow; window != "" {
+			if err := jobScheduler.SetUploadWindow(window); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Copy the backups of the jobs that list them to storage destinations
+	for _, name := range slices.Sorted(maps.Keys(cfg.Storage.Destinations)) {
+		d := cfg.Storage.Destinations[name]
+		remote, _, err := newRemoteStorage(d.Storage(cfg.Storage.Local), storageMetrics)
+		if err != nil {
+			return nil, nil, fmt.Errorf("storage destination %s: %w", name, err)
+		}
+		jobScheduler.AddDestination(name, d.Type, remote)
+	}
+	jobScheduler.SetStorageMetrics(storageMetrics, cfg.Storage.Type)
+
+	runHistory, err := openHistory(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open run history: %w", err)
+	}
+	jobScheduler.SetHistory(runHistory)
+	return jobScheduler, runHistory, nil
+}
+
+// remoteSettings are the staging settings shared by the remote storage types
+type remoteSettings struct {
+	KeepLocal    bool
+	Cache        *config.CacheConfig
+	UploadWindow string
+}
+
+// newRemoteStorage connects to the remote storage backups are uploaded to and
+// returns it with its staging settings, or nil for local storage
+func newRemoteStorage(cfg config.StorageConfig, metrics *storage.Metrics) (scheduler.RemoteStorage, *remoteSettings, error) {
+	var remote scheduler.RemoteStorage
+	var settings remoteSettings
+	var err error
+	switch cfg.Type {
+	case "s3":
+		settings = remoteSettings{cfg.S3.KeepLocal, cfg.S3.Cache, cfg.S3.UploadWindow}
+		remote, err = newS3Storage(*cfg.S3, metrics)
+	case "b2":
+		settings = remoteSettings{cfg.B2.KeepLocal, cfg.B2.Cache, cfg.B2.UploadWindow}
+		remote, err = newB2Storage(*cfg.B2, metrics)
+	case "webdav":
+		settings = remoteSettings{cfg.WebDAV.KeepLocal, cfg.WebDAV.Cache, cfg.WebDAV.UploadWindow}
+		remote, err = webdav.New(*cfg.WebDAV, metrics)
+	case "rclone":
+		settings = remoteSettings{cfg.Rclone.KeepLocal, cfg.Rclone.Cache, cfg.Rclone.UploadWindow}
+		remote, err = rclone.New(*cfg.Rclone, metrics)
+	default:
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure %s storage: %w", cfg.Type, err)
+	}
+	return remote, &settings, nil
+}
+
+// historyPath returns the configured run history file
+func historyPath(cfg *config.Config) string {
+	if cfg.History.Path != "" {
+		return cfg.History.Path
+	}
+	return history.DefaultPath(cfg.Storage.Local.Directory)
+}
+
+// openHistory opens the run history and prunes runs older than keep_days
+func openHistory(cfg *config.Config) (*history.Store, error) {
+	store, err := history.Open(historyPath(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	keepDays := cfg.History.KeepDays
+	if keepDays == 0 {
+		keepDays = history.DefaultKeepDays
+	}
+	if err := store.Prune(time.Now().AddDate(0, 0, -keepDays)); err != nil {
+		log.Printf("Warning: failed to prune run history: %v", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// newMaintenanceCommand implements `backmeup pause` and `backmeup resume` by
+// calling the /maintenance endpoint of a running daemon, or the
+// /jobs/{name}/pause endpoint for a single job
+func newMaintenanceCommand(command string) *cobra.Command {
+	var all bool
+	var addr, token, reason, until string
+	var ttl time.Duration
+
+	short := "Pause a job or all scheduled jobs of a running backmeup"
+	if command == "resume" {
+		short = "Resume a job or all scheduled jobs of a running backmeup"
+	}
+
+	cmd := &cobra.Command{
+		Use:   command + " (job | --all)",
+		Short: short,
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all == (len(args) > 0) {
+				return fmt.Errorf("%s requires a job name or --all", command)
+			}
+			if all {
+				return runMaintenance(command, addr, token, ttl, reason)
+			}
+			if command == "pause" && until == "" {
+				return fmt.Errorf("pausing a job requires --until, so that it is not forgotten")
+			}
+			return runJobPause(command, addr, token, args[0], until)
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "Apply to all jobs (maintenance mode)")
+	cmd.Flags().StringVar(&addr, "addr", "http://localhost:8080", "Address of the backmeup HTTP server")
+	cmd.Flags().StringVar(&token, "token", os.Getenv("BACKMEUP_TOKEN"), "Bearer token of the backmeup HTTP server (server.token), defaults to $BACKMEUP_TOKEN")
+	if command == "pause" {
+		cmd.Flags().DurationVar(&ttl, "ttl", 0, "Lift maintenance mode automatically after this duration")
+		cmd.Flags().StringVar(&reason, "reason", "", "Reason shown in the maintenance status")
+		cmd.Flags().StringVar(&until, "until", "", "Resume the job automatically at this date or local time, such as 2024-08-15")
+	}
+	return cmd
+}
+
+// runJobPause pauses or resumes the scheduled runs of one job
+func runJobPause(command, addr, token, jobName, until string) error {
+	endpoint := strings.TrimSuffix(addr, "/") + "/jobs/" + url.PathEscape(jobName) + "/pause"
+
+	var req *http.Request
+	var err error
+	if command == "pause" {
+		body, _ := json.Marshal(map[string]string{"until": until})
+		req, err = http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	} else {
+		req, err = http.NewRequest(http.MethodDelete, endpoint, nil)
+	}
+	if err != nil {
+		return err
+	}
+	authorize(req, token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach backmeup at %s: %w", addr, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var state struct {
+		Paused      bool      `json:"paused"`
+		PausedUntil time.Time `json:"pausedUntil"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if state.Paused {
+		fmt.Printf("Job %s paused until %s.\n", jobName, state.PausedUntil.Local().Format(time.RFC3339))
+	} else {
+		fmt.Printf("Job %s resumed, its scheduled runs will run again.\n", jobName)
+	}
+	return nil
+}
+
+func runMaintenance(command, addr, token string, ttl time.Duration, reason string) error {
+	url := strings.TrimSuffix(addr, "/") + "/maintenance"
+
+	var req *http.Request
+	var err error
+	if command == "pause" {
+		body, _ := json.Marshal(map[string]string{
+			"ttl":    durationString(ttl),
+			"reason": reason,
+		})
+		req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	} else {
+		req, err = http.NewRequest(http.MethodDelete, url, nil)
+	}
+	if err != nil {
+		return err
+	}
+	authorize(req, token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach backmeup at %s: %w", addr, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var state scheduler.MaintenanceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	switch {
+	case !state.Active:
+		fmt.Println("Maintenance mode lifted, scheduled jobs will run again.")
+	case state.Until.IsZero():
+		fmt.Println("Maintenance mode active until lifted with `backmeup resume --all`.")
+	default:
+		fmt.Printf("Maintenance mode active until %s.\n", state.Until.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// authorize adds the server token to a request to the backmeup HTTP server
+func authorize(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func durationString(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/runid"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// migratableStorage is remote storage that backups can be copied into and
+// out of, with their checksums verified
+type migratableStorage interface {
+	scheduler.RemoteStorage
+	Download(ctx context.Context, jobName, localDir string) (storage.DownloadResult, error)
+	Verify(ctx context.Context, jobName, localDir string) (int, error)
+}
+
+// migrateResult is the --output json document of `backmeup migrate-storage`
+type migrateResult struct {
+	outcome
+	From string         `json:"from"`
+	To   string         `json:"to"`
+	Jobs []jobMigration `json:"jobs"`
+}
+
+// jobMigration is what was copied of one job
+type jobMigration struct {
+	Job         string `json:"job"`
+	Backups     int    `json:"backups"`     // Backups now stored in the destination
+	Transferred int    `json:"transferred"` // Files copied
+	Skipped     int    `json:"skipped"`     // Files already in the destination
+	Bytes       int64  `json:"bytes"`
+	Verified    int    `json:"verified"` // Files whose checksum matched after the copy
+	Error       string `json:"error,omitempty"`
+}
+
+// newMigrateStorageCommand implements `backmeup migrate-storage`, which copies
+// the backups of existing jobs from one storage backend to another
+func newMigrateStorageCommand() *cobra.Command {
+	var configPath, output, from, to string
+	var jobNames []string
+
+	cmd := &cobra.Command{
+		Use:   "migrate-storage --from <backend> --to <backend>",
+		Short: "Copy existing backups to another storage backend",
+		Long: "Copies the backups of every job, or of the jobs given with --job, between the local directory and " +
+			"the s3 bucket of the configuration and verifies every copied file against its checksum. Nothing is " +
+			"removed from the source. Manifests and run history stay in the local directory, which both backends use.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+			backends := []string{"local", "s3"}
+			if !slices.Contains(backends, from) || !slices.Contains(backends, to) || from == to {
+				return usageError(fmt.Errorf("--from and --to must be two different backends of: local, s3"))
+			}
+
+			result := migrateResult{From: from, To: to, Jobs: []jobMigration{}}
+			err := migrateStorage(cmd.Context(), configPath, jobNames, to == "s3", &result)
+			if output == outputJSON {
+				result.outcome = newOutcome(err)
+				if encErr := jsonOutput().Encode(result); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+
+			for _, job := range result.Jobs {
+				if job.Error != "" {
+					fmt.Printf("%s: %s\n", job.Job, job.Error)
+					continue
+				}
+				fmt.Printf("%s: %d backups in %s, copied %d files (%s), skipped %d already there, verified %d\n",
+					job.Job, job.Backups, to, job.Transferred, formatSize(job.Bytes), job.Skipped, job.Verified)
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	cmd.Flags().StringVar(&from, "from", "", "Backend to copy from: local or s3")
+	cmd.Flags().StringVar(&to, "to", "", "Backend to copy to: local or s3")
+	cmd.Flags().StringSliceVar(&jobNames, "job", nil, "Only migrate this job, can be repeated")
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func migrateStorage(ctx context.Context, configPath string, jobNames []string, toS3 bool, result *migrateResult) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return configError(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return configError(fmt.Errorf("invalid configuration: %w", err))
+	}
+	if cfg.Storage.S3 == nil || cfg.Storage.S3.Endpoint == "" || cfg.Storage.S3.Bucket == "" {
+		return configError(fmt.Errorf("s3 storage must have an endpoint and bucket to migrate to or from it"))
+	}
+
+	jobs, err := selectJobs(cfg, jobNames)
+	if err != nil {
+		return err
+	}
+
+	remote, err := newS3Storage(*cfg.Storage.S3, nil)
+	if err != nil {
+		return configError(fmt.Errorf("failed to configure s3 storage: %w", err))
+	}
+	bucket, ok := remote.(migratableStorage)
+	if !ok {
+		return configError(fmt.Errorf("s3 storage cannot migrate backups"))
+	}
+	manifests := manifest.NewStore(manifest.DefaultDir(cfg.Storage.Local.Directory))
+
+	var failed []error
+	for _, jobConfig := range jobs {
+		localDir := filepath.Join(cfg.Storage.Local.Directory, jobConfig.Name)
+		job := jobMigration{Job: jobConfig.Name}
+
+		if toS3 {
+			err = migrateToBucket(ctx, bucket, manifests, jobConfig, localDir, &job)
+		} else {
+			err = migrateFromBucket(ctx, bucket, jobConfig, localDir, &job)
+		}
+		if err != nil {
+			failed = append(failed, err)
+			job.Error = err.Error()
+		}
+		result.Jobs = append(result.Jobs, job)
+	}
+
+	return jobsOutcome(failed, len(jobs))
+}
+
+// migrateToBucket uploads a job's local backups with the job's storage class
+// and tags, attributed to the runs recorded in their manifests
+func migrateToBucket(ctx context.Context, bucket migratableStorage, manifests *manifest.Store, jobConfig config.JobConfig, localDir string, job *jobMigration) error {
+	if configured, ok := bucket.(scheduler.ConfiguredStorage); ok {
+		if err := configured.ConfigureJob(ctx, jobConfig); err != nil {
+			return err
+		}
+	}
+
+	recorded, err := manifests.List(jobConfig.Name)
+	if err != nil {
+		return err
+	}
+	runIDs := make(map[string]string, len(recorded))
+	for _, m := range recorded {
+		runIDs[m.Backup] = m.RunID
+	}
+
+	uploaded, err := bucket.Upload(runid.WithBackups(ctx, runIDs), jobConfig.Name, localDir)
+	job.Backups = len(uploaded.Entries)
+	job.Transferred = uploaded.Uploaded + uploaded.Copied
+	job.Skipped = uploaded.Skipped
+	job.Bytes = uploaded.Bytes
+	if err != nil {
+		return err
+	}
+
+	job.Verified, err = bucket.Verify(ctx, jobConfig.Name, localDir)
+	return err
+}
+
+// migrateFromBucket downloads a job's backups, which are verified as they
+// arrive
+func migrateFromBucket(ctx context.Context, bucket migratableStorage, jobConfig config.JobConfig, localDir string, job *jobMigration) error {
+	downloaded, err := bucket.Download(ctx, jobConfig.Name, localDir)
+	job.Backups = len(downloaded.Entries)
+	job.Transferred = downloaded.Downloaded
+	job.Skipped = downloaded.Skipped
+	job.Bytes = downloaded.Bytes
+	if err != nil {
+		return err
+	}
+	job.Verified = downloaded.Downloaded + downloaded.Skipped
+	return nil
+}
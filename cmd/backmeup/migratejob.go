@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// jobRenamer is storage that can move the backups of a job to a new name
+type jobRenamer interface {
+	RenameJob(ctx context.Context, from, to string) (int, error)
+}
+
+// migrateJobResult is the --output json document of `backmeup migrate-job`
+type migrateJobResult struct {
+	outcome
+	From          string `json:"from"`
+	To            string `json:"to"`
+	RemoteBackups int    `json:"remote_backups"`
+	LocalBackups  int    `json:"local_backups"`
+	Manifests     int    `json:"manifests"`
+	Runs          int    `json:"runs"`
+	PendingUpload bool   `json:"pending_upload"` // The job was queued for the upload window
+}
+
+// newMigrateJobCommand implements `backmeup migrate-job`, which moves the
+// backups and records of a renamed job to its new name
+func newMigrateJobCommand() *cobra.Command {
+	var configPath, output string
+
+	cmd := &cobra.Command{
+		Use:   "migrate-job <old name> <new name>",
+		Short: "Move the backups and history of a renamed job to its new name",
+		Long: "Moves the backups of a job that was renamed in the configuration, in the bucket and in the local " +
+			"directory, along with their manifests, run history and queued upload, so that the job's retention " +
+			"policy applies to them again. The new name must be configured and the old one must not.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+
+			result := migrateJobResult{From: args[0], To: args[1]}
+			err := migrateJob(cmd.Context(), configPath, &result)
+			if output == outputJSON {
+				result.outcome = newOutcome(err)
+				if encErr := jsonOutput().Encode(result); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+
+			moved := fmt.Sprintf("%d local backups, %d manifests, %d runs in the history", result.LocalBackups, result.Manifests, result.Runs)
+			if result.RemoteBackups > 0 {
+				moved = fmt.Sprintf("%d backups in the bucket, %s", result.RemoteBackups, moved)
+			}
+			fmt.Printf("Moved job %s to %s: %s\n", result.From, result.To, moved)
+			if result.PendingUpload {
+				fmt.Printf("The pending upload of %s is now queued as %s\n", result.From, result.To)
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func migrateJob(ctx context.Context, configPath string, result *migrateJobResult) error {
+	from, to := result.From, result.To
+	if from == to {
+		return usageError(fmt.Errorf("the old and new names are the same"))
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return configError(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return configError(fmt.Errorf("invalid configuration: %w", err))
+	}
+	if _, err := selectJobs(cfg, []string{to}); err != nil {
+		return err
+	}
+	if _, err := selectJobs(cfg, []string{from}); err == nil {
+		return usageError(fmt.Errorf("job %s is still configured, rename it in the configuration first", from))
+	}
+
+	// The bucket goes first, it is the likeliest to refuse the move
+	remote, _, err := newRemoteStorage(cfg.Storage, nil)
+	if err != nil {
+		return configError(err)
+	}
+	if remote != nil {
+		renamer, ok := remote.(jobRenamer)
+		if !ok {
+			return configError(fmt.Errorf("%s storage cannot rename jobs", cfg.Storage.Type))
+		}
+		if result.RemoteBackups, err = renamer.RenameJob(ctx, from, to); err != nil {
+			return err
+		}
+	}
+
+	if result.LocalBackups, err = localfs.New(cfg.Storage.Local).RenameJob(ctx, from, to); err != nil {
+		return err
+	}
+	if result.Manifests, err = manifest.NewStore(manifest.DefaultDir(cfg.Storage.Local.Directory)).RenameJob(from, to); err != nil {
+		return err
+	}
+	runHistory, err := history.Open(historyPath(cfg))
+	if err != nil {
+		return err
+	}
+	if result.Runs, err = runHistory.RenameJob(from, to); err != nil {
+		return err
+	}
+	result.PendingUpload, err = scheduler.RenamePendingUpload(cfg.Storage.Local.Directory, from, to)
+	return err
+}
+
+// renameWarnings points out backups that belong to no configured job, which
+// is what renaming a job in the configuration leaves behind. Jobs found by
+// discovery are only known at runtime, so nothing is reported when it is on.
+func renameWarnings(cfg *config.Config) []string {
+	if cfg.Discovery.Docker.Enabled || cfg.Discovery.Kubernetes.Enabled {
+		return nil
+	}
+
+	localDir := cfg.Storage.Local.Directory
+	manifests := manifest.NewStore(manifest.DefaultDir(localDir))
+	names := make(map[string]bool)
+	for _, dir := range []string{localDir, manifest.DefaultDir(localDir)} {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				names[e.Name()] = true
+			}
+		}
+	}
+
+	// Configured jobs without any backups may be the new names
+	var unclaimed []config.JobConfig
+	for _, job := range cfg.Jobs {
+		if !names[job.Name] {
+			unclaimed = append(unclaimed, job)
+		}
+	}
+
+	var stranded []string
+	for name := range names {
+		if !slices.ContainsFunc(cfg.Jobs, func(job config.JobConfig) bool { return job.Name == name }) {
+			stranded = append(stranded, name)
+		}
+	}
+	sort.Strings(stranded)
+
+	var warnings []string
+	for _, name := range stranded {
+		candidates := unclaimed
+		if recorded, err := manifests.List(name); err == nil && len(recorded) > 0 {
+			candidates = slices.DeleteFunc(slices.Clone(unclaimed), func(job config.JobConfig) bool {
+				return job.Type != recorded[0].Type
+			})
+		}
+		if len(candidates) == 1 {
+			warnings = append(warnings, fmt.Sprintf("backups of '%s' belong to no configured job, if it was renamed to '%s' run `backmeup migrate-job %s %s` so that retention applies to them again",
+				name, candidates[0].Name, name, candidates[0].Name))
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("backups of '%s' belong to no configured job, if it was renamed run `backmeup migrate-job %s <new name>` so that retention applies to them again",
+			name, name))
+	}
+	return warnings
+}
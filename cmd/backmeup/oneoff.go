@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// oneOffLayouts are the formats --at accepts, in local time unless they carry
+// an offset
+var oneOffLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// oneOffResult is the --output json document of `backmeup run --at`
+type oneOffResult struct {
+	outcome
+	Scheduled []scheduler.OneOffRun `json:"scheduled"`
+}
+
+// parseRunAt parses the time given to --at
+func parseRunAt(value string) (time.Time, error) {
+	for _, layout := range oneOffLayouts {
+		if at, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --at time '%s', use a date and time such as 2024-07-01T02:00", value)
+}
+
+// scheduleOneOffRuns has a running daemon run each named job once at the
+// given time, through its /jobs/{name}/runs/scheduled endpoint
+func scheduleOneOffRuns(addr, token string, names []string, value, output string) error {
+	result := oneOffResult{Scheduled: []scheduler.OneOffRun{}}
+	err := postOneOffRuns(addr, token, names, value, &result)
+	if output == outputJSON {
+		result.outcome = newOutcome(err)
+		if encErr := jsonOutput().Encode(result); encErr != nil {
+			return encErr
+		}
+		return err
+	}
+
+	for _, run := range result.Scheduled {
+		fmt.Printf("Scheduled a one-off run of %s at %s (id %s)\n", run.Job, run.At.Local().Format(time.RFC3339), run.ID)
+	}
+	return err
+}
+
+func postOneOffRuns(addr, token string, names []string, value string, result *oneOffResult) error {
+	at, err := parseRunAt(value)
+	if err != nil {
+		return usageError(err)
+	}
+	body, _ := json.Marshal(map[string]time.Time{"at": at})
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, name := range names {
+		endpoint := strings.TrimSuffix(addr, "/") + "/jobs/" + url.PathEscape(name) + "/runs/scheduled"
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		authorize(req, token)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to reach backmeup at %s: %w", addr, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		if resp.StatusCode != http.StatusCreated {
+			return fmt.Errorf("failed to schedule job %s: server returned %d: %s", name, resp.StatusCode, strings.TrimSpace(string(data)))
+		}
+
+		var run scheduler.OneOffRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+		result.Scheduled = append(result.Scheduled, run)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/failure"
+)
+
+// Exit codes let scripts tell why a command failed
+const (
+	exitOK        = 0
+	exitExecution = 1 // A backup, upload or retention run failed
+	exitConfig    = 2 // The configuration could not be loaded or is invalid
+	exitPartial   = 3 // Some jobs or some databases of a job succeeded and others failed
+	exitUsage     = 4 // Unknown flags, missing arguments
+
+	// Every job failed for the same reason
+	exitConnection  = 5 // A database, bucket or API could not be reached
+	exitToolMissing = 6 // A dump tool is not installed
+	exitStorageFull = 7 // Storage ran out of space
+	exitTimeout     = 8 // Runs exceeded their deadline
+)
+
+// failureExitCodes maps the class of a job failure to an exit code
+var failureExitCodes = map[failure.Code]int{
+	failure.CodeConfig:      exitConfig,
+	failure.CodeConnection:  exitConnection,
+	failure.CodeToolMissing: exitToolMissing,
+	failure.CodeStorageFull: exitStorageFull,
+	failure.CodeTimeout:     exitTimeout,
+}
+
+// Values of --output
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// exitError is an error that ends the process with a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func configError(err error) error  { return &exitError{code: exitConfig, err: err} }
+func partialError(err error) error { return &exitError{code: exitPartial, err: err} }
+func usageError(err error) error   { return &exitError{code: exitUsage, err: err} }
+
+// exitCode returns the exit code for the error a command returned
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitExecution
+}
+
+// jobsOutcome is the error of a command that processed several jobs, given
+// the errors of the jobs that failed: none when all succeeded and a partial
+// failure when only some did. When every job failed for the same reason the
+// exit code says which.
+func jobsOutcome(failed []error, total int) error {
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(failed) < total || slices.ContainsFunc(failed, isPartial):
+		return partialError(fmt.Errorf("%d of %d jobs failed", len(failed), total))
+	}
+
+	err := fmt.Errorf("%d of %d jobs failed", len(failed), total)
+	code := failure.CodeOf(failed[0])
+	for _, jobErr := range failed[1:] {
+		if failure.CodeOf(jobErr) != code {
+			return err
+		}
+	}
+	if exit, ok := failureExitCodes[code]; ok {
+		return &exitError{code: exit, err: fmt.Errorf("%w: %s", err, code)}
+	}
+	return err
+}
+
+// isPartial reports whether a job failed on some of its artifacts only, or
+// stored its backups but failed to copy them to some of its destinations
+func isPartial(err error) bool {
+	var artifacts *failure.ArtifactsError
+	var replication *failure.ReplicationError
+	return (errors.As(err, &artifacts) && artifacts.Partial()) || errors.As(err, &replication)
+}
+
+// outcome is the part of every --output json document that says how the
+// command ended
+type outcome struct {
+	Status   string `json:"status"` // "ok", "failed", "config_error", "partial", "usage_error" or the class of a failure such as "timeout"
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newOutcome(err error) outcome {
+	code := exitCode(err)
+	o := outcome{ExitCode: code}
+	switch code {
+	case exitOK:
+		o.Status = "ok"
+	case exitConfig:
+		o.Status = "config_error"
+	case exitPartial:
+		o.Status = "partial"
+	case exitUsage:
+		o.Status = "usage_error"
+	case exitConnection:
+		o.Status = "connection_error"
+	case exitToolMissing:
+		o.Status = "tool_missing"
+	case exitStorageFull:
+		o.Status = "storage_full"
+	case exitTimeout:
+		o.Status = "timeout"
+	default:
+		o.Status = "failed"
+	}
+	if err != nil {
+		o.Error = err.Error()
+	}
+	return o
+}
+
+// addOutputFlag adds --output to a command that can print JSON
+func addOutputFlag(cmd *cobra.Command, output *string) {
+	cmd.Flags().StringVar(output, "output", outputText, "Output format, text or json")
+}
+
+func checkOutput(output string) error {
+	if output != outputText && output != outputJSON {
+		return usageError(fmt.Errorf("unsupported output format: %s", output))
+	}
+	return nil
+}
+
+// jsonOutput returns the encoder --output json documents are written with
+func jsonOutput() *json.Encoder {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc
+}
+
+// markUsageErrors makes flag and argument errors of every command exit with
+// exitUsage
+func markUsageErrors(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return usageError(err)
+	})
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(c *cobra.Command, a []string) error {
+			if err := args(c, a); err != nil {
+				return usageError(err)
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		markUsageErrors(sub)
+	}
+}
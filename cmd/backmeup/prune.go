@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/redact"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// pruneResult is the --output json document of `backmeup prune`
+type pruneResult struct {
+	outcome
+	DryRun bool       `json:"dry_run"`
+	Jobs   []jobPrune `json:"jobs"`
+}
+
+// jobPrune is what one job's retention policy removed, with the fields of
+// GET /jobs/{name}/retention/plan in the snake_case of the CLI's output
+type jobPrune struct {
+	Job            string       `json:"job"`
+	Action         string       `json:"action"` // "delete" or "trash"
+	Total          int          `json:"total"`
+	Kept           int          `json:"kept"`
+	Remove         []pruneEntry `json:"remove"`
+	Deferred       int          `json:"deferred"`
+	Protected      int          `json:"protected"`
+	PurgeTrash     []pruneEntry `json:"purge_trash"`
+	ReclaimedBytes int64        `json:"reclaimed_bytes"`
+	Error          string       `json:"error,omitempty"`
+}
+
+type pruneEntry struct {
+	Name    string    `json:"name"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// newPruneCommand implements `backmeup prune`, which applies retention
+// policies outside of a run
+func newPruneCommand() *cobra.Command {
+	var configPath, output string
+	var all, dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "prune [job...]",
+		Short: "Apply retention policies now",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+			if all == (len(args) > 0) {
+				return usageError(fmt.Errorf("name the jobs to prune or use --all"))
+			}
+
+			result := pruneResult{DryRun: dryRun, Jobs: []jobPrune{}}
+			err := pruneJobs(cmd.Context(), configPath, args, dryRun, &result)
+			if output == outputJSON {
+				result.outcome = newOutcome(err)
+				if encErr := jsonOutput().Encode(result); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+
+			verb := "removed"
+			if dryRun {
+				verb = "would remove"
+			}
+			for _, job := range result.Jobs {
+				if job.Error != "" {
+					fmt.Printf("%s: %s\n", job.Job, job.Error)
+					continue
+				}
+				fmt.Printf("%s: %s %d of %d backups, %d from trash, reclaiming %s\n",
+					job.Job, verb, len(job.Remove), job.Total, len(job.PurgeTrash), formatSize(job.ReclaimedBytes))
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	cmd.Flags().BoolVar(&all, "all", false, "Prune every configured job")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show what would be removed")
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func pruneJobs(ctx context.Context, configPath string, names []string, dryRun bool, result *pruneResult) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return configError(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return configError(fmt.Errorf("invalid configuration: %w", err))
+	}
+
+	jobs, err := selectJobs(cfg, names)
+	if err != nil {
+		return err
+	}
+
+	redactor := redact.New(cfg.Secrets()...)
+	log.SetOutput(redactor.Writer(os.Stderr))
+
+	jobScheduler, _, err := newScheduler(cfg, storage.NewMetrics())
+	if err != nil {
+		return configError(err)
+	}
+
+	var failed []error
+	for _, jobConfig := range jobs {
+		plan, err := jobScheduler.Prune(ctx, jobConfig, dryRun)
+
+		job := jobPrune{
+			Job:            jobConfig.Name,
+			Action:         "delete",
+			Total:          plan.Total,
+			Kept:           plan.Total - len(plan.Remove),
+			Remove:         pruneEntries(plan.Remove),
+			Deferred:       plan.Deferred,
+			Protected:      plan.Protected,
+			PurgeTrash:     pruneEntries(plan.Purge),
+			ReclaimedBytes: plan.ReclaimedBytes,
+		}
+		if plan.ToTrash {
+			job.Action = "trash"
+		}
+		if err != nil {
+			failed = append(failed, err)
+			job.Error = redactor.String(err.Error())
+		}
+		result.Jobs = append(result.Jobs, job)
+	}
+
+	return jobsOutcome(failed, len(jobs))
+}
+
+func pruneEntries(entries []storage.BackupEntry) []pruneEntry {
+	result := make([]pruneEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, pruneEntry{
+			Name:    filepath.Base(entry.Key),
+			ModTime: entry.ModTime,
+			Size:    entry.Size,
+		})
+	}
+	return result
+}
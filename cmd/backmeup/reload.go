@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// scheduledJob is a job from the configuration file along with the executor
+// it was scheduled with, so that it can be put back after a failed reload
+type scheduledJob struct {
+	config   config.JobConfig
+	executor scheduler.BackupExecutor
+}
+
+// configReloader applies job changes from the configuration file on SIGHUP.
+// Other settings, such as storage and the HTTP server, need a restart. When
+// the new file does not validate, or none of its jobs can be scheduled, the
+// running jobs are kept and the last good version is restored on disk.
+type configReloader struct {
+	path        string
+	versions    *config.VersionStore
+	scheduler   *scheduler.JobScheduler
+	newExecutor func(config.JobConfig) (scheduler.BackupExecutor, error)
+	jobs        map[string]scheduledJob
+}
+
+func newConfigReloader(path string, versions *config.VersionStore, jobScheduler *scheduler.JobScheduler,
+	newExecutor func(config.JobConfig) (scheduler.BackupExecutor, error)) *configReloader {
+	return &configReloader{
+		path:        path,
+		versions:    versions,
+		scheduler:   jobScheduler,
+		newExecutor: newExecutor,
+		jobs:        make(map[string]scheduledJob),
+	}
+}
+
+// track records a job scheduled at startup
+func (r *configReloader) track(jobConfig config.JobConfig, executor scheduler.BackupExecutor) {
+	r.jobs[jobConfig.Name] = scheduledJob{config: jobConfig, executor: executor}
+}
+
+// Reload reads the configuration file again and reconciles the scheduled jobs
+func (r *configReloader) Reload() {
+	cfg, err := config.LoadConfig(r.path)
+	if err != nil {
+		r.rollback(err)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		r.rollback(fmt.Errorf("invalid configuration: %w", err))
+		return
+	}
+
+	desired := make(map[string]config.JobConfig, len(cfg.Jobs))
+	for _, jobConfig := range cfg.Jobs {
+		desired[jobConfig.Name] = jobConfig
+	}
+
+	// Create executors before touching the scheduler, so that a config none of
+	// whose jobs work leaves the running jobs alone
+	prepared := make(map[string]scheduledJob)
+	var failed int
+	for name, jobConfig := range desired {
+		if current, ok := r.jobs[name]; ok && reflect.DeepEqual(current.config, jobConfig) {
+			continue
+		}
+		if _, ok := r.jobs[name]; !ok && r.scheduler.HasJob(name) {
+			log.Printf("Reload: ignoring job %s, a discovered job with the same name is scheduled", name)
+			failed++
+			continue
+		}
+		executor, err := r.newExecutor(jobConfig)
+		if err != nil {
+			log.Printf("Reload: error creating executor for job %s: %v", name, err)
+			failed++
+			continue
+		}
+		prepared[name] = scheduledJob{config: jobConfig, executor: executor}
+	}
+	if len(desired) > 0 && failed == len(desired) {
+		r.rollback(fmt.Errorf("none of the %d jobs could be created", len(desired)))
+		return
+	}
+
+	previous := make(map[string]scheduledJob, len(r.jobs))
+	for name, job := range r.jobs {
+		previous[name] = job
+	}
+
+	for _, name := range sortedNames(r.jobs) {
+		if _, ok := prepared[name]; !ok {
+			if _, keep := desired[name]; keep {
+				continue
+			}
+		}
+		if err := r.scheduler.RemoveJob(name); err != nil {
+			log.Printf("Reload: error removing job %s: %v", name, err)
+		}
+		delete(r.jobs, name)
+	}
+
+	added := 0
+	for _, name := range sortedNames(prepared) {
+		job := prepared[name]
+		if err := r.scheduler.AddJob(job.config, job.executor); err != nil {
+			log.Printf("Reload: error adding job %s: %v", name, err)
+			failed++
+			continue
+		}
+		r.jobs[name] = job
+		added++
+	}
+
+	if len(desired) > 0 && failed == len(desired) {
+		r.restore(previous)
+		r.rollback(fmt.Errorf("none of the %d jobs could be scheduled", len(desired)))
+		return
+	}
+
+	if err := r.versions.Save(cfg.Source()); err != nil {
+		log.Printf("Warning: failed to save config version: %v", err)
+	}
+	log.Printf("Configuration reloaded: %d jobs scheduled, %d added or changed, %d failed", len(r.jobs), added, failed)
+}
+
+// restore puts the jobs that were scheduled before a reload back in place
+func (r *configReloader) restore(previous map[string]scheduledJob) {
+	for _, name := range sortedNames(r.jobs) {
+		if err := r.scheduler.RemoveJob(name); err != nil {
+			log.Printf("Reload: error removing job %s: %v", name, err)
+		}
+	}
+	r.jobs = make(map[string]scheduledJob, len(previous))
+	for _, name := range sortedNames(previous) {
+		job := previous[name]
+		if err := r.scheduler.AddJob(job.config, job.executor); err != nil {
+			log.Printf("Reload: error restoring job %s: %v", name, err)
+			continue
+		}
+		r.jobs[name] = job
+	}
+}
+
+// rollback replaces the rejected configuration file with the last version
+// that loaded successfully
+func (r *configReloader) rollback(cause error) {
+	log.Printf("Reload rejected, keeping the running jobs: %v", cause)
+
+	versions, err := r.versions.List()
+	if err != nil {
+		log.Printf("Warning: failed to roll back config: %v", err)
+		return
+	}
+	if len(versions) == 0 {
+		log.Printf("Warning: no saved config version to roll back to")
+		return
+	}
+	if err := r.versions.Restore(versions[0], r.path); err != nil {
+		log.Printf("Warning: failed to roll back config: %v", err)
+		return
+	}
+	log.Printf("Rolled back %s to the version saved at %s, the rejected file is kept as %s.rejected",
+		r.path, versions[0].SavedAt.Format("2006-01-02 15:04:05"), r.path)
+}
+
+func sortedNames(jobs map[string]scheduledJob) []string {
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/backup"
+	"github.com/thitiph0n/backmeup/internal/clock"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// restoreCheckResult is the --output json document of `backmeup restore-check`
+type restoreCheckResult struct {
+	outcome
+	Manifest *manifest.Manifest `json:"manifest,omitempty"`
+	Target   *manifest.Source   `json:"target,omitempty"`
+	Format   *manifest.Format   `json:"format,omitempty"`
+	Steps    []string           `json:"restore_steps,omitempty"`
+	Sequence []string           `json:"restore_sequence,omitempty"`
+	Warnings []string           `json:"warnings"`
+}
+
+// newRestoreCheckCommand implements `backmeup restore-check`, which compares
+// the manifest of a backup with the job's database before a restore
+func newRestoreCheckCommand() *cobra.Command {
+	var configPath, output string
+
+	cmd := &cobra.Command{
+		Use:   "restore-check <job> <backup>",
+		Short: "Check that a backup can be restored into the job's database",
+		Long: "Compares the server and schema versions recorded in the manifest of a backup with those of " +
+			"the job's database and warns about restores across incompatible versions. It also tells how the " +
+			"backup is encoded and which steps restore it.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+
+			result := restoreCheckResult{Warnings: []string{}}
+			err := restoreCheck(cmd, configPath, args[0], args[1], &result)
+			if output == outputJSON {
+				result.outcome = newOutcome(err)
+				if encErr := jsonOutput().Encode(result); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+
+			if result.Manifest != nil && result.Manifest.Source != nil {
+				source := result.Manifest.Source
+				fmt.Printf("backup: %s %s, schema %s\n", source.Engine, source.ServerVersion, orUnknown(source.SchemaVersion))
+			}
+			if result.Target != nil {
+				fmt.Printf("target: %s %s, schema %s\n", result.Target.Engine, result.Target.ServerVersion, orUnknown(result.Target.SchemaVersion))
+			}
+			if result.Manifest != nil {
+				if node := result.Manifest.Node; node != nil {
+					role := "primary"
+					if node.Standby {
+						role = "standby"
+					}
+					fmt.Printf("dumped from: %s (%s)\n", node.Host, role)
+				}
+				for _, c := range result.Manifest.Coordinates {
+					fmt.Printf("coordinates (%s): %s %s:%d", c.Kind, c.Database, c.LogFile, c.LogPosition)
+					if c.GTIDSet != "" {
+						fmt.Printf(", gtid %s", c.GTIDSet)
+					}
+					fmt.Println()
+				}
+			}
+			if len(result.Sequence) > 0 {
+				fmt.Printf("restore sequence: %s\n", strings.Join(result.Sequence, ", "))
+			}
+			if result.Format != nil {
+				fmt.Printf("format: %s\n", describeFormat(*result.Format))
+				for i, step := range result.Steps {
+					fmt.Printf("  %d. %s\n", i+1, step)
+				}
+			}
+			for _, warning := range result.Warnings {
+				fmt.Printf("warning: %s\n", warning)
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func restoreCheck(cmd *cobra.Command, configPath, jobName, backupName string, result *restoreCheckResult) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return configError(err)
+	}
+	jobs, err := selectJobs(cfg, []string{jobName})
+	if err != nil {
+		return err
+	}
+	jobConfig := jobs[0]
+
+	store := manifest.NewStore(manifest.DefaultDir(cfg.Storage.Local.Directory))
+	m, err := store.Read(jobName, backupName)
+	if err != nil {
+		return err
+	}
+	result.Manifest = &m
+
+	// Increments restore on top of every backup before them in their chain
+	if m.Chain != nil && m.Chain.Parent != "" {
+		sequence, err := store.Sequence(jobName, backupName)
+		if err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+		} else {
+			result.Sequence = sequence
+		}
+	}
+
+	// Manifests written before formats were recorded are completed from the
+	// local copy when there is one
+	format := m.Format
+	if format == nil {
+		if detected, err := manifest.DetectFormat(filepath.Join(cfg.Storage.Local.Directory, jobName, backupName)); err == nil {
+			format = &detected
+		}
+	}
+	if format != nil {
+		result.Format = format
+		result.Steps = format.RestoreSteps()
+	}
+
+	if m.Source == nil {
+		return fmt.Errorf("the manifest of %s records no source database", backupName)
+	}
+
+	executor, err := backup.CreateExecutor(jobConfig, cfg.Storage, nil, clock.Real)
+	if err != nil {
+		return configError(err)
+	}
+	describer, ok := executor.(scheduler.SourceDescriber)
+	if !ok {
+		return configError(fmt.Errorf("jobs of type %s have no source database to check", jobConfig.Type))
+	}
+	target, err := describer.DescribeSource(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return configError(fmt.Errorf("jobs of type %s have no source database to check", jobConfig.Type))
+	}
+	result.Target = target
+
+	result.Warnings = append(result.Warnings, m.Source.RestoreWarnings(*target)...)
+	if len(result.Warnings) > 0 {
+		return errors.New("the backup may not restore cleanly")
+	}
+	return nil
+}
+
+// describeFormat reads like "gzip compressed tar, split"
+func describeFormat(f manifest.Format) string {
+	var layers []string
+	if f.Encryption != "" {
+		layers = append(layers, f.Encryption+" encrypted")
+	}
+	if f.Compression != "" {
+		layers = append(layers, f.Compression+" compressed")
+	}
+	description := strings.Join(append(layers, f.Container), " ")
+	if f.Split {
+		description += ", split"
+	}
+	return description
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+)
+
+// legacyFlag matches long flags written with a single dash, such as -config,
+// which the flag package accepted before the CLI moved to cobra
+var legacyFlag = regexp.MustCompile(`^-[A-Za-z][A-Za-z0-9-]+(=.*)?$`)
+
+// newRootCommand builds the command tree. Without a subcommand backmeup runs
+// the scheduler.
+func newRootCommand() *cobra.Command {
+	var configPath string
+
+	root := &cobra.Command{
+		Use:   "backmeup",
+		Short: "Scheduled backups of databases, object storage and files",
+		Long: "BackMeUp runs scheduled backups of databases, object storage and files, " +
+			"keeps them according to retention policies and reports on them.\n\n" +
+			"Without a subcommand it starts the scheduler with the given configuration.",
+		Version:       buildinfo.Version,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runDaemon(configPath)
+			return nil
+		},
+	}
+	root.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	root.SetVersionTemplate(versionText(versionInfo()))
+
+	root.AddCommand(
+		newInitCommand(),
+		newValidateCommand(),
+		newConfigCommand(),
+		newScheduleCommand(),
+		newRunCommand(),
+		newPruneCommand(),
+		newRestoreCheckCommand(),
+		newVerifyCommand(),
+		newMigrateStorageCommand(),
+		newMigrateJobCommand(),
+		newMaintenanceCommand("pause"),
+		newMaintenanceCommand("resume"),
+		newHistoryCommand(),
+		newJoinCommand(),
+		newSelfUpdateCommand(),
+		newManCommand(),
+	)
+
+	markUsageErrors(root)
+	root.SetArgs(normalizeLegacyFlags(os.Args[1:]))
+	return root
+}
+
+// normalizeLegacyFlags rewrites -flag to --flag so that existing scripts and
+// container commands keep working. Single-letter shorthands such as -o are
+// left alone.
+func normalizeLegacyFlags(args []string) []string {
+	normalized := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			copy(normalized[i:], args[i:])
+			break
+		}
+		if legacyFlag.MatchString(arg) {
+			arg = "-" + arg
+		}
+		normalized[i] = arg
+	}
+	return normalized
+}
+
+// newManCommand implements `backmeup man`, which writes a man page for every
+// command
+func newManCommand() *cobra.Command {
+	var dir string
+
+	cmd := &cobra.Command{
+		Use:   "man",
+		Short: "Generate man pages",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+			header := &doc.GenManHeader{Title: "BACKMEUP", Section: "1", Source: "BackMeUp"}
+			if err := doc.GenManTree(cmd.Root(), header, dir); err != nil {
+				return fmt.Errorf("failed to generate man pages: %w", err)
+			}
+			fmt.Printf("Man pages written to %s\n", dir)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "man", "Directory to write the man pages to")
+	return cmd
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/backup"
+	"github.com/thitiph0n/backmeup/internal/clock"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/redact"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// runResult is the --output json document of `backmeup run`
+type runResult struct {
+	outcome
+	Runs []jobRun `json:"runs"`
+}
+
+// jobRun is one job's run, with its duration spelled out
+type jobRun struct {
+	history.Run
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// newRunCommand implements `backmeup run`, which runs jobs once in the
+// foreground, including upload and retention, and exits
+func newRunCommand() *cobra.Command {
+	var configPath, output, at, addr, token string
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "run [job...]",
+		Short: "Run jobs once and exit, or plan a single run with --at",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+			if at != "" {
+				if all || len(args) == 0 {
+					return usageError(fmt.Errorf("name the jobs to run at %s", at))
+				}
+				return scheduleOneOffRuns(addr, token, args, at, output)
+			}
+			if all == (len(args) > 0) {
+				return usageError(fmt.Errorf("name the jobs to run or use --all"))
+			}
+
+			result := runResult{Runs: []jobRun{}}
+			err := runJobs(configPath, args, &result)
+			if output == outputJSON {
+				result.outcome = newOutcome(err)
+				if encErr := jsonOutput().Encode(result); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+
+			for _, run := range result.Runs {
+				line := fmt.Sprintf("%-7s %s (%s, %s)", run.Status, run.Job, run.Duration().Round(time.Second), formatSize(run.Size))
+				if run.Error != "" && len(run.Artifacts) == 0 {
+					line += fmt.Sprintf(": [%s] %s", run.ErrorCode, run.Error)
+				}
+				fmt.Println(line)
+				for _, excerpt := range run.ErrorExcerpt {
+					fmt.Println("    " + excerpt)
+				}
+				for _, artifact := range run.Artifacts {
+					line := fmt.Sprintf("  %-7s %s", artifact.Status, artifact.Name)
+					if artifact.Error != "" {
+						line += fmt.Sprintf(": [%s] %s", artifact.ErrorCode, artifact.Error)
+					}
+					fmt.Println(line)
+				}
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	cmd.Flags().BoolVar(&all, "all", false, "Run every configured job")
+	cmd.Flags().StringVar(&at, "at", "", "Have a running backmeup run the jobs once at this local time, such as 2024-07-01T02:00")
+	cmd.Flags().StringVar(&addr, "addr", "http://localhost:8080", "Address of the backmeup HTTP server, with --at")
+	cmd.Flags().StringVar(&token, "token", os.Getenv("BACKMEUP_TOKEN"), "Bearer token of the backmeup HTTP server (server.token), with --at, defaults to $BACKMEUP_TOKEN")
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+// runJobs runs the named jobs, or all jobs when names is empty, one after the
+// other and records them in the run history
+func runJobs(configPath string, names []string, result *runResult) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return configError(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return configError(fmt.Errorf("invalid configuration: %w", err))
+	}
+
+	jobs, err := selectJobs(cfg, names)
+	if err != nil {
+		return err
+	}
+
+	redactor := redact.New(cfg.Secrets()...)
+	log.SetOutput(redactor.Writer(os.Stderr))
+
+	storageMetrics := storage.NewMetrics()
+	jobScheduler, _, err := newScheduler(cfg, storageMetrics)
+	if err != nil {
+		return configError(err)
+	}
+	jobScheduler.SetRedactor(redactor)
+
+	var failed []error
+	for _, jobConfig := range jobs {
+		var run history.Run
+		executor, err := backup.CreateExecutor(jobConfig, cfg.Storage, storageMetrics, clock.Real)
+		if err == nil {
+			err = jobScheduler.AddJob(jobConfig, executor)
+		}
+		if err == nil {
+			run, err = jobScheduler.RunNow(jobConfig.Name)
+		}
+
+		if err != nil {
+			failed = append(failed, err)
+			if run.Job == "" {
+				now := time.Now()
+				run = history.Run{Job: jobConfig.Name, Type: jobConfig.Type, Status: history.StatusFailed, StartedAt: now, FinishedAt: now,
+					ErrorCode: string(failure.CodeOf(err))}
+			}
+			run.Error = redactor.String(err.Error())
+			run.Artifacts = slices.Clone(run.Artifacts)
+			for i := range run.Artifacts {
+				run.Artifacts[i].Error = redactor.String(run.Artifacts[i].Error)
+			}
+		}
+		result.Runs = append(result.Runs, jobRun{Run: run, DurationSeconds: run.Duration().Seconds()})
+	}
+
+	return jobsOutcome(failed, len(jobs))
+}
+
+// selectJobs returns the configured jobs with the given names, in the order
+// given, or all jobs when no names are given
+func selectJobs(cfg *config.Config, names []string) ([]config.JobConfig, error) {
+	if len(names) == 0 {
+		return cfg.Jobs, nil
+	}
+
+	jobs := make([]config.JobConfig, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(cfg.Jobs, func(job config.JobConfig) bool { return job.Name == name })
+		if i < 0 {
+			return nil, usageError(fmt.Errorf("job %s is not configured", name))
+		}
+		jobs = append(jobs, cfg.Jobs[i])
+	}
+	return jobs, nil
+}
+
+// formatSize formats a byte count with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
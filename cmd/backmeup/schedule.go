@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// newScheduleCommand implements `backmeup schedule preview`, which prints the
+// upcoming run times of a cron expression or of the configured jobs
+func newScheduleCommand() *cobra.Command {
+	var expr, configPath, job, tz string
+	var count int
+
+	preview := &cobra.Command{
+		Use:   "preview",
+		Short: "Print the upcoming run times of a cron expression or of the configured jobs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSchedulePreview(expr, configPath, job, count, tz)
+		},
+	}
+	preview.Flags().StringVar(&expr, "expr", "", "Cron expression to preview instead of the configured jobs")
+	preview.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	preview.Flags().StringVar(&job, "job", "", "Only preview this job")
+	preview.Flags().IntVar(&count, "count", 5, "Number of runs to show")
+	preview.Flags().StringVar(&tz, "tz", "", "Time zone schedules are evaluated in, such as Europe/Berlin (default: local)")
+
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Inspect job schedules",
+	}
+	cmd.AddCommand(preview)
+	return cmd
+}
+
+func runSchedulePreview(expr, configPath, job string, count int, tz string) error {
+	if count <= 0 {
+		return fmt.Errorf("count must be positive")
+	}
+	loc := time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return fmt.Errorf("unknown time zone %s: %w", tz, err)
+		}
+	}
+	now := time.Now().In(loc)
+
+	if expr != "" {
+		runs, err := nextRuns(expr, now, count)
+		if err != nil {
+			return err
+		}
+		for _, at := range runs {
+			fmt.Println(formatRun(at, now))
+		}
+		return nil
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	excluded, err := excludedDates(cfg.Scheduler.Exclusions)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, jobConfig := range cfg.Jobs {
+		if job != "" && jobConfig.Name != job {
+			continue
+		}
+		found = true
+
+		fmt.Printf("%s (%s)\n", jobConfig.Name, jobConfig.DescribeSchedule())
+		runs, err := jobRuns(jobConfig, now, count)
+		if err != nil {
+			fmt.Printf("  %v\n\n", err)
+			continue
+		}
+		for _, run := range runs {
+			at := run.at
+			line := "  " + formatRun(at, now)
+			if run.mode != "" {
+				line += "  " + run.mode + " dump"
+			}
+			if excluded[at.Format("2006-01-02")] {
+				line += "  excluded date, " + excludedAction(jobConfig.OnExcludedDate)
+			}
+			fmt.Println(line)
+		}
+		fmt.Println()
+	}
+	if job != "" && !found {
+		return fmt.Errorf("job %s not found in %s", job, configPath)
+	}
+	if tz == "" {
+		fmt.Printf("Times are in the local time zone (%s), which the scheduler uses\n", now.Format("MST"))
+	}
+	return nil
+}
+
+// nextRuns returns the next count start times of a cron schedule after from,
+// in from's time zone
+func nextRuns(schedule string, from time.Time, count int) ([]time.Time, error) {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", schedule, err)
+	}
+
+	runs := make([]time.Time, 0, count)
+	for next := sched.Next(from); !next.IsZero() && len(runs) < count; next = sched.Next(next) {
+		runs = append(runs, next)
+	}
+	return runs, nil
+}
+
+// scheduledRun is an upcoming run of a job, with its dump mode when the job
+// has several schedules
+type scheduledRun struct {
+	at   time.Time
+	mode string
+}
+
+// jobRuns returns the next count runs of a job by any of its schedules. A
+// minute that several schedules share is one run, the one the scheduler
+// starts.
+func jobRuns(jobConfig config.JobConfig, from time.Time, count int) ([]scheduledRun, error) {
+	var runs []scheduledRun
+	for _, entry := range jobConfig.ScheduleEntries() {
+		times, err := nextRuns(entry.Cron, from, count)
+		if err != nil {
+			return nil, err
+		}
+		for _, at := range times {
+			if due, _ := jobConfig.ScheduleAt(at); len(jobConfig.Schedules) > 1 && due != entry {
+				continue
+			}
+			run := scheduledRun{at: at}
+			if len(jobConfig.Schedules) > 1 {
+				run.mode = jobConfig.ForSchedule(entry).DumpMode()
+			}
+			runs = append(runs, run)
+		}
+	}
+	slices.SortFunc(runs, func(a, b scheduledRun) int {
+		return a.at.Compare(b.at)
+	})
+	return runs[:min(count, len(runs))], nil
+}
+
+func formatRun(at, now time.Time) string {
+	in := at.Sub(now).Round(time.Minute)
+	days, hours, minutes := int(in/(24*time.Hour)), int(in%(24*time.Hour)/time.Hour), int(in%time.Hour/time.Minute)
+
+	var until string
+	switch {
+	case days > 0:
+		until = fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		until = fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		until = fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%s  (in %s)", at.Format("Mon 2006-01-02 15:04 MST"), until)
+}
+
+// excludedDates returns the static exclusion dates as YYYY-MM-DD. Dates from
+// an iCal feed are only known to the running scheduler.
+func excludedDates(cfg config.ExclusionConfig) (map[string]bool, error) {
+	dates := make(map[string]bool)
+	for _, entry := range cfg.Dates {
+		from, to, err := config.ParseDateRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			dates[d.Format("2006-01-02")] = true
+		}
+	}
+	return dates, nil
+}
+
+func excludedAction(onExcludedDate string) string {
+	switch onExcludedDate {
+	case "run":
+		return "runs anyway"
+	case "shift":
+		return "shifted to the next allowed date"
+	default:
+		return "skipped"
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+	"github.com/thitiph0n/backmeup/internal/selfupdate"
+)
+
+// newSelfUpdateCommand implements `backmeup self-update`, which replaces the
+// binary with a verified GitHub release
+func newSelfUpdateCommand() *cobra.Command {
+	var check, force bool
+	var tag, repository string
+
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace this binary with the latest release",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSelfUpdate(cmd.Context(), repository, tag, check, force)
+		},
+	}
+	cmd.Flags().BoolVar(&check, "check", false, "Only report whether an update is available")
+	cmd.Flags().StringVar(&tag, "version", "", "Install this release tag instead of the latest, e.g. v1.4.0")
+	cmd.Flags().BoolVar(&force, "force", false, "Install even if the release is not newer, or this is a development build")
+	cmd.Flags().StringVar(&repository, "repository", selfupdate.DefaultRepository, "GitHub repository to fetch releases from")
+	return cmd
+}
+
+func runSelfUpdate(ctx context.Context, repository, tag string, check, force bool) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	updater, err := selfupdate.New(repository)
+	if err != nil {
+		return err
+	}
+	release, err := updater.Release(ctx, tag)
+	if err != nil {
+		return err
+	}
+
+	current := buildinfo.Version
+	newer, err := selfupdate.Newer(release.Tag, current)
+	switch {
+	case err != nil && !force:
+		return fmt.Errorf("cannot compare %s with the running version %s, use --force to install it anyway: %w",
+			release.Tag, current, err)
+	case err == nil && !newer && !force:
+		fmt.Printf("backmeup %s is up to date (latest release %s)\n", current, release.Tag)
+		return nil
+	}
+
+	if check {
+		fmt.Printf("Update available: %s -> %s\n", current, release.Tag)
+		return nil
+	}
+
+	if len(updater.PublicKey) == 0 {
+		fmt.Fprintln(os.Stderr, "Warning: this binary was built without a release public key, only the checksum is verified")
+	}
+	binary, err := updater.Download(ctx, release)
+	if err != nil {
+		return err
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if err := selfupdate.Replace(path, binary); err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated %s from %s to %s, restart backmeup to run the new version\n", path, current, release.Tag)
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build nos3
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// newS3Storage fails, s3 storage was left out of this binary
+func newS3Storage(cfg config.S3Config, metrics *storage.Metrics) (scheduler.RemoteStorage, error) {
+	return nil, fmt.Errorf("storage type s3 is %w, the binary was built with the nos3 tag", buildinfo.ErrNotCompiledIn)
+}
+
+// newB2Storage fails, b2 storage uses the s3 client that was left out of this
+// binary
+func newB2Storage(cfg config.B2Config, metrics *storage.Metrics) (scheduler.RemoteStorage, error) {
+	return nil, fmt.Errorf("storage type b2 is %w, the binary was built with the nos3 tag", buildinfo.ErrNotCompiledIn)
+}
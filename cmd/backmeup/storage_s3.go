@@ -0,0 +1,24 @@
+//go:build !nos3
+
+package main
+
+import (
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+	"github.com/thitiph0n/backmeup/internal/storage/s3"
+)
+
+func init() {
+	storageTypes = append(storageTypes, "s3", "b2")
+}
+
+// newS3Storage connects to the bucket backups are uploaded to
+func newS3Storage(cfg config.S3Config, metrics *storage.Metrics) (scheduler.RemoteStorage, error) {
+	return s3.New(cfg, metrics)
+}
+
+// newB2Storage connects to the Backblaze B2 bucket backups are uploaded to
+func newB2Storage(cfg config.B2Config, metrics *storage.Metrics) (scheduler.RemoteStorage, error) {
+	return s3.NewB2(cfg, metrics)
+}
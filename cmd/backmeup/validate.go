@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// validateResult is the --output json document of `backmeup validate`
+type validateResult struct {
+	outcome
+	Config   string   `json:"config"`
+	Jobs     int      `json:"jobs"`
+	Warnings []string `json:"warnings"`
+}
+
+// newValidateCommand implements `backmeup validate`, which checks a
+// configuration file without starting the scheduler and prints lint warnings
+func newValidateCommand() *cobra.Command {
+	var configPath, output string
+	var strict bool
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check a configuration file without starting the scheduler",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+
+			result := validateResult{Config: configPath, Warnings: []string{}}
+			err := runValidate(configPath, strict, &result)
+			if output == outputJSON {
+				result.outcome = newOutcome(err)
+				if encErr := jsonOutput().Encode(result); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+
+			for _, warning := range result.Warnings {
+				fmt.Printf("Warning: %s\n", warning)
+			}
+			if err == nil {
+				fmt.Printf("Configuration %s is valid (%d jobs, %d warnings)\n", configPath, result.Jobs, len(result.Warnings))
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail when there are warnings")
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func runValidate(configPath string, strict bool, result *validateResult) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return configError(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return configError(fmt.Errorf("invalid configuration: %w", err))
+	}
+	if err := checkCompiledIn(cfg); err != nil {
+		return configError(err)
+	}
+
+	result.Jobs = len(cfg.Jobs)
+	result.Warnings = append(result.Warnings, cfg.Lint()...)
+	result.Warnings = append(result.Warnings, renameWarnings(cfg)...)
+	if strict && len(result.Warnings) > 0 {
+		return configError(fmt.Errorf("%d warnings", len(result.Warnings)))
+	}
+	return nil
+}
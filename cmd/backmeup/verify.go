@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+)
+
+// Outcomes of the verification of one backup
+const (
+	verifyOK      = "ok"
+	verifyCorrupt = "corrupt"
+	verifySkipped = "skipped"
+)
+
+// verifyResult is the --output json document of `backmeup verify`
+type verifyResult struct {
+	outcome
+	Backups []verifiedBackup `json:"backups"`
+}
+
+type verifiedBackup struct {
+	Backup string `json:"backup"`
+	Status string `json:"status"` // ok, corrupt or skipped
+	Reason string `json:"reason,omitempty"`
+}
+
+// newVerifyCommand implements `backmeup verify`, which checks backups against
+// the checksums recorded in their manifests
+func newVerifyCommand() *cobra.Command {
+	var configPath, dir, output string
+
+	cmd := &cobra.Command{
+		Use:   "verify <job> [backup...]",
+		Short: "Verify backups against their recorded SHA-256 checksums",
+		Long: "Recomputes the SHA-256 checksums of a job's backups and compares them with those recorded in " +
+			"their manifests when they were written, so that corruption is found before a restore. Every " +
+			"backup with a manifest is verified unless backups are named. Backups are read from the job's " +
+			"local directory, or from --dir for copies downloaded from remote storage; backups without a " +
+			"copy there are skipped.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+
+			result := verifyResult{Backups: []verifiedBackup{}}
+			err := verifyBackups(configPath, dir, args[0], args[1:], &result)
+			if output == outputJSON {
+				result.outcome = newOutcome(err)
+				if encErr := jsonOutput().Encode(result); encErr != nil {
+					return encErr
+				}
+				return err
+			}
+
+			for _, b := range result.Backups {
+				if b.Reason != "" {
+					fmt.Printf("%s: %s (%s)\n", b.Backup, b.Status, b.Reason)
+				} else {
+					fmt.Printf("%s: %s\n", b.Backup, b.Status)
+				}
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "config.yml", "Path to configuration file")
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory holding the backups, defaults to the job's local directory")
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func verifyBackups(configPath, dir, jobName string, backupNames []string, result *verifyResult) error {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return configError(err)
+	}
+	if _, err := selectJobs(cfg, []string{jobName}); err != nil {
+		return err
+	}
+	if dir == "" {
+		dir = filepath.Join(cfg.Storage.Local.Directory, jobName)
+	}
+
+	store := manifest.NewStore(manifest.DefaultDir(cfg.Storage.Local.Directory))
+	var manifests []manifest.Manifest
+	if len(backupNames) == 0 {
+		if manifests, err = store.List(jobName); err != nil {
+			return err
+		}
+		if len(manifests) == 0 {
+			return fmt.Errorf("job %s has no recorded backups", jobName)
+		}
+	}
+	for _, name := range backupNames {
+		m, err := store.Read(jobName, name)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, m)
+	}
+
+	corrupt := 0
+	for _, m := range manifests {
+		verified := verifiedBackup{Backup: m.Backup, Status: verifyOK}
+		path := filepath.Join(dir, m.Backup)
+		switch _, statErr := os.Stat(path); {
+		case len(m.Checksums) == 0:
+			verified.Status, verified.Reason = verifySkipped, "no checksums were recorded"
+		case os.IsNotExist(statErr):
+			verified.Status, verified.Reason = verifySkipped, "no copy in "+dir
+		default:
+			if err := manifest.VerifyChecksums(path, m.Checksums); err != nil {
+				verified.Status, verified.Reason = verifyCorrupt, err.Error()
+				corrupt++
+			}
+		}
+		result.Backups = append(result.Backups, verified)
+	}
+
+	if corrupt > 0 {
+		return fmt.Errorf("%d of %d backups do not match their checksums", corrupt, len(manifests))
+	}
+	if !hasVerified(result.Backups) {
+		return errors.New("no backup could be verified")
+	}
+	return nil
+}
+
+func hasVerified(backups []verifiedBackup) bool {
+	for _, b := range backups {
+		if b.Status == verifyOK {
+			return true
+		}
+	}
+	return false
+}
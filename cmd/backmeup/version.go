@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/backup"
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// storageTypes are the storage backends this binary supports, backends left
+// out with a build tag are not listed
+var storageTypes = []string{"local", "webdav", "rclone"}
+
+// checkCompiledIn reports jobs and storage the configuration uses but this
+// binary was built without
+func checkCompiledIn(cfg *config.Config) error {
+	if !slices.Contains(storageTypes, cfg.Storage.Type) {
+		return fmt.Errorf("storage type %s is %w", cfg.Storage.Type, buildinfo.ErrNotCompiledIn)
+	}
+	for _, job := range cfg.Jobs {
+		if err := backup.CheckType(job.Type); err != nil {
+			return fmt.Errorf("job %s: %w", job.Name, err)
+		}
+	}
+	return nil
+}
+
+// versionInfo returns the build information with the features of this binary
+func versionInfo() buildinfo.Info {
+	info := buildinfo.Get()
+	info.JobTypes = backup.Types()
+	info.Storage = storageTypes
+	return info
+}
+
+// versionText is what --version prints
+func versionText(info buildinfo.Info) string {
+	commit, date := info.Commit, info.BuildDate
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "backmeup %s\n", info.Version)
+	fmt.Fprintf(&b, "commit:     %s\n", commit)
+	fmt.Fprintf(&b, "built:      %s\n", date)
+	fmt.Fprintf(&b, "go:         %s %s\n", info.GoVersion, info.Platform)
+	fmt.Fprintf(&b, "job types:  %s\n", strings.Join(info.JobTypes, ", "))
+	fmt.Fprintf(&b, "storage:    %s\n", strings.Join(info.Storage, ", "))
+	if len(info.BuildTags) > 0 {
+		fmt.Fprintf(&b, "build tags: %s\n", strings.Join(info.BuildTags, ", "))
+	}
+	return b.String()
+}
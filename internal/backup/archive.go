@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveWriter streams named entries into a gzip-compressed tar archive, or
+// a plain one that out compresses
+type archiveWriter struct {
+	out io.WriteCloser
+	gz  *gzip.Writer // Nil for a plain tar archive
+	tw  *tar.Writer
+}
+
+func newArchiveWriter(out io.WriteCloser) *archiveWriter {
+	gz := gzip.NewWriter(out)
+	return &archiveWriter{
+		out: out,
+		gz:  gz,
+		tw:  tar.NewWriter(gz),
+	}
+}
+
+// newTarWriter streams entries into a tar archive without compressing it
+func newTarWriter(out io.WriteCloser) *archiveWriter {
+	return &archiveWriter{out: out, tw: tar.NewWriter(out)}
+}
+
+// AddFile writes a single regular file entry to the archive
+func (a *archiveWriter) AddFile(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive header for %s: %w", name, err)
+	}
+	if _, err := a.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddPath writes a file, directory or symlink from disk to the archive under
+// the given entry name
+func (a *archiveWriter) AddPath(name, path string, info fs.FileInfo) error {
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %w", path, err)
+		}
+		link = target
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to create archive header for %s: %w", path, err)
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write archive header for %s: %w", name, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(a.tw, f); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+	return nil
+}
+
+// AddTree walks root and archives everything below it. Entry names are
+// rewritten so that root is stored as nameRoot, which allows archiving a
+// snapshot mount under the original path. Entries whose base name or full
+// path matches one of the exclude patterns are skipped.
+func (a *archiveWriter) AddTree(root, nameRoot string, exclude []string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimPrefix(filepath.Join(nameRoot, rel), "/"))
+
+		if excluded(exclude, d.Name(), filepath.Join(nameRoot, rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return a.AddPath(name, path, info)
+	})
+}
+
+func excluded(patterns []string, base, path string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Close flushes the tar and gzip streams and closes the underlying writer
+func (a *archiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		a.out.Close()
+		return fmt.Errorf("failed to finalize archive: %w", err)
+	}
+	if a.gz == nil {
+		return a.out.Close()
+	}
+	if err := a.gz.Close(); err != nil {
+		a.out.Close()
+		return fmt.Errorf("failed to finalize compression: %w", err)
+	}
+	return a.out.Close()
+}
+
+// runCommand executes an external tool and returns its standard output
+func runCommand(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := command(ctx, name, args...)
+	if env != nil {
+		cmd.Env = env
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w, stderr: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -4,10 +4,19 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"time"
 
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+	"github.com/thitiph0n/backmeup/internal/clock"
 	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/runid"
 	"github.com/thitiph0n/backmeup/internal/storage"
 	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+	"github.com/thitiph0n/backmeup/internal/storage/split"
 )
 
 type Executor interface {
@@ -17,23 +26,183 @@ type Executor interface {
 type BaseExecutor struct {
 	Config  config.JobConfig
 	Storage storage.Storage
+	Clock   clock.Clock // Names and timestamps backups, the system clock when nil
 }
 
-func (b *BaseExecutor) LogBackupInfo(message string) {
+// LogBackupInfo logs a message of the job, tagged with the run ID of ctx
+func (b *BaseExecutor) LogBackupInfo(ctx context.Context, message string) {
+	if id := runid.From(ctx); id != "" {
+		log.Printf("[Job: %s] [Run: %s] %s", b.Config.Name, id, message)
+		return
+	}
 	log.Printf("[Job: %s] %s", b.Config.Name, message)
 }
 
-func CreateExecutor(jobConfig config.JobConfig, storageConfig config.StorageConfig) (Executor, error) {
-	store := localfs.New(storageConfig.Local)
+// dumpPrefix is the prefix of the backups of a database job in a dump mode,
+// such as pg_backup for full dumps and pg_schema for schema dumps, so that
+// a schema snapshot is never mistaken for a full backup
+func dumpPrefix(engine, mode string) string {
+	if mode == config.ModeFull {
+		return engine + "_backup"
+	}
+	return engine + "_" + mode
+}
+
+// Now returns the current time of the executor's clock
+func (b *BaseExecutor) Now() time.Time {
+	if b.Clock == nil {
+		return time.Now()
+	}
+	return b.Clock.Now()
+}
+
+// FileName returns the name of a backup taken now
+func (b *BaseExecutor) FileName(prefix, extension string) string {
+	return localfs.FileName(prefix, extension, b.Now())
+}
+
+func (b *BaseExecutor) setClock(c clock.Clock) {
+	b.Clock = c
+}
+
+// clockSetter is implemented by every executor that embeds BaseExecutor
+type clockSetter interface {
+	setClock(c clock.Clock)
+}
+
+// catalogSetter is implemented by executors that continue chains of
+// incremental backups recorded in the manifests
+type catalogSetter interface {
+	setCatalog(catalog *manifest.Store)
+}
+
+// CreateExecutor builds the executor for a job. Backups it writes are recorded
+// in metrics as uploads to the "local" backend when metrics is not nil, and
+// are named after the time of clk. Errors are failure.ConfigErrors.
+func CreateExecutor(jobConfig config.JobConfig, storageConfig config.StorageConfig, metrics *storage.Metrics, clk clock.Clock) (Executor, error) {
+	local := localfs.New(storageConfig.Local)
+	local.SetClock(clk)
+	perms, hasPerms, err := backupPermissions(jobConfig)
+	if err != nil {
+		return nil, &failure.ConfigError{Err: err}
+	}
+	if hasPerms {
+		local.SetPermissions(perms)
+	}
+
+	var store storage.Storage = local
+	if storageConfig.SplitSize != "" {
+		partSize, err := config.ParseSize(storageConfig.SplitSize)
+		if err != nil {
+			return nil, &failure.ConfigError{Err: fmt.Errorf("invalid split size: %w", err)}
+		}
+		store = split.New(store, partSize)
+	}
+	if metrics != nil {
+		store = storage.Instrument(store, "local", metrics)
+	}
+	// Encrypted outermost, so that split parts and metrics see the encrypted stream
+	var encrypted *encryptingStorage
+	if encryption := storageConfig.JobEncryption(jobConfig); encryption != nil {
+		encrypted = newEncryptingStorage(store, *encryption)
+		store = encrypted
+	}
+
+	catalog := manifest.NewStore(manifest.DefaultDir(storageConfig.Local.Directory))
+	build := func(jobConfig config.JobConfig) (Executor, error) {
+		executor, err := newExecutor(jobConfig, store)
+		if err != nil {
+			return nil, &failure.ConfigError{Err: err}
+		}
+		if setter, ok := executor.(clockSetter); ok {
+			setter.setClock(clk)
+		}
+		if setter, ok := executor.(catalogSetter); ok {
+			setter.setCatalog(catalog)
+		}
+		return executor, nil
+	}
+	executor, err := build(jobConfig)
+	if err != nil {
+		return nil, err
+	}
+	// Schedules that dump with another mode run an executor of their own
+	for _, entry := range jobConfig.ScheduleEntries() {
+		scheduled := jobConfig.ForSchedule(entry)
+		mode := scheduled.DumpMode()
+		if mode == jobConfig.DumpMode() {
+			continue
+		}
+		modes, ok := executor.(*modeExecutor)
+		if !ok {
+			modes = &modeExecutor{Executor: executor, modes: make(map[string]Executor)}
+			executor = modes
+		}
+		if _, exists := modes.modes[mode]; exists {
+			continue
+		}
+		if modes.modes[mode], err = build(scheduled); err != nil {
+			return nil, err
+		}
+	}
+	if jobConfig.RunAs != "" || jobConfig.NoNewPrivileges {
+		executor = &restrictedExecutor{
+			Executor:        executor,
+			runAs:           jobConfig.RunAs,
+			noNewPrivileges: jobConfig.NoNewPrivileges,
+		}
+	}
+	if encrypted != nil {
+		executor = &encryptionExecutor{Executor: executor, jobName: jobConfig.Name, store: encrypted}
+	}
+	if hasPerms {
+		executor = &permissionsExecutor{Executor: executor, jobName: jobConfig.Name, local: local}
+	}
+	return executor, nil
+}
+
+// executorFactory creates the executor of one job type
+type executorFactory func(jobConfig config.JobConfig, store storage.Storage) (Executor, error)
+
+// executors maps each job type to its executor. Executors that pull in large
+// dependencies register themselves from files that a build tag can leave out.
+var executors = map[string]executorFactory{
+	"postgres": NewPostgresExecutor,
+	"mysql":    NewMySQLExecutor,
+	"kafka":    NewKafkaExecutor,
+	"consul":   NewConsulExecutor,
+	"keycloak": NewKeycloakExecutor,
+	"rest":     NewRESTExecutor,
+	"grafana":  NewGrafanaExecutor,
+	"snapshot": NewSnapshotExecutor,
+	"files":    NewFilesExecutor,
+	"sqlite":   NewSQLiteExecutor,
+}
+
+// excludedTypes maps the job types left out of this binary to the build tag that
+// excluded them
+var excludedTypes = map[string]string{}
+
+// Types returns the job types this binary can run, sorted
+func Types() []string {
+	return slices.Sorted(maps.Keys(executors))
+}
+
+// CheckType returns an error wrapping buildinfo.ErrNotCompiledIn when jobs of
+// the given type cannot run because a build tag left their executor out
+func CheckType(jobType string) error {
+	if _, ok := executors[jobType]; ok {
+		return nil
+	}
+	if tag, excluded := excludedTypes[jobType]; excluded {
+		return fmt.Errorf("job type %s is %w, the binary was built with the %s tag", jobType, buildinfo.ErrNotCompiledIn, tag)
+	}
+	return fmt.Errorf("unsupported job type: %s", jobType)
+}
 
-	switch jobConfig.Type {
-	case "postgres":
-		return NewPostgresExecutor(jobConfig, store)
-	case "mysql":
-		return NewMySQLExecutor(jobConfig, store)
-	case "minio":
-		return NewMinioExecutor(jobConfig, store)
-	default:
-		return nil, fmt.Errorf("unsupported job type: %s", jobConfig.Type)
+func newExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	if err := CheckType(jobConfig.Type); err != nil {
+		return nil, err
 	}
+	return executors[jobConfig.Type](jobConfig, store)
 }
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/thitiph0n/backmeup/internal/compress"
+)
+
+// compressionExtension returns the extension the job's compression adds to
+// the names of its dump files, empty without compression
+func (b *BaseExecutor) compressionExtension() string {
+	if b.Config.Compression == nil {
+		return ""
+	}
+	return b.Config.Compression.Extension()
+}
+
+// newDumpWriter opens a backup file for the output of a dump tool, compressed
+// with the job's compression. fileName must already end in
+// compressionExtension.
+func (b *BaseExecutor) newDumpWriter(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	writer, err := b.newWriter(ctx, fileName)
+	if err != nil || b.Config.Compression == nil {
+		return writer, err
+	}
+	compressed, err := compress.NewWriter(ctx, writer, *b.Config.Compression)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	return &layeredWriter{WriteCloser: compressed, file: writer}, nil
+}
+
+// writeDump fills a backup file with write and closes it, also when write
+// fails. Compression and encryption finish the file, and may fail, on close,
+// so the file is only complete once it closed without error. When both fail,
+// the error of write is returned.
+func writeDump(writer io.WriteCloser, write func() error) error {
+	err := write()
+	closeErr := writer.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write backup file: %w", closeErr)
+	}
+	return nil
+}
+
+// contextWriter is a storage that runs tools to write backup files, such as
+// gpg, which must stop with the run
+type contextWriter interface {
+	NewWriterContext(ctx context.Context, jobName, fileName string) (io.WriteCloser, error)
+}
+
+// newWriter opens a backup file of the job, tying the tools the storage runs
+// to write it to ctx
+func (b *BaseExecutor) newWriter(ctx context.Context, fileName string) (io.WriteCloser, error) {
+	if store, ok := b.Storage.(contextWriter); ok {
+		return store.NewWriterContext(ctx, b.Config.Name, fileName)
+	}
+	return b.Storage.NewWriter(b.Config.Name, fileName)
+}
+
+// layeredWriter compresses or encrypts into a backup file and closes both
+type layeredWriter struct {
+	io.WriteCloser
+	file io.WriteCloser
+}
+
+func (c *layeredWriter) Close() error {
+	err := c.WriteCloser.Close()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// fakeTools puts shell scripts named after tools first on PATH
+func fakeTools(t *testing.T, scripts map[string]string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tools are shell scripts")
+	}
+	dir := t.TempDir()
+	for name, script := range scripts {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755))
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunPgDump_CompressionFailsOnClose(t *testing.T) {
+	// The dump fits in the pipe buffer, so only closing the compressor
+	// tells that it failed
+	fakeTools(t, map[string]string{
+		"pg_dump": "echo 'CREATE TABLE orders (id int);'\n",
+		"lz4":     "cat > /dev/null\nexit 1\n",
+	})
+	dir := t.TempDir()
+	p := &PostgresExecutor{BaseExecutor: BaseExecutor{
+		Config: config.JobConfig{Name: "orders", PostgresConfig: &config.PostgresConfig{},
+			Compression: &config.CompressionConfig{Algorithm: config.CompressionLz4}},
+		Storage: localfs.New(config.LocalConfig{Directory: dir}),
+	}}
+
+	_, err := p.runPgDump(context.Background(), "pg_backup.sql.lz4", nil, nil)
+	assert.ErrorContains(t, err, "failed to write backup file: lz4 failed")
+
+	fakeTools(t, map[string]string{"lz4": "cat\n"})
+	size, err := p.runPgDump(context.Background(), "pg_backup.sql.lz4", nil, nil)
+	require.NoError(t, err)
+	assert.Positive(t, size)
+}
+
+// closeRecorder records whether it was closed
+type closeRecorder struct {
+	io.Writer
+	closed bool
+	err    error
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return w.err
+}
+
+func TestWriteDump(t *testing.T) {
+	writer := &closeRecorder{Writer: io.Discard}
+	assert.NoError(t, writeDump(writer, func() error { return nil }))
+	assert.True(t, writer.closed)
+
+	writer = &closeRecorder{Writer: io.Discard, err: errors.New("gpg failed")}
+	assert.EqualError(t, writeDump(writer, func() error { return nil }), "failed to write backup file: gpg failed")
+
+	writer = &closeRecorder{Writer: io.Discard, err: errors.New("gpg failed")}
+	assert.EqualError(t, writeDump(writer, func() error { return errors.New("pg_dump failed") }), "pg_dump failed",
+		"the failure of the dump is reported first")
+	assert.True(t, writer.closed, "closed even when the dump fails")
+}
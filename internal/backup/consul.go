@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// ConsulExecutor takes a Raft snapshot with `consul snapshot save` and
+// optionally exports the KV store as JSON.
+type ConsulExecutor struct {
+	BaseExecutor
+}
+
+func NewConsulExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	if jobConfig.ConsulConfig == nil {
+		return nil, fmt.Errorf("missing Consul configuration for job: %s", jobConfig.Name)
+	}
+
+	return &ConsulExecutor{
+		BaseExecutor: BaseExecutor{
+			Config:  jobConfig,
+			Storage: store,
+		},
+	}, nil
+}
+
+// environment passes connection settings through the CONSUL_* variables so
+// the ACL token never shows up in the process list
+func (c *ConsulExecutor) environment() []string {
+	cfg := c.Config.ConsulConfig
+
+	env := append(os.Environ(), fmt.Sprintf("CONSUL_HTTP_ADDR=%s", cfg.Address))
+
+	settings := map[string]string{
+		"CONSUL_HTTP_TOKEN":      cfg.Token,
+		"CONSUL_CACERT":          cfg.CACert,
+		"CONSUL_CLIENT_CERT":     cfg.ClientCert,
+		"CONSUL_CLIENT_KEY":      cfg.ClientKey,
+		"CONSUL_TLS_SERVER_NAME": cfg.TLSServerName,
+	}
+	for key, value := range settings {
+		if value != "" {
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+
+	return env
+}
+
+func (c *ConsulExecutor) Execute(ctx context.Context) error {
+	c.LogBackupInfo(ctx, "Starting Consul backup")
+
+	cfg := c.Config.ConsulConfig
+	env := c.environment()
+
+	backupDirName := c.FileName("consul_backup", "")
+
+	backupDir, err := c.Storage.NewDir(c.Config.Name, backupDirName)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup directory: %w", err)
+	}
+	if err := grantToolAccess(ctx, backupDir); err != nil {
+		return err
+	}
+
+	snapshotArgs := []string{"snapshot", "save"}
+	if cfg.Datacenter != "" {
+		snapshotArgs = append(snapshotArgs, "-datacenter="+cfg.Datacenter)
+	}
+	snapshotArgs = append(snapshotArgs, filepath.Join(backupDir, "consul.snap"))
+
+	c.LogBackupInfo(ctx, fmt.Sprintf("Saving Consul snapshot to %s", backupDir))
+	if _, err := runCommand(ctx, env, "consul", snapshotArgs...); err != nil {
+		return fmt.Errorf("consul snapshot failed: %w", err)
+	}
+
+	if cfg.ExportKV {
+		kvArgs := []string{"kv", "export"}
+		if cfg.Datacenter != "" {
+			kvArgs = append(kvArgs, "-datacenter="+cfg.Datacenter)
+		}
+		if cfg.KVPrefix != "" {
+			kvArgs = append(kvArgs, cfg.KVPrefix)
+		}
+
+		c.LogBackupInfo(ctx, "Exporting Consul KV store")
+		output, err := runCommand(ctx, env, "consul", kvArgs...)
+		if err != nil {
+			return fmt.Errorf("consul kv export failed: %w", err)
+		}
+
+		if err := os.WriteFile(filepath.Join(backupDir, "kv.json"), output, 0644); err != nil {
+			return fmt.Errorf("failed to write KV export: %w", err)
+		}
+	}
+
+	c.LogBackupInfo(ctx, fmt.Sprintf("Consul backup completed successfully to %s", backupDir))
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/encrypt"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// encryptingStorage encrypts the backup files written through NewWriter as
+// they are written. Backup directories that tools write into are created
+// hidden, so that retention and uploads skip them, and are archived and
+// encrypted by encryptionExecutor once the run is over.
+type encryptingStorage struct {
+	storage.Storage
+	cfg config.EncryptionConfig
+
+	mu     sync.Mutex
+	staged map[string]string // Hidden directories by the name of the backup directory
+}
+
+func newEncryptingStorage(inner storage.Storage, cfg config.EncryptionConfig) *encryptingStorage {
+	return &encryptingStorage{Storage: inner, cfg: cfg, staged: make(map[string]string)}
+}
+
+func (e *encryptingStorage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	return e.NewWriterContext(context.Background(), jobName, fileName)
+}
+
+// NewWriterContext opens a backup file whose encryption stops with ctx, so
+// that gpg does not outlive a cancelled or timed out run
+func (e *encryptingStorage) NewWriterContext(ctx context.Context, jobName, fileName string) (io.WriteCloser, error) {
+	if staged, ok := e.stagedPath(fileName); ok {
+		return e.Storage.NewWriter(jobName, staged)
+	}
+	if strings.HasPrefix(fileName, ".") {
+		return e.Storage.NewWriter(jobName, fileName)
+	}
+
+	writer, err := e.Storage.NewWriter(jobName, fileName+e.cfg.Extension())
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encrypt.NewWriter(ctx, writer, e.cfg)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	return &layeredWriter{WriteCloser: encrypted, file: writer}, nil
+}
+
+func (e *encryptingStorage) NewDir(jobName, dirName string) (string, error) {
+	if staged, ok := e.stagedPath(dirName); ok {
+		return e.Storage.NewDir(jobName, staged)
+	}
+	// Hidden directories are the executor's own staging
+	if strings.HasPrefix(dirName, ".") {
+		return e.Storage.NewDir(jobName, dirName)
+	}
+
+	dir, err := e.Storage.NewDir(jobName, "."+dirName)
+	if err != nil {
+		return "", err
+	}
+	e.mu.Lock()
+	e.staged[dirName] = dir
+	e.mu.Unlock()
+	return dir, nil
+}
+
+// stagedPath maps a name inside a backup directory to the same name in its
+// hidden directory
+func (e *encryptingStorage) stagedPath(name string) (string, bool) {
+	top, rest, _ := strings.Cut(filepath.ToSlash(name), "/")
+	e.mu.Lock()
+	_, ok := e.staged[top]
+	e.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	return filepath.Join("."+top, rest), true
+}
+
+// seal archives every staged backup directory into an encrypted tar archive
+// named after it, then removes the directory, so that nothing unencrypted is
+// left behind even when the run failed
+func (e *encryptingStorage) seal(ctx context.Context, jobName string) error {
+	e.mu.Lock()
+	staged := e.staged
+	e.staged = make(map[string]string)
+	e.mu.Unlock()
+
+	var errs []error
+	for name, dir := range staged {
+		if err := e.archive(ctx, jobName, name, dir); err != nil {
+			errs = append(errs, fmt.Errorf("failed to encrypt %s: %w", name, err))
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove unencrypted directory %s: %w", dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e *encryptingStorage) archive(ctx context.Context, jobName, name, dir string) error {
+	writer, err := e.NewWriterContext(ctx, jobName, name+".tar")
+	if err != nil {
+		return err
+	}
+	archive := newTarWriter(writer)
+	if err := archive.AddTree(dir, name, nil); err != nil {
+		archive.Close()
+		return err
+	}
+	return archive.Close()
+}
+
+// encryptionExecutor seals the backup directories of a run once it is over
+type encryptionExecutor struct {
+	Executor
+	jobName string
+	store   *encryptingStorage
+}
+
+func (e *encryptionExecutor) Execute(ctx context.Context) error {
+	return e.ExecuteMode(ctx, "")
+}
+
+func (e *encryptionExecutor) ExecuteMode(ctx context.Context, mode string) error {
+	err := executeMode(ctx, e.Executor, mode)
+	if sealErr := e.store.seal(ctx, e.jobName); sealErr != nil {
+		return errors.Join(err, sealErr)
+	}
+	return err
+}
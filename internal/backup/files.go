@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// FilesExecutor archives directories and files into a tar.gz, optionally
+// reading them from an LVM snapshot or a Windows Volume Shadow Copy for a
+// crash-consistent copy
+type FilesExecutor struct {
+	BaseExecutor
+}
+
+func NewFilesExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	if jobConfig.FilesConfig == nil {
+		return nil, fmt.Errorf("missing files configuration for job: %s", jobConfig.Name)
+	}
+
+	return &FilesExecutor{
+		BaseExecutor: BaseExecutor{
+			Config:  jobConfig,
+			Storage: store,
+		},
+	}, nil
+}
+
+func (f *FilesExecutor) Execute(ctx context.Context) error {
+	f.LogBackupInfo(ctx, "Starting files backup")
+
+	cfg := f.Config.FilesConfig
+
+	// sourceRoot maps an original path to the location it is read from
+	sourceRoot := func(path string) string { return path }
+
+	if cfg.VSS {
+		mapper, cleanup, err := f.shadowCopies(ctx)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		sourceRoot = mapper
+	}
+
+	if cfg.LVMSnapshot != nil {
+		mountDir, cleanup, err := f.mountLVMSnapshot(ctx)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		origin := filepath.Clean(cfg.LVMSnapshot.MountPoint)
+		sourceRoot = func(path string) string {
+			rel, _ := filepath.Rel(origin, filepath.Clean(path))
+			return filepath.Join(mountDir, rel)
+		}
+	}
+
+	filename := f.FileName("files_backup", ".tar.gz")
+
+	writer, err := f.newWriter(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup file: %w", err)
+	}
+
+	archive := newArchiveWriter(writer)
+
+	for _, path := range cfg.Paths {
+		f.LogBackupInfo(ctx, fmt.Sprintf("Archiving %s", path))
+		if err := archive.AddTree(sourceRoot(path), archiveName(path), cfg.Exclude); err != nil {
+			archive.Close()
+			return fmt.Errorf("failed to archive %s: %w", path, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	f.LogBackupInfo(ctx, fmt.Sprintf("Files backup completed successfully: %s", filename))
+
+	return nil
+}
+
+// archiveName returns the entry name for a source path. Windows volume names
+// become a top-level directory (C:\data -> C/data).
+func archiveName(path string) string {
+	path = filepath.Clean(path)
+	volume := filepath.VolumeName(path)
+	if volume == "" {
+		return path
+	}
+	return strings.TrimSuffix(volume, ":") + strings.TrimPrefix(path, volume)
+}
+
+// mountLVMSnapshot creates a snapshot of the configured logical volume and
+// mounts it read-only. The returned cleanup function unmounts and removes the
+// snapshot and must always be called.
+func (f *FilesExecutor) mountLVMSnapshot(ctx context.Context) (string, func(), error) {
+	cfg := f.Config.FilesConfig.LVMSnapshot
+
+	snapshotName := "backmeup-" + strings.ReplaceAll(f.Config.Name, " ", "_")
+	origin := fmt.Sprintf("%s/%s", cfg.VolumeGroup, cfg.LogicalVolume)
+	snapshotLV := fmt.Sprintf("%s/%s", cfg.VolumeGroup, snapshotName)
+
+	f.LogBackupInfo(ctx, fmt.Sprintf("Creating LVM snapshot %s of %s", snapshotLV, origin))
+	if _, err := runCommand(ctx, nil, "lvcreate", "--snapshot", "--name", snapshotName, "--size", cfg.Size, origin); err != nil {
+		return "", nil, fmt.Errorf("failed to create LVM snapshot: %w", err)
+	}
+
+	// Cleanup must run even when the job context has been cancelled
+	removeSnapshot := func() {
+		if _, err := runCommand(context.Background(), nil, "lvremove", "--force", snapshotLV); err != nil {
+			f.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to remove LVM snapshot %s: %v", snapshotLV, err))
+			return
+		}
+		f.LogBackupInfo(ctx, fmt.Sprintf("Removed LVM snapshot %s", snapshotLV))
+	}
+
+	mountDir, err := os.MkdirTemp("", tempPrefixLVM)
+	if err != nil {
+		removeSnapshot()
+		return "", nil, fmt.Errorf("failed to create mount point: %w", err)
+	}
+
+	options := "ro"
+	if cfg.MountOptions != "" {
+		options = "ro," + cfg.MountOptions
+	}
+
+	device := fmt.Sprintf("/dev/%s", snapshotLV)
+	if _, err := runCommand(ctx, nil, "mount", "-o", options, device, mountDir); err != nil {
+		os.Remove(mountDir)
+		removeSnapshot()
+		return "", nil, fmt.Errorf("failed to mount LVM snapshot: %w", err)
+	}
+
+	cleanup := func() {
+		if _, err := runCommand(context.Background(), nil, "umount", mountDir); err != nil {
+			f.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to unmount %s: %v", mountDir, err))
+			return
+		}
+		os.Remove(mountDir)
+		removeSnapshot()
+	}
+
+	return mountDir, cleanup, nil
+}
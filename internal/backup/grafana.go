@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const grafanaSearchPageSize = 1000
+
+// GrafanaExecutor exports dashboards, folders, datasources and alert rules
+// through the Grafana HTTP API
+type GrafanaExecutor struct {
+	BaseExecutor
+}
+
+func NewGrafanaExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	if jobConfig.GrafanaConfig == nil {
+		return nil, fmt.Errorf("missing Grafana configuration for job: %s", jobConfig.Name)
+	}
+
+	return &GrafanaExecutor{
+		BaseExecutor: BaseExecutor{
+			Config:  jobConfig,
+			Storage: store,
+		},
+	}, nil
+}
+
+func (g *GrafanaExecutor) headers() map[string]string {
+	cfg := g.Config.GrafanaConfig
+
+	headers := map[string]string{"Accept": "application/json"}
+	if cfg.APIToken != "" {
+		headers["Authorization"] = "Bearer " + cfg.APIToken
+	} else if cfg.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(cfg.Username + ":" + cfg.Password))
+		headers["Authorization"] = "Basic " + credentials
+	}
+	if cfg.OrgID > 0 {
+		headers["X-Grafana-Org-Id"] = strconv.Itoa(cfg.OrgID)
+	}
+	return headers
+}
+
+func (g *GrafanaExecutor) get(ctx context.Context, path string) ([]byte, error) {
+	base := strings.TrimSuffix(g.Config.GrafanaConfig.URL, "/")
+	return doRequest(ctx, http.MethodGet, base+path, g.headers(), nil)
+}
+
+// dashboardUIDs pages through the search API and returns every dashboard UID
+func (g *GrafanaExecutor) dashboardUIDs(ctx context.Context) ([]string, error) {
+	var uids []string
+
+	for page := 1; ; page++ {
+		data, err := g.get(ctx, fmt.Sprintf("/api/search?type=dash-db&limit=%d&page=%d", grafanaSearchPageSize, page))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search dashboards: %w", err)
+		}
+
+		var results []struct {
+			UID string `json:"uid"`
+		}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, fmt.Errorf("failed to parse dashboard search results: %w", err)
+		}
+		for _, r := range results {
+			uids = append(uids, r.UID)
+		}
+
+		if len(results) < grafanaSearchPageSize {
+			return uids, nil
+		}
+	}
+}
+
+func (g *GrafanaExecutor) Execute(ctx context.Context) error {
+	g.LogBackupInfo(ctx, "Starting Grafana export")
+
+	health, err := g.get(ctx, "/api/health")
+	if err != nil {
+		return fmt.Errorf("failed to reach Grafana: %w", err)
+	}
+	version, _ := jsonField(health, "version")
+
+	filename := g.FileName("grafana_backup", ".tar.gz")
+
+	writer, err := g.newWriter(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup file: %w", err)
+	}
+
+	archive := newArchiveWriter(writer)
+
+	fail := func(err error) error {
+		archive.Close()
+		return err
+	}
+
+	metadata, err := json.MarshalIndent(struct {
+		GrafanaVersion string    `json:"grafana_version"`
+		URL            string    `json:"url"`
+		ExportedAt     time.Time `json:"exported_at"`
+	}{version, g.Config.GrafanaConfig.URL, g.Now()}, "", "  ")
+	if err != nil {
+		return fail(fmt.Errorf("failed to encode metadata: %w", err))
+	}
+	if err := archive.AddFile("metadata.json", metadata); err != nil {
+		return fail(err)
+	}
+
+	collections := []struct {
+		name string
+		path string
+	}{
+		{"folders.json", "/api/folders"},
+		{"datasources.json", "/api/datasources"},
+		{"alerting/alert-rules.json", "/api/v1/provisioning/alert-rules"},
+		{"alerting/contact-points.json", "/api/v1/provisioning/contact-points"},
+		{"alerting/policies.json", "/api/v1/provisioning/policies"},
+	}
+
+	for _, c := range collections {
+		g.LogBackupInfo(ctx, fmt.Sprintf("Exporting %s", c.name))
+		data, err := g.get(ctx, c.path)
+		if err != nil {
+			return fail(fmt.Errorf("failed to export %s: %w", c.name, err))
+		}
+		if err := archive.AddFile(c.name, data); err != nil {
+			return fail(err)
+		}
+	}
+
+	uids, err := g.dashboardUIDs(ctx)
+	if err != nil {
+		return fail(err)
+	}
+
+	g.LogBackupInfo(ctx, fmt.Sprintf("Exporting %d dashboards", len(uids)))
+	for _, uid := range uids {
+		data, err := g.get(ctx, "/api/dashboards/uid/"+url.PathEscape(uid))
+		if err != nil {
+			return fail(fmt.Errorf("failed to export dashboard %s: %w", uid, err))
+		}
+		if err := archive.AddFile("dashboards/"+uid+".json", data); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	g.LogBackupInfo(ctx, fmt.Sprintf("Grafana export completed successfully: %s (Grafana %s, %d dashboards)",
+		filename, version, len(uids)))
+
+	return nil
+}
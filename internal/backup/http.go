@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const httpRequestTimeout = 5 * time.Minute
+
+// openRequest sends an HTTP request and returns the response body, treating
+// any non-2xx status as an error. The caller must close the body.
+func openRequest(ctx context.Context, method, url string, headers map[string]string, body io.Reader) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", method, url, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s returned status %d: %s", method, url, resp.StatusCode,
+			strings.TrimSpace(string(data)))
+	}
+
+	return resp.Body, nil
+}
+
+// doRequest sends an HTTP request and returns the full response body
+func doRequest(ctx context.Context, method, url string, headers map[string]string, body io.Reader) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpRequestTimeout)
+	defer cancel()
+
+	respBody, err := openRequest(ctx, method, url, headers, body)
+	if err != nil {
+		return nil, err
+	}
+	defer respBody.Close()
+
+	data, err := io.ReadAll(respBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// streamRequest sends an HTTP request and copies the response body into w
+func streamRequest(ctx context.Context, method, url string, headers map[string]string, body io.Reader, w io.Writer) (int64, error) {
+	respBody, err := openRequest(ctx, method, url, headers, body)
+	if err != nil {
+		return 0, err
+	}
+	defer respBody.Close()
+
+	n, err := io.Copy(w, respBody)
+	if err != nil {
+		return n, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return n, nil
+}
+
+// jsonField extracts a string value from a JSON document using a dotted path
+// such as "data.slug". Numbers and booleans are returned in their JSON form.
+func jsonField(data []byte, path string) (string, error) {
+	current := json.RawMessage(data)
+
+	for _, key := range strings.Split(path, ".") {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(current, &obj); err != nil {
+			return "", fmt.Errorf("field %q: not a JSON object", path)
+		}
+		value, ok := obj[key]
+		if !ok {
+			return "", fmt.Errorf("field %q not found in response", path)
+		}
+		current = value
+	}
+
+	var s string
+	if err := json.Unmarshal(current, &s); err == nil {
+		return s, nil
+	}
+	return strings.TrimSpace(string(current)), nil
+}
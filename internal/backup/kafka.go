@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// KafkaExecutor exports cluster metadata (topics, configs, ACLs and consumer
+// group offsets) using the Kafka admin command-line tools. Message data is not
+// included.
+type KafkaExecutor struct {
+	BaseExecutor
+}
+
+func NewKafkaExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	if jobConfig.KafkaConfig == nil {
+		return nil, fmt.Errorf("missing Kafka configuration for job: %s", jobConfig.Name)
+	}
+
+	return &KafkaExecutor{
+		BaseExecutor: BaseExecutor{
+			Config:  jobConfig,
+			Storage: store,
+		},
+	}, nil
+}
+
+func (k *KafkaExecutor) tool(name string) string {
+	if dir := k.Config.KafkaConfig.ToolsPath; dir != "" {
+		return filepath.Join(dir, name)
+	}
+	return name
+}
+
+func (k *KafkaExecutor) run(ctx context.Context, tool string, args ...string) ([]byte, error) {
+	cfg := k.Config.KafkaConfig
+
+	cmdArgs := []string{"--bootstrap-server", cfg.BootstrapServers}
+	if cfg.CommandConfig != "" {
+		cmdArgs = append(cmdArgs, "--command-config", cfg.CommandConfig)
+	}
+	cmdArgs = append(cmdArgs, args...)
+
+	return runCommand(ctx, nil, k.tool(tool), cmdArgs...)
+}
+
+func (k *KafkaExecutor) Execute(ctx context.Context) error {
+	k.LogBackupInfo(ctx, "Starting Kafka metadata backup")
+
+	cfg := k.Config.KafkaConfig
+
+	type export struct {
+		name string
+		tool string
+		args []string
+	}
+
+	exports := []export{
+		{"brokers/configs.txt", "kafka-configs.sh", []string{"--describe", "--entity-type", "brokers"}},
+		{"consumer-groups/offsets.txt", "kafka-consumer-groups.sh", []string{"--describe", "--all-groups", "--offsets"}},
+	}
+
+	if len(cfg.Topics) == 0 {
+		exports = append(exports,
+			export{"topics/describe.txt", "kafka-topics.sh", []string{"--describe"}},
+			export{"topics/configs.txt", "kafka-configs.sh", []string{"--describe", "--all", "--entity-type", "topics"}},
+		)
+	} else {
+		for _, topic := range cfg.Topics {
+			exports = append(exports,
+				export{fmt.Sprintf("topics/%s/describe.txt", topic), "kafka-topics.sh",
+					[]string{"--describe", "--topic", topic}},
+				export{fmt.Sprintf("topics/%s/configs.txt", topic), "kafka-configs.sh",
+					[]string{"--describe", "--all", "--entity-type", "topics", "--entity-name", topic}},
+			)
+		}
+	}
+
+	if cfg.IncludeACLs {
+		exports = append(exports, export{"acls.txt", "kafka-acls.sh", []string{"--list"}})
+	}
+
+	filename := k.FileName("kafka_backup", ".tar.gz")
+
+	writer, err := k.newWriter(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup file: %w", err)
+	}
+
+	archive := newArchiveWriter(writer)
+
+	for _, e := range exports {
+		k.LogBackupInfo(ctx, fmt.Sprintf("Exporting %s", e.name))
+
+		output, err := k.run(ctx, e.tool, e.args...)
+		if err != nil {
+			archive.Close()
+			return fmt.Errorf("failed to export %s: %w", e.name, err)
+		}
+
+		if err := archive.AddFile(e.name, output); err != nil {
+			archive.Close()
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	k.LogBackupInfo(ctx, fmt.Sprintf("Kafka metadata backup completed successfully: %s", filename))
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const keycloakUserPageSize = 500
+
+// KeycloakExecutor exports realms (and optionally their users) through the
+// Keycloak admin REST API
+type KeycloakExecutor struct {
+	BaseExecutor
+}
+
+func NewKeycloakExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	if jobConfig.KeycloakConfig == nil {
+		return nil, fmt.Errorf("missing Keycloak configuration for job: %s", jobConfig.Name)
+	}
+
+	return &KeycloakExecutor{
+		BaseExecutor: BaseExecutor{
+			Config:  jobConfig,
+			Storage: store,
+		},
+	}, nil
+}
+
+func (k *KeycloakExecutor) baseURL() string {
+	return strings.TrimSuffix(k.Config.KeycloakConfig.URL, "/")
+}
+
+// token obtains an admin access token using client credentials when a client
+// secret is configured, or the password grant otherwise
+func (k *KeycloakExecutor) token(ctx context.Context) (string, error) {
+	cfg := k.Config.KeycloakConfig
+
+	authRealm := cfg.AuthRealm
+	if authRealm == "" {
+		authRealm = "master"
+	}
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = "admin-cli"
+	}
+
+	form := url.Values{}
+	form.Set("client_id", clientID)
+	if cfg.ClientSecret != "" {
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_secret", cfg.ClientSecret)
+	} else {
+		form.Set("grant_type", "password")
+		form.Set("username", cfg.Username)
+		form.Set("password", cfg.Password)
+	}
+
+	tokenURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", k.baseURL(), url.PathEscape(authRealm))
+	data, err := doRequest(ctx, http.MethodPost, tokenURL,
+		map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to authenticate with Keycloak: %w", err)
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("failed to parse Keycloak token response: %w", err)
+	}
+	if resp.AccessToken == "" {
+		return "", fmt.Errorf("keycloak token response did not contain an access token")
+	}
+
+	return resp.AccessToken, nil
+}
+
+func (k *KeycloakExecutor) realms(ctx context.Context, headers map[string]string) ([]string, error) {
+	if len(k.Config.KeycloakConfig.Realms) > 0 {
+		return k.Config.KeycloakConfig.Realms, nil
+	}
+
+	data, err := doRequest(ctx, http.MethodGet, k.baseURL()+"/admin/realms", headers, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list realms: %w", err)
+	}
+
+	var realms []struct {
+		Realm string `json:"realm"`
+	}
+	if err := json.Unmarshal(data, &realms); err != nil {
+		return nil, fmt.Errorf("failed to parse realm list: %w", err)
+	}
+
+	names := make([]string, 0, len(realms))
+	for _, r := range realms {
+		names = append(names, r.Realm)
+	}
+	return names, nil
+}
+
+// exportUsers pages through the realm's users and returns them as one JSON array
+func (k *KeycloakExecutor) exportUsers(ctx context.Context, realm string, headers map[string]string) ([]byte, error) {
+	users := make([]json.RawMessage, 0)
+
+	for first := 0; ; first += keycloakUserPageSize {
+		usersURL := fmt.Sprintf("%s/admin/realms/%s/users?briefRepresentation=false&first=%d&max=%d",
+			k.baseURL(), url.PathEscape(realm), first, keycloakUserPageSize)
+
+		data, err := doRequest(ctx, http.MethodGet, usersURL, headers, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []json.RawMessage
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse users of realm %s: %w", realm, err)
+		}
+		users = append(users, page...)
+
+		if len(page) < keycloakUserPageSize {
+			break
+		}
+	}
+
+	return json.MarshalIndent(users, "", "  ")
+}
+
+func (k *KeycloakExecutor) Execute(ctx context.Context) error {
+	k.LogBackupInfo(ctx, "Starting Keycloak realm export")
+
+	token, err := k.token(ctx)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Authorization": "Bearer " + token}
+
+	realms, err := k.realms(ctx, headers)
+	if err != nil {
+		return err
+	}
+
+	filename := k.FileName("keycloak_backup", ".tar.gz")
+
+	writer, err := k.newWriter(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup file: %w", err)
+	}
+
+	archive := newArchiveWriter(writer)
+
+	for _, realm := range realms {
+		k.LogBackupInfo(ctx, fmt.Sprintf("Exporting realm %s", realm))
+
+		exportURL := fmt.Sprintf("%s/admin/realms/%s/partial-export?exportClients=true&exportGroupsAndRoles=true",
+			k.baseURL(), url.PathEscape(realm))
+		data, err := doRequest(ctx, http.MethodPost, exportURL, headers, nil)
+		if err != nil {
+			archive.Close()
+			return fmt.Errorf("failed to export realm %s: %w", realm, err)
+		}
+		if err := archive.AddFile(realm+"/realm.json", data); err != nil {
+			archive.Close()
+			return err
+		}
+
+		if k.Config.KeycloakConfig.IncludeUsers {
+			users, err := k.exportUsers(ctx, realm, headers)
+			if err != nil {
+				archive.Close()
+				return fmt.Errorf("failed to export users of realm %s: %w", realm, err)
+			}
+			if err := archive.AddFile(realm+"/users.json", users); err != nil {
+				archive.Close()
+				return err
+			}
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	k.LogBackupInfo(ctx, fmt.Sprintf("Keycloak export completed successfully: %s (%d realms)", filename, len(realms)))
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadChecker is implemented by the executors of database jobs
+type loadChecker interface {
+	CheckLoad(ctx context.Context) (int, time.Duration, error)
+}
+
+func checkLoad(ctx context.Context, executor Executor) (int, time.Duration, error) {
+	if checker, ok := executor.(loadChecker); ok {
+		return checker.CheckLoad(ctx)
+	}
+	return 0, 0, fmt.Errorf("the executor cannot report the load of its source")
+}
+
+func (r *restrictedExecutor) CheckLoad(ctx context.Context) (int, time.Duration, error) {
+	ctx, err := r.processContext(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return checkLoad(ctx, r.Executor)
+}
+
+func (p *permissionsExecutor) CheckLoad(ctx context.Context) (int, time.Duration, error) {
+	return checkLoad(ctx, p.Executor)
+}
+
+func (e *encryptionExecutor) CheckLoad(ctx context.Context) (int, time.Duration, error) {
+	return checkLoad(ctx, e.Executor)
+}
+
+// pgLoadQuery returns the other active queries and the replication lag: how
+// far a standby is behind, or how far the slowest standby of a primary is
+const pgLoadQuery = `SELECT
+  (SELECT count(*) FROM pg_stat_activity WHERE state = 'active' AND pid <> pg_backend_pid()),
+  CASE WHEN pg_is_in_recovery()
+    THEN COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+    ELSE COALESCE((SELECT EXTRACT(EPOCH FROM max(replay_lag)) FROM pg_stat_replication), 0)
+  END`
+
+// CheckLoad returns the number of other active queries on the configured
+// host and its replication lag
+func (p *PostgresExecutor) CheckLoad(ctx context.Context) (int, time.Duration, error) {
+	output, err := p.query(ctx, pgLoadQuery)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read the server load: %w", err)
+	}
+	connections, lag, _ := strings.Cut(output, "|")
+	active, err := strconv.Atoi(connections)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected load query result %q", output)
+	}
+	seconds, err := strconv.ParseFloat(lag, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected load query result %q", output)
+	}
+	return active, time.Duration(seconds * float64(time.Second)), nil
+}
+
+// mysqlLoadQuery counts the statements other clients are running
+const mysqlLoadQuery = `SELECT COUNT(*) FROM information_schema.PROCESSLIST
+  WHERE COMMAND NOT IN ('Sleep', 'Daemon', 'Binlog Dump', 'Binlog Dump GTID') AND ID <> CONNECTION_ID()`
+
+// CheckLoad returns the number of statements other clients are running and,
+// on a replica, how many seconds it is behind its source
+func (m *MySQLExecutor) CheckLoad(ctx context.Context) (int, time.Duration, error) {
+	conn, err := parseMySQLConnectionString(m.Config.MySQLConfig.ConnectionString)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	output, err := m.query(ctx, conn, mysqlLoadQuery)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read the server load: %w", err)
+	}
+	active, err := strconv.Atoi(output)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected load query result %q", output)
+	}
+
+	// SHOW SLAVE STATUS for MySQL before 8.0.22 and MariaDB before 10.5.1
+	status, err := m.queryRows(ctx, conn, "SHOW REPLICA STATUS")
+	if err != nil {
+		if status, err = m.queryRows(ctx, conn, "SHOW SLAVE STATUS"); err != nil {
+			return 0, 0, fmt.Errorf("failed to read the replication status: %w", err)
+		}
+	}
+	return active, replicaLag(status), nil
+}
+
+// replicaLag reads Seconds_Behind_Source from SHOW REPLICA STATUS output. A
+// server that is not a replica returns no rows, and a stopped replica NULL,
+// and neither has a lag.
+func replicaLag(status []byte) time.Duration {
+	lines := strings.Split(strings.TrimSpace(string(status)), "\n")
+	if len(lines) < 2 {
+		return 0
+	}
+	header, row := strings.Split(lines[0], "\t"), strings.Split(lines[1], "\t")
+	for i, column := range header {
+		if (column == "Seconds_Behind_Source" || column == "Seconds_Behind_Master") && i < len(row) {
+			seconds, err := strconv.Atoi(row[i])
+			if err != nil {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
@@ -1,9 +1,13 @@
+//go:build !nominio
+
 package backup
 
 import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/url"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
@@ -12,14 +16,20 @@ import (
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/thitiph0n/backmeup/internal/config"
 	"github.com/thitiph0n/backmeup/internal/storage"
-	"github.com/thitiph0n/backmeup/internal/storage/localfs"
 )
 
+// mcAlias is the alias mc resolves through the MC_HOST_<alias> variable
+const mcAlias = "backmeup"
+
 type MinioExecutor struct {
 	BaseExecutor
 	client *minio.Client
 }
 
+func init() {
+	executors["minio"] = NewMinioExecutor
+}
+
 func NewMinioExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
 	if jobConfig.MinIOConfig == nil {
 		return nil, fmt.Errorf("missing MinIO configuration for job: %s", jobConfig.Name)
@@ -42,8 +52,9 @@ func NewMinioExecutor(jobConfig config.JobConfig, store storage.Storage) (Execut
 	}, nil
 }
 
-func (m *MinioExecutor) checkMCInstalled() error {
+func (m *MinioExecutor) checkMCInstalled(env []string) error {
 	cmd := exec.Command("mc", "version")
+	cmd.Env = env
 	err := cmd.Run()
 	if err != nil {
 		return fmt.Errorf("MinIO Client (mc) is not installed or not in PATH. Please install mc tool: %w", err)
@@ -51,12 +62,36 @@ func (m *MinioExecutor) checkMCInstalled() error {
 	return nil
 }
 
-func (m *MinioExecutor) configureMC(ctx context.Context) (string, error) {
-	cfg := m.Config.MinIOConfig
+// mcConfigDir returns the configuration directory mc uses during a run and a
+// function that removes it afterwards. Without a configured mc_config_dir it
+// is a new temporary directory, so that mc never writes to the home directory
+// of the user running it.
+func (m *MinioExecutor) mcConfigDir(ctx context.Context) (string, func(), error) {
+	if dir := m.Config.MinIOConfig.MCConfigDir; dir != "" {
+		return dir, func() {}, nil
+	}
 
-	alias := fmt.Sprintf("backmeup-%s", m.Config.Name)
+	dir, err := os.MkdirTemp("", tempPrefixMC)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create mc configuration directory: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			m.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to remove mc configuration directory %s: %v", dir, err))
+		}
+	}
+	if err := grantToolAccess(ctx, dir); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return dir, cleanup, nil
+}
 
-	var stdout, stderr bytes.Buffer
+// mcEnvironment configures mc through the environment rather than `mc alias
+// set`, which would expose the secret key in the process list and persist it
+// in mc's configuration directory
+func (m *MinioExecutor) mcEnvironment(ctx context.Context, configDir string) ([]string, error) {
+	cfg := m.Config.MinIOConfig
 
 	endpoint := cfg.Endpoint
 	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
@@ -67,60 +102,64 @@ func (m *MinioExecutor) configureMC(ctx context.Context) (string, error) {
 		}
 	}
 
-	schemeAndHost := endpoint
-
-	slashPos := 0
-	if strings.HasPrefix(endpoint, "https://") {
-		slashPos = 8
-	} else if strings.HasPrefix(endpoint, "http://") {
-		slashPos = 7
-	}
-
-	if pathSlashPos := strings.Index(endpoint[slashPos:], "/"); pathSlashPos != -1 {
-		schemeAndHost = endpoint[:slashPos+pathSlashPos+1]
-	} else if !strings.HasSuffix(endpoint, "/") {
-		schemeAndHost = endpoint + "/"
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MinIO endpoint: %w", err)
 	}
+	hostURL := url.URL{Scheme: u.Scheme, Host: u.Host, User: url.UserPassword(cfg.AccessKey, cfg.SecretKey)}
 
-	endpoint = schemeAndHost
-
-	cmd := exec.CommandContext(ctx, "mc", "alias", "set", alias,
-		endpoint, cfg.AccessKey, cfg.SecretKey)
+	m.LogBackupInfo(ctx, fmt.Sprintf("Configuring MinIO client with endpoint: %s://%s/", u.Scheme, u.Host))
 
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	return append(os.Environ(),
+		fmt.Sprintf("MC_HOST_%s=%s", mcAlias, hostURL.String()),
+		"MC_CONFIG_DIR="+configDir,
+	), nil
+}
 
-	m.LogBackupInfo(fmt.Sprintf("Configuring MinIO client with endpoint: %s", endpoint))
+func (m *MinioExecutor) Execute(ctx context.Context) error {
+	m.LogBackupInfo(ctx, "Starting MinIO backup using mc mirror")
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to configure mc: %w, stderr: %s", err, stderr.String())
+	configDir, cleanup, err := m.mcConfigDir(ctx)
+	if err != nil {
+		return err
 	}
+	defer cleanup()
 
-	return alias, nil
-}
-
-func (m *MinioExecutor) Execute(ctx context.Context) error {
-	m.LogBackupInfo("Starting MinIO backup using mc mirror")
+	env, err := m.mcEnvironment(ctx, configDir)
+	if err != nil {
+		return err
+	}
 
-	if err := m.checkMCInstalled(); err != nil {
+	if err := m.checkMCInstalled(env); err != nil {
 		return err
 	}
 
 	cfg := m.Config.MinIOConfig
 
-	backupDirName := localfs.GenerateFileName("minio_backup", "")
+	backupDirName := m.FileName("minio_backup", "")
 
-	backupDir, err := m.Storage.NewDir(m.Config.Name, backupDirName)
+	// A compressed backup is mirrored to a hidden directory first, which
+	// retention and uploads skip, and archived from there
+	mirrorDirName := backupDirName
+	if m.Config.Compression != nil {
+		mirrorDirName = "." + backupDirName
+	}
+	backupDir, err := m.Storage.NewDir(m.Config.Name, mirrorDirName)
 	if err != nil {
 		return fmt.Errorf("failed to prepare backup directory: %w", err)
 	}
-
-	alias, err := m.configureMC(ctx)
-	if err != nil {
+	if m.Config.Compression != nil {
+		defer func() {
+			if err := os.RemoveAll(backupDir); err != nil {
+				m.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to remove mirror directory %s: %v", backupDir, err))
+			}
+		}()
+	}
+	if err := grantToolAccess(ctx, backupDir); err != nil {
 		return err
 	}
 
-	sourcePath := fmt.Sprintf("%s/%s", alias, cfg.BucketName)
+	sourcePath := fmt.Sprintf("%s/%s", mcAlias, cfg.BucketName)
 	if cfg.SourceFolder != "" {
 		if !strings.HasSuffix(cfg.SourceFolder, "/") {
 			sourcePath = fmt.Sprintf("%s/%s/", sourcePath, cfg.SourceFolder)
@@ -129,11 +168,12 @@ func (m *MinioExecutor) Execute(ctx context.Context) error {
 		}
 	}
 
-	m.LogBackupInfo(fmt.Sprintf("Mirroring from %s to %s", sourcePath, backupDir))
+	m.LogBackupInfo(ctx, fmt.Sprintf("Mirroring from %s to %s", sourcePath, backupDir))
 
 	var stdout, stderr bytes.Buffer
 
-	cmd := exec.CommandContext(ctx, "mc", "mirror", "--preserve", sourcePath, backupDir)
+	cmd := command(ctx, "mc", "mirror", "--preserve", sourcePath, backupDir)
+	cmd.Env = env
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
@@ -150,7 +190,7 @@ func (m *MinioExecutor) Execute(ctx context.Context) error {
 		for {
 			select {
 			case <-ticker.C:
-				m.LogBackupInfo("MC mirror in progress...")
+				m.LogBackupInfo(ctx, "MC mirror in progress...")
 			case <-ctx.Done():
 				return
 			case <-done:
@@ -166,8 +206,32 @@ func (m *MinioExecutor) Execute(ctx context.Context) error {
 		return fmt.Errorf("mc mirror failed: %w, stderr: %s", err, stderr.String())
 	}
 
-	m.LogBackupInfo(fmt.Sprintf("MinIO backup completed successfully to %s", backupDir))
-	m.LogBackupInfo(fmt.Sprintf("mc output: %s", stdout.String()))
+	m.LogBackupInfo(ctx, fmt.Sprintf("mc output: %s", stdout.String()))
+	if m.Config.Compression != nil {
+		filename := backupDirName + ".tar" + m.compressionExtension()
+		if err := m.archiveMirror(ctx, backupDir, backupDirName, filename); err != nil {
+			return err
+		}
+		m.LogBackupInfo(ctx, fmt.Sprintf("MinIO backup completed successfully: %s", filename))
+		return nil
+	}
+	m.LogBackupInfo(ctx, fmt.Sprintf("MinIO backup completed successfully to %s", backupDir))
 
 	return nil
 }
+
+// archiveMirror stores a mirrored bucket as a tar archive compressed with the
+// job's compression, its entries below name
+func (m *MinioExecutor) archiveMirror(ctx context.Context, mirrorDir, name, filename string) error {
+	m.LogBackupInfo(ctx, fmt.Sprintf("Archiving the mirror to %s", filename))
+	writer, err := m.newDumpWriter(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup file: %w", err)
+	}
+	archive := newTarWriter(writer)
+	if err := archive.AddTree(mirrorDir, name, nil); err != nil {
+		archive.Close()
+		return fmt.Errorf("failed to archive the mirror: %w", err)
+	}
+	return archive.Close()
+}
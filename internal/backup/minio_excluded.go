@@ -0,0 +1,7 @@
+//go:build nominio
+
+package backup
+
+func init() {
+	excludedTypes["minio"] = "nominio"
+}
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/manifest"
+)
+
+// modeRunner is implemented by executors that can run a job with the dump
+// mode of one of its schedules
+type modeRunner interface {
+	ExecuteMode(ctx context.Context, mode string) error
+}
+
+// executeMode runs an executor with a dump mode, the job's own for executors
+// of jobs whose schedules all share it
+func executeMode(ctx context.Context, executor Executor, mode string) error {
+	if runner, ok := executor.(modeRunner); ok {
+		return runner.ExecuteMode(ctx, mode)
+	}
+	return executor.Execute(ctx)
+}
+
+// modeExecutor runs a database job whose schedules dump with different modes.
+// The embedded executor dumps with the job's own mode, modes holds one for
+// every other mode.
+type modeExecutor struct {
+	Executor
+	modes map[string]Executor
+}
+
+// ExecuteMode runs the executor of a dump mode, the job's own for an empty
+// or unknown mode
+func (m *modeExecutor) ExecuteMode(ctx context.Context, mode string) error {
+	if executor, ok := m.modes[mode]; ok {
+		return executor.Execute(ctx)
+	}
+	return m.Executor.Execute(ctx)
+}
+
+func (m *modeExecutor) CheckLoad(ctx context.Context) (int, time.Duration, error) {
+	return checkLoad(ctx, m.Executor)
+}
+
+func (m *modeExecutor) DescribeSource(ctx context.Context) (*manifest.Source, error) {
+	return describeSource(ctx, m.Executor)
+}
+
+func (r *restrictedExecutor) ExecuteMode(ctx context.Context, mode string) error {
+	ctx, err := r.processContext(ctx)
+	if err != nil {
+		return err
+	}
+	return executeMode(ctx, r.Executor, mode)
+}
@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// permissionsExecutor applies a job's backup permissions to files that tools
+// wrote directly into backup directories, once the run is over
+type permissionsExecutor struct {
+	Executor
+	jobName string
+	local   *localfs.Storage
+}
+
+func (p *permissionsExecutor) Execute(ctx context.Context) error {
+	return p.ExecuteMode(ctx, "")
+}
+
+func (p *permissionsExecutor) ExecuteMode(ctx context.Context, mode string) error {
+	start := time.Now().Truncate(time.Second)
+	err := executeMode(ctx, p.Executor, mode)
+	if permErr := p.local.ApplyPermissions(p.jobName, start); permErr != nil {
+		return errors.Join(err, permErr)
+	}
+	return err
+}
+
+// backupPermissions returns the job's file_mode, dir_mode and owner settings,
+// or false when the job keeps the defaults
+func backupPermissions(jobConfig config.JobConfig) (localfs.Permissions, bool, error) {
+	if jobConfig.FileMode == "" && jobConfig.DirMode == "" && jobConfig.Owner == "" {
+		return localfs.Permissions{}, false, nil
+	}
+
+	perms := localfs.Permissions{UID: -1, GID: -1}
+	var err error
+	if jobConfig.FileMode != "" {
+		if perms.FileMode, err = config.ParseFileMode(jobConfig.FileMode); err != nil {
+			return localfs.Permissions{}, false, fmt.Errorf("invalid file_mode: %w", err)
+		}
+	}
+	if jobConfig.DirMode != "" {
+		if perms.DirMode, err = config.ParseFileMode(jobConfig.DirMode); err != nil {
+			return localfs.Permissions{}, false, fmt.Errorf("invalid dir_mode: %w", err)
+		}
+	}
+	if jobConfig.Owner != "" {
+		if perms.UID, perms.GID, err = lookupOwner(jobConfig.Owner); err != nil {
+			return localfs.Permissions{}, false, fmt.Errorf("failed to resolve owner '%s': %w", jobConfig.Owner, err)
+		}
+	}
+	return perms, true, nil
+}
@@ -3,14 +3,23 @@ package backup
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/manifest"
 	"github.com/thitiph0n/backmeup/internal/storage"
-	"github.com/thitiph0n/backmeup/internal/storage/localfs"
 )
 
+// standbyCheckTimeout bounds the check of a standby, so that an unreachable
+// node does not delay the failover to the primary
+const standbyCheckTimeout = 10 * time.Second
+
 type PostgresExecutor struct {
 	BaseExecutor
 }
@@ -28,48 +37,271 @@ func NewPostgresExecutor(jobConfig config.JobConfig, store storage.Storage) (Exe
 	}, nil
 }
 
-func (p *PostgresExecutor) Execute(ctx context.Context) error {
-	p.LogBackupInfo("Starting PostgreSQL backup")
+// connectionArgs returns the host, port and user flags shared by pg_dump and psql
+func (p *PostgresExecutor) connectionArgs() []string {
+	cfg := p.Config.PostgresConfig
+
+	args := []string{"-h", cfg.Host}
+
+	if cfg.Port != "" {
+		args = append(args, "-p", cfg.Port)
+	} else {
+		args = append(args, "-p", "5432")
+	}
+
+	if cfg.User != "" {
+		args = append(args, "-U", cfg.User)
+	}
+
+	return args
+}
+
+func (p *PostgresExecutor) environment() []string {
+	env := os.Environ()
+	if password := p.Config.PostgresConfig.Password; password != "" {
+		env = append(env, fmt.Sprintf("PGPASSWORD=%s", password))
+	}
+	return env
+}
+
+// discoverDatabases lists every database that accepts connections, minus the
+// configured exclusions
+func (p *PostgresExecutor) discoverDatabases(ctx context.Context) ([]string, error) {
+	cfg := p.Config.PostgresConfig
+
+	maintenanceDB := cfg.Database
+	if maintenanceDB == "" {
+		maintenanceDB = "postgres"
+	}
 
-	filename := localfs.GenerateFileName("pg_backup", ".sql")
+	args := append(p.connectionArgs(), "-d", maintenanceDB, "--no-password", "-At", "-c",
+		"SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
 
-	writer, err := p.Storage.NewWriter(p.Config.Name, filename)
+	output, err := runCommand(ctx, p.environment(), "psql", args...)
 	if err != nil {
-		return fmt.Errorf("failed to prepare backup file: %w", err)
+		return nil, fmt.Errorf("failed to list databases: %w", err)
 	}
-	defer writer.Close()
 
-	cmdArgs := []string{}
+	return filterDatabases(strings.Split(string(output), "\n"), cfg.ExcludeDatabases), nil
+}
+
+// filterDatabases trims the listed names and drops empty and excluded entries
+func filterDatabases(names, exclude []string) []string {
+	excluded := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		excluded[name] = true
+	}
 
-	host := p.Config.PostgresConfig.Host
+	databases := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name != "" && !excluded[name] {
+			databases = append(databases, name)
+		}
+	}
+	return databases
+}
+
+func (p *PostgresExecutor) Execute(ctx context.Context) error {
+	p.LogBackupInfo(ctx, "Starting PostgreSQL backup")
+
+	if !p.Config.PostgresConfig.PreferStandby {
+		return p.execute(ctx)
+	}
+	node := p.chooseNode(ctx)
+	manifest.RecordNode(ctx, manifest.Node{Host: node.nodeAddress(), Standby: node != p})
+	return node.execute(ctx)
+}
+
+// chooseNode returns an executor for the first standby that is in recovery,
+// or p itself to fail over to the primary
+func (p *PostgresExecutor) chooseNode(ctx context.Context) *PostgresExecutor {
+	for _, address := range p.Config.PostgresConfig.StandbyHosts {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = address, p.Config.PostgresConfig.Port
+		}
+		node := p.onHost(host, port)
+
+		checkCtx, cancel := context.WithTimeout(ctx, standbyCheckTimeout)
+		recovery, err := node.query(checkCtx, "SELECT pg_is_in_recovery()")
+		cancel()
+		switch {
+		case err != nil:
+			p.LogBackupInfo(ctx, fmt.Sprintf("Warning: standby %s is not reachable: %v", address, err))
+		case recovery != "t":
+			p.LogBackupInfo(ctx, fmt.Sprintf("Warning: %s is not in recovery, skipping it", address))
+		default:
+			p.LogBackupInfo(ctx, fmt.Sprintf("Dumping from standby %s", node.nodeAddress()))
+			return node
+		}
+	}
+
+	p.LogBackupInfo(ctx, fmt.Sprintf("No standby available, dumping from primary %s", p.nodeAddress()))
+	return p
+}
+
+// onHost returns a copy of p that connects to another node of the cluster
+func (p *PostgresExecutor) onHost(host, port string) *PostgresExecutor {
+	pg := *p.Config.PostgresConfig
+	pg.Host, pg.Port = host, port
+	node := *p
+	node.Config.PostgresConfig = &pg
+	return &node
+}
+
+// nodeAddress returns the host:port the executor connects to
+func (p *PostgresExecutor) nodeAddress() string {
 	port := p.Config.PostgresConfig.Port
-	user := p.Config.PostgresConfig.User
-	password := p.Config.PostgresConfig.Password
-	dbname := p.Config.PostgresConfig.Database
+	if port == "" {
+		port = "5432"
+	}
+	return net.JoinHostPort(p.Config.PostgresConfig.Host, port)
+}
 
-	cmdArgs = append(cmdArgs, "-h", host)
+// execute dumps the configured databases from the node p connects to
+func (p *PostgresExecutor) execute(ctx context.Context) error {
 
-	if port != "" {
-		cmdArgs = append(cmdArgs, "-p", port)
-	} else {
-		cmdArgs = append(cmdArgs, "-p", "5432")
+	if !p.Config.PostgresConfig.Discover {
+		name, err := p.dumpDatabase(ctx, p.Config.PostgresConfig.Database, p.FileName(dumpPrefix("pg", p.Config.PostgresConfig.DumpMode()), ""))
+		if err != nil {
+			return err
+		}
+		p.LogBackupInfo(ctx, fmt.Sprintf("PostgreSQL backup completed successfully: %s", name))
+		return nil
+	}
+
+	databases, err := p.discoverDatabases(ctx)
+	if err != nil {
+		return err
+	}
+	if len(databases) == 0 {
+		return fmt.Errorf("database discovery found no databases to back up")
 	}
+	p.LogBackupInfo(ctx, fmt.Sprintf("Discovered %d databases: %s", len(databases), strings.Join(databases, ", ")))
 
-	if user != "" {
-		cmdArgs = append(cmdArgs, "-U", user)
+	// All databases of a run share one directory so retention treats the run as a single backup
+	backupDirName := p.FileName(dumpPrefix("pg", p.Config.PostgresConfig.DumpMode()), "")
+	if _, err := p.Storage.NewDir(p.Config.Name, backupDirName); err != nil {
+		return fmt.Errorf("failed to prepare backup directory: %w", err)
 	}
 
+	// A failed database does not stop the others, the run is then partial
+	artifacts := make([]failure.Artifact, 0, len(databases))
+	for _, db := range databases {
+		_, err := p.dumpDatabase(ctx, db, filepath.Join(backupDirName, db))
+		if err != nil {
+			p.LogBackupInfo(ctx, fmt.Sprintf("Backup of database %s failed: %v", db, err))
+		}
+		artifacts = append(artifacts, failure.Artifact{Name: db, Err: err})
+	}
+	if err := failure.Artifacts("databases", artifacts); err != nil {
+		return err
+	}
+
+	p.LogBackupInfo(ctx, fmt.Sprintf("PostgreSQL backup completed successfully: %s (%d databases)", backupDirName, len(databases)))
+
+	return nil
+}
+
+// dumpDatabase writes a plain-format dump of a database to name.sql, or a
+// parallel dump directory called name when parallel dumps are configured. It
+// returns the name of the backup it wrote.
+func (p *PostgresExecutor) dumpDatabase(ctx context.Context, dbname, name string) (string, error) {
+	mode := p.Config.PostgresConfig.DumpMode()
+	if !p.Config.PostgresConfig.DumpsBlobs() && mode != config.ModeSchema {
+		p.warnSkippedBlobs(ctx, dbname)
+	}
+
+	var rows *tableRows
+	if p.Config.PostgresConfig.Verify != nil {
+		rows = newTableRows()
+	}
+
+	if p.Config.PostgresConfig.Parallel != nil {
+		if err := p.dumpDatabaseParallel(ctx, dbname, name, rows); err != nil {
+			return name, err
+		}
+		return name, p.verifyDump(ctx, dbname, rows)
+	}
+
+	// A data dump loads into the existing schema, pg_dump refuses --clean
+	// with it
+	args := []string{"--clean", "--if-exists"}
+	switch mode {
+	case config.ModeSchema:
+		args = append(args, "--schema-only")
+	case config.ModeData:
+		args = []string{"--data-only"}
+	}
+	filename := name + ".sql" + p.compressionExtension()
+	p.LogBackupInfo(ctx, fmt.Sprintf("Running pg_dump to %s", filename))
+	if _, err := p.runPgDump(ctx, filename, p.pgDumpArgs(dbname, args...), rows); err != nil {
+		return filename, err
+	}
+	return filename, p.verifyDump(ctx, dbname, rows)
+}
+
+// warnSkippedBlobs logs a warning when a database holds large objects that
+// the dump leaves out. The check never fails the backup.
+func (p *PostgresExecutor) warnSkippedBlobs(ctx context.Context, dbname string) {
+	count, err := p.queryDatabase(ctx, dbname, "SELECT count(*) FROM pg_largeobject_metadata")
+	if err != nil {
+		p.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to count the large objects of database %s: %v", dbname, err))
+		return
+	}
+	if count != "" && count != "0" {
+		p.LogBackupInfo(ctx, fmt.Sprintf("Warning: database %s has %s large objects that are not included in the dump, set blobs: true to include them", dbname, count))
+	}
+}
+
+// pgDumpArgs returns the pg_dump arguments for a database, with the
+// configured options appended after extra
+func (p *PostgresExecutor) pgDumpArgs(dbname string, extra ...string) []string {
+	cmdArgs := p.connectionArgs()
+
 	cmdArgs = append(cmdArgs, "-d", dbname)
 
+	// With the job's compression the dump is compressed once, by backmeup
+	compressLevel := "--compress=9"
+	if p.Config.Compression != nil {
+		compressLevel = "--compress=0"
+	}
 	cmdArgs = append(cmdArgs,
 		"--no-password",
-		"--clean",
-		"--if-exists",
 		"--no-owner",
-		"--compress=9",
+		compressLevel,
 	)
+	cmdArgs = append(cmdArgs, extra...)
 
-	for key, value := range p.Config.PostgresConfig.Options {
+	pg := p.Config.PostgresConfig
+	for _, table := range pg.IncludeTables {
+		cmdArgs = append(cmdArgs, "--table="+table)
+	}
+	for _, table := range pg.ExcludeTables {
+		cmdArgs = append(cmdArgs, "--exclude-table="+table)
+	}
+	for _, schema := range pg.Schemas {
+		cmdArgs = append(cmdArgs, "--schema="+schema)
+	}
+	for _, schema := range pg.ExcludeSchemas {
+		cmdArgs = append(cmdArgs, "--exclude-schema="+schema)
+	}
+	if pg.Blobs != nil {
+		if *pg.Blobs {
+			cmdArgs = append(cmdArgs, "--blobs")
+		} else {
+			cmdArgs = append(cmdArgs, "--no-blobs")
+		}
+	}
+	for _, extension := range pg.Extensions {
+		cmdArgs = append(cmdArgs, "--extension="+extension)
+	}
+	for _, extension := range pg.ExcludeExtensions {
+		cmdArgs = append(cmdArgs, "--exclude-extension="+extension)
+	}
+
+	for key, value := range pg.Options {
 		if value == "" {
 			cmdArgs = append(cmdArgs, fmt.Sprintf("--%s", key))
 		} else {
@@ -77,22 +309,39 @@ func (p *PostgresExecutor) Execute(ctx context.Context) error {
 		}
 	}
 
-	env := os.Environ()
-	if password != "" {
-		env = append(env, fmt.Sprintf("PGPASSWORD=%s", password))
-	}
+	return cmdArgs
+}
 
-	cmd := exec.CommandContext(ctx, "pg_dump", cmdArgs...)
-	cmd.Env = env
-	cmd.Stdout = writer
-	cmd.Stderr = os.Stderr
+// runPgDump streams pg_dump output into a backup file and returns its size.
+// The rows of each table are added to rows unless it is nil.
+func (p *PostgresExecutor) runPgDump(ctx context.Context, filename string, args []string, rows *tableRows) (int64, error) {
+	writer, err := p.newDumpWriter(ctx, filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare backup file: %w", err)
+	}
 
-	p.LogBackupInfo(fmt.Sprintf("Running pg_dump to %s", filename))
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("pg_dump failed: %w", err)
+	counter := &countingWriter{w: writer}
+	cmd := command(ctx, "pg_dump", args...)
+	cmd.Env = p.environment()
+	cmd.Stdout = counter
+	if rows != nil {
+		rowCounter := newPgRowCounter()
+		gunzip := newGunzipWriter(rowCounter)
+		cmd.Stdout = io.MultiWriter(counter, gunzip)
+		defer func() { rows.add(rowCounter.rows, gunzip.Close()) }()
 	}
+	stderr := newStderrTail()
+	cmd.Stderr = stderr
 
-	p.LogBackupInfo(fmt.Sprintf("PostgreSQL backup completed successfully: %s", filename))
+	err = writeDump(writer, func() error {
+		if err := cmd.Run(); err != nil {
+			return stderr.wrap(fmt.Errorf("pg_dump failed: %w", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	return nil
+	return counter.n, nil
 }
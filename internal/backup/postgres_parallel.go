@@ -0,0 +1,247 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/runid"
+)
+
+const (
+	defaultParallelJobs      = 4
+	defaultParallelTableSize = 1 << 30
+	parallelManifestName     = "manifest.json"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// pgParallelManifest lists the files of a parallel dump in restore order
+type pgParallelManifest struct {
+	Version   int          `json:"version"`
+	RunID     string       `json:"run_id,omitempty"`
+	Database  string       `json:"database"`
+	Snapshot  string       `json:"snapshot"`
+	CreatedAt time.Time    `json:"created_at"`
+	Files     []pgDumpPart `json:"files"`
+}
+
+// pgDumpPart is one pg_dump invocation of a parallel dump
+type pgDumpPart struct {
+	Name    string `json:"name"`
+	Section string `json:"section"`
+	Table   string `json:"table,omitempty"`
+	Size    int64  `json:"size"`
+
+	args []string
+}
+
+// dumpDatabaseParallel dumps the schema, the data of small tables and every
+// large table as separate files, concurrently and from one exported snapshot
+// so the files are consistent with each other. The rows of each table are
+// added to rows unless it is nil.
+func (p *PostgresExecutor) dumpDatabaseParallel(ctx context.Context, dbname, dirName string, rows *tableRows) error {
+	parallel := p.Config.PostgresConfig.Parallel
+
+	jobs := parallel.Jobs
+	if jobs == 0 {
+		jobs = defaultParallelJobs
+	}
+	minSize := int64(defaultParallelTableSize)
+	if parallel.MinTableSize != "" {
+		size, err := config.ParseSize(parallel.MinTableSize)
+		if err != nil {
+			return fmt.Errorf("invalid min_table_size: %w", err)
+		}
+		minSize = size
+	}
+
+	if _, err := p.Storage.NewDir(p.Config.Name, dirName); err != nil {
+		return fmt.Errorf("failed to prepare backup directory: %w", err)
+	}
+
+	snapshot, err := p.exportSnapshot(ctx, dbname)
+	if err != nil {
+		return err
+	}
+	defer snapshot.release()
+
+	tables, err := p.largeTables(ctx, dbname, minSize)
+	if err != nil {
+		return err
+	}
+	p.LogBackupInfo(ctx, fmt.Sprintf("Dumping %s with %d separate tables and %d concurrent jobs", dbname, len(tables), jobs))
+
+	parts := planDumpParts(tables)
+	for i := range parts {
+		parts[i].Name += p.compressionExtension()
+	}
+	errs := make([]error, len(parts))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+
+	for i := range parts {
+		wg.Add(1)
+		go func(part *pgDumpPart, errp *error) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			extra := append([]string{"--snapshot=" + snapshot.id, "--section=" + part.Section}, part.args...)
+			size, err := p.runPgDump(ctx, path.Join(dirName, part.Name), p.pgDumpArgs(dbname, extra...), rows)
+			if err != nil {
+				*errp = fmt.Errorf("%s: %w", part.Name, err)
+				return
+			}
+			part.Size = size
+		}(&parts[i], &errs[i])
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	return p.writeParallelManifest(path.Join(dirName, parallelManifestName), pgParallelManifest{
+		Version:   1,
+		RunID:     runid.From(ctx),
+		Database:  dbname,
+		Snapshot:  snapshot.id,
+		CreatedAt: p.Now(),
+		Files:     parts,
+	})
+}
+
+// planDumpParts returns the dump files in restore order: the schema, the data
+// of every table that is not dumped on its own, each large table and finally
+// indexes and constraints
+func planDumpParts(tables []string) []pgDumpPart {
+	parts := []pgDumpPart{{Name: "pre-data.sql", Section: "pre-data", args: []string{"--clean", "--if-exists"}}}
+
+	data := pgDumpPart{Name: "data.sql", Section: "data"}
+	for _, table := range tables {
+		data.args = append(data.args, "--exclude-table-data="+table)
+	}
+	parts = append(parts, data)
+
+	for i, table := range tables {
+		parts = append(parts, pgDumpPart{
+			Name:    fmt.Sprintf("table-%03d-%s.sql", i+1, unsafeFileChars.ReplaceAllString(table, "_")),
+			Section: "data",
+			Table:   table,
+			args:    []string{"--table=" + table},
+		})
+	}
+
+	return append(parts, pgDumpPart{Name: "post-data.sql", Section: "post-data"})
+}
+
+// largeTables lists the tables at least minSize bytes large, largest first, as
+// quoted schema qualified names
+func (p *PostgresExecutor) largeTables(ctx context.Context, dbname string, minSize int64) ([]string, error) {
+	query := "SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname) " +
+		"FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace " +
+		"WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema') " +
+		"AND n.nspname NOT LIKE 'pg_toast%' " +
+		"AND pg_total_relation_size(c.oid) >= " + strconv.FormatInt(minSize, 10) + " " +
+		"ORDER BY pg_total_relation_size(c.oid) DESC"
+
+	args := append(p.connectionArgs(), "-d", dbname, "--no-password", "-At", "-c", query)
+	output, err := runCommand(ctx, p.environment(), "psql", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list large tables: %w", err)
+	}
+
+	var tables []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			tables = append(tables, line)
+		}
+	}
+	return tables, nil
+}
+
+func (p *PostgresExecutor) writeParallelManifest(filename string, manifest pgParallelManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	writer, err := p.Storage.NewWriter(p.Config.Name, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare manifest: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return writer.Close()
+}
+
+// exportedSnapshot is a psql session holding a repeatable read transaction
+// open so pg_dump processes can share its snapshot
+type exportedSnapshot struct {
+	id     string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *bytes.Buffer
+}
+
+func (p *PostgresExecutor) exportSnapshot(ctx context.Context, dbname string) (*exportedSnapshot, error) {
+	args := append(p.connectionArgs(), "-d", dbname, "--no-password", "-qAt", "-v", "ON_ERROR_STOP=1")
+
+	var stderr bytes.Buffer
+	cmd := command(ctx, "psql", args...)
+	cmd.Env = p.environment()
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("psql failed: %w", err)
+	}
+
+	snapshot := &exportedSnapshot{cmd: cmd, stdin: stdin, stderr: &stderr}
+	if _, err := io.WriteString(stdin, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;\nSELECT pg_export_snapshot();\n"); err != nil {
+		snapshot.release()
+		return nil, fmt.Errorf("failed to export snapshot: %w", err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	snapshot.id = strings.TrimSpace(line)
+	if snapshot.id == "" {
+		stdin.Close()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to export snapshot: %v, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return snapshot, nil
+}
+
+// release ends the transaction, after which the snapshot can no longer be used
+func (s *exportedSnapshot) release() error {
+	io.WriteString(s.stdin, "COMMIT;\n")
+	s.stdin.Close()
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("psql failed: %w, stderr: %s", err, strings.TrimSpace(s.stderr.String()))
+	}
+	return nil
+}
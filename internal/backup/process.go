@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/thitiph0n/backmeup/internal/failure"
+)
+
+// processKey is the context key of the processOptions a job's tools run with
+type processKey struct{}
+
+// processOptions restrict the processes spawned for a job
+type processOptions struct {
+	attr            *syscall.SysProcAttr
+	uid, gid        int // -1 when tools run as the daemon's user
+	noNewPrivileges bool
+}
+
+// restrictedExecutor runs a job's tools as another user and/or without the
+// ability to gain privileges, while the daemon keeps its own privileges for
+// storage access
+type restrictedExecutor struct {
+	Executor
+	runAs           string
+	noNewPrivileges bool
+}
+
+func (r *restrictedExecutor) Execute(ctx context.Context) error {
+	ctx, err := r.processContext(ctx)
+	if err != nil {
+		return err
+	}
+	return r.Executor.Execute(ctx)
+}
+
+// processContext returns a context that makes command apply the restrictions
+func (r *restrictedExecutor) processContext(ctx context.Context) (context.Context, error) {
+	opts := processOptions{uid: -1, gid: -1, noNewPrivileges: r.noNewPrivileges}
+	if r.runAs != "" {
+		attr, uid, gid, err := runAsAttributes(r.runAs)
+		if err != nil {
+			return nil, &failure.ConfigError{Err: fmt.Errorf("failed to resolve run_as '%s': %w", r.runAs, err)}
+		}
+		opts.attr, opts.uid, opts.gid = attr, uid, gid
+	}
+	return context.WithValue(ctx, processKey{}, opts), nil
+}
+
+// command prepares a tool invocation with the job's process restrictions.
+// no_new_privileges is applied through setpriv since Go cannot set it on a
+// child process directly. For the same reason there is no seccomp filter;
+// tools inherit the one the daemon runs under.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	opts, ok := ctx.Value(processKey{}).(processOptions)
+	if !ok {
+		return exec.CommandContext(ctx, name, args...)
+	}
+
+	if opts.noNewPrivileges {
+		args = append([]string{"--no-new-privs", "--", name}, args...)
+		name = "setpriv"
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = opts.attr
+	return cmd
+}
+
+// grantToolAccess hands a file or directory the daemon created to the run_as
+// user, for tools that read or write it directly
+func grantToolAccess(ctx context.Context, path string) error {
+	opts, ok := ctx.Value(processKey{}).(processOptions)
+	if !ok || opts.uid < 0 {
+		return nil
+	}
+	if err := os.Chown(path, opts.uid, opts.gid); err != nil {
+		return fmt.Errorf("failed to grant run_as user access to %s: %w", path, err)
+	}
+	return nil
+}
+
+// maxExcerptLine bounds each line kept of a tool's stderr
+const maxExcerptLine = 1024
+
+// stderrTail passes a tool's stderr on to the log and keeps its last lines,
+// so that a failure can be reported with what the tool said
+type stderrTail struct {
+	mu      sync.Mutex
+	out     io.Writer
+	lines   []string
+	partial []byte // Start of a line not yet terminated
+}
+
+// newStderrTail returns a tail that writes through to the log
+func newStderrTail() *stderrTail {
+	return &stderrTail{out: log.Writer()}
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.keep(string(bytes.TrimRight(t.partial[:i], "\r")))
+		t.partial = t.partial[i+1:]
+	}
+	// A tool printing without newlines only keeps the end of its output
+	if len(t.partial) > maxExcerptLine {
+		t.partial = t.partial[len(t.partial)-maxExcerptLine:]
+	}
+	return t.out.Write(p)
+}
+
+func (t *stderrTail) keep(line string) {
+	if line == "" {
+		return
+	}
+	if len(line) > maxExcerptLine {
+		line = line[:maxExcerptLine]
+	}
+	t.lines = append(t.lines, line)
+	if len(t.lines) > failure.ExcerptLines {
+		t.lines = t.lines[len(t.lines)-failure.ExcerptLines:]
+	}
+}
+
+// wrap attaches the last lines written so far to the error of the tool
+func (t *stderrTail) wrap(err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := t.lines
+	if len(t.partial) > 0 {
+		lines = append(lines, string(t.partial))
+		lines = lines[max(len(lines)-failure.ExcerptLines, 0):]
+	}
+	return &failure.OutputError{Err: err, Lines: slices.Clone(lines)}
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+
+package backup
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runAsAttributes resolves a user[:group] for spawned processes.
+// Supplementary groups are dropped.
+func runAsAttributes(runAs string) (*syscall.SysProcAttr, int, int, error) {
+	uid, gid, err := lookupOwner(runAs)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	attr := &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	return attr, uid, gid, nil
+}
+
+// lookupOwner resolves a user[:group] of names or numeric ids. Without a
+// group the user's primary group is used.
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, hasGroup := strings.Cut(owner, ":")
+
+	uid, err := strconv.Atoi(userName)
+	var account *user.User
+	if err != nil {
+		if account, err = user.Lookup(userName); err != nil {
+			return 0, 0, err
+		}
+		uid, _ = strconv.Atoi(account.Uid)
+	} else if !hasGroup {
+		if account, err = user.LookupId(userName); err != nil {
+			return 0, 0, fmt.Errorf("uid %d has no user entry, set the group as uid:gid", uid)
+		}
+	}
+
+	if !hasGroup {
+		gid, _ := strconv.Atoi(account.Gid)
+		return uid, gid, nil
+	}
+
+	gid, err := strconv.Atoi(groupName)
+	if err != nil {
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+	return uid, gid, nil
+}
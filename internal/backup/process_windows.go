@@ -0,0 +1,16 @@
+//go:build windows
+
+package backup
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func runAsAttributes(string) (*syscall.SysProcAttr, int, int, error) {
+	return nil, 0, 0, fmt.Errorf("run_as is not supported on Windows")
+}
+
+func lookupOwner(string) (int, int, error) {
+	return 0, 0, fmt.Errorf("owner is not supported on Windows")
+}
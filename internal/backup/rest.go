@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const (
+	defaultRESTPollInterval = 10 * time.Second
+	defaultRESTPollTimeout  = 30 * time.Minute
+)
+
+// RESTExecutor triggers a snapshot on an appliance that exposes a backup API
+// (Home Assistant, Grafana, Portainer, ...), optionally waits for it to
+// complete and downloads the resulting archive
+type RESTExecutor struct {
+	BaseExecutor
+}
+
+func NewRESTExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	if jobConfig.RESTConfig == nil {
+		return nil, fmt.Errorf("missing REST configuration for job: %s", jobConfig.Name)
+	}
+
+	return &RESTExecutor{
+		BaseExecutor: BaseExecutor{
+			Config:  jobConfig,
+			Storage: store,
+		},
+	}, nil
+}
+
+func (r *RESTExecutor) headers() map[string]string {
+	cfg := r.Config.RESTConfig
+
+	headers := make(map[string]string, len(cfg.Headers)+1)
+	for key, value := range cfg.Headers {
+		headers[key] = value
+	}
+	if cfg.AuthToken != "" {
+		headers["Authorization"] = "Bearer " + cfg.AuthToken
+	}
+	return headers
+}
+
+// expand substitutes the {id} placeholder with the snapshot identifier
+func expand(template, id string) string {
+	return strings.ReplaceAll(template, "{id}", id)
+}
+
+// waitForCompletion polls the status endpoint until the snapshot reports the
+// configured done value and returns the last status response
+func (r *RESTExecutor) waitForCompletion(ctx context.Context, id string, headers map[string]string) ([]byte, error) {
+	cfg := r.Config.RESTConfig
+
+	interval := cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultRESTPollInterval
+	}
+	timeout := cfg.PollTimeout
+	if timeout <= 0 {
+		timeout = defaultRESTPollTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	statusURL := expand(cfg.StatusURL, id)
+
+	for {
+		data, err := doRequest(ctx, http.MethodGet, statusURL, headers, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to poll snapshot status: %w", err)
+		}
+
+		status, err := jsonField(data, cfg.StatusField)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.EqualFold(status, cfg.DoneValue):
+			return data, nil
+		case cfg.FailedValue != "" && strings.EqualFold(status, cfg.FailedValue):
+			return nil, fmt.Errorf("snapshot reported status %q", status)
+		}
+
+		r.LogBackupInfo(ctx, fmt.Sprintf("Snapshot status: %s, waiting...", status))
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for snapshot to complete: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolveURL makes relative download links absolute using the trigger URL
+func (r *RESTExecutor) resolveURL(ref string) (string, error) {
+	base, err := url.Parse(r.Config.RESTConfig.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	target, err := base.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid download url %q: %w", ref, err)
+	}
+	return target.String(), nil
+}
+
+func (r *RESTExecutor) Execute(ctx context.Context) error {
+	r.LogBackupInfo(ctx, "Starting REST snapshot backup")
+
+	cfg := r.Config.RESTConfig
+
+	extension := cfg.Extension
+	if extension == "" {
+		extension = ".tar"
+	}
+
+	filename := r.FileName("rest_backup", extension)
+
+	writer, err := r.newWriter(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup file: %w", err)
+	}
+
+	var size int64
+	err = writeDump(writer, func() (err error) {
+		size, err = r.snapshot(ctx, writer)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.LogBackupInfo(ctx, fmt.Sprintf("REST snapshot completed successfully: %s (%d bytes)", filename, size))
+
+	return nil
+}
+
+// snapshot requests a snapshot, waits for it when the API builds it in the
+// background and streams it into writer. It returns the size of the snapshot.
+func (r *RESTExecutor) snapshot(ctx context.Context, writer io.Writer) (int64, error) {
+	cfg := r.Config.RESTConfig
+	headers := r.headers()
+
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	r.LogBackupInfo(ctx, fmt.Sprintf("Requesting snapshot from %s", cfg.URL))
+
+	// Without a separate download step the trigger response is the archive itself
+	if cfg.DownloadURL == "" && cfg.DownloadURLField == "" {
+		size, err := streamRequest(ctx, method, cfg.URL, headers, strings.NewReader(cfg.Body), writer)
+		if err != nil {
+			return 0, fmt.Errorf("snapshot request failed: %w", err)
+		}
+		return size, nil
+	}
+
+	response, err := doRequest(ctx, method, cfg.URL, headers, strings.NewReader(cfg.Body))
+	if err != nil {
+		return 0, fmt.Errorf("snapshot request failed: %w", err)
+	}
+
+	var id string
+	if cfg.IDField != "" {
+		if id, err = jsonField(response, cfg.IDField); err != nil {
+			return 0, fmt.Errorf("failed to read snapshot id: %w", err)
+		}
+		r.LogBackupInfo(ctx, fmt.Sprintf("Snapshot %s requested", id))
+	}
+
+	if cfg.StatusURL != "" {
+		if response, err = r.waitForCompletion(ctx, id, headers); err != nil {
+			return 0, err
+		}
+	}
+
+	downloadRef := expand(cfg.DownloadURL, id)
+	if cfg.DownloadURLField != "" {
+		if downloadRef, err = jsonField(response, cfg.DownloadURLField); err != nil {
+			return 0, fmt.Errorf("failed to read download url: %w", err)
+		}
+	}
+
+	downloadURL, err := r.resolveURL(downloadRef)
+	if err != nil {
+		return 0, err
+	}
+
+	r.LogBackupInfo(ctx, fmt.Sprintf("Downloading snapshot from %s", downloadURL))
+	size, err := streamRequest(ctx, http.MethodGet, downloadURL, headers, nil, writer)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot download failed: %w", err)
+	}
+	return size, nil
+}
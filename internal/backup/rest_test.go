@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// failingCloseStorage opens backup files whose Close fails, as encryption
+// does when gpg exits with an error
+type failingCloseStorage struct {
+	storage.Storage
+}
+
+type failingCloseWriter struct {
+	io.WriteCloser
+}
+
+func (w failingCloseWriter) Close() error {
+	w.WriteCloser.Close()
+	return errors.New("gpg failed: exit status 2")
+}
+
+func (s failingCloseStorage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	writer, err := s.Storage.NewWriter(jobName, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return failingCloseWriter{writer}, nil
+}
+
+func TestRESTExecutor_WriterFailsOnClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("snapshot"))
+	}))
+	defer server.Close()
+
+	executor, err := NewRESTExecutor(config.JobConfig{
+		Name:       "api",
+		RESTConfig: &config.RESTConfig{URL: server.URL},
+	}, failingCloseStorage{localfs.New(config.LocalConfig{Directory: t.TempDir()})})
+	require.NoError(t, err)
+
+	assert.ErrorContains(t, executor.Execute(context.Background()), "failed to write backup file: gpg failed")
+}
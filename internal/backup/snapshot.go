@@ -0,0 +1,284 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const (
+	snapshotPrefix     = "backmeup-"
+	snapshotTimeLayout = "20060102-150405"
+)
+
+// snapshotDriver abstracts the filesystem specific snapshot commands
+type snapshotDriver interface {
+	// Create takes a read-only snapshot with the given name
+	Create(ctx context.Context, name string) error
+	// List returns the names of existing backmeup snapshots
+	List(ctx context.Context) ([]string, error)
+	// Send returns a command that streams the snapshot to stdout, relative to
+	// parent when parent is not empty
+	Send(ctx context.Context, name, parent string) *exec.Cmd
+	// Destroy removes a snapshot
+	Destroy(ctx context.Context, name string) error
+}
+
+type zfsDriver struct {
+	dataset string
+}
+
+func (z *zfsDriver) Create(ctx context.Context, name string) error {
+	_, err := runCommand(ctx, nil, "zfs", "snapshot", z.dataset+"@"+name)
+	return err
+}
+
+func (z *zfsDriver) List(ctx context.Context) ([]string, error) {
+	output, err := runCommand(ctx, nil, "zfs", "list", "-H", "-t", "snapshot", "-o", "name", "-d", "1", z.dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		_, name, found := strings.Cut(strings.TrimSpace(line), "@")
+		if found && strings.HasPrefix(name, snapshotPrefix) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (z *zfsDriver) Send(ctx context.Context, name, parent string) *exec.Cmd {
+	if parent == "" {
+		return command(ctx, "zfs", "send", z.dataset+"@"+name)
+	}
+	return command(ctx, "zfs", "send", "-i", z.dataset+"@"+parent, z.dataset+"@"+name)
+}
+
+func (z *zfsDriver) Destroy(ctx context.Context, name string) error {
+	_, err := runCommand(ctx, nil, "zfs", "destroy", z.dataset+"@"+name)
+	return err
+}
+
+type btrfsDriver struct {
+	subvolume   string
+	snapshotDir string
+}
+
+func (b *btrfsDriver) path(name string) string {
+	return filepath.Join(b.snapshotDir, name)
+}
+
+func (b *btrfsDriver) Create(ctx context.Context, name string) error {
+	if err := os.MkdirAll(b.snapshotDir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	_, err := runCommand(ctx, nil, "btrfs", "subvolume", "snapshot", "-r", b.subvolume, b.path(name))
+	return err
+}
+
+func (b *btrfsDriver) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(b.snapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), snapshotPrefix) {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (b *btrfsDriver) Send(ctx context.Context, name, parent string) *exec.Cmd {
+	if parent == "" {
+		return command(ctx, "btrfs", "send", b.path(name))
+	}
+	return command(ctx, "btrfs", "send", "-p", b.path(parent), b.path(name))
+}
+
+func (b *btrfsDriver) Destroy(ctx context.Context, name string) error {
+	_, err := runCommand(ctx, nil, "btrfs", "subvolume", "delete", b.path(name))
+	return err
+}
+
+// SnapshotExecutor backs up a ZFS dataset or Btrfs subvolume by taking a
+// snapshot and streaming it (full or incremental) into storage
+type SnapshotExecutor struct {
+	BaseExecutor
+	driver  snapshotDriver
+	catalog *manifest.Store // Manifests of earlier backups, increments are only sent on top of recorded ones
+}
+
+func (s *SnapshotExecutor) setCatalog(catalog *manifest.Store) {
+	s.catalog = catalog
+}
+
+func NewSnapshotExecutor(jobConfig config.JobConfig, store storage.Storage) (Executor, error) {
+	cfg := jobConfig.SnapshotConfig
+	if cfg == nil {
+		return nil, fmt.Errorf("missing snapshot configuration for job: %s", jobConfig.Name)
+	}
+
+	var driver snapshotDriver
+	switch cfg.Filesystem {
+	case "zfs":
+		driver = &zfsDriver{dataset: cfg.Source}
+	case "btrfs":
+		snapshotDir := cfg.SnapshotDir
+		if snapshotDir == "" {
+			snapshotDir = filepath.Join(cfg.Source, ".backmeup-snapshots")
+		}
+		driver = &btrfsDriver{subvolume: cfg.Source, snapshotDir: snapshotDir}
+	default:
+		return nil, fmt.Errorf("unsupported snapshot filesystem: %s", cfg.Filesystem)
+	}
+
+	return &SnapshotExecutor{
+		BaseExecutor: BaseExecutor{
+			Config:  jobConfig,
+			Storage: store,
+		},
+		driver: driver,
+	}, nil
+}
+
+func (s *SnapshotExecutor) Execute(ctx context.Context) error {
+	cfg := s.Config.SnapshotConfig
+	s.LogBackupInfo(ctx, fmt.Sprintf("Starting %s snapshot backup of %s", cfg.Filesystem, cfg.Source))
+
+	existing, err := s.driver.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	sort.Strings(existing)
+
+	name := snapshotPrefix + s.Now().Format(snapshotTimeLayout)
+	if err := s.driver.Create(ctx, name); err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	s.LogBackupInfo(ctx, fmt.Sprintf("Created snapshot %s", name))
+
+	chain := manifest.Chain{Snapshot: name}
+	kind := "full"
+	if cfg.Incremental && len(existing) > 0 {
+		chain.Parent, chain.Depth = s.chainParent(ctx, existing[len(existing)-1])
+		if chain.Parent != "" {
+			kind = "incr"
+		}
+	}
+	parent := chain.Parent
+
+	filename := s.FileName(fmt.Sprintf("%s_%s", cfg.Filesystem, kind), "."+cfg.Filesystem+s.compressionExtension())
+
+	writer, err := s.newDumpWriter(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to prepare backup file: %w", err)
+	}
+
+	cmd := s.driver.Send(ctx, name, parent)
+	cmd.Stdout = writer
+	stderr := newStderrTail()
+	cmd.Stderr = stderr
+
+	if parent != "" {
+		s.LogBackupInfo(ctx, fmt.Sprintf("Sending incremental stream %s -> %s to %s", parent, name, filename))
+	} else {
+		s.LogBackupInfo(ctx, fmt.Sprintf("Sending full stream of %s to %s", name, filename))
+	}
+	err = writeDump(writer, func() error {
+		if err := cmd.Run(); err != nil {
+			return stderr.wrap(fmt.Errorf("%s send failed: %w", cfg.Filesystem, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	manifest.RecordChain(ctx, chain)
+
+	s.pruneSnapshots(ctx, append(existing, name))
+
+	s.LogBackupInfo(ctx, fmt.Sprintf("Snapshot backup completed successfully: %s", filename))
+
+	return nil
+}
+
+// chainParent decides whether the next backup is an increment on top of the
+// newest snapshot, returning the parent snapshot and the depth of the new
+// backup, or an empty parent for a full backup. A full backup is sent when
+// no recorded backup holds the newest snapshot, such as after a failed send,
+// and once the chain has full_every increments.
+func (s *SnapshotExecutor) chainParent(ctx context.Context, newest string) (string, int) {
+	if s.catalog == nil {
+		return newest, 0
+	}
+	m, found, err := s.catalog.FindSnapshot(s.Config.Name, newest)
+	if err != nil {
+		s.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to read the backup chain, sending a full stream: %v", err))
+		return "", 0
+	}
+	if !found {
+		s.LogBackupInfo(ctx, fmt.Sprintf("No backup holds snapshot %s, sending a full stream", newest))
+		return "", 0
+	}
+
+	depth := m.Chain.Depth + 1
+	if every := s.Config.SnapshotConfig.FullEvery; every > 0 && depth > every {
+		s.LogBackupInfo(ctx, fmt.Sprintf("Chain has %d increments, sending a full stream", depth-1))
+		return "", 0
+	}
+	return newest, depth
+}
+
+// pruneSnapshots removes local snapshots according to the job's retention
+// policy. The newest snapshot is always kept as the base for the next
+// incremental send.
+func (s *SnapshotExecutor) pruneSnapshots(ctx context.Context, snapshots []string) {
+	if len(snapshots) <= 1 {
+		return
+	}
+
+	policy := s.Config.RetentionPolicy
+	newestFirst := make([]string, len(snapshots))
+	for i, name := range snapshots {
+		newestFirst[len(snapshots)-1-i] = name
+	}
+
+	cutoff := s.Now().AddDate(0, 0, -policy.Value)
+
+	for i, name := range newestFirst[1:] {
+		var expired bool
+		switch policy.Type {
+		case "count":
+			expired = i+1 >= policy.Value
+		case "days":
+			created, err := time.ParseInLocation(snapshotTimeLayout, strings.TrimPrefix(name, snapshotPrefix), time.Local)
+			expired = err == nil && created.Before(cutoff)
+		}
+		if !expired {
+			continue
+		}
+
+		if err := s.driver.Destroy(ctx, name); err != nil {
+			s.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to remove snapshot %s: %v", name, err))
+			continue
+		}
+		s.LogBackupInfo(ctx, fmt.Sprintf("Removed snapshot %s", name))
+	}
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// echoDriver sends a fixed stream in place of zfs or btrfs
+type echoDriver struct {
+	snapshots []string
+}
+
+func (d *echoDriver) Create(ctx context.Context, name string) error {
+	d.snapshots = append(d.snapshots, name)
+	return nil
+}
+
+func (d *echoDriver) List(ctx context.Context) ([]string, error) { return d.snapshots, nil }
+
+func (d *echoDriver) Send(ctx context.Context, name, parent string) *exec.Cmd {
+	return exec.CommandContext(ctx, "echo", "stream of "+name)
+}
+
+func (d *echoDriver) Destroy(ctx context.Context, name string) error { return nil }
+
+func TestSnapshotExecutor_WriterFailsOnClose(t *testing.T) {
+	if _, err := exec.LookPath("echo"); err != nil {
+		t.Skip("echo is not available")
+	}
+	executor, err := NewSnapshotExecutor(config.JobConfig{
+		Name:           "tank",
+		SnapshotConfig: &config.SnapshotConfig{Filesystem: "zfs", Source: "tank/data"},
+	}, failingCloseStorage{localfs.New(config.LocalConfig{Directory: t.TempDir()})})
+	require.NoError(t, err)
+	snapshots := executor.(*SnapshotExecutor)
+	snapshots.driver = &echoDriver{}
+
+	ctx, recorder := manifest.WithRecorder(context.Background())
+	assert.ErrorContains(t, executor.Execute(ctx), "failed to write backup file: gpg failed")
+	assert.Nil(t, recorder.Chain(), "a backup that failed to close starts no chain")
+}
@@ -0,0 +1,146 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/manifest"
+)
+
+// sourceDescriber is implemented by the executors of database jobs
+type sourceDescriber interface {
+	DescribeSource(ctx context.Context) (*manifest.Source, error)
+}
+
+// describeSource describes the source of an executor, or returns nil when it
+// does not back up a database
+func describeSource(ctx context.Context, executor Executor) (*manifest.Source, error) {
+	if describer, ok := executor.(sourceDescriber); ok {
+		return describer.DescribeSource(ctx)
+	}
+	return nil, nil
+}
+
+func (r *restrictedExecutor) DescribeSource(ctx context.Context) (*manifest.Source, error) {
+	ctx, err := r.processContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return describeSource(ctx, r.Executor)
+}
+
+func (p *permissionsExecutor) DescribeSource(ctx context.Context) (*manifest.Source, error) {
+	return describeSource(ctx, p.Executor)
+}
+
+func (e *encryptionExecutor) DescribeSource(ctx context.Context) (*manifest.Source, error) {
+	return describeSource(ctx, e.Executor)
+}
+
+// DescribeSource returns the server version and, when a schema_version_query
+// is configured, the schema version of the database being backed up
+func (p *PostgresExecutor) DescribeSource(ctx context.Context) (*manifest.Source, error) {
+	source := &manifest.Source{Engine: manifest.EnginePostgres}
+
+	version, err := p.query(ctx, "SHOW server_version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server version: %w", err)
+	}
+	source.ServerVersion = version
+
+	if query := p.Config.PostgresConfig.SchemaVersionQuery; query != "" {
+		if source.SchemaVersion, err = p.query(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to read schema version: %w", err)
+		}
+	}
+	return source, nil
+}
+
+// query runs a query returning a single value against the configured
+// database, or the postgres database when discovering
+func (p *PostgresExecutor) query(ctx context.Context, query string) (string, error) {
+	dbname := p.Config.PostgresConfig.Database
+	if dbname == "" {
+		dbname = "postgres"
+	}
+	return p.queryDatabase(ctx, dbname, query)
+}
+
+// queryDatabase runs a query returning a single value against a database
+func (p *PostgresExecutor) queryDatabase(ctx context.Context, dbname, query string) (string, error) {
+	args := append(p.connectionArgs(), "-d", dbname, "--no-password", "-At", "-c", query)
+	output, err := runCommand(ctx, p.environment(), "psql", args...)
+	if err != nil {
+		return "", err
+	}
+	return firstLine(output), nil
+}
+
+// queryDatabaseRows runs a query against a database and returns its rows,
+// with the columns separated by tabs
+func (p *PostgresExecutor) queryDatabaseRows(ctx context.Context, dbname, query string) ([]string, error) {
+	args := append(p.connectionArgs(), "-d", dbname, "--no-password", "-At", "-F", "\t", "-c", query)
+	output, err := runCommand(ctx, p.environment(), "psql", args...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+}
+
+// DescribeSource returns the server version and, when a schema_version_query
+// is configured, the schema version of the database being backed up
+func (m *MySQLExecutor) DescribeSource(ctx context.Context) (*manifest.Source, error) {
+	source := &manifest.Source{Engine: manifest.EngineMySQL}
+
+	conn, err := parseMySQLConnectionString(m.Config.MySQLConfig.ConnectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := m.query(ctx, conn, "SELECT VERSION()")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server version: %w", err)
+	}
+	source.ServerVersion = version
+
+	if query := m.Config.MySQLConfig.SchemaVersionQuery; query != "" {
+		if source.SchemaVersion, err = m.query(ctx, conn, query); err != nil {
+			return nil, fmt.Errorf("failed to read schema version: %w", err)
+		}
+	}
+	return source, nil
+}
+
+// query runs a query returning a single value against the connection's
+// database
+func (m *MySQLExecutor) query(ctx context.Context, conn mysqlConnection, query string) (string, error) {
+	output, err := m.queryRows(ctx, conn, query, "--skip-column-names")
+	if err != nil {
+		return "", err
+	}
+	return firstLine(output), nil
+}
+
+// queryRows runs a query against the connection's database and returns the
+// tab separated rows, after a header row unless extra skips it
+func (m *MySQLExecutor) queryRows(ctx context.Context, conn mysqlConnection, query string, extra ...string) ([]byte, error) {
+	optionFile, cleanup, err := writeOptionFile(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	args := append([]string{"--defaults-extra-file=" + optionFile, "--batch"}, extra...)
+	args = append(args, "--execute="+query)
+	if conn.database != "" {
+		args = append(args, conn.database)
+	}
+	return runCommand(ctx, nil, "mysql", args...)
+}
+
+// firstLine returns the first line of a query result
+func firstLine(output []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,33 @@
+package backup
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+func TestSQLiteExecutor_CompressionFailsOnClose(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+	dbPath := filepath.Join(t.TempDir(), "app.db")
+	if out, err := exec.Command("sqlite3", dbPath, "CREATE TABLE t (a); INSERT INTO t VALUES (1);").CombinedOutput(); err != nil {
+		t.Fatalf("failed to create database: %v: %s", err, out)
+	}
+	fakeTools(t, map[string]string{"lz4": "cat > /dev/null\nexit 1\n"})
+
+	executor, err := NewSQLiteExecutor(config.JobConfig{
+		Name:         "app",
+		SQLiteConfig: &config.SQLiteConfig{Path: dbPath},
+		Compression:  &config.CompressionConfig{Algorithm: config.CompressionLz4},
+	}, localfs.New(config.LocalConfig{Directory: t.TempDir()}))
+	require.NoError(t, err)
+
+	assert.ErrorContains(t, executor.Execute(context.Background()), "failed to write backup file: lz4 failed")
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/encrypt"
+)
+
+// Prefixes of the files and directories runs create in the system temporary
+// directory
+const (
+	tempPrefixSQLite = "backmeup-sqlite-"
+	tempPrefixMySQL  = "backmeup-mysql-"
+	tempPrefixMC     = "backmeup-mc-"
+	tempPrefixLVM    = "backmeup-lvm-"
+)
+
+// PruneTempFiles removes the temporary files and directories of runs that
+// were last changed before the given time, left behind when BackMeUp was
+// stopped in the middle of a run. It returns how many were removed and the
+// space they took. LVM mount points are only removed when empty, in case a
+// snapshot is still mounted on one.
+func PruneTempFiles(before time.Time) (int, int64, error) {
+	entries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var removed int
+	var reclaimed int64
+	for _, e := range entries {
+		name := e.Name()
+		if !hasTempPrefix(name) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+
+		path := filepath.Join(os.TempDir(), name)
+		if strings.HasPrefix(name, tempPrefixLVM) {
+			if os.Remove(path) == nil {
+				removed++
+			}
+			continue
+		}
+		size := treeSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			return removed, reclaimed, err
+		}
+		removed++
+		reclaimed += size
+	}
+	return removed, reclaimed, nil
+}
+
+func hasTempPrefix(name string) bool {
+	for _, prefix := range []string{tempPrefixSQLite, tempPrefixMySQL, tempPrefixMC, tempPrefixLVM, encrypt.TempPrefix} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// treeSize returns the size of a file, or of the files below a directory
+func treeSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
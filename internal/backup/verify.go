@@ -0,0 +1,509 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+)
+
+const (
+	// maxLineHead is how much of a dump line is kept to recognize the
+	// statement it starts, longer lines are rows
+	maxLineHead = 64 << 10
+
+	// countBatch is how many tables are counted by one query, which keeps
+	// the query below the length of a command line argument
+	countBatch = 500
+)
+
+// tableRows collects the rows of each table in the dump of one database, from
+// every stream the dump is written by
+type tableRows struct {
+	mu   sync.Mutex
+	rows map[string]int64
+	err  error // The first stream that could not be counted
+}
+
+func newTableRows() *tableRows {
+	return &tableRows{rows: make(map[string]int64)}
+}
+
+// add merges the counts of one stream
+func (t *tableRows) add(rows map[string]int64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	for table, n := range rows {
+		t.rows[table] += n
+	}
+}
+
+// checkDump compares the rows counted in the dump of a database with the rows
+// expected from the source, records the counts in the run's manifest and
+// returns a VerifyError naming every table that does not match
+func checkDump(ctx context.Context, verify *config.VerifyConfig, database string, dumped, expected map[string]int64) error {
+	tables := make([]manifest.TableRows, 0, len(dumped))
+	for _, table := range slices.Sorted(maps.Keys(dumped)) {
+		tables = append(tables, manifest.TableRows{Database: database, Table: table, Rows: dumped[table]})
+	}
+	manifest.RecordTables(ctx, tables)
+
+	var problems []string
+	for _, table := range slices.Sorted(maps.Keys(expected)) {
+		want := expected[table]
+		got, ok := dumped[table]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("table %s is missing from the dump", table))
+		case math.Abs(float64(got-want)) > float64(want)*verify.Tolerance/100:
+			problems = append(problems, fmt.Sprintf("table %s has %d rows in the dump but %d in the source", table, got, want))
+		}
+	}
+	if len(problems) > 0 {
+		return &failure.VerifyError{Err: fmt.Errorf("the dump of database %s does not match its source: %s", database, strings.Join(problems, "; "))}
+	}
+	return nil
+}
+
+// parseRows reads a query result as a number of rows
+func parseRows(table, value string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("the verify query of table %s returned %q instead of a number of rows", table, value)
+	}
+	return n, nil
+}
+
+// countQuery returns a query counting the rows of each table, with the index
+// of the table in front of its count
+func countQuery(tables []string) string {
+	selects := make([]string, len(tables))
+	for i, table := range tables {
+		selects[i] = fmt.Sprintf("SELECT %d, count(*) FROM %s", i, table)
+	}
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// parseCounts maps the rows of a countQuery result back to the names of
+// their tables
+func parseCounts(lines, names []string, expected map[string]int64) error {
+	for _, line := range lines {
+		index, count, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(names) {
+			return fmt.Errorf("unexpected row count result %q", line)
+		}
+		if expected[names[i]], err = strconv.ParseInt(strings.TrimSpace(count), 10, 64); err != nil {
+			return fmt.Errorf("unexpected row count result %q", line)
+		}
+	}
+	return nil
+}
+
+// verifyDump checks the dump of a database against the source, it does
+// nothing when the job does not verify its dumps
+func (p *PostgresExecutor) verifyDump(ctx context.Context, dbname string, rows *tableRows) error {
+	if rows == nil {
+		return nil
+	}
+	if rows.err != nil {
+		return fmt.Errorf("failed to count the rows of the dump of database %s: %w", dbname, rows.err)
+	}
+	expected, err := p.sourceRows(ctx, dbname)
+	if err != nil {
+		return fmt.Errorf("failed to count the rows of database %s: %w", dbname, err)
+	}
+	if err := checkDump(ctx, p.Config.PostgresConfig.Verify, dbname, rows.rows, expected); err != nil {
+		return err
+	}
+	p.LogBackupInfo(ctx, fmt.Sprintf("Verified %d tables of database %s against the source", len(expected), dbname))
+	return nil
+}
+
+// pgTablesQuery lists the tables pg_dump writes the data of: ordinary tables
+// and partitions outside of the system schemas and of extensions
+const pgTablesQuery = `SELECT n.nspname, c.relname, format('%I.%I', n.nspname, c.relname)
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind = 'r' AND n.nspname <> 'information_schema' AND n.nspname NOT LIKE 'pg\_%'
+AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e')
+ORDER BY 1, 2`
+
+// sourceRows returns the rows the dump of a database must hold: a count of
+// every table the job dumps when row_counts is set, and the result of each
+// verify query
+func (p *PostgresExecutor) sourceRows(ctx context.Context, dbname string) (map[string]int64, error) {
+	verify := p.Config.PostgresConfig.Verify
+	expected := make(map[string]int64)
+
+	if verify.RowCounts {
+		lines, err := p.queryDatabaseRows(ctx, dbname, pgTablesQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		var names, idents []string
+		for _, line := range lines {
+			fields := strings.Split(line, "\t")
+			if len(fields) != 3 {
+				continue
+			}
+			name := fields[0] + "." + fields[1]
+			if _, ok := verify.Queries[name]; ok || !pgDumpsTable(p.Config.PostgresConfig, fields[0], fields[1]) {
+				continue
+			}
+			names, idents = append(names, name), append(idents, fields[2])
+		}
+		for start := 0; start < len(idents); start += countBatch {
+			end := min(start+countBatch, len(idents))
+			counts, err := p.queryDatabaseRows(ctx, dbname, countQuery(idents[start:end]))
+			if err != nil {
+				return nil, err
+			}
+			if err := parseCounts(counts, names[start:end], expected); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for table, query := range verify.Queries {
+		value, err := p.queryDatabase(ctx, dbname, query)
+		if err != nil {
+			return nil, fmt.Errorf("verify query of table %s failed: %w", table, err)
+		}
+		if expected[table], err = parseRows(table, value); err != nil {
+			return nil, err
+		}
+	}
+	return expected, nil
+}
+
+// pgDumpsTable reports whether the table and schema filters of a job select
+// a table, following pg_dump: unqualified table patterns match tables of any
+// schema and unquoted patterns are case-insensitive
+func pgDumpsTable(pg *config.PostgresConfig, schema, table string) bool {
+	matchesAny := func(patterns []string, match func(string) bool) bool {
+		return slices.ContainsFunc(patterns, match)
+	}
+	matchesTable := func(pattern string) bool {
+		if s, t, qualified := strings.Cut(pattern, "."); qualified {
+			return pgMatch(s, schema) && pgMatch(t, table)
+		}
+		return pgMatch(pattern, table)
+	}
+	matchesSchema := func(pattern string) bool {
+		return pgMatch(pattern, schema)
+	}
+
+	if len(pg.IncludeTables) > 0 && !matchesAny(pg.IncludeTables, matchesTable) {
+		return false
+	}
+	if len(pg.Schemas) > 0 && !matchesAny(pg.Schemas, matchesSchema) {
+		return false
+	}
+	return !matchesAny(pg.ExcludeTables, matchesTable) && !matchesAny(pg.ExcludeSchemas, matchesSchema)
+}
+
+// pgMatch matches a name with one part of a pg_dump pattern
+func pgMatch(pattern, name string) bool {
+	if strings.Contains(pattern, `"`) {
+		pattern = strings.ReplaceAll(pattern, `"`, "")
+	} else {
+		pattern = strings.ToLower(pattern)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// verifyDump checks the dump of a database against the source, it does
+// nothing when the job does not verify its dumps
+func (m *MySQLExecutor) verifyDump(ctx context.Context, conn mysqlConnection, dbName string, rows *tableRows) error {
+	if rows == nil {
+		return nil
+	}
+	expected, err := m.sourceRows(ctx, conn, dbName)
+	if err != nil {
+		return fmt.Errorf("failed to count the rows of database %s: %w", dbName, err)
+	}
+	if err := checkDump(ctx, m.Config.MySQLConfig.Verify, dbName, rows.rows, expected); err != nil {
+		return err
+	}
+	m.LogBackupInfo(ctx, fmt.Sprintf("Verified %d tables of database %s against the source", len(expected), dbName))
+	return nil
+}
+
+// sourceRows returns the rows the dump of a database must hold: a count of
+// every table the job dumps when row_counts is set, and the result of each
+// verify query
+func (m *MySQLExecutor) sourceRows(ctx context.Context, conn mysqlConnection, dbName string) (map[string]int64, error) {
+	cfg := m.Config.MySQLConfig
+	conn.database = dbName
+	expected := make(map[string]int64)
+
+	if cfg.Verify.RowCounts {
+		output, err := m.queryRows(ctx, conn, fmt.Sprintf(
+			"SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = '%s' AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME",
+			mysqlString(dbName)), "--skip-column-names")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		include, exclude := mysqlTables(cfg.IncludeTables, dbName), mysqlTables(cfg.ExcludeTables, dbName)
+		var names, idents []string
+		for _, table := range strings.Split(string(output), "\n") {
+			if table == "" || slices.Contains(exclude, table) || len(include) > 0 && !slices.Contains(include, table) {
+				continue
+			}
+			if _, ok := cfg.Verify.Queries[table]; ok {
+				continue
+			}
+			names, idents = append(names, table), append(idents, mysqlIdent(dbName)+"."+mysqlIdent(table))
+		}
+		for start := 0; start < len(idents); start += countBatch {
+			end := min(start+countBatch, len(idents))
+			counts, err := m.queryRows(ctx, conn, countQuery(idents[start:end]), "--skip-column-names")
+			if err != nil {
+				return nil, err
+			}
+			if err := parseCounts(strings.Split(string(counts), "\n"), names[start:end], expected); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for table, query := range cfg.Verify.Queries {
+		value, err := m.query(ctx, conn, query)
+		if err != nil {
+			return nil, fmt.Errorf("verify query of table %s failed: %w", table, err)
+		}
+		if expected[table], err = parseRows(table, value); err != nil {
+			return nil, err
+		}
+	}
+	return expected, nil
+}
+
+// mysqlString escapes a value for a single-quoted MySQL string
+func mysqlString(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+// mysqlIdent quotes a MySQL identifier
+func mysqlIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// pgRowCounter counts the rows of each COPY block in a plain-format pg_dump
+// stream
+type pgRowCounter struct {
+	rows  map[string]int64
+	line  []byte // Start of the current line
+	table string // Table of the COPY block being read, empty outside of one
+}
+
+func newPgRowCounter() *pgRowCounter {
+	return &pgRowCounter{rows: make(map[string]int64)}
+}
+
+func (c *pgRowCounter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			c.keep(p)
+			break
+		}
+		c.keep(p[:i])
+		c.endLine()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (c *pgRowCounter) keep(p []byte) {
+	if room := maxLineHead - len(c.line); room > 0 {
+		c.line = append(c.line, p[:min(len(p), room)]...)
+	}
+}
+
+// endLine counts a row of a COPY block, or starts one. Rows are single lines
+// since COPY escapes newlines in values.
+func (c *pgRowCounter) endLine() {
+	line := string(c.line)
+	c.line = c.line[:0]
+
+	if c.table != "" {
+		if line == `\.` {
+			c.table = ""
+		} else {
+			c.rows[c.table]++
+		}
+		return
+	}
+	if rest, ok := strings.CutPrefix(line, "COPY "); ok && strings.HasSuffix(line, " FROM stdin;") {
+		c.table = pgUnquote(pgQualifiedName(rest))
+		c.rows[c.table] += 0
+	}
+}
+
+// pgQualifiedName returns the name at the start of s, which ends at a space
+// outside of double quotes
+func pgQualifiedName(s string) string {
+	quoted := false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// pgUnquote removes the double quotes of a qualified name
+func pgUnquote(name string) string {
+	if !strings.Contains(name, `"`) {
+		return name
+	}
+	name = strings.ReplaceAll(name, `""`, "\x00")
+	name = strings.ReplaceAll(name, `"`, "")
+	return strings.ReplaceAll(name, "\x00", `"`)
+}
+
+// gunzipWriter passes what is written to it to w, decompressed when it is
+// gzip, so that the compressed output of pg_dump can be counted. Writes never
+// fail, an error is returned by Close.
+type gunzipWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func newGunzipWriter(w io.Writer) *gunzipWriter {
+	pr, pw := io.Pipe()
+	g := &gunzipWriter{pipe: pw, done: make(chan error, 1)}
+
+	go func() {
+		br := bufio.NewReader(pr)
+		var r io.Reader = br
+		var err error
+		if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			var zr *gzip.Reader
+			if zr, err = gzip.NewReader(br); err == nil {
+				r = zr
+			}
+		}
+		if err == nil {
+			_, err = io.Copy(w, r)
+		}
+		// Keep taking the dump when it cannot be read
+		io.Copy(io.Discard, br)
+		g.done <- err
+	}()
+	return g
+}
+
+func (g *gunzipWriter) Write(p []byte) (int, error) {
+	return g.pipe.Write(p)
+}
+
+func (g *gunzipWriter) Close() error {
+	g.pipe.Close()
+	return <-g.done
+}
+
+// mysqlRowCounter counts the rows of the extended INSERT statements in a
+// mysqldump stream, and the tables created without rows
+type mysqlRowCounter struct {
+	rows    map[string]int64
+	line    []byte // Start of the current line
+	table   string // Table of the INSERT being read, empty outside of one
+	depth   int    // Parentheses open in the values
+	quote   byte   // Quote of the string being read, 0 outside of one
+	escaped bool
+}
+
+func newMySQLRowCounter() *mysqlRowCounter {
+	return &mysqlRowCounter{rows: make(map[string]int64)}
+}
+
+func (c *mysqlRowCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if c.table != "" {
+			c.value(b)
+			continue
+		}
+		if b == '\n' {
+			c.line = c.line[:0]
+			continue
+		}
+		if len(c.line) < maxLineHead {
+			c.line = append(c.line, b)
+		}
+		if b == ' ' || b == '(' {
+			c.statement()
+		}
+	}
+	return len(p), nil
+}
+
+// statement starts counting the values of an INSERT, or records a table
+// when the line so far starts one
+func (c *mysqlRowCounter) statement() {
+	line := string(c.line)
+	if rest, ok := strings.CutPrefix(line, "INSERT INTO `"); ok && strings.HasSuffix(line, " VALUES ") {
+		table, _, _ := strings.Cut(rest, "`")
+		c.table, c.depth, c.quote, c.escaped = table, 0, 0, false
+		c.rows[table] += 0
+		return
+	}
+	if rest, ok := strings.CutPrefix(line, "CREATE TABLE `"); ok && strings.HasSuffix(line, "` (") {
+		c.rows[strings.TrimSuffix(rest, "` (")] += 0
+	}
+}
+
+// value reads a byte of the values of an INSERT, where every parenthesis at
+// the top level starts a row
+func (c *mysqlRowCounter) value(b byte) {
+	if c.quote != 0 {
+		switch {
+		case c.escaped:
+			c.escaped = false
+		case b == '\\':
+			c.escaped = true
+		case b == c.quote:
+			c.quote = 0
+		}
+		return
+	}
+	switch b {
+	case '\'', '"':
+		c.quote = b
+	case '(':
+		if c.depth == 0 {
+			c.rows[c.table]++
+		}
+		c.depth++
+	case ')':
+		c.depth--
+	case ';', '\n':
+		c.table = ""
+		c.line = c.line[:0]
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package backup
+
+import (
+	"context"
+	"fmt"
+)
+
+// shadowCopies is only available on Windows
+func (f *FilesExecutor) shadowCopies(_ context.Context) (func(string) string, func(), error) {
+	return nil, nil, fmt.Errorf("volume shadow copies are only supported on Windows")
+}
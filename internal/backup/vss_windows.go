@@ -0,0 +1,65 @@
+//go:build windows
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// shadowCopies creates one Volume Shadow Copy per volume referenced by the
+// job's paths and returns a function mapping an original path to its location
+// inside the shadow copy. The cleanup function deletes the shadow copies.
+func (f *FilesExecutor) shadowCopies(ctx context.Context) (func(string) string, func(), error) {
+	devices := make(map[string]string)
+	var ids []string
+
+	cleanup := func() {
+		for _, id := range ids {
+			script := fmt.Sprintf("Get-CimInstance Win32_ShadowCopy | Where-Object { $_.ID -eq '%s' } | Remove-CimInstance", id)
+			if _, err := runCommand(context.Background(), nil, "powershell", "-NoProfile", "-NonInteractive", "-Command", script); err != nil {
+				f.LogBackupInfo(ctx, fmt.Sprintf("Warning: failed to delete shadow copy %s: %v", id, err))
+				continue
+			}
+			f.LogBackupInfo(ctx, fmt.Sprintf("Deleted shadow copy %s", id))
+		}
+	}
+
+	for _, path := range f.Config.FilesConfig.Paths {
+		volume := strings.ToUpper(filepath.VolumeName(path))
+		if _, ok := devices[volume]; ok {
+			continue
+		}
+
+		script := fmt.Sprintf(
+			"$r = Invoke-CimMethod -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{Volume='%s\\'; Context='ClientAccessible'}; "+
+				"if ($r.ReturnValue -ne 0) { throw \"shadow copy failed with code $($r.ReturnValue)\" }; "+
+				"$c = Get-CimInstance Win32_ShadowCopy | Where-Object { $_.ID -eq $r.ShadowID }; "+
+				"Write-Output \"$($c.ID)|$($c.DeviceObject)\"", volume)
+
+		f.LogBackupInfo(ctx, fmt.Sprintf("Creating shadow copy of %s", volume))
+		output, err := runCommand(ctx, nil, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to create shadow copy of %s: %w", volume, err)
+		}
+
+		id, device, found := strings.Cut(strings.TrimSpace(string(output)), "|")
+		if !found || device == "" {
+			cleanup()
+			return nil, nil, fmt.Errorf("unexpected shadow copy output: %s", output)
+		}
+
+		ids = append(ids, id)
+		devices[volume] = device
+	}
+
+	mapper := func(path string) string {
+		volume := filepath.VolumeName(path)
+		return devices[strings.ToUpper(volume)] + strings.TrimPrefix(filepath.Clean(path), volume)
+	}
+
+	return mapper, cleanup, nil
+}
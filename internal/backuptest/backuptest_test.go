@@ -0,0 +1,126 @@
+package backuptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/retention"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	assert.Equal(t, start.Add(time.Hour), clock.Advance(time.Hour))
+	assert.Equal(t, start.Add(time.Hour), clock.Now())
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
+
+func TestExecutor(t *testing.T) {
+	clock := NewClock(time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC))
+	store := NewStorage(clock)
+	executor := &Executor{Storage: store, Job: "orders", Data: []byte("dump")}
+
+	require.NoError(t, executor.Execute(context.Background()))
+	clock.Advance(24 * time.Hour)
+	require.NoError(t, executor.Execute(context.Background()))
+
+	assert.Equal(t, 2, executor.Runs())
+	assert.Equal(t, []string{"backup_20260101-000001.bak", "backup_20260101-000002.bak"}, store.Names("orders"))
+	data, ok := store.Data("orders", "backup_20260101-000002.bak")
+	require.True(t, ok)
+	assert.Equal(t, "dump", string(data))
+
+	entries, err := store.List(context.Background(), "orders")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, clock.Now(), entries[1].ModTime)
+	assert.Equal(t, int64(4), entries[1].Size)
+
+	failing := &Executor{Storage: store, Job: "orders", Err: errors.New("connection refused")}
+	assert.EqualError(t, failing.Execute(context.Background()), "connection refused")
+	assert.Len(t, store.Names("orders"), 2)
+
+	slow := &Executor{Delay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, slow.Execute(ctx), context.Canceled)
+}
+
+func TestStorageRetention(t *testing.T) {
+	now := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(now)
+	store := NewStorage(clock)
+	for day := 1; day <= 5; day++ {
+		store.Put("orders", fmt.Sprintf("day_2026030%d-000000", day), now.AddDate(0, 0, day-6), []byte("x"))
+	}
+
+	job := config.JobConfig{Name: "orders", RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 3, GracePeriod: time.Hour}}
+	require.NoError(t, retention.NewManager(store).ApplyRetentionPolicy(context.Background(), job))
+
+	assert.Equal(t, []string{"day_20260303-000000", "day_20260304-000000", "day_20260305-000000"}, store.Names("orders"))
+	assert.Equal(t, []string{"day_20260301-000000", "day_20260302-000000"}, store.TrashNames("orders"))
+	trashed, err := store.ListTrash(context.Background(), "orders")
+	require.NoError(t, err)
+	assert.Equal(t, now, trashed[0].ModTime)
+
+	store.DeleteErr = errors.New("permission denied")
+	entries, err := store.List(context.Background(), "orders")
+	require.NoError(t, err)
+	assert.Error(t, store.Delete(context.Background(), entries[0]))
+}
+
+func TestStorageNewDir(t *testing.T) {
+	store := NewStorage(nil)
+	_, err := store.NewDir("consul", "snapshot")
+	assert.Error(t, err)
+
+	store.Dir = t.TempDir()
+	dir, err := store.NewDir("consul", "snapshot")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "state.json"), []byte("{}"), 0644))
+
+	entries, err := store.List(context.Background(), "consul")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(2), entries[0].Size)
+
+	require.NoError(t, store.Delete(context.Background(), entries[0]))
+	assert.NoDirExists(t, dir)
+}
+
+func TestBlockingExecutorAndStatusRecorder(t *testing.T) {
+	js := scheduler.NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{})
+	var recorder StatusRecorder
+	js.RegisterStatusCallback(recorder.Record)
+
+	executor := NewBlockingExecutor()
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, executor))
+
+	done := make(chan error)
+	go func() {
+		_, err := js.RunNow("orders")
+		done <- err
+	}()
+
+	<-executor.Started()
+	assert.Contains(t, recorder.Statuses("orders"), scheduler.StatusRunning)
+	executor.Release()
+	require.NoError(t, <-done)
+	statuses := recorder.Statuses("orders")
+	assert.Equal(t, scheduler.StatusComplete, statuses[len(statuses)-1])
+}
@@ -0,0 +1,44 @@
+// Package backuptest provides in-memory test doubles for code that extends
+// backmeup, so executors, storage backends and scheduling logic can be tested
+// without databases, buckets or waiting for real time to pass
+package backuptest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a clock.Clock that only moves when told to. Pass it to the
+// SetClock methods of the scheduler, retention managers and storage, or to
+// backup.CreateExecutor. It is safe for concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock stopped at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to t, which may be in the past
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Advance moves the clock forward by d and returns the new time
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
@@ -0,0 +1,127 @@
+package backuptest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// Executor is a fake backup executor. Every run optionally writes a backup
+// file to Storage and then returns Err.
+type Executor struct {
+	Storage storage.Storage // Receives a backup file per run when set
+	Job     string          // Job the backup files are written for
+	Data    []byte          // Content of the backup files
+	Delay   time.Duration   // How long a run takes, cut short when its context is done
+	Err     error           // Returned by every run
+
+	mu   sync.Mutex
+	runs int
+}
+
+// Execute runs the fake backup
+func (e *Executor) Execute(ctx context.Context) error {
+	e.mu.Lock()
+	e.runs++
+	run := e.runs
+	e.mu.Unlock()
+
+	if e.Delay > 0 {
+		timer := time.NewTimer(e.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if e.Err != nil {
+		return e.Err
+	}
+	if e.Storage == nil {
+		return nil
+	}
+
+	// Named like real backups so that retention removes them, the run
+	// number stands in for the time of day
+	w, err := e.Storage.NewWriter(e.Job, fmt.Sprintf("backup_20260101-%06d.bak", run))
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	if _, err := w.Write(e.Data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+	return w.Close()
+}
+
+// Runs returns how often Execute was called
+func (e *Executor) Runs() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.runs
+}
+
+// BlockingExecutor is a fake executor whose runs last until they are
+// released, to test overlapping runs, concurrency limits and shutdown
+type BlockingExecutor struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+// NewBlockingExecutor returns an executor that blocks every run
+func NewBlockingExecutor() *BlockingExecutor {
+	return &BlockingExecutor{
+		started: make(chan struct{}, 64),
+		release: make(chan struct{}),
+	}
+}
+
+// Execute blocks until Release is called or ctx is done
+func (e *BlockingExecutor) Execute(ctx context.Context) error {
+	e.started <- struct{}{}
+	select {
+	case <-e.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Started is sent a value whenever a run starts
+func (e *BlockingExecutor) Started() <-chan struct{} {
+	return e.started
+}
+
+// Release ends all current and future runs
+func (e *BlockingExecutor) Release() {
+	close(e.release)
+}
+
+// StatusRecorder records the job status updates of a scheduler. Register
+// Record with RegisterStatusCallback.
+type StatusRecorder struct {
+	mu       sync.Mutex
+	statuses map[string][]string
+}
+
+// Record stores a status update
+func (r *StatusRecorder) Record(jobName, status string, _ time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.statuses == nil {
+		r.statuses = make(map[string][]string)
+	}
+	r.statuses[jobName] = append(r.statuses[jobName], status)
+}
+
+// Statuses returns the statuses a job went through, in order
+func (r *StatusRecorder) Statuses(jobName string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.statuses[jobName]...)
+}
@@ -0,0 +1,261 @@
+package backuptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// Storage is an in-memory storage backend. Backups and trashed backups are
+// both keyed "job/name".
+type Storage struct {
+	Clock     *Clock // Time of new and trashed backups, the real time when nil
+	Dir       string // Where NewDir creates directories, NewDir fails when empty
+	WriteErr  error  // Returned by NewWriter and NewDir when set
+	ListErr   error  // Returned by List and ListTrash when set
+	DeleteErr error  // Returned by Delete and MoveToTrash when set
+
+	mu     sync.Mutex
+	backup map[string]object
+	trash  map[string]object
+}
+
+type object struct {
+	data    []byte
+	dir     string // Set for backups created with NewDir
+	modTime time.Time
+}
+
+// NewStorage returns an empty storage backend that uses clock, which may be
+// nil, for modification times
+func NewStorage(clock *Clock) *Storage {
+	return &Storage{
+		Clock:  clock,
+		backup: make(map[string]object),
+		trash:  make(map[string]object),
+	}
+}
+
+func (s *Storage) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock.Now()
+	}
+	return time.Now()
+}
+
+// Put stores a backup directly, for example to test retention against
+// backups of different ages
+func (s *Storage) Put(jobName, name string, modTime time.Time, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backup[path.Join(jobName, name)] = object{data: slices.Clone(data), modTime: modTime}
+}
+
+// Names returns the names of a job's backups, sorted
+func (s *Storage) Names(jobName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return names(s.backup, jobName)
+}
+
+// TrashNames returns the names of a job's trashed backups, sorted
+func (s *Storage) TrashNames(jobName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return names(s.trash, jobName)
+}
+
+// Data returns the content of a backup written with NewWriter or Put
+func (s *Storage) Data(jobName, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.backup[path.Join(jobName, name)]
+	return slices.Clone(obj.data), ok
+}
+
+func names(objects map[string]object, jobName string) []string {
+	var result []string
+	for key := range objects {
+		if job, name := splitKey(key); job == jobName {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func splitKey(key string) (jobName, name string) {
+	i := strings.Index(key, "/")
+	return key[:i], key[i+1:]
+}
+
+func (s *Storage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	if s.WriteErr != nil {
+		return nil, s.WriteErr
+	}
+	return &writer{storage: s, key: path.Join(jobName, fileName)}, nil
+}
+
+// writer stores its data when it is closed
+type writer struct {
+	storage *Storage
+	key     string
+	buf     bytes.Buffer
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed backup %s", w.key)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	s := w.storage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backup[w.key] = object{data: w.buf.Bytes(), modTime: s.now()}
+	return nil
+}
+
+// NewDir creates a real directory below Dir, for executors that write
+// backups as directories
+func (s *Storage) NewDir(jobName, dirName string) (string, error) {
+	if s.WriteErr != nil {
+		return "", s.WriteErr
+	}
+	if s.Dir == "" {
+		return "", fmt.Errorf("backuptest storage has no Dir to create %s in", dirName)
+	}
+
+	dir := filepath.Join(s.Dir, jobName, dirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backup[path.Join(jobName, dirName)] = object{dir: dir, modTime: s.now()}
+	return dir, nil
+}
+
+func (s *Storage) List(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.ListErr != nil {
+		return nil, s.ListErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return entries(s.backup, jobName), nil
+}
+
+func (s *Storage) ListTrash(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.ListErr != nil {
+		return nil, s.ListErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return entries(s.trash, jobName), nil
+}
+
+func entries(objects map[string]object, jobName string) []storage.BackupEntry {
+	var result []storage.BackupEntry
+	for key, obj := range objects {
+		if job, _ := splitKey(key); job != jobName {
+			continue
+		}
+		result = append(result, storage.BackupEntry{Key: key, ModTime: obj.modTime, Size: obj.size()})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func (o object) size() int64 {
+	if o.dir == "" {
+		return int64(len(o.data))
+	}
+	var size int64
+	filepath.WalkDir(o.dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+func (s *Storage) Delete(ctx context.Context, entry storage.BackupEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.DeleteErr != nil {
+		return s.DeleteErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if obj, ok := s.backup[entry.Key]; ok {
+		delete(s.backup, entry.Key)
+		if obj.dir != "" {
+			return os.RemoveAll(obj.dir)
+		}
+		return nil
+	}
+	if obj, ok := s.trash[entry.Key]; ok {
+		delete(s.trash, entry.Key)
+		if obj.dir != "" {
+			return os.RemoveAll(obj.dir)
+		}
+		return nil
+	}
+	return fmt.Errorf("backup %s does not exist", entry.Key)
+}
+
+// MoveToTrash moves a backup to the trash, its ModTime becomes the time it was
+// trashed
+func (s *Storage) MoveToTrash(ctx context.Context, jobName string, entry storage.BackupEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.DeleteErr != nil {
+		return s.DeleteErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.backup[entry.Key]
+	if !ok {
+		return fmt.Errorf("backup %s does not exist", entry.Key)
+	}
+	delete(s.backup, entry.Key)
+	_, name := splitKey(entry.Key)
+	obj.modTime = s.now()
+	s.trash[path.Join(jobName, name)] = obj
+	return nil
+}
@@ -0,0 +1,71 @@
+// Package buildinfo describes the running binary
+package buildinfo
+
+import (
+	"errors"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Version is the release the binary was built from, set at build time with
+// -ldflags "-X github.com/thitiph0n/backmeup/internal/buildinfo.Version=v1.2.3"
+var Version = "dev"
+
+// Commit and Date identify the source revision and build time, set at build
+// time like Version. Builds from a git checkout fill them in from the VCS
+// stamp when they are not set.
+var (
+	Commit string
+	Date   string
+)
+
+// ErrNotCompiledIn is returned for a job type or storage backend that was
+// left out of the binary with a build tag
+var ErrNotCompiledIn = errors.New("not compiled in")
+
+// Info is what /version and --version report
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"build_date"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	BuildTags []string `json:"build_tags,omitempty"`
+	JobTypes  []string `json:"job_types"`
+	Storage   []string `json:"storage"`
+}
+
+// Get returns the build information of the running binary. The feature lists
+// are left for the caller to fill in.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "-tags":
+				info.BuildTags = strings.Split(setting.Value, ",")
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			case "vcs.modified":
+				if setting.Value == "true" && Commit == "" && info.Commit != "" {
+					info.Commit += "-dirty"
+				}
+			}
+		}
+	}
+	return info
+}
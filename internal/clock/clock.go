@@ -0,0 +1,17 @@
+// Package clock abstracts the current time so that code which schedules,
+// names or expires backups can be run against a controlled clock
+package clock
+
+import "time"
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,78 @@
+// Package compress compresses the output of dump tools as it is written, with
+// gzip, zstd or lz4
+package compress
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// NewWriter returns a writer that compresses what is written to it into w
+// with the configured algorithm and level. Close flushes the compressed
+// stream but does not close w. lz4 streams are written by the lz4 tool,
+// which must be installed, and stop when ctx is done.
+func NewWriter(ctx context.Context, w io.Writer, cfg config.CompressionConfig) (io.WriteCloser, error) {
+	switch cfg.Algorithm {
+	case config.CompressionGzip:
+		level := cfg.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case config.CompressionZstd:
+		level := zstd.SpeedDefault
+		if cfg.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(cfg.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case config.CompressionLz4:
+		return newLz4Writer(ctx, w, cfg.Level)
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm: %s", cfg.Algorithm)
+}
+
+// lz4Writer pipes what is written to it through the lz4 tool
+type lz4Writer struct {
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+}
+
+func newLz4Writer(ctx context.Context, w io.Writer, level int) (*lz4Writer, error) {
+	args := []string{"-c", "-q"}
+	if level != 0 {
+		args = append(args, "-"+strconv.Itoa(level))
+	}
+	cmd := exec.CommandContext(ctx, "lz4", args...)
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start lz4: %w", err)
+	}
+	return &lz4Writer{stdin: stdin, cmd: cmd}, nil
+}
+
+func (l *lz4Writer) Write(p []byte) (int, error) {
+	n, err := l.stdin.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("lz4 failed: %w", err)
+	}
+	return n, nil
+}
+
+func (l *lz4Writer) Close() error {
+	l.stdin.Close()
+	if err := l.cmd.Wait(); err != nil {
+		return fmt.Errorf("lz4 failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+var dump = strings.Repeat("INSERT INTO orders VALUES (1, 'widget');\n", 1000)
+
+func compress(t *testing.T, cfg config.CompressionConfig) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(context.Background(), &buf, cfg)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, dump)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Less(t, buf.Len(), len(dump))
+	return buf.Bytes()
+}
+
+func TestGzip(t *testing.T) {
+	r, err := gzip.NewReader(bytes.NewReader(compress(t, config.CompressionConfig{Algorithm: config.CompressionGzip, Level: 9})))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, dump, string(data))
+}
+
+func TestZstd(t *testing.T) {
+	for _, level := range []int{0, 19} {
+		r, err := zstd.NewReader(bytes.NewReader(compress(t, config.CompressionConfig{Algorithm: config.CompressionZstd, Level: level})))
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, dump, string(data))
+	}
+}
+
+func TestLz4(t *testing.T) {
+	if _, err := exec.LookPath("lz4"); err != nil {
+		t.Skip("lz4 is not installed")
+	}
+	compressed := compress(t, config.CompressionConfig{Algorithm: config.CompressionLz4, Level: 9})
+	assert.Equal(t, []byte{0x04, 0x22, 0x4d, 0x18}, compressed[:4], "an lz4 frame")
+
+	cmd := exec.Command("lz4", "-d", "-c")
+	cmd.Stdin = bytes.NewReader(compressed)
+	data, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, dump, string(data))
+}
+
+func TestUnsupported(t *testing.T) {
+	_, err := NewWriter(context.Background(), io.Discard, config.CompressionConfig{Algorithm: "brotli"})
+	assert.EqualError(t, err, "unsupported compression algorithm: brotli")
+}
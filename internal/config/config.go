@@ -2,66 +2,825 @@ package config
 
 import (
 	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"filippo.io/age"
 	"github.com/goccy/go-yaml"
+	"github.com/robfig/cron/v3"
 )
 
 // Config represents the root configuration structure
 type Config struct {
-	Version string        `yaml:"version"`
-	Server  ServerConfig  `yaml:"server"`
-	Storage StorageConfig `yaml:"storage"`
-	Jobs    []JobConfig   `yaml:"jobs"`
+	Version      string             `yaml:"version"`
+	Server       ServerConfig       `yaml:"server"`
+	Storage      StorageConfig      `yaml:"storage"`
+	Scheduler    SchedulerConfig    `yaml:"scheduler,omitempty"`
+	Discovery    DiscoveryConfig    `yaml:"discovery,omitempty"`
+	Coordination CoordinationConfig `yaml:"coordination,omitempty"`
+	History      HistoryConfig      `yaml:"history,omitempty"`
+	Report       ReportConfig       `yaml:"report,omitempty"`
+	Logging      LoggingConfig      `yaml:"logging,omitempty"`
+	Versions     VersionsConfig     `yaml:"config_versions,omitempty"`
+	JobTemplates []JobTemplate      `yaml:"job_templates,omitempty"`
+	Jobs         []JobConfig        `yaml:"jobs"`
+
+	source []byte // The file as written, before environment variables are expanded
+}
+
+// DiscoveryConfig contains settings for discovering jobs at runtime
+type DiscoveryConfig struct {
+	Docker     DockerDiscoveryConfig     `yaml:"docker,omitempty"`
+	Kubernetes KubernetesDiscoveryConfig `yaml:"kubernetes,omitempty"`
+}
+
+// DockerDiscoveryConfig contains settings for creating jobs from container labels
+type DockerDiscoveryConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Endpoint     string        `yaml:"endpoint,omitempty"`      // Docker API endpoint, defaults to unix:///var/run/docker.sock
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"` // How often containers are listed, defaults to 30s
+}
+
+// KubernetesDiscoveryConfig contains settings for creating jobs from BackupJob
+// resources and annotated StatefulSets
+type KubernetesDiscoveryConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Namespace    string        `yaml:"namespace,omitempty"`     // Limit discovery to one namespace, all namespaces if empty
+	APIServer    string        `yaml:"api_server,omitempty"`    // Defaults to the in-cluster API server
+	TokenFile    string        `yaml:"token_file,omitempty"`    // Defaults to the pod's service account token
+	CAFile       string        `yaml:"ca_file,omitempty"`       // Defaults to the pod's service account CA
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"` // How often resources are listed, defaults to 30s
+}
+
+// CoordinationConfig lets several instances share one set of jobs. Every job
+// runs on a single live instance and moves to another when that one goes away.
+type CoordinationConfig struct {
+	Enabled           bool                        `yaml:"enabled"`
+	Backend           string                      `yaml:"backend"`                      // "postgres" or "redis"
+	InstanceID        string                      `yaml:"instance_id,omitempty"`        // Defaults to the hostname
+	HeartbeatInterval time.Duration               `yaml:"heartbeat_interval,omitempty"` // Defaults to 10s
+	MemberTTL         time.Duration               `yaml:"member_ttl,omitempty"`         // Instances silent for longer are considered gone, defaults to 3 heartbeats
+	Postgres          *CoordinationPostgresConfig `yaml:"postgres,omitempty"`
+	Redis             *CoordinationRedisConfig    `yaml:"redis,omitempty"`
+}
+
+// CoordinationPostgresConfig contains the connection used for coordination tables
+type CoordinationPostgresConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port,omitempty"`
+	User     string `yaml:"user,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Database string `yaml:"database"`
+}
+
+// CoordinationRedisConfig contains the connection used for coordination keys
+type CoordinationRedisConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	DB       int    `yaml:"db,omitempty"`
+}
+
+// HistoryConfig controls the record of runs that reports are built from
+type HistoryConfig struct {
+	Path     string `yaml:"path,omitempty"`      // Defaults to .backmeup/history.jsonl in the local storage directory
+	KeepDays int    `yaml:"keep_days,omitempty"` // Older runs are pruned at startup, defaults to 90
+}
+
+// ReportConfig controls the HTML or Markdown run reports written to disk
+type ReportConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Directory string `yaml:"directory,omitempty"` // Defaults to .backmeup/reports in the local storage directory
+	Format    string `yaml:"format,omitempty"`    // "html" (default) or "markdown"
+	Schedule  string `yaml:"schedule,omitempty"`  // Cron schedule of the daily report, defaults to "0 7 * * *"
+	PerRun    bool   `yaml:"per_run,omitempty"`   // Also write a report for every finished run
+}
+
+// LoggingConfig controls the daemon's own log
+type LoggingConfig struct {
+	File     string `yaml:"file,omitempty"`      // Log to this file instead of stderr
+	MaxSize  string `yaml:"max_size,omitempty"`  // Rotate the file once it reaches this size, defaults to 100MB
+	Keep     int    `yaml:"keep,omitempty"`      // Rotated files to keep, 0 keeps them all
+	KeepDays int    `yaml:"keep_days,omitempty"` // Rotated files older than this are removed, 0 keeps them all
+	Compress bool   `yaml:"compress,omitempty"`  // Gzip rotated files
+}
+
+// VersionsConfig controls the copies of previously loaded configurations kept
+// in <config file>.versions for rollback
+type VersionsConfig struct {
+	Keep int `yaml:"keep,omitempty"` // Defaults to 5
+}
+
+// JobTemplate is a job definition containing {{parameter}} placeholders.
+// Jobs referencing it with from_template are expanded once per parameter set.
+type JobTemplate struct {
+	Name string      `yaml:"name"`
+	Job  RawTemplate `yaml:"job"`
+}
+
+// RawTemplate keeps the YAML source of a template so that placeholders can be
+// substituted before the job is decoded, including in non-string fields
+type RawTemplate []byte
+
+// UnmarshalYAML stores the raw YAML of the template node
+func (r *RawTemplate) UnmarshalYAML(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
 }
 
 // ServerConfig contains settings for the HTTP server
 type ServerConfig struct {
-	Enabled bool `yaml:"enabled"`
-	Port    int  `yaml:"port"`
+	Enabled bool        `yaml:"enabled"`
+	Port    int         `yaml:"port"`
+	Token   string      `yaml:"token,omitempty"` // Bearer token the endpoints that change the scheduler state require
+	Debug   DebugConfig `yaml:"debug,omitempty"`
+}
+
+// DebugConfig contains settings for the profiling and runtime state endpoints
+type DebugConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token,omitempty"` // Bearer token the endpoints require
+}
+
+// SchedulerConfig contains settings for job execution limits
+type SchedulerConfig struct {
+	MaxConcurrentJobs int             `yaml:"max_concurrent_jobs,omitempty"` // 0 means unlimited
+	ConcurrencyGroups map[string]int  `yaml:"concurrency_groups,omitempty"`  // Group name to max concurrent jobs, defaults to 1
+	Exclusions        ExclusionConfig `yaml:"exclusions,omitempty"`
+	Cleanup           CleanupConfig   `yaml:"cleanup,omitempty"`
+
+	// Group name to the run time its jobs may use per daily window
+	GroupBudgets map[string]GroupBudgetConfig `yaml:"group_budgets,omitempty"`
+}
+
+// GroupBudgetConfig caps the total run time of the jobs of a concurrency
+// group within a daily window. Scheduled runs left once it is spent wait for
+// the next window.
+type GroupBudgetConfig struct {
+	Window string        `yaml:"window"` // e.g. "22:00-06:00"
+	Budget time.Duration `yaml:"budget"` // e.g. 4h
+}
+
+// CleanupConfig controls the background workers that apply retention and the
+// storage quota after runs
+type CleanupConfig struct {
+	Workers int           `yaml:"workers,omitempty"` // Jobs cleaned up at the same time, defaults to 2
+	Timeout time.Duration `yaml:"timeout,omitempty"` // Longest one cleanup may take, defaults to 1h
+}
+
+// ExclusionConfig lists dates on which scheduled runs must not happen
+type ExclusionConfig struct {
+	Dates           []string      `yaml:"dates,omitempty"`            // YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD
+	ICalURL         string        `yaml:"ical_url,omitempty"`         // All-day and timed VEVENTs mark their days as excluded
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"` // How often the iCal feed is reloaded, defaults to 24h
 }
 
 // StorageConfig contains settings for backup storage
 type StorageConfig struct {
-	Type  string      `yaml:"type"`
-	Local LocalConfig `yaml:"local,omitempty"`
+	Type      string        `yaml:"type"`
+	Local     LocalConfig   `yaml:"local,omitempty"`
+	S3        *S3Config     `yaml:"s3,omitempty"`
+	B2        *B2Config     `yaml:"b2,omitempty"`
+	WebDAV    *WebDAVConfig `yaml:"webdav,omitempty"`
+	Rclone    *RcloneConfig `yaml:"rclone,omitempty"`
+	Quota     QuotaConfig   `yaml:"quota,omitempty"`
+	SplitSize string        `yaml:"split_size,omitempty"` // Split written files into parts of this size, e.g. 4GB
+
+	// Encryption applies to the backups of every job without an encryption
+	// of its own
+	Encryption *EncryptionConfig `yaml:"encryption,omitempty"`
+
+	// Destinations are additional remote storages by name, which the jobs
+	// listing them get a copy of each backup in
+	Destinations map[string]DestinationConfig `yaml:"destinations,omitempty"`
+}
+
+// DestinationConfig is a remote storage that backups are copied to besides
+// the primary storage. The copies are sent from the local staging directory
+// right after each run and the job's retention policy applies to them.
+type DestinationConfig struct {
+	Type   string        `yaml:"type"` // s3, b2, webdav or rclone
+	S3     *S3Config     `yaml:"s3,omitempty"`
+	B2     *B2Config     `yaml:"b2,omitempty"`
+	WebDAV *WebDAVConfig `yaml:"webdav,omitempty"`
+	Rclone *RcloneConfig `yaml:"rclone,omitempty"`
+}
+
+// Storage returns the destination as a storage configuration that stages
+// backups in local
+func (d DestinationConfig) Storage(local LocalConfig) StorageConfig {
+	return StorageConfig{Type: d.Type, Local: local, S3: d.S3, B2: d.B2, WebDAV: d.WebDAV, Rclone: d.Rclone}
+}
+
+// S3Config contains settings for S3 compatible storage. Backups are written to
+// the local directory first and uploaded after each successful run.
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region,omitempty"`
+	Secure    bool   `yaml:"secure"`
+	Prefix    string `yaml:"prefix,omitempty"`     // Key prefix for all jobs, e.g. backmeup/
+	KeepLocal bool   `yaml:"keep_local,omitempty"` // Keep uploaded backups in the local directory as well
+
+	Cache        *CacheConfig `yaml:"cache,omitempty"`         // Keep only the newest uploaded backups locally
+	UploadWindow string       `yaml:"upload_window,omitempty"` // Only upload within this time of day, e.g. "01:00-06:00"
+	StorageClass string       `yaml:"storage_class,omitempty"` // Storage class of uploads, such as STANDARD_IA, unless a job sets its own
+
+	ObjectTags *ObjectTagsConfig `yaml:"object_tags,omitempty"` // Tag uploaded objects with their job, run and retention class
+}
+
+// B2Config contains settings for a Backblaze B2 bucket, which is reached
+// through its S3 compatible API. Like s3 storage, backups are staged in the
+// local directory and uploaded after each successful run.
+type B2Config struct {
+	KeyID          string `yaml:"key_id"`          // ID of an application key with access to the bucket
+	ApplicationKey string `yaml:"application_key"` // Secret of the application key
+	Bucket         string `yaml:"bucket"`
+	Region         string `yaml:"region"`             // Region of the bucket's endpoint, e.g. us-west-004
+	Endpoint       string `yaml:"endpoint,omitempty"` // Defaults to s3.<region>.backblazeb2.com
+	Prefix         string `yaml:"prefix,omitempty"`
+	KeepLocal      bool   `yaml:"keep_local,omitempty"`
+
+	Cache        *CacheConfig `yaml:"cache,omitempty"`
+	UploadWindow string       `yaml:"upload_window,omitempty"`
+
+	// Only hide deleted backups and leave removing their file versions to
+	// the bucket's lifecycle rules. By default retention deletes every
+	// version, B2 keeps hidden files and bills them otherwise.
+	HideOnly bool `yaml:"hide_only,omitempty"`
+}
+
+// S3Config returns the settings of the bucket's S3 compatible API
+func (b B2Config) S3Config() S3Config {
+	endpoint := b.Endpoint
+	if endpoint == "" {
+		endpoint = "s3." + b.Region + ".backblazeb2.com"
+	}
+	return S3Config{
+		Endpoint:     endpoint,
+		AccessKey:    b.KeyID,
+		SecretKey:    b.ApplicationKey,
+		Bucket:       b.Bucket,
+		Region:       b.Region,
+		Secure:       true,
+		Prefix:       b.Prefix,
+		KeepLocal:    b.KeepLocal,
+		Cache:        b.Cache,
+		UploadWindow: b.UploadWindow,
+	}
+}
+
+// minWebDAVChunkSize is the smallest chunk Nextcloud accepts, but for the last
+const minWebDAVChunkSize = 5 << 20
+
+// WebDAVConfig contains settings for a WebDAV server such as Nextcloud or
+// ownCloud. Backups are staged in the local directory and uploaded after each
+// successful run.
+type WebDAVConfig struct {
+	URL      string `yaml:"url"` // Collection backups are stored below, e.g. https://cloud.example.com/remote.php/dav/files/backup/backmeup
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// Nextcloud uploads collection, e.g. https://cloud.example.com/remote.php/dav/uploads/backup.
+	// Files larger than ChunkSize are uploaded through it in chunks.
+	ChunkURL  string `yaml:"chunk_url,omitempty"`
+	ChunkSize string `yaml:"chunk_size,omitempty"` // Defaults to 100MB
+
+	KeepLocal    bool         `yaml:"keep_local,omitempty"`
+	Cache        *CacheConfig `yaml:"cache,omitempty"`
+	UploadWindow string       `yaml:"upload_window,omitempty"`
+}
+
+// RcloneConfig contains settings for storage reached through rclone, which
+// supports many destinations the other storage types do not. Backups are
+// staged in the local directory and uploaded after each successful run.
+type RcloneConfig struct {
+	Remote     string   `yaml:"remote"`                // Remote and path backups are stored below, e.g. gdrive:backups
+	Binary     string   `yaml:"binary,omitempty"`      // Path of the rclone binary, defaults to rclone on the PATH
+	ConfigFile string   `yaml:"config_file,omitempty"` // rclone configuration file, defaults to rclone's own
+	Flags      []string `yaml:"flags,omitempty"`       // Extra flags for every rclone command, e.g. --transfers=8
+
+	KeepLocal    bool         `yaml:"keep_local,omitempty"`
+	Cache        *CacheConfig `yaml:"cache,omitempty"`
+	UploadWindow string       `yaml:"upload_window,omitempty"`
+}
+
+// Default object tag keys
+const (
+	DefaultJobTagKey       = "backmeup-job"
+	DefaultRunIDTagKey     = "backmeup-run-id"
+	DefaultRetentionTagKey = "backmeup-retention"
+)
+
+// maxObjectTags is the number of tags S3 allows on one object
+const maxObjectTags = 10
+
+// objectTagText matches the characters S3 allows in tag keys and values
+var objectTagText = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// ObjectTagsConfig names the tags set on uploaded objects, so that bucket
+// lifecycle rules, policies and external tools can select backups by job,
+// run or retention class
+type ObjectTagsConfig struct {
+	JobKey       string            `yaml:"job_key,omitempty"`       // Defaults to backmeup-job
+	RunIDKey     string            `yaml:"run_id_key,omitempty"`    // Defaults to backmeup-run-id
+	RetentionKey string            `yaml:"retention_key,omitempty"` // Defaults to backmeup-retention
+	Extra        map[string]string `yaml:"extra,omitempty"`         // Set on every object, such as team: platform
+}
+
+// CacheConfig keeps the newest uploaded backups of each job in the local
+// directory for fast restores, removing older copies after each upload
+type CacheConfig struct {
+	Keep    int    `yaml:"keep"`               // Newest backups kept per job
+	MaxSize string `yaml:"max_size,omitempty"` // Total size of the cache, the oldest copies are removed first, e.g. 50GB
+}
+
+// QuotaConfig is a storage budget shared by all jobs. When it is exceeded the
+// backups of the jobs with the lowest quota_weight are removed first.
+type QuotaConfig struct {
+	MaxSize string `yaml:"max_size,omitempty"` // e.g. 500GB, empty disables the quota
+	MinKeep int    `yaml:"min_keep,omitempty"` // Backups every job keeps regardless of the quota, defaults to 1
 }
 
 // LocalConfig contains settings for local file storage
 type LocalConfig struct {
-	Directory string `yaml:"directory"`
-	MaxSize   string `yaml:"max_size"`
+	Directory      string `yaml:"directory"`
+	MaxSize        string `yaml:"max_size"`                  // Size the directory may hold, e.g. 200GB, empty for no limit
+	OnMaxSize      string `yaml:"on_max_size,omitempty"`     // "warn" (default) or "refuse" runs whose backup would not fit
+	EarlyRetention bool   `yaml:"early_retention,omitempty"` // Apply the job's retention policy before a run whose backup would not fit
 }
 
 // JobConfig represents a single backup job configuration
 type JobConfig struct {
-	Name            string          `yaml:"name"`
-	Description     string          `yaml:"description"`
-	Type            string          `yaml:"type"`
-	PostgresConfig  *PostgresConfig `yaml:"postgres_config,omitempty"`
-	MySQLConfig     *MySQLConfig    `yaml:"mysql_config,omitempty"`
-	MinIOConfig     *MinIOConfig    `yaml:"minio_config,omitempty"`
-	Schedule        string          `yaml:"schedule"`
-	RetentionPolicy RetentionPolicy `yaml:"retention_policy"`
-	Notification    Notification    `yaml:"notification"`
+	Name             string              `yaml:"name"`
+	FromTemplate     string              `yaml:"from_template,omitempty"`
+	Parameters       []map[string]string `yaml:"parameters,omitempty"` // One job is created per parameter set
+	Description      string              `yaml:"description"`
+	Tags             []string            `yaml:"tags,omitempty"`   // Free-form labels used to filter jobs in the API
+	Labels           map[string]string   `yaml:"labels,omitempty"` // Attached to the job's metrics and notification events
+	Type             string              `yaml:"type"`
+	PostgresConfig   *PostgresConfig     `yaml:"postgres_config,omitempty"`
+	MySQLConfig      *MySQLConfig        `yaml:"mysql_config,omitempty"`
+	MinIOConfig      *MinIOConfig        `yaml:"minio_config,omitempty"`
+	KafkaConfig      *KafkaConfig        `yaml:"kafka_config,omitempty"`
+	ConsulConfig     *ConsulConfig       `yaml:"consul_config,omitempty"`
+	KeycloakConfig   *KeycloakConfig     `yaml:"keycloak_config,omitempty"`
+	RESTConfig       *RESTConfig         `yaml:"rest_config,omitempty"`
+	GrafanaConfig    *GrafanaConfig      `yaml:"grafana_config,omitempty"`
+	SnapshotConfig   *SnapshotConfig     `yaml:"snapshot_config,omitempty"`
+	FilesConfig      *FilesConfig        `yaml:"files_config,omitempty"`
+	SQLiteConfig     *SQLiteConfig       `yaml:"sqlite_config,omitempty"`
+	Schedule         string              `yaml:"-"`                           // Cron schedule, the first entry's when schedule is a list
+	Schedules        []ScheduleEntry     `yaml:"-"`                           // Every entry when schedule is a list, see ScheduleEntries
+	ConcurrencyGroup string              `yaml:"concurrency_group,omitempty"` // Jobs sharing a group never exceed its limit
+	Priority         int                 `yaml:"priority,omitempty"`          // Higher priority jobs leave the queue first
+	QuotaWeight      int                 `yaml:"quota_weight,omitempty"`      // Jobs with lower weights lose backups first when the storage quota is exceeded
+	OnExcludedDate   string              `yaml:"on_excluded_date,omitempty"`  // "skip" (default), "shift" or "run"
+	PauseUntil       string              `yaml:"pause_until,omitempty"`       // Skip scheduled runs until this date or local time, see ParsePauseUntil
+	RunAs            string              `yaml:"run_as,omitempty"`            // Run the job's tools as user[:group], by name or numeric id
+	NoNewPrivileges  bool                `yaml:"no_new_privileges,omitempty"` // Stop the job's tools from gaining privileges (Linux only)
+	FileMode         string              `yaml:"file_mode,omitempty"`         // Octal mode of backup files, such as "0640"
+	DirMode          string              `yaml:"dir_mode,omitempty"`          // Octal mode of backup directories, such as "0750"
+	Owner            string              `yaml:"owner,omitempty"`             // Owner of backups as user[:group], by name or numeric id
+	MaxBackupAge     time.Duration       `yaml:"max_backup_age,omitempty"`    // Backups older than this are reported stale, defaults to twice the schedule interval
+	LoadCheck        *LoadCheckConfig    `yaml:"load_check,omitempty"`        // Defer scheduled runs while the source database is busy
+	Compression      *CompressionConfig  `yaml:"compression,omitempty"`       // Compress dump output as it is written
+	Encryption       *EncryptionConfig   `yaml:"encryption,omitempty"`        // Encrypt backups before they are stored, instead of storage.encryption
+	StorageClass     string              `yaml:"storage_class,omitempty"`     // Storage class of the job's uploads to s3 storage
+	Transitions      []TransitionConfig  `yaml:"transitions,omitempty"`       // Move uploaded backups to cheaper storage classes as they age
+	Destinations     []string            `yaml:"destinations,omitempty"`      // Storage destinations that get a copy of each backup
+	RetentionPolicy  RetentionPolicy     `yaml:"retention_policy"`
+	Notification     Notification        `yaml:"notification"`
+}
+
+// ScheduleEntry is one of several schedules of a job. Its runs dump with its
+// mode instead of the job's, so that a job can take frequent schema dumps and
+// a nightly full one.
+type ScheduleEntry struct {
+	Cron string `json:"cron" yaml:"cron"`
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"` // Dump mode of this schedule's runs, the job's mode when empty
+}
+
+// UnmarshalYAML accepts an entry written as a bare cron expression
+func (e *ScheduleEntry) UnmarshalYAML(unmarshal func(any) error) error {
+	if err := unmarshal(&e.Cron); err == nil {
+		return nil
+	}
+	type plain ScheduleEntry
+	return unmarshal((*plain)(e))
+}
+
+// UnmarshalYAML decodes a job whose schedule is either a cron expression or
+// a list of schedule entries
+func (j *JobConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	type plain JobConfig
+	if err := unmarshal((*plain)(j)); err != nil {
+		return err
+	}
+
+	var single struct {
+		Schedule string `yaml:"schedule"`
+	}
+	if err := unmarshal(&single); err == nil {
+		j.Schedule = single.Schedule
+		return nil
+	}
+	var list struct {
+		Schedule []ScheduleEntry `yaml:"schedule"`
+	}
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("schedule must be a cron expression or a list of them: %w", err)
+	}
+	j.Schedules = list.Schedule
+	if len(list.Schedule) > 0 {
+		j.Schedule = list.Schedule[0].Cron
+	}
+	return nil
+}
+
+// ScheduleEntries returns every schedule of the job, which is just Schedule
+// unless schedule was written as a list
+func (j JobConfig) ScheduleEntries() []ScheduleEntry {
+	if len(j.Schedules) > 0 {
+		return j.Schedules
+	}
+	return []ScheduleEntry{{Cron: j.Schedule}}
+}
+
+// DescribeSchedule lists the job's cron expressions, each followed by the
+// mode it sets
+func (j JobConfig) DescribeSchedule() string {
+	entries := j.ScheduleEntries()
+	described := make([]string, len(entries))
+	for i, entry := range entries {
+		described[i] = entry.Cron
+		if entry.Mode != "" {
+			described[i] += " [" + entry.Mode + "]"
+		}
+	}
+	return strings.Join(described, ", ")
+}
+
+// NextRun returns the first time after from that one of the job's schedules
+// fires, zero when none of them parses
+func (j JobConfig) NextRun(from time.Time) time.Time {
+	var next time.Time
+	for _, entry := range j.ScheduleEntries() {
+		sched, err := cron.ParseStandard(entry.Cron)
+		if err != nil {
+			continue
+		}
+		if at := sched.Next(from); !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	return next
+}
+
+// ScheduleAt returns the schedule that a run starting at t belongs to. When
+// several of the job's schedules fire at that minute the job runs once: with
+// a full dump if one of them takes it, since it holds the schema and the
+// data, and otherwise with the first of them.
+func (j JobConfig) ScheduleAt(t time.Time) (ScheduleEntry, bool) {
+	minute := t.Truncate(time.Minute)
+	var due []ScheduleEntry
+	for _, entry := range j.ScheduleEntries() {
+		sched, err := cron.ParseStandard(entry.Cron)
+		if err == nil && sched.Next(minute.Add(-time.Second)).Equal(minute) {
+			due = append(due, entry)
+		}
+	}
+	if len(due) == 0 {
+		return ScheduleEntry{}, false
+	}
+	for _, entry := range due {
+		if j.ForSchedule(entry).DumpMode() == ModeFull {
+			return entry, true
+		}
+	}
+	return due[0], true
+}
+
+// ForSchedule returns the job as run by one of its schedules: with the
+// entry's dump mode, and without parallel dumps and verification when the
+// mode leaves them nothing to work on
+func (j JobConfig) ForSchedule(entry ScheduleEntry) JobConfig {
+	if entry.Mode == "" || entry.Mode == j.DumpMode() {
+		return j
+	}
+	switch {
+	case j.Type == "postgres" && j.PostgresConfig != nil:
+		pg := *j.PostgresConfig
+		pg.Mode = entry.Mode
+		if entry.Mode != ModeFull {
+			pg.Parallel = nil
+		}
+		if entry.Mode == ModeSchema {
+			pg.Verify = nil
+		}
+		j.PostgresConfig = &pg
+	case j.Type == "mysql" && j.MySQLConfig != nil:
+		my := *j.MySQLConfig
+		my.Mode = entry.Mode
+		my.NoData = false
+		if entry.Mode != ModeFull {
+			my.Verify = nil
+		}
+		j.MySQLConfig = &my
+	}
+	return j
+}
+
+// pauseLayouts are the formats of pause_until, in local time unless they
+// carry an offset
+var pauseLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParsePauseUntil parses the end of a job's pause: a date such as 2024-08-15,
+// which ends the pause at the start of that day, or a date and time
+func ParsePauseUntil(value string) (time.Time, error) {
+	for _, layout := range pauseLayouts {
+		if until, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return until, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid pause_until '%s', use a date such as 2024-08-15 or a date and time such as 2024-08-15T06:00", value)
+}
+
+// PausedUntil returns the end of the pause set with pause_until, or the zero
+// time when the job has none
+func (j JobConfig) PausedUntil() time.Time {
+	if j.PauseUntil == "" {
+		return time.Time{}
+	}
+	until, _ := ParsePauseUntil(j.PauseUntil)
+	return until
+}
+
+// LoadCheckConfig defers the scheduled runs of a database job while its
+// source is busy. A run deferred max_retries times runs anyway.
+type LoadCheckConfig struct {
+	MaxActiveConnections int           `yaml:"max_active_connections,omitempty"` // Busy when more queries than this are running
+	MaxReplicationLag    time.Duration `yaml:"max_replication_lag,omitempty"`    // Busy when replication lags more than this
+	RetryInterval        time.Duration `yaml:"retry_interval,omitempty"`         // Wait between checks, default 5m
+	MaxRetries           int           `yaml:"max_retries,omitempty"`            // Deferrals before running anyway, default 6
+}
+
+// Compression algorithms of dump output
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionLz4  = "lz4"
+)
+
+// compressionLevels are the levels each algorithm accepts
+var compressionLevels = map[string][2]int{
+	CompressionGzip: {1, 9},
+	CompressionZstd: {1, 22},
+	CompressionLz4:  {1, 12},
+}
+
+// CompressionConfig compresses what a job's dump tool writes before it is
+// stored, and adds the algorithm's extension to the file name
+type CompressionConfig struct {
+	Algorithm string `yaml:"algorithm"`       // gzip, zstd or lz4
+	Level     int    `yaml:"level,omitempty"` // The algorithm's default when 0
+}
+
+// UnmarshalYAML accepts a compression written as the bare algorithm
+func (c *CompressionConfig) UnmarshalYAML(unmarshal func(any) error) error {
+	if err := unmarshal(&c.Algorithm); err == nil {
+		return nil
+	}
+	type plain CompressionConfig
+	return unmarshal((*plain)(c))
+}
+
+// Extension returns the file extension of the algorithm, such as .zst
+func (c CompressionConfig) Extension() string {
+	switch c.Algorithm {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	case CompressionLz4:
+		return ".lz4"
+	}
+	return ""
+}
+
+func (c CompressionConfig) validate() error {
+	levels, ok := compressionLevels[c.Algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported compression algorithm '%s', use gzip, zstd or lz4", c.Algorithm)
+	}
+	if c.Level != 0 && (c.Level < levels[0] || c.Level > levels[1]) {
+		return fmt.Errorf("%s compression level must be between %d and %d", c.Algorithm, levels[0], levels[1])
+	}
+	return nil
+}
+
+// Encryption types of backups
+const (
+	EncryptionGPG = "gpg"
+	EncryptionAge = "age"
+)
+
+// EncryptionConfig encrypts backups to public keys before they are stored,
+// and adds the type's extension to their names
+type EncryptionConfig struct {
+	Type           string   `yaml:"type,omitempty"`            // gpg, the default, or age
+	PublicKeyFile  string   `yaml:"public_key_file,omitempty"` // gpg: OpenPGP public key, armored or binary, every key in it is a recipient
+	Recipients     []string `yaml:"recipients,omitempty"`      // age: public keys such as age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+	RecipientsFile string   `yaml:"recipients_file,omitempty"` // age: file with one public key per line, # starts a comment
+}
+
+// EncryptionType returns the type of the encryption, gpg when it is not set
+func (e EncryptionConfig) EncryptionType() string {
+	if e.Type == "" {
+		return EncryptionGPG
+	}
+	return e.Type
+}
+
+// Extension returns the file extension of encrypted backups, such as .gpg
+func (e EncryptionConfig) Extension() string {
+	switch e.EncryptionType() {
+	case EncryptionGPG:
+		return ".gpg"
+	case EncryptionAge:
+		return ".age"
+	}
+	return ""
+}
+
+func (e EncryptionConfig) validate() error {
+	switch e.EncryptionType() {
+	case EncryptionGPG:
+		if e.PublicKeyFile == "" {
+			return fmt.Errorf("gpg encryption requires a public_key_file")
+		}
+		if len(e.Recipients) > 0 || e.RecipientsFile != "" {
+			return fmt.Errorf("gpg encryption takes its recipients from public_key_file, recipients and recipients_file are for age")
+		}
+	case EncryptionAge:
+		if len(e.Recipients) == 0 && e.RecipientsFile == "" {
+			return fmt.Errorf("age encryption requires recipients or a recipients_file")
+		}
+		if e.PublicKeyFile != "" {
+			return fmt.Errorf("age encryption takes recipients, public_key_file is for gpg")
+		}
+		for _, recipient := range e.Recipients {
+			if _, err := age.ParseX25519Recipient(recipient); err != nil {
+				return fmt.Errorf("age encryption has an invalid recipient '%s', use a public key from age-keygen, which starts with age1", recipient)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported encryption type '%s', use gpg or age", e.Type)
+	}
+	return nil
+}
+
+// JobEncryption returns the encryption of a job's backups, the job's own or
+// storage.encryption, nil when they are not encrypted
+func (s StorageConfig) JobEncryption(job JobConfig) *EncryptionConfig {
+	if job.Encryption != nil {
+		return job.Encryption
+	}
+	return s.Encryption
+}
+
+// TransitionConfig moves a job's uploaded backups to another storage class
+// once they are older than after, through a bucket lifecycle rule
+type TransitionConfig struct {
+	After        time.Duration `yaml:"after"` // Whole days, such as 720h
+	StorageClass string        `yaml:"storage_class"`
 }
 
+// Dump modes of database jobs, which name their backups after the mode
+const (
+	ModeFull   = "full"   // Schema and data
+	ModeSchema = "schema" // Definitions of tables and other objects, without rows
+	ModeData   = "data"   // Rows only, to load into an existing schema
+)
+
 // PostgresConfig contains PostgreSQL specific backup settings
 type PostgresConfig struct {
-	Host     string            `yaml:"host"`
-	Port     string            `yaml:"port,omitempty"`
-	User     string            `yaml:"user,omitempty"`
-	Password string            `yaml:"password,omitempty"`
-	Database string            `yaml:"database"`
-	Options  map[string]string `yaml:"options,omitempty"` // Additional pg_dump options
+	Host             string                  `yaml:"host"`
+	Port             string                  `yaml:"port,omitempty"`
+	User             string                  `yaml:"user,omitempty"`
+	Password         string                  `yaml:"password,omitempty"`
+	Database         string                  `yaml:"database"`
+	Options          map[string]string       `yaml:"options,omitempty"`           // Additional pg_dump options
+	Discover         bool                    `yaml:"discover,omitempty"`          // Back up every database on the server
+	ExcludeDatabases []string                `yaml:"exclude_databases,omitempty"` // Databases skipped by discovery
+	Parallel         *PostgresParallelConfig `yaml:"parallel,omitempty"`          // Dump large tables concurrently
+
+	// Standby nodes, as host or host:port, tried in order before host when
+	// prefer_standby is set. Host is used when none of them is in recovery.
+	StandbyHosts  []string `yaml:"standby_hosts,omitempty"`
+	PreferStandby bool     `yaml:"prefer_standby,omitempty"`
+
+	// Table and schema filters, as pg_dump patterns such as public.orders or
+	// audit.*
+	IncludeTables  []string `yaml:"include_tables,omitempty"`  // Only dump these tables
+	ExcludeTables  []string `yaml:"exclude_tables,omitempty"`  // Dump everything but these tables
+	Schemas        []string `yaml:"schemas,omitempty"`         // Only dump these schemas
+	ExcludeSchemas []string `yaml:"exclude_schemas,omitempty"` // Dump everything but these schemas
+
+	// Large objects are dumped by default, unless tables or schemas are
+	// filtered. Blobs overrides that either way.
+	Blobs *bool `yaml:"blobs,omitempty"`
+
+	// Extension filters, pg_dump patterns like the table filters.
+	// exclude_extensions needs pg_dump 17 or later.
+	Extensions        []string `yaml:"extensions,omitempty"`         // Only dump these extensions
+	ExcludeExtensions []string `yaml:"exclude_extensions,omitempty"` // Dump every extension but these
+
+	// Query returning the application's schema migration version, recorded
+	// in each backup's manifest
+	SchemaVersionQuery string `yaml:"schema_version_query,omitempty"`
+
+	// Checks the tables of each dump against the source after it is written
+	Verify *VerifyConfig `yaml:"verify,omitempty"`
+
+	Mode string `yaml:"mode,omitempty"` // full (default), schema or data
+}
+
+// PostgresParallelConfig splits a plain-format dump into the schema, the
+// remaining data and one COPY stream per large table, dumped concurrently from
+// the same snapshot
+type PostgresParallelConfig struct {
+	Jobs         int    `yaml:"jobs,omitempty"`           // Concurrent pg_dump processes, default 4
+	MinTableSize string `yaml:"min_table_size,omitempty"` // Tables at least this large get their own stream, default 1GB
 }
 
 // MySQLConfig contains MySQL specific backup settings
 type MySQLConfig struct {
-	ConnectionString string `yaml:"connection_string"`
+	ConnectionString string   `yaml:"connection_string"`
+	Discover         bool     `yaml:"discover,omitempty"`          // Back up every non-system database on the server
+	ExcludeDatabases []string `yaml:"exclude_databases,omitempty"` // Databases skipped by discovery
+	Tool             string   `yaml:"tool,omitempty"`              // mysqldump (default) or mydumper
+	Threads          int      `yaml:"threads,omitempty"`           // mydumper worker threads, default 4
+	ChunkSize        string   `yaml:"chunk_size,omitempty"`        // mydumper splits tables into files of this size, e.g. 64MB
+	Databases        []string `yaml:"databases,omitempty"`         // Back up these databases instead of the connection string's
+
+	// Table filters, each either table or database.table. Unqualified
+	// entries apply to every database the job dumps.
+	IncludeTables []string `yaml:"include_tables,omitempty"` // Only dump these tables
+	ExcludeTables []string `yaml:"exclude_tables,omitempty"` // Dump every table but these
+
+	Routines bool   `yaml:"routines,omitempty"` // Dump stored procedures and functions
+	Events   bool   `yaml:"events,omitempty"`   // Dump scheduled events
+	Triggers *bool  `yaml:"triggers,omitempty"` // Unset keeps the tool's default, on for mysqldump and off for mydumper
+	NoData   bool   `yaml:"no_data,omitempty"`  // Dump the schema only, the same as mode schema
+	Mode     string `yaml:"mode,omitempty"`     // full (default), schema or data
+
+	// Binlog coordinates written as comments into mysqldump output and
+	// recorded in the backup's manifest. "source" records the dumped
+	// server's own position, "replica" the position of the server it
+	// replicates from, for dumps taken from a read replica.
+	Coordinates string `yaml:"coordinates,omitempty"`
+	GTIDPurged  string `yaml:"gtid_purged,omitempty"` // mysqldump --set-gtid-purged: on, off or auto
+
+	// Query returning the application's schema migration version, recorded
+	// in each backup's manifest
+	SchemaVersionQuery string `yaml:"schema_version_query,omitempty"`
+
+	// Checks the tables of each dump against the source after it is written
+	Verify *VerifyConfig `yaml:"verify,omitempty"`
+}
+
+// VerifyConfig compares the rows of each table in a dump, counted while
+// it is written, with the source once the dump is done. A table the dump
+// left out, for instance because the job's user cannot read it, fails the
+// run.
+type VerifyConfig struct {
+	RowCounts bool    `yaml:"row_counts,omitempty"` // Compare every dumped table with a count(*) on the source
+	Tolerance float64 `yaml:"tolerance,omitempty"`  // Percent of its rows a table may differ by, for sources that are written to during the dump
+
+	// Queries returning how many rows the dump must hold, by table as the
+	// dump names it (schema.table for PostgreSQL, table for MySQL). They
+	// replace the count(*) of their table, or are the only tables checked
+	// without row_counts.
+	Queries map[string]string `yaml:"queries,omitempty"`
 }
 
 // MinIOConfig contains MinIO specific backup settings
@@ -72,12 +831,122 @@ type MinIOConfig struct {
 	BucketName   string `yaml:"bucket_name"`
 	UseSSL       bool   `yaml:"use_ssl"`
 	SourceFolder string `yaml:"source_folder"`
+	MCConfigDir  string `yaml:"mc_config_dir,omitempty"` // Configuration directory of mc, kept between runs; a temporary one per run when empty
+}
+
+// KafkaConfig contains Kafka cluster metadata backup settings
+type KafkaConfig struct {
+	BootstrapServers string   `yaml:"bootstrap_servers"`
+	CommandConfig    string   `yaml:"command_config,omitempty"` // Client properties file for SASL/TLS
+	ToolsPath        string   `yaml:"tools_path,omitempty"`     // Directory containing kafka-*.sh scripts
+	Topics           []string `yaml:"topics,omitempty"`         // Limit export to these topics, all topics if empty
+	IncludeACLs      bool     `yaml:"include_acls"`
+}
+
+// ConsulConfig contains Consul snapshot and KV backup settings
+type ConsulConfig struct {
+	Address       string `yaml:"address"`
+	Token         string `yaml:"token,omitempty"`
+	Datacenter    string `yaml:"datacenter,omitempty"`
+	CACert        string `yaml:"ca_cert,omitempty"`
+	ClientCert    string `yaml:"client_cert,omitempty"`
+	ClientKey     string `yaml:"client_key,omitempty"`
+	TLSServerName string `yaml:"tls_server_name,omitempty"`
+	ExportKV      bool   `yaml:"export_kv"`
+	KVPrefix      string `yaml:"kv_prefix,omitempty"` // Limit the KV export to this prefix
+}
+
+// KeycloakConfig contains Keycloak realm export settings
+type KeycloakConfig struct {
+	URL          string   `yaml:"url"`
+	AuthRealm    string   `yaml:"auth_realm,omitempty"` // Realm used to obtain the admin token, defaults to master
+	ClientID     string   `yaml:"client_id,omitempty"`  // Defaults to admin-cli
+	ClientSecret string   `yaml:"client_secret,omitempty"`
+	Username     string   `yaml:"username,omitempty"`
+	Password     string   `yaml:"password,omitempty"`
+	Realms       []string `yaml:"realms,omitempty"` // Export all realms if empty
+	IncludeUsers bool     `yaml:"include_users"`
+}
+
+// RESTConfig contains settings for appliances that produce a snapshot through
+// an HTTP API. URL templates may contain an {id} placeholder which is replaced
+// with the value read from IDField in the trigger response.
+type RESTConfig struct {
+	URL              string            `yaml:"url"`
+	Method           string            `yaml:"method,omitempty"` // Defaults to POST
+	Headers          map[string]string `yaml:"headers,omitempty"`
+	AuthToken        string            `yaml:"auth_token,omitempty"` // Sent as a Bearer token
+	Body             string            `yaml:"body,omitempty"`
+	IDField          string            `yaml:"id_field,omitempty"`
+	StatusURL        string            `yaml:"status_url,omitempty"`
+	StatusField      string            `yaml:"status_field,omitempty"`
+	DoneValue        string            `yaml:"done_value,omitempty"`
+	FailedValue      string            `yaml:"failed_value,omitempty"`
+	PollInterval     time.Duration     `yaml:"poll_interval,omitempty"`
+	PollTimeout      time.Duration     `yaml:"poll_timeout,omitempty"`
+	DownloadURL      string            `yaml:"download_url,omitempty"`
+	DownloadURLField string            `yaml:"download_url_field,omitempty"`
+	Extension        string            `yaml:"extension,omitempty"` // Defaults to .tar
+}
+
+// GrafanaConfig contains Grafana export settings
+type GrafanaConfig struct {
+	URL      string `yaml:"url"`
+	APIToken string `yaml:"api_token,omitempty"` // Service account token, preferred over basic auth
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	OrgID    int    `yaml:"org_id,omitempty"`
+}
+
+// SnapshotConfig contains ZFS/Btrfs snapshot backup settings
+type SnapshotConfig struct {
+	Filesystem  string `yaml:"filesystem"`             // "zfs" or "btrfs"
+	Source      string `yaml:"source"`                 // ZFS dataset or Btrfs subvolume path
+	SnapshotDir string `yaml:"snapshot_dir,omitempty"` // Btrfs only, where read-only snapshots are kept
+	Incremental bool   `yaml:"incremental"`            // Send relative to the previous snapshot
+	FullEvery   int    `yaml:"full_every,omitempty"`   // Start a new chain with a full stream after this many increments, 0 never
+}
+
+// FilesConfig contains settings for archiving files and directories
+type FilesConfig struct {
+	Paths       []string           `yaml:"paths"`
+	Exclude     []string           `yaml:"exclude,omitempty"` // Glob patterns matched against names and full paths
+	LVMSnapshot *LVMSnapshotConfig `yaml:"lvm_snapshot,omitempty"`
+	VSS         bool               `yaml:"vss"` // Read from Volume Shadow Copies (Windows only)
+}
+
+// LVMSnapshotConfig describes the logical volume to snapshot before archiving.
+// All paths of the job must live below MountPoint, the mount point of the
+// origin volume.
+type LVMSnapshotConfig struct {
+	VolumeGroup   string `yaml:"volume_group"`
+	LogicalVolume string `yaml:"logical_volume"`
+	Size          string `yaml:"size"` // Copy-on-write space reserved for the snapshot, e.g. 5G
+	MountPoint    string `yaml:"mount_point"`
+	MountOptions  string `yaml:"mount_options,omitempty"` // Extra mount options, e.g. nouuid for XFS
+}
+
+// SQLiteConfig contains SQLite specific backup settings
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
 }
 
 // RetentionPolicy defines how long backups are kept
 type RetentionPolicy struct {
-	Type  string `yaml:"type"` // "count" or "days"
-	Value int    `yaml:"value"`
+	Type         string        `yaml:"type"` // "count" or "days"
+	Value        int           `yaml:"value"`
+	GracePeriod  time.Duration `yaml:"grace_period,omitempty"`  // Keep expired backups in .trash for this long before deleting them
+	MaxDeletions int           `yaml:"max_deletions,omitempty"` // Limit how many backups one run may remove, 0 means unlimited
+	Class        string        `yaml:"class,omitempty"`         // Retention tag of uploaded objects, defaults to type-value such as days-30
+}
+
+// ClassName returns the retention class of the policy's backups, empty
+// without a policy
+func (p RetentionPolicy) ClassName() string {
+	if p.Class != "" || p.Type == "" {
+		return p.Class
+	}
+	return fmt.Sprintf("%s-%d", p.Type, p.Value)
 }
 
 // Notification defines notification settings for backup jobs
@@ -85,12 +954,51 @@ type Notification struct {
 	Enabled bool             `yaml:"enabled"`
 	Discord *DiscordSettings `yaml:"discord,omitempty"`
 	Webhook *WebhookSettings `yaml:"webhook,omitempty"`
+	Matrix  *MatrixSettings  `yaml:"matrix,omitempty"`
+	Signal  *SignalSettings  `yaml:"signal,omitempty"`
+	Syslog  *SyslogSettings  `yaml:"syslog,omitempty"`
+	SNMP    *SNMPSettings    `yaml:"snmp,omitempty"`
+	SNS     *SNSSettings     `yaml:"sns,omitempty"`
+	PubSub  *PubSubSettings  `yaml:"pubsub,omitempty"`
+	MQTT    *MQTTSettings    `yaml:"mqtt,omitempty"`
+	NSCA    *NSCASettings    `yaml:"nsca,omitempty"`
+	Icinga  *IcingaSettings  `yaml:"icinga,omitempty"`
+	Zabbix  *ZabbixSettings  `yaml:"zabbix,omitempty"`
+
+	// Identical failures in a row are sent once per window, with the number
+	// of occurrences, instead of once per run
+	DedupWindow time.Duration `yaml:"dedup_window,omitempty"`
 }
 
 // DiscordSettings contains Discord notification configuration
 type DiscordSettings struct {
 	When       []string `yaml:"when"`
 	WebhookURL string   `yaml:"webhook_url"`
+
+	// Discord user and role IDs mentioned in failure notifications
+	MentionUsers []string `yaml:"mention_users,omitempty"`
+	MentionRoles []string `yaml:"mention_roles,omitempty"`
+
+	// Failures in a row before anyone is mentioned, 1 when unset
+	MentionAfter int `yaml:"mention_after,omitempty"`
+}
+
+// metricLabelName matches the label names Prometheus accepts
+var metricLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// discordID matches the numeric IDs of Discord users and roles
+var discordID = regexp.MustCompile(`^[0-9]{1,20}$`)
+
+func (d *DiscordSettings) validate() error {
+	if d.MentionAfter < 0 {
+		return fmt.Errorf("mention_after must not be negative")
+	}
+	for _, id := range slices.Concat(d.MentionUsers, d.MentionRoles) {
+		if !discordID.MatchString(id) {
+			return fmt.Errorf("invalid mention '%s', expected a numeric user or role ID", id)
+		}
+	}
+	return nil
 }
 
 // WebhookSettings contains external webhook notification configuration
@@ -101,44 +1009,456 @@ type WebhookSettings struct {
 	ContentType string            `yaml:"content_type,omitempty"`
 }
 
-// LoadConfig loads configuration from the specified YAML file
-func LoadConfig(path string) (*Config, error) {
-	// Expand home directory if path starts with ~
-	if strings.HasPrefix(path, "~") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("failed to expand home directory: %w", err)
-		}
-		path = filepath.Join(home, path[1:])
-	}
+// MatrixSettings contains Matrix room notification configuration
+type MatrixSettings struct {
+	When        []string `yaml:"when"`
+	Homeserver  string   `yaml:"homeserver"` // Such as https://matrix.example.org
+	AccessToken string   `yaml:"access_token"`
+	RoomID      string   `yaml:"room_id"` // Such as !abcdef:example.org
+}
 
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+func (m *MatrixSettings) validate() error {
+	if m.Homeserver == "" || m.AccessToken == "" || m.RoomID == "" {
+		return fmt.Errorf("homeserver, access_token and room_id are required")
 	}
-
-	// Process environment variables in the raw YAML content before unmarshaling
-	processedData, unresolvedVars, err := replaceEnvVarsInYAML(string(data))
-	if err != nil {
-		return nil, err
+	if !strings.HasPrefix(m.RoomID, "!") {
+		return fmt.Errorf("room_id '%s' must be a room ID starting with !, not an alias", m.RoomID)
 	}
+	return nil
+}
 
-	// Report unresolved environment variables
-	if len(unresolvedVars) > 0 {
-		return nil, fmt.Errorf("missing required environment variables: %s", strings.Join(unresolvedVars, ", "))
-	}
+// SignalSettings contains Signal notification configuration, sent through a
+// signal-cli REST API
+type SignalSettings struct {
+	When       []string `yaml:"when"`
+	URL        string   `yaml:"url"`    // Such as http://signal-cli:8080
+	Number     string   `yaml:"number"` // The registered account that sends
+	Recipients []string `yaml:"recipients"`
+}
 
-	var config Config
-	if err := yaml.Unmarshal([]byte(processedData), &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+func (s *SignalSettings) validate() error {
+	if s.URL == "" || s.Number == "" || len(s.Recipients) == 0 {
+		return fmt.Errorf("url, number and recipients are required")
 	}
+	return nil
+}
 
-	return &config, nil
+// SyslogSettings contains syslog notification configuration. Messages
+// follow RFC 5424.
+type SyslogSettings struct {
+	When     []string `yaml:"when"`
+	Address  string   `yaml:"address"`            // host:port of the syslog server
+	Network  string   `yaml:"network,omitempty"`  // udp (default) or tcp
+	Facility string   `yaml:"facility,omitempty"` // daemon by default
 }
 
-// replaceEnvVarsInYAML replaces environment variable placeholders in the raw YAML content
-// Returns the processed YAML content and a list of any unresolved environment variables
-func replaceEnvVarsInYAML(yamlContent string) (string, []string, error) {
+// syslogFacilities are the facility codes of RFC 5424 by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogFacility returns the code of a syslog facility, daemon when unset
+func SyslogFacility(name string) (int, bool) {
+	if name == "" {
+		name = "daemon"
+	}
+	code, ok := syslogFacilities[name]
+	return code, ok
+}
+
+func (s *SyslogSettings) validate() error {
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return fmt.Errorf("address '%s' must be host:port", s.Address)
+	}
+	if s.Network != "" && s.Network != "udp" && s.Network != "tcp" {
+		return fmt.Errorf("invalid network '%s', expected udp or tcp", s.Network)
+	}
+	if _, ok := SyslogFacility(s.Facility); !ok {
+		return fmt.Errorf("unknown facility '%s'", s.Facility)
+	}
+	return nil
+}
+
+// SNMPSettings contains SNMPv2c trap notification configuration
+type SNMPSettings struct {
+	When          []string `yaml:"when"`
+	Address       string   `yaml:"address"`                  // host:port of the trap receiver, usually port 162
+	Community     string   `yaml:"community,omitempty"`      // public by default
+	EnterpriseOID string   `yaml:"enterprise_oid,omitempty"` // Roots the trap and object OIDs
+}
+
+// numericOID matches dotted OIDs such as 1.3.6.1.4.1
+var numericOID = regexp.MustCompile(`^[0-2](\.[0-9]+)+$`)
+
+func (s *SNMPSettings) validate() error {
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return fmt.Errorf("address '%s' must be host:port", s.Address)
+	}
+	if s.EnterpriseOID != "" && !numericOID.MatchString(s.EnterpriseOID) {
+		return fmt.Errorf("invalid enterprise_oid '%s', expected a dotted numeric OID", s.EnterpriseOID)
+	}
+	return nil
+}
+
+// SNSSettings contains AWS SNS topic notification configuration
+type SNSSettings struct {
+	When     []string `yaml:"when"`
+	TopicARN string   `yaml:"topic_arn"`
+	Region   string   `yaml:"region,omitempty"`   // The topic's region when unset
+	Endpoint string   `yaml:"endpoint,omitempty"` // For SNS compatible services such as LocalStack
+
+	// Taken from the environment, the shared credentials file or the
+	// instance role when unset
+	AccessKey string `yaml:"access_key,omitempty"`
+	SecretKey string `yaml:"secret_key,omitempty"`
+}
+
+// ARNRegion returns the region of an ARN such as
+// arn:aws:sns:eu-west-1:123456789012:backups
+func ARNRegion(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[3]
+}
+
+func (s *SNSSettings) validate() error {
+	if parts := strings.Split(s.TopicARN, ":"); len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[5] == "" {
+		return fmt.Errorf("invalid topic_arn '%s', expected arn:aws:sns:<region>:<account>:<topic>", s.TopicARN)
+	}
+	if s.Region == "" && ARNRegion(s.TopicARN) == "" {
+		return fmt.Errorf("topic_arn '%s' has no region, set region", s.TopicARN)
+	}
+	if (s.AccessKey == "") != (s.SecretKey == "") {
+		return fmt.Errorf("access_key and secret_key must be set together")
+	}
+	return nil
+}
+
+// PubSubSettings contains Google Cloud Pub/Sub topic notification configuration
+type PubSubSettings struct {
+	When     []string `yaml:"when"`
+	Topic    string   `yaml:"topic"`              // projects/<project>/topics/<topic>
+	Endpoint string   `yaml:"endpoint,omitempty"` // https://pubsub.googleapis.com by default
+
+	// A service account key file, the metadata server's account when unset
+	CredentialsFile string `yaml:"credentials_file,omitempty"`
+}
+
+// pubSubTopic matches the full names of Pub/Sub topics
+var pubSubTopic = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+func (p *PubSubSettings) validate() error {
+	if !pubSubTopic.MatchString(p.Topic) {
+		return fmt.Errorf("invalid topic '%s', expected projects/<project>/topics/<topic>", p.Topic)
+	}
+	return nil
+}
+
+// MQTTSettings contains MQTT broker notification configuration
+type MQTTSettings struct {
+	When     []string `yaml:"when"`            // Also takes started, for runs that begin
+	Broker   string   `yaml:"broker"`          // mqtt://host:1883, or mqtts://host:8883 for TLS
+	Topic    string   `yaml:"topic,omitempty"` // Template with {job}, {type} and {status}, backmeup/{job}/event by default
+	QoS      int      `yaml:"qos,omitempty"`
+	Retain   bool     `yaml:"retain,omitempty"`
+	ClientID string   `yaml:"client_id,omitempty"` // backmeup-<hostname> by default
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+}
+
+func (m *MQTTSettings) validate() error {
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid broker '%s', expected mqtt://host:port or mqtts://host:port", m.Broker)
+	}
+	switch u.Scheme {
+	case "mqtt", "tcp", "mqtts", "ssl":
+	default:
+		return fmt.Errorf("invalid broker '%s', expected mqtt://host:port or mqtts://host:port", m.Broker)
+	}
+	if m.QoS < 0 || m.QoS > 2 {
+		return fmt.Errorf("qos must be 0, 1 or 2")
+	}
+	if strings.ContainsAny(m.Topic, "+#") {
+		return fmt.Errorf("topic '%s' cannot contain the wildcards + or #", m.Topic)
+	}
+	if m.Password != "" && m.Username == "" {
+		return fmt.Errorf("password requires a username")
+	}
+	return nil
+}
+
+// NSCASettings contains the NSCA daemon that passive check results of every
+// run are submitted to
+type NSCASettings struct {
+	Address    string `yaml:"address"`              // host:port, usually port 5667
+	Host       string `yaml:"host"`                 // The Nagios host the service belongs to
+	Service    string `yaml:"service,omitempty"`    // Template with {job}, "backmeup {job}" by default
+	Password   string `yaml:"password,omitempty"`   // The daemon's password setting
+	Encryption string `yaml:"encryption,omitempty"` // xor (default) or none, matching decryption_method 1 or 0
+}
+
+func (n *NSCASettings) validate() error {
+	if _, _, err := net.SplitHostPort(n.Address); err != nil {
+		return fmt.Errorf("address '%s' must be host:port", n.Address)
+	}
+	if n.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if n.Encryption != "" && n.Encryption != "xor" && n.Encryption != "none" {
+		return fmt.Errorf("unsupported encryption '%s', expected xor or none", n.Encryption)
+	}
+	return nil
+}
+
+// IcingaSettings contains the Icinga 2 API that passive check results of
+// every run are submitted to
+type IcingaSettings struct {
+	URL      string `yaml:"url"`      // Such as https://icinga.example.com:5665
+	Username string `yaml:"username"` // An API user allowed actions/process-check-result
+	Password string `yaml:"password"`
+	Host     string `yaml:"host"`              // The Icinga host the service belongs to
+	Service  string `yaml:"service,omitempty"` // Template with {job}, "backmeup {job}" by default
+	CACert   string `yaml:"ca_cert,omitempty"` // The Icinga CA, when the system does not trust it
+}
+
+func (i *IcingaSettings) validate() error {
+	if u, err := url.Parse(i.URL); err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url '%s'", i.URL)
+	}
+	if i.Host == "" || i.Username == "" {
+		return fmt.Errorf("host and username are required")
+	}
+	return nil
+}
+
+// ZabbixSettings contains the Zabbix server or proxy that the status,
+// duration and size of every run are sent to as trapper items
+type ZabbixSettings struct {
+	Server    string `yaml:"server"`               // host:port, usually port 10051
+	Host      string `yaml:"host"`                 // The host name the items are defined on in Zabbix
+	KeyPrefix string `yaml:"key_prefix,omitempty"` // backmeup by default, for keys such as backmeup.status[<job>]
+}
+
+// zabbixKey matches the characters Zabbix allows in item keys
+var zabbixKey = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (z *ZabbixSettings) validate() error {
+	if _, _, err := net.SplitHostPort(z.Server); err != nil {
+		return fmt.Errorf("server '%s' must be host:port", z.Server)
+	}
+	if z.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if z.KeyPrefix != "" && !zabbixKey.MatchString(z.KeyPrefix) {
+		return fmt.Errorf("invalid key_prefix '%s', keys take letters, digits and _ . -", z.KeyPrefix)
+	}
+	return nil
+}
+
+// LoadConfig loads configuration from the specified YAML file
+func LoadConfig(path string) (*Config, error) {
+	// Expand home directory if path starts with ~
+	if strings.HasPrefix(path, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand home directory: %w", err)
+		}
+		path = filepath.Join(home, path[1:])
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Process environment variables in the raw YAML content before unmarshaling
+	processedData, unresolvedVars, err := replaceEnvVarsInYAML(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Report unresolved environment variables
+	if len(unresolvedVars) > 0 {
+		return nil, fmt.Errorf("missing required environment variables: %s", strings.Join(unresolvedVars, ", "))
+	}
+
+	var config Config
+	if err := yaml.Unmarshal([]byte(processedData), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if err := config.expandTemplates(); err != nil {
+		return nil, err
+	}
+	config.source = data
+
+	return &config, nil
+}
+
+// templatePlaceholder matches {{name}}
+var templatePlaceholder = regexp.MustCompile(`{{\s*([A-Za-z0-9_]+)\s*}}`)
+
+// expandTemplates replaces every job that references a template with one job
+// per parameter set
+func (c *Config) expandTemplates() error {
+	templates := make(map[string]RawTemplate, len(c.JobTemplates))
+	for _, t := range c.JobTemplates {
+		if t.Name == "" {
+			return fmt.Errorf("job template has no name")
+		}
+		if _, exists := templates[t.Name]; exists {
+			return fmt.Errorf("duplicate job template '%s'", t.Name)
+		}
+		templates[t.Name] = t.Job
+	}
+
+	jobs := make([]JobConfig, 0, len(c.Jobs))
+	for _, job := range c.Jobs {
+		if job.FromTemplate == "" {
+			jobs = append(jobs, job)
+			continue
+		}
+
+		template, ok := templates[job.FromTemplate]
+		if !ok {
+			return fmt.Errorf("job references unknown template '%s'", job.FromTemplate)
+		}
+		// The template is the whole job, anything else would be lost
+		if fields := templateJobFields(job); len(fields) > 0 {
+			return fmt.Errorf("job from template '%s' also sets %s, set them in the template, with placeholders where they differ",
+				job.FromTemplate, strings.Join(fields, ", "))
+		}
+
+		parameterSets := job.Parameters
+		if len(parameterSets) == 0 {
+			parameterSets = []map[string]string{{}}
+		}
+
+		for i, params := range parameterSets {
+			expanded, err := expandTemplate(template, params)
+			if err != nil {
+				return fmt.Errorf("template '%s' parameter set #%d: %w", job.FromTemplate, i+1, err)
+			}
+			jobs = append(jobs, expanded)
+		}
+	}
+
+	c.Jobs = jobs
+	return nil
+}
+
+// templateJobFields returns the keys a job referencing a template sets besides
+// from_template and parameters
+func templateJobFields(job JobConfig) []string {
+	var fields []string
+	value := reflect.ValueOf(job)
+	for i := range value.NumField() {
+		name, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("yaml"), ",")
+		switch name {
+		case "from_template", "parameters":
+			continue
+		case "-":
+			// The schedule is decoded into Schedule or Schedules
+			if name = "schedule"; slices.Contains(fields, name) {
+				continue
+			}
+		}
+		if !value.Field(i).IsZero() {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// expandTemplate fills the placeholders of a template with the parameters.
+// The template is parsed first and the placeholders are filled in its string
+// values, so that parameters never need escaping. A placeholder that makes up
+// a whole value is replaced by a number or boolean when the parameter is one.
+func expandTemplate(template RawTemplate, params map[string]string) (JobConfig, error) {
+	var tree any
+	if err := yaml.Unmarshal(template, &tree); err != nil {
+		return JobConfig{}, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var missing []string
+	tree = fillPlaceholders(tree, params, &missing)
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return JobConfig{}, fmt.Errorf("missing parameters: %s", strings.Join(missing, ", "))
+	}
+
+	source, err := yaml.Marshal(tree)
+	if err != nil {
+		return JobConfig{}, fmt.Errorf("failed to build expanded job: %w", err)
+	}
+	var job JobConfig
+	if err := yaml.Unmarshal(source, &job); err != nil {
+		return JobConfig{}, fmt.Errorf("failed to parse expanded job: %w", err)
+	}
+	if job.FromTemplate != "" {
+		return JobConfig{}, fmt.Errorf("templates cannot reference other templates")
+	}
+
+	return job, nil
+}
+
+// fillPlaceholders replaces the placeholders in the string values of a parsed
+// template, recording the parameters it lacks
+func fillPlaceholders(node any, params map[string]string, missing *[]string) any {
+	lookup := func(name string) (string, bool) {
+		value, ok := params[name]
+		if !ok && !slices.Contains(*missing, name) {
+			*missing = append(*missing, name)
+		}
+		return value, ok
+	}
+
+	switch v := node.(type) {
+	case string:
+		if match := templatePlaceholder.FindStringSubmatch(v); match != nil && match[0] == v {
+			value, ok := lookup(match[1])
+			if !ok {
+				return v
+			}
+			// Whole value: numbers and booleans decode into typed fields
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return n
+			}
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				return f
+			}
+			if b, err := strconv.ParseBool(value); err == nil {
+				return b
+			}
+			return value
+		}
+		return templatePlaceholder.ReplaceAllStringFunc(v, func(match string) string {
+			value, ok := lookup(templatePlaceholder.FindStringSubmatch(match)[1])
+			if !ok {
+				return match
+			}
+			return value
+		})
+	case map[string]any:
+		for key, child := range v {
+			v[key] = fillPlaceholders(child, params, missing)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = fillPlaceholders(child, params, missing)
+		}
+	}
+	return node
+}
+
+// replaceEnvVarsInYAML replaces environment variable placeholders in the raw YAML content
+// Returns the processed YAML content and a list of any unresolved environment variables
+func replaceEnvVarsInYAML(yamlContent string) (string, []string, error) {
 	// Regex to match string values potentially containing ${ENV_VAR} patterns
 	// This looks for strings that might contain environment variables
 	re := regexp.MustCompile(`:\s*"([^"]*\${[A-Za-z0-9_]+}[^"]*)"`)
@@ -196,6 +1516,142 @@ func replaceEnvVars(value string) (string, []string) {
 	return result, unresolvedVars
 }
 
+// validateBackend checks the settings of the storage type
+func (s StorageConfig) validateBackend() error {
+	switch s.Type {
+	case "local":
+		if s.Local.Directory == "" {
+			return fmt.Errorf("local storage directory must be specified")
+		}
+	case "s3":
+		if s.Local.Directory == "" {
+			return fmt.Errorf("local storage directory must be specified as the staging area for s3 storage")
+		}
+		if s.S3 == nil || s.S3.Endpoint == "" || s.S3.Bucket == "" {
+			return fmt.Errorf("s3 storage must have an endpoint and bucket")
+		}
+		if window := s.S3.UploadWindow; window != "" {
+			if _, _, err := ParseTimeWindow(window); err != nil {
+				return fmt.Errorf("invalid s3 storage upload_window '%s': %w", window, err)
+			}
+		}
+		if class := s.S3.StorageClass; class != "" && !storageClassName.MatchString(class) {
+			return fmt.Errorf("invalid s3 storage storage_class: %s", class)
+		}
+		if tags := s.S3.ObjectTags; tags != nil {
+			if err := tags.validate(); err != nil {
+				return fmt.Errorf("invalid s3 storage object_tags: %w", err)
+			}
+		}
+		if err := validateCache("s3", s.S3.Cache, s.S3.KeepLocal); err != nil {
+			return err
+		}
+	case "b2":
+		if s.Local.Directory == "" {
+			return fmt.Errorf("local storage directory must be specified as the staging area for b2 storage")
+		}
+		b2 := s.B2
+		if b2 == nil || b2.Bucket == "" || (b2.Region == "" && b2.Endpoint == "") {
+			return fmt.Errorf("b2 storage must have a bucket and region")
+		}
+		if b2.KeyID == "" || b2.ApplicationKey == "" {
+			return fmt.Errorf("b2 storage must have an application key_id and application_key")
+		}
+		if window := b2.UploadWindow; window != "" {
+			if _, _, err := ParseTimeWindow(window); err != nil {
+				return fmt.Errorf("invalid b2 storage upload_window '%s': %w", window, err)
+			}
+		}
+		if err := validateCache("b2", b2.Cache, b2.KeepLocal); err != nil {
+			return err
+		}
+	case "webdav":
+		if s.Local.Directory == "" {
+			return fmt.Errorf("local storage directory must be specified as the staging area for webdav storage")
+		}
+		dav := s.WebDAV
+		if dav == nil || dav.URL == "" {
+			return fmt.Errorf("webdav storage must have a url")
+		}
+		for _, raw := range []string{dav.URL, dav.ChunkURL} {
+			if u, err := url.Parse(raw); raw != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+				return fmt.Errorf("invalid webdav storage url: %s", raw)
+			}
+		}
+		if dav.ChunkSize != "" {
+			size, err := ParseSize(dav.ChunkSize)
+			if err != nil || size <= 0 {
+				return fmt.Errorf("invalid webdav storage chunk_size: %s", dav.ChunkSize)
+			}
+			if size < minWebDAVChunkSize {
+				return fmt.Errorf("webdav storage chunk_size must be at least 5MB, Nextcloud refuses smaller chunks")
+			}
+		}
+		if window := dav.UploadWindow; window != "" {
+			if _, _, err := ParseTimeWindow(window); err != nil {
+				return fmt.Errorf("invalid webdav storage upload_window '%s': %w", window, err)
+			}
+		}
+		if err := validateCache("webdav", dav.Cache, dav.KeepLocal); err != nil {
+			return err
+		}
+	case "rclone":
+		if s.Local.Directory == "" {
+			return fmt.Errorf("local storage directory must be specified as the staging area for rclone storage")
+		}
+		rc := s.Rclone
+		if rc == nil || rc.Remote == "" {
+			return fmt.Errorf("rclone storage must have a remote")
+		}
+		if !strings.Contains(rc.Remote, ":") {
+			return fmt.Errorf("invalid rclone storage remote '%s', use remote:path", rc.Remote)
+		}
+		for _, flag := range rc.Flags {
+			if !strings.HasPrefix(flag, "-") {
+				return fmt.Errorf("invalid rclone storage flag '%s', write flags with their value as --flag=value", flag)
+			}
+		}
+		if window := rc.UploadWindow; window != "" {
+			if _, _, err := ParseTimeWindow(window); err != nil {
+				return fmt.Errorf("invalid rclone storage upload_window '%s': %w", window, err)
+			}
+		}
+		if err := validateCache("rclone", rc.Cache, rc.KeepLocal); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported storage type: %s", s.Type)
+	}
+	return nil
+}
+
+// validateDestination checks a storage destination, which takes the settings
+// of its storage type except those of the staging area
+func (s StorageConfig) validateDestination(name string) error {
+	d := s.Destinations[name]
+	if d.Type == "local" {
+		return fmt.Errorf("storage destination %s must be remote storage, backups are already staged in the local directory", name)
+	}
+	if err := d.Storage(s.Local).validateBackend(); err != nil {
+		return fmt.Errorf("storage destination %s: %w", name, err)
+	}
+	var staging []bool
+	switch {
+	case d.S3 != nil && d.Type == "s3":
+		staging = []bool{d.S3.KeepLocal, d.S3.Cache != nil, d.S3.UploadWindow != ""}
+	case d.B2 != nil && d.Type == "b2":
+		staging = []bool{d.B2.KeepLocal, d.B2.Cache != nil, d.B2.UploadWindow != ""}
+	case d.WebDAV != nil && d.Type == "webdav":
+		staging = []bool{d.WebDAV.KeepLocal, d.WebDAV.Cache != nil, d.WebDAV.UploadWindow != ""}
+	case d.Rclone != nil && d.Type == "rclone":
+		staging = []bool{d.Rclone.KeepLocal, d.Rclone.Cache != nil, d.Rclone.UploadWindow != ""}
+	}
+	if slices.Contains(staging, true) {
+		return fmt.Errorf("storage destination %s: keep_local, cache and upload_window only apply to the primary storage", name)
+	}
+	return nil
+}
+
 // MarkEnvVarOptional helps to document that a specific environment variable is optional in the configuration
 // This is just a helper function to make code more expressive
 func MarkEnvVarOptional(varName string) string {
@@ -208,25 +1664,145 @@ func (c *Config) Validate() error {
 	if c.Server.Enabled && (c.Server.Port <= 0 || c.Server.Port > 65535) {
 		return fmt.Errorf("server port must be between 1 and 65535")
 	}
+	if c.Server.Debug.Enabled && c.Server.Debug.Token == "" {
+		return fmt.Errorf("server debug endpoints require a token")
+	}
 
 	// Check storage configuration
-	if c.Storage.Type == "local" {
-		if c.Storage.Local.Directory == "" {
-			return fmt.Errorf("local storage directory must be specified")
+	if err := c.Storage.validateBackend(); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Storage.Destinations)) {
+		if err := c.Storage.validateDestination(name); err != nil {
+			return err
+		}
+	}
+
+	if dir := c.Storage.Local.Directory; dir != "" && isSystemDirectory(dir) {
+		return fmt.Errorf("local storage directory %s is a system directory, use a directory of its own such as /var/lib/backmeup so that retention only ever sees backups", dir)
+	}
+
+	if err := c.Storage.Local.validateMaxSize(); err != nil {
+		return err
+	}
+
+	if c.Storage.Encryption != nil {
+		if err := c.Storage.Encryption.validate(); err != nil {
+			return fmt.Errorf("storage %w", err)
+		}
+	}
+	if c.Storage.SplitSize != "" {
+		if size, err := ParseSize(c.Storage.SplitSize); err != nil || size <= 0 {
+			return fmt.Errorf("invalid storage split_size: %s", c.Storage.SplitSize)
+		}
+	}
+
+	// Check storage quota
+	if c.Storage.Quota.MaxSize != "" {
+		if size, err := ParseSize(c.Storage.Quota.MaxSize); err != nil || size <= 0 {
+			return fmt.Errorf("invalid storage quota max_size: %s", c.Storage.Quota.MaxSize)
+		}
+	}
+	if c.Storage.Quota.MinKeep < 0 {
+		return fmt.Errorf("storage quota min_keep must not be negative")
+	}
+
+	// Check scheduler configuration
+	if c.Scheduler.MaxConcurrentJobs < 0 {
+		return fmt.Errorf("scheduler max_concurrent_jobs must not be negative")
+	}
+	for group, limit := range c.Scheduler.ConcurrencyGroups {
+		if limit <= 0 {
+			return fmt.Errorf("concurrency group '%s' must have a positive limit", group)
+		}
+	}
+	for group, budget := range c.Scheduler.GroupBudgets {
+		if _, _, err := ParseTimeWindow(budget.Window); err != nil {
+			return fmt.Errorf("invalid window '%s' of the budget of concurrency group '%s': %w", budget.Window, group, err)
+		}
+		if budget.Budget <= 0 {
+			return fmt.Errorf("budget of concurrency group '%s' must be positive", group)
+		}
+	}
+	if c.Scheduler.Cleanup.Workers < 0 || c.Scheduler.Cleanup.Timeout < 0 {
+		return fmt.Errorf("scheduler cleanup workers and timeout must not be negative")
+	}
+	for _, entry := range c.Scheduler.Exclusions.Dates {
+		if _, _, err := ParseDateRange(entry); err != nil {
+			return fmt.Errorf("invalid exclusion date '%s': %w", entry, err)
+		}
+	}
+
+	// Check coordination configuration
+	if c.Coordination.Enabled {
+		switch c.Coordination.Backend {
+		case "postgres":
+			if c.Coordination.Postgres == nil || c.Coordination.Postgres.Host == "" || c.Coordination.Postgres.Database == "" {
+				return fmt.Errorf("postgres coordination must have a host and database")
+			}
+		case "redis":
+			if c.Coordination.Redis == nil || c.Coordination.Redis.Host == "" {
+				return fmt.Errorf("redis coordination must have a host")
+			}
+		default:
+			return fmt.Errorf("unsupported coordination backend: %s", c.Coordination.Backend)
+		}
+		if c.Coordination.HeartbeatInterval < 0 || c.Coordination.MemberTTL < 0 {
+			return fmt.Errorf("coordination intervals must not be negative")
+		}
+		if c.Coordination.MemberTTL > 0 && c.Coordination.HeartbeatInterval > 0 &&
+			c.Coordination.MemberTTL <= c.Coordination.HeartbeatInterval {
+			return fmt.Errorf("coordination member_ttl must be longer than heartbeat_interval")
+		}
+	}
+
+	if c.Versions.Keep < 0 {
+		return fmt.Errorf("config_versions keep must not be negative")
+	}
+	if c.History.KeepDays < 0 {
+		return fmt.Errorf("history keep_days must not be negative")
+	}
+	if c.Logging.MaxSize != "" {
+		if size, err := ParseSize(c.Logging.MaxSize); err != nil || size <= 0 {
+			return fmt.Errorf("invalid logging max_size: %s", c.Logging.MaxSize)
+		}
+	}
+	if c.Logging.Keep < 0 || c.Logging.KeepDays < 0 {
+		return fmt.Errorf("logging keep and keep_days must not be negative")
+	}
+
+	// Check report configuration
+	if c.Report.Enabled {
+		switch c.Report.Format {
+		case "", "html", "markdown":
+		default:
+			return fmt.Errorf("unsupported report format: %s", c.Report.Format)
+		}
+		if c.Report.Schedule != "" {
+			if _, err := cron.ParseStandard(c.Report.Schedule); err != nil {
+				return fmt.Errorf("invalid report schedule: %w", err)
+			}
 		}
-	} else {
-		return fmt.Errorf("unsupported storage type: %s", c.Storage.Type)
 	}
 
-	// Check jobs configuration
-	if len(c.Jobs) == 0 {
+	// Check jobs configuration, discovered jobs are validated when they appear
+	if len(c.Jobs) == 0 && !c.Discovery.Docker.Enabled && !c.Discovery.Kubernetes.Enabled {
 		return fmt.Errorf("at least one job must be configured")
 	}
 
+	// Jobs are scheduled, stored and reported by name
+	positions := make(map[string]int, len(c.Jobs))
 	for i, job := range c.Jobs {
 		if job.Name == "" {
 			return fmt.Errorf("job #%d has no name", i+1)
 		}
+		if !validJobName(job.Name) {
+			return fmt.Errorf("job name '%s' must not be . or .. or contain a slash, it names the job's backup directory", job.Name)
+		}
+		if first, ok := positions[job.Name]; ok {
+			return fmt.Errorf("jobs #%d and #%d are both named '%s', job names must be unique", first, i+1, job.Name)
+		}
+		positions[job.Name] = i + 1
 
 		// Check job type and required configuration
 		switch job.Type {
@@ -239,18 +1815,166 @@ func (c *Config) Validate() error {
 			if job.PostgresConfig.Host == "" {
 				return fmt.Errorf("postgres job '%s' must have a host", job.Name)
 			}
-			if job.PostgresConfig.Database == "" {
+			if job.PostgresConfig.Database == "" && !job.PostgresConfig.Discover {
 				return fmt.Errorf("postgres job '%s' must have a database name", job.Name)
 			}
+			if parallel := job.PostgresConfig.Parallel; parallel != nil {
+				if parallel.Jobs < 0 {
+					return fmt.Errorf("postgres job '%s' parallel jobs must not be negative", job.Name)
+				}
+				if parallel.MinTableSize != "" {
+					if _, err := ParseSize(parallel.MinTableSize); err != nil {
+						return fmt.Errorf("postgres job '%s' has an invalid parallel min_table_size: %w", job.Name, err)
+					}
+				}
+				if format, ok := job.PostgresConfig.Options["format"]; ok && format != "plain" && format != "p" {
+					return fmt.Errorf("postgres job '%s' parallel dumps require the plain format", job.Name)
+				}
+			}
+			if job.PostgresConfig.PreferStandby && len(job.PostgresConfig.StandbyHosts) == 0 {
+				return fmt.Errorf("postgres job '%s' prefer_standby requires standby_hosts", job.Name)
+			}
+			for _, host := range job.PostgresConfig.StandbyHosts {
+				if strings.TrimSpace(host) == "" {
+					return fmt.Errorf("postgres job '%s' has an empty entry in standby_hosts", job.Name)
+				}
+			}
+			if err := validatePostgresFilters(job.PostgresConfig); err != nil {
+				return fmt.Errorf("postgres job '%s' %w", job.Name, err)
+			}
+			if err := validateVerify(job.PostgresConfig.Verify); err != nil {
+				return fmt.Errorf("postgres job '%s' %w", job.Name, err)
+			}
+			if err := validateMode(job.PostgresConfig.Mode); err != nil {
+				return fmt.Errorf("postgres job '%s' %w", job.Name, err)
+			}
+			if mode := job.PostgresConfig.DumpMode(); mode != ModeFull && job.PostgresConfig.Parallel != nil {
+				return fmt.Errorf("postgres job '%s' parallel dumps require mode full", job.Name)
+			}
+			if job.PostgresConfig.Verify != nil && job.PostgresConfig.DumpMode() == ModeSchema {
+				return fmt.Errorf("postgres job '%s' verify requires a dump with data", job.Name)
+			}
 		case "mysql":
 			if job.MySQLConfig == nil || job.MySQLConfig.ConnectionString == "" {
 				return fmt.Errorf("mysql job '%s' must have a valid connection string", job.Name)
 			}
+			switch job.MySQLConfig.Tool {
+			case "", "mysqldump":
+				if job.MySQLConfig.Threads != 0 || job.MySQLConfig.ChunkSize != "" {
+					return fmt.Errorf("mysql job '%s' threads and chunk_size require tool mydumper", job.Name)
+				}
+			case "mydumper":
+				if job.MySQLConfig.Threads < 0 {
+					return fmt.Errorf("mysql job '%s' threads must not be negative", job.Name)
+				}
+				if job.MySQLConfig.ChunkSize != "" {
+					if _, err := ParseSize(job.MySQLConfig.ChunkSize); err != nil {
+						return fmt.Errorf("mysql job '%s' has an invalid chunk_size: %w", job.Name, err)
+					}
+				}
+			default:
+				return fmt.Errorf("mysql job '%s' has unsupported tool '%s'", job.Name, job.MySQLConfig.Tool)
+			}
+			if err := validateMySQLDump(job.MySQLConfig); err != nil {
+				return fmt.Errorf("mysql job '%s' %w", job.Name, err)
+			}
+			if err := validateVerify(job.MySQLConfig.Verify); err != nil {
+				return fmt.Errorf("mysql job '%s' %w", job.Name, err)
+			}
+			if err := validateMode(job.MySQLConfig.Mode); err != nil {
+				return fmt.Errorf("mysql job '%s' %w", job.Name, err)
+			}
+			if job.MySQLConfig.NoData && job.MySQLConfig.Mode != "" && job.MySQLConfig.Mode != ModeSchema {
+				return fmt.Errorf("mysql job '%s' no_data dumps the schema only, which contradicts mode %s", job.Name, job.MySQLConfig.Mode)
+			}
+			// Tables without rows leave no trace in a data-only dump
+			if job.MySQLConfig.Verify != nil && (job.MySQLConfig.Tool == "mydumper" || job.MySQLConfig.DumpMode() != ModeFull) {
+				return fmt.Errorf("mysql job '%s' verify requires tool mysqldump and mode full", job.Name)
+			}
 		case "minio":
 			if job.MinIOConfig == nil || job.MinIOConfig.Endpoint == "" ||
 				job.MinIOConfig.BucketName == "" {
 				return fmt.Errorf("minio job '%s' must have a valid endpoint and bucket name", job.Name)
 			}
+		case "kafka":
+			if job.KafkaConfig == nil || job.KafkaConfig.BootstrapServers == "" {
+				return fmt.Errorf("kafka job '%s' must have bootstrap servers", job.Name)
+			}
+		case "consul":
+			if job.ConsulConfig == nil || job.ConsulConfig.Address == "" {
+				return fmt.Errorf("consul job '%s' must have an address", job.Name)
+			}
+			if (job.ConsulConfig.ClientCert == "") != (job.ConsulConfig.ClientKey == "") {
+				return fmt.Errorf("consul job '%s' must set both client_cert and client_key", job.Name)
+			}
+		case "keycloak":
+			if job.KeycloakConfig == nil || job.KeycloakConfig.URL == "" {
+				return fmt.Errorf("keycloak job '%s' must have a url", job.Name)
+			}
+			if job.KeycloakConfig.ClientSecret == "" &&
+				(job.KeycloakConfig.Username == "" || job.KeycloakConfig.Password == "") {
+				return fmt.Errorf("keycloak job '%s' must have a client secret or a username and password", job.Name)
+			}
+		case "rest":
+			if job.RESTConfig == nil || job.RESTConfig.URL == "" {
+				return fmt.Errorf("rest job '%s' must have a url", job.Name)
+			}
+			if job.RESTConfig.StatusURL != "" &&
+				(job.RESTConfig.StatusField == "" || job.RESTConfig.DoneValue == "") {
+				return fmt.Errorf("rest job '%s' must have status_field and done_value when status_url is set", job.Name)
+			}
+			if (strings.Contains(job.RESTConfig.StatusURL, "{id}") ||
+				strings.Contains(job.RESTConfig.DownloadURL, "{id}")) && job.RESTConfig.IDField == "" {
+				return fmt.Errorf("rest job '%s' uses an {id} placeholder but has no id_field", job.Name)
+			}
+		case "grafana":
+			if job.GrafanaConfig == nil || job.GrafanaConfig.URL == "" {
+				return fmt.Errorf("grafana job '%s' must have a url", job.Name)
+			}
+			if job.GrafanaConfig.APIToken == "" && job.GrafanaConfig.Username == "" {
+				return fmt.Errorf("grafana job '%s' must have an api token or username", job.Name)
+			}
+		case "snapshot":
+			if job.SnapshotConfig == nil || job.SnapshotConfig.Source == "" {
+				return fmt.Errorf("snapshot job '%s' must have a source", job.Name)
+			}
+			if job.SnapshotConfig.Filesystem != "zfs" && job.SnapshotConfig.Filesystem != "btrfs" {
+				return fmt.Errorf("snapshot job '%s' has unsupported filesystem: %s", job.Name, job.SnapshotConfig.Filesystem)
+			}
+			if job.SnapshotConfig.FullEvery < 0 {
+				return fmt.Errorf("snapshot job '%s' full_every must not be negative", job.Name)
+			}
+			if job.SnapshotConfig.FullEvery > 0 && !job.SnapshotConfig.Incremental {
+				return fmt.Errorf("snapshot job '%s' sets full_every, which only applies to incremental jobs", job.Name)
+			}
+		case "files":
+			if job.FilesConfig == nil || len(job.FilesConfig.Paths) == 0 {
+				return fmt.Errorf("files job '%s' must have at least one path", job.Name)
+			}
+			if job.FilesConfig.VSS {
+				if runtime.GOOS != "windows" {
+					return fmt.Errorf("files job '%s' uses vss, which is only supported on Windows", job.Name)
+				}
+				if job.FilesConfig.LVMSnapshot != nil {
+					return fmt.Errorf("files job '%s' cannot use both vss and lvm_snapshot", job.Name)
+				}
+			}
+			if lvm := job.FilesConfig.LVMSnapshot; lvm != nil {
+				if lvm.VolumeGroup == "" || lvm.LogicalVolume == "" || lvm.Size == "" || lvm.MountPoint == "" {
+					return fmt.Errorf("files job '%s' lvm_snapshot must have volume_group, logical_volume, size and mount_point", job.Name)
+				}
+				for _, path := range job.FilesConfig.Paths {
+					rel, err := filepath.Rel(lvm.MountPoint, path)
+					if err != nil || strings.HasPrefix(rel, "..") {
+						return fmt.Errorf("files job '%s' path %s is not on the snapshotted volume mounted at %s",
+							job.Name, path, lvm.MountPoint)
+					}
+				}
+			}
+		case "sqlite":
+			if job.SQLiteConfig == nil || job.SQLiteConfig.Path == "" {
+				return fmt.Errorf("sqlite job '%s' must have a database path", job.Name)
+			}
 		default:
 			return fmt.Errorf("unsupported job type '%s' for job '%s'", job.Type, job.Name)
 		}
@@ -259,6 +1983,171 @@ func (c *Config) Validate() error {
 		if job.Schedule == "" {
 			return fmt.Errorf("job '%s' has no schedule", job.Name)
 		}
+		for i, entry := range job.Schedules {
+			if entry.Cron == "" {
+				return fmt.Errorf("job '%s' schedule #%d has no cron expression", job.Name, i+1)
+			}
+			if slices.Contains(job.Schedules[:i], entry) {
+				return fmt.Errorf("job '%s' lists schedule '%s' twice", job.Name, entry.Cron)
+			}
+			if entry.Mode == "" {
+				continue
+			}
+			if job.DumpMode() == "" {
+				return fmt.Errorf("job '%s' schedule #%d sets a mode, which only postgres and mysql jobs support", job.Name, i+1)
+			}
+			if err := validateMode(entry.Mode); err != nil {
+				return fmt.Errorf("job '%s' schedule #%d %w", job.Name, i+1, err)
+			}
+		}
+
+		switch job.OnExcludedDate {
+		case "", "skip", "shift", "run":
+		default:
+			return fmt.Errorf("job '%s' has invalid on_excluded_date: %s", job.Name, job.OnExcludedDate)
+		}
+
+		if job.RunAs != "" {
+			if runtime.GOOS == "windows" {
+				return fmt.Errorf("job '%s' uses run_as, which is not supported on Windows", job.Name)
+			}
+			user, group, hasGroup := strings.Cut(job.RunAs, ":")
+			if user == "" || (hasGroup && group == "") {
+				return fmt.Errorf("job '%s' has invalid run_as '%s', expected user or user:group", job.Name, job.RunAs)
+			}
+			// zfs, btrfs, lvcreate and mount need root
+			if job.Type == "snapshot" || (job.FilesConfig != nil && job.FilesConfig.LVMSnapshot != nil) {
+				return fmt.Errorf("job '%s' takes filesystem snapshots, which cannot be combined with run_as", job.Name)
+			}
+		}
+		for _, mode := range [][2]string{{"file_mode", job.FileMode}, {"dir_mode", job.DirMode}} {
+			if _, err := ParseFileMode(mode[1]); mode[1] != "" && err != nil {
+				return fmt.Errorf("job '%s' has invalid %s: %w", job.Name, mode[0], err)
+			}
+		}
+		if job.Owner != "" {
+			if runtime.GOOS == "windows" {
+				return fmt.Errorf("job '%s' uses owner, which is not supported on Windows", job.Name)
+			}
+			user, group, hasGroup := strings.Cut(job.Owner, ":")
+			if user == "" || (hasGroup && group == "") {
+				return fmt.Errorf("job '%s' has invalid owner '%s', expected user or user:group", job.Name, job.Owner)
+			}
+		}
+		if job.MaxBackupAge < 0 {
+			return fmt.Errorf("job '%s' max_backup_age must not be negative", job.Name)
+		}
+		for name := range job.Labels {
+			if !metricLabelName.MatchString(name) || strings.HasPrefix(name, "__") || name == "backup_job" {
+				return fmt.Errorf("job '%s' has invalid label '%s', names take letters, digits and underscores, cannot start with a digit or __ and cannot be backup_job", job.Name, name)
+			}
+		}
+		if job.Notification.DedupWindow < 0 {
+			return fmt.Errorf("job '%s' notification dedup_window must not be negative", job.Name)
+		}
+		if discord := job.Notification.Discord; discord != nil {
+			if err := discord.validate(); err != nil {
+				return fmt.Errorf("job '%s' discord notification: %w", job.Name, err)
+			}
+		}
+		if matrix := job.Notification.Matrix; matrix != nil {
+			if err := matrix.validate(); err != nil {
+				return fmt.Errorf("job '%s' matrix notification: %w", job.Name, err)
+			}
+		}
+		if signal := job.Notification.Signal; signal != nil {
+			if err := signal.validate(); err != nil {
+				return fmt.Errorf("job '%s' signal notification: %w", job.Name, err)
+			}
+		}
+		if syslog := job.Notification.Syslog; syslog != nil {
+			if err := syslog.validate(); err != nil {
+				return fmt.Errorf("job '%s' syslog notification: %w", job.Name, err)
+			}
+		}
+		if snmp := job.Notification.SNMP; snmp != nil {
+			if err := snmp.validate(); err != nil {
+				return fmt.Errorf("job '%s' snmp notification: %w", job.Name, err)
+			}
+		}
+		if sns := job.Notification.SNS; sns != nil {
+			if err := sns.validate(); err != nil {
+				return fmt.Errorf("job '%s' sns notification: %w", job.Name, err)
+			}
+		}
+		if pubsub := job.Notification.PubSub; pubsub != nil {
+			if err := pubsub.validate(); err != nil {
+				return fmt.Errorf("job '%s' pubsub notification: %w", job.Name, err)
+			}
+		}
+		if mqtt := job.Notification.MQTT; mqtt != nil {
+			if err := mqtt.validate(); err != nil {
+				return fmt.Errorf("job '%s' mqtt notification: %w", job.Name, err)
+			}
+		}
+		if nsca := job.Notification.NSCA; nsca != nil {
+			if err := nsca.validate(); err != nil {
+				return fmt.Errorf("job '%s' nsca notification: %w", job.Name, err)
+			}
+		}
+		if icinga := job.Notification.Icinga; icinga != nil {
+			if err := icinga.validate(); err != nil {
+				return fmt.Errorf("job '%s' icinga notification: %w", job.Name, err)
+			}
+		}
+		if zabbix := job.Notification.Zabbix; zabbix != nil {
+			if err := zabbix.validate(); err != nil {
+				return fmt.Errorf("job '%s' zabbix notification: %w", job.Name, err)
+			}
+		}
+		if check := job.LoadCheck; check != nil {
+			if job.Type != "postgres" && job.Type != "mysql" {
+				return fmt.Errorf("job '%s' uses load_check, which only postgres and mysql jobs support", job.Name)
+			}
+			if check.MaxActiveConnections <= 0 && check.MaxReplicationLag <= 0 {
+				return fmt.Errorf("job '%s' load_check must set max_active_connections or max_replication_lag", job.Name)
+			}
+			if check.RetryInterval < 0 || check.MaxRetries < 0 {
+				return fmt.Errorf("job '%s' load_check retry_interval and max_retries must not be negative", job.Name)
+			}
+		}
+		if job.PauseUntil != "" {
+			if _, err := ParsePauseUntil(job.PauseUntil); err != nil {
+				return fmt.Errorf("job '%s' has an %w", job.Name, err)
+			}
+		}
+		if compression := job.Compression; compression != nil {
+			if !slices.Contains([]string{"postgres", "mysql", "minio", "sqlite", "snapshot"}, job.Type) {
+				return fmt.Errorf("job '%s' uses compression, which only postgres, mysql, minio, sqlite and snapshot jobs support", job.Name)
+			}
+			if err := compression.validate(); err != nil {
+				return fmt.Errorf("job '%s' %w", job.Name, err)
+			}
+			// mydumper compresses its files itself, with its default level
+			if job.Type == "mysql" && job.MySQLConfig != nil && job.MySQLConfig.Tool == "mydumper" &&
+				(compression.Algorithm == CompressionLz4 || compression.Level != 0) {
+				return fmt.Errorf("job '%s' compression with mydumper supports gzip and zstd without a level", job.Name)
+			}
+		}
+		if job.Encryption != nil {
+			if err := job.Encryption.validate(); err != nil {
+				return fmt.Errorf("job '%s' %w", job.Name, err)
+			}
+		}
+		if err := validateTransitions(job, c.Storage.Type); err != nil {
+			return fmt.Errorf("job '%s' %w", job.Name, err)
+		}
+		for i, name := range job.Destinations {
+			if _, ok := c.Storage.Destinations[name]; !ok {
+				return fmt.Errorf("job '%s' copies backups to unknown storage destination '%s'", job.Name, name)
+			}
+			if slices.Contains(job.Destinations[:i], name) {
+				return fmt.Errorf("job '%s' lists storage destination '%s' twice", job.Name, name)
+			}
+		}
+		if job.NoNewPrivileges && runtime.GOOS != "linux" {
+			return fmt.Errorf("job '%s' uses no_new_privileges, which is only supported on Linux", job.Name)
+		}
 
 		// Check retention policy
 		if job.RetentionPolicy.Type != "count" && job.RetentionPolicy.Type != "days" {
@@ -267,7 +2156,488 @@ func (c *Config) Validate() error {
 		if job.RetentionPolicy.Value <= 0 {
 			return fmt.Errorf("job '%s' has invalid retention policy value: %d", job.Name, job.RetentionPolicy.Value)
 		}
+		if job.RetentionPolicy.GracePeriod < 0 || job.RetentionPolicy.MaxDeletions < 0 {
+			return fmt.Errorf("job '%s' retention grace_period and max_deletions must not be negative", job.Name)
+		}
+		if class := job.RetentionPolicy.Class; len(class) > 256 || !objectTagText.MatchString(class) {
+			return fmt.Errorf("job '%s' has invalid retention class '%s', tags allow letters, digits, spaces and _ . : / = + - @", job.Name, class)
+		}
+	}
+
+	return nil
+}
+
+// Keys returns the job, run ID and retention tag keys, with the defaults
+// for those left empty
+func (t *ObjectTagsConfig) Keys() (job, runID, retention string) {
+	job, runID, retention = t.JobKey, t.RunIDKey, t.RetentionKey
+	if job == "" {
+		job = DefaultJobTagKey
+	}
+	if runID == "" {
+		runID = DefaultRunIDTagKey
+	}
+	if retention == "" {
+		retention = DefaultRetentionTagKey
+	}
+	return job, runID, retention
+}
+
+// Tags returns the tags of an object uploaded by a job's run, leaving out
+// the run ID and retention tags when they are unknown
+func (t *ObjectTagsConfig) Tags(job JobConfig, runID string) map[string]string {
+	jobKey, runIDKey, retentionKey := t.Keys()
+	tags := make(map[string]string, len(t.Extra)+3)
+	for k, v := range t.Extra {
+		tags[k] = v
+	}
+	tags[jobKey] = job.Name
+	if class := job.RetentionPolicy.ClassName(); class != "" {
+		tags[retentionKey] = class
+	}
+	if runID != "" {
+		tags[runIDKey] = runID
+	}
+	return tags
+}
+
+func (t *ObjectTagsConfig) validate() error {
+	jobKey, runIDKey, retentionKey := t.Keys()
+	if len(t.Extra)+3 > maxObjectTags {
+		return fmt.Errorf("objects take at most %d tags, leaving %d for extra", maxObjectTags, maxObjectTags-3)
 	}
 
+	seen := make(map[string]bool)
+	for _, key := range []string{jobKey, runIDKey, retentionKey} {
+		if seen[key] {
+			return fmt.Errorf("tag key '%s' is used twice", key)
+		}
+		seen[key] = true
+	}
+	keys := []string{jobKey, runIDKey, retentionKey}
+	for key, value := range t.Extra {
+		if seen[key] {
+			return fmt.Errorf("extra tag '%s' is already set by backmeup", key)
+		}
+		if len(value) > 256 || !objectTagText.MatchString(value) {
+			return fmt.Errorf("extra tag '%s' has an invalid value '%s'", key, value)
+		}
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if key == "" || len(key) > 128 || !objectTagText.MatchString(key) || strings.HasPrefix(key, "aws:") {
+			return fmt.Errorf("invalid tag key '%s', keys take 1 to 128 letters, digits, spaces and _ . : / = + - @ and cannot start with aws:", key)
+		}
+	}
 	return nil
 }
+
+// systemDirectories are directories that hold more than backups on any
+// system, which retention must never work in
+var systemDirectories = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/home", "/lib", "/lib64", "/media", "/mnt", "/opt", "/proc",
+	"/root", "/run", "/sbin", "/srv", "/sys", "/tmp", "/usr", "/usr/local", "/var", "/var/lib", "/Users",
+}
+
+// isSystemDirectory reports whether dir is a system directory or the home
+// directory of the current user
+func isSystemDirectory(dir string) bool {
+	dir = filepath.Clean(dir)
+	if slices.Contains(systemDirectories, dir) {
+		return true
+	}
+	home, err := os.UserHomeDir()
+	return err == nil && dir == filepath.Clean(home)
+}
+
+// validateCache checks the local cache of a remote storage type
+func validateCache(storageType string, cache *CacheConfig, keepLocal bool) error {
+	if cache == nil {
+		return nil
+	}
+	if keepLocal {
+		return fmt.Errorf("%s storage cannot combine keep_local with cache, keep_local already keeps every backup", storageType)
+	}
+	if cache.Keep <= 0 {
+		return fmt.Errorf("%s storage cache must keep at least one backup per job", storageType)
+	}
+	if cache.MaxSize != "" {
+		if size, err := ParseSize(cache.MaxSize); err != nil || size <= 0 {
+			return fmt.Errorf("invalid %s storage cache max_size: %s", storageType, cache.MaxSize)
+		}
+	}
+	return nil
+}
+
+// storageClassName matches the storage classes of AWS and the tier names of
+// other S3 compatible stores, such as GLACIER_IR or WARM-TIER
+var storageClassName = regexp.MustCompile(`^[A-Z0-9_-]+$`)
+
+// validateTransitions checks a job's storage class and transitions, which
+// only apply to backups uploaded to s3 storage
+func validateTransitions(job JobConfig, storageType string) error {
+	if job.StorageClass == "" && len(job.Transitions) == 0 {
+		return nil
+	}
+	if storageType != "s3" {
+		return fmt.Errorf("sets a storage_class or transitions, which only s3 storage supports")
+	}
+	if job.StorageClass != "" && !storageClassName.MatchString(job.StorageClass) {
+		return fmt.Errorf("has invalid storage_class: %s", job.StorageClass)
+	}
+
+	var previous time.Duration
+	for _, t := range job.Transitions {
+		if !storageClassName.MatchString(t.StorageClass) {
+			return fmt.Errorf("has a transition with invalid storage_class: '%s'", t.StorageClass)
+		}
+		if t.After < 24*time.Hour || t.After%(24*time.Hour) != 0 {
+			return fmt.Errorf("has a transition to %s after %s, transitions take whole days", t.StorageClass, t.After)
+		}
+		if t.After <= previous {
+			return fmt.Errorf("transitions must be ordered by after, %s comes after %s", t.After, previous)
+		}
+		previous = t.After
+	}
+	return nil
+}
+
+// validateMaxSize checks the size limit of the local directory
+func (l LocalConfig) validateMaxSize() error {
+	if l.MaxSize == "" {
+		if l.OnMaxSize != "" || l.EarlyRetention {
+			return fmt.Errorf("local storage on_max_size and early_retention require max_size")
+		}
+		return nil
+	}
+	if size, err := ParseSize(l.MaxSize); err != nil || size <= 0 {
+		return fmt.Errorf("invalid local storage max_size: %s", l.MaxSize)
+	}
+	switch l.OnMaxSize {
+	case "", "warn", "refuse":
+		return nil
+	}
+	return fmt.Errorf("invalid local storage on_max_size: %s, use warn or refuse", l.OnMaxSize)
+}
+
+// validJobName reports whether name can be used as a single path component,
+// since backups are stored in a directory named after their job
+func validJobName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// ValidateJob checks a single job against the rest of the configuration
+func (c *Config) ValidateJob(job JobConfig) error {
+	cfg := *c
+	cfg.Jobs = []JobConfig{job}
+	return cfg.Validate()
+}
+
+// FiltersTables reports whether a postgres job dumps only some of its tables
+func (pg *PostgresConfig) FiltersTables() bool {
+	return len(pg.IncludeTables) > 0 || len(pg.ExcludeTables) > 0 || len(pg.Schemas) > 0 || len(pg.ExcludeSchemas) > 0
+}
+
+// DumpsBlobs reports whether pg_dump includes large objects, which it does
+// by default unless only some tables or schemas are dumped. A schema dump
+// never holds their contents.
+func (pg *PostgresConfig) DumpsBlobs() bool {
+	if pg.DumpMode() == ModeSchema {
+		return false
+	}
+	if pg.Blobs != nil {
+		return *pg.Blobs
+	}
+	return len(pg.IncludeTables) == 0 && len(pg.Schemas) == 0
+}
+
+// DumpMode returns the job's dump mode, ModeFull unless set
+func (pg *PostgresConfig) DumpMode() string {
+	if pg.Mode == "" {
+		return ModeFull
+	}
+	return pg.Mode
+}
+
+// DumpMode returns the job's dump mode, ModeSchema for no_data and ModeFull
+// unless set
+func (my *MySQLConfig) DumpMode() string {
+	switch {
+	case my.Mode != "":
+		return my.Mode
+	case my.NoData:
+		return ModeSchema
+	}
+	return ModeFull
+}
+
+// DumpMode returns the dump mode of a database job, or "" for the types
+// without one
+func (j JobConfig) DumpMode() string {
+	switch {
+	case j.Type == "postgres" && j.PostgresConfig != nil:
+		return j.PostgresConfig.DumpMode()
+	case j.Type == "mysql" && j.MySQLConfig != nil:
+		return j.MySQLConfig.DumpMode()
+	}
+	return ""
+}
+
+// validateMode checks the dump mode of a database job, the error is prefixed
+// with the job by the caller
+func validateMode(mode string) error {
+	switch mode {
+	case "", ModeFull, ModeSchema, ModeData:
+		return nil
+	}
+	return fmt.Errorf("has unsupported mode '%s', use full, schema or data", mode)
+}
+
+// postgresFilterOptions maps the pg_dump flags, long and short, that the
+// table, schema, extension, large object and mode fields replace
+var postgresFilterOptions = map[string]string{
+	"schema-only":       "mode",
+	"s":                 "mode",
+	"data-only":         "mode",
+	"a":                 "mode",
+	"table":             "include_tables",
+	"t":                 "include_tables",
+	"exclude-table":     "exclude_tables",
+	"T":                 "exclude_tables",
+	"schema":            "schemas",
+	"n":                 "schemas",
+	"exclude-schema":    "exclude_schemas",
+	"N":                 "exclude_schemas",
+	"extension":         "extensions",
+	"e":                 "extensions",
+	"exclude-extension": "exclude_extensions",
+	"blobs":             "blobs",
+	"b":                 "blobs",
+	"no-blobs":          "blobs",
+	"B":                 "blobs",
+	"large-objects":     "blobs",
+	"no-large-objects":  "blobs",
+}
+
+// validatePostgresFilters checks the table, schema and extension filters of
+// a postgres job, the error is prefixed with the job by the caller
+func validatePostgresFilters(pg *PostgresConfig) error {
+	filters := []struct {
+		field    string
+		patterns []string
+	}{
+		{"include_tables", pg.IncludeTables},
+		{"exclude_tables", pg.ExcludeTables},
+		{"schemas", pg.Schemas},
+		{"exclude_schemas", pg.ExcludeSchemas},
+		{"extensions", pg.Extensions},
+		{"exclude_extensions", pg.ExcludeExtensions},
+	}
+	for _, filter := range filters {
+		for _, pattern := range filter.patterns {
+			if strings.TrimSpace(pattern) == "" {
+				return fmt.Errorf("has an empty entry in %s", filter.field)
+			}
+		}
+	}
+
+	for _, table := range pg.IncludeTables {
+		if slices.Contains(pg.ExcludeTables, table) {
+			return fmt.Errorf("both includes and excludes table %s", table)
+		}
+	}
+	for _, schema := range pg.Schemas {
+		if slices.Contains(pg.ExcludeSchemas, schema) {
+			return fmt.Errorf("both includes and excludes schema %s", schema)
+		}
+	}
+	for _, extension := range pg.Extensions {
+		if slices.Contains(pg.ExcludeExtensions, extension) {
+			return fmt.Errorf("both includes and excludes extension %s", extension)
+		}
+	}
+	// pg_dump ignores --schema and --exclude-schema once --table is given
+	if len(pg.IncludeTables) > 0 && (len(pg.Schemas) > 0 || len(pg.ExcludeSchemas) > 0) {
+		return fmt.Errorf("cannot combine include_tables with schema filters, qualify the tables with their schema instead")
+	}
+
+	for option := range pg.Options {
+		if field, ok := postgresFilterOptions[option]; ok {
+			return fmt.Errorf("sets the %s option, use %s instead", option, field)
+		}
+	}
+	// The large tables of a parallel dump are copied without pg_dump
+	if pg.FiltersTables() && pg.Parallel != nil {
+		return fmt.Errorf("cannot combine table or schema filters with parallel dumps")
+	}
+	return nil
+}
+
+// validateVerify checks the dump verification of a database job, the error
+// is prefixed with the job by the caller
+func validateVerify(verify *VerifyConfig) error {
+	if verify == nil {
+		return nil
+	}
+	if !verify.RowCounts && len(verify.Queries) == 0 {
+		return fmt.Errorf("verify needs row_counts or queries")
+	}
+	if verify.Tolerance < 0 || verify.Tolerance > 100 {
+		return fmt.Errorf("verify tolerance must be a percentage between 0 and 100")
+	}
+	for table, query := range verify.Queries {
+		if strings.TrimSpace(table) == "" || strings.TrimSpace(query) == "" {
+			return fmt.Errorf("verify queries need a table and a query")
+		}
+	}
+	return nil
+}
+
+// validateMySQLDump checks the database and table selection and the
+// binlog options of a mysql job, the error is prefixed with the job by the
+// caller
+func validateMySQLDump(my *MySQLConfig) error {
+	if len(my.Databases) > 0 {
+		if my.Discover {
+			return fmt.Errorf("cannot combine databases with discover")
+		}
+		if !strings.HasSuffix(my.ConnectionString, "/") {
+			return fmt.Errorf("lists databases, the connection string must not name one as well")
+		}
+	}
+	for _, db := range my.Databases {
+		if strings.TrimSpace(db) == "" {
+			return fmt.Errorf("has an empty entry in databases")
+		}
+	}
+
+	switch my.Coordinates {
+	case "", "source", "replica":
+	default:
+		return fmt.Errorf("has unsupported coordinates '%s', use source or replica", my.Coordinates)
+	}
+	switch strings.ToLower(my.GTIDPurged) {
+	case "", "on", "off", "auto":
+	default:
+		return fmt.Errorf("has unsupported gtid_purged '%s', use on, off or auto", my.GTIDPurged)
+	}
+	// mydumper always records the coordinates in its metadata file
+	if my.Tool == "mydumper" && (my.Coordinates != "" || my.GTIDPurged != "") {
+		return fmt.Errorf("coordinates and gtid_purged require tool mysqldump")
+	}
+
+	filters := []struct {
+		field  string
+		tables []string
+	}{
+		{"include_tables", my.IncludeTables},
+		{"exclude_tables", my.ExcludeTables},
+	}
+	for _, filter := range filters {
+		for _, table := range filter.tables {
+			if strings.TrimSpace(table) == "" || strings.Count(table, ".") > 1 {
+				return fmt.Errorf("has an invalid entry '%s' in %s, use table or database.table", table, filter.field)
+			}
+		}
+	}
+	for _, table := range my.IncludeTables {
+		if slices.Contains(my.ExcludeTables, table) {
+			return fmt.Errorf("both includes and excludes table %s", table)
+		}
+	}
+	return nil
+}
+
+// sizeUnits maps size suffixes to their multiplier, units are powers of 1024
+var sizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"K":  1 << 10,
+	"KB": 1 << 10,
+	"M":  1 << 20,
+	"MB": 1 << 20,
+	"G":  1 << 30,
+	"GB": 1 << 30,
+	"T":  1 << 40,
+	"TB": 1 << 40,
+}
+
+// ParseSize parses a size such as 512MB or 1.5TB into bytes
+func ParseSize(value string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(value))
+	trimmed = strings.Replace(trimmed, "IB", "B", 1)
+
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(trimmed)
+	}
+
+	number, err := strconv.ParseFloat(trimmed[:split], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	unit, ok := sizeUnits[strings.TrimSpace(trimmed[split:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", value)
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+// ParseFileMode parses an octal permission mode such as 0640
+func ParseFileMode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("expected an octal mode between 0000 and 0777, got %q", value)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// ParseDateRange parses a single date (YYYY-MM-DD) or an inclusive range
+// (YYYY-MM-DD..YYYY-MM-DD) in the local time zone
+func ParseDateRange(value string) (time.Time, time.Time, error) {
+	fromStr, toStr, isRange := strings.Cut(value, "..")
+	if !isRange {
+		toStr = fromStr
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(fromStr), time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD")
+	}
+	to, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(toStr), time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("expected YYYY-MM-DD or YYYY-MM-DD..YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("range end is before its start")
+	}
+
+	return from, to, nil
+}
+
+// ParseTimeWindow parses a daily time window such as 01:00-06:00 into its
+// start and end as offsets from midnight. The end may be before the start
+// for windows that span midnight.
+func ParseTimeWindow(value string) (time.Duration, time.Duration, error) {
+	startStr, endStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(startStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(endStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("expected HH:MM-HH:MM")
+	}
+	if start.Equal(end) {
+		return 0, 0, fmt.Errorf("window start and end are the same")
+	}
+
+	midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+	return start.Sub(midnight), end.Sub(midnight), nil
+}
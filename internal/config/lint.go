@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduleOverlapWindow is how far ahead schedules are compared for runs that
+// start at the same minute
+const scheduleOverlapWindow = 7 * 24 * time.Hour
+
+// productionTags mark jobs whose failures should never go unnoticed
+var productionTags = []string{"production", "prod"}
+
+// secretLine matches YAML lines holding a secret value
+var secretLine = regexp.MustCompile(`^\s*(?:-\s+)?(` + strings.Join(secretFields, "|") + `)\s*:\s*(.*)$`)
+
+// connectionPassword matches the password of a user:password@host URL
+var connectionPassword = regexp.MustCompile(`^\s*(?:-\s+)?connection_string\s*:\s*["']?\w+://[^:/@\s]+:([^@\s]+)@`)
+
+// Lint reports settings that are valid but probably not what was intended.
+// Unlike Validate it never rejects a configuration.
+func (c *Config) Lint() []string {
+	var warnings []string
+
+	for _, job := range c.Jobs {
+		if job.RetentionPolicy.Type == "count" && job.RetentionPolicy.Value < 2 {
+			warnings = append(warnings, fmt.Sprintf("job '%s' keeps only %d backup, a failed run leaves nothing to restore from",
+				job.Name, job.RetentionPolicy.Value))
+		}
+
+		// pg_dump silently drops large objects once tables or schemas are picked
+		if pg := job.PostgresConfig; job.Type == "postgres" && pg != nil && pg.Blobs == nil &&
+			(len(pg.IncludeTables) > 0 || len(pg.Schemas) > 0) {
+			warnings = append(warnings, fmt.Sprintf("job '%s' dumps only some tables or schemas, which leaves large objects out unless blobs is set",
+				job.Name))
+		}
+
+		// A count policy counts the backups of every schedule together, so
+		// frequent schema dumps push the nightly full dumps out
+		if job.RetentionPolicy.Type == "count" && scheduleModes(job) > 1 {
+			warnings = append(warnings, fmt.Sprintf("job '%s' has schedules with different modes and a count retention policy, which counts their backups together",
+				job.Name))
+		}
+
+		// Objects removed before a transition never reach the cheaper class
+		if job.RetentionPolicy.Type == "days" {
+			for _, t := range job.Transitions {
+				if days := int(t.After / (24 * time.Hour)); days >= job.RetentionPolicy.Value {
+					warnings = append(warnings, fmt.Sprintf("job '%s' removes backups after %d days, before they move to %s after %d days",
+						job.Name, job.RetentionPolicy.Value, t.StorageClass, days))
+				}
+			}
+		}
+
+		// A pause that has ended no longer does anything and is easily
+		// mistaken for one that is still in place
+		if until := job.PausedUntil(); !until.IsZero() && !until.After(time.Now()) {
+			warnings = append(warnings, fmt.Sprintf("job '%s' was paused until %s, which has passed, remove pause_until",
+				job.Name, job.PauseUntil))
+		}
+
+		for _, tag := range productionTags {
+			if slices.Contains(job.Tags, tag) && !job.Notification.Enabled {
+				warnings = append(warnings, fmt.Sprintf("job '%s' is tagged %s but has notifications disabled", job.Name, tag))
+				break
+			}
+		}
+	}
+
+	warnings = append(warnings, c.lintScheduleOverlaps()...)
+	warnings = append(warnings, lintPlaintextSecrets(c.source)...)
+
+	return warnings
+}
+
+// lintScheduleOverlaps reports database jobs on the same host whose schedules
+// start runs at the same minute
+func (c *Config) lintScheduleOverlaps() []string {
+	byHost := make(map[string][]JobConfig)
+	for _, job := range c.Jobs {
+		if host := databaseHost(job); host != "" {
+			byHost[host] = append(byHost[host], job)
+		}
+	}
+
+	hosts := make([]string, 0, len(byHost))
+	for host := range byHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var warnings []string
+	start := time.Now().Truncate(time.Minute)
+	for _, host := range hosts {
+		jobs := byHost[host]
+		runs := make([]map[time.Time]bool, len(jobs))
+		for i, job := range jobs {
+			runs[i] = scheduleRuns(job, start, scheduleOverlapWindow)
+		}
+
+		for i := range jobs {
+			for j := i + 1; j < len(jobs); j++ {
+				if at, ok := firstCommonRun(runs[i], runs[j]); ok {
+					warnings = append(warnings, fmt.Sprintf("jobs '%s' and '%s' both start at %s against database host %s",
+						jobs[i].Name, jobs[j].Name, at.Format("Mon 15:04"), host))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// databaseHost returns the database server a job connects to, if any
+func databaseHost(job JobConfig) string {
+	switch {
+	case job.Type == "postgres" && job.PostgresConfig != nil:
+		return job.PostgresConfig.Host
+	case job.Type == "mysql" && job.MySQLConfig != nil:
+		_, rest, ok := strings.Cut(job.MySQLConfig.ConnectionString, "@")
+		if !ok {
+			return ""
+		}
+		host, _, _ := strings.Cut(rest, "/")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host
+	}
+	return ""
+}
+
+// scheduleModes returns how many dump modes the schedules of a job use
+func scheduleModes(job JobConfig) int {
+	modes := make(map[string]bool)
+	for _, entry := range job.ScheduleEntries() {
+		modes[job.ForSchedule(entry).DumpMode()] = true
+	}
+	return len(modes)
+}
+
+// scheduleRuns returns the start times of a job's schedules within the
+// window. Unparsable schedules have no runs.
+func scheduleRuns(job JobConfig, start time.Time, window time.Duration) map[time.Time]bool {
+	runs := make(map[time.Time]bool)
+	end := start.Add(window)
+	for _, entry := range job.ScheduleEntries() {
+		sched, err := cron.ParseStandard(entry.Cron)
+		if err != nil {
+			continue
+		}
+		for next := sched.Next(start); !next.IsZero() && next.Before(end); next = sched.Next(next) {
+			runs[next] = true
+		}
+	}
+	return runs
+}
+
+func firstCommonRun(a, b map[time.Time]bool) (time.Time, bool) {
+	var first time.Time
+	for at := range a {
+		if b[at] && (first.IsZero() || at.Before(first)) {
+			first = at
+		}
+	}
+	return first, !first.IsZero()
+}
+
+// lintPlaintextSecrets reports secrets written directly into the config file
+// rather than referenced as ${ENV_VAR}
+func lintPlaintextSecrets(source []byte) []string {
+	var warnings []string
+	for i, line := range strings.Split(string(source), "\n") {
+		if match := secretLine.FindStringSubmatch(line); match != nil {
+			if isPlaintext(match[2]) {
+				warnings = append(warnings, fmt.Sprintf("line %d: %s is stored in plain text, use an ${ENV_VAR} reference instead",
+					i+1, match[1]))
+			}
+			continue
+		}
+		if match := connectionPassword.FindStringSubmatch(line); match != nil && isPlaintext(match[1]) {
+			warnings = append(warnings, fmt.Sprintf("line %d: connection_string contains a plain text password, use an ${ENV_VAR} reference instead",
+				i+1))
+		}
+	}
+	return warnings
+}
+
+func isPlaintext(value string) bool {
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	return value != "" && !strings.Contains(value, "${") && !strings.Contains(value, "{{")
+}
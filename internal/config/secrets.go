@@ -0,0 +1,68 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// secretFields are the YAML keys whose values are credentials
+var secretFields = []string{"password", "secret_key", "application_key", "client_secret", "token", "auth_token", "api_token", "access_token", "webhook_url"}
+
+// Secrets returns every credential in the configuration, including those of
+// job templates, so they can be masked in logs and API responses
+func (c *Config) Secrets() []string {
+	var secrets []string
+	collectSecrets(reflect.ValueOf(c).Elem(), &secrets)
+	return secrets
+}
+
+// Secrets returns every credential in the job configuration
+func (j JobConfig) Secrets() []string {
+	var secrets []string
+	collectSecrets(reflect.ValueOf(j), &secrets)
+	return secrets
+}
+
+func collectSecrets(v reflect.Value, secrets *[]string) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			collectSecrets(v.Elem(), secrets)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			collectSecrets(v.Index(i), secrets)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			collectSecrets(v.MapIndex(key), secrets)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			value := v.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+
+			if value.Kind() != reflect.String || value.String() == "" {
+				collectSecrets(value, secrets)
+				continue
+			}
+			switch {
+			case slices.Contains(secretFields, name):
+				*secrets = append(*secrets, value.String())
+			case name == "connection_string":
+				if u, err := url.Parse(value.String()); err == nil {
+					if password, ok := u.User.Password(); ok && password != "" {
+						*secrets = append(*secrets, password)
+					}
+				}
+			}
+		}
+	}
+}
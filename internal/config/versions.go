@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultKeepVersions is the number of configuration versions kept when
+// config_versions.keep is not set
+const DefaultKeepVersions = 5
+
+const versionTimeLayout = "20060102-150405.000"
+
+// Version is a saved copy of a configuration that loaded and validated
+type Version struct {
+	Path    string
+	SavedAt time.Time
+}
+
+// VersionStore keeps the last successfully loaded configurations next to the
+// configuration file, so that a bad edit can be rolled back
+type VersionStore struct {
+	dir  string
+	keep int
+}
+
+// NewVersionStore returns the store of the configuration file at path. Its
+// location only depends on the path so that it can be found even when the
+// current file no longer parses.
+func NewVersionStore(path string, keep int) *VersionStore {
+	if keep <= 0 {
+		keep = DefaultKeepVersions
+	}
+	return &VersionStore{dir: path + ".versions", keep: keep}
+}
+
+// Source returns the configuration file as it was read, before environment
+// variables were expanded
+func (c *Config) Source() []byte {
+	return c.source
+}
+
+// Save records a configuration unless it is identical to the newest version,
+// and removes versions beyond the number kept
+func (v *VersionStore) Save(source []byte) error {
+	versions, err := v.List()
+	if err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		newest, err := os.ReadFile(versions[0].Path)
+		if err == nil && bytes.Equal(newest, source) {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(v.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create config version directory: %w", err)
+	}
+	// Names sort by time, so a save within the same millisecond moves forward
+	savedAt := time.Now().Truncate(time.Millisecond)
+	if len(versions) > 0 && !savedAt.After(versions[0].SavedAt) {
+		savedAt = versions[0].SavedAt.Add(time.Millisecond)
+	}
+	path := filepath.Join(v.dir, "config-"+savedAt.Format(versionTimeLayout)+".yml")
+	if err := os.WriteFile(path, source, 0600); err != nil {
+		return fmt.Errorf("failed to save config version: %w", err)
+	}
+
+	versions, err = v.List()
+	if err != nil {
+		return err
+	}
+	for _, old := range versions[min(v.keep, len(versions)):] {
+		if err := os.Remove(old.Path); err != nil {
+			return fmt.Errorf("failed to remove old config version: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns the saved versions, newest first
+func (v *VersionStore) List() ([]Version, error) {
+	entries, err := os.ReadDir(v.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config versions: %w", err)
+	}
+
+	var versions []Version
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(strings.TrimSuffix(e.Name(), ".yml"), "config-")
+		if !ok || e.IsDir() {
+			continue
+		}
+		savedAt, err := time.ParseInLocation(versionTimeLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, Version{Path: filepath.Join(v.dir, e.Name()), SavedAt: savedAt})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].SavedAt.After(versions[j].SavedAt) })
+	return versions, nil
+}
+
+// Restore writes a saved version over the configuration file. The replaced
+// file is kept as <path>.rejected for inspection.
+func (v *VersionStore) Restore(version Version, path string) error {
+	data, err := os.ReadFile(version.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read config version: %w", err)
+	}
+
+	if current, err := os.ReadFile(path); err == nil {
+		if err := os.WriteFile(path+".rejected", current, 0600); err != nil {
+			return fmt.Errorf("failed to keep the rejected config: %w", err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	mode := os.FileMode(0600)
+	if err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp := path + ".rollback"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		// A configuration file bind-mounted into a container cannot be replaced,
+		// only rewritten
+		os.Remove(tmp)
+		if err := os.WriteFile(path, data, mode); err != nil {
+			return fmt.Errorf("failed to write config: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	versions := NewVersionStore(path, 3)
+
+	saved, err := versions.List()
+	require.NoError(t, err)
+	assert.Empty(t, saved)
+
+	for _, source := range []string{"a", "a", "b", "c", "d"} {
+		require.NoError(t, versions.Save([]byte(source)))
+	}
+
+	// Identical saves are skipped and only the newest three are kept
+	saved, err = versions.List()
+	require.NoError(t, err)
+	require.Len(t, saved, 3)
+	for i, want := range []string{"d", "c", "b"} {
+		data, err := os.ReadFile(saved[i].Path)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte("broken"), 0640))
+	require.NoError(t, versions.Restore(saved[1], path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "c", string(data))
+	rejected, err := os.ReadFile(path + ".rejected")
+	require.NoError(t, err)
+	assert.Equal(t, "broken", string(rejected))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
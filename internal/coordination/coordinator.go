@@ -0,0 +1,184 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const (
+	defaultHeartbeatInterval = 10 * time.Second
+	backendTimeout           = 10 * time.Second
+)
+
+// Backend stores instance membership and run claims shared by all instances
+type Backend interface {
+	// Heartbeat records that the instance is alive
+	Heartbeat(ctx context.Context, instanceID string) error
+	// Members lists the instances seen within ttl
+	Members(ctx context.Context, ttl time.Duration) ([]string, error)
+	// Claim records that the instance runs the job's run scheduled at the
+	// given time. It reports false if another instance claimed it first.
+	Claim(ctx context.Context, jobName string, scheduledAt time.Time, instanceID string) (bool, error)
+	// Leave removes the instance from the member list
+	Leave(ctx context.Context, instanceID string) error
+}
+
+// Coordinator assigns every job to one live instance using rendezvous
+// hashing, so jobs only move when the instance that owns them goes away
+type Coordinator struct {
+	backend    Backend
+	instanceID string
+	interval   time.Duration
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	members []string
+}
+
+// New creates a coordinator for the configured backend
+func New(cfg config.CoordinationConfig) (*Coordinator, error) {
+	var backend Backend
+	switch cfg.Backend {
+	case "postgres":
+		backend = newPostgresBackend(*cfg.Postgres)
+	case "redis":
+		backend = newRedisBackend(*cfg.Redis)
+	default:
+		return nil, fmt.Errorf("unsupported coordination backend: %s", cfg.Backend)
+	}
+
+	instanceID := cfg.InstanceID
+	if instanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine instance id: %w", err)
+		}
+		instanceID = hostname
+	}
+
+	return newCoordinator(backend, instanceID, cfg.HeartbeatInterval, cfg.MemberTTL), nil
+}
+
+func newCoordinator(backend Backend, instanceID string, interval, ttl time.Duration) *Coordinator {
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	if ttl <= 0 {
+		ttl = 3 * interval
+	}
+
+	return &Coordinator{
+		backend:    backend,
+		instanceID: instanceID,
+		interval:   interval,
+		ttl:        ttl,
+	}
+}
+
+// InstanceID returns the identifier this instance registers with
+func (c *Coordinator) InstanceID() string {
+	return c.instanceID
+}
+
+// Run sends heartbeats until the context is cancelled, then leaves the member
+// list so that other instances take over immediately
+func (c *Coordinator) Run(ctx context.Context) {
+	log.Printf("Coordination started as instance %s", c.instanceID)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.heartbeat(ctx)
+
+		select {
+		case <-ctx.Done():
+			leaveCtx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+			if err := c.backend.Leave(leaveCtx, c.instanceID); err != nil {
+				log.Printf("Error leaving coordination: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Coordinator) heartbeat(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	if err := c.backend.Heartbeat(ctx, c.instanceID); err != nil {
+		log.Printf("Coordination heartbeat failed: %v", err)
+		return
+	}
+
+	members, err := c.backend.Members(ctx, c.ttl)
+	if err != nil {
+		log.Printf("Failed to list coordination members: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	changed := fmt.Sprint(members) != fmt.Sprint(c.members)
+	c.members = members
+	c.mu.Unlock()
+
+	if changed {
+		log.Printf("Coordination members: %v", members)
+	}
+}
+
+// ShouldRun reports whether this instance runs the job's run scheduled at the
+// given time. If the backend cannot be reached the job runs locally, since a
+// duplicate backup is preferable to a missing one.
+func (c *Coordinator) ShouldRun(jobName string, scheduledAt time.Time) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+
+	members, err := c.backend.Members(ctx, c.ttl)
+	if err != nil {
+		log.Printf("Coordination unavailable, running job %s locally: %v", jobName, err)
+		return true
+	}
+
+	if owner := Owner(jobName, append(members, c.instanceID)); owner != c.instanceID {
+		log.Printf("Skipping backup job %s: assigned to instance %s", jobName, owner)
+		return false
+	}
+
+	claimed, err := c.backend.Claim(ctx, jobName, scheduledAt.Truncate(time.Minute), c.instanceID)
+	if err != nil {
+		log.Printf("Coordination unavailable, running job %s locally: %v", jobName, err)
+		return true
+	}
+	if !claimed {
+		log.Printf("Skipping backup job %s: the run was already claimed by another instance", jobName)
+	}
+	return claimed
+}
+
+// Owner picks the member that runs a job, the one with the highest hash of
+// member and job name
+func Owner(jobName string, members []string) string {
+	var owner string
+	var best uint64
+	for _, member := range members {
+		h := fnv.New64a()
+		h.Write([]byte(member))
+		h.Write([]byte{0})
+		h.Write([]byte(jobName))
+		score := h.Sum64()
+		if owner == "" || score > best || (score == best && member < owner) {
+			owner, best = member, score
+		}
+	}
+	return owner
+}
@@ -0,0 +1,131 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryBackend shares membership and claims between coordinators in a test
+type memoryBackend struct {
+	seen   map[string]time.Time
+	claims map[string]string
+	err    error
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{seen: make(map[string]time.Time), claims: make(map[string]string)}
+}
+
+func (m *memoryBackend) Heartbeat(_ context.Context, instanceID string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.seen[instanceID] = time.Now()
+	return nil
+}
+
+func (m *memoryBackend) Members(_ context.Context, ttl time.Duration) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var members []string
+	for id, seen := range m.seen {
+		if time.Since(seen) < ttl {
+			members = append(members, id)
+		}
+	}
+	return members, nil
+}
+
+func (m *memoryBackend) Claim(_ context.Context, jobName string, scheduledAt time.Time, instanceID string) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	key := fmt.Sprintf("%s@%d", jobName, scheduledAt.Unix())
+	if _, ok := m.claims[key]; ok {
+		return false, nil
+	}
+	m.claims[key] = instanceID
+	return true, nil
+}
+
+func (m *memoryBackend) Leave(_ context.Context, instanceID string) error {
+	delete(m.seen, instanceID)
+	return nil
+}
+
+func TestOwnerIsStable(t *testing.T) {
+	members := []string{"node-a", "node-b", "node-c"}
+	jobs := []string{"orders", "billing", "wiki", "grafana", "consul", "keycloak"}
+
+	for _, job := range jobs {
+		owner := Owner(job, members)
+		assert.Contains(t, members, owner)
+		assert.Equal(t, owner, Owner(job, []string{"node-c", "node-a", "node-b"}), "owner must not depend on member order")
+
+		// Removing an instance that does not own the job keeps the assignment
+		for _, gone := range members {
+			if gone == owner {
+				continue
+			}
+			var remaining []string
+			for _, member := range members {
+				if member != gone {
+					remaining = append(remaining, member)
+				}
+			}
+			assert.Equal(t, owner, Owner(job, remaining))
+		}
+	}
+	assert.Empty(t, Owner("orders", nil))
+}
+
+func TestShouldRun(t *testing.T) {
+	backend := newMemoryBackend()
+	ctx := context.Background()
+
+	nodes := map[string]*Coordinator{}
+	for _, id := range []string{"node-a", "node-b", "node-c"} {
+		nodes[id] = newCoordinator(backend, id, time.Second, time.Minute)
+		assert.NoError(t, backend.Heartbeat(ctx, id))
+	}
+
+	at := time.Date(2026, 10, 16, 2, 0, 0, 0, time.UTC)
+	for _, job := range []string{"orders", "billing", "wiki"} {
+		var runners []string
+		for id, node := range nodes {
+			if node.ShouldRun(job, at) {
+				runners = append(runners, id)
+			}
+		}
+		assert.Len(t, runners, 1, "job %s must run on exactly one instance", job)
+	}
+
+	// The owner goes away, another instance takes over the next run
+	owner := Owner("orders", []string{"node-a", "node-b", "node-c"})
+	assert.NoError(t, backend.Leave(ctx, owner))
+	delete(nodes, owner)
+
+	next := at.Add(24 * time.Hour)
+	var runners []string
+	for id, node := range nodes {
+		if node.ShouldRun("orders", next) {
+			runners = append(runners, id)
+		}
+	}
+	assert.Len(t, runners, 1)
+	assert.NotEqual(t, owner, runners[0])
+
+	// A run that was already claimed is not run again
+	assert.False(t, nodes[runners[0]].ShouldRun("orders", next.Add(30*time.Second)))
+
+	// Without a reachable backend every instance runs its jobs
+	backend.err = fmt.Errorf("connection refused")
+	for _, node := range nodes {
+		assert.True(t, node.ShouldRun("orders", next.Add(time.Hour)))
+	}
+}
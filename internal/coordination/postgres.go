@@ -0,0 +1,140 @@
+package coordination
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS backmeup_members (
+	instance_id text PRIMARY KEY,
+	last_seen timestamptz NOT NULL
+);
+CREATE TABLE IF NOT EXISTS backmeup_run_claims (
+	job_name text NOT NULL,
+	scheduled_at timestamptz NOT NULL,
+	instance_id text NOT NULL,
+	PRIMARY KEY (job_name, scheduled_at)
+);
+`
+
+// postgresBackend keeps coordination state in two tables, accessed with psql
+type postgresBackend struct {
+	cfg config.CoordinationPostgresConfig
+
+	schemaMu sync.Mutex
+	schema   bool // Set once the tables exist, so that a failure is retried
+}
+
+func newPostgresBackend(cfg config.CoordinationPostgresConfig) *postgresBackend {
+	return &postgresBackend{cfg: cfg}
+}
+
+// query runs SQL through psql's stdin so that :'name' variables are quoted by psql
+func (p *postgresBackend) query(ctx context.Context, sql string, vars map[string]string) (string, error) {
+	port := p.cfg.Port
+	if port == "" {
+		port = "5432"
+	}
+
+	args := []string{"-h", p.cfg.Host, "-p", port, "-d", p.cfg.Database,
+		"--no-password", "-X", "-q", "-At", "-v", "ON_ERROR_STOP=1"}
+	if p.cfg.User != "" {
+		args = append(args, "-U", p.cfg.User)
+	}
+	for name, value := range vars {
+		args = append(args, "-v", name+"="+value)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "psql", args...)
+	cmd.Env = os.Environ()
+	if p.cfg.Password != "" {
+		cmd.Env = append(cmd.Env, "PGPASSWORD="+p.cfg.Password)
+	}
+	cmd.Stdin = strings.NewReader(sql)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("psql failed: %w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// ensureSchema creates the tables on first use. A database that cannot be
+// reached is tried again on the next call.
+func (p *postgresBackend) ensureSchema(ctx context.Context) error {
+	p.schemaMu.Lock()
+	defer p.schemaMu.Unlock()
+
+	if p.schema {
+		return nil
+	}
+	if _, err := p.query(ctx, postgresSchema, nil); err != nil {
+		return err
+	}
+	p.schema = true
+	return nil
+}
+
+func (p *postgresBackend) Heartbeat(ctx context.Context, instanceID string) error {
+	if err := p.ensureSchema(ctx); err != nil {
+		return err
+	}
+
+	_, err := p.query(ctx, `
+INSERT INTO backmeup_members (instance_id, last_seen) VALUES (:'id', now())
+	ON CONFLICT (instance_id) DO UPDATE SET last_seen = now();
+DELETE FROM backmeup_run_claims WHERE scheduled_at < now() - interval '7 days';
+`, map[string]string{"id": instanceID})
+	return err
+}
+
+func (p *postgresBackend) Members(ctx context.Context, ttl time.Duration) ([]string, error) {
+	if err := p.ensureSchema(ctx); err != nil {
+		return nil, err
+	}
+
+	output, err := p.query(ctx, `
+SELECT instance_id FROM backmeup_members
+	WHERE last_seen > now() - make_interval(secs => :ttl) ORDER BY instance_id;
+`, map[string]string{"ttl": strconv.FormatFloat(ttl.Seconds(), 'f', -1, 64)})
+	if err != nil {
+		return nil, err
+	}
+	if output == "" {
+		return nil, nil
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+func (p *postgresBackend) Claim(ctx context.Context, jobName string, scheduledAt time.Time, instanceID string) (bool, error) {
+	if err := p.ensureSchema(ctx); err != nil {
+		return false, err
+	}
+
+	output, err := p.query(ctx, `
+INSERT INTO backmeup_run_claims (job_name, scheduled_at, instance_id) VALUES (:'job', to_timestamp(:at), :'id')
+	ON CONFLICT DO NOTHING RETURNING instance_id;
+`, map[string]string{"job": jobName, "at": strconv.FormatInt(scheduledAt.Unix(), 10), "id": instanceID})
+	if err != nil {
+		return false, err
+	}
+	return output == instanceID, nil
+}
+
+func (p *postgresBackend) Leave(ctx context.Context, instanceID string) error {
+	_, err := p.query(ctx, `DELETE FROM backmeup_members WHERE instance_id = :'id';`,
+		map[string]string{"id": instanceID})
+	return err
+}
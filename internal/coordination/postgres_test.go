@@ -0,0 +1,60 @@
+package coordination
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// fakePsql puts a psql on PATH that logs its calls, fails the first one and
+// prints output for the others
+func fakePsql(t *testing.T, output string) (calls func() int) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake psql is a shell script")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+cat > /dev/null
+echo call >> "` + log + `"
+if [ "$(wc -l < "` + log + `")" -eq 1 ]; then
+	echo "could not connect to server" >&2
+	exit 2
+fi
+echo "` + output + `"
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "psql"), []byte(script), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return func() int {
+		data, _ := os.ReadFile(log)
+		return strings.Count(string(data), "call")
+	}
+}
+
+func TestPostgresBackend_SchemaRetried(t *testing.T) {
+	calls := fakePsql(t, "node-1")
+	p := newPostgresBackend(config.CoordinationPostgresConfig{Host: "localhost", Database: "backmeup"})
+	ctx := context.Background()
+	at := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+
+	_, err := p.Claim(ctx, "orders", at, "node-1")
+	assert.ErrorContains(t, err, "could not connect to server")
+
+	claimed, err := p.Claim(ctx, "orders", at, "node-1")
+	require.NoError(t, err, "the schema is created again after a failure")
+	assert.True(t, claimed)
+	assert.Equal(t, 3, calls())
+
+	_, err = p.Claim(ctx, "orders", at, "node-1")
+	require.NoError(t, err)
+	assert.Equal(t, 4, calls(), "the schema is only created once it succeeded")
+}
@@ -0,0 +1,97 @@
+package coordination
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const (
+	redisMembersKey  = "backmeup:members"
+	redisClaimPrefix = "backmeup:run:"
+	redisClaimTTL    = 7 * 24 * time.Hour
+)
+
+// redisBackend keeps members in a sorted set scored by last heartbeat and run
+// claims in keys set with NX, accessed with redis-cli
+type redisBackend struct {
+	cfg config.CoordinationRedisConfig
+}
+
+func newRedisBackend(cfg config.CoordinationRedisConfig) *redisBackend {
+	return &redisBackend{cfg: cfg}
+}
+
+func (r *redisBackend) command(ctx context.Context, args ...string) (string, error) {
+	port := r.cfg.Port
+	if port == "" {
+		port = "6379"
+	}
+
+	cmdArgs := append([]string{"-h", r.cfg.Host, "-p", port, "-n", strconv.Itoa(r.cfg.DB), "--raw"}, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "redis-cli", cmdArgs...)
+	cmd.Env = os.Environ()
+	if r.cfg.Password != "" {
+		cmd.Env = append(cmd.Env, "REDISCLI_AUTH="+r.cfg.Password)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("redis-cli failed: %w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// redis-cli exits 0 on command errors and prints them on stdout
+	output := strings.TrimSpace(stdout.String())
+	if strings.HasPrefix(output, "ERR") || strings.HasPrefix(output, "WRONGTYPE") || strings.HasPrefix(output, "NOAUTH") {
+		return "", fmt.Errorf("redis-cli failed: %s", output)
+	}
+	return output, nil
+}
+
+func (r *redisBackend) Heartbeat(ctx context.Context, instanceID string) error {
+	now := time.Now()
+	if _, err := r.command(ctx, "ZADD", redisMembersKey, strconv.FormatInt(now.UnixMilli(), 10), instanceID); err != nil {
+		return err
+	}
+
+	// Drop instances that have not been seen for a long time
+	staleScore := strconv.FormatInt(now.Add(-redisClaimTTL).UnixMilli(), 10)
+	_, err := r.command(ctx, "ZREMRANGEBYSCORE", redisMembersKey, "-inf", "("+staleScore)
+	return err
+}
+
+func (r *redisBackend) Members(ctx context.Context, ttl time.Duration) ([]string, error) {
+	minScore := strconv.FormatInt(time.Now().Add(-ttl).UnixMilli(), 10)
+	output, err := r.command(ctx, "ZRANGEBYSCORE", redisMembersKey, "("+minScore, "+inf")
+	if err != nil {
+		return nil, err
+	}
+	if output == "" {
+		return nil, nil
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+func (r *redisBackend) Claim(ctx context.Context, jobName string, scheduledAt time.Time, instanceID string) (bool, error) {
+	key := fmt.Sprintf("%s%s:%d", redisClaimPrefix, jobName, scheduledAt.Unix())
+	output, err := r.command(ctx, "SET", key, instanceID, "NX", "EX", strconv.Itoa(int(redisClaimTTL.Seconds())))
+	if err != nil {
+		return false, err
+	}
+	return output == "OK", nil
+}
+
+func (r *redisBackend) Leave(ctx context.Context, instanceID string) error {
+	_, err := r.command(ctx, "ZREM", redisMembersKey, instanceID)
+	return err
+}
@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// JobRegistry is the part of the scheduler that discovered jobs are managed through
+type JobRegistry interface {
+	AddJob(jobConfig config.JobConfig, executor scheduler.BackupExecutor) error
+	RemoveJob(jobName string) error
+	HasJob(jobName string) bool
+}
+
+// ExecutorFactory creates the executor for a discovered job
+type ExecutorFactory func(jobConfig config.JobConfig) (scheduler.BackupExecutor, error)
+
+// discoverableTypes are the job types that discovery may create. They reach
+// their source over the network; jobs that read paths on the host (files,
+// sqlite and snapshot) must be configured in config.yml.
+var discoverableTypes = map[string]bool{
+	"postgres": true,
+	"mysql":    true,
+	"minio":    true,
+	"kafka":    true,
+	"consul":   true,
+	"keycloak": true,
+	"rest":     true,
+	"grafana":  true,
+}
+
+// checkDiscovered rejects what labels and annotations must not control, since
+// whoever can start a container or create a resource can set them
+func checkDiscovered(job config.JobConfig) error {
+	if !discoverableTypes[job.Type] {
+		return fmt.Errorf("%s jobs cannot be discovered, configure them in config.yml", job.Type)
+	}
+	if job.RunAs != "" {
+		return fmt.Errorf("run_as cannot be set on a discovered job")
+	}
+	if job.Owner != "" {
+		return fmt.Errorf("owner cannot be set on a discovered job")
+	}
+	return nil
+}
+
+// candidate is a job found by a provider, along with a fingerprint of the
+// definition it was built from so that changes can be detected
+type candidate struct {
+	source      string
+	job         config.JobConfig
+	fingerprint string
+}
+
+// reconciler keeps the jobs registered by one provider in line with what the
+// provider currently finds
+type reconciler struct {
+	cfg      *config.Config
+	registry JobRegistry
+	factory  ExecutorFactory
+	managed  map[string]string // Job name to the fingerprint it was built from
+}
+
+func newReconciler(cfg *config.Config, registry JobRegistry, factory ExecutorFactory) reconciler {
+	return reconciler{
+		cfg:      cfg,
+		registry: registry,
+		factory:  factory,
+		managed:  make(map[string]string),
+	}
+}
+
+// apply adds jobs for new candidates, removes jobs whose candidate is gone and
+// re-creates jobs whose definition changed. Jobs it did not register itself
+// are never touched.
+func (r *reconciler) apply(candidates []candidate) {
+	desired := make(map[string]candidate)
+	for _, c := range candidates {
+		if err := r.cfg.ValidateJob(c.job); err != nil {
+			log.Printf("Ignoring %s: %v", c.source, err)
+			continue
+		}
+		if err := checkDiscovered(c.job); err != nil {
+			log.Printf("Ignoring %s: %v", c.source, err)
+			continue
+		}
+		if other, ok := desired[c.job.Name]; ok {
+			log.Printf("Ignoring %s: job %s is already defined by %s", c.source, c.job.Name, other.source)
+			continue
+		}
+		desired[c.job.Name] = c
+	}
+
+	for name, fp := range r.managed {
+		if c, ok := desired[name]; ok && c.fingerprint == fp {
+			continue
+		}
+		if err := r.registry.RemoveJob(name); err != nil {
+			log.Printf("Error removing discovered job %s: %v", name, err)
+		} else {
+			log.Printf("Discovered job %s removed", name)
+		}
+		delete(r.managed, name)
+	}
+
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := r.managed[name]; ok {
+			continue
+		}
+		if r.registry.HasJob(name) {
+			log.Printf("Ignoring discovered job %s: a job with the same name is already configured", name)
+			continue
+		}
+
+		c := desired[name]
+		executor, err := r.factory(c.job)
+		if err != nil {
+			log.Printf("Error creating executor for discovered job %s: %v", name, err)
+			continue
+		}
+		if err := r.registry.AddJob(c.job, executor); err != nil {
+			log.Printf("Error adding discovered job %s: %v", name, err)
+			continue
+		}
+
+		r.managed[name] = c.fingerprint
+		log.Printf("Discovered job %s (%s) added from %s with schedule %s", name, c.job.Type, c.source, c.job.DescribeSchedule())
+	}
+}
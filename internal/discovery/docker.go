@@ -0,0 +1,306 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const (
+	labelPrefix           = "backmeup."
+	labelEnable           = labelPrefix + "enable"
+	defaultDockerEndpoint = "unix:///var/run/docker.sock"
+	defaultPollInterval   = 30 * time.Second
+)
+
+// container is the subset of the Docker container list response that discovery uses
+type container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// Docker registers a job for every running container labelled
+// backmeup.enable=true and removes it again when the container goes away
+type Docker struct {
+	reconciler
+	interval time.Duration
+	list     func(ctx context.Context) ([]container, error)
+}
+
+// NewDocker creates a Docker discovery provider from the configuration
+func NewDocker(cfg *config.Config, registry JobRegistry, factory ExecutorFactory) (*Docker, error) {
+	dockerCfg := cfg.Discovery.Docker
+
+	endpoint := dockerCfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultDockerEndpoint
+	}
+	client, baseURL, err := dockerClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := dockerCfg.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	d := &Docker{
+		reconciler: newReconciler(cfg, registry, factory),
+		interval:   interval,
+	}
+	d.list = func(ctx context.Context) ([]container, error) {
+		return listContainers(ctx, client, baseURL)
+	}
+	return d, nil
+}
+
+// dockerClient returns an HTTP client and base URL for a unix://, tcp:// or http(s):// endpoint
+func dockerClient(endpoint string) (*http.Client, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid docker endpoint %s: %w", endpoint, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &http.Client{Transport: transport, Timeout: 30 * time.Second}, "http://docker", nil
+	case "tcp":
+		return &http.Client{Timeout: 30 * time.Second}, "http://" + u.Host, nil
+	case "http", "https":
+		return &http.Client{Timeout: 30 * time.Second}, strings.TrimSuffix(endpoint, "/"), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported docker endpoint scheme: %s", u.Scheme)
+	}
+}
+
+func listContainers(ctx context.Context, client *http.Client, baseURL string) ([]container, error) {
+	filters := url.QueryEscape(fmt.Sprintf(`{"label":["%s=true"]}`, labelEnable))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/containers/json?filters="+filters, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to list containers: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var containers []container
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("failed to decode container list: %w", err)
+	}
+	return containers, nil
+}
+
+// Run reconciles the scheduled jobs with the running containers until the
+// context is cancelled
+func (d *Docker) Run(ctx context.Context) {
+	log.Printf("Docker discovery started, polling every %s", d.interval)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.reconcile(ctx); err != nil {
+			log.Printf("Docker discovery failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile builds a job for every labelled container and applies the result
+// to the scheduler. Existing jobs are kept if the container list cannot be
+// fetched.
+func (d *Docker) reconcile(ctx context.Context) error {
+	containers, err := d.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	candidates := make([]candidate, 0, len(containers))
+	for _, c := range containers {
+		name := containerName(c)
+		job, err := JobFromLabels(name, c.Labels)
+		if err != nil {
+			log.Printf("Ignoring container %s: %v", name, err)
+			continue
+		}
+		candidates = append(candidates, candidate{
+			source:      "container " + name,
+			job:         job,
+			fingerprint: fingerprint(labelPrefix, c.Labels),
+		})
+	}
+
+	d.apply(candidates)
+	return nil
+}
+
+func containerName(c container) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
+
+// fingerprint joins the keys starting with prefix and their values in a
+// stable order
+func fingerprint(prefix string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, labels[key])
+	}
+	return b.String()
+}
+
+// labelNode is one level of the job definition rebuilt from dotted label keys
+type labelNode struct {
+	value    string
+	leaf     bool
+	children map[string]*labelNode
+}
+
+// JobFromLabels builds a job from backmeup.* labels. Label keys mirror the
+// job's YAML keys, e.g. backmeup.postgres_config.user or
+// backmeup.retention_policy.value. The job name defaults to the container
+// name and a postgres host defaults to the container name.
+func JobFromLabels(containerName string, labels map[string]string) (config.JobConfig, error) {
+	return jobFromKeys(labelPrefix, labelEnable, labels, containerName, containerName)
+}
+
+// jobFromKeys builds a job from the dotted keys that follow prefix, ignoring
+// the enable key. The name and postgres host fall back to the given defaults.
+func jobFromKeys(prefix, enableKey string, keys map[string]string, defaultName, defaultHost string) (config.JobConfig, error) {
+	root := &labelNode{children: make(map[string]*labelNode)}
+	for key, value := range keys {
+		if !strings.HasPrefix(key, prefix) || key == enableKey {
+			continue
+		}
+		if err := root.set(strings.Split(strings.TrimPrefix(key, prefix), "."), value); err != nil {
+			return config.JobConfig{}, fmt.Errorf("label %s: %w", key, err)
+		}
+	}
+
+	var b strings.Builder
+	root.render(&b, 0)
+
+	var job config.JobConfig
+	if err := yaml.Unmarshal([]byte(b.String()), &job); err != nil {
+		return config.JobConfig{}, fmt.Errorf("invalid job definition: %w", err)
+	}
+
+	if job.Name == "" {
+		job.Name = defaultName
+	}
+	if job.Type == "postgres" && job.PostgresConfig != nil && job.PostgresConfig.Host == "" {
+		job.PostgresConfig.Host = defaultHost
+	}
+
+	return job, nil
+}
+
+func (n *labelNode) set(path []string, value string) error {
+	for _, part := range path {
+		if part == "" {
+			return fmt.Errorf("empty key segment")
+		}
+	}
+
+	node := n
+	for _, part := range path {
+		if node.leaf {
+			return fmt.Errorf("conflicts with another label")
+		}
+		child, ok := node.children[part]
+		if !ok {
+			child = &labelNode{children: make(map[string]*labelNode)}
+			node.children[part] = child
+		}
+		node = child
+	}
+	if node.leaf || len(node.children) > 0 {
+		return fmt.Errorf("conflicts with another label")
+	}
+
+	node.leaf = true
+	node.value = value
+	return nil
+}
+
+func (n *labelNode) render(b *strings.Builder, depth int) {
+	keys := make([]string, 0, len(n.children))
+	for key := range n.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	indent := strings.Repeat("  ", depth)
+	for _, key := range keys {
+		child := n.children[key]
+		if child.leaf {
+			fmt.Fprintf(b, "%s%s: %s\n", indent, strconv.Quote(key), scalar(child.value))
+			continue
+		}
+		fmt.Fprintf(b, "%s%s:\n", indent, strconv.Quote(key))
+		child.render(b, depth+1)
+	}
+}
+
+// scalar renders a label value as YAML. Numbers and booleans stay bare so they
+// decode into numeric and boolean fields, [a, b] is kept as a list and
+// everything else is quoted.
+func scalar(value string) string {
+	if value == "true" || value == "false" {
+		return value
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value
+	}
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		return value
+	}
+	return strconv.Quote(value)
+}
@@ -0,0 +1,157 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+type fakeRegistry struct {
+	jobs    map[string]config.JobConfig
+	added   []string
+	removed []string
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{jobs: make(map[string]config.JobConfig)}
+}
+
+func (r *fakeRegistry) AddJob(jobConfig config.JobConfig, _ scheduler.BackupExecutor) error {
+	r.jobs[jobConfig.Name] = jobConfig
+	r.added = append(r.added, jobConfig.Name)
+	return nil
+}
+
+func (r *fakeRegistry) RemoveJob(jobName string) error {
+	if _, ok := r.jobs[jobName]; !ok {
+		return fmt.Errorf("job %s is not scheduled", jobName)
+	}
+	delete(r.jobs, jobName)
+	r.removed = append(r.removed, jobName)
+	return nil
+}
+
+func (r *fakeRegistry) HasJob(jobName string) bool {
+	_, ok := r.jobs[jobName]
+	return ok
+}
+
+type nopExecutor struct{}
+
+func (nopExecutor) Execute(context.Context) error { return nil }
+
+func nopFactory(config.JobConfig) (scheduler.BackupExecutor, error) {
+	return nopExecutor{}, nil
+}
+
+func postgresLabels(schedule string) map[string]string {
+	return map[string]string{
+		"backmeup.enable":                   "true",
+		"backmeup.type":                     "postgres",
+		"backmeup.schedule":                 schedule,
+		"backmeup.postgres_config.user":     "postgres",
+		"backmeup.postgres_config.port":     "5432",
+		"backmeup.postgres_config.discover": "true",
+		"backmeup.retention_policy.type":    "count",
+		"backmeup.retention_policy.value":   "7",
+		"com.docker.compose.service":        "db",
+	}
+}
+
+func TestJobFromLabels(t *testing.T) {
+	job, err := JobFromLabels("orders-db", postgresLabels("0 2 * * *"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "orders-db", job.Name)
+	assert.Equal(t, "postgres", job.Type)
+	assert.Equal(t, "0 2 * * *", job.Schedule)
+	require.NotNil(t, job.PostgresConfig)
+	assert.Equal(t, "orders-db", job.PostgresConfig.Host)
+	assert.Equal(t, "5432", job.PostgresConfig.Port)
+	assert.True(t, job.PostgresConfig.Discover)
+	assert.Equal(t, "count", job.RetentionPolicy.Type)
+	assert.Equal(t, 7, job.RetentionPolicy.Value)
+
+	labels := postgresLabels("0 2 * * *")
+	labels["backmeup.name"] = "orders"
+	labels["backmeup.postgres_config.exclude_databases"] = "[scratch, tmp]"
+	job, err = JobFromLabels("orders-db", labels)
+	require.NoError(t, err)
+	assert.Equal(t, "orders", job.Name)
+	assert.Equal(t, []string{"scratch", "tmp"}, job.PostgresConfig.ExcludeDatabases)
+
+	_, err = JobFromLabels("orders-db", map[string]string{
+		"backmeup.postgres_config":      "db",
+		"backmeup.postgres_config.user": "postgres",
+	})
+	assert.Error(t, err)
+}
+
+func TestDockerReconcile(t *testing.T) {
+	cfg := &config.Config{
+		Storage:   config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: "/backups"}},
+		Discovery: config.DiscoveryConfig{Docker: config.DockerDiscoveryConfig{Enabled: true}},
+	}
+	registry := newFakeRegistry()
+	registry.jobs["static"] = config.JobConfig{Name: "static"}
+
+	var containers []container
+	d := &Docker{
+		reconciler: newReconciler(cfg, registry, nopFactory),
+		list: func(context.Context) ([]container, error) {
+			return containers, nil
+		},
+	}
+	ctx := context.Background()
+
+	staticLabels := postgresLabels("0 2 * * *")
+	staticLabels["backmeup.name"] = "static"
+	invalidLabels := postgresLabels("0 2 * * *")
+	delete(invalidLabels, "backmeup.retention_policy.type")
+	escapeLabels := postgresLabels("0 2 * * *")
+	escapeLabels["backmeup.name"] = "../orders"
+	sqliteLabels := map[string]string{
+		"backmeup.enable":                 "true",
+		"backmeup.type":                   "sqlite",
+		"backmeup.schedule":               "0 2 * * *",
+		"backmeup.sqlite_config.path":     "/etc/shadow",
+		"backmeup.retention_policy.type":  "count",
+		"backmeup.retention_policy.value": "7",
+	}
+	rootLabels := postgresLabels("0 2 * * *")
+	rootLabels["backmeup.run_as"] = "root"
+	containers = []container{
+		{ID: "a", Names: []string{"/orders-db"}, Labels: postgresLabels("0 2 * * *")},
+		{ID: "b", Names: []string{"/static-db"}, Labels: staticLabels},
+		{ID: "c", Names: []string{"/broken-db"}, Labels: invalidLabels},
+		{ID: "d", Names: []string{"/escape-db"}, Labels: escapeLabels},
+		{ID: "e", Names: []string{"/app"}, Labels: sqliteLabels},
+		{ID: "f", Names: []string{"/root-db"}, Labels: rootLabels},
+	}
+	require.NoError(t, d.reconcile(ctx))
+	assert.Equal(t, []string{"orders-db"}, registry.added)
+	assert.True(t, registry.HasJob("static"))
+
+	require.NoError(t, d.reconcile(ctx))
+	assert.Equal(t, []string{"orders-db"}, registry.added, "unchanged containers are not re-added")
+
+	containers = []container{{ID: "a", Names: []string{"/orders-db"}, Labels: postgresLabels("0 3 * * *")}}
+	require.NoError(t, d.reconcile(ctx))
+	assert.Equal(t, []string{"orders-db"}, registry.removed)
+	assert.Equal(t, "0 3 * * *", registry.jobs["orders-db"].Schedule)
+
+	containers = nil
+	require.NoError(t, d.reconcile(ctx))
+	assert.False(t, registry.HasJob("orders-db"))
+	assert.True(t, registry.HasJob("static"), "configured jobs are never removed")
+
+	d.list = func(context.Context) ([]container, error) {
+		return nil, fmt.Errorf("docker unavailable")
+	}
+	assert.Error(t, d.reconcile(ctx))
+}
@@ -0,0 +1,277 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const (
+	annotationPrefix     = "backmeup.io/"
+	annotationEnable     = annotationPrefix + "enable"
+	backupJobsPath       = "/apis/backmeup.io/v1alpha1"
+	statefulSetsPath     = "/apis/apps/v1"
+	serviceAccountDir    = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultTokenFile     = serviceAccountDir + "/token"
+	defaultCAFile        = serviceAccountDir + "/ca.crt"
+	kubernetesAPITimeout = 30 * time.Second
+)
+
+type objectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// backupJob is a BackupJob custom resource. Its spec uses the same keys as a
+// job in config.yml.
+type backupJob struct {
+	Metadata objectMeta      `json:"metadata"`
+	Spec     json.RawMessage `json:"spec"`
+}
+
+type statefulSet struct {
+	Metadata objectMeta `json:"metadata"`
+	Spec     struct {
+		ServiceName string `json:"serviceName"`
+	} `json:"spec"`
+}
+
+// Kubernetes registers a job for every BackupJob resource and every
+// StatefulSet annotated with backmeup.io/enable=true, and removes it again
+// when the resource is deleted
+type Kubernetes struct {
+	reconciler
+	client     *http.Client
+	apiServer  string
+	tokenFile  string
+	namespace  string
+	interval   time.Duration
+	crdMissing bool
+}
+
+// NewKubernetes creates a Kubernetes discovery provider from the configuration.
+// Without an explicit API server it uses the in-cluster service account.
+func NewKubernetes(cfg *config.Config, registry JobRegistry, factory ExecutorFactory) (*Kubernetes, error) {
+	k8sCfg := cfg.Discovery.Kubernetes
+
+	apiServer := k8sCfg.APIServer
+	if apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("kubernetes api_server must be set when not running in a cluster")
+		}
+		apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+
+	tokenFile := k8sCfg.TokenFile
+	if tokenFile == "" && k8sCfg.APIServer == "" {
+		tokenFile = defaultTokenFile
+	}
+
+	caFile := k8sCfg.CAFile
+	if caFile == "" && k8sCfg.APIServer == "" {
+		caFile = defaultCAFile
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubernetes CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in kubernetes CA file %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	interval := k8sCfg.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	return &Kubernetes{
+		reconciler: newReconciler(cfg, registry, factory),
+		client:     &http.Client{Transport: transport, Timeout: kubernetesAPITimeout},
+		apiServer:  strings.TrimSuffix(apiServer, "/"),
+		tokenFile:  tokenFile,
+		namespace:  k8sCfg.Namespace,
+		interval:   interval,
+	}, nil
+}
+
+// Run reconciles the scheduled jobs with the cluster state until the context
+// is cancelled
+func (k *Kubernetes) Run(ctx context.Context) {
+	log.Printf("Kubernetes discovery started, polling every %s", k.interval)
+
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := k.reconcile(ctx); err != nil {
+			log.Printf("Kubernetes discovery failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile builds a job for every BackupJob and annotated StatefulSet and
+// applies the result to the scheduler. Existing jobs are kept if either list
+// cannot be fetched.
+func (k *Kubernetes) reconcile(ctx context.Context) error {
+	backupJobs, err := k.listBackupJobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := k.get(ctx, k.resourcePath(statefulSetsPath, "statefulsets"))
+	if err != nil {
+		return err
+	}
+	var statefulSets struct {
+		Items []statefulSet `json:"items"`
+	}
+	if err := json.Unmarshal(body, &statefulSets); err != nil {
+		return fmt.Errorf("failed to decode StatefulSet list: %w", err)
+	}
+
+	candidates := make([]candidate, 0, len(backupJobs)+len(statefulSets.Items))
+	for _, item := range backupJobs {
+		source := fmt.Sprintf("BackupJob %s/%s", item.Metadata.Namespace, item.Metadata.Name)
+
+		var job config.JobConfig
+		if err := yaml.Unmarshal(item.Spec, &job); err != nil {
+			log.Printf("Ignoring %s: invalid spec: %v", source, err)
+			continue
+		}
+		if job.Name == "" {
+			job.Name = item.Metadata.Namespace + "-" + item.Metadata.Name
+		}
+
+		candidates = append(candidates, candidate{
+			source:      source,
+			job:         job,
+			fingerprint: string(item.Spec),
+		})
+	}
+
+	for _, item := range statefulSets.Items {
+		if item.Metadata.Annotations[annotationEnable] != "true" {
+			continue
+		}
+		source := fmt.Sprintf("StatefulSet %s/%s", item.Metadata.Namespace, item.Metadata.Name)
+
+		service := item.Spec.ServiceName
+		if service == "" {
+			service = item.Metadata.Name
+		}
+		job, err := jobFromKeys(annotationPrefix, annotationEnable, item.Metadata.Annotations,
+			item.Metadata.Namespace+"-"+item.Metadata.Name, service+"."+item.Metadata.Namespace+".svc")
+		if err != nil {
+			log.Printf("Ignoring %s: %v", source, err)
+			continue
+		}
+
+		candidates = append(candidates, candidate{
+			source:      source,
+			job:         job,
+			fingerprint: fingerprint(annotationPrefix, item.Metadata.Annotations),
+		})
+	}
+
+	k.apply(candidates)
+	return nil
+}
+
+// listBackupJobs lists BackupJob resources, treating a missing CRD as an empty list
+func (k *Kubernetes) listBackupJobs(ctx context.Context) ([]backupJob, error) {
+	body, err := k.get(ctx, k.resourcePath(backupJobsPath, "backupjobs"))
+	if errors.Is(err, errNotFound) {
+		if !k.crdMissing {
+			log.Printf("BackupJob resources are not available, is the CRD installed? Only annotated StatefulSets are discovered")
+			k.crdMissing = true
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Items []backupJob `json:"items"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode BackupJob list: %w", err)
+	}
+
+	k.crdMissing = false
+	return list.Items, nil
+}
+
+func (k *Kubernetes) resourcePath(groupVersion, resource string) string {
+	if k.namespace != "" {
+		return fmt.Sprintf("%s/namespaces/%s/%s", groupVersion, k.namespace, resource)
+	}
+	return fmt.Sprintf("%s/%s", groupVersion, resource)
+}
+
+var errNotFound = errors.New("resource not found")
+
+// get fetches a path from the API server, authenticating with the service
+// account token when one is configured
+func (k *Kubernetes) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.apiServer+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if k.tokenFile != "" {
+		// Read on every request since projected tokens are rotated
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kubernetes token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes request %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubernetes response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("kubernetes request %s failed: status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
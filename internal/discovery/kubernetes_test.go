@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const backupJobList = `{"items":[{
+	"metadata":{"name":"orders","namespace":"prod"},
+	"spec":{
+		"type":"postgres",
+		"schedule":"0 2 * * *",
+		"postgres_config":{"host":"orders-db.prod.svc","database":"orders"},
+		"retention_policy":{"type":"count","value":7}
+	}
+},{
+	"metadata":{"name":"escape","namespace":"prod"},
+	"spec":{
+		"name":"../../etc",
+		"type":"postgres",
+		"schedule":"0 2 * * *",
+		"postgres_config":{"host":"orders-db.prod.svc","database":"orders"},
+		"retention_policy":{"type":"count","value":7}
+	}
+},{
+	"metadata":{"name":"host-files","namespace":"prod"},
+	"spec":{
+		"type":"files",
+		"schedule":"0 2 * * *",
+		"files_config":{"paths":["/etc"]},
+		"retention_policy":{"type":"count","value":7}
+	}
+},{
+	"metadata":{"name":"as-root","namespace":"prod"},
+	"spec":{
+		"type":"postgres",
+		"schedule":"0 2 * * *",
+		"run_as":"root",
+		"postgres_config":{"host":"orders-db.prod.svc","database":"orders"},
+		"retention_policy":{"type":"count","value":7}
+	}
+}]}`
+
+const statefulSetList = `{"items":[
+	{
+		"metadata":{"name":"billing-db","namespace":"prod","annotations":{
+			"backmeup.io/enable":"true",
+			"backmeup.io/type":"postgres",
+			"backmeup.io/schedule":"0 3 * * *",
+			"backmeup.io/postgres_config.discover":"true",
+			"backmeup.io/retention_policy.type":"days",
+			"backmeup.io/retention_policy.value":"14"
+		}},
+		"spec":{"serviceName":"billing"}
+	},
+	{
+		"metadata":{"name":"cache","namespace":"prod","annotations":{"backmeup.io/type":"postgres"}},
+		"spec":{}
+	}
+]}`
+
+func TestKubernetesReconcile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret-token\n"), 0600))
+
+	crdInstalled := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/apis/backmeup.io/v1alpha1/namespaces/prod/backupjobs":
+			if !crdInstalled {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(backupJobList))
+		case "/apis/apps/v1/namespaces/prod/statefulsets":
+			w.Write([]byte(statefulSetList))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Storage: config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: "/backups"}},
+		Discovery: config.DiscoveryConfig{Kubernetes: config.KubernetesDiscoveryConfig{
+			Enabled:   true,
+			Namespace: "prod",
+			APIServer: server.URL,
+			TokenFile: tokenFile,
+		}},
+	}
+	registry := newFakeRegistry()
+
+	k, err := NewKubernetes(cfg, registry, nopFactory)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, k.reconcile(ctx))
+	// Unsafe names, host paths and run_as are rejected
+	assert.ElementsMatch(t, []string{"prod-orders", "prod-billing-db"}, registry.added)
+	assert.Equal(t, "orders", registry.jobs["prod-orders"].PostgresConfig.Database)
+
+	billing := registry.jobs["prod-billing-db"]
+	assert.Equal(t, "billing.prod.svc", billing.PostgresConfig.Host)
+	assert.True(t, billing.PostgresConfig.Discover)
+	assert.Equal(t, 14, billing.RetentionPolicy.Value)
+
+	crdInstalled = false
+	require.NoError(t, k.reconcile(ctx))
+	assert.Equal(t, []string{"prod-orders"}, registry.removed)
+	assert.True(t, registry.HasJob("prod-billing-db"))
+
+	k.tokenFile = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, k.reconcile(ctx))
+	assert.True(t, registry.HasJob("prod-billing-db"), "jobs are kept when the API is unreachable")
+}
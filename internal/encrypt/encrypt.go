@@ -0,0 +1,112 @@
+// Package encrypt encrypts backups to public keys as they are written, with
+// gpg or age
+package encrypt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// TempPrefix is the prefix of the directories gpg keeps its state in while
+// it encrypts
+const TempPrefix = "backmeup-gpg-"
+
+// NewWriter returns a writer that encrypts what is written to it into w with
+// the configured encryption. Close flushes the encrypted stream but does not
+// close w. gpg streams are written by the gpg tool, which must be installed,
+// and stop when ctx is done. age is built in.
+func NewWriter(ctx context.Context, w io.Writer, cfg config.EncryptionConfig) (io.WriteCloser, error) {
+	switch cfg.EncryptionType() {
+	case config.EncryptionGPG:
+		return newGPGWriter(ctx, w, cfg.PublicKeyFile)
+	case config.EncryptionAge:
+		recipients, err := ageRecipients(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return age.Encrypt(w, recipients...)
+	}
+	return nil, fmt.Errorf("unsupported encryption type: %s", cfg.Type)
+}
+
+// ageRecipients parses the recipients of an age encryption, those listed in
+// the configuration and those of the recipients file
+func ageRecipients(cfg config.EncryptionConfig) ([]age.Recipient, error) {
+	recipients := make([]age.Recipient, 0, len(cfg.Recipients))
+	for _, key := range cfg.Recipients {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %s: %w", key, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if cfg.RecipientsFile != "" {
+		f, err := os.Open(cfg.RecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age recipients: %w", err)
+		}
+		defer f.Close()
+		listed, err := age.ParseRecipients(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipients file %s: %w", cfg.RecipientsFile, err)
+		}
+		recipients = append(recipients, listed...)
+	}
+	return recipients, nil
+}
+
+// gpgWriter pipes what is written to it through gpg, which runs with a
+// temporary home directory so that it never touches a keyring
+type gpgWriter struct {
+	stdin  io.WriteCloser
+	cmd    *exec.Cmd
+	stderr strings.Builder
+	home   string
+}
+
+func newGPGWriter(ctx context.Context, w io.Writer, keyFile string) (*gpgWriter, error) {
+	home, err := os.MkdirTemp("", TempPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gpg home directory: %w", err)
+	}
+
+	g := &gpgWriter{home: home}
+	g.cmd = exec.CommandContext(ctx, "gpg", "--batch", "--no-tty", "--quiet", "--homedir", home,
+		"--trust-model", "always", "--recipient-file", keyFile, "--encrypt")
+	g.cmd.Stdout = w
+	g.cmd.Stderr = &g.stderr
+	if g.stdin, err = g.cmd.StdinPipe(); err != nil {
+		os.RemoveAll(home)
+		return nil, err
+	}
+	if err := g.cmd.Start(); err != nil {
+		os.RemoveAll(home)
+		return nil, fmt.Errorf("failed to start gpg: %w", err)
+	}
+	return g, nil
+}
+
+func (g *gpgWriter) Write(p []byte) (int, error) {
+	n, err := g.stdin.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("gpg failed: %w", err)
+	}
+	return n, nil
+}
+
+func (g *gpgWriter) Close() error {
+	defer os.RemoveAll(g.home)
+	g.stdin.Close()
+	if err := g.cmd.Wait(); err != nil {
+		return fmt.Errorf("gpg failed: %w, stderr: %s", err, strings.TrimSpace(g.stderr.String()))
+	}
+	return nil
+}
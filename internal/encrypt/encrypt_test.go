@@ -0,0 +1,103 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+var dump = strings.Repeat("INSERT INTO orders VALUES (1, 'widget');\n", 1000)
+
+// newKey creates a key pair in a new keyring and returns the keyring and the
+// exported public key
+func newKey(t *testing.T) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	home := t.TempDir()
+	gpg := func(args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--batch", "--homedir", home}, args...)...)
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		return out
+	}
+	gpg("--passphrase", "", "--quick-gen-key", "Backups <backups@example.com>", "default", "default", "never")
+	keyFile := filepath.Join(t.TempDir(), "backups.asc")
+	require.NoError(t, os.WriteFile(keyFile, gpg("--armor", "--export", "backups@example.com"), 0644))
+	return home, keyFile
+}
+
+func TestGPG(t *testing.T) {
+	home, keyFile := newKey(t)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(context.Background(), &buf, config.EncryptionConfig{PublicKeyFile: keyFile})
+	require.NoError(t, err)
+	_, err = io.WriteString(w, dump)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.NotContains(t, buf.String(), "INSERT INTO")
+
+	cmd := exec.Command("gpg", "--batch", "--quiet", "--homedir", home, "--decrypt")
+	cmd.Stdin = &buf
+	data, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, dump, string(data))
+}
+
+func TestGPG_MissingKey(t *testing.T) {
+	newKey(t)
+
+	w, err := NewWriter(context.Background(), io.Discard, config.EncryptionConfig{PublicKeyFile: filepath.Join(t.TempDir(), "missing.asc")})
+	require.NoError(t, err)
+	io.WriteString(w, dump)
+	assert.ErrorContains(t, w.Close(), "gpg failed")
+}
+
+func TestAge(t *testing.T) {
+	inline, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	listed, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	recipientsFile := filepath.Join(t.TempDir(), "recipients.txt")
+	require.NoError(t, os.WriteFile(recipientsFile, []byte("# ops team\n"+listed.Recipient().String()+"\n"), 0644))
+
+	var buf bytes.Buffer
+	w, err := NewWriter(context.Background(), &buf, config.EncryptionConfig{Type: config.EncryptionAge,
+		Recipients: []string{inline.Recipient().String()}, RecipientsFile: recipientsFile})
+	require.NoError(t, err)
+	_, err = io.WriteString(w, dump)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.True(t, strings.HasPrefix(buf.String(), "age-encryption.org/v1"))
+
+	for _, identity := range []age.Identity{inline, listed} {
+		r, err := age.Decrypt(bytes.NewReader(buf.Bytes()), identity)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, dump, string(data), "every recipient can decrypt")
+	}
+}
+
+func TestAge_MissingRecipientsFile(t *testing.T) {
+	_, err := NewWriter(context.Background(), io.Discard, config.EncryptionConfig{Type: config.EncryptionAge,
+		RecipientsFile: filepath.Join(t.TempDir(), "missing.txt")})
+	assert.ErrorContains(t, err, "failed to read age recipients")
+}
+
+func TestUnsupported(t *testing.T) {
+	_, err := NewWriter(context.Background(), io.Discard, config.EncryptionConfig{Type: "rot13"})
+	assert.EqualError(t, err, "unsupported encryption type: rot13")
+}
@@ -0,0 +1,273 @@
+// Package failure classifies why a backup run failed, so that the run
+// history, metrics and exit codes can say more than "failed"
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Code is the class of a failure
+type Code string
+
+const (
+	CodeConfig      Code = "config"       // The job is misconfigured
+	CodeConnection  Code = "connection"   // The source could not be reached
+	CodeToolMissing Code = "tool_missing" // A dump tool is not installed
+	CodeStorageFull Code = "storage_full" // The backup did not fit in storage
+	CodeTimeout     Code = "timeout"      // The run exceeded its deadline
+	CodeVerify      Code = "verify"       // The backup does not match its source
+	CodeUnknown     Code = "unknown"      // Anything else
+)
+
+// ConfigError is a job configuration that cannot work, found when the job is
+// set up or run
+type ConfigError struct{ Err error }
+
+func (e *ConfigError) Error() string { return e.Err.Error() }
+func (e *ConfigError) Unwrap() error { return e.Err }
+func (e *ConfigError) Code() Code    { return CodeConfig }
+
+// ConnectionError is a database, bucket or API that could not be reached
+type ConnectionError struct{ Err error }
+
+func (e *ConnectionError) Error() string { return e.Err.Error() }
+func (e *ConnectionError) Unwrap() error { return e.Err }
+func (e *ConnectionError) Code() Code    { return CodeConnection }
+
+// ToolMissingError is an external program that is not installed
+type ToolMissingError struct{ Err error }
+
+func (e *ToolMissingError) Error() string { return e.Err.Error() }
+func (e *ToolMissingError) Unwrap() error { return e.Err }
+func (e *ToolMissingError) Code() Code    { return CodeToolMissing }
+
+// StorageFullError is a backup that could not be written for lack of space
+type StorageFullError struct{ Err error }
+
+func (e *StorageFullError) Error() string { return e.Err.Error() }
+func (e *StorageFullError) Unwrap() error { return e.Err }
+func (e *StorageFullError) Code() Code    { return CodeStorageFull }
+
+// TimeoutError is a run that was cut short by its deadline
+type TimeoutError struct{ Err error }
+
+func (e *TimeoutError) Error() string { return e.Err.Error() }
+func (e *TimeoutError) Unwrap() error { return e.Err }
+func (e *TimeoutError) Code() Code    { return CodeTimeout }
+
+// VerifyError is a backup that was written but does not match its source,
+// such as a dump missing tables
+type VerifyError struct{ Err error }
+
+func (e *VerifyError) Error() string { return e.Err.Error() }
+func (e *VerifyError) Unwrap() error { return e.Err }
+func (e *VerifyError) Code() Code    { return CodeVerify }
+
+// ExcerptLines is how many of the last lines a failing tool printed are kept
+// with its error
+const ExcerptLines = 20
+
+// OutputError is an external tool that failed, with the last lines it wrote
+// to stderr. It leaves the class of the failure to the error it wraps.
+type OutputError struct {
+	Err   error
+	Lines []string
+}
+
+func (e *OutputError) Error() string { return e.Err.Error() }
+func (e *OutputError) Unwrap() error { return e.Err }
+
+// Excerpt returns the output of the first failed tool err wraps, or nil when
+// it wraps none
+func Excerpt(err error) []string {
+	var output *OutputError
+	if errors.As(err, &output) {
+		return output.Lines
+	}
+	return nil
+}
+
+// Artifact is the outcome of one of several artifacts a run writes, such as
+// one database of a multi-database dump
+type Artifact struct {
+	Name string
+	Err  error // Nil when the artifact was written
+}
+
+// ArtifactsError is a run that failed to write some or all of its artifacts
+type ArtifactsError struct {
+	Kind      string // What the artifacts are, such as "databases"
+	Artifacts []Artifact
+}
+
+// Artifacts returns an ArtifactsError when any of the artifacts failed
+func Artifacts(kind string, artifacts []Artifact) error {
+	for _, artifact := range artifacts {
+		if artifact.Err != nil {
+			return &ArtifactsError{Kind: kind, Artifacts: artifacts}
+		}
+	}
+	return nil
+}
+
+func (e *ArtifactsError) Error() string {
+	failed := e.Unwrap()
+	return fmt.Sprintf("%d of %d %s failed: %v", len(failed), len(e.Artifacts), e.Kind, errors.Join(failed...))
+}
+
+// Unwrap returns the errors of the failed artifacts, prefixed with their names
+func (e *ArtifactsError) Unwrap() []error {
+	return failedArtifacts(e.Artifacts)
+}
+
+// Partial reports whether some of the artifacts were written
+func (e *ArtifactsError) Partial() bool {
+	for _, artifact := range e.Artifacts {
+		if artifact.Err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Code is the class the failed artifacts share, or CodeUnknown when they
+// failed for different reasons
+func (e *ArtifactsError) Code() Code {
+	return sharedCode(e.Artifacts)
+}
+
+// ReplicationError is a run whose backups were stored but could not be copied
+// to some of its job's storage destinations. The run counts as partial.
+type ReplicationError struct {
+	Destinations []Artifact
+}
+
+// Replication returns a ReplicationError when any of the copies to the
+// destinations failed
+func Replication(destinations []Artifact) error {
+	for _, destination := range destinations {
+		if destination.Err != nil {
+			return &ReplicationError{Destinations: destinations}
+		}
+	}
+	return nil
+}
+
+func (e *ReplicationError) Error() string {
+	failed := e.Unwrap()
+	return fmt.Sprintf("copy to %d of %d destinations failed: %v", len(failed), len(e.Destinations), errors.Join(failed...))
+}
+
+// Unwrap returns the errors of the failed copies, prefixed with the names of
+// their destinations
+func (e *ReplicationError) Unwrap() []error {
+	return failedArtifacts(e.Destinations)
+}
+
+// Code is the class the failed copies share, or CodeUnknown when they failed
+// for different reasons
+func (e *ReplicationError) Code() Code {
+	return sharedCode(e.Destinations)
+}
+
+// failedArtifacts returns the errors of the failed artifacts, prefixed with
+// their names
+func failedArtifacts(artifacts []Artifact) []error {
+	var errs []error
+	for _, artifact := range artifacts {
+		if artifact.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", artifact.Name, artifact.Err))
+		}
+	}
+	return errs
+}
+
+// sharedCode is the class of the failed artifacts, or CodeUnknown when they
+// failed for different reasons
+func sharedCode(artifacts []Artifact) Code {
+	var code Code
+	for _, artifact := range artifacts {
+		if artifact.Err == nil {
+			continue
+		}
+		c := CodeOf(artifact.Err)
+		if code != "" && c != code {
+			return CodeUnknown
+		}
+		code = c
+	}
+	if code == "" {
+		return CodeUnknown
+	}
+	return code
+}
+
+// coder is implemented by the typed errors of this package
+type coder interface {
+	Code() Code
+}
+
+// connectionMessages are what dump tools print when they cannot reach their
+// server, matched case-insensitively
+var connectionMessages = []string{
+	"connection refused",
+	"could not connect to server",
+	"connection to server at",
+	"can't connect to mysql server",
+	"could not translate host name",
+	"no route to host",
+	"connection reset by peer",
+	"network is unreachable",
+	"no such host",
+	"i/o timeout",
+}
+
+// CodeOf returns the class of err: the code of the outermost typed error it
+// wraps, or else a guess from well-known errors and tool output. It is empty
+// for a nil error.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+
+	var typed coder
+	if errors.As(err, &typed) {
+		return typed.Code()
+	}
+
+	var execErr *exec.Error
+	switch {
+	case errors.As(err, &execErr) || errors.Is(err, exec.ErrNotFound):
+		return CodeToolMissing
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, syscall.ENOSPC):
+		return CodeStorageFull
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return CodeTimeout
+		}
+		return CodeConnection
+	}
+
+	// Tools that log to stderr say why they failed there, not in the error
+	message := strings.ToLower(err.Error() + "\n" + strings.Join(Excerpt(err), "\n"))
+	if strings.Contains(message, "no space left on device") {
+		return CodeStorageFull
+	}
+	for _, m := range connectionMessages {
+		if strings.Contains(message, m) {
+			return CodeConnection
+		}
+	}
+	return CodeUnknown
+}
@@ -0,0 +1,96 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{"nil", nil, ""},
+		{"config", &ConfigError{Err: errors.New("unsupported job type: oracle")}, CodeConfig},
+		{"wrapped typed error", fmt.Errorf("upload failed: %w", &ConnectionError{Err: errors.New("dial")}), CodeConnection},
+		{"outermost typed error wins", &TimeoutError{Err: &ConnectionError{Err: errors.New("dial")}}, CodeTimeout},
+		{"missing tool", fmt.Errorf("pg_dump failed: %w", &exec.Error{Name: "pg_dump", Err: exec.ErrNotFound}), CodeToolMissing},
+		{"verify", fmt.Errorf("orders: %w", &VerifyError{Err: errors.New("table public.audit is missing from the dump")}), CodeVerify},
+		{"deadline", fmt.Errorf("mysqldump failed: %w", context.DeadlineExceeded), CodeTimeout},
+		{"disk full", &os.PathError{Op: "write", Path: "/backups/db.sql", Err: syscall.ENOSPC}, CodeStorageFull},
+		{"disk full in tool output", errors.New(`pg_dump failed: exit status 1, stderr: "could not write to output file: No space left on device"`), CodeStorageFull},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, CodeConnection},
+		{"connection in tool output", errors.New(`mysqldump failed: exit status 2, stderr: "Can't connect to MySQL server on 'db'"`), CodeConnection},
+		{"anything else", errors.New("checksum mismatch"), CodeUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CodeOf(tt.err))
+		})
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	cause := errors.New("bucket not found")
+	err := fmt.Errorf("upload failed: %w", &ConnectionError{Err: cause})
+
+	assert.ErrorIs(t, err, cause)
+	assert.EqualError(t, err, "upload failed: bucket not found")
+	var connErr *ConnectionError
+	assert.ErrorAs(t, err, &connErr)
+}
+
+func TestArtifacts(t *testing.T) {
+	assert.NoError(t, Artifacts("databases", []Artifact{{Name: "orders"}, {Name: "users"}}))
+
+	refused := errors.New("connection refused")
+	err := Artifacts("databases", []Artifact{{Name: "orders"}, {Name: "users", Err: refused}})
+	var artifacts *ArtifactsError
+	assert.ErrorAs(t, err, &artifacts)
+	assert.True(t, artifacts.Partial())
+	assert.ErrorIs(t, err, refused)
+	assert.EqualError(t, err, "1 of 2 databases failed: users: connection refused")
+	assert.Equal(t, CodeConnection, CodeOf(err))
+
+	err = Artifacts("databases", []Artifact{
+		{Name: "orders", Err: &ToolMissingError{Err: errors.New("mysqldump not found")}},
+		{Name: "users", Err: refused},
+	})
+	assert.ErrorAs(t, err, &artifacts)
+	assert.False(t, artifacts.Partial())
+	assert.Equal(t, CodeUnknown, CodeOf(err), "artifacts that failed for different reasons")
+}
+
+func TestReplication(t *testing.T) {
+	assert.NoError(t, Replication([]Artifact{{Name: "offsite"}}))
+
+	refused := &ConnectionError{Err: errors.New("connection refused")}
+	err := Replication([]Artifact{{Name: "offsite", Err: refused}, {Name: "nas"}})
+	var replication *ReplicationError
+	assert.ErrorAs(t, err, &replication)
+	assert.ErrorIs(t, err, refused)
+	assert.EqualError(t, err, "copy to 1 of 2 destinations failed: offsite: connection refused")
+	assert.Equal(t, CodeConnection, CodeOf(err))
+}
+
+func TestExcerpt(t *testing.T) {
+	assert.Nil(t, Excerpt(errors.New("checksum mismatch")))
+
+	lines := []string{`pg_dump: error: connection to server at "db" (10.0.0.5), port 5432 failed: Connection refused`}
+	err := fmt.Errorf("orders: %w", &OutputError{Err: errors.New("pg_dump failed: exit status 1"), Lines: lines})
+	assert.Equal(t, lines, Excerpt(err))
+	assert.EqualError(t, err, "orders: pg_dump failed: exit status 1")
+	assert.Equal(t, CodeConnection, CodeOf(err), "classified by the tool's output")
+
+	err = Artifacts("databases", []Artifact{{Name: "orders", Err: err}, {Name: "users"}})
+	assert.Equal(t, lines, Excerpt(err))
+}
@@ -0,0 +1,98 @@
+package history
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// exportColumns are the CSV header, matching the JSON field names
+var exportColumns = []string{"job", "type", "status", "started_at", "finished_at", "duration_seconds", "size", "error", "error_code", "run_id"}
+
+// exportRun is a run as exported, with its duration spelled out for
+// spreadsheets and BI tools
+type exportRun struct {
+	Run
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Export writes runs as CSV with a header row or as a JSON array
+func Export(w io.Writer, format string, runs []Run) error {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		for _, run := range runs {
+			cw.Write([]string{
+				run.Job,
+				run.Type,
+				run.Status,
+				run.StartedAt.Format(time.RFC3339),
+				run.FinishedAt.Format(time.RFC3339),
+				strconv.FormatFloat(run.Duration().Seconds(), 'f', 3, 64),
+				strconv.FormatInt(run.Size, 10),
+				run.Error,
+				run.ErrorCode,
+				run.ID,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatJSON:
+		exported := make([]exportRun, 0, len(runs))
+		for _, run := range runs {
+			exported = append(exported, exportRun{Run: run, DurationSeconds: run.Duration().Seconds()})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exported)
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// ParseSince parses the start of an export as an age such as 30d or 12h, a
+// date (YYYY-MM-DD) or an RFC 3339 time
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid since %q", value)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since %q, expected an age such as 30d or 12h, a date or an RFC 3339 time", value)
+}
+
+// Filter returns the runs of a job, or all runs when job is empty
+func Filter(runs []Run, job string) []Run {
+	if job == "" {
+		return runs
+	}
+	var filtered []Run
+	for _, run := range runs {
+		if run.Job == job {
+			filtered = append(filtered, run)
+		}
+	}
+	return filtered
+}
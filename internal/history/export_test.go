@@ -0,0 +1,60 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	started := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	runs := []Run{
+		{ID: "20260301T020000Z-0a1b2c3d", Job: "orders", Type: "postgres", Status: StatusSuccess, StartedAt: started, FinishedAt: started.Add(90 * time.Second), Size: 2048},
+		{Job: "users", Type: "mysql", Status: StatusFailed, StartedAt: started, FinishedAt: started.Add(time.Second), Error: "mysqldump failed: exit status 2, stderr: \"denied\"", ErrorCode: "unknown"},
+	}
+
+	var csvOut bytes.Buffer
+	require.NoError(t, Export(&csvOut, FormatCSV, runs))
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "job,type,status,started_at,finished_at,duration_seconds,size,error,error_code,run_id", lines[0])
+	assert.Equal(t, "orders,postgres,success,2026-03-01T02:00:00Z,2026-03-01T02:01:30Z,90.000,2048,,,20260301T020000Z-0a1b2c3d", lines[1])
+	assert.Contains(t, lines[2], `"mysqldump failed: exit status 2, stderr: ""denied""",unknown,`)
+
+	var jsonOut bytes.Buffer
+	require.NoError(t, Export(&jsonOut, FormatJSON, Filter(runs, "orders")))
+	var exported []map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(jsonOut.Bytes(), &exported))
+	require.Len(t, exported, 1)
+	assert.Equal(t, `"orders"`, string(exported[0]["job"]))
+	assert.Equal(t, "90", string(exported[0]["duration_seconds"]))
+
+	assert.Error(t, Export(&jsonOut, "xml", runs))
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	since, err := ParseSince("30d", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), since)
+
+	since, err = ParseSince("12h", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-12*time.Hour), since)
+
+	since, err = ParseSince("2026-01-15T00:00:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC), since)
+
+	_, err = ParseSince("2026-01-15", now)
+	assert.NoError(t, err)
+
+	_, err = ParseSince("last week", now)
+	assert.Error(t, err)
+}
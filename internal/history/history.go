@@ -0,0 +1,203 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Run statuses
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusPartial = "partial" // Some artifacts were written and others failed, or a copy to a destination failed
+)
+
+// DefaultKeepDays is how long runs are kept when no keep_days is configured
+const DefaultKeepDays = 90
+
+// Run is one execution of a backup job
+type Run struct {
+	ID         string    `json:"id,omitempty"` // Run ID, also found in the run's log lines
+	Job        string    `json:"job"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Size       int64     `json:"size"` // Bytes of the backups the run wrote
+	Error      string    `json:"error,omitempty"`
+	ErrorCode  string    `json:"error_code,omitempty"` // Class of the failure, see the failure package
+
+	// ErrorExcerpt is the end of what the failing tool wrote to stderr, with
+	// secrets masked
+	ErrorExcerpt []string `json:"error_excerpt,omitempty"`
+
+	// Artifacts are the outcomes of the databases of a multi-database dump,
+	// set when any of them failed
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// Destinations are the outcomes of the copies to the job's storage
+	// destinations, named after them
+	Destinations []Artifact `json:"destinations,omitempty"`
+}
+
+// Artifact is the outcome of one artifact of a run, or of its copy to a
+// storage destination
+type Artifact struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"` // StatusSuccess or StatusFailed
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// Duration is how long the run took
+func (r Run) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// Store records runs as JSON lines in a file, oldest first
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// DefaultPath is the history file used when none is configured
+func DefaultPath(localDir string) string {
+	return filepath.Join(localDir, ".backmeup", "history.jsonl")
+}
+
+// Open returns the store for a history file, creating its directory
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &Store{path: path}, nil
+}
+
+// Append records a finished run
+func (s *Store) Append(run Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to encode run: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// Since returns the runs started at or after the given time, oldest first
+func (s *Store) Since(since time.Time) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(func(run Run) bool { return !run.StartedAt.Before(since) })
+}
+
+// Prune removes runs started before the given time
+func (s *Store) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs, err := s.read(func(run Run) bool { return !run.StartedAt.Before(before) })
+	if err != nil {
+		return err
+	}
+	return s.write(runs)
+}
+
+// RenameJob records the runs of a job under its new name and returns how
+// many were changed
+func (s *Store) RenameJob(from, to string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs, err := s.read(func(Run) bool { return true })
+	if err != nil {
+		return 0, err
+	}
+	var renamed int
+	for i := range runs {
+		if runs[i].Job == from {
+			runs[i].Job = to
+			renamed++
+		}
+	}
+	if renamed == 0 {
+		return 0, nil
+	}
+	return renamed, s.write(runs)
+}
+
+// write replaces the history file with runs
+func (s *Store) write(runs []Run) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".history-*")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, run := range runs {
+		if err := enc.Encode(run); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to rewrite history: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to rewrite history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to rewrite history: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to rewrite history: %w", err)
+	}
+	return nil
+}
+
+// read decodes the history file, skipping lines that cannot be parsed such
+// as a line cut short by a crash
+func (s *Store) read(keep func(Run) bool) ([]Run, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var runs []Run
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			continue
+		}
+		if keep(run) {
+			runs = append(runs, run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return runs, nil
+}
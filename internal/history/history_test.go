@@ -0,0 +1,95 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".backmeup", "history.jsonl")
+	store, err := Open(path)
+	require.NoError(t, err)
+
+	runs, err := store.Since(time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, runs, "a missing history file has no runs")
+
+	now := time.Now().Truncate(time.Second)
+	for i := 3; i > 0; i-- {
+		started := now.Add(-time.Duration(i) * 24 * time.Hour)
+		require.NoError(t, store.Append(Run{
+			Job: "orders", Type: "postgres", Status: StatusSuccess,
+			StartedAt: started, FinishedAt: started.Add(time.Minute), Size: int64(i * 100),
+		}))
+	}
+
+	// A line cut short by a crash is skipped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"job":"orders","sta`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	runs, err = store.Since(now.Add(-36 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, int64(100), runs[0].Size)
+	assert.Equal(t, time.Minute, runs[0].Duration())
+
+	require.NoError(t, store.Prune(now.Add(-60*time.Hour)))
+	runs, err = store.Since(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, int64(200), runs[0].Size, "runs stay oldest first")
+}
+
+func TestJobStats(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	require.NoError(t, err)
+
+	stats, err := store.JobStats("orders")
+	require.NoError(t, err)
+	assert.Zero(t, stats.Runs)
+
+	started := time.Now().Add(-48 * time.Hour)
+	// 25 runs of 1..25 minutes, only the last 20 (6..25) count
+	for i := 1; i <= 25; i++ {
+		require.NoError(t, store.Append(Run{Job: "orders", Status: StatusSuccess, StartedAt: started, FinishedAt: started.Add(time.Duration(i) * time.Minute)}))
+	}
+	require.NoError(t, store.Append(Run{Job: "orders", Status: StatusFailed, StartedAt: started, FinishedAt: started.Add(5 * time.Hour)}))
+	require.NoError(t, store.Append(Run{Job: "users", Status: StatusSuccess, StartedAt: started, FinishedAt: started.Add(5 * time.Hour)}))
+
+	stats, err = store.JobStats("orders")
+	require.NoError(t, err)
+	assert.Equal(t, 20, stats.Runs)
+	assert.Equal(t, 15*time.Minute, stats.Median)
+	assert.Equal(t, 24*time.Minute, stats.P95)
+}
+
+func TestRenameJob(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	require.NoError(t, err)
+	for _, job := range []string{"old", "orders", "old"} {
+		require.NoError(t, store.Append(Run{Job: job, Status: StatusSuccess, StartedAt: time.Now()}))
+	}
+
+	renamed, err := store.RenameJob("old", "new")
+	require.NoError(t, err)
+	assert.Equal(t, 2, renamed)
+
+	runs, err := store.Since(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, runs, 3)
+	assert.Equal(t, "new", runs[0].Job)
+	assert.Equal(t, "orders", runs[1].Job)
+	assert.Equal(t, "new", runs[2].Job)
+
+	renamed, err = store.RenameJob("old", "new")
+	require.NoError(t, err)
+	assert.Zero(t, renamed)
+}
@@ -0,0 +1,67 @@
+package history
+
+import (
+	"slices"
+	"time"
+)
+
+// statsWindow is the number of recent successful runs durations are
+// estimated from, so estimates follow a job's growth
+const statsWindow = 20
+
+// Stats describe the durations of a job's recent successful runs
+type Stats struct {
+	Runs   int
+	Median time.Duration
+	P95    time.Duration
+}
+
+// JobStats returns the duration statistics of a job's most recent successful
+// runs. Runs is zero when the job has never succeeded.
+func (s *Store) JobStats(job string) (Stats, error) {
+	s.mu.Lock()
+	runs, err := s.read(func(run Run) bool { return run.Job == job && run.Status == StatusSuccess })
+	s.mu.Unlock()
+	if err != nil {
+		return Stats{}, err
+	}
+	return durationStats(runs), nil
+}
+
+// LastSuccess returns the job's most recent successful run
+func (s *Store) LastSuccess(job string) (Run, bool, error) {
+	s.mu.Lock()
+	runs, err := s.read(func(run Run) bool { return run.Job == job && run.Status == StatusSuccess })
+	s.mu.Unlock()
+	if err != nil || len(runs) == 0 {
+		return Run{}, false, err
+	}
+	return runs[len(runs)-1], true, nil
+}
+
+func durationStats(runs []Run) Stats {
+	if len(runs) > statsWindow {
+		runs = runs[len(runs)-statsWindow:]
+	}
+	if len(runs) == 0 {
+		return Stats{}
+	}
+
+	durations := make([]time.Duration, len(runs))
+	for i, run := range runs {
+		durations[i] = run.Duration()
+	}
+	slices.Sort(durations)
+
+	return Stats{
+		Runs:   len(durations),
+		Median: durations[(len(durations)-1)/2],
+		P95:    percentile(durations, 95),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
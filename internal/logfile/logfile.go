@@ -0,0 +1,253 @@
+// Package logfile writes the daemon's log to a file that rotates itself once
+// it reaches a size, so deployments without containers need no logrotate
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/clock"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// DefaultMaxSize is the size at which files rotate without a max_size
+const DefaultMaxSize = 100 << 20
+
+// timeFormat stamps rotated files, sorting by name in the order of rotation
+const timeFormat = "2006-01-02T15-04-05.000"
+
+// Writer appends to a log file and rotates it. Rotated files are named after
+// the file with the time of the rotation, e.g. backmeup-2026-03-01T02-00-00.000.log.
+type Writer struct {
+	path     string
+	maxSize  int64
+	keep     int
+	keepDays int
+	compress bool
+	clock    clock.Clock
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	wg   sync.WaitGroup // Compression and cleanup after a rotation
+	bgMu sync.Mutex     // Keeps cleanup from listing a file being compressed
+}
+
+// New opens the log file of the configuration, creating it and its directory
+// when missing
+func New(cfg config.LoggingConfig, c clock.Clock) (*Writer, error) {
+	maxSize := int64(DefaultMaxSize)
+	if cfg.MaxSize != "" {
+		size, err := config.ParseSize(cfg.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logging max_size: %w", err)
+		}
+		maxSize = size
+	}
+	w := &Writer{
+		path:     cfg.File,
+		maxSize:  maxSize,
+		keep:     cfg.Keep,
+		keepDays: cfg.KeepDays,
+		compress: cfg.Compress,
+		clock:    c,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends p to the file, rotating it first when p would take it past
+// the maximum size. A single write larger than the maximum is not split.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new file regardless of the size of the current one
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+// Close closes the file and waits for rotated files to be compressed
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return err
+}
+
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one. Compression and
+// removal of old files happen in the background, as they would otherwise
+// hold up every goroutine that logs.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	rotated := w.rotatedName(w.clock.Now())
+	if err := os.Rename(w.path, rotated); err != nil {
+		// Keep appending to the current file rather than losing messages
+		fmt.Fprintf(os.Stderr, "Warning: failed to rotate log file: %v\n", err)
+		return w.open()
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.bgMu.Lock()
+		defer w.bgMu.Unlock()
+		if w.compress {
+			if err := compressFile(rotated); err != nil {
+				// The log itself is where this would go, so it goes to stderr
+				fmt.Fprintf(os.Stderr, "Warning: failed to compress rotated log %s: %v\n", rotated, err)
+			}
+		}
+		w.removeOld()
+	}()
+	return nil
+}
+
+func (w *Writer) rotatedName(t time.Time) string {
+	ext := filepath.Ext(w.path)
+	return strings.TrimSuffix(w.path, ext) + "-" + t.UTC().Format(timeFormat) + ext
+}
+
+// rotatedLog is a rotated file of the log
+type rotatedLog struct {
+	path      string
+	rotatedAt time.Time
+}
+
+// rotated returns the rotated files of the log, newest first
+func (w *Writer) rotated() ([]rotatedLog, error) {
+	dir := filepath.Dir(w.path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(filepath.Base(w.path), ext) + "-"
+
+	var rotated []rotatedLog
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		rotatedAt, err := time.Parse(timeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		rotated = append(rotated, rotatedLog{path: filepath.Join(dir, entry.Name()), rotatedAt: rotatedAt})
+	}
+	slices.SortFunc(rotated, func(a, b rotatedLog) int {
+		return b.rotatedAt.Compare(a.rotatedAt)
+	})
+	return rotated, nil
+}
+
+// removeOld removes the rotated files beyond keep and those older than
+// keep_days
+func (w *Writer) removeOld() {
+	if w.keep == 0 && w.keepDays == 0 {
+		return
+	}
+	rotated, err := w.rotated()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to list rotated logs: %v\n", err)
+		return
+	}
+
+	cutoff := w.clock.Now().AddDate(0, 0, -w.keepDays)
+	for i, r := range rotated {
+		if (w.keep > 0 && i >= w.keep) || (w.keepDays > 0 && r.rotatedAt.Before(cutoff)) {
+			if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Warning: failed to remove rotated log %s: %v\n", r.path, err)
+			}
+		}
+	}
+}
+
+// compressFile replaces a file with its gzip
+func compressFile(path string) error {
+	if err := writeGzip(path+".gz", path); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+func writeGzip(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,95 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+func TestWriter_RotatesAtMaxSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "backmeup.log")
+	clock := backuptest.NewClock(time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC))
+
+	w, err := New(config.LoggingConfig{File: path, MaxSize: "20B"}, clock)
+	require.NoError(t, err)
+	line := "0123456789abcdef\n" // 17 bytes, one per file
+
+	_, err = w.Write([]byte(line))
+	require.NoError(t, err)
+	clock.Advance(time.Second)
+	_, err = w.Write([]byte(line))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	rotated := filepath.Join(dir, "logs", "backmeup-2026-03-01T02-00-01.000.log")
+	assert.FileExists(t, rotated)
+	for _, file := range []string{path, rotated} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, line, string(data))
+	}
+
+	// Reopening appends and counts the size already written
+	w, err = New(config.LoggingConfig{File: path, MaxSize: "20B"}, clock)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("x\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, line+"x\n", string(data))
+
+	_, err = w.Write([]byte("closed\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestWriter_CompressAndRemoveOld(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backmeup.log")
+	clock := backuptest.NewClock(time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC))
+
+	// Rotated long ago, removed by keep_days
+	stale := filepath.Join(dir, "backmeup-2026-01-01T00-00-00.000.log.gz")
+	require.NoError(t, os.WriteFile(stale, nil, 0644))
+	unrelated := filepath.Join(dir, "backmeup-notes.log")
+	require.NoError(t, os.WriteFile(unrelated, nil, 0644))
+
+	w, err := New(config.LoggingConfig{File: path, Keep: 2, KeepDays: 30, Compress: true}, clock)
+	require.NoError(t, err)
+	for i := range 3 {
+		_, err := w.Write([]byte(strings.Repeat("line\n", i+1)))
+		require.NoError(t, err)
+		clock.Advance(time.Hour)
+		require.NoError(t, w.Rotate())
+	}
+	require.NoError(t, w.Close())
+
+	rotated, err := w.rotated()
+	require.NoError(t, err)
+	var names []string
+	for _, r := range rotated {
+		names = append(names, filepath.Base(r.path))
+	}
+	assert.Equal(t, []string{"backmeup-2026-03-01T05-00-00.000.log.gz", "backmeup-2026-03-01T04-00-00.000.log.gz"}, names)
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, unrelated)
+
+	f, err := os.Open(rotated[0].path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("line\n", 3), string(data))
+}
@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Chain places a backup in a chain of incremental backups, such as the
+// streams of an incremental zfs or btrfs snapshot job
+type Chain struct {
+	Snapshot string `json:"snapshot"`         // Source snapshot the backup brings the target to
+	Parent   string `json:"parent,omitempty"` // Snapshot an increment applies on top of, empty for a full backup
+	Depth    int    `json:"depth"`            // Increments since the full backup
+}
+
+// List returns the manifests of a job's backups, oldest first
+func (s *Store) List(job string) ([]Manifest, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, job))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list manifests: %w", err)
+	}
+
+	var manifests []Manifest
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		m, err := s.Read(job, strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].CreatedAt.Before(manifests[j].CreatedAt)
+	})
+	return manifests, nil
+}
+
+// FindSnapshot returns the manifest of the backup that holds a snapshot
+func (s *Store) FindSnapshot(job, snapshot string) (Manifest, bool, error) {
+	manifests, err := s.List(job)
+	if err != nil {
+		return Manifest{}, false, err
+	}
+	for _, m := range manifests {
+		if m.Chain != nil && m.Chain.Snapshot == snapshot {
+			return m, true, nil
+		}
+	}
+	return Manifest{}, false, nil
+}
+
+// Parents maps each incremental backup of a job to the backup it applies
+// on top of. Increments whose parent has no manifest are left out.
+func (s *Store) Parents(job string) (map[string]string, error) {
+	manifests, err := s.List(job)
+	if err != nil {
+		return nil, err
+	}
+
+	bySnapshot := make(map[string]string)
+	for _, m := range manifests {
+		if m.Chain != nil {
+			bySnapshot[m.Chain.Snapshot] = m.Backup
+		}
+	}
+	parents := make(map[string]string)
+	for _, m := range manifests {
+		if m.Chain == nil || m.Chain.Parent == "" {
+			continue
+		}
+		if parent, ok := bySnapshot[m.Chain.Parent]; ok {
+			parents[m.Backup] = parent
+		}
+	}
+	return parents, nil
+}
+
+// Sequence returns the backups that restore a backup, in the order they are
+// applied: the full backup of its chain first and the backup itself last
+func (s *Store) Sequence(job, backup string) ([]string, error) {
+	m, err := s.Read(job, backup)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := s.List(job)
+	if err != nil {
+		return nil, err
+	}
+	bySnapshot := make(map[string]Manifest)
+	for _, other := range manifests {
+		if other.Chain != nil {
+			bySnapshot[other.Chain.Snapshot] = other
+		}
+	}
+
+	sequence := []string{m.Backup}
+	for m.Chain != nil && m.Chain.Parent != "" {
+		parent, ok := bySnapshot[m.Chain.Parent]
+		if !ok {
+			return nil, fmt.Errorf("the chain of %s is broken, no backup holds snapshot %s", backup, m.Chain.Parent)
+		}
+		if len(sequence) > len(manifests) {
+			return nil, fmt.Errorf("the chain of %s loops at snapshot %s", backup, m.Chain.Parent)
+		}
+		m = parent
+		sequence = append(sequence, m.Backup)
+	}
+
+	for i, j := 0, len(sequence)-1; i < j; i, j = i+1, j-1 {
+		sequence[i], sequence[j] = sequence[j], sequence[i]
+	}
+	return sequence, nil
+}
+
+// Dependents returns the backups among all that must stay for those in
+// keep to remain restorable: the ancestors of every kept increment
+func Dependents(parents map[string]string, keep []string) map[string]bool {
+	needed := make(map[string]bool)
+	for _, backup := range keep {
+		for parent, ok := parents[backup]; ok && !needed[parent]; parent, ok = parents[parent] {
+			needed[parent] = true
+		}
+	}
+	return needed
+}
@@ -0,0 +1,67 @@
+package manifest
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "manifests"))
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	chains := []Chain{
+		{Snapshot: "tank/data@a"},
+		{Snapshot: "tank/data@b", Parent: "tank/data@a", Depth: 1},
+		{Snapshot: "tank/data@c", Parent: "tank/data@b", Depth: 2},
+		{Snapshot: "tank/data@d"},
+		{Snapshot: "tank/data@f", Parent: "tank/data@e", Depth: 1},
+	}
+	for i, chain := range chains {
+		require.NoError(t, store.Write(Manifest{
+			Job:       "data",
+			Type:      "zfs",
+			Backup:    chain.Snapshot[len("tank/data@"):] + ".zfs",
+			CreatedAt: start.Add(time.Duration(i) * time.Hour),
+			Chain:     &chain,
+		}))
+	}
+
+	manifests, err := store.List("data")
+	require.NoError(t, err)
+	require.Len(t, manifests, 5)
+	assert.Equal(t, "a.zfs", manifests[0].Backup)
+	assert.Equal(t, "f.zfs", manifests[4].Backup)
+
+	missing, err := store.List("other")
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	m, ok, err := store.FindSnapshot("data", "tank/data@b")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "b.zfs", m.Backup)
+	_, ok, err = store.FindSnapshot("data", "tank/data@e")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	parents, err := store.Parents("data")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"b.zfs": "a.zfs", "c.zfs": "b.zfs"}, parents)
+
+	sequence, err := store.Sequence("data", "c.zfs")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.zfs", "b.zfs", "c.zfs"}, sequence)
+
+	sequence, err = store.Sequence("data", "d.zfs")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d.zfs"}, sequence)
+
+	_, err = store.Sequence("data", "f.zfs")
+	assert.ErrorContains(t, err, "no backup holds snapshot tank/data@e")
+
+	assert.Equal(t, map[string]bool{"a.zfs": true, "b.zfs": true}, Dependents(parents, []string{"c.zfs", "d.zfs"}))
+	assert.Empty(t, Dependents(parents, []string{"a.zfs", "d.zfs"}))
+}
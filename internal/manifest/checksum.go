@@ -0,0 +1,146 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Checksum is the SHA-256 of a backup file, or of one file of a backup
+// directory
+type Checksum struct {
+	Path   string `json:"path,omitempty"` // Slash separated path inside a backup directory, empty for a file
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ChecksumError lists the files of a backup that do not match their
+// recorded checksums
+type ChecksumError struct {
+	Mismatched []string // Changed content or size
+	Missing    []string
+	Unexpected []string // Files of a backup directory without a recorded checksum
+}
+
+func (e *ChecksumError) Error() string {
+	var problems []error
+	for _, path := range e.Mismatched {
+		problems = append(problems, fmt.Errorf("%s does not match its checksum", orBackup(path)))
+	}
+	for _, path := range e.Missing {
+		problems = append(problems, fmt.Errorf("%s is missing", orBackup(path)))
+	}
+	for _, path := range e.Unexpected {
+		problems = append(problems, fmt.Errorf("%s has no recorded checksum", path))
+	}
+	return errors.Join(problems...).Error()
+}
+
+func orBackup(path string) string {
+	if path == "" {
+		return "the backup"
+	}
+	return path
+}
+
+// ComputeChecksums returns the SHA-256 of a backup file, or of every regular
+// file in a backup directory in walk order
+func ComputeChecksums(path string) ([]Checksum, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	if !info.IsDir() {
+		sum, err := checksumFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []Checksum{sum}, nil
+	}
+
+	checksums := []Checksum{}
+	err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		sum, err := checksumFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		sum.Path = filepath.ToSlash(rel)
+		checksums = append(checksums, sum)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum backup: %w", err)
+	}
+	return checksums, nil
+}
+
+func checksumFile(path string) (Checksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return Checksum{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// VerifyChecksums recomputes the checksums of a backup and compares them
+// with the recorded ones. It returns a *ChecksumError when they differ.
+func VerifyChecksums(path string, recorded []Checksum) error {
+	if len(recorded) == 0 {
+		return errors.New("no checksums were recorded for the backup")
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &ChecksumError{Missing: []string{""}}
+	}
+	current, err := ComputeChecksums(path)
+	if err != nil {
+		return err
+	}
+
+	byPath := make(map[string]Checksum, len(current))
+	for _, sum := range current {
+		byPath[sum.Path] = sum
+	}
+	result := &ChecksumError{}
+	for _, want := range recorded {
+		got, ok := byPath[want.Path]
+		delete(byPath, want.Path)
+		switch {
+		case !ok:
+			result.Missing = append(result.Missing, want.Path)
+		case got != want:
+			result.Mismatched = append(result.Mismatched, want.Path)
+		}
+	}
+	for _, sum := range current {
+		if _, ok := byPath[sum.Path]; ok {
+			result.Unexpected = append(result.Unexpected, sum.Path)
+		}
+	}
+
+	if len(result.Mismatched)+len(result.Missing)+len(result.Unexpected) > 0 {
+		return result
+	}
+	return nil
+}
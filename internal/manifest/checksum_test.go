@@ -0,0 +1,58 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyChecksums_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.sql")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0644))
+
+	checksums, err := ComputeChecksums(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Checksum{{Size: 5, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}}, checksums)
+	assert.NoError(t, VerifyChecksums(path, checksums))
+
+	require.NoError(t, os.WriteFile(path, []byte("jello"), 0644))
+	var checksumErr *ChecksumError
+	require.ErrorAs(t, VerifyChecksums(path, checksums), &checksumErr)
+	assert.Equal(t, []string{""}, checksumErr.Mismatched)
+	assert.EqualError(t, checksumErr, "the backup does not match its checksum")
+
+	require.NoError(t, os.Remove(path))
+	assert.EqualError(t, VerifyChecksums(path, checksums), "the backup is missing")
+
+	assert.EqualError(t, VerifyChecksums(path, nil), "no checksums were recorded for the backup")
+}
+
+func TestVerifyChecksums_Directory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backup")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "orders"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metadata"), []byte("meta"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orders", "items.sql"), []byte("items"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orders", "users.sql"), []byte("users"), 0644))
+
+	checksums, err := ComputeChecksums(dir)
+	require.NoError(t, err)
+	require.Len(t, checksums, 3)
+	assert.Equal(t, "metadata", checksums[0].Path)
+	assert.Equal(t, "orders/items.sql", checksums[1].Path)
+	assert.NoError(t, VerifyChecksums(dir, checksums))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "orders", "items.sql"), []byte("itemz"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "orders", "users.sql")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "extra"), []byte("x"), 0644))
+
+	var checksumErr *ChecksumError
+	require.ErrorAs(t, VerifyChecksums(dir, checksums), &checksumErr)
+	assert.Equal(t, &ChecksumError{
+		Mismatched: []string{"orders/items.sql"},
+		Missing:    []string{"orders/users.sql"},
+		Unexpected: []string{"extra"},
+	}, checksumErr)
+}
@@ -0,0 +1,226 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/storage/split"
+)
+
+// Encryption and compression schemes, and artifact containers
+const (
+	EncryptionAge = "age"
+	EncryptionGPG = "gpg"
+
+	CompressionGzip  = "gzip"
+	CompressionZstd  = "zstd"
+	CompressionXz    = "xz"
+	CompressionBzip2 = "bzip2"
+	CompressionLz4   = "lz4"
+
+	ContainerPgDump    = "pg_dump"   // pg_dump custom or directory format
+	ContainerTar       = "tar"       // Archives of the file, config and API exports
+	ContainerSQLite    = "sqlite"    // A copy of the database file
+	ContainerDirectory = "directory" // A mirror, restored by copying
+	ContainerPlain     = "plain"     // SQL or other text, loaded with the database client
+	ContainerUnknown   = "unknown"   // Not recognized, or hidden by encryption
+)
+
+// sniffSize is how much of an artifact is read to detect its format
+const sniffSize = 512
+
+// Format is how a backup artifact is encoded, outermost layer first, so a
+// restore can pick its steps without knowing how the job was configured
+type Format struct {
+	Split       bool   `json:"split,omitempty"`       // Stored as parts to be joined first
+	Encryption  string `json:"encryption,omitempty"`  // age or gpg
+	Compression string `json:"compression,omitempty"` // gzip, zstd, xz, bzip2 or lz4
+	Container   string `json:"container"`
+}
+
+// magic numbers of the layers an artifact can be wrapped in
+var magics = []struct {
+	prefix      []byte
+	encryption  string
+	compression string
+}{
+	{prefix: []byte("age-encryption.org/v1"), encryption: EncryptionAge},
+	{prefix: []byte("-----BEGIN AGE ENCRYPTED FILE-----"), encryption: EncryptionAge},
+	{prefix: []byte("-----BEGIN PGP MESSAGE-----"), encryption: EncryptionGPG},
+	{prefix: []byte{0x1f, 0x8b}, compression: CompressionGzip},
+	{prefix: []byte{0x28, 0xb5, 0x2f, 0xfd}, compression: CompressionZstd},
+	{prefix: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, compression: CompressionXz},
+	{prefix: []byte("BZh"), compression: CompressionBzip2},
+	{prefix: []byte{0x04, 0x22, 0x4d, 0x18}, compression: CompressionLz4},
+}
+
+// DetectFormat reads the first bytes of a backup artifact to tell how it is
+// encoded. Split artifacts are detected from their first part and directories
+// from their contents.
+func DetectFormat(path string) (Format, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Format{}, fmt.Errorf("failed to read backup: %w", err)
+	}
+	if !info.IsDir() {
+		return detectFile(path)
+	}
+
+	if strings.HasSuffix(path, split.DirSuffix) {
+		first, err := firstPart(path)
+		if err != nil {
+			return Format{}, err
+		}
+		f, err := detectFile(first)
+		f.Split = true
+		return f, err
+	}
+	if _, err := os.Stat(filepath.Join(path, "toc.dat")); err == nil {
+		return Format{Container: ContainerPgDump}, nil
+	}
+	return Format{Container: ContainerDirectory}, nil
+}
+
+// firstPart returns the path of the first part of a split artifact
+func firstPart(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, split.ManifestName))
+	if err != nil {
+		return "", fmt.Errorf("failed to read split manifest: %w", err)
+	}
+	var m split.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", fmt.Errorf("failed to parse split manifest: %w", err)
+	}
+	if len(m.Parts) == 0 {
+		return "", fmt.Errorf("split manifest of %s lists no parts", dir)
+	}
+	return filepath.Join(dir, m.Parts[0].Name), nil
+}
+
+func detectFile(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Format{}, fmt.Errorf("failed to read backup: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, sniffSize)
+	head, err := r.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		return Format{}, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	var format Format
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.prefix) {
+			format.Encryption, format.Compression = m.encryption, m.compression
+			break
+		}
+	}
+	if format.Encryption == "" && isGPGPacket(head) {
+		format.Encryption = EncryptionGPG
+	}
+
+	switch {
+	case format.Encryption != "":
+		// The payload cannot be seen before decryption
+		format.Container = ContainerUnknown
+	case format.Compression == CompressionGzip:
+		// Only gzip is readable with the standard library
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return Format{}, fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		inner := make([]byte, sniffSize)
+		n, _ := io.ReadFull(gz, inner)
+		format.Container = container(inner[:n])
+	case format.Compression != "":
+		format.Container = ContainerUnknown
+	default:
+		format.Container = container(head)
+	}
+	return format, nil
+}
+
+// container recognizes the format of an unencrypted, uncompressed artifact
+func container(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("PGDMP")):
+		return ContainerPgDump
+	case bytes.HasPrefix(head, []byte("SQLite format 3\x00")):
+		return ContainerSQLite
+	case len(head) >= 262 && bytes.Equal(head[257:262], []byte("ustar")):
+		return ContainerTar
+	case len(head) > 0 && !bytes.ContainsRune(head, 0):
+		return ContainerPlain
+	}
+	return ContainerUnknown
+}
+
+// isGPGPacket reports whether data starts with the packet of a binary
+// OpenPGP message: a session key or a symmetrically encrypted session key
+func isGPGPacket(head []byte) bool {
+	if len(head) == 0 || head[0]&0x80 == 0 {
+		return false
+	}
+	var tag byte
+	if head[0]&0x40 != 0 {
+		tag = head[0] & 0x3f
+	} else {
+		tag = (head[0] >> 2) & 0x0f
+	}
+	return tag == 1 || tag == 3
+}
+
+// RestoreSteps lists the commands that turn the artifact back into
+// something its database or filesystem can load, in order
+func (f Format) RestoreSteps() []string {
+	var steps []string
+	if f.Split {
+		steps = append(steps, "backmeup join")
+	}
+	switch f.Encryption {
+	case EncryptionAge:
+		steps = append(steps, "age --decrypt --identity <key file>")
+	case EncryptionGPG:
+		steps = append(steps, "gpg --decrypt")
+	}
+	switch f.Compression {
+	case CompressionGzip:
+		if f.Container != ContainerTar {
+			steps = append(steps, "gunzip")
+		}
+	case CompressionZstd:
+		steps = append(steps, "zstd --decompress")
+	case CompressionXz:
+		steps = append(steps, "xz --decompress")
+	case CompressionBzip2:
+		steps = append(steps, "bunzip2")
+	case CompressionLz4:
+		steps = append(steps, "lz4 --decompress")
+	}
+	switch f.Container {
+	case ContainerPgDump:
+		steps = append(steps, "pg_restore")
+	case ContainerTar:
+		if f.Compression == CompressionGzip {
+			steps = append(steps, "tar -xzf")
+		} else {
+			steps = append(steps, "tar -xf")
+		}
+	case ContainerSQLite, ContainerDirectory:
+		steps = append(steps, "copy into place")
+	case ContainerPlain:
+		steps = append(steps, "load with the database client, such as psql or mysql")
+	default:
+		steps = append(steps, "inspect the decoded artifact")
+	}
+	return steps
+}
@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "config.json", Mode: 0644, Size: 2}))
+	_, err := tw.Write([]byte("{}"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	var sql bytes.Buffer
+	gz = gzip.NewWriter(&sql)
+	_, err = gz.Write([]byte("-- MySQL dump 10.13\nCREATE TABLE orders (id int);\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	files := map[string][]byte{
+		"backup.tar.gz":  archive.Bytes(),
+		"backup.sql.gz":  sql.Bytes(),
+		"backup.sql":     []byte("--\n-- PostgreSQL database dump\n--\n"),
+		"backup.dump":    append([]byte("PGDMP"), 1, 14, 0),
+		"backup.db":      append([]byte("SQLite format 3\x00"), make([]byte, 84)...),
+		"backup.sql.age": []byte("age-encryption.org/v1\n-> X25519 abc\n"),
+		"backup.sql.zst": {0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x00},
+		"backup.sql.gpg": {0x85, 0x01, 0x0c, 0x03},
+	}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "pg_dir", "pg"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pg_dir", "toc.dat"), []byte("PGDMP"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "mirror", "bucket"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "big.sql.gz.split"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.sql.gz.split", "part-00001"), sql.Bytes(), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big.sql.gz.split", "manifest.json"),
+		[]byte(`{"version": 1, "parts": [{"name": "part-00001"}]}`), 0644))
+
+	tests := []struct {
+		name  string
+		want  Format
+		steps []string
+	}{
+		{"backup.tar.gz", Format{Compression: CompressionGzip, Container: ContainerTar}, []string{"tar -xzf"}},
+		{"backup.sql.gz", Format{Compression: CompressionGzip, Container: ContainerPlain},
+			[]string{"gunzip", "load with the database client, such as psql or mysql"}},
+		{"backup.sql", Format{Container: ContainerPlain}, []string{"load with the database client, such as psql or mysql"}},
+		{"backup.dump", Format{Container: ContainerPgDump}, []string{"pg_restore"}},
+		{"backup.db", Format{Container: ContainerSQLite}, []string{"copy into place"}},
+		{"backup.sql.age", Format{Encryption: EncryptionAge, Container: ContainerUnknown},
+			[]string{"age --decrypt --identity <key file>", "inspect the decoded artifact"}},
+		{"backup.sql.zst", Format{Compression: CompressionZstd, Container: ContainerUnknown},
+			[]string{"zstd --decompress", "inspect the decoded artifact"}},
+		{"backup.sql.gpg", Format{Encryption: EncryptionGPG, Container: ContainerUnknown},
+			[]string{"gpg --decrypt", "inspect the decoded artifact"}},
+		{"pg_dir", Format{Container: ContainerPgDump}, []string{"pg_restore"}},
+		{"mirror", Format{Container: ContainerDirectory}, []string{"copy into place"}},
+		{"big.sql.gz.split", Format{Split: true, Compression: CompressionGzip, Container: ContainerPlain},
+			[]string{"backmeup join", "gunzip", "load with the database client, such as psql or mysql"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := DetectFormat(filepath.Join(dir, tt.name))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, format)
+			assert.Equal(t, tt.steps, format.RestoreSteps())
+		})
+	}
+
+	_, err = DetectFormat(filepath.Join(dir, "missing.sql"))
+	assert.Error(t, err)
+}
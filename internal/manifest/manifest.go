@@ -0,0 +1,222 @@
+// Package manifest records what produced each backup, so that a restore can
+// check the backup against its target before anything is overwritten
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatVersion is the version of the manifest format written by this build
+const formatVersion = 1
+
+// Source engines
+const (
+	EnginePostgres = "postgres"
+	EngineMySQL    = "mysql"
+)
+
+// Manifest describes one backup of a job
+type Manifest struct {
+	Version   int       `json:"version"`
+	Job       string    `json:"job"`
+	Type      string    `json:"type"`
+	Backup    string    `json:"backup"` // Name of the backup in the job's directory
+	RunID     string    `json:"run_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Source    *Source   `json:"source,omitempty"`
+	Mode      string    `json:"mode,omitempty"` // Dump mode of a database job: full, schema or data
+
+	// Binlog positions of the dumped databases, when the job records them
+	Coordinates []Coordinates `json:"coordinates,omitempty"`
+
+	// Server of a cluster the backup was taken from, when the job can choose
+	Node *Node `json:"node,omitempty"`
+
+	// Encoding of the artifact, detected when the backup was written
+	Format *Format `json:"format,omitempty"`
+
+	// Place of the backup in a chain of increments, when the job sends them
+	Chain *Chain `json:"chain,omitempty"`
+
+	// Rows of each table in the dump, when the job verifies its dumps
+	Tables []TableRows `json:"tables,omitempty"`
+
+	// SHA-256 of the artifact as it was written, see VerifyChecksums
+	Checksums []Checksum `json:"checksums,omitempty"`
+}
+
+// TableRows is the number of rows of a table in a dump
+type TableRows struct {
+	Database string `json:"database,omitempty"`
+	Table    string `json:"table"`
+	Rows     int64  `json:"rows"`
+}
+
+// Node is the server of a cluster that served a backup
+type Node struct {
+	Host    string `json:"host"`
+	Standby bool   `json:"standby"`
+}
+
+// Source describes the database server a backup was taken from
+type Source struct {
+	Engine        string `json:"engine"`
+	ServerVersion string `json:"server_version"`
+	SchemaVersion string `json:"schema_version,omitempty"` // Result of the job's schema_version_query
+}
+
+// Coordinates kinds
+const (
+	CoordinatesSource  = "source"
+	CoordinatesReplica = "replica"
+)
+
+// Coordinates position a dump in a MySQL binary log, for rebuilding replicas
+// and replaying binlogs from the point the dump was taken
+type Coordinates struct {
+	Database    string `json:"database,omitempty"`
+	Kind        string `json:"kind"` // source: the dumped server's own log, replica: the log of the server it replicates from
+	LogFile     string `json:"log_file,omitempty"`
+	LogPosition int64  `json:"log_position,omitempty"`
+	GTIDSet     string `json:"gtid_set,omitempty"`
+}
+
+// Store keeps manifests as one JSON file per backup, outside of the job
+// directories so that retention and uploads do not see them
+type Store struct {
+	dir string
+}
+
+// DefaultDir is the manifest directory inside the local storage directory
+func DefaultDir(localDir string) string {
+	return filepath.Join(localDir, ".backmeup", "manifests")
+}
+
+// NewStore returns the store for a manifest directory, which is created on
+// the first write
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func (s *Store) path(job, backup string) string {
+	return filepath.Join(s.dir, job, backup+".json")
+}
+
+// Write records the manifest of a backup, replacing an earlier one
+func (s *Store) Write(m Manifest) error {
+	m.Version = formatVersion
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	path := s.path(m.Job, m.Backup)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// Read returns the manifest of a backup
+func (s *Store) Read(job, backup string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(s.path(job, backup))
+	if err != nil {
+		return m, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Version > formatVersion {
+		return m, fmt.Errorf("manifest version %d is newer than this build supports (%d)", m.Version, formatVersion)
+	}
+	return m, nil
+}
+
+// RenameJob moves the manifests of a job to its new name and returns how
+// many were moved. Nothing is moved when the new name has a manifest of the
+// same backup.
+func (s *Store) RenameJob(from, to string) (int, error) {
+	manifests, err := s.List(from)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range manifests {
+		if _, err := os.Stat(s.path(to, m.Backup)); err == nil {
+			return 0, fmt.Errorf("cannot move the manifest of %s, job %s already has one", m.Backup, to)
+		}
+	}
+
+	for i, m := range manifests {
+		m.Job = to
+		if err := s.Write(m); err != nil {
+			return i, err
+		}
+		if err := os.Remove(s.path(from, m.Backup)); err != nil {
+			return i, fmt.Errorf("failed to remove manifest: %w", err)
+		}
+	}
+	os.Remove(filepath.Join(s.dir, from))
+	return len(manifests), nil
+}
+
+// RestoreWarnings lists the reasons a backup taken from s may not restore
+// cleanly into target. Dumps load into newer PostgreSQL major versions but
+// not reliably into older ones; MySQL dumps are only safe within the same
+// major.minor series.
+func (s Source) RestoreWarnings(target Source) []string {
+	var warnings []string
+
+	if s.Engine != target.Engine {
+		warnings = append(warnings, fmt.Sprintf("the backup was taken from %s but the target is %s", s.Engine, target.Engine))
+	} else if s.ServerVersion != "" && target.ServerVersion != "" {
+		from, to := majorVersion(s.Engine, s.ServerVersion), majorVersion(s.Engine, target.ServerVersion)
+		switch c := slices.Compare(from, to); {
+		case c > 0:
+			warnings = append(warnings, fmt.Sprintf("the backup was taken from server version %s, restoring it to the older %s may fail",
+				s.ServerVersion, target.ServerVersion))
+		case c < 0 && s.Engine == EngineMySQL:
+			warnings = append(warnings, fmt.Sprintf("the backup was taken from server version %s, restoring it to %s crosses a major release, check the upgrade notes",
+				s.ServerVersion, target.ServerVersion))
+		}
+	}
+
+	if s.SchemaVersion != "" && target.SchemaVersion != "" && s.SchemaVersion != target.SchemaVersion {
+		warnings = append(warnings, fmt.Sprintf("the backup has schema version %s but the target has %s, the application may need its migrations re-run",
+			s.SchemaVersion, target.SchemaVersion))
+	}
+	return warnings
+}
+
+// majorVersion returns the release series of a server version: the first
+// number from PostgreSQL 10 on, and the first two numbers for older
+// PostgreSQL, MySQL and MariaDB. "16.2 (Debian 16.2-1)" gives [16] and
+// "8.0.36" gives [8 0].
+func majorVersion(engine, version string) []int {
+	version, _, _ = strings.Cut(strings.TrimSpace(version), " ")
+	version, _, _ = strings.Cut(version, "-")
+
+	var parts []int
+	for _, field := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+
+	if engine == EnginePostgres && len(parts) > 0 && parts[0] >= 10 {
+		return parts[:1]
+	}
+	return parts[:min(len(parts), 2)]
+}
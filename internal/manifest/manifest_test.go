@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "manifests"))
+	m := Manifest{
+		Job:       "orders",
+		Type:      "postgres",
+		Backup:    "pg_backup_20260301_020000.sql",
+		RunID:     "20260301T020000Z-0a1b2c3d",
+		CreatedAt: time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC),
+		Source:    &Source{Engine: EnginePostgres, ServerVersion: "16.2", SchemaVersion: "20260214"},
+	}
+	require.NoError(t, store.Write(m))
+
+	read, err := store.Read("orders", "pg_backup_20260301_020000.sql")
+	require.NoError(t, err)
+	m.Version = formatVersion
+	assert.Equal(t, m, read)
+
+	_, err = store.Read("orders", "pg_backup_20260302_020000.sql")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	m.Backup = "future.sql"
+	require.NoError(t, store.Write(m))
+	path := store.path("orders", "future.sql")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 99}`), 0644))
+	_, err = store.Read("orders", "future.sql")
+	assert.ErrorContains(t, err, "newer than this build supports")
+}
+
+func TestRenameJob(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "manifests"))
+	for _, backup := range []string{"a.sql", "b.sql"} {
+		require.NoError(t, store.Write(Manifest{Job: "old", Type: "postgres", Backup: backup}))
+	}
+
+	moved, err := store.RenameJob("old", "new")
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+
+	m, err := store.Read("new", "a.sql")
+	require.NoError(t, err)
+	assert.Equal(t, "new", m.Job)
+	old, err := store.List("old")
+	require.NoError(t, err)
+	assert.Empty(t, old)
+
+	require.NoError(t, store.Write(Manifest{Job: "other", Backup: "a.sql"}))
+	_, err = store.RenameJob("other", "new")
+	assert.ErrorContains(t, err, "already has one")
+}
+
+func TestRestoreWarnings(t *testing.T) {
+	pg := func(version, schema string) Source {
+		return Source{Engine: EnginePostgres, ServerVersion: version, SchemaVersion: schema}
+	}
+	mysql := func(version string) Source {
+		return Source{Engine: EngineMySQL, ServerVersion: version}
+	}
+
+	tests := []struct {
+		name     string
+		from, to Source
+		warnings int
+	}{
+		{"same version", pg("16.2", ""), pg("16.4 (Debian 16.4-1.pgdg120+1)", ""), 0},
+		{"newer postgres", pg("15.6", ""), pg("16.2", ""), 0},
+		{"older postgres", pg("16.2", ""), pg("15.6", ""), 1},
+		{"old postgres numbering", pg("9.6.24", ""), pg("9.5.25", ""), 1},
+		{"same mysql series", mysql("8.0.36"), mysql("8.0.40"), 0},
+		{"newer mysql series", mysql("5.7.44-log"), mysql("8.0.36"), 1},
+		{"older mysql series", mysql("8.4.0"), mysql("8.0.36"), 1},
+		{"different engine", pg("16.2", ""), mysql("8.0.36"), 1},
+		{"unknown target version", pg("16.2", ""), pg("", ""), 0},
+		{"schema version", pg("16.2", "20260214"), pg("16.2", "20260301"), 1},
+		{"older postgres and schema", pg("16.2", "20260214"), pg("15.6", "20260301"), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, tt.from.RestoreWarnings(tt.to), tt.warnings)
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	RecordCoordinates(context.Background(), Coordinates{LogFile: "ignored"})
+	RecordNode(context.Background(), Node{Host: "ignored"})
+
+	ctx, recorder := WithRecorder(context.Background())
+	assert.Nil(t, recorder.Node())
+	RecordNode(ctx, Node{Host: "db-replica-1:5432", Standby: true})
+	assert.Equal(t, &Node{Host: "db-replica-1:5432", Standby: true}, recorder.Node())
+
+	RecordCoordinates(ctx, Coordinates{Database: "shop", Kind: CoordinatesSource, LogFile: "binlog.000003", LogPosition: 157})
+	RecordCoordinates(ctx, Coordinates{Database: "crm", Kind: CoordinatesSource, LogFile: "binlog.000003", LogPosition: 2048})
+
+	coordinates := recorder.Coordinates()
+	require.Len(t, coordinates, 2)
+	assert.Equal(t, "shop", coordinates[0].Database)
+	assert.Equal(t, int64(2048), coordinates[1].LogPosition)
+
+	RecordTables(ctx, []TableRows{{Database: "shop", Table: "public.orders", Rows: 42}})
+	RecordTables(ctx, []TableRows{{Database: "crm", Table: "public.leads", Rows: 7}})
+	assert.Equal(t, []TableRows{
+		{Database: "shop", Table: "public.orders", Rows: 42},
+		{Database: "crm", Table: "public.leads", Rows: 7},
+	}, recorder.Tables())
+}
@@ -0,0 +1,101 @@
+package manifest
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+type recorderKey struct{}
+
+// Recorder collects what executors learn during a run that only the run
+// itself can tell, such as binlog coordinates or the node that was dumped
+type Recorder struct {
+	mu          sync.Mutex
+	coordinates []Coordinates
+	node        *Node
+	chain       *Chain
+	tables      []TableRows
+}
+
+// WithRecorder returns a context carrying a new recorder
+func WithRecorder(ctx context.Context) (context.Context, *Recorder) {
+	r := &Recorder{}
+	return context.WithValue(ctx, recorderKey{}, r), r
+}
+
+// RecordCoordinates adds binlog coordinates to the recorder of a context, it
+// does nothing outside of a run
+func RecordCoordinates(ctx context.Context, c Coordinates) {
+	r, ok := ctx.Value(recorderKey{}).(*Recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.coordinates = append(r.coordinates, c)
+}
+
+// RecordNode sets the node that served the run in the recorder of a
+// context, it does nothing outside of a run
+func RecordNode(ctx context.Context, node Node) {
+	r, ok := ctx.Value(recorderKey{}).(*Recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.node = &node
+}
+
+// RecordChain sets the chain the run's backup belongs to in the recorder of
+// a context, it does nothing outside of a run
+func RecordChain(ctx context.Context, chain Chain) {
+	r, ok := ctx.Value(recorderKey{}).(*Recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chain = &chain
+}
+
+// RecordTables adds the row counts of the tables in a dump to the recorder
+// of a context, it does nothing outside of a run
+func RecordTables(ctx context.Context, tables []TableRows) {
+	r, ok := ctx.Value(recorderKey{}).(*Recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tables = append(r.tables, tables...)
+}
+
+// Coordinates returns the recorded binlog coordinates
+func (r *Recorder) Coordinates() []Coordinates {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.coordinates)
+}
+
+// Node returns the recorded node, nil when the executor recorded none
+func (r *Recorder) Node() *Node {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.node
+}
+
+// Chain returns the recorded chain, nil when the executor recorded none
+func (r *Recorder) Chain() *Chain {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.chain
+}
+
+// Tables returns the recorded row counts
+func (r *Recorder) Tables() []TableRows {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.tables)
+}
@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// DefaultMQTTTopic is the topic template of MQTT channels without one
+const DefaultMQTTTopic = "backmeup/{job}/event"
+
+// MQTT 3.1.1 packet types, shifted into the high nibble of the first byte
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttPuback     = 0x40
+	mqttPubrec     = 0x50
+	mqttPubrel     = 0x62 // Carries the reserved flags the spec requires
+	mqttPubcomp    = 0x70
+	mqttDisconnect = 0xE0
+)
+
+// mqttPacketID identifies the one message each connection publishes
+const mqttPacketID = 1
+
+// mqttSender publishes events as JSON to an MQTT broker. It connects for each
+// event, which suits brokers that only see a few runs a day.
+type mqttSender struct {
+	settings config.MQTTSettings
+}
+
+func (s *mqttSender) Send(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode mqtt message: %w", err)
+	}
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mqtt broker: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	r := bufio.NewReader(conn)
+	if _, err := conn.Write(s.connectPacket()); err != nil {
+		return fmt.Errorf("failed to connect to mqtt broker: %w", err)
+	}
+	packetType, body, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mqtt broker: %w", err)
+	}
+	if packetType != mqttConnack || len(body) != 2 {
+		return fmt.Errorf("mqtt broker sent an unexpected packet %#x instead of connack", packetType)
+	}
+	if code := body[1]; code != 0 {
+		return fmt.Errorf("mqtt broker refused the connection: %s", connackReason(code))
+	}
+
+	if _, err := conn.Write(s.publishPacket(Topic(s.settings.Topic, event), payload)); err != nil {
+		return fmt.Errorf("failed to publish mqtt message: %w", err)
+	}
+	switch s.settings.QoS {
+	case 1:
+		err = expectAck(r, mqttPuback)
+	case 2:
+		if err = expectAck(r, mqttPubrec); err == nil {
+			if _, err = conn.Write([]byte{mqttPubrel, 2, 0, mqttPacketID}); err == nil {
+				err = expectAck(r, mqttPubcomp)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("mqtt broker did not acknowledge the message: %w", err)
+	}
+
+	_, _ = conn.Write([]byte{mqttDisconnect, 0})
+	return nil
+}
+
+// Topic fills the {job}, {type} and {status} placeholders of a topic template
+func Topic(template string, event Event) string {
+	if template == "" {
+		template = DefaultMQTTTopic
+	}
+	return strings.NewReplacer("{job}", event.Job, "{type}", event.Type, "{status}", event.Status).Replace(template)
+}
+
+// dial connects to the broker, over TLS for mqtts:// and ssl:// URLs
+func (s *mqttSender) dial(ctx context.Context) (net.Conn, error) {
+	u, err := url.Parse(s.settings.Broker)
+	if err != nil {
+		return nil, err
+	}
+	secure := u.Scheme == "mqtts" || u.Scheme == "ssl"
+	address := u.Host
+	if u.Port() == "" {
+		port := "1883"
+		if secure {
+			port = "8883"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	if secure {
+		dialer := tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+func (s *mqttSender) connectPacket() []byte {
+	clientID := s.settings.ClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = "backmeup-" + hostname
+	}
+
+	flags := byte(0x02) // Clean session
+	payload := mqttString(clientID)
+	if s.settings.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(s.settings.Username)...)
+		if s.settings.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(s.settings.Password)...)
+		}
+	}
+	header := append(mqttString("MQTT"), 4, flags, 0, 60) // Protocol level 4, 60s keep alive
+	return mqttPacket(mqttConnect, append(header, payload...))
+}
+
+func (s *mqttSender) publishPacket(topic string, payload []byte) []byte {
+	packetType := byte(mqttPublish) | byte(s.settings.QoS)<<1
+	if s.settings.Retain {
+		packetType |= 0x01
+	}
+	body := mqttString(topic)
+	if s.settings.QoS > 0 {
+		body = append(body, 0, mqttPacketID)
+	}
+	return mqttPacket(packetType, append(body, payload...))
+}
+
+// mqttPacket prefixes a packet body with its type and variable length
+func mqttPacket(packetType byte, body []byte) []byte {
+	out := []byte{packetType}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+// mqttString encodes a string with its two byte length
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// readPacket reads one packet and returns its first byte and body
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	packetType, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 4 {
+			return 0, nil, fmt.Errorf("malformed packet length")
+		}
+		length += int(b&0x7F) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return packetType, body, nil
+}
+
+// expectAck reads the acknowledgement of the published message
+func expectAck(r *bufio.Reader, ackType byte) error {
+	packetType, body, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if packetType != ackType || len(body) != 2 || binary.BigEndian.Uint16(body) != mqttPacketID {
+		return fmt.Errorf("unexpected packet %#x", packetType)
+	}
+	return nil
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// DefaultCheckService is the service template of passive checks without one
+const DefaultCheckService = "backmeup {job}"
+
+// Nagios plugin states of the run statuses
+const (
+	stateOK       = 0
+	stateWarning  = 1
+	stateCritical = 2
+)
+
+// Field sizes of an NSCA packet. The 512 byte output is the original packet
+// layout, which NSCA 2.9 and later still accept.
+const (
+	nscaVersion     = 3
+	nscaIVSize      = 128
+	nscaHostSize    = 64
+	nscaServiceSize = 128
+	nscaOutputSize  = 512
+	nscaPacketSize  = 720 // The C struct, padded to four bytes
+)
+
+// checkState maps a run status to the state of a passive check
+func checkState(status string) int {
+	switch status {
+	case history.StatusSuccess:
+		return stateOK
+	case history.StatusPartial:
+		return stateWarning
+	}
+	return stateCritical
+}
+
+// checkService fills the {job} placeholder of a service template
+func checkService(template, job string) string {
+	if template == "" {
+		template = DefaultCheckService
+	}
+	return strings.ReplaceAll(template, "{job}", job)
+}
+
+// checkOutput is the plugin output of an event, with the size and duration
+// as performance data
+func checkOutput(event Event) string {
+	return fmt.Sprintf("%s | size=%dB duration=%ds", strings.ReplaceAll(event.Summary(), "|", "/"),
+		event.Size, int64(event.FinishedAt.Sub(event.StartedAt).Seconds()))
+}
+
+// nscaSender submits events as passive check results to an NSCA daemon
+type nscaSender struct {
+	settings config.NSCASettings
+}
+
+func (s *nscaSender) Send(ctx context.Context, event Event) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.settings.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to nsca: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// The daemon opens with the IV and the timestamp to send back
+	init := make([]byte, nscaIVSize+4)
+	if _, err := io.ReadFull(conn, init); err != nil {
+		return fmt.Errorf("failed to read nsca greeting: %w", err)
+	}
+	packet := s.packet(event, init[:nscaIVSize], binary.BigEndian.Uint32(init[nscaIVSize:]))
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to send nsca check result: %w", err)
+	}
+	return nil
+}
+
+func (s *nscaSender) packet(event Event, iv []byte, timestamp uint32) []byte {
+	packet := make([]byte, nscaPacketSize)
+	binary.BigEndian.PutUint16(packet[0:], nscaVersion)
+	binary.BigEndian.PutUint32(packet[8:], timestamp)
+	binary.BigEndian.PutUint16(packet[12:], uint16(checkState(event.Status)))
+	fields := packet[14:]
+	copy(fields[:nscaHostSize-1], s.settings.Host)
+	copy(fields[nscaHostSize:nscaHostSize+nscaServiceSize-1], checkService(s.settings.Service, event.Job))
+	copy(fields[nscaHostSize+nscaServiceSize:nscaHostSize+nscaServiceSize+nscaOutputSize-1], checkOutput(event))
+	binary.BigEndian.PutUint32(packet[4:], crc32.ChecksumIEEE(packet))
+
+	if s.settings.Encryption != "none" {
+		for i := range packet {
+			packet[i] ^= iv[i%len(iv)]
+		}
+		if password := s.settings.Password; password != "" {
+			for i := range packet {
+				packet[i] ^= password[i%len(password)]
+			}
+		}
+	}
+	return packet
+}
+
+// icingaSender submits events as passive check results through the Icinga 2
+// API
+type icingaSender struct {
+	settings config.IcingaSettings
+}
+
+type icingaCheckResult struct {
+	Type         string `json:"type"`
+	Filter       string `json:"filter"`
+	ExitStatus   int    `json:"exit_status"`
+	PluginOutput string `json:"plugin_output"`
+}
+
+func (s *icingaSender) Send(ctx context.Context, event Event) error {
+	service := checkService(s.settings.Service, event.Job)
+	body, err := json.Marshal(icingaCheckResult{
+		Type:         "Service",
+		Filter:       fmt.Sprintf("host.name==%q && service.name==%q", s.settings.Host, service),
+		ExitStatus:   checkState(event.Status),
+		PluginOutput: checkOutput(event),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode icinga check result: %w", err)
+	}
+
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(s.settings.URL, "/")+"/v1/actions/process-check-result", strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(s.settings.Username, s.settings.Password)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send icinga check result: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("icinga returned %s", resp.Status)
+	}
+
+	// A filter that matches no service is not an error to the API
+	var result struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && len(result.Results) == 0 {
+		return fmt.Errorf("icinga has no service %q on host %q", service, s.settings.Host)
+	}
+	return nil
+}
+
+// client trusts the configured CA, as Icinga usually runs with its own
+func (s *icingaSender) client() (*http.Client, error) {
+	if s.settings.CACert == "" {
+		return &http.Client{Timeout: sendTimeout}, nil
+	}
+	pem, err := os.ReadFile(s.settings.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read icinga ca_cert: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("icinga ca_cert %s holds no certificates", s.settings.CACert)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Timeout: sendTimeout, Transport: transport}, nil
+}
@@ -0,0 +1,303 @@
+// Package notify sends the outcome of backup runs to the channels configured
+// for each job
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/redact"
+)
+
+// sendTimeout bounds the delivery of one event to one channel
+var sendTimeout = 30 * time.Second
+
+// Values of a channel's when list
+const (
+	WhenSuccess = "success"
+	WhenFailure = "failure" // Failed and partial runs
+	WhenStarted = "started" // Runs that begin, only sent to MQTT
+)
+
+// StatusRunning is the status of the events of runs that begin
+const StatusRunning = "running"
+
+// Event is the outcome of a run as sent to a channel
+type Event struct {
+	Job        string    `json:"job"`
+	Type       string    `json:"type"`
+	RunID      string    `json:"run_id,omitempty"`
+	Status     string    `json:"status"` // A history status: success, failed or partial, or running
+	Error      string    `json:"error,omitempty"`
+	ErrorCode  string    `json:"error_code,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Size       int64     `json:"size"`
+
+	// Last lines the failing tool wrote to stderr, with secrets masked
+	ErrorExcerpt []string `json:"error_excerpt,omitempty"`
+
+	// Outcomes of the copies to the job's storage destinations, with secrets
+	// masked
+	Destinations []history.Artifact `json:"destinations,omitempty"`
+
+	// Static labels of the job from its configuration, for routing
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Identical failures in a row, set on the updates sent in place of
+	// repeated notifications
+	Occurrences int `json:"occurrences,omitempty"`
+
+	// Failed runs in a row, counting this one
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+}
+
+// Failed reports whether the event is a failed or partial run
+func (e Event) Failed() bool {
+	return e.Status == history.StatusFailed || e.Status == history.StatusPartial
+}
+
+// Summary is a one line description of the event
+func (e Event) Summary() string {
+	switch {
+	case e.Status == StatusRunning:
+		return fmt.Sprintf("Backup job %s started", e.Job)
+	case !e.Failed():
+		return fmt.Sprintf("Backup job %s succeeded in %s", e.Job, e.FinishedAt.Sub(e.StartedAt).Round(time.Second))
+	case e.Occurrences > 1:
+		return fmt.Sprintf("Backup job %s is still failing, %d occurrences: %s", e.Job, e.Occurrences, e.Error)
+	case e.Status == history.StatusPartial:
+		return fmt.Sprintf("Backup job %s completed partially: %s", e.Job, e.Error)
+	}
+	return fmt.Sprintf("Backup job %s failed: %s", e.Job, e.Error)
+}
+
+// Text is the summary of the event followed by the excerpt of the failing
+// tool's output, for channels that send plain text
+func (e Event) Text() string {
+	if len(e.ErrorExcerpt) == 0 {
+		return e.Summary()
+	}
+	return e.Summary() + "\n\n" + strings.Join(e.ErrorExcerpt, "\n")
+}
+
+// Sender delivers events to one channel
+type Sender interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// Source provides the configuration of the scheduled jobs
+type Source interface {
+	JobConfig(jobName string) (config.JobConfig, bool)
+}
+
+// Notifier sends every finished run of a job to the job's channels, and
+// holds back failures that repeat within the job's dedup window
+type Notifier struct {
+	ctx      context.Context // Abandons the notifications in flight when done
+	source   Source
+	redactor *redact.Redactor
+	client   *http.Client
+	started  time.Time
+
+	mu       sync.Mutex
+	failures map[string]*failureState // Current failure of each job
+	wg       sync.WaitGroup
+}
+
+// failureState tracks the failure a job keeps repeating
+type failureState struct {
+	streak      int       // Runs that failed in a row
+	key         string    // Error code and message
+	occurrences int       // Runs that failed with it in a row
+	notified    time.Time // When it was last sent
+}
+
+// New creates a notifier that masks secrets in the events it sends. Sends
+// still in flight are given up once ctx is done.
+func New(ctx context.Context, source Source, redactor *redact.Redactor) *Notifier {
+	return &Notifier{
+		ctx:      ctx,
+		source:   source,
+		redactor: redactor,
+		client:   &http.Client{Timeout: sendTimeout},
+		started:  time.Now(),
+		failures: make(map[string]*failureState),
+	}
+}
+
+// RunFinished sends a finished run to the job's channels in the background,
+// to be registered as a scheduler run callback
+func (n *Notifier) RunFinished(run history.Run) {
+	jobConfig, ok := n.source.JobConfig(run.Job)
+	if !ok || !jobConfig.Notification.Enabled {
+		return
+	}
+
+	event := Event{
+		Job:        run.Job,
+		Type:       run.Type,
+		RunID:      run.ID,
+		Status:     run.Status,
+		Error:      n.redactor.String(run.Error),
+		ErrorCode:  run.ErrorCode,
+		StartedAt:  run.StartedAt,
+		FinishedAt: run.FinishedAt,
+		Size:       run.Size,
+		Labels:     jobConfig.Labels,
+	}
+	for _, line := range run.ErrorExcerpt {
+		event.ErrorExcerpt = append(event.ErrorExcerpt, n.redactor.String(line))
+	}
+	for _, c := range run.Destinations {
+		c.Error = n.redactor.String(c.Error)
+		event.Destinations = append(event.Destinations, c)
+	}
+	if !n.dedup(jobConfig.Notification.DedupWindow, &event) {
+		log.Printf("[Job: %s] Holding back a repeated failure notification", run.Job)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.send(jobConfig, event)
+	}()
+}
+
+// RunStarted sends the start of a run to the job's MQTT channel when it
+// wants started runs
+func (n *Notifier) RunStarted(jobName string, at time.Time) {
+	jobConfig, ok := n.source.JobConfig(jobName)
+	if !ok || !jobConfig.Notification.Enabled {
+		return
+	}
+	m := jobConfig.Notification.MQTT
+	if m == nil || !slices.Contains(m.When, WhenStarted) {
+		return
+	}
+
+	event := Event{Job: jobName, Type: jobConfig.Type, Status: StatusRunning, StartedAt: at, Labels: jobConfig.Labels}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ctx, cancel := context.WithTimeout(n.ctx, sendTimeout)
+		defer cancel()
+		if err := (&mqttSender{settings: *m}).Send(ctx, event); err != nil {
+			log.Printf("[Job: %s] Failed to send mqtt notification: %s", jobName, n.redactor.String(err.Error()))
+		}
+	}()
+}
+
+// Wait blocks until the notifications in flight are sent or given up
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// dedup counts the failures of a job in a row and reports whether an event
+// should be sent. A failure identical to the job's previous one is held back
+// until the window has passed since it was last sent, and is then sent with
+// the number of occurrences. A success ends the failure.
+func (n *Notifier) dedup(window time.Duration, event *Event) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !event.Failed() {
+		delete(n.failures, event.Job)
+		return true
+	}
+
+	state, ok := n.failures[event.Job]
+	if !ok {
+		state = &failureState{}
+		n.failures[event.Job] = state
+	}
+	state.streak++
+	event.ConsecutiveFailures = state.streak
+
+	key := event.ErrorCode + "\x00" + event.Error
+	if window <= 0 || state.key != key {
+		state.key, state.occurrences, state.notified = key, 1, event.FinishedAt
+		return true
+	}
+
+	state.occurrences++
+	if event.FinishedAt.Sub(state.notified) < window {
+		return false
+	}
+	state.notified = event.FinishedAt
+	event.Occurrences = state.occurrences
+	return true
+}
+
+// send delivers an event to every channel of the job that wants it, giving
+// each channel its own timeout so a slow one does not starve the others
+func (n *Notifier) send(jobConfig config.JobConfig, event Event) {
+	for name, sender := range n.senders(jobConfig, event) {
+		ctx, cancel := context.WithTimeout(n.ctx, sendTimeout)
+		err := sender.Send(ctx, event)
+		cancel()
+		if err != nil {
+			log.Printf("[Job: %s] Failed to send %s notification: %s", event.Job, name, n.redactor.String(err.Error()))
+		}
+	}
+}
+
+// senders returns the channels of a job that want an event, by name
+func (n *Notifier) senders(jobConfig config.JobConfig, event Event) map[string]Sender {
+	settings := jobConfig.Notification
+	senders := make(map[string]Sender)
+	if d := settings.Discord; d != nil && wants(d.When, event) {
+		senders["discord"] = &discordSender{settings: *d, nextRun: jobConfig.NextRun, client: n.client}
+	}
+	if m := settings.Matrix; m != nil && wants(m.When, event) {
+		senders["matrix"] = &matrixSender{settings: *m, client: n.client}
+	}
+	if s := settings.Signal; s != nil && wants(s.When, event) {
+		senders["signal"] = &signalSender{settings: *s, client: n.client}
+	}
+	if s := settings.Syslog; s != nil && wants(s.When, event) {
+		senders["syslog"] = &syslogSender{settings: *s}
+	}
+	if s := settings.SNMP; s != nil && wants(s.When, event) {
+		senders["snmp"] = &snmpSender{settings: *s, started: n.started}
+	}
+	if s := settings.SNS; s != nil && wants(s.When, event) {
+		senders["sns"] = &snsSender{settings: *s, client: n.client}
+	}
+	if p := settings.PubSub; p != nil && wants(p.When, event) {
+		senders["pubsub"] = &pubSubSender{settings: *p, client: n.client}
+	}
+	if m := settings.MQTT; m != nil && wants(m.When, event) {
+		senders["mqtt"] = &mqttSender{settings: *m}
+	}
+	if c := settings.NSCA; c != nil {
+		senders["nsca"] = &nscaSender{settings: *c}
+	}
+	if c := settings.Icinga; c != nil {
+		senders["icinga"] = &icingaSender{settings: *c}
+	}
+	if c := settings.Zabbix; c != nil {
+		senders["zabbix"] = &zabbixSender{settings: *c}
+	}
+	if w := settings.Webhook; w != nil {
+		senders["webhook"] = &webhookSender{settings: *w, client: n.client}
+	}
+	return senders
+}
+
+// wants reports whether a when list includes an event
+func wants(when []string, event Event) bool {
+	if event.Failed() {
+		return slices.Contains(when, WhenFailure)
+	}
+	return slices.Contains(when, WhenSuccess)
+}
@@ -0,0 +1,747 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/redact"
+)
+
+type jobSource map[string]config.JobConfig
+
+func (s jobSource) JobConfig(jobName string) (config.JobConfig, bool) {
+	job, ok := s[jobName]
+	return job, ok
+}
+
+// recorder is an HTTP endpoint that keeps every request it receives
+type recorder struct {
+	mu       sync.Mutex
+	bodies   []string
+	requests []*http.Request
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, string(body))
+	r.requests = append(r.requests, req)
+}
+
+func (r *recorder) received() ([]string, []*http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...), append([]*http.Request(nil), r.requests...)
+}
+
+func failedRun(job, err string, at time.Time) history.Run {
+	return history.Run{Job: job, Type: "postgres", Status: history.StatusFailed, Error: err, ErrorCode: "connection",
+		StartedAt: at.Add(-time.Minute), FinishedAt: at}
+}
+
+func TestRunFinished(t *testing.T) {
+	discord, webhook := &recorder{}, &recorder{}
+	discordServer, webhookServer := httptest.NewServer(discord), httptest.NewServer(webhook)
+	defer discordServer.Close()
+	defer webhookServer.Close()
+
+	source := jobSource{
+		"orders": {Name: "orders", Labels: map[string]string{"team": "payments"}, Notification: config.Notification{
+			Enabled: true,
+			Discord: &config.DiscordSettings{When: []string{WhenFailure}, WebhookURL: discordServer.URL},
+			Webhook: &config.WebhookSettings{URL: webhookServer.URL, AuthToken: "token-1234", Headers: map[string]string{"X-Team": "db"}},
+		}},
+		"quiet": {Name: "quiet", Notification: config.Notification{
+			Webhook: &config.WebhookSettings{URL: webhookServer.URL},
+		}},
+	}
+	n := New(context.Background(), source, redact.New("hunter22"))
+
+	now := time.Now()
+	n.RunFinished(failedRun("orders", "connection refused, password hunter22", now))
+	n.RunFinished(history.Run{Job: "orders", Status: history.StatusSuccess, StartedAt: now, FinishedAt: now.Add(time.Minute)})
+	n.RunFinished(failedRun("quiet", "disabled", now))
+	n.RunFinished(failedRun("unknown", "not scheduled", now))
+	n.Wait()
+
+	bodies, _ := discord.received()
+	require.Len(t, bodies, 1, "the discord channel only wants failures")
+	var msg discordMessage
+	require.NoError(t, json.Unmarshal([]byte(bodies[0]), &msg))
+	require.Len(t, msg.Embeds, 1)
+	assert.Equal(t, "Backup job orders failed: connection refused, password ***", msg.Embeds[0].Title)
+
+	bodies, requests := webhook.received()
+	require.Len(t, bodies, 2)
+	assert.Equal(t, "Bearer token-1234", requests[0].Header.Get("Authorization"))
+	assert.Equal(t, "db", requests[0].Header.Get("X-Team"))
+	assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+
+	var events []Event
+	for _, body := range bodies {
+		var event Event
+		require.NoError(t, json.Unmarshal([]byte(body), &event))
+		events = append(events, event)
+	}
+	assert.ElementsMatch(t, []string{history.StatusFailed, history.StatusSuccess}, []string{events[0].Status, events[1].Status})
+	assert.Equal(t, map[string]string{"team": "payments"}, events[0].Labels)
+}
+
+func TestRunFinished_Destinations(t *testing.T) {
+	webhook := &recorder{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	source := jobSource{"orders": {Name: "orders", Notification: config.Notification{
+		Enabled: true,
+		Webhook: &config.WebhookSettings{URL: server.URL},
+	}}}
+	n := New(context.Background(), source, redact.New("hunter22"))
+
+	now := time.Now()
+	n.RunFinished(history.Run{Job: "orders", Status: history.StatusPartial, StartedAt: now, FinishedAt: now,
+		Error: "copy to 1 of 2 destinations failed: offsite: access denied for hunter22", ErrorCode: "unknown",
+		Destinations: []history.Artifact{
+			{Name: "nas", Status: history.StatusSuccess},
+			{Name: "offsite", Status: history.StatusFailed, Error: "access denied for hunter22", ErrorCode: "unknown"},
+		}})
+	n.Wait()
+
+	bodies, _ := webhook.received()
+	require.Len(t, bodies, 1)
+	var event Event
+	require.NoError(t, json.Unmarshal([]byte(bodies[0]), &event))
+	assert.Equal(t, []history.Artifact{
+		{Name: "nas", Status: history.StatusSuccess},
+		{Name: "offsite", Status: history.StatusFailed, Error: "access denied for ***", ErrorCode: "unknown"},
+	}, event.Destinations)
+	assert.Equal(t, "Backup job orders completed partially: copy to 1 of 2 destinations failed: offsite: access denied for ***", event.Summary())
+}
+
+// staller is an HTTP endpoint that answers nothing until it is closed
+type staller chan struct{}
+
+func (s staller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	<-s
+}
+
+func TestRunFinished_TimeoutPerChannel(t *testing.T) {
+	defer func(timeout time.Duration) { sendTimeout = timeout }(sendTimeout)
+	sendTimeout = 200 * time.Millisecond
+
+	discord, webhook := make(staller), &recorder{}
+	discordServer, webhookServer := httptest.NewServer(discord), httptest.NewServer(webhook)
+	defer discordServer.Close()
+	defer close(discord)
+	defer webhookServer.Close()
+
+	source := jobSource{"orders": {Name: "orders", Notification: config.Notification{
+		Enabled: true,
+		Discord: &config.DiscordSettings{When: []string{WhenFailure}, WebhookURL: discordServer.URL},
+		Webhook: &config.WebhookSettings{URL: webhookServer.URL},
+	}}}
+	n := New(context.Background(), source, redact.New())
+
+	// Channels are sent in no particular order, so a few runs make sure the
+	// webhook also comes after the stalled discord channel
+	now := time.Now()
+	for i := range 3 {
+		n.RunFinished(failedRun("orders", fmt.Sprintf("failure %d", i), now))
+	}
+	n.Wait()
+
+	bodies, _ := webhook.received()
+	assert.Len(t, bodies, 3, "a stalled channel must not use up the time of the others")
+}
+
+func TestRunFinished_Stopped(t *testing.T) {
+	webhook := make(staller)
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+	defer close(webhook)
+
+	source := jobSource{"orders": {Name: "orders", Notification: config.Notification{
+		Enabled: true,
+		Webhook: &config.WebhookSettings{URL: server.URL},
+	}}}
+	ctx, stop := context.WithCancel(context.Background())
+	n := New(ctx, source, redact.New())
+	n.RunFinished(failedRun("orders", "connection refused", time.Now()))
+
+	stop()
+	done := make(chan struct{})
+	go func() {
+		n.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notifications in flight were not given up when the notifier stopped")
+	}
+}
+
+func TestDedup(t *testing.T) {
+	n := New(context.Background(), jobSource{}, redact.New())
+	start := time.Now()
+	window := time.Hour
+
+	send := func(run history.Run) (Event, bool) {
+		event := Event{Job: run.Job, Status: run.Status, Error: run.Error, ErrorCode: run.ErrorCode, FinishedAt: run.FinishedAt}
+		sent := n.dedup(window, &event)
+		return event, sent
+	}
+
+	_, sent := send(failedRun("orders", "connection refused", start))
+	assert.True(t, sent, "the first failure is sent")
+	for i := 1; i <= 3; i++ {
+		_, sent = send(failedRun("orders", "connection refused", start.Add(time.Duration(i)*10*time.Minute)))
+		assert.False(t, sent, "repeats within the window are held back")
+	}
+	_, sent = send(failedRun("billing", "connection refused", start.Add(10*time.Minute)))
+	assert.True(t, sent, "jobs are tracked separately")
+
+	event, sent := send(failedRun("orders", "connection refused", start.Add(window)))
+	assert.True(t, sent)
+	assert.Equal(t, 5, event.Occurrences)
+	assert.Equal(t, "Backup job orders is still failing, 5 occurrences: connection refused", event.Summary())
+
+	event, sent = send(failedRun("orders", "disk full", start.Add(window+time.Minute)))
+	assert.True(t, sent, "a different error is sent right away")
+	assert.Zero(t, event.Occurrences)
+	assert.Equal(t, 6, event.ConsecutiveFailures)
+
+	_, sent = send(history.Run{Job: "orders", Status: history.StatusSuccess, FinishedAt: start.Add(window + 2*time.Minute)})
+	assert.True(t, sent)
+	event, sent = send(failedRun("orders", "disk full", start.Add(window+3*time.Minute)))
+	assert.True(t, sent, "a success ends the failure")
+	assert.Equal(t, 1, event.ConsecutiveFailures)
+
+	window = 0
+	for range 2 {
+		_, sent = send(failedRun("orders", "disk full", start.Add(window+4*time.Minute)))
+		assert.True(t, sent, "without a window every failure is sent")
+	}
+}
+
+func TestDiscordMessage(t *testing.T) {
+	finished := time.Date(2026, 3, 1, 2, 5, 0, 0, time.Local)
+	sender := &discordSender{
+		settings: config.DiscordSettings{MentionUsers: []string{"1001"}, MentionRoles: []string{"2002"}, MentionAfter: 2},
+		nextRun:  config.JobConfig{Schedule: "0 2 * * *"}.NextRun,
+	}
+	event := Event{Job: "orders", Status: history.StatusFailed, Error: "disk full @everyone", ErrorCode: "storage",
+		StartedAt: finished.Add(-90 * time.Second), FinishedAt: finished, Size: 3 << 20, ConsecutiveFailures: 1}
+
+	msg := sender.message(event)
+	assert.Empty(t, msg.Content, "the first failure is below mention_after")
+	assert.Empty(t, msg.AllowedMentions.Parse)
+	require.Len(t, msg.Embeds, 1)
+	embed := msg.Embeds[0]
+	assert.Equal(t, colorFailed, embed.Color)
+	assert.Equal(t, "disk full @everyone", embed.Description)
+	next := finished.Add(24*time.Hour - 5*time.Minute).Unix()
+	assert.Equal(t, []discordField{
+		{Name: "Status", Value: "failed", Inline: true},
+		{Name: "Size", Value: "3.0 MiB", Inline: true},
+		{Name: "Duration", Value: "1m30s", Inline: true},
+		{Name: "Next run", Value: fmt.Sprintf("<t:%d:f> (<t:%d:R>)", next, next), Inline: true},
+		{Name: "Error code", Value: "storage", Inline: true},
+	}, embed.Fields)
+
+	event.ConsecutiveFailures = 2
+	msg = sender.message(event)
+	assert.Equal(t, "<@1001> <@&2002>", msg.Content)
+	assert.Equal(t, []string{"1001"}, msg.AllowedMentions.Users)
+	assert.Equal(t, []string{"2002"}, msg.AllowedMentions.Roles)
+
+	event.Status, event.Error, event.ErrorCode, event.ConsecutiveFailures = history.StatusSuccess, "", "", 0
+	msg = sender.message(event)
+	assert.Empty(t, msg.Content, "successes never mention")
+	assert.Equal(t, colorSuccess, msg.Embeds[0].Color)
+
+	sender.nextRun = config.JobConfig{}.NextRun
+	msg = sender.message(event)
+	assert.Len(t, msg.Embeds[0].Fields, 3, "jobs without a schedule have no next run")
+
+	event.Status, event.ErrorExcerpt = history.StatusFailed, []string{strings.Repeat("x", 2000), "pg_dump: error: disk full"}
+	fields := sender.message(event).Embeds[0].Fields
+	output := fields[len(fields)-1]
+	assert.Equal(t, "Tool output", output.Name)
+	assert.False(t, output.Inline)
+	assert.LessOrEqual(t, len([]rune(output.Value)), 1024)
+	assert.True(t, strings.HasSuffix(output.Value, "\npg_dump: error: disk full\n```"), "the end of the output is kept")
+}
+
+func TestMatrixAndSignal(t *testing.T) {
+	matrix, signal := &recorder{}, &recorder{}
+	matrixServer, signalServer := httptest.NewServer(matrix), httptest.NewServer(signal)
+	defer matrixServer.Close()
+	defer signalServer.Close()
+
+	source := jobSource{"orders": {Name: "orders", Notification: config.Notification{
+		Enabled: true,
+		Matrix:  &config.MatrixSettings{When: []string{WhenFailure}, Homeserver: matrixServer.URL + "/", AccessToken: "syt_secret", RoomID: "!room:example.org"},
+		Signal:  &config.SignalSettings{When: []string{WhenSuccess, WhenFailure}, URL: signalServer.URL, Number: "+15550100", Recipients: []string{"+15550101"}},
+	}}}
+	n := New(context.Background(), source, redact.New())
+
+	now := time.Now()
+	run := failedRun("orders", "connection refused", now)
+	run.ErrorExcerpt = []string{"pg_dump: error: connection refused"}
+	n.RunFinished(run)
+	n.RunFinished(history.Run{Job: "orders", Status: history.StatusSuccess, StartedAt: now, FinishedAt: now.Add(time.Minute)})
+	n.Wait()
+
+	bodies, requests := matrix.received()
+	require.Len(t, bodies, 1, "the matrix room only wants failures")
+	assert.Equal(t, http.MethodPut, requests[0].Method)
+	assert.Regexp(t, `^/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/backmeup-\d+$`, requests[0].URL.Path)
+	assert.Equal(t, "Bearer syt_secret", requests[0].Header.Get("Authorization"))
+	assert.JSONEq(t, `{"msgtype": "m.notice", "body": "Backup job orders failed: connection refused\n\npg_dump: error: connection refused"}`, bodies[0])
+
+	bodies, requests = signal.received()
+	require.Len(t, bodies, 2)
+	assert.Equal(t, "/v2/send", requests[0].URL.Path)
+	var messages []string
+	for _, body := range bodies {
+		var msg signalMessage
+		require.NoError(t, json.Unmarshal([]byte(body), &msg))
+		assert.Equal(t, "+15550100", msg.Number)
+		assert.Equal(t, []string{"+15550101"}, msg.Recipients)
+		messages = append(messages, msg.Message)
+	}
+	assert.Contains(t, messages, "Backup job orders failed: connection refused\n\npg_dump: error: connection refused")
+}
+
+func TestSyslogMessage(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	finished := time.Date(2026, 3, 1, 2, 5, 0, 0, time.UTC)
+	event := Event{Job: "orders", Type: "postgres", RunID: "r1", Status: history.StatusFailed, Error: `quote " and ] bracket`,
+		ErrorCode: "connection", StartedAt: finished.Add(-90 * time.Second), FinishedAt: finished, Size: 42}
+	sender := &syslogSender{settings: config.SyslogSettings{Address: conn.LocalAddr().String(), Facility: "local3"}}
+	require.NoError(t, sender.Send(context.Background(), event))
+
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	hostname, _ := os.Hostname()
+	assert.Regexp(t, regexp.MustCompile(`^<155>1 \S+ `+regexp.QuoteMeta(hostname)+` backmeup \d+ FAILED `+
+		regexp.QuoteMeta(`[backmeup@32473 job="orders" type="postgres" status="failed" run_id="r1" error_code="connection" size="42" duration="90"] `+
+			`Backup job orders failed: quote " and ] bracket`)+`$`), string(buf[:n]))
+
+	event.Status = history.StatusPartial
+	msg := (&syslogSender{}).message(event, finished)
+	assert.True(t, strings.HasPrefix(msg, "<28>1 2026-03-01T02:05:00.000000Z "), msg)
+	assert.Contains(t, msg, `status="partial"`)
+	assert.Equal(t, `a \\ \" \]`, escapeParam(`a \ " ]`))
+}
+
+func TestSNMPTrap(t *testing.T) {
+	oid, err := encodeOID("1.3.6.1.4.1.32473.1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x06, 0x09, 0x2B, 0x06, 0x01, 0x04, 0x01, 0x81, 0xFD, 0x59, 0x01}, oid)
+	_, err = encodeOID("enterprises")
+	assert.Error(t, err)
+
+	assert.Equal(t, []byte{0x00}, encodeInteger(0))
+	assert.Equal(t, []byte{0x00, 0x80}, encodeInteger(128))
+	assert.Equal(t, []byte{0x01, 0x2C}, encodeInteger(300))
+	assert.Equal(t, []byte{0xFF}, encodeInteger(-1))
+	assert.Equal(t, []byte{0xFF, 0x7F}, encodeInteger(-129))
+	assert.Equal(t, []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF}, encodeUnsigned(0xFFFFFFFF))
+	assert.Equal(t, []byte{0x04, 0x81, 0xC8}, tlv(tagOctetString, make([]byte, 200))[:3])
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	started := time.Now().Add(-time.Minute)
+	sender := &snmpSender{settings: config.SNMPSettings{Address: conn.LocalAddr().String(), Community: "backups"}, started: started}
+	event := Event{Job: "orders", Status: history.StatusPartial, Error: "2 of 3 databases", StartedAt: started, FinishedAt: started.Add(time.Minute)}
+	require.NoError(t, sender.Send(context.Background(), event))
+
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	packet := buf[:n]
+
+	assert.Equal(t, byte(tagSequence), packet[0])
+	header := []byte{0x02, 0x01, 0x01, 0x04, 0x07, 'b', 'a', 'c', 'k', 'u', 'p', 's', tagTrapPDU}
+	assert.True(t, bytes.Contains(packet, header), "version 2c, the community and a trap pdu")
+
+	trapOID, err := encodeOID(DefaultEnterpriseOID + ".0.3")
+	require.NoError(t, err)
+	name, err := encodeOID(oidSnmpTrapOID)
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(packet, tlv(tagSequence, concat(name, trapOID))), "partial runs send the .0.3 trap")
+
+	name, err = encodeOID(DefaultEnterpriseOID + ".1.1")
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(packet, tlv(tagSequence, concat(name, tlv(tagOctetString, []byte("orders"))))))
+	name, err = encodeOID(DefaultEnterpriseOID + ".1.6")
+	require.NoError(t, err)
+	assert.True(t, bytes.Contains(packet, tlv(tagSequence, concat(name, tlv(tagInteger, []byte{60})))))
+}
+
+func TestSignV4(t *testing.T) {
+	// The post-x-www-form-urlencoded case of the AWS Signature Version 4 test suite
+	body := "Param1=value1"
+	req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	creds := credentials.Value{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, []byte(body), creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		req.Header.Get("Authorization"))
+}
+
+func TestSNS(t *testing.T) {
+	var form url.Values
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+	}))
+	defer server.Close()
+
+	sender := &snsSender{settings: config.SNSSettings{
+		TopicARN: "arn:aws:sns:eu-west-1:123456789012:backups", Endpoint: server.URL, AccessKey: "AKID", SecretKey: "secret",
+	}, client: server.Client()}
+	event := Event{Job: "orders", Status: history.StatusFailed, Error: "disk full"}
+	require.NoError(t, sender.Send(context.Background(), event))
+
+	assert.Regexp(t, `^AWS4-HMAC-SHA256 Credential=AKID/\d{8}/eu-west-1/sns/aws4_request, `, auth)
+	assert.Equal(t, "Publish", form.Get("Action"))
+	assert.Equal(t, "arn:aws:sns:eu-west-1:123456789012:backups", form.Get("TopicArn"))
+	assert.Equal(t, "status", form.Get("MessageAttributes.entry.2.Name"))
+	assert.Equal(t, "failed", form.Get("MessageAttributes.entry.2.Value.StringValue"))
+	var published Event
+	require.NoError(t, json.Unmarshal([]byte(form.Get("Message")), &published))
+	assert.Equal(t, event.Job, published.Job)
+	assert.Equal(t, event.Error, published.Error)
+}
+
+func TestPubSub(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	var published pubSubRequest
+	var auth, path string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "ya29.token", "expires_in": 3600}`))
+	})
+	mux.HandleFunc("POST /v1/", func(w http.ResponseWriter, r *http.Request) {
+		auth, path = r.Header.Get("Authorization"), r.URL.Path
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&published))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "key.json")
+	key, err := json.Marshal(serviceAccountKey{
+		ClientEmail: "backmeup@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    server.URL + "/token",
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyFile, key, 0o600))
+
+	sender := &pubSubSender{settings: config.PubSubSettings{
+		Topic: "projects/ops/topics/backups", Endpoint: server.URL, CredentialsFile: keyFile,
+	}, client: server.Client()}
+	require.NoError(t, sender.Send(context.Background(), Event{Job: "orders", Status: history.StatusSuccess}))
+
+	assert.Equal(t, "Bearer ya29.token", auth)
+	assert.Equal(t, "/v1/projects/ops/topics/backups:publish", path)
+	require.Len(t, published.Messages, 1)
+	assert.Equal(t, map[string]string{"job": "orders", "status": "success"}, published.Messages[0].Attributes)
+	data, err := base64.StdEncoding.DecodeString(published.Messages[0].Data)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"job":"orders"`)
+}
+
+// fakeBroker accepts one MQTT connection and acknowledges what it publishes
+type fakeBroker struct {
+	listener net.Listener
+	packets  chan []byte // First byte and body of every packet received
+}
+
+func newFakeBroker(t *testing.T, connackCode byte) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &fakeBroker{listener: listener, packets: make(chan []byte, 10)}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			packetType, body, err := readPacket(r)
+			if err != nil {
+				close(b.packets)
+				return
+			}
+			b.packets <- append([]byte{packetType}, body...)
+			switch {
+			case packetType == mqttConnect:
+				_, _ = conn.Write([]byte{mqttConnack, 2, 0, connackCode})
+			case packetType&0xF0 == mqttPublish && packetType&0x06 == 2:
+				_, _ = conn.Write([]byte{mqttPuback, 2, 0, mqttPacketID})
+			case packetType&0xF0 == mqttPublish && packetType&0x06 == 4:
+				_, _ = conn.Write([]byte{mqttPubrec, 2, 0, mqttPacketID})
+			case packetType == mqttPubrel:
+				_, _ = conn.Write([]byte{mqttPubcomp, 2, 0, mqttPacketID})
+			}
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+func TestMQTT(t *testing.T) {
+	broker := newFakeBroker(t, 0)
+	sender := &mqttSender{settings: config.MQTTSettings{
+		Broker: "mqtt://" + broker.listener.Addr().String(), Topic: "home/backups/{job}/{status}",
+		QoS: 2, Retain: true, ClientID: "nas", Username: "backmeup", Password: "hunter22",
+	}}
+	require.NoError(t, sender.Send(context.Background(), Event{Job: "photos", Status: history.StatusSuccess}))
+
+	var packets [][]byte
+	for p := range broker.packets {
+		packets = append(packets, p)
+	}
+	require.Len(t, packets, 4, "connect, publish, pubrel and disconnect")
+
+	connect := packets[0]
+	assert.Equal(t, byte(mqttConnect), connect[0])
+	assert.Equal(t, concat(mqttString("MQTT"), []byte{4, 0xC2, 0, 60}, mqttString("nas"), mqttString("backmeup"), mqttString("hunter22")), connect[1:])
+
+	publish := packets[1]
+	assert.Equal(t, byte(mqttPublish|2<<1|1), publish[0], "qos 2 and retained")
+	topic := mqttString("home/backups/photos/success")
+	assert.Equal(t, topic, publish[1:1+len(topic)])
+	assert.Equal(t, []byte{0, mqttPacketID}, publish[1+len(topic):3+len(topic)])
+	var event Event
+	require.NoError(t, json.Unmarshal(publish[3+len(topic):], &event))
+	assert.Equal(t, "photos", event.Job)
+
+	assert.Equal(t, []byte{mqttPubrel, 0, mqttPacketID}, packets[2])
+	assert.Equal(t, []byte{mqttDisconnect}, packets[3])
+
+	refused := newFakeBroker(t, 4)
+	sender.settings.Broker = "mqtt://" + refused.listener.Addr().String()
+	assert.ErrorContains(t, sender.Send(context.Background(), Event{Job: "photos"}), "bad username or password")
+
+	assert.Equal(t, "backmeup/photos/event", Topic("", Event{Job: "photos"}))
+	assert.Equal(t, []byte{mqttPublish, 0x80, 0x01}, mqttPacket(mqttPublish, make([]byte, 128))[:3])
+}
+
+func TestRunStarted(t *testing.T) {
+	broker := newFakeBroker(t, 0)
+	source := jobSource{
+		"photos": {Name: "photos", Type: "files", Notification: config.Notification{Enabled: true, MQTT: &config.MQTTSettings{
+			When: []string{WhenStarted}, Broker: "tcp://" + broker.listener.Addr().String(),
+		}}},
+		"music": {Name: "music", Type: "files", Notification: config.Notification{Enabled: true, MQTT: &config.MQTTSettings{
+			When: []string{WhenFailure}, Broker: "tcp://" + broker.listener.Addr().String(),
+		}}},
+	}
+	n := New(context.Background(), source, redact.New())
+	n.RunStarted("music", time.Now())
+	n.RunStarted("photos", time.Now())
+	n.Wait()
+
+	<-broker.packets
+	publish := <-broker.packets
+	topic := mqttString("backmeup/photos/event")
+	var event Event
+	require.NoError(t, json.Unmarshal(publish[1+len(topic):], &event))
+	assert.Equal(t, StatusRunning, event.Status)
+	assert.Equal(t, "files", event.Type)
+	assert.NotContains(t, string(publish), "finished_at")
+	assert.Equal(t, "Backup job photos started", event.Summary())
+	assert.False(t, event.Failed())
+}
+
+func TestNSCA(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	iv := bytes.Repeat([]byte{0x5A, 0xC3}, nscaIVSize/2)
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write(binary.BigEndian.AppendUint32(slices.Clone(iv), 1772330700))
+		packet := make([]byte, nscaPacketSize)
+		_, _ = io.ReadFull(conn, packet)
+		received <- packet
+	}()
+
+	sender := &nscaSender{settings: config.NSCASettings{Address: listener.Addr().String(), Host: "backup01", Password: "s3cret"}}
+	finished := time.Now()
+	event := Event{Job: "orders", Status: history.StatusPartial, Error: "1 of 2 databases | failed", StartedAt: finished.Add(-time.Minute), FinishedAt: finished, Size: 2048}
+	require.NoError(t, sender.Send(context.Background(), event))
+
+	packet := <-received
+	for i := range packet {
+		packet[i] ^= iv[i%len(iv)] ^ "s3cret"[i%6]
+	}
+	assert.Equal(t, uint16(nscaVersion), binary.BigEndian.Uint16(packet[0:]))
+	assert.Equal(t, uint32(1772330700), binary.BigEndian.Uint32(packet[8:]))
+	assert.Equal(t, uint16(stateWarning), binary.BigEndian.Uint16(packet[12:]))
+	field := func(from, size int) string {
+		return string(bytes.TrimRight(packet[14+from:14+from+size], "\x00"))
+	}
+	assert.Equal(t, "backup01", field(0, nscaHostSize))
+	assert.Equal(t, "backmeup orders", field(nscaHostSize, nscaServiceSize))
+	assert.Equal(t, "Backup job orders completed partially: 1 of 2 databases / failed | size=2048B duration=60s",
+		field(nscaHostSize+nscaServiceSize, nscaOutputSize))
+
+	crc := binary.BigEndian.Uint32(packet[4:])
+	binary.BigEndian.PutUint32(packet[4:], 0)
+	assert.Equal(t, crc32.ChecksumIEEE(packet), crc)
+}
+
+func TestIcinga(t *testing.T) {
+	var result icingaCheckResult
+	var user, password, path string
+	matches := `[{"code": 200, "status": "Successfully processed check result"}]`
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ = r.BasicAuth()
+		path = r.URL.Path
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&result))
+		_, _ = w.Write([]byte(`{"results": ` + matches + `}`))
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	sender := &icingaSender{settings: config.IcingaSettings{
+		URL: server.URL, Username: "backmeup", Password: "hunter22", Host: "backup01", Service: "backup-{job}", CACert: caFile,
+	}}
+	require.NoError(t, sender.Send(context.Background(), Event{Job: "orders", Status: history.StatusFailed, Error: "disk full"}))
+	assert.Equal(t, "/v1/actions/process-check-result", path)
+	assert.Equal(t, "backmeup", user)
+	assert.Equal(t, "hunter22", password)
+	assert.Equal(t, icingaCheckResult{
+		Type:         "Service",
+		Filter:       `host.name=="backup01" && service.name=="backup-orders"`,
+		ExitStatus:   stateCritical,
+		PluginOutput: "Backup job orders failed: disk full | size=0B duration=0s",
+	}, result)
+
+	matches = `[]`
+	assert.ErrorContains(t, sender.Send(context.Background(), Event{Job: "billing", Status: history.StatusSuccess}),
+		`icinga has no service "backup-billing" on host "backup01"`)
+
+	sender.settings.CACert = ""
+	assert.ErrorContains(t, sender.Send(context.Background(), Event{Job: "orders"}), "certificate")
+}
+
+func TestZabbix(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	requests := make(chan zabbixRequest, 2)
+	responses := []string{
+		`{"response": "success", "info": "processed: 3; failed: 0; total: 3; seconds spent: 0.000055"}`,
+		`{"response": "success", "info": "processed: 1; failed: 2; total: 3; seconds spent: 0.000041"}`,
+	}
+	go func() {
+		for _, response := range responses {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			header := make([]byte, 13)
+			_, _ = io.ReadFull(conn, header)
+			body := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+			_, _ = io.ReadFull(conn, body)
+			var req zabbixRequest
+			_ = json.Unmarshal(body, &req)
+			requests <- req
+			_, _ = conn.Write(append(binary.LittleEndian.AppendUint64([]byte("ZBXD\x01"), uint64(len(response))), response...))
+			conn.Close()
+		}
+	}()
+
+	sender := &zabbixSender{settings: config.ZabbixSettings{Server: listener.Addr().String(), Host: "backup01"}}
+	finished := time.Unix(1772330700, 0)
+	event := Event{Job: "orders db", Status: history.StatusFailed, StartedAt: finished.Add(-95 * time.Second), FinishedAt: finished, Size: 4096}
+	require.NoError(t, sender.Send(context.Background(), event))
+	assert.Equal(t, zabbixRequest{
+		Request: "sender data",
+		Data: []zabbixItem{
+			{Host: "backup01", Key: `backmeup.status["orders db"]`, Value: "2", Clock: 1772330700},
+			{Host: "backup01", Key: `backmeup.duration["orders db"]`, Value: "95", Clock: 1772330700},
+			{Host: "backup01", Key: `backmeup.size["orders db"]`, Value: "4096", Clock: 1772330700},
+		},
+		Clock: 1772330700,
+	}, <-requests)
+
+	sender.settings.KeyPrefix = "backup"
+	event.Job = "orders"
+	assert.ErrorContains(t, sender.Send(context.Background(), event), "zabbix rejected 2 values")
+	assert.Equal(t, "backup.status[orders]", (<-requests).Data[0].Key)
+}
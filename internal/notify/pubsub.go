@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// pubSubScope is the OAuth scope that allows publishing
+const pubSubScope = "https://www.googleapis.com/auth/pubsub"
+
+// metadataTokenURL serves the access token of a GCE, GKE or Cloud Run
+// instance's service account
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// pubSubSender publishes events as JSON to a Google Cloud Pub/Sub topic, with
+// the job and status as message attributes for subscription filters
+type pubSubSender struct {
+	settings config.PubSubSettings
+	client   *http.Client
+}
+
+type pubSubRequest struct {
+	Messages []pubSubMessage `json:"messages"`
+}
+
+type pubSubMessage struct {
+	Data       string            `json:"data"` // Base64 encoded
+	Attributes map[string]string `json:"attributes"`
+}
+
+func (s *pubSubSender) Send(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode pubsub message: %w", err)
+	}
+	body, err := json.Marshal(pubSubRequest{Messages: []pubSubMessage{{
+		Data:       base64.StdEncoding.EncodeToString(data),
+		Attributes: map[string]string{"job": event.Job, "status": event.Status},
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to encode pubsub message: %w", err)
+	}
+
+	// Tokens are fetched per event, which is rare enough not to cache them
+	token, err := s.token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to authenticate to pubsub: %w", err)
+	}
+	endpoint := s.settings.Endpoint
+	if endpoint == "" {
+		endpoint = "https://pubsub.googleapis.com"
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/" + s.settings.Topic + ":publish"
+	headers := map[string]string{"Authorization": "Bearer " + token}
+	return request(ctx, s.client, http.MethodPost, endpoint, "application/json", headers, body)
+}
+
+// serviceAccountKey holds the fields of a service account key file used to
+// sign token requests
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// token returns an access token for the service account of the key file, or
+// of the instance when there is none
+func (s *pubSubSender) token(ctx context.Context) (string, error) {
+	var req *http.Request
+	if s.settings.CredentialsFile == "" {
+		var err error
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil); err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	} else {
+		key, assertion, err := s.assertion(time.Now())
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode())); err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// assertion reads the key file and returns a JWT signed with its key, to be
+// exchanged for an access token
+func (s *pubSubSender) assertion(now time.Time) (serviceAccountKey, string, error) {
+	var key serviceAccountKey
+	data, err := os.ReadFile(s.settings.CredentialsFile)
+	if err != nil {
+		return key, "", fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	if err := json.Unmarshal(data, &key); err != nil || key.ClientEmail == "" || key.TokenURI == "" {
+		return key, "", fmt.Errorf("credentials file %s is not a service account key", s.settings.CredentialsFile)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return key, "", fmt.Errorf("credentials file %s has no private key", s.settings.CredentialsFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return key, "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return key, "", fmt.Errorf("credentials file %s does not hold an RSA key", s.settings.CredentialsFile)
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(struct {
+		Issuer   string `json:"iss"`
+		Scope    string `json:"scope"`
+		Audience string `json:"aud"`
+		IssuedAt int64  `json:"iat"`
+		Expires  int64  `json:"exp"`
+	}{key.ClientEmail, pubSubScope, key.TokenURI, now.Unix(), now.Add(time.Hour).Unix()})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return key, "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return key, unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
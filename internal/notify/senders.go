@@ -0,0 +1,249 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// Colors of Discord embeds by run status
+const (
+	colorSuccess = 0x2ECC71
+	colorPartial = 0xE67E22
+	colorFailed  = 0xE74C3C
+)
+
+// discordSender posts events to a Discord webhook as embeds, mentioning the
+// configured users and roles on failures
+type discordSender struct {
+	settings config.DiscordSettings
+	nextRun  func(from time.Time) time.Time // The job's next scheduled run, by any of its schedules
+	client   *http.Client
+}
+
+type discordMessage struct {
+	Content         string          `json:"content,omitempty"`
+	Embeds          []discordEmbed  `json:"embeds"`
+	AllowedMentions discordMentions `json:"allowed_mentions"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// discordMentions limits who a message may notify. Parse is always empty so
+// that an error message cannot mention @everyone.
+type discordMentions struct {
+	Parse []string `json:"parse"`
+	Users []string `json:"users,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+func (s *discordSender) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(s.message(event))
+	if err != nil {
+		return fmt.Errorf("failed to encode discord message: %w", err)
+	}
+	return request(ctx, s.client, http.MethodPost, s.settings.WebhookURL, "application/json", nil, body)
+}
+
+func (s *discordSender) message(event Event) discordMessage {
+	embed := discordEmbed{
+		Title:       truncate(event.Summary(), 256),
+		Description: truncate(event.Error, 4096),
+		Color:       colorFailed,
+		Timestamp:   event.FinishedAt.Format(time.RFC3339),
+	}
+	switch event.Status {
+	case history.StatusSuccess:
+		embed.Color, embed.Description = colorSuccess, ""
+	case history.StatusPartial:
+		embed.Color = colorPartial
+	}
+
+	field := func(name, value string) {
+		embed.Fields = append(embed.Fields, discordField{Name: name, Value: truncate(value, 1024), Inline: true})
+	}
+	field("Status", event.Status)
+	field("Size", formatSize(event.Size))
+	field("Duration", event.FinishedAt.Sub(event.StartedAt).Round(time.Second).String())
+	if s.nextRun != nil {
+		if next := s.nextRun(event.FinishedAt); !next.IsZero() {
+			field("Next run", fmt.Sprintf("<t:%d:f> (<t:%d:R>)", next.Unix(), next.Unix()))
+		}
+	}
+	if event.ConsecutiveFailures > 1 {
+		field("Failures in a row", fmt.Sprint(event.ConsecutiveFailures))
+	}
+	if event.ErrorCode != "" {
+		field("Error code", event.ErrorCode)
+	}
+	if event.RunID != "" {
+		field("Run ID", event.RunID)
+	}
+	if len(event.ErrorExcerpt) > 0 {
+		// The end of the output is where tools say why they failed
+		excerpt := tail(strings.Join(event.ErrorExcerpt, "\n"), 1024-len("```\n\n```"))
+		embed.Fields = append(embed.Fields, discordField{Name: "Tool output", Value: "```\n" + excerpt + "\n```"})
+	}
+
+	msg := discordMessage{Embeds: []discordEmbed{embed}, AllowedMentions: discordMentions{Parse: []string{}}}
+	if s.mentions(event) {
+		var mentions []string
+		for _, id := range s.settings.MentionUsers {
+			mentions = append(mentions, "<@"+id+">")
+		}
+		for _, id := range s.settings.MentionRoles {
+			mentions = append(mentions, "<@&"+id+">")
+		}
+		msg.Content = strings.Join(mentions, " ")
+		msg.AllowedMentions.Users, msg.AllowedMentions.Roles = s.settings.MentionUsers, s.settings.MentionRoles
+	}
+	return msg
+}
+
+// mentions reports whether an event mentions the configured users and roles,
+// which happens once a job has failed mention_after times in a row
+func (s *discordSender) mentions(event Event) bool {
+	return event.Failed() && event.ConsecutiveFailures >= max(s.settings.MentionAfter, 1) &&
+		len(s.settings.MentionUsers)+len(s.settings.MentionRoles) > 0
+}
+
+// webhookSender posts events as JSON to any HTTP endpoint
+type webhookSender struct {
+	settings config.WebhookSettings
+	client   *http.Client
+}
+
+func (s *webhookSender) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	contentType := s.settings.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	headers := make(map[string]string, len(s.settings.Headers)+1)
+	for k, v := range s.settings.Headers {
+		headers[k] = v
+	}
+	if s.settings.AuthToken != "" {
+		headers["Authorization"] = "Bearer " + s.settings.AuthToken
+	}
+	return request(ctx, s.client, http.MethodPost, s.settings.URL, contentType, headers, body)
+}
+
+// request sends a notification body and fails on any status but 2xx
+func request(ctx context.Context, client *http.Client, method, endpoint, contentType string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// matrixSender posts the summary of an event to a Matrix room
+type matrixSender struct {
+	settings config.MatrixSettings
+	client   *http.Client
+}
+
+func (s *matrixSender) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.notice", "body": event.Text()})
+	if err != nil {
+		return fmt.Errorf("failed to encode matrix message: %w", err)
+	}
+	// The transaction ID only has to be unique for the access token
+	txnID := fmt.Sprintf("backmeup-%d", time.Now().UnixNano())
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(s.settings.Homeserver, "/"), url.PathEscape(s.settings.RoomID), txnID)
+	headers := map[string]string{"Authorization": "Bearer " + s.settings.AccessToken}
+	return request(ctx, s.client, http.MethodPut, endpoint, "application/json", headers, body)
+}
+
+// signalSender sends the summary of an event as a Signal message through a
+// signal-cli REST API
+type signalSender struct {
+	settings config.SignalSettings
+	client   *http.Client
+}
+
+type signalMessage struct {
+	Message    string   `json:"message"`
+	Number     string   `json:"number"`
+	Recipients []string `json:"recipients"`
+}
+
+func (s *signalSender) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(signalMessage{Message: event.Text(), Number: s.settings.Number, Recipients: s.settings.Recipients})
+	if err != nil {
+		return fmt.Errorf("failed to encode signal message: %w", err)
+	}
+	endpoint := strings.TrimSuffix(s.settings.URL, "/") + "/v2/send"
+	return request(ctx, s.client, http.MethodPost, endpoint, "application/json", nil, body)
+}
+
+// truncate shortens text to at most limit characters
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// tail shortens text to at most its last limit characters
+func tail(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return "…" + string(runes[len(runes)-limit+1:])
+}
+
+// formatSize formats a byte count with a binary unit
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// DefaultEnterpriseOID roots the trap and object OIDs when none is configured.
+// 32473 is the enterprise number reserved for examples.
+const DefaultEnterpriseOID = "1.3.6.1.4.1.32473.1"
+
+// OIDs every SNMPv2 trap starts with
+const (
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSnmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+// BER tags of the SNMP types used in traps
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagTimeTicks   = 0x43
+	tagCounter64   = 0x46
+	tagTrapPDU     = 0xA7
+)
+
+// snmpVersion2c is the version field of SNMPv2c messages
+const snmpVersion2c = 1
+
+// snmpSender sends events as SNMPv2c traps. Under the enterprise OID, the
+// trap is .0.1 for a success, .0.2 for a failure and .0.3 for a partial run,
+// and the objects are .1.1 job, .1.2 status, .1.3 error, .1.4 run ID, .1.5
+// size in bytes and .1.6 duration in seconds.
+type snmpSender struct {
+	settings config.SNMPSettings
+	started  time.Time // Reported as the sysUpTime of the traps
+}
+
+func (s *snmpSender) Send(ctx context.Context, event Event) error {
+	packet, err := s.trap(event, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", s.settings.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to snmp manager: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to send snmp trap: %w", err)
+	}
+	return nil
+}
+
+// trap encodes an event as an SNMPv2c trap message
+func (s *snmpSender) trap(event Event, now time.Time) ([]byte, error) {
+	base := s.settings.EnterpriseOID
+	if base == "" {
+		base = DefaultEnterpriseOID
+	}
+	community := s.settings.Community
+	if community == "" {
+		community = "public"
+	}
+
+	trapOID := base + ".0.2"
+	switch event.Status {
+	case history.StatusSuccess:
+		trapOID = base + ".0.1"
+	case history.StatusPartial:
+		trapOID = base + ".0.3"
+	}
+
+	trap, err := encodeOID(trapOID)
+	if err != nil {
+		return nil, err
+	}
+	// sysUpTime counts hundredths of a second and wraps at 32 bits
+	uptime := uint64(now.Sub(s.started)/(10*time.Millisecond)) & 0xFFFFFFFF
+	bindings := []struct {
+		oid   string
+		value []byte
+	}{
+		{oidSysUpTime, tlv(tagTimeTicks, encodeUnsigned(uptime))},
+		{oidSnmpTrapOID, trap},
+		{base + ".1.1", tlv(tagOctetString, []byte(event.Job))},
+		{base + ".1.2", tlv(tagOctetString, []byte(event.Status))},
+		{base + ".1.3", tlv(tagOctetString, []byte(event.Error))},
+		{base + ".1.4", tlv(tagOctetString, []byte(event.RunID))},
+		{base + ".1.5", tlv(tagCounter64, encodeUnsigned(uint64(max(event.Size, 0))))},
+		{base + ".1.6", tlv(tagInteger, encodeInteger(int64(event.FinishedAt.Sub(event.StartedAt).Seconds())))},
+	}
+
+	var varbinds []byte
+	for _, b := range bindings {
+		name, err := encodeOID(b.oid)
+		if err != nil {
+			return nil, err
+		}
+		varbinds = append(varbinds, tlv(tagSequence, concat(name, b.value))...)
+	}
+
+	pdu := concat(
+		tlv(tagInteger, encodeInteger(int64(rand.Int32()))), // Request ID
+		tlv(tagInteger, encodeInteger(0)),                   // Error status
+		tlv(tagInteger, encodeInteger(0)),                   // Error index
+		tlv(tagSequence, varbinds),
+	)
+	return tlv(tagSequence, concat(
+		tlv(tagInteger, encodeInteger(snmpVersion2c)),
+		tlv(tagOctetString, []byte(community)),
+		tlv(tagTrapPDU, pdu),
+	)), nil
+}
+
+// tlv encodes a BER tag, length and value
+func tlv(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	if n := len(value); n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, value...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// encodeInteger encodes a two's complement integer in as few bytes as possible
+func encodeInteger(v int64) []byte {
+	out := []byte{byte(v)}
+	for (v > 0x7F || v < -0x80) && len(out) < 8 {
+		v >>= 8
+		out = append([]byte{byte(v)}, out...)
+	}
+	return out
+}
+
+// encodeUnsigned encodes an unsigned integer, with a leading zero byte when
+// the high bit is set so it does not read as negative
+func encodeUnsigned(v uint64) []byte {
+	var out []byte
+	for {
+		out = append([]byte{byte(v)}, out...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	if out[0]&0x80 != 0 {
+		out = append([]byte{0}, out...)
+	}
+	return out
+}
+
+// encodeOID encodes a dotted OID such as 1.3.6.1 as a BER object identifier
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(oid, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid oid %s", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, p := range parts {
+		arc, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oid %s", oid)
+		}
+		arcs[i] = arc
+	}
+
+	var value []byte
+	for _, arc := range append([]uint64{arcs[0]*40 + arcs[1]}, arcs[2:]...) {
+		chunk := []byte{byte(arc & 0x7F)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			chunk = append([]byte{byte(arc&0x7F) | 0x80}, chunk...)
+		}
+		value = append(value, chunk...)
+	}
+	return tlv(tagOID, value), nil
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// structuredDataID names the structured data of syslog messages. 32473 is the
+// enterprise number reserved for examples, as backmeup has none of its own.
+const structuredDataID = "backmeup@32473"
+
+// Syslog severities of the run statuses
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// syslogSender sends events as RFC 5424 messages to a syslog server
+type syslogSender struct {
+	settings config.SyslogSettings
+}
+
+func (s *syslogSender) Send(ctx context.Context, event Event) error {
+	network := s.settings.Network
+	if network == "" {
+		network = "udp"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, s.settings.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog server: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	msg := s.message(event, time.Now())
+	// Stream transports frame each message with its length (RFC 6587)
+	if network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("failed to send syslog message: %w", err)
+	}
+	return nil
+}
+
+func (s *syslogSender) message(event Event, now time.Time) string {
+	facility, _ := config.SyslogFacility(s.settings.Facility)
+	severity := severityError
+	switch event.Status {
+	case history.StatusSuccess:
+		severity = severityInfo
+	case history.StatusPartial:
+		severity = severityWarning
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	params := [][2]string{{"job", event.Job}, {"type", event.Type}, {"status", event.Status}}
+	if event.RunID != "" {
+		params = append(params, [2]string{"run_id", event.RunID})
+	}
+	if event.ErrorCode != "" {
+		params = append(params, [2]string{"error_code", event.ErrorCode})
+	}
+	params = append(params,
+		[2]string{"size", fmt.Sprint(event.Size)},
+		[2]string{"duration", fmt.Sprint(int64(event.FinishedAt.Sub(event.StartedAt).Seconds()))})
+	var data strings.Builder
+	data.WriteString("[" + structuredDataID)
+	for _, p := range params {
+		fmt.Fprintf(&data, " %s=\"%s\"", p[0], escapeParam(p[1]))
+	}
+	data.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s backmeup %d %s %s %s", facility*8+severity,
+		now.Format("2006-01-02T15:04:05.000000Z07:00"), hostname, os.Getpid(), strings.ToUpper(event.Status),
+		data.String(), strings.ReplaceAll(event.Summary(), "\n", " "))
+}
+
+// escapeParam escapes the characters RFC 5424 reserves in parameter values
+func escapeParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
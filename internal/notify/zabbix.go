@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// DefaultZabbixKeyPrefix starts the item keys of Zabbix channels without one
+const DefaultZabbixKeyPrefix = "backmeup"
+
+// zabbixHeader starts every message of the Zabbix sender protocol
+var zabbixHeader = []byte("ZBXD\x01")
+
+// zabbixFailed extracts the count of rejected values from a server response
+var zabbixFailed = regexp.MustCompile(`failed: (\d+)`)
+
+// zabbixSender sends the status, duration and size of a run as trapper
+// items, the way zabbix_sender does
+type zabbixSender struct {
+	settings config.ZabbixSettings
+}
+
+type zabbixRequest struct {
+	Request string       `json:"request"`
+	Data    []zabbixItem `json:"data"`
+	Clock   int64        `json:"clock"`
+}
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+func (s *zabbixSender) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(s.request(event))
+	if err != nil {
+		return fmt.Errorf("failed to encode zabbix data: %w", err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.settings.Server)
+	if err != nil {
+		return fmt.Errorf("failed to connect to zabbix: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	message := binary.LittleEndian.AppendUint64(append([]byte{}, zabbixHeader...), uint64(len(body)))
+	if _, err := conn.Write(append(message, body...)); err != nil {
+		return fmt.Errorf("failed to send zabbix data: %w", err)
+	}
+
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed to read zabbix response: %w", err)
+	}
+	if string(header[:4]) != "ZBXD" {
+		return fmt.Errorf("zabbix sent an invalid response")
+	}
+	length := binary.LittleEndian.Uint64(header[len(zabbixHeader):])
+	if length > 1<<20 {
+		return fmt.Errorf("zabbix sent an invalid response")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return fmt.Errorf("failed to read zabbix response: %w", err)
+	}
+
+	var resp zabbixResponse
+	if err := json.Unmarshal(data, &resp); err != nil || resp.Response != "success" {
+		return fmt.Errorf("zabbix rejected the data: %s", data)
+	}
+	// Values of items that do not exist or are not trapper items are dropped
+	if match := zabbixFailed.FindStringSubmatch(resp.Info); match != nil && match[1] != "0" {
+		return fmt.Errorf("zabbix rejected %s values (%s), check that the trapper items exist on host %s",
+			match[1], resp.Info, s.settings.Host)
+	}
+	return nil
+}
+
+// request returns the items of an event. Status is 0 for a success, 1 for a
+// partial run and 2 for a failure, like the states of passive checks.
+func (s *zabbixSender) request(event Event) zabbixRequest {
+	prefix := s.settings.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultZabbixKeyPrefix
+	}
+	clock := event.FinishedAt.Unix()
+	item := func(name, value string) zabbixItem {
+		return zabbixItem{Host: s.settings.Host, Key: fmt.Sprintf("%s.%s[%s]", prefix, name, zabbixParam(event.Job)), Value: value, Clock: clock}
+	}
+	return zabbixRequest{
+		Request: "sender data",
+		Data: []zabbixItem{
+			item("status", strconv.Itoa(checkState(event.Status))),
+			item("duration", strconv.FormatInt(int64(event.FinishedAt.Sub(event.StartedAt).Seconds()), 10)),
+			item("size", strconv.FormatInt(event.Size, 10)),
+		},
+		Clock: clock,
+	}
+}
+
+// zabbixParam quotes an item key parameter that holds characters Zabbix
+// would otherwise split on
+func zabbixParam(value string) string {
+	if !strings.ContainsAny(value, ",[]\" ") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
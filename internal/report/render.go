@@ -0,0 +1,104 @@
+package report
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	texttemplate "text/template"
+)
+
+var funcs = texttemplate.FuncMap{
+	"bytes":    formatBytes,
+	"change":   formatChange,
+	"duration": formatDuration,
+	"time":     formatTime,
+	"status":   formatStatus,
+	// cell keeps Markdown table cells on one line and unbroken by pipes
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+	},
+}
+
+func formatStatus(status string) string {
+	if status == "" {
+		return "no runs"
+	}
+	return status
+}
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("report").Funcs(htmltemplate.FuncMap(funcs)).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f0f0f0; }
+.success { color: #1a7f37; }
+.failed { color: #cf222e; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Period {{time .From}} to {{time .To}}, generated {{time .GeneratedAt}}.</p>
+<p>{{.Succeeded}} successful and {{.Failed}} failed runs. {{.Backups}} backups use {{bytes .Usage}} of storage.</p>
+
+<h2>Jobs</h2>
+<table>
+<tr><th>Job</th><th>Type</th><th>Last status</th><th>Last run</th><th>Runs</th><th>Failures</th><th>Size</th><th>Size trend</th><th>Duration</th><th>Duration trend</th><th>Backups</th><th>Storage</th></tr>
+{{- range .Jobs}}
+<tr><td>{{.Name}}</td><td>{{.Type}}</td><td class="{{.LastStatus}}">{{status .LastStatus}}</td><td>{{time .LastRun}}</td><td>{{.Runs}}</td><td>{{.Failures}}</td><td>{{bytes .LastSize}}</td><td>{{change .SizeChange}}</td><td>{{duration .LastDuration}}</td><td>{{change .DurationChange}}</td><td>{{.Backups}}</td><td>{{bytes .Usage}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Runs</h2>
+{{- if .Runs}}
+<table>
+<tr><th>Job</th><th>Started</th><th>Status</th><th>Duration</th><th>Size</th><th>Error</th></tr>
+{{- range .Runs}}
+<tr><td>{{.Job}}</td><td>{{time .StartedAt}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{duration .Duration}}</td><td>{{bytes .Size}}</td><td>{{.Error}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No runs in this period.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+var markdownReport = texttemplate.Must(texttemplate.New("report").Funcs(funcs).Parse(`# {{.Title}}
+
+Period {{time .From}} to {{time .To}}, generated {{time .GeneratedAt}}.
+
+{{.Succeeded}} successful and {{.Failed}} failed runs. {{.Backups}} backups use {{bytes .Usage}} of storage.
+
+## Jobs
+
+| Job | Type | Last status | Last run | Runs | Failures | Size | Size trend | Duration | Duration trend | Backups | Storage |
+|-----|------|-------------|----------|------|----------|------|------------|----------|----------------|---------|---------|
+{{- range .Jobs}}
+| {{cell .Name}} | {{.Type}} | {{status .LastStatus}} | {{time .LastRun}} | {{.Runs}} | {{.Failures}} | {{bytes .LastSize}} | {{change .SizeChange}} | {{duration .LastDuration}} | {{change .DurationChange}} | {{.Backups}} | {{bytes .Usage}} |
+{{- end}}
+
+## Runs
+{{if .Runs}}
+| Job | Started | Status | Duration | Size | Error |
+|-----|---------|--------|----------|------|-------|
+{{- range .Runs}}
+| {{cell .Job}} | {{time .StartedAt}} | {{.Status}} | {{duration .Duration}} | {{bytes .Size}} | {{cell .Error}} |
+{{- end}}
+{{else}}
+No runs in this period.
+{{end}}`))
+
+// WriteHTML renders the report as a standalone HTML page
+func (r Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
+
+// WriteMarkdown renders the report as Markdown tables
+func (r Report) WriteMarkdown(w io.Writer) error {
+	return markdownReport.Execute(w, r)
+}
@@ -0,0 +1,152 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// TrendWindow is how far before a report's period runs are compared against
+// to show size and duration trends
+const TrendWindow = 7 * 24 * time.Hour
+
+// Job is a job's configuration and storage usage at the time of the report
+type Job struct {
+	Name    string
+	Type    string
+	Backups int   // Backups currently in storage
+	Usage   int64 // Total size of those backups
+}
+
+// JobSummary is one row of a report
+type JobSummary struct {
+	Job
+	Runs           int
+	Failures       int
+	LastStatus     string // Empty when the job did not run in the period
+	LastRun        time.Time
+	LastError      string
+	LastSize       int64
+	LastDuration   time.Duration
+	SizeChange     float64 // Percent change from the trend window average, NaN without a baseline
+	DurationChange float64
+}
+
+// Report summarises the runs of a period
+type Report struct {
+	Title       string
+	GeneratedAt time.Time
+	From        time.Time
+	To          time.Time
+	Jobs        []JobSummary
+	Runs        []history.Run // Runs of the period, oldest first
+	Succeeded   int
+	Failed      int
+	Backups     int
+	Usage       int64
+}
+
+// Build summarises the runs started between from and to. runs must include
+// the trend window before from for trends to be computed.
+func Build(title string, from, to time.Time, jobs []Job, runs []history.Run) Report {
+	r := Report{Title: title, GeneratedAt: time.Now(), From: from, To: to}
+
+	baseline := make(map[string][]history.Run)
+	inPeriod := make(map[string][]history.Run)
+	for _, run := range runs {
+		switch {
+		case run.StartedAt.Before(from.Add(-TrendWindow)) || run.StartedAt.After(to):
+		case run.StartedAt.Before(from):
+			if run.Status == history.StatusSuccess {
+				baseline[run.Job] = append(baseline[run.Job], run)
+			}
+		default:
+			inPeriod[run.Job] = append(inPeriod[run.Job], run)
+			r.Runs = append(r.Runs, run)
+			if run.Status == history.StatusSuccess {
+				r.Succeeded++
+			} else {
+				r.Failed++
+			}
+		}
+	}
+	sort.SliceStable(r.Runs, func(i, j int) bool { return r.Runs[i].StartedAt.Before(r.Runs[j].StartedAt) })
+
+	for _, job := range jobs {
+		summary := JobSummary{Job: job, SizeChange: math.NaN(), DurationChange: math.NaN()}
+		r.Backups += job.Backups
+		r.Usage += job.Usage
+
+		jobRuns := inPeriod[job.Name]
+		summary.Runs = len(jobRuns)
+		for _, run := range jobRuns {
+			if run.Status != history.StatusSuccess {
+				summary.Failures++
+			}
+		}
+		if len(jobRuns) > 0 {
+			last := jobRuns[len(jobRuns)-1]
+			summary.LastStatus = last.Status
+			summary.LastRun = last.StartedAt
+			summary.LastError = last.Error
+			summary.LastSize = last.Size
+			summary.LastDuration = last.Duration()
+
+			if base := baseline[job.Name]; len(base) > 0 && last.Status == history.StatusSuccess {
+				var size, duration float64
+				for _, run := range base {
+					size += float64(run.Size)
+					duration += float64(run.Duration())
+				}
+				summary.SizeChange = change(float64(last.Size), size/float64(len(base)))
+				summary.DurationChange = change(float64(last.Duration()), duration/float64(len(base)))
+			}
+		}
+		r.Jobs = append(r.Jobs, summary)
+	}
+
+	return r
+}
+
+func change(value, average float64) float64 {
+	if average == 0 {
+		return math.NaN()
+	}
+	return (value - average) / average * 100
+}
+
+// formatBytes formats a size with binary units
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// formatChange formats a trend, or a dash when there is no baseline
+func formatChange(percent float64) string {
+	if math.IsNaN(percent) {
+		return "-"
+	}
+	return fmt.Sprintf("%+.0f%%", percent)
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
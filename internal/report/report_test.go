@@ -0,0 +1,128 @@
+package report
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+func newRun(job, status string, started time.Time, duration time.Duration, size int64) history.Run {
+	return history.Run{Job: job, Type: "postgres", Status: status, StartedAt: started, FinishedAt: started.Add(duration), Size: size}
+}
+
+func TestBuild(t *testing.T) {
+	to := time.Date(2026, 3, 10, 7, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+
+	runs := []history.Run{
+		newRun("orders", history.StatusSuccess, from.Add(-8*24*time.Hour), time.Hour, 10000), // Before the trend window
+		newRun("orders", history.StatusSuccess, from.Add(-48*time.Hour), 10*time.Minute, 1000),
+		newRun("orders", history.StatusSuccess, from.Add(-24*time.Hour), 10*time.Minute, 1000),
+		newRun("orders", history.StatusFailed, from.Add(time.Hour), time.Minute, 0),
+		newRun("orders", history.StatusSuccess, from.Add(2*time.Hour), 15*time.Minute, 1500),
+		newRun("users", history.StatusFailed, from.Add(3*time.Hour), time.Minute, 0),
+	}
+	jobs := []Job{
+		{Name: "orders", Type: "postgres", Backups: 3, Usage: 3500},
+		{Name: "users", Type: "mysql", Backups: 1, Usage: 200},
+		{Name: "idle", Type: "sqlite"},
+	}
+
+	r := Build("Backup report", from, to, jobs, runs)
+	assert.Equal(t, 1, r.Succeeded)
+	assert.Equal(t, 2, r.Failed)
+	assert.Equal(t, 4, r.Backups)
+	assert.Equal(t, int64(3700), r.Usage)
+	require.Len(t, r.Runs, 3)
+
+	require.Len(t, r.Jobs, 3)
+	orders := r.Jobs[0]
+	assert.Equal(t, 2, orders.Runs)
+	assert.Equal(t, 1, orders.Failures)
+	assert.Equal(t, history.StatusSuccess, orders.LastStatus)
+	assert.Equal(t, int64(1500), orders.LastSize)
+	assert.InDelta(t, 50, orders.SizeChange, 0.01)
+	assert.InDelta(t, 50, orders.DurationChange, 0.01)
+
+	users := r.Jobs[1]
+	assert.Equal(t, history.StatusFailed, users.LastStatus)
+	assert.True(t, math.IsNaN(users.SizeChange), "failed runs have no trend")
+
+	assert.Empty(t, r.Jobs[2].LastStatus)
+	assert.Equal(t, 0, r.Jobs[2].Runs)
+}
+
+func TestRender(t *testing.T) {
+	to := time.Date(2026, 3, 10, 7, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+	failed := newRun("orders", history.StatusFailed, from.Add(time.Hour), time.Minute, 0)
+	failed.Error = "pg_dump failed: <connection refused> | exit 1"
+
+	r := Build("Backup report", from, to, []Job{{Name: "orders", Type: "postgres", Usage: 2048}}, []history.Run{failed})
+
+	var html bytes.Buffer
+	require.NoError(t, r.WriteHTML(&html))
+	assert.Contains(t, html.String(), "<title>Backup report</title>")
+	assert.Contains(t, html.String(), "&lt;connection refused&gt;", "errors are escaped")
+	assert.Contains(t, html.String(), "2.0 KiB")
+
+	var md bytes.Buffer
+	require.NoError(t, r.WriteMarkdown(&md))
+	assert.Contains(t, md.String(), "# Backup report")
+	assert.Contains(t, md.String(), `<connection refused> \| exit 1`, "pipes must not break the table")
+	assert.Contains(t, md.String(), "| orders | postgres | failed |")
+}
+
+type fakeSource struct {
+	jobs map[string]config.JobConfig
+}
+
+func (f fakeSource) JobNames() []string {
+	return []string{"orders"}
+}
+
+func (f fakeSource) JobConfig(jobName string) (config.JobConfig, bool) {
+	jobConfig, ok := f.jobs[jobName]
+	return jobConfig, ok
+}
+
+func (f fakeSource) Usage(string) (int, int64, error) {
+	return 2, 4096, nil
+}
+
+func TestReporter(t *testing.T) {
+	dir := t.TempDir()
+	store, err := history.Open(filepath.Join(dir, "history.jsonl"))
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	require.NoError(t, store.Append(newRun("orders", history.StatusSuccess, now.Add(-time.Minute), time.Minute, 100)))
+
+	source := fakeSource{jobs: map[string]config.JobConfig{"orders": {Name: "orders", Type: "postgres"}}}
+	reporter := New(config.ReportConfig{Enabled: true, Format: "markdown", PerRun: true}, filepath.Join(dir, "reports"), store, source)
+
+	path, err := reporter.WriteDaily(now)
+	require.NoError(t, err)
+	assert.Equal(t, ".md", filepath.Ext(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "| orders | postgres | success |")
+
+	reporter.RunFinished("orders", scheduler.StatusComplete, now)
+	_, err = os.Stat(filepath.Join(dir, "reports", "orders_"+now.Add(-time.Minute).Format("20060102-150405")+".md"))
+	assert.NoError(t, err)
+
+	reporter.RunFinished("orders", scheduler.StatusError, now.Add(time.Hour))
+	entries, err := os.ReadDir(filepath.Join(dir, "reports"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "a failure without a recorded run writes no report")
+}
@@ -0,0 +1,168 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// defaultSchedule writes the daily report every morning
+const defaultSchedule = "0 7 * * *"
+
+// Source provides the scheduled jobs and their storage usage
+type Source interface {
+	JobNames() []string
+	JobConfig(jobName string) (config.JobConfig, bool)
+	Usage(jobName string) (int, int64, error)
+}
+
+// Reporter writes daily and per-run reports to a directory
+type Reporter struct {
+	cfg     config.ReportConfig
+	dir     string
+	history *history.Store
+	source  Source
+}
+
+// DefaultDirectory is the report directory used when none is configured
+func DefaultDirectory(localDir string) string {
+	return filepath.Join(localDir, ".backmeup", "reports")
+}
+
+func New(cfg config.ReportConfig, dir string, store *history.Store, source Source) *Reporter {
+	return &Reporter{cfg: cfg, dir: dir, history: store, source: source}
+}
+
+// Run writes the daily report on the configured schedule until the context is
+// cancelled. Each report covers the 24 hours before it is written.
+func (r *Reporter) Run(ctx context.Context) {
+	spec := r.cfg.Schedule
+	if spec == "" {
+		spec = defaultSchedule
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		log.Printf("Error: invalid report schedule %q: %v", spec, err)
+		return
+	}
+
+	for {
+		next := schedule.Next(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		path, err := r.WriteDaily(next)
+		if err != nil {
+			log.Printf("Error writing daily report: %v", err)
+			continue
+		}
+		log.Printf("Daily report written to %s", path)
+	}
+}
+
+// WriteDaily writes the report of the 24 hours before the given time
+func (r *Reporter) WriteDaily(to time.Time) (string, error) {
+	from := to.Add(-24 * time.Hour)
+	return r.write("daily_"+to.Format("20060102-150405"), "Backup report", from, to, r.source.JobNames())
+}
+
+// RunFinished writes a report for a finished run when per-run reports are
+// enabled. It is registered as a scheduler status callback.
+func (r *Reporter) RunFinished(jobName, status string, at time.Time) {
+	if !r.cfg.PerRun || (status != scheduler.StatusComplete && status != scheduler.StatusError && status != scheduler.StatusPartial) {
+		return
+	}
+	if _, ok := r.source.JobConfig(jobName); !ok {
+		return
+	}
+
+	runs, err := r.history.Since(at.Add(-24 * time.Hour))
+	if err != nil {
+		log.Printf("Error writing run report for job %s: %v", jobName, err)
+		return
+	}
+	var last history.Run
+	for _, run := range runs {
+		if run.Job == jobName {
+			last = run
+		}
+	}
+	// Runs that ended before executing, such as a queue timeout, are not
+	// recorded and must not repeat the previous run's report
+	if last.Job == "" || at.Sub(last.FinishedAt) > time.Minute {
+		return
+	}
+
+	title := fmt.Sprintf("Backup report: %s", jobName)
+	path, err := r.write(jobName+"_"+last.StartedAt.Format("20060102-150405"), title, last.StartedAt, last.FinishedAt, []string{jobName})
+	if err != nil {
+		log.Printf("Error writing run report for job %s: %v", jobName, err)
+		return
+	}
+	log.Printf("[Job: %s] Run report written to %s", jobName, path)
+}
+
+func (r *Reporter) write(name, title string, from, to time.Time, jobNames []string) (string, error) {
+	runs, err := r.history.Since(from.Add(-TrendWindow))
+	if err != nil {
+		return "", err
+	}
+
+	selected := make(map[string]bool, len(jobNames))
+	jobs := make([]Job, 0, len(jobNames))
+	for _, name := range jobNames {
+		jobConfig, ok := r.source.JobConfig(name)
+		if !ok {
+			continue
+		}
+		job := Job{Name: name, Type: jobConfig.Type}
+		if job.Backups, job.Usage, err = r.source.Usage(name); err != nil {
+			log.Printf("Warning: failed to read storage usage of job %s: %v", name, err)
+		}
+		jobs = append(jobs, job)
+		selected[name] = true
+	}
+
+	jobRuns := runs[:0]
+	for _, run := range runs {
+		if selected[run.Job] {
+			jobRuns = append(jobRuns, run)
+		}
+	}
+	report := Build(title, from, to, jobs, jobRuns)
+
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create report directory: %w", err)
+	}
+
+	path := filepath.Join(r.dir, name+".html")
+	render := report.WriteHTML
+	if r.cfg.Format == "markdown" {
+		path = filepath.Join(r.dir, name+".md")
+		render = report.WriteMarkdown
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create report: %w", err)
+	}
+	if err := render(f); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to render report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+	return path, nil
+}
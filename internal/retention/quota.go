@@ -0,0 +1,130 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const defaultQuotaMinKeep = 1
+
+// quotaCandidate is a backup that may be removed to get back under the quota
+type quotaCandidate struct {
+	jobName string
+	weight  int
+	entry   storage.BackupEntry
+}
+
+// EnforceQuota removes backups across jobs until their combined size fits the
+// storage quota. Trashed backups go first, then the oldest backups of the jobs
+// with the lowest quota weight. Every job keeps at least min_keep backups, and
+// the bases of the increments it keeps.
+func (m *Manager) EnforceQuota(ctx context.Context, jobs []config.JobConfig, quota config.QuotaConfig) error {
+	if quota.MaxSize == "" {
+		return nil
+	}
+	maxSize, err := config.ParseSize(quota.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	minKeep := quota.MinKeep
+	if minKeep == 0 {
+		minKeep = defaultQuotaMinKeep
+	}
+
+	var used int64
+	var trashed, backups []quotaCandidate
+	parents := make(map[string]map[string]string) // Per job, as in the catalog
+	dependents := make(map[string]map[string]int) // Per job, increments left on top of each backup
+	for _, job := range jobs {
+		parents[job.Name] = m.parents(job.Name)
+		dependents[job.Name] = make(map[string]int)
+
+		entries, err := m.storage.List(ctx, job.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list backups of job %s: %w", job.Name, err)
+		}
+		trash, err := m.storage.ListTrash(ctx, job.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list trash of job %s: %w", job.Name, err)
+		}
+
+		for _, entry := range trash {
+			used += entry.Size
+			trashed = append(trashed, quotaCandidate{jobName: job.Name, weight: job.QuotaWeight, entry: entry})
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].ModTime.After(entries[j].ModTime)
+		})
+		for i, entry := range entries {
+			used += entry.Size
+			if parent, ok := parents[job.Name][backupName(entry)]; ok {
+				dependents[job.Name][parent]++
+			}
+			if i >= minKeep {
+				backups = append(backups, quotaCandidate{jobName: job.Name, weight: job.QuotaWeight, entry: entry})
+			}
+		}
+	}
+
+	if used <= maxSize {
+		return nil
+	}
+	log.Printf("Storage quota exceeded: %d of %d bytes used, removing backups", used, maxSize)
+
+	sort.Slice(trashed, func(i, j int) bool {
+		return trashed[i].entry.ModTime.Before(trashed[j].entry.ModTime)
+	})
+	sort.SliceStable(backups, func(i, j int) bool {
+		if backups[i].weight != backups[j].weight {
+			return backups[i].weight < backups[j].weight
+		}
+		return backups[i].entry.ModTime.Before(backups[j].entry.ModTime)
+	})
+
+	// A backup with increments left on top of it is skipped until they are
+	// gone, which takes another pass as they are newer
+	candidates := append(trashed, backups...)
+	guard := m.newGuard()
+	done := make([]bool, len(candidates))
+	for progress := true; progress && used > maxSize; {
+		progress = false
+		for i, c := range candidates {
+			if used <= maxSize {
+				break
+			}
+			name := backupName(c.entry)
+			if done[i] || (i >= len(trashed) && dependents[c.jobName][name] > 0) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("quota enforcement stopped with %d of %d bytes used: %w", used, maxSize, err)
+			}
+			done[i], progress = true, true
+			if err := guard.check(c.jobName, c.entry); err != nil {
+				log.Printf("Warning: %v", err)
+				continue
+			}
+			if err := m.delete(ctx, c.entry); err != nil {
+				log.Printf("Warning: failed to delete backup %s: %v", c.entry.Key, err)
+				continue
+			}
+			used -= c.entry.Size
+			if parent, ok := parents[c.jobName][name]; ok && i >= len(trashed) {
+				dependents[c.jobName][parent]--
+			}
+			log.Printf("[Job: %s] Deleted backup to stay within the storage quota: %s", c.jobName, c.entry.Key)
+		}
+	}
+
+	if used > maxSize {
+		log.Printf("Warning: storage quota still exceeded after removing backups: %d of %d bytes used", used, maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package retention
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// writeSizedBackup creates a backup of size bytes that is ageDays old
+func writeSizedBackup(t *testing.T, dir, jobName string, ageDays, size int) {
+	t.Helper()
+	jobDir := filepath.Join(dir, jobName)
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+
+	modTime := time.Now().AddDate(0, 0, -ageDays)
+	path := filepath.Join(jobDir, backupFile(modTime))
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestEnforceQuota(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+
+	for age := 0; age < 3; age++ {
+		writeSizedBackup(t, dir, "important", age, 100)
+		writeSizedBackup(t, dir, "scratch", age, 100)
+	}
+	jobs := []config.JobConfig{
+		{Name: "important", QuotaWeight: 10},
+		{Name: "scratch"},
+	}
+
+	m := NewManager(store)
+
+	// Within budget, nothing is removed
+	require.NoError(t, m.EnforceQuota(context.Background(), jobs, config.QuotaConfig{MaxSize: "1KB"}))
+	entries, err := store.List(context.Background(), "scratch")
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	// The least important job loses its oldest backups first
+	require.NoError(t, m.EnforceQuota(context.Background(), jobs, config.QuotaConfig{MaxSize: "450B"}))
+	entries, err = store.List(context.Background(), "scratch")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].ModTime.After(time.Now().Add(-time.Hour)), "the newest backup is kept")
+	entries, err = store.List(context.Background(), "important")
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	// Once it is down to min_keep, the next job is used
+	require.NoError(t, m.EnforceQuota(context.Background(), jobs, config.QuotaConfig{MaxSize: "300B"}))
+	entries, err = store.List(context.Background(), "scratch")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	entries, err = store.List(context.Background(), "important")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// min_keep is never violated, even if the quota cannot be met
+	require.NoError(t, m.EnforceQuota(context.Background(), jobs, config.QuotaConfig{MaxSize: "1B", MinKeep: 2}))
+	entries, err = store.List(context.Background(), "important")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestEnforceQuota_TrashFirst(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+
+	for age := 0; age < 4; age++ {
+		writeSizedBackup(t, dir, "db", age, 100)
+	}
+	entries, err := store.List(context.Background(), "db")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		if entry.ModTime.Before(time.Now().AddDate(0, 0, -2).Add(-12 * time.Hour)) {
+			require.NoError(t, store.MoveToTrash(context.Background(), "db", entry))
+		}
+	}
+
+	m := NewManager(store)
+	require.NoError(t, m.EnforceQuota(context.Background(), []config.JobConfig{{Name: "db"}}, config.QuotaConfig{MaxSize: "350B"}))
+
+	trashed, err := store.ListTrash(context.Background(), "db")
+	require.NoError(t, err)
+	assert.Empty(t, trashed, "trashed backups are removed before live ones")
+	entries, err = store.List(context.Background(), "db")
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestEnforceQuota_Chains(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+
+	for age := 0; age < 4; age++ {
+		writeSizedBackup(t, dir, "tank", age, 100)
+	}
+	catalog := manifest.NewStore(manifest.DefaultDir(dir))
+	writeChain(t, catalog, "tank", 0, 3) // The kept backup needs the oldest one
+	writeChain(t, catalog, "tank", 1, 2) // Removed increment first, then its base
+
+	m := NewManager(store)
+	m.SetCatalog(catalog)
+	require.NoError(t, m.EnforceQuota(context.Background(), []config.JobConfig{{Name: "tank"}}, config.QuotaConfig{MaxSize: "200B"}))
+
+	entries, err := store.List(context.Background(), "tank")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, filepath.Base(entry.Key))
+	}
+	assert.ElementsMatch(t, []string{
+		backupFile(time.Now()),
+		backupFile(time.Now().AddDate(0, 0, -3)),
+	}, names)
+}
@@ -1,85 +1,266 @@
 package retention
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"path/filepath"
 	"sort"
 	"time"
 
+	"github.com/thitiph0n/backmeup/internal/clock"
 	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
 	"github.com/thitiph0n/backmeup/internal/storage"
 )
 
 type Manager struct {
 	storage storage.Storage
+	clock   clock.Clock
+	catalog *manifest.Store
+	metrics *storage.Metrics
+	backend string // Name of the storage in metrics
 }
 
 func NewManager(s storage.Storage) *Manager {
-	return &Manager{storage: s}
+	return &Manager{storage: s, clock: clock.Real}
 }
 
-func (m *Manager) ApplyRetentionPolicy(jobConfig config.JobConfig) error {
-	switch jobConfig.RetentionPolicy.Type {
-	case "count":
-		return m.applyCountBasedRetention(jobConfig.Name, jobConfig.RetentionPolicy.Value)
-	case "days":
-		return m.applyDaysBasedRetention(jobConfig.Name, jobConfig.RetentionPolicy.Value)
-	default:
-		return fmt.Errorf("unsupported retention policy type: %s", jobConfig.RetentionPolicy.Type)
+// SetClock sets the clock backup ages and grace periods are measured with
+func (m *Manager) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// SetCatalog makes retention and the quota keep the full backups and
+// earlier increments that the increments they keep depend on, as recorded
+// in the manifests of the catalog
+func (m *Manager) SetCatalog(catalog *manifest.Store) {
+	m.catalog = catalog
+}
+
+// SetMetrics records every backup deleted by retention and the quota, and
+// the bytes it freed, as a deletion from backend
+func (m *Manager) SetMetrics(metrics *storage.Metrics, backend string) {
+	m.metrics = metrics
+	m.backend = backend
+}
+
+// delete removes a backup, every file of a directory backup included, and
+// records it in the metrics
+func (m *Manager) delete(ctx context.Context, entry storage.BackupEntry) error {
+	err := m.storage.Delete(ctx, entry)
+	m.metrics.RecordDelete(m.backend, entry.Size, err)
+	return err
+}
+
+// parents returns the backup each increment of a job applies on top of,
+// nil without a catalog
+func (m *Manager) parents(jobName string) map[string]string {
+	if m.catalog == nil {
+		return nil
+	}
+	parents, err := m.catalog.Parents(jobName)
+	if err != nil {
+		log.Printf("Warning: failed to read the backup chains of job %s, increments may lose their base: %v", jobName, err)
+		return nil
 	}
+	return parents
 }
 
-func (m *Manager) applyCountBasedRetention(jobName string, keepCount int) error {
-	entries, err := m.storage.List(jobName)
+// backupName is the name of a backup in its job's directory, as used by
+// the manifests
+func backupName(entry storage.BackupEntry) string {
+	return filepath.Base(entry.Key)
+}
+
+// Plan describes what applying a retention policy would do right now
+type Plan struct {
+	Total          int                   // Backups currently stored
+	Remove         []storage.BackupEntry // Expired backups removed by this run, oldest first, or newest first for jobs with increments
+	Deferred       int                   // Expired backups left for later runs by max_deletions
+	Protected      int                   // Expired backups kept because increments that stay depend on them
+	ToTrash        bool                  // Removed backups are moved to trash instead of deleted
+	Purge          []storage.BackupEntry // Trashed backups past their grace period
+	ReclaimedBytes int64                 // Space freed by deleting and purging
+}
+
+// Plan works out which backups the job's retention policy would remove
+// without changing anything
+func (m *Manager) Plan(ctx context.Context, jobConfig config.JobConfig) (Plan, error) {
+	policy := jobConfig.RetentionPolicy
+
+	entries, err := m.storage.List(ctx, jobConfig.Name)
 	if err != nil {
-		return fmt.Errorf("failed to list backup files: %w", err)
+		return Plan{}, fmt.Errorf("failed to list backup files: %w", err)
 	}
 
-	if len(entries) <= keepCount {
-		return nil
+	var expired []storage.BackupEntry
+	switch policy.Type {
+	case "count":
+		expired = expiredByCount(entries, policy.Value)
+	case "days":
+		expired = expiredByAge(entries, policy.Value, m.clock.Now())
+	default:
+		return Plan{}, fmt.Errorf("unsupported retention policy type: %s", policy.Type)
 	}
 
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].ModTime.After(entries[j].ModTime)
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ModTime.Before(expired[j].ModTime)
 	})
 
-	for i := keepCount; i < len(entries); i++ {
-		if err := m.storage.Delete(entries[i]); err != nil {
-			log.Printf("Warning: failed to delete old backup %s: %v", entries[i].Key, err)
-			continue
+	plan := Plan{Total: len(entries), ToTrash: policy.GracePeriod > 0}
+	parents := m.parents(jobConfig.Name)
+	expired, plan.Protected = protectBases(entries, expired, parents)
+	if len(parents) > 0 {
+		// Increments go before their bases, so that max_deletions never
+		// leaves an increment behind without its base
+		sort.SliceStable(expired, func(i, j int) bool {
+			return expired[i].ModTime.After(expired[j].ModTime)
+		})
+	}
+	if policy.MaxDeletions > 0 && len(expired) > policy.MaxDeletions {
+		plan.Deferred = len(expired) - policy.MaxDeletions
+		expired = expired[:policy.MaxDeletions]
+	}
+	plan.Remove = expired
+
+	if !plan.ToTrash {
+		for _, entry := range plan.Remove {
+			plan.ReclaimedBytes += entry.Size
 		}
-		log.Printf("[Job: %s] Deleted old backup: %s", jobName, entries[i].Key)
+		return plan, nil
 	}
 
-	log.Printf("[Job: %s] Retention policy applied: kept %d of %d backups",
-		jobName, keepCount, len(entries))
+	trashed, err := m.storage.ListTrash(ctx, jobConfig.Name)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to list trash: %w", err)
+	}
+	cutoffTime := m.clock.Now().Add(-policy.GracePeriod)
+	for _, entry := range trashed {
+		if entry.ModTime.Before(cutoffTime) {
+			plan.Purge = append(plan.Purge, entry)
+			plan.ReclaimedBytes += entry.Size
+		}
+	}
 
-	return nil
+	return plan, nil
 }
 
-func (m *Manager) applyDaysBasedRetention(jobName string, keepDays int) error {
-	entries, err := m.storage.List(jobName)
+// ApplyRetentionPolicy removes the backups the job's policy expires. It stops
+// between removals once ctx is done, leaving the rest for the next run.
+func (m *Manager) ApplyRetentionPolicy(ctx context.Context, jobConfig config.JobConfig) error {
+	plan, err := m.Plan(ctx, jobConfig)
 	if err != nil {
-		return fmt.Errorf("failed to list backup files: %w", err)
+		return err
 	}
 
-	cutoffTime := time.Now().AddDate(0, 0, -keepDays)
-	deletedCount := 0
+	if plan.Deferred > 0 {
+		log.Printf("[Job: %s] Retention limited to %d removals, %d expired backups deferred to later runs",
+			jobConfig.Name, len(plan.Remove), plan.Deferred)
+	}
+	if plan.Protected > 0 {
+		log.Printf("[Job: %s] Keeping %d expired backups that newer increments depend on", jobConfig.Name, plan.Protected)
+	}
 
-	for _, entry := range entries {
-		if entry.ModTime.Before(cutoffTime) {
-			if err := m.storage.Delete(entry); err != nil {
+	removed := 0
+	var freed int64
+	guard := m.newGuard()
+	for _, entry := range plan.Remove {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("retention stopped after removing %d of %d backups: %w", removed, len(plan.Remove), err)
+		}
+		if err := guard.check(jobConfig.Name, entry); err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		if plan.ToTrash {
+			if err := m.storage.MoveToTrash(ctx, jobConfig.Name, entry); err != nil {
+				log.Printf("Warning: failed to move old backup %s to trash: %v", entry.Key, err)
+				continue
+			}
+			log.Printf("[Job: %s] Moved old backup to trash: %s", jobConfig.Name, entry.Key)
+		} else {
+			if err := m.delete(ctx, entry); err != nil {
 				log.Printf("Warning: failed to delete old backup %s: %v", entry.Key, err)
 				continue
 			}
-			deletedCount++
-			log.Printf("[Job: %s] Deleted backup older than %d days: %s",
-				jobName, keepDays, entry.Key)
+			log.Printf("[Job: %s] Deleted old backup: %s (%d bytes)", jobConfig.Name, entry.Key, entry.Size)
+			freed += entry.Size
 		}
+		removed++
 	}
 
-	log.Printf("[Job: %s] Retention policy applied: deleted %d backups older than %d days",
-		jobName, deletedCount, keepDays)
+	log.Printf("[Job: %s] Retention policy applied: removed %d of %d backups, freed %d bytes",
+		jobConfig.Name, removed, plan.Total, freed)
+
+	for _, entry := range plan.Purge {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("retention stopped while purging the trash: %w", err)
+		}
+		if err := guard.check(jobConfig.Name, entry); err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		if err := m.delete(ctx, entry); err != nil {
+			log.Printf("Warning: failed to delete trashed backup %s: %v", entry.Key, err)
+			continue
+		}
+		log.Printf("[Job: %s] Deleted backup from trash after %s: %s",
+			jobConfig.Name, jobConfig.RetentionPolicy.GracePeriod, entry.Key)
+	}
 
 	return nil
 }
+
+// protectBases leaves out of expired every backup that a backup staying in
+// entries depends on, returning what can be removed and how many were kept
+func protectBases(entries, expired []storage.BackupEntry, parents map[string]string) ([]storage.BackupEntry, int) {
+	if len(parents) == 0 {
+		return expired, 0
+	}
+
+	removed := make(map[string]bool, len(expired))
+	for _, entry := range expired {
+		removed[entry.Key] = true
+	}
+	var staying []string
+	for _, entry := range entries {
+		if !removed[entry.Key] {
+			staying = append(staying, backupName(entry))
+		}
+	}
+	needed := manifest.Dependents(parents, staying)
+
+	var removable []storage.BackupEntry
+	for _, entry := range expired {
+		if !needed[backupName(entry)] {
+			removable = append(removable, entry)
+		}
+	}
+	return removable, len(expired) - len(removable)
+}
+
+func expiredByCount(entries []storage.BackupEntry, keepCount int) []storage.BackupEntry {
+	if len(entries) <= keepCount {
+		return nil
+	}
+
+	sorted := append([]storage.BackupEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ModTime.After(sorted[j].ModTime)
+	})
+
+	return sorted[keepCount:]
+}
+
+func expiredByAge(entries []storage.BackupEntry, keepDays int, now time.Time) []storage.BackupEntry {
+	cutoffTime := now.AddDate(0, 0, -keepDays)
+
+	var expired []storage.BackupEntry
+	for _, entry := range entries {
+		if entry.ModTime.Before(cutoffTime) {
+			expired = append(expired, entry)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,284 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/storage"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// backupFile names a backup taken on the day of t
+func backupFile(t time.Time) string {
+	return "backup_" + t.Format("20060102") + "-000000.sql"
+}
+
+// writeBackups creates count backups for a job, one day apart, the newest first
+func writeBackups(t *testing.T, dir, jobName string, count int) {
+	t.Helper()
+	jobDir := filepath.Join(dir, jobName)
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+
+	for i := 0; i < count; i++ {
+		path := filepath.Join(jobDir, backupFile(time.Now().AddDate(0, 0, -i)))
+		require.NoError(t, os.WriteFile(path, []byte("backup"), 0644))
+		modTime := time.Now().AddDate(0, 0, -i)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+}
+
+func newJob(policy config.RetentionPolicy) config.JobConfig {
+	return config.JobConfig{Name: "myjob", RetentionPolicy: policy}
+}
+
+func TestApplyRetentionPolicy_Delete(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+	writeBackups(t, dir, "myjob", 5)
+
+	m := NewManager(store)
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), newJob(config.RetentionPolicy{Type: "count", Value: 2})))
+
+	entries, err := store.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	trashed, err := store.ListTrash(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, trashed)
+}
+
+func TestApplyRetentionPolicy_DirectoryBackups(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+	for i, name := range []string{"minio_backup_20250101-000000", "minio_backup_20250102-000000"} {
+		backup := filepath.Join(dir, "myjob", name)
+		require.NoError(t, os.MkdirAll(filepath.Join(backup, "bucket", "nested"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(backup, "bucket", "a.txt"), []byte("aaaa"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(backup, "bucket", "nested", "b.txt"), []byte("bb"), 0644))
+		modTime := time.Now().AddDate(0, 0, i-2)
+		require.NoError(t, os.Chtimes(backup, modTime, modTime))
+	}
+
+	metrics := storage.NewMetrics()
+	m := NewManager(store)
+	m.SetMetrics(metrics, "local")
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), newJob(config.RetentionPolicy{Type: "count", Value: 1})))
+
+	assert.NoDirExists(t, filepath.Join(dir, "myjob", "minio_backup_20250101-000000"))
+	assert.DirExists(t, filepath.Join(dir, "myjob", "minio_backup_20250102-000000"))
+	local := metrics.GetAll()["local"]
+	assert.Equal(t, 1, local.Deletions)
+	assert.Equal(t, int64(6), local.DeletedBytes)
+}
+
+func TestApplyRetentionPolicy_KeepsForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+	writeBackups(t, dir, "myjob", 2)
+	foreign := filepath.Join(dir, "myjob", "notes.txt")
+	require.NoError(t, os.WriteFile(foreign, []byte("keep me"), 0644))
+	old := time.Now().AddDate(-1, 0, 0)
+	require.NoError(t, os.Chtimes(foreign, old, old))
+
+	m := NewManager(store)
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), newJob(config.RetentionPolicy{Type: "count", Value: 1})))
+
+	assert.FileExists(t, foreign, "files without a backup timestamp or manifest are never removed")
+	entries, err := store.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestGuard(t *testing.T) {
+	g := NewManager(nil).newGuard()
+	for key, safe := range map[string]bool{
+		"/backups/myjob/pg_backup_20250101-000000.sql":                    true,
+		"backmeup/myjob/minio_backup_20250101-000000/":                    true,
+		"/backups/myjob/.trash/20250102-000000_pg_backup_20250101-000000": true,
+		"/backups/otherjob/pg_backup_20250101-000000.sql":                 false,
+		"/backups/myjob/nested/pg_backup_20250101-000000.sql":             false,
+		"/backups/myjob/.backmeup_20250101-000000":                        false,
+		"/backups/myjob/important.doc":                                    false,
+		"/backups/myjob":                                                  false,
+	} {
+		err := g.check("myjob", storage.BackupEntry{Key: key})
+		assert.Equal(t, safe, err == nil, key)
+	}
+}
+
+func TestApplyRetentionPolicy_Trash(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+	writeBackups(t, dir, "myjob", 5)
+
+	m := NewManager(store)
+	job := newJob(config.RetentionPolicy{Type: "days", Value: 2, GracePeriod: time.Hour})
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+
+	entries, err := store.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	trashed, err := store.ListTrash(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Len(t, trashed, 3, "expired backups stay in the trash during the grace period")
+
+	job.RetentionPolicy.GracePeriod = time.Nanosecond
+	time.Sleep(time.Second)
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+
+	trashed, err = store.ListTrash(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, trashed, "trash is purged once the grace period is over")
+}
+
+func TestApplyRetentionPolicy_MaxDeletions(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+	writeBackups(t, dir, "myjob", 6)
+
+	m := NewManager(store)
+	job := newJob(config.RetentionPolicy{Type: "count", Value: 1, MaxDeletions: 2})
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+
+	entries, err := store.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	oldest := time.Now()
+	for _, entry := range entries {
+		if entry.ModTime.Before(oldest) {
+			oldest = entry.ModTime
+		}
+	}
+	assert.True(t, oldest.After(time.Now().AddDate(0, 0, -4)), "the oldest backups are removed first")
+
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+	entries, err = store.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestApplyRetentionPolicy_Clock(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	clock := backuptest.NewClock(now)
+	store := backuptest.NewStorage(clock)
+	for day := 0; day < 10; day++ {
+		store.Put("myjob", backupFile(now.AddDate(0, 0, -day)), now.AddDate(0, 0, -day), []byte("backup"))
+	}
+
+	m := NewManager(store)
+	m.SetClock(clock)
+	job := newJob(config.RetentionPolicy{Type: "days", Value: 7, GracePeriod: 48 * time.Hour})
+
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+	assert.Len(t, store.Names("myjob"), 8)
+	assert.Len(t, store.TrashNames("myjob"), 2)
+
+	// A day later one more backup expires and the trash is still in its grace period
+	clock.Advance(24 * time.Hour)
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+	assert.Len(t, store.Names("myjob"), 7)
+	assert.Len(t, store.TrashNames("myjob"), 3)
+
+	// Two days later the first trashed backups are purged
+	clock.Advance(48*time.Hour + time.Minute)
+	plan, err := m.Plan(context.Background(), job)
+	require.NoError(t, err)
+	assert.Len(t, plan.Purge, 3)
+	assert.Len(t, plan.Remove, 3)
+}
+
+// cancellingStorage cancels a context once a backup has been removed
+type cancellingStorage struct {
+	*backuptest.Storage
+	cancel context.CancelFunc
+}
+
+func (s cancellingStorage) Delete(ctx context.Context, entry storage.BackupEntry) error {
+	defer s.cancel()
+	return s.Storage.Delete(ctx, entry)
+}
+
+func TestApplyRetentionPolicy_Cancelled(t *testing.T) {
+	now := time.Now()
+	store := backuptest.NewStorage(nil)
+	for day := 0; day < 5; day++ {
+		store.Put("myjob", backupFile(now.AddDate(0, 0, -day)), now.AddDate(0, 0, -day), []byte("backup"))
+	}
+	job := newJob(config.RetentionPolicy{Type: "count", Value: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := NewManager(store).ApplyRetentionPolicy(ctx, job)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, store.Names("myjob"), 5, "nothing is listed or removed once the context is done")
+
+	// Cancelled during the run, retention stops after the backup being removed
+	ctx, cancel = context.WithCancel(context.Background())
+	err = NewManager(cancellingStorage{Storage: store, cancel: cancel}).ApplyRetentionPolicy(ctx, job)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, store.Names("myjob"), 4)
+}
+
+// writeChain records in a catalog that each backup, named by its age in
+// days, is an increment of the one after it, the last being a full backup
+func writeChain(t *testing.T, catalog *manifest.Store, jobName string, ages ...int) {
+	t.Helper()
+	for i, age := range ages {
+		chain := &manifest.Chain{Snapshot: fmt.Sprintf("snap-%d", age)}
+		if i < len(ages)-1 {
+			chain.Parent = fmt.Sprintf("snap-%d", ages[i+1])
+		}
+		require.NoError(t, catalog.Write(manifest.Manifest{
+			Job:    jobName,
+			Backup: backupFile(time.Now().AddDate(0, 0, -age)),
+			Chain:  chain,
+		}))
+	}
+}
+
+func TestApplyRetentionPolicy_Chains(t *testing.T) {
+	dir := t.TempDir()
+	store := localfs.New(config.LocalConfig{Directory: dir})
+	writeBackups(t, dir, "myjob", 6)
+	catalog := manifest.NewStore(manifest.DefaultDir(dir))
+	writeChain(t, catalog, "myjob", 1, 2, 3) // Kept by nothing, removed as a whole
+	writeChain(t, catalog, "myjob", 0, 4, 5) // The newest backup needs two expired ones
+
+	m := NewManager(store)
+	m.SetCatalog(catalog)
+	job := newJob(config.RetentionPolicy{Type: "count", Value: 1, MaxDeletions: 2})
+
+	plan, err := m.Plan(context.Background(), job)
+	require.NoError(t, err)
+	assert.Equal(t, 2, plan.Protected)
+	assert.Equal(t, 1, plan.Deferred)
+	require.Len(t, plan.Remove, 2)
+	assert.True(t, plan.Remove[0].ModTime.After(plan.Remove[1].ModTime), "increments go before their bases")
+
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+	require.NoError(t, m.ApplyRetentionPolicy(context.Background(), job))
+	entries, err := store.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, filepath.Base(entry.Key))
+	}
+	assert.ElementsMatch(t, []string{
+		backupFile(time.Now()),
+		backupFile(time.Now().AddDate(0, 0, -4)),
+		backupFile(time.Now().AddDate(0, 0, -5)),
+	}, names)
+}
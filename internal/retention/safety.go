@@ -0,0 +1,77 @@
+package retention
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// trashDirName is the directory below a job's directory that holds its
+// trashed backups, in every storage
+const trashDirName = ".trash"
+
+// backupTimestamp matches the time every backup name carries, as written by
+// localfs.FileName
+var backupTimestamp = regexp.MustCompile(`_\d{8}-\d{6}`)
+
+// guard refuses to remove anything that does not look like a backup of its
+// job, so that a misconfigured storage directory never costs files that
+// backmeup did not write
+type guard struct {
+	manager  *Manager
+	recorded map[string]map[string]bool // Backups with a manifest, per job
+}
+
+func (m *Manager) newGuard() *guard {
+	return &guard{manager: m, recorded: make(map[string]map[string]bool)}
+}
+
+// check returns an error unless entry sits directly in the job's directory or
+// its trash and is named like a backup or has a manifest
+func (g *guard) check(jobName string, entry storage.BackupEntry) error {
+	key := strings.TrimSuffix(strings.ReplaceAll(entry.Key, `\`, "/"), "/")
+	dir, name := path.Split(key)
+	dir = strings.TrimSuffix(dir, "/")
+	trashed := path.Base(dir) == trashDirName
+	if trashed {
+		dir = path.Dir(dir)
+		if _, backup, ok := strings.Cut(name, "_"); ok {
+			name = backup
+		}
+	}
+
+	switch {
+	case jobName == "" || name == "" || name == "." || name == "..":
+		return fmt.Errorf("refusing to remove %s, it is not a backup", entry.Key)
+	case path.Base(dir) != jobName:
+		return fmt.Errorf("refusing to remove %s, it is outside the directory of job %s", entry.Key, jobName)
+	case strings.HasPrefix(name, ".") && !trashed:
+		return fmt.Errorf("refusing to remove %s, hidden files are not backups", entry.Key)
+	case backupTimestamp.MatchString(name) || g.hasManifest(jobName, name):
+		return nil
+	}
+	return fmt.Errorf("refusing to remove %s, its name does not carry a backup timestamp and it has no manifest", entry.Key)
+}
+
+// hasManifest reports whether the catalog records a backup of the job
+func (g *guard) hasManifest(jobName, name string) bool {
+	recorded, ok := g.recorded[jobName]
+	if !ok {
+		recorded = make(map[string]bool)
+		g.recorded[jobName] = recorded
+		if catalog := g.manager.catalog; catalog != nil {
+			manifests, err := catalog.List(jobName)
+			if err != nil {
+				log.Printf("Warning: failed to read the manifests of job %s: %v", jobName, err)
+			}
+			for _, m := range manifests {
+				recorded[m.Backup] = true
+			}
+		}
+	}
+	return recorded[name]
+}
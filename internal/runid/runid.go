@@ -0,0 +1,48 @@
+// Package runid identifies a single execution of a job, so that its log
+// lines, history entry, metrics and artifacts can be matched up
+package runid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+type contextKey struct{}
+
+// New returns a run ID: the start time of the run followed by random hex, so
+// that IDs sort by start time
+func New(start time.Time) string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return start.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(random)
+}
+
+// With returns a context carrying a run ID
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// From returns the run ID of a context, empty outside of a run
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+type backupsKey struct{}
+
+// WithBackups returns a context carrying the run IDs of staged backups by
+// name, for uploads that send the backups of several runs at once
+func WithBackups(ctx context.Context, ids map[string]string) context.Context {
+	return context.WithValue(ctx, backupsKey{}, ids)
+}
+
+// ForBackup returns the run that wrote a backup. Without backup run IDs on
+// the context every backup belongs to the context's run.
+func ForBackup(ctx context.Context, backup string) string {
+	if ids, ok := ctx.Value(backupsKey{}).(map[string]string); ok {
+		return ids[backup]
+	}
+	return From(ctx)
+}
@@ -0,0 +1,31 @@
+package runid
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	a, b := New(start), New(start)
+	assert.Regexp(t, `^20260301T020000Z-[0-9a-f]{8}$`, a)
+	assert.NotEqual(t, a, b)
+	assert.Less(t, a, New(start.Add(time.Second)))
+}
+
+func TestContext(t *testing.T) {
+	assert.Empty(t, From(context.Background()))
+	assert.Equal(t, "20260301T020000Z-0a1b2c3d", From(With(context.Background(), "20260301T020000Z-0a1b2c3d")))
+}
+
+func TestForBackup(t *testing.T) {
+	ctx := With(context.Background(), "20260301T020000Z-0a1b2c3d")
+	assert.Equal(t, "20260301T020000Z-0a1b2c3d", ForBackup(ctx, "backup_1.sql"))
+
+	ctx = WithBackups(ctx, map[string]string{"backup_0.sql": "20260228T020000Z-99aa88bb"})
+	assert.Equal(t, "20260228T020000Z-99aa88bb", ForBackup(ctx, "backup_0.sql"))
+	assert.Empty(t, ForBackup(ctx, "backup_1.sql"), "backups without a known run")
+}
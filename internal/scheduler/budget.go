@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// groupBudget is the run time the jobs of a concurrency group may use from
+// one opening of a daily window to the next
+type groupBudget struct {
+	window dailyWindow
+	budget time.Duration
+
+	mu      sync.Mutex
+	opened  time.Time            // Opening the spent time counts from
+	spent   time.Duration        // Run time of the runs that finished since
+	running map[string]time.Time // Start of the runs in progress by run ID
+}
+
+// newGroupBudgets returns the budgets of the concurrency groups that have
+// one. Budgets with an invalid window are ignored, validation rejects them.
+func newGroupBudgets(budgets map[string]config.GroupBudgetConfig) map[string]*groupBudget {
+	groups := make(map[string]*groupBudget, len(budgets))
+	for group, budget := range budgets {
+		start, end, err := config.ParseTimeWindow(budget.Window)
+		if err != nil {
+			log.Printf("Warning: ignoring the budget of concurrency group %s: invalid window '%s': %v", group, budget.Window, err)
+			continue
+		}
+		groups[group] = &groupBudget{
+			window:  dailyWindow{start: start, end: end},
+			budget:  budget.Budget,
+			running: make(map[string]time.Time),
+		}
+	}
+	return groups
+}
+
+// roll starts counting afresh when the window opened again since the last
+// call. It must be called with mu held.
+func (b *groupBudget) roll(now time.Time) {
+	if opened := b.window.opened(now); !opened.Equal(b.opened) {
+		b.opened = opened
+		b.spent = 0
+	}
+}
+
+// elapsed is the part of a run from start to now since the window opened.
+// It must be called with mu held.
+func (b *groupBudget) elapsed(start, now time.Time) time.Duration {
+	if start.Before(b.opened) {
+		start = b.opened
+	}
+	return max(now.Sub(start), 0)
+}
+
+// used returns the run time spent since the window last opened, including
+// that of the runs in progress
+func (b *groupBudget) used(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(now)
+	used := b.spent
+	for _, start := range b.running {
+		used += b.elapsed(start, now)
+	}
+	return used
+}
+
+func (b *groupBudget) start(id string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running[id] = now
+}
+
+func (b *groupBudget) finish(id string, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start, ok := b.running[id]
+	delete(b.running, id)
+	if !ok {
+		return
+	}
+	b.roll(now)
+	b.spent += b.elapsed(start, now)
+}
+
+// chargeBudget counts a run against the budget of its concurrency group
+// until the returned function is called
+func (js *JobScheduler) chargeBudget(jobConfig config.JobConfig, id string) func() {
+	budget, ok := js.budgets[jobConfig.ConcurrencyGroup]
+	if !ok {
+		return func() {}
+	}
+	budget.start(id, js.clock.Now())
+	return func() {
+		budget.finish(id, js.clock.Now())
+	}
+}
+
+// deferForBudget postpones a scheduled run to the next opening of its
+// concurrency group's window once the group has used up its budget. It
+// returns true when the run was deferred.
+func (js *JobScheduler) deferForBudget(jobConfig config.JobConfig, executor BackupExecutor) bool {
+	group := jobConfig.ConcurrencyGroup
+	budget, ok := js.budgets[group]
+	if !ok {
+		return false
+	}
+	now := js.clock.Now()
+	used := budget.used(now)
+	if used < budget.budget {
+		return false
+	}
+
+	next := budget.window.nextOpen(now)
+	log.Printf("Warning: concurrency group %s used %s of its %s budget, deferring backup job %s to %s",
+		group, used.Round(time.Second), budget.budget, jobConfig.Name, next.Format(time.RFC3339))
+
+	js.shiftedMu.Lock()
+	defer js.shiftedMu.Unlock()
+
+	if timer, ok := js.shifted[jobConfig.Name]; ok {
+		timer.Stop()
+	}
+	js.shifted[jobConfig.Name] = time.AfterFunc(next.Sub(now), func() {
+		js.trigger(jobConfig, executor)
+	})
+	return true
+}
@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+func TestDailyWindowOpenings(t *testing.T) {
+	overMidnight := dailyWindow{start: 22 * time.Hour, end: 6 * time.Hour}
+	assert.Equal(t, date(t, "2026-03-01 22:00"), overMidnight.opened(date(t, "2026-03-01 23:30")))
+	assert.Equal(t, date(t, "2026-03-01 22:00"), overMidnight.opened(date(t, "2026-03-02 03:00")))
+	assert.Equal(t, date(t, "2026-03-01 22:00"), overMidnight.opened(date(t, "2026-03-02 12:00")))
+	assert.Equal(t, date(t, "2026-03-02 22:00"), overMidnight.nextOpen(date(t, "2026-03-02 03:00")))
+	assert.Equal(t, date(t, "2026-03-03 22:00"), overMidnight.nextOpen(date(t, "2026-03-02 22:00")))
+}
+
+// timedExecutor writes a backup and takes a given time on the clock
+type timedExecutor struct {
+	fileExecutor
+	clock    *backuptest.Clock
+	duration time.Duration
+	runs     *int
+}
+
+func (e timedExecutor) Execute(ctx context.Context) error {
+	*e.runs++
+	e.clock.Advance(e.duration)
+	return e.fileExecutor.Execute(ctx)
+}
+
+func newBudgetScheduler(t *testing.T, clk *backuptest.Clock, maxRunning int) (*JobScheduler, string) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{
+		MaxConcurrentJobs: maxRunning,
+		GroupBudgets: map[string]config.GroupBudgetConfig{
+			"db-server-1": {Window: "22:00-06:00", Budget: time.Hour},
+		},
+	})
+	js.SetClock(clk)
+	t.Cleanup(js.Stop)
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	return js, jobDir
+}
+
+func TestDeferForBudget(t *testing.T) {
+	clk := backuptest.NewClock(date(t, "2026-03-01 22:00"))
+	js, jobDir := newBudgetScheduler(t, clk, 0)
+
+	job := config.JobConfig{Name: "orders", Schedule: "0 22 * * *", ConcurrencyGroup: "db-server-1",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	runs := 0
+	executor := timedExecutor{fileExecutor: fileExecutor{dir: jobDir}, clock: clk, duration: 40 * time.Minute, runs: &runs}
+	require.NoError(t, js.AddJob(job, executor))
+
+	js.trigger(job, executor)
+	js.trigger(job, executor)
+	assert.Equal(t, 2, runs, "the budget is checked before a run starts")
+
+	js.trigger(job, executor)
+	assert.Equal(t, 2, runs)
+	assert.Equal(t, []string{"orders"}, js.DebugState().ShiftedRuns, "the run waits for the next window")
+
+	_, err := js.RunNow("orders")
+	require.NoError(t, err)
+	assert.Equal(t, 3, runs, "manual runs are not held to the budget")
+
+	clk.Set(date(t, "2026-03-02 22:00"))
+	js.trigger(job, executor)
+	assert.Equal(t, 4, runs, "the budget is reset when the window opens again")
+	assert.Empty(t, js.DebugState().ShiftedRuns)
+}
+
+func TestDeferForBudget_Queued(t *testing.T) {
+	clk := backuptest.NewClock(date(t, "2026-03-01 22:00"))
+	js, jobDir := newBudgetScheduler(t, clk, 1)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	blocker := config.JobConfig{Name: "users", Schedule: "0 22 * * *", RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(blocker, blockingExecutor{started: started, release: release}))
+	job := config.JobConfig{Name: "orders", Schedule: "0 22 * * *", ConcurrencyGroup: "db-server-1",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	runs := 0
+	executor := timedExecutor{fileExecutor: fileExecutor{dir: jobDir}, clock: clk, runs: &runs}
+	require.NoError(t, js.AddJob(job, executor))
+
+	go js.RunNow("users")
+	<-started
+	done := make(chan struct{})
+	go func() {
+		js.trigger(job, executor)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return len(js.Runs().Queued) == 1 }, time.Second, 10*time.Millisecond)
+
+	// The group uses up its budget while the run waits for its slot
+	budget := js.budgets["db-server-1"]
+	budget.start("manual", clk.Now())
+	budget.finish("manual", clk.Advance(90*time.Minute))
+
+	close(release)
+	<-done
+	assert.Zero(t, runs)
+	assert.Equal(t, []string{"orders"}, js.DebugState().ShiftedRuns)
+}
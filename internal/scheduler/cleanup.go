@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// Defaults of the cleanup workers
+const (
+	defaultCleanupWorkers = 2
+	defaultCleanupTimeout = time.Hour
+)
+
+// cleanups applies retention and the storage quota after runs in the
+// background, so that removing many old backups from slow storage neither
+// keeps a run RUNNING nor holds its concurrency slot. A job has at most one
+// cleanup at a time; runs that finish during it are cleaned up once more
+// when it is done.
+type cleanups struct {
+	timeout time.Duration
+	slots   chan struct{} // Limits the cleanups running at the same time
+	run     func(ctx context.Context, jobConfig config.JobConfig)
+	stopCtx context.Context // Cancels every cleanup once done
+
+	mu   sync.Mutex
+	jobs map[string]*cleanup
+	wg   sync.WaitGroup
+}
+
+// cleanup is the pending or running cleanup of one job
+type cleanup struct {
+	jobConfig config.JobConfig
+	again     bool          // Another run finished while it was running
+	done      chan struct{} // Closed once no cleanup of the job is left
+}
+
+func newCleanups(cfg config.CleanupConfig, stopCtx context.Context, run func(ctx context.Context, jobConfig config.JobConfig)) *cleanups {
+	workers := cfg.Workers
+	if workers == 0 {
+		workers = defaultCleanupWorkers
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultCleanupTimeout
+	}
+	return &cleanups{
+		timeout: timeout,
+		slots:   make(chan struct{}, workers),
+		run:     run,
+		stopCtx: stopCtx,
+		jobs:    make(map[string]*cleanup),
+	}
+}
+
+// start cleans up after a run of a job in the background
+func (c *cleanups) start(jobConfig config.JobConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if job, ok := c.jobs[jobConfig.Name]; ok {
+		job.jobConfig, job.again = jobConfig, true
+		return
+	}
+	job := &cleanup{jobConfig: jobConfig, done: make(chan struct{})}
+	c.jobs[jobConfig.Name] = job
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			c.mu.Lock()
+			current := job.jobConfig
+			job.again = false
+			c.mu.Unlock()
+
+			c.runOne(current)
+
+			c.mu.Lock()
+			if !job.again || c.stopCtx.Err() != nil {
+				delete(c.jobs, current.Name)
+				close(job.done)
+				c.mu.Unlock()
+				return
+			}
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// runOne waits for a free worker and runs a cleanup within the timeout
+func (c *cleanups) runOne(jobConfig config.JobConfig) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-c.stopCtx.Done():
+		return
+	}
+	defer func() { <-c.slots }()
+
+	ctx, cancel := context.WithTimeout(c.stopCtx, c.timeout)
+	defer cancel()
+	c.run(ctx, jobConfig)
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Warning: cleanup of job %s did not finish within %s, the rest is left for its next run", jobConfig.Name, c.timeout)
+	}
+}
+
+// wait blocks until the job has no cleanup left
+func (c *cleanups) wait(jobName string) {
+	c.mu.Lock()
+	job, ok := c.jobs[jobName]
+	c.mu.Unlock()
+	if ok {
+		<-job.done
+	}
+}
+
+// waitAll blocks until every cleanup has returned
+func (c *cleanups) waitAll() {
+	c.wg.Wait()
+}
+
+// names returns the jobs with a pending or running cleanup, sorted
+func (c *cleanups) names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(maps.Keys(c.jobs))
+}
@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+func TestCleanups_CoalescesRunsOfAJob(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	var mu sync.Mutex
+	var cleaned []int
+	c := newCleanups(config.CleanupConfig{}, context.Background(), func(ctx context.Context, jobConfig config.JobConfig) {
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		cleaned = append(cleaned, jobConfig.RetentionPolicy.Value)
+		mu.Unlock()
+	})
+
+	job := config.JobConfig{Name: "orders", RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+	c.start(job)
+	<-started
+	assert.Equal(t, []string{"orders"}, c.names())
+
+	// Runs finishing during the cleanup add one more, with the newest configuration
+	job.RetentionPolicy.Value = 2
+	c.start(job)
+	job.RetentionPolicy.Value = 3
+	c.start(job)
+
+	close(release)
+	c.wait("orders")
+	assert.Empty(t, c.names())
+	assert.Equal(t, []int{1, 3}, cleaned)
+}
+
+func TestCleanups_WorkersAndTimeout(t *testing.T) {
+	stopCtx, stop := context.WithCancel(context.Background())
+	started := make(chan string, 2)
+	c := newCleanups(config.CleanupConfig{Workers: 1, Timeout: 50 * time.Millisecond}, stopCtx, func(ctx context.Context, jobConfig config.JobConfig) {
+		started <- jobConfig.Name
+		<-ctx.Done()
+	})
+
+	c.start(config.JobConfig{Name: "orders"})
+	c.start(config.JobConfig{Name: "users"})
+	first := <-started
+	select {
+	case name := <-started:
+		t.Fatalf("cleanup of %s started while the only worker was busy", name)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The first cleanup times out and frees the worker
+	second := <-started
+	assert.ElementsMatch(t, []string{"orders", "users"}, []string{first, second})
+
+	stop()
+	c.waitAll()
+	require.Empty(t, c.names())
+}
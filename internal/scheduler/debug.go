@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DebugState is a snapshot of the scheduler internals, for diagnosing runs
+// that hang or never start
+type DebugState struct {
+	Started        bool                    `json:"started"`
+	Jobs           []ScheduledJobState     `json:"jobs"`
+	Running        map[string]RunningState `json:"running"`
+	Queue          QueueState              `json:"queue"`
+	ShiftedRuns    []string                `json:"shiftedRuns"`         // Jobs with a run moved off an excluded date or deferred for load
+	Deferrals      map[string]int          `json:"deferrals,omitempty"` // Times the current run of a job was deferred for load
+	PendingUploads []string                `json:"pendingUploads"`
+	Cleanups       []string                `json:"cleanups"` // Jobs whose retention is applied or waiting for a worker
+	Maintenance    MaintenanceState        `json:"maintenance"`
+}
+
+// ScheduledJobState is a job as the cron scheduler sees it
+type ScheduledJobState struct {
+	Name     string    `json:"name"`
+	NextRun  time.Time `json:"nextRun"`
+	LastRun  time.Time `json:"lastRun,omitzero"`
+	RunCount int       `json:"runCount"`
+	Running  bool      `json:"running"` // Inside the cron callback, including while queued for a slot
+}
+
+// RunningState is a run in progress
+type RunningState struct {
+	RunID     string    `json:"runId"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// QueueState shows the slots taken and the runs waiting for one
+type QueueState struct {
+	Running int               `json:"running"`
+	Groups  map[string]int    `json:"groups,omitempty"` // Running jobs per concurrency group
+	Waiting []WaitingRunState `json:"waiting"`          // In the order slots are granted
+}
+
+// WaitingRunState is a run waiting for a concurrency slot
+type WaitingRunState struct {
+	Group    string `json:"group,omitempty"`
+	Priority int    `json:"priority"`
+}
+
+// DebugState returns a snapshot of the scheduler internals
+func (js *JobScheduler) DebugState() DebugState {
+	state := DebugState{
+		Started:        js.scheduler.IsRunning(),
+		Jobs:           []ScheduledJobState{},
+		Running:        make(map[string]RunningState),
+		Queue:          js.limiter.state(),
+		PendingUploads: js.PendingUploads(),
+		Cleanups:       js.cleanups.names(),
+		Maintenance:    js.Maintenance(),
+	}
+	if state.PendingUploads == nil {
+		state.PendingUploads = []string{}
+	}
+	if state.Cleanups == nil {
+		state.Cleanups = []string{}
+	}
+
+	for _, job := range js.scheduler.Jobs() {
+		tags := job.Tags()
+		if len(tags) == 0 {
+			continue
+		}
+		state.Jobs = append(state.Jobs, ScheduledJobState{
+			Name:     tags[0],
+			NextRun:  job.NextRun(),
+			LastRun:  job.LastRun(),
+			RunCount: job.RunCount(),
+			Running:  job.IsRunning(),
+		})
+	}
+	slices.SortFunc(state.Jobs, func(a, b ScheduledJobState) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	js.runningMu.Lock()
+	for name, run := range js.running {
+		state.Running[name] = RunningState{RunID: run.id, StartedAt: run.startedAt}
+	}
+	js.runningMu.Unlock()
+
+	js.shiftedMu.Lock()
+	state.ShiftedRuns = slices.Sorted(maps.Keys(js.shifted))
+	state.Deferrals = maps.Clone(js.deferrals)
+	js.shiftedMu.Unlock()
+	if state.ShiftedRuns == nil {
+		state.ShiftedRuns = []string{}
+	}
+	return state
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/retention"
+	"github.com/thitiph0n/backmeup/internal/runid"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// destination is a remote storage that the jobs listing it get a copy of
+// each backup in, besides the primary storage
+type destination struct {
+	remote    RemoteStorage
+	backend   string // Storage type, for metrics
+	retention *retention.Manager
+}
+
+// AddDestination copies the backups of the jobs that list name in their
+// destinations to remote after each run, before they are uploaded to the
+// primary storage, and applies the jobs' retention policies to the copies.
+// backend is the storage type of remote. It must be called before Start.
+func (js *JobScheduler) AddDestination(name, backend string, remote RemoteStorage) {
+	mgr := retention.NewManager(remote)
+	mgr.SetClock(js.clock)
+	mgr.SetCatalog(js.manifests)
+	if js.destinations == nil {
+		js.destinations = make(map[string]*destination)
+	}
+	js.destinations[name] = &destination{remote: remote, backend: backend, retention: mgr}
+}
+
+// replicate copies the job's staged backups to each of its destinations and
+// returns the outcome of every copy, named after its destination
+func (js *JobScheduler) replicate(ctx context.Context, jobConfig config.JobConfig) []failure.Artifact {
+	if len(jobConfig.Destinations) == 0 {
+		return nil
+	}
+	jobName := jobConfig.Name
+	jobDir := filepath.Join(js.localDir, jobName)
+	ctx = runid.WithBackups(ctx, js.stagedRunIDs(jobName))
+
+	copies := make([]failure.Artifact, 0, len(jobConfig.Destinations))
+	for _, name := range jobConfig.Destinations {
+		result, err := js.copyTo(ctx, name, jobConfig, jobDir)
+		if err != nil {
+			log.Printf("Error copying backup job %s to destination %s: %v", jobName, name, err)
+		} else {
+			log.Printf("[Job: %s] Copied %d files (%d bytes) to destination %s, skipped %d already there",
+				jobName, result.Uploaded, result.Bytes, name, result.Skipped)
+		}
+		copies = append(copies, failure.Artifact{Name: name, Err: err})
+	}
+	return copies
+}
+
+// copyTo uploads the job's staged backups to one destination
+func (js *JobScheduler) copyTo(ctx context.Context, name string, jobConfig config.JobConfig, jobDir string) (storage.UploadResult, error) {
+	d, ok := js.destinations[name]
+	if !ok {
+		return storage.UploadResult{}, &failure.ConfigError{Err: fmt.Errorf("storage destination %s is not configured", name)}
+	}
+	if configured, ok := d.remote.(ConfiguredStorage); ok {
+		if err := configured.ConfigureJob(ctx, jobConfig); err != nil {
+			log.Printf("Warning: failed to set the storage transitions of job %s on destination %s: %v", jobConfig.Name, name, err)
+		}
+	}
+	return d.remote.Upload(ctx, jobConfig.Name, jobDir)
+}
+
+// cleanUpDestinations applies a job's retention policy to its copies on each
+// of its destinations
+func (js *JobScheduler) cleanUpDestinations(ctx context.Context, jobConfig config.JobConfig) {
+	for _, name := range jobConfig.Destinations {
+		d, ok := js.destinations[name]
+		if !ok {
+			continue
+		}
+		if err := d.retention.ApplyRetentionPolicy(ctx, jobConfig); err != nil {
+			log.Printf("Error applying retention policy to destination %s of job %s: %v", name, jobConfig.Name, err)
+		}
+	}
+}
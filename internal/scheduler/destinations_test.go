@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/storage"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// copyingRemote is a remote storage in a local directory that copies every
+// staged backup file into it, or fails
+type copyingRemote struct {
+	*localfs.Storage
+	dir string
+	err error
+}
+
+func newCopyingRemote(t *testing.T, err error) copyingRemote {
+	dir := t.TempDir()
+	return copyingRemote{Storage: localfs.New(config.LocalConfig{Directory: dir}), dir: dir, err: err}
+}
+
+func (r copyingRemote) Upload(ctx context.Context, jobName, localDir string) (storage.UploadResult, error) {
+	if r.err != nil {
+		return storage.UploadResult{}, r.err
+	}
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return storage.UploadResult{}, err
+	}
+	var result storage.UploadResult
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(localDir, entry.Name()))
+		if err != nil {
+			return result, err
+		}
+		if err := os.MkdirAll(filepath.Join(r.dir, jobName), 0755); err != nil {
+			return result, err
+		}
+		if err := os.WriteFile(filepath.Join(r.dir, jobName, entry.Name()), data, 0644); err != nil {
+			return result, err
+		}
+		result.Entries = append(result.Entries, entry.Name())
+		result.Uploaded++
+	}
+	return result, nil
+}
+
+func TestRunNow_Destinations(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	nas := newCopyingRemote(t, nil)
+	js.AddDestination("nas", "rclone", nas)
+	js.AddDestination("offsite", "s3", newCopyingRemote(t, &failure.ConnectionError{Err: errors.New("connection refused")}))
+
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	old := filepath.Join(nas.dir, "orders", "backup_20250101-000000")
+	require.NoError(t, os.MkdirAll(filepath.Dir(old), 0755))
+	require.NoError(t, os.WriteFile(old, []byte("data"), 0644))
+	require.NoError(t, os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+
+	var statuses []string
+	js.RegisterStatusCallback(func(jobName, status string, timestamp time.Time) {
+		statuses = append(statuses, status)
+	})
+
+	job := config.JobConfig{Name: "orders", Type: "postgres", Schedule: "0 3 * * *", Destinations: []string{"nas", "offsite"},
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: jobDir}))
+
+	run, err := js.RunNow("orders")
+	assert.EqualError(t, err, "copy to 1 of 2 destinations failed: offsite: connection refused")
+	assert.Equal(t, history.StatusPartial, run.Status)
+	assert.Equal(t, "connection", run.ErrorCode)
+	assert.Equal(t, []history.Artifact{
+		{Name: "nas", Status: history.StatusSuccess},
+		{Name: "offsite", Status: history.StatusFailed, Error: "connection refused", ErrorCode: "connection"},
+	}, run.Destinations)
+	assert.Equal(t, StatusPartial, statuses[len(statuses)-1])
+
+	local, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	require.Len(t, local, 1)
+	copies, err := os.ReadDir(filepath.Join(nas.dir, "orders"))
+	require.NoError(t, err)
+	require.Len(t, copies, 1, "retention applies to the copies on the destination")
+	assert.Equal(t, local[0].Name(), copies[0].Name())
+}
+
+func TestRunNow_UnknownDestination(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "orders"), 0755))
+
+	job := config.JobConfig{Name: "orders", Type: "postgres", Schedule: "0 3 * * *", Destinations: []string{"nas"},
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+
+	run, err := js.RunNow("orders")
+	assert.EqualError(t, err, "copy to 1 of 1 destinations failed: nas: storage destination nas is not configured")
+	assert.Equal(t, history.StatusPartial, run.Status)
+	assert.Equal(t, "config", run.ErrorCode)
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// minEstimateRuns is the number of successful runs a job needs before its
+// durations are estimated
+const minEstimateRuns = 3
+
+// activeRun is a run in progress with the durations expected from history
+type activeRun struct {
+	id        string
+	startedAt time.Time
+	stats     history.Stats
+	overdue   *time.Timer
+}
+
+// RunEstimate describes a run in progress. Durations are zero until the job
+// has enough successful runs in its history.
+type RunEstimate struct {
+	RunID               string
+	StartedAt           time.Time
+	Typical             time.Duration // Median duration of recent successful runs
+	P95                 time.Duration
+	EstimatedCompletion time.Time
+	Overdue             bool // Running for longer than the p95 duration
+}
+
+// RunEstimate returns the progress of a job's current run, or false when the
+// job is not running
+func (js *JobScheduler) RunEstimate(jobName string) (RunEstimate, bool) {
+	js.runningMu.Lock()
+	run, ok := js.running[jobName]
+	js.runningMu.Unlock()
+	if !ok {
+		return RunEstimate{}, false
+	}
+
+	estimate := RunEstimate{RunID: run.id, StartedAt: run.startedAt}
+	if run.stats.Runs >= minEstimateRuns {
+		estimate.Typical = run.stats.Median
+		estimate.P95 = run.stats.P95
+		estimate.EstimatedCompletion = run.startedAt.Add(run.stats.Median)
+		estimate.Overdue = time.Since(run.startedAt) > run.stats.P95
+	}
+	return estimate, true
+}
+
+// startRun tracks a run in progress, the only one of its job, and warns once it outlasts the job's
+// historical p95 duration
+func (js *JobScheduler) startRun(jobName, id string, startedAt time.Time) {
+	run := &activeRun{id: id, startedAt: startedAt}
+	if js.history != nil {
+		stats, err := js.history.JobStats(jobName)
+		if err != nil {
+			log.Printf("Warning: failed to read run history of job %s: %v", jobName, err)
+		}
+		run.stats = stats
+	}
+
+	if run.stats.Runs >= minEstimateRuns {
+		p95 := run.stats.P95
+		run.overdue = time.AfterFunc(time.Until(startedAt.Add(p95)), func() {
+			log.Printf("Warning: backup job %s (run %s) has been running for longer than its p95 duration of %s (typically %s)",
+				jobName, id, p95.Round(time.Second), run.stats.Median.Round(time.Second))
+		})
+	}
+
+	js.runningMu.Lock()
+	js.running[jobName] = run
+	js.runningMu.Unlock()
+}
+
+// finishRun stops tracking the run. runJob never starts a job that is
+// already running, but a run only ever drops its own tracking.
+func (js *JobScheduler) finishRun(jobName, id string) {
+	js.runningMu.Lock()
+	run, ok := js.running[jobName]
+	if ok && run.id == id {
+		delete(js.running, jobName)
+	}
+	js.runningMu.Unlock()
+
+	if ok && run.id == id && run.overdue != nil {
+		run.overdue.Stop()
+	}
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+func TestRunEstimate(t *testing.T) {
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{})
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	require.NoError(t, err)
+	js.SetHistory(store)
+
+	_, ok := js.RunEstimate("orders")
+	assert.False(t, ok, "jobs that are not running have no estimate")
+
+	// Too little history for an estimate
+	started := time.Now().Add(-24 * time.Hour)
+	require.NoError(t, store.Append(history.Run{Job: "orders", Status: history.StatusSuccess, StartedAt: started, FinishedAt: started.Add(10 * time.Minute)}))
+	js.startRun("orders", "run-1", time.Now())
+	estimate, ok := js.RunEstimate("orders")
+	require.True(t, ok)
+	assert.Equal(t, "run-1", estimate.RunID)
+	assert.Zero(t, estimate.Typical)
+	assert.True(t, estimate.EstimatedCompletion.IsZero())
+	js.finishRun("orders", "run-1")
+
+	for _, minutes := range []int{10, 12, 30} {
+		require.NoError(t, store.Append(history.Run{Job: "orders", Status: history.StatusSuccess, StartedAt: started, FinishedAt: started.Add(time.Duration(minutes) * time.Minute)}))
+	}
+
+	now := time.Now()
+	js.startRun("orders", "run-2", now.Add(-5*time.Minute))
+	estimate, ok = js.RunEstimate("orders")
+	require.True(t, ok)
+	assert.Equal(t, 10*time.Minute, estimate.Typical)
+	assert.Equal(t, 30*time.Minute, estimate.P95)
+	assert.Equal(t, now.Add(5*time.Minute), estimate.EstimatedCompletion)
+	assert.False(t, estimate.Overdue)
+	js.finishRun("orders", "run-2")
+
+	js.startRun("orders", "run-3", now.Add(-time.Hour))
+	estimate, _ = js.RunEstimate("orders")
+	assert.True(t, estimate.Overdue)
+	js.finishRun("orders", "run-2")
+	estimate, ok = js.RunEstimate("orders")
+	require.True(t, ok, "another run does not drop the tracking")
+	assert.Equal(t, "run-3", estimate.RunID)
+	js.finishRun("orders", "run-3")
+
+	_, ok = js.RunEstimate("orders")
+	assert.False(t, ok)
+}
@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const (
+	dateLayout            = "2006-01-02"
+	icalDateLayout        = "20060102"
+	defaultICalRefresh    = 24 * time.Hour
+	icalFetchTimeout      = 30 * time.Second
+	maxExclusionLookahead = 366
+)
+
+// exclusionCalendar holds the dates on which scheduled runs must not happen.
+// Static dates come from the configuration; dates from an iCal feed are
+// refreshed periodically and the previous set is kept if a refresh fails.
+type exclusionCalendar struct {
+	mu          sync.Mutex
+	static      map[string]bool
+	ical        map[string]bool
+	icalURL     string
+	refresh     time.Duration
+	lastFetched time.Time
+	fetch       func(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+func newExclusionCalendar(cfg config.ExclusionConfig) *exclusionCalendar {
+	c := &exclusionCalendar{
+		static:  make(map[string]bool),
+		icalURL: cfg.ICalURL,
+		refresh: cfg.RefreshInterval,
+		fetch:   fetchURL,
+	}
+	if c.refresh <= 0 {
+		c.refresh = defaultICalRefresh
+	}
+
+	for _, entry := range cfg.Dates {
+		from, to, err := config.ParseDateRange(entry)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid exclusion date %q: %v", entry, err)
+			continue
+		}
+		addDays(c.static, from, to)
+	}
+
+	return c
+}
+
+// Excluded reports whether t falls on an excluded date
+func (c *exclusionCalendar) Excluded(t time.Time) bool {
+	c.refreshICal(time.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	day := t.Format(dateLayout)
+	return c.static[day] || c.ical[day]
+}
+
+// NextAllowed returns the same time of day on the first following date that
+// is not excluded
+func (c *exclusionCalendar) NextAllowed(t time.Time) (time.Time, bool) {
+	for i := 1; i <= maxExclusionLookahead; i++ {
+		next := t.AddDate(0, 0, i)
+		if !c.Excluded(next) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// refreshICal reloads the iCal feed when it is due. The fetch runs without
+// holding the lock so that lookups keep answering from the previous set.
+func (c *exclusionCalendar) refreshICal(now time.Time) {
+	c.mu.Lock()
+	if c.icalURL == "" || (!c.lastFetched.IsZero() && now.Sub(c.lastFetched) < c.refresh) {
+		c.mu.Unlock()
+		return
+	}
+	c.lastFetched = now
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), icalFetchTimeout)
+	defer cancel()
+
+	body, err := c.fetch(ctx, c.icalURL)
+	if err != nil {
+		log.Printf("Warning: failed to fetch exclusion calendar %s: %v", c.icalURL, err)
+		return
+	}
+	defer body.Close()
+
+	days, err := parseICalDates(body)
+	if err != nil {
+		log.Printf("Warning: failed to parse exclusion calendar %s: %v", c.icalURL, err)
+		return
+	}
+
+	c.mu.Lock()
+	c.ical = days
+	c.mu.Unlock()
+	log.Printf("Loaded %d excluded dates from %s", len(days), c.icalURL)
+}
+
+func fetchURL(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func addDays(days map[string]bool, from, to time.Time) {
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		days[d.Format(dateLayout)] = true
+	}
+}
+
+// parseICalDates collects the days covered by every VEVENT of an iCalendar
+// document. Recurrence rules are not expanded.
+func parseICalDates(r io.Reader) (map[string]bool, error) {
+	days := make(map[string]bool)
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Folded lines continue the previous line
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var inEvent bool
+	var start, end string
+	for _, line := range lines {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		property, _, _ := strings.Cut(name, ";")
+
+		switch strings.ToUpper(property) {
+		case "BEGIN":
+			if value == "VEVENT" {
+				inEvent, start, end = true, "", ""
+			}
+		case "DTSTART":
+			start = value
+		case "DTEND":
+			end = value
+		case "END":
+			if value == "VEVENT" && inEvent {
+				inEvent = false
+				if err := addEventDays(days, start, end); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func addEventDays(days map[string]bool, start, end string) error {
+	if len(start) < len(icalDateLayout) {
+		return fmt.Errorf("invalid DTSTART %q", start)
+	}
+	from, err := time.ParseInLocation(icalDateLayout, start[:len(icalDateLayout)], time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid DTSTART %q: %w", start, err)
+	}
+
+	to := from
+	if len(end) >= len(icalDateLayout) {
+		to, err = time.ParseInLocation(icalDateLayout, end[:len(icalDateLayout)], time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid DTEND %q: %w", end, err)
+		}
+		// All-day and midnight ends are exclusive
+		if len(end) == len(icalDateLayout) || strings.HasPrefix(end[len(icalDateLayout):], "T000000") {
+			to = to.AddDate(0, 0, -1)
+		}
+		if to.Before(from) {
+			to = from
+		}
+	}
+
+	addDays(days, from, to)
+	return nil
+}
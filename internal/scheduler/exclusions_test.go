@@ -0,0 +1,125 @@
+package scheduler
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+func date(t *testing.T, value string) time.Time {
+	t.Helper()
+	d, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	require.NoError(t, err)
+	return d
+}
+
+func TestExclusionCalendarStaticDates(t *testing.T) {
+	c := newExclusionCalendar(config.ExclusionConfig{
+		Dates: []string{"2026-12-24", "2026-12-28..2027-01-01"},
+	})
+
+	assert.True(t, c.Excluded(date(t, "2026-12-24 03:00")))
+	assert.False(t, c.Excluded(date(t, "2026-12-25 03:00")))
+	assert.True(t, c.Excluded(date(t, "2026-12-28 00:00")))
+	assert.True(t, c.Excluded(date(t, "2027-01-01 23:59")))
+	assert.False(t, c.Excluded(date(t, "2027-01-02 00:00")))
+
+	next, ok := c.NextAllowed(date(t, "2026-12-28 03:00"))
+	require.True(t, ok)
+	assert.Equal(t, date(t, "2027-01-02 03:00"), next)
+}
+
+const testCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VEVENT
+SUMMARY:Christmas
+DTSTART;VALUE=DATE:20261225
+DTEND;VALUE=DATE:20261227
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Year-end
+  closing
+DTSTART:20261231T090000
+DTEND:20261231T170000
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestExclusionCalendarICal(t *testing.T) {
+	c := newExclusionCalendar(config.ExclusionConfig{ICalURL: "https://example.com/holidays.ics"})
+
+	fetches := 0
+	c.fetch = func(_ context.Context, _ string) (io.ReadCloser, error) {
+		fetches++
+		return io.NopCloser(strings.NewReader(testCalendar)), nil
+	}
+
+	assert.False(t, c.Excluded(date(t, "2026-12-24 03:00")))
+	assert.True(t, c.Excluded(date(t, "2026-12-25 03:00")))
+	assert.True(t, c.Excluded(date(t, "2026-12-26 03:00")))
+	assert.False(t, c.Excluded(date(t, "2026-12-27 03:00")), "all-day DTEND is exclusive")
+	assert.True(t, c.Excluded(date(t, "2026-12-31 03:00")))
+
+	assert.Equal(t, 1, fetches, "feed is cached until the refresh interval passes")
+}
+
+func TestExclusionCalendarICalFetchDoesNotBlockLookups(t *testing.T) {
+	c := newExclusionCalendar(config.ExclusionConfig{
+		Dates:   []string{"2026-12-24"},
+		ICalURL: "https://example.com/holidays.ics",
+	})
+
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	c.fetch = func(_ context.Context, _ string) (io.ReadCloser, error) {
+		close(fetching)
+		<-release
+		return io.NopCloser(strings.NewReader(testCalendar)), nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Excluded(date(t, "2026-12-25 03:00"))
+	}()
+	<-fetching
+
+	assert.True(t, c.Excluded(date(t, "2026-12-24 03:00")), "answered while the feed is fetched")
+	assert.False(t, c.Excluded(date(t, "2026-12-25 03:00")), "previous feed until the fetch completes")
+
+	close(release)
+	<-done
+	assert.True(t, c.Excluded(date(t, "2026-12-25 03:00")))
+}
+
+func TestShiftRun(t *testing.T) {
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{
+		Exclusions: config.ExclusionConfig{Dates: []string{"2026-12-24"}},
+	})
+	defer js.Stop()
+
+	// 2026-12-24 is a Thursday
+	now := date(t, "2026-12-24 03:00")
+	weekly := config.JobConfig{Name: "weekly", Type: "postgres", Schedule: "0 3 * * 4", OnExcludedDate: "shift"}
+	daily := config.JobConfig{Name: "daily", Type: "postgres", Schedule: "0 3 * * *", OnExcludedDate: "shift"}
+	js.jobConfigs[weekly.Name] = weekly
+	js.jobConfigs[daily.Name] = daily
+
+	js.shiftRun(weekly, fileExecutor{}, now)
+	assert.Contains(t, js.shifted, "weekly", "shifted to the next allowed date")
+
+	js.shiftRun(weekly, fileExecutor{}, now)
+	assert.Len(t, js.shifted, 1, "a single shifted run is pending")
+
+	js.shiftRun(daily, fileExecutor{}, now)
+	assert.NotContains(t, js.shifted, "daily", "the schedule runs on the next allowed date anyway")
+
+	js.cancelShiftedRun("weekly")
+	assert.Empty(t, js.shifted)
+}
@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// PruneLeftovers removes what crashed runs left in the local directory, for
+// every job directory, and the directories of jobs that are empty. Call it
+// before Start, while no run is in progress.
+func (js *JobScheduler) PruneLeftovers() localfs.PruneReport {
+	var report localfs.PruneReport
+	entries, err := os.ReadDir(js.localDir)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to prune the local directory: %v", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		report.Add(js.pruneJobDir(e.Name()))
+	}
+	report.Add(js.removeEmptyJobDirs(nil))
+	logPruned("the local directory", report)
+	return report
+}
+
+// pruneLeftovers removes what crashed runs left in a job's directory, and the
+// empty directories of jobs that are no longer scheduled, after retention
+func (js *JobScheduler) pruneLeftovers(jobName string) {
+	report := js.pruneJobDir(jobName)
+	report.Add(js.removeEmptyJobDirs(js.JobNames()))
+	logPruned("job "+jobName, report)
+}
+
+// pruneJobDir removes the leftovers of a job that are older than any run in
+// progress can be
+func (js *JobScheduler) pruneJobDir(jobName string) localfs.PruneReport {
+	report, err := js.local.PruneJob(jobName, js.clock.Now().Add(-RunTimeout))
+	if err != nil {
+		log.Printf("Warning: failed to prune the leftovers of job %s: %v", jobName, err)
+	}
+	return report
+}
+
+func (js *JobScheduler) removeEmptyJobDirs(keep []string) localfs.PruneReport {
+	report, err := js.local.RemoveEmptyJobDirs(keep)
+	if err != nil {
+		log.Printf("Warning: failed to remove empty job directories: %v", err)
+	}
+	return report
+}
+
+func logPruned(what string, report localfs.PruneReport) {
+	if report.Files == 0 && report.Dirs == 0 {
+		return
+	}
+	log.Printf("Pruned %s: removed %d leftover files and %d empty job directories, reclaimed %d bytes",
+		what, report.Files, report.Dirs, report.Bytes)
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+func TestPruneLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	defer js.Stop()
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "orders"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "removed"), 0755))
+	stale := filepath.Join(dir, "orders", "orders_20260301-020000.db.partial")
+	recent := filepath.Join(dir, "orders", "orders_20260302-020000.db.partial")
+	require.NoError(t, os.WriteFile(stale, []byte("stale"), 0644))
+	require.NoError(t, os.WriteFile(recent, []byte("recent"), 0644))
+	old := time.Now().Add(-RunTimeout - time.Hour)
+	require.NoError(t, os.Chtimes(stale, old, old))
+
+	report := js.PruneLeftovers()
+	assert.Equal(t, localfs.PruneReport{Files: 1, Dirs: 1, Bytes: int64(len("stale"))}, report)
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, recent, "a file a run may still be writing is kept")
+	assert.NoDirExists(t, filepath.Join(dir, "removed"))
+
+	// After retention only the directories of jobs that are not scheduled go
+	require.NoError(t, os.Remove(recent))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "renamed"), 0755))
+	js.pruneLeftovers("orders")
+	assert.DirExists(t, filepath.Join(dir, "orders"))
+	assert.NoDirExists(t, filepath.Join(dir, "renamed"))
+}
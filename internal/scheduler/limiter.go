@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// limiter bounds the number of concurrently running jobs, both globally and
+// per concurrency group. Waiting jobs are granted a slot by priority (highest
+// first, arrival order within the same priority) as soon as both limits allow
+// it.
+type limiter struct {
+	mu          sync.Mutex
+	maxRunning  int
+	groupLimits map[string]int
+	running     int
+	groups      map[string]int
+	waiters     []*waiter
+}
+
+type waiter struct {
+	group    string
+	priority int
+	ready    chan struct{}
+}
+
+// defaultGroupLimit applies to groups without an explicit limit so that jobs
+// sharing a group never overlap
+const defaultGroupLimit = 1
+
+func newLimiter(maxRunning int, groupLimits map[string]int) *limiter {
+	return &limiter{
+		maxRunning:  maxRunning,
+		groupLimits: groupLimits,
+		groups:      make(map[string]int),
+	}
+}
+
+func (l *limiter) groupLimit(group string) int {
+	if limit, ok := l.groupLimits[group]; ok {
+		return limit
+	}
+	return defaultGroupLimit
+}
+
+func (l *limiter) available(group string) bool {
+	if l.maxRunning > 0 && l.running >= l.maxRunning {
+		return false
+	}
+	if group != "" && l.groups[group] >= l.groupLimit(group) {
+		return false
+	}
+	return true
+}
+
+func (l *limiter) take(group string) {
+	l.running++
+	if group != "" {
+		l.groups[group]++
+	}
+}
+
+// TryAcquire takes a slot for the group if one is free right now. Queued
+// waiters are never eligible between releases, so a caller that fits does not
+// jump ahead of anyone.
+func (l *limiter) TryAcquire(group string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.available(group) {
+		return false
+	}
+	l.take(group)
+	return true
+}
+
+// Acquire blocks until a slot is available for the group or the context is
+// cancelled. Higher priority waiters are served first.
+func (l *limiter) Acquire(ctx context.Context, group string, priority int) error {
+	l.mu.Lock()
+	if l.available(group) {
+		l.take(group)
+		l.mu.Unlock()
+		return nil
+	}
+
+	w := &waiter{group: group, priority: priority, ready: make(chan struct{})}
+	l.enqueue(w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		select {
+		case <-w.ready:
+			// The slot was granted concurrently, hand it back
+			l.release(group)
+		default:
+			l.remove(w)
+		}
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot held for the group and wakes eligible waiters
+func (l *limiter) Release(group string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.release(group)
+}
+
+func (l *limiter) release(group string) {
+	l.running--
+	if group != "" {
+		l.groups[group]--
+	}
+	l.grant()
+}
+
+// grant hands free slots to waiters in order, skipping waiters whose group is
+// still at its limit
+func (l *limiter) grant() {
+	remaining := l.waiters[:0]
+	for _, w := range l.waiters {
+		if l.available(w.group) {
+			l.take(w.group)
+			close(w.ready)
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	l.waiters = remaining
+}
+
+// enqueue inserts the waiter behind all waiters of equal or higher priority
+func (l *limiter) enqueue(w *waiter) {
+	i := sort.Search(len(l.waiters), func(i int) bool {
+		return l.waiters[i].priority < w.priority
+	})
+	l.waiters = append(l.waiters, nil)
+	copy(l.waiters[i+1:], l.waiters[i:])
+	l.waiters[i] = w
+}
+
+func (l *limiter) remove(target *waiter) {
+	for i, w := range l.waiters {
+		if w == target {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// state returns the slots taken and the waiters in the order they are served
+func (l *limiter) state() QueueState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := QueueState{Running: l.running, Waiting: make([]WaitingRunState, len(l.waiters))}
+	for group, running := range l.groups {
+		if running > 0 {
+			if state.Groups == nil {
+				state.Groups = make(map[string]int)
+			}
+			state.Groups[group] = running
+		}
+	}
+	for i, w := range l.waiters {
+		state.Waiting[i] = WaitingRunState{Group: w.group, Priority: w.priority}
+	}
+	return state
+}
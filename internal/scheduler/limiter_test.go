@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func acquireAsync(l *limiter, group string) chan error {
+	return acquireWithPriority(l, group, 0)
+}
+
+func acquireWithPriority(l *limiter, group string, priority int) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Acquire(context.Background(), group, priority)
+	}()
+	return done
+}
+
+func assertBlocked(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Fatal("acquire should be blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func assertAcquired(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("acquire should have succeeded")
+	}
+}
+
+func TestLimiterGroupLimit(t *testing.T) {
+	l := newLimiter(0, nil)
+
+	assert.True(t, l.TryAcquire("db-server-1"))
+	assert.False(t, l.TryAcquire("db-server-1"))
+
+	sameGroup := acquireAsync(l, "db-server-1")
+	assertBlocked(t, sameGroup)
+
+	otherGroup := acquireAsync(l, "db-server-2")
+	assertAcquired(t, otherGroup)
+
+	ungrouped := acquireAsync(l, "")
+	assertAcquired(t, ungrouped)
+
+	l.Release("db-server-1")
+	assertAcquired(t, sameGroup)
+}
+
+func TestLimiterConfiguredGroupLimit(t *testing.T) {
+	l := newLimiter(0, map[string]int{"uplink": 2})
+
+	assertAcquired(t, acquireAsync(l, "uplink"))
+	assertAcquired(t, acquireAsync(l, "uplink"))
+
+	third := acquireAsync(l, "uplink")
+	assertBlocked(t, third)
+
+	l.Release("uplink")
+	assertAcquired(t, third)
+}
+
+func TestLimiterGlobalLimit(t *testing.T) {
+	l := newLimiter(1, nil)
+
+	assertAcquired(t, acquireAsync(l, "a"))
+
+	second := acquireAsync(l, "b")
+	assertBlocked(t, second)
+
+	l.Release("a")
+	assertAcquired(t, second)
+}
+
+func TestLimiterCancelledWait(t *testing.T) {
+	l := newLimiter(1, nil)
+	assertAcquired(t, acquireAsync(l, ""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, l.Acquire(ctx, "", 0), context.Canceled)
+
+	l.Release("")
+	assertAcquired(t, acquireAsync(l, ""))
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := newLimiter(1, nil)
+	assertAcquired(t, acquireAsync(l, ""))
+
+	low := acquireWithPriority(l, "", 1)
+	assertBlocked(t, low)
+	high := acquireWithPriority(l, "", 10)
+	assertBlocked(t, high)
+	sameAsLow := acquireWithPriority(l, "", 1)
+	assertBlocked(t, sameAsLow)
+
+	l.Release("")
+	assertAcquired(t, high)
+	assertBlocked(t, low)
+
+	l.Release("")
+	assertAcquired(t, low)
+	assertBlocked(t, sameAsLow)
+
+	l.Release("")
+	assertAcquired(t, sameAsLow)
+}
+
+func TestLimiterPriorityDoesNotBlockOtherGroups(t *testing.T) {
+	l := newLimiter(2, nil)
+	assertAcquired(t, acquireAsync(l, "db"))
+	assertAcquired(t, acquireAsync(l, "files"))
+
+	highSameGroup := acquireWithPriority(l, "db", 10)
+	lowOtherGroup := acquireWithPriority(l, "wiki", 0)
+	assertBlocked(t, highSameGroup)
+	assertBlocked(t, lowOtherGroup)
+
+	// The freed global slot cannot go to the "db" waiter while "db" is busy
+	l.Release("files")
+	assertAcquired(t, lowOtherGroup)
+	assertBlocked(t, highSameGroup)
+
+	l.Release("wiki")
+	l.Release("db")
+	assertAcquired(t, highSameGroup)
+}
+
+func TestLimiterState(t *testing.T) {
+	l := newLimiter(2, nil)
+
+	assert.True(t, l.TryAcquire("db-server-1"))
+	assert.True(t, l.TryAcquire(""))
+	low := acquireWithPriority(l, "", 0)
+	assertBlocked(t, low)
+	high := acquireWithPriority(l, "db-server-2", 5)
+	assertBlocked(t, high)
+
+	assert.Equal(t, QueueState{
+		Running: 2,
+		Groups:  map[string]int{"db-server-1": 1},
+		Waiting: []WaitingRunState{{Group: "db-server-2", Priority: 5}, {Priority: 0}},
+	}, l.state())
+
+	l.Release("")
+	assertAcquired(t, high)
+	l.Release("db-server-1")
+	assertAcquired(t, low)
+	assert.Equal(t, QueueState{Running: 2, Groups: map[string]int{"db-server-2": 1}, Waiting: []WaitingRunState{}}, l.state())
+}
@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+const (
+	defaultLoadRetryInterval = 5 * time.Minute
+	defaultLoadMaxRetries    = 6
+
+	// loadCheckTimeout bounds the load query, a source too busy to answer
+	// in time is not deferred on
+	loadCheckTimeout = 30 * time.Second
+)
+
+// LoadChecker is implemented by executors of database jobs, which report how
+// busy their source is before a scheduled run
+type LoadChecker interface {
+	CheckLoad(ctx context.Context) (activeConnections int, replicationLag time.Duration, err error)
+}
+
+// deferForLoad checks the load of a job's source and postpones the run by
+// the retry interval while it is busy. It returns true when the run was
+// deferred.
+func (js *JobScheduler) deferForLoad(jobConfig config.JobConfig, executor BackupExecutor) bool {
+	check := jobConfig.LoadCheck
+	checker, ok := executor.(LoadChecker)
+	if check == nil || !ok {
+		return false
+	}
+	jobName := jobConfig.Name
+
+	ctx, cancel := context.WithTimeout(js.stopCtx, loadCheckTimeout)
+	connections, lag, err := checker.CheckLoad(ctx)
+	cancel()
+
+	js.shiftedMu.Lock()
+	defer js.shiftedMu.Unlock()
+
+	if err != nil {
+		log.Printf("Warning: failed to check the load of backup job %s, running it anyway: %v", jobName, err)
+		delete(js.deferrals, jobName)
+		return false
+	}
+	reasons := busyReasons(check, connections, lag)
+	if len(reasons) == 0 {
+		delete(js.deferrals, jobName)
+		return false
+	}
+
+	maxRetries := check.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultLoadMaxRetries
+	}
+	if js.deferrals[jobName] >= maxRetries {
+		log.Printf("Running backup job %s although its source is busy (%s): deferred %d times already",
+			jobName, strings.Join(reasons, ", "), js.deferrals[jobName])
+		delete(js.deferrals, jobName)
+		return false
+	}
+
+	interval := check.RetryInterval
+	if interval == 0 {
+		interval = defaultLoadRetryInterval
+	}
+	js.deferrals[jobName]++
+	log.Printf("Deferring backup job %s by %s, its source is busy (%s): attempt %d of %d",
+		jobName, interval, strings.Join(reasons, ", "), js.deferrals[jobName], maxRetries)
+
+	if timer, ok := js.shifted[jobName]; ok {
+		timer.Stop()
+	}
+	js.shifted[jobName] = time.AfterFunc(interval, func() {
+		js.trigger(jobConfig, executor)
+	})
+	return true
+}
+
+// busyReasons lists the load thresholds a source exceeds
+func busyReasons(check *config.LoadCheckConfig, connections int, lag time.Duration) []string {
+	var reasons []string
+	if check.MaxActiveConnections > 0 && connections > check.MaxActiveConnections {
+		reasons = append(reasons, fmt.Sprintf("%d active connections, limit %d", connections, check.MaxActiveConnections))
+	}
+	if check.MaxReplicationLag > 0 && lag > check.MaxReplicationLag {
+		reasons = append(reasons, fmt.Sprintf("replication lag %s, limit %s", lag, check.MaxReplicationLag))
+	}
+	return reasons
+}
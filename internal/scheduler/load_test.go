@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// busyExecutor reports a fixed load for its source
+type busyExecutor struct {
+	fileExecutor
+	connections int
+	lag         time.Duration
+}
+
+func (e busyExecutor) CheckLoad(ctx context.Context) (int, time.Duration, error) {
+	return e.connections, e.lag, nil
+}
+
+func TestDeferForLoad(t *testing.T) {
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{})
+	defer js.Stop()
+
+	job := config.JobConfig{Name: "orders", Type: "postgres", LoadCheck: &config.LoadCheckConfig{
+		MaxActiveConnections: 20,
+		MaxReplicationLag:    30 * time.Second,
+		RetryInterval:        time.Hour,
+		MaxRetries:           2,
+	}}
+
+	assert.False(t, js.deferForLoad(job, busyExecutor{connections: 5, lag: time.Second}), "quiet source")
+	assert.False(t, js.deferForLoad(job, fileExecutor{}), "executor without a load check")
+
+	busy := busyExecutor{connections: 50, lag: time.Minute}
+	assert.True(t, js.deferForLoad(job, busy))
+	assert.Contains(t, js.shifted, "orders", "the deferred run is pending")
+	assert.True(t, js.deferForLoad(job, busy))
+	assert.Equal(t, 2, js.deferrals["orders"])
+	assert.False(t, js.deferForLoad(job, busy), "runs anyway after max_retries deferrals")
+	assert.NotContains(t, js.deferrals, "orders")
+}
+
+func TestBusyReasons(t *testing.T) {
+	check := &config.LoadCheckConfig{MaxActiveConnections: 20, MaxReplicationLag: 30 * time.Second}
+
+	assert.Empty(t, busyReasons(check, 20, 30*time.Second))
+	assert.Equal(t, []string{"21 active connections, limit 20"}, busyReasons(check, 21, 0))
+	assert.Equal(t, []string{"replication lag 1m0s, limit 30s"}, busyReasons(check, 0, time.Minute))
+	assert.Empty(t, busyReasons(&config.LoadCheckConfig{MaxReplicationLag: time.Second}, 500, 0), "no connection limit")
+}
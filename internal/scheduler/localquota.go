@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/retention"
+)
+
+// checkLocalSpace makes sure that the job's next backup fits in the local
+// directory's max_size, expecting it to be as large as the job's last
+// successful one. With early_retention the job's retention policy is applied
+// first, as if the new backup were already there. A backup that still does
+// not fit is refused with on_max_size refuse and logged otherwise.
+func (js *JobScheduler) checkLocalSpace(ctx context.Context, jobConfig config.JobConfig) error {
+	if js.localLimit.MaxSize == "" {
+		return nil
+	}
+	maxSize, err := config.ParseSize(js.localLimit.MaxSize)
+	if err != nil {
+		return &failure.ConfigError{Err: fmt.Errorf("invalid local storage max_size: %w", err)}
+	}
+	expected := js.expectedSize(jobConfig.Name)
+
+	js.quotaMu.Lock()
+	defer js.quotaMu.Unlock()
+
+	used := directorySize(js.localDir)
+	if used+expected <= maxSize {
+		return nil
+	}
+	if mgr := js.localRetentionManager(); js.localLimit.EarlyRetention && mgr != nil {
+		log.Printf("[Job: %s] Local directory holds %d of %d bytes, applying the retention policy before the run",
+			jobConfig.Name, used, maxSize)
+		if err := mgr.ApplyRetentionPolicy(ctx, earlyRetention(jobConfig)); err != nil {
+			log.Printf("Error applying retention policy early for job %s: %v", jobConfig.Name, err)
+		}
+		used = directorySize(js.localDir)
+		if used+expected <= maxSize {
+			return nil
+		}
+	}
+
+	err = fmt.Errorf("local directory holds %d of its max_size of %d bytes, a backup of about %d bytes does not fit",
+		used, maxSize, expected)
+	if js.localLimit.OnMaxSize == "refuse" {
+		return &failure.StorageFullError{Err: err}
+	}
+	log.Printf("Warning: job %s: %v", jobConfig.Name, err)
+	return nil
+}
+
+// expectedSize returns the size of the job's last successful backup, or zero
+// without a run history
+func (js *JobScheduler) expectedSize(jobName string) int64 {
+	if js.history == nil {
+		return 0
+	}
+	run, ok, err := js.history.LastSuccess(jobName)
+	if err != nil {
+		log.Printf("Warning: failed to read run history of job %s: %v", jobName, err)
+	}
+	if !ok {
+		return 0
+	}
+	return run.Size
+}
+
+// localRetentionManager returns the retention manager of the backups kept in
+// the local directory, nil when it only stages uploads, which must not be
+// removed before they are sent
+func (js *JobScheduler) localRetentionManager() *retention.Manager {
+	if js.remote == nil {
+		return js.retentionMgr
+	}
+	return js.localRetention
+}
+
+// earlyRetention returns the job with its retention policy as it applies once
+// the next backup is written: a count policy keeps one backup fewer, but
+// never none
+func earlyRetention(jobConfig config.JobConfig) config.JobConfig {
+	if policy := &jobConfig.RetentionPolicy; policy.Type == "count" && policy.Value > 1 {
+		policy.Value--
+	}
+	return jobConfig
+}
+
+// directorySize returns the total size of the files below a directory,
+// skipping those that cannot be read
+func directorySize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
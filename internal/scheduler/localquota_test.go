@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// newLocalLimitScheduler returns a scheduler whose job orders has two backups
+// of 4 bytes and whose last run wrote 4 bytes
+func newLocalLimitScheduler(t *testing.T, local config.LocalConfig) (*JobScheduler, string) {
+	t.Helper()
+	local.Directory = t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: local}, config.SchedulerConfig{})
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	require.NoError(t, err)
+	require.NoError(t, store.Append(history.Run{Job: "orders", Status: history.StatusSuccess, Size: 4}))
+	js.SetHistory(store)
+
+	jobDir := filepath.Join(local.Directory, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	for i, name := range []string{"backup_20250101-000000", "backup_20250102-000000"} {
+		path := filepath.Join(jobDir, name)
+		require.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+		at := time.Now().Add(time.Duration(i-2) * time.Hour)
+		require.NoError(t, os.Chtimes(path, at, at))
+	}
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 2}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: jobDir}))
+	return js, jobDir
+}
+
+func TestLocalMaxSize_Warn(t *testing.T) {
+	js, _ := newLocalLimitScheduler(t, config.LocalConfig{MaxSize: "10B"})
+
+	run, err := js.RunNow("orders")
+	require.NoError(t, err, "the backup is only logged as exceeding max_size")
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Equal(t, int64(4), run.Size)
+}
+
+func TestLocalMaxSize_Refuse(t *testing.T) {
+	js, jobDir := newLocalLimitScheduler(t, config.LocalConfig{MaxSize: "10B", OnMaxSize: "refuse"})
+
+	run, err := js.RunNow("orders")
+	assert.EqualError(t, err, "local directory holds 8 of its max_size of 10 bytes, a backup of about 4 bytes does not fit")
+	assert.Equal(t, history.StatusFailed, run.Status)
+	assert.Equal(t, "storage_full", run.ErrorCode)
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "nothing is written")
+}
+
+func TestLocalMaxSize_EarlyRetention(t *testing.T) {
+	js, jobDir := newLocalLimitScheduler(t, config.LocalConfig{MaxSize: "10B", OnMaxSize: "refuse", EarlyRetention: true})
+
+	run, err := js.RunNow("orders")
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "backup_20250102-000000", entries[0].Name(), "the oldest backup made room")
+}
@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// MaintenanceState describes a global pause of all scheduled jobs
+type MaintenanceState struct {
+	Active bool      `json:"active"`
+	Since  time.Time `json:"since,omitempty"`
+	Until  time.Time `json:"until,omitempty"` // Zero means until lifted manually
+	Reason string    `json:"reason,omitempty"`
+}
+
+type maintenance struct {
+	mu    sync.Mutex
+	state MaintenanceState
+}
+
+// current returns the maintenance state, lifting it once its TTL has expired
+func (m *maintenance) current(now time.Time) MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state.Active && !m.state.Until.IsZero() && !now.Before(m.state.Until) {
+		m.state = MaintenanceState{}
+	}
+	return m.state
+}
+
+func (m *maintenance) set(state MaintenanceState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = state
+}
+
+// EnterMaintenance suspends all scheduled runs. A positive ttl lifts the
+// maintenance mode automatically once it expires. Runs that are already in
+// progress are not interrupted.
+func (js *JobScheduler) EnterMaintenance(ttl time.Duration, reason string) MaintenanceState {
+	now := js.clock.Now()
+	state := MaintenanceState{Active: true, Since: now, Reason: reason}
+	if ttl > 0 {
+		state.Until = now.Add(ttl)
+	}
+	js.maintenance.set(state)
+
+	for _, callback := range js.callbacks {
+		callback("scheduler", StatusPaused, now)
+	}
+
+	return state
+}
+
+// ExitMaintenance resumes scheduled runs
+func (js *JobScheduler) ExitMaintenance() {
+	js.maintenance.set(MaintenanceState{})
+
+	for _, callback := range js.callbacks {
+		callback("scheduler", StatusRunning, js.clock.Now())
+	}
+}
+
+// Maintenance returns the current maintenance state
+func (js *JobScheduler) Maintenance() MaintenanceState {
+	return js.maintenance.current(js.clock.Now())
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceExpires(t *testing.T) {
+	now := time.Now()
+	m := &maintenance{}
+
+	m.set(MaintenanceState{Active: true, Since: now, Until: now.Add(time.Hour), Reason: "storage migration"})
+
+	state := m.current(now.Add(30 * time.Minute))
+	assert.True(t, state.Active)
+	assert.Equal(t, "storage migration", state.Reason)
+
+	state = m.current(now.Add(time.Hour))
+	assert.False(t, state.Active)
+
+	// Once expired the state stays lifted
+	assert.False(t, m.current(now).Active)
+}
+
+func TestMaintenanceWithoutTTL(t *testing.T) {
+	now := time.Now()
+	m := &maintenance{}
+
+	m.set(MaintenanceState{Active: true, Since: now})
+	assert.True(t, m.current(now.Add(24*365*time.Hour)).Active)
+
+	m.set(MaintenanceState{})
+	assert.False(t, m.current(now).Active)
+}
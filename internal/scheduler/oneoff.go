@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrOneOffRunNotFound is returned when cancelling a one-off run that is not
+// pending
+var ErrOneOffRunNotFound = errors.New("one-off run not found")
+
+// ErrOneOffRunPassed is returned when planning a one-off run at a time that
+// has passed
+var ErrOneOffRunPassed = errors.New("one-off run must be in the future")
+
+// oneOffIDAttempts is how many random IDs are drawn before giving up on
+// finding one that no pending one-off run uses
+const oneOffIDAttempts = 5
+
+// oneOffIDSource provides the random bytes of one-off run IDs
+var oneOffIDSource io.Reader = rand.Reader
+
+// OneOffRun is a single run of a job planned for a given time, on top of the
+// job's own schedule. It is dropped once it starts or is cancelled.
+type OneOffRun struct {
+	ID  string    `json:"id"`
+	Job string    `json:"job"`
+	At  time.Time `json:"at"`
+}
+
+// pendingOneOffRun is a planned run waiting for its timer
+type pendingOneOffRun struct {
+	OneOffRun
+	timer *time.Timer
+}
+
+// ScheduleOnce plans a single run of a scheduled job at the given time. Like
+// a manual run it is made regardless of maintenance mode and excluded dates,
+// and it uses the job's configuration at that time, so a reload in between
+// applies to it. A job that is no longer scheduled by then is not run.
+// One-off runs are kept in memory and do not survive a restart.
+func (js *JobScheduler) ScheduleOnce(jobName string, at time.Time) (OneOffRun, error) {
+	if !js.HasJob(jobName) {
+		return OneOffRun{}, ErrJobNotFound
+	}
+	now := js.clock.Now()
+	if !at.After(now) {
+		return OneOffRun{}, fmt.Errorf("%w, %s has passed", ErrOneOffRunPassed, at.Format(time.RFC3339))
+	}
+
+	js.oneOffMu.Lock()
+	defer js.oneOffMu.Unlock()
+
+	id, err := js.newOneOffID()
+	if err != nil {
+		return OneOffRun{}, fmt.Errorf("failed to plan a one-off run of job %s: %w", jobName, err)
+	}
+	run := OneOffRun{ID: id, Job: jobName, At: at}
+
+	js.oneOff[run.ID] = &pendingOneOffRun{
+		OneOffRun: run,
+		timer: time.AfterFunc(at.Sub(now), func() {
+			js.runOneOff(run)
+		}),
+	}
+	log.Printf("One-off run %s of backup job %s scheduled for %s", run.ID, jobName, at.Format(time.RFC3339))
+	return run, nil
+}
+
+// newOneOffID draws a random ID that no pending one-off run uses. The caller
+// holds oneOffMu.
+func (js *JobScheduler) newOneOffID() (string, error) {
+	random := make([]byte, 4)
+	for range oneOffIDAttempts {
+		if _, err := io.ReadFull(oneOffIDSource, random); err != nil {
+			return "", fmt.Errorf("failed to generate a run ID: %w", err)
+		}
+		id := hex.EncodeToString(random)
+		if _, taken := js.oneOff[id]; !taken {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no free run ID after %d attempts", oneOffIDAttempts)
+}
+
+// OneOffRuns returns the pending one-off runs, the earliest first
+func (js *JobScheduler) OneOffRuns() []OneOffRun {
+	js.oneOffMu.Lock()
+	runs := make([]OneOffRun, 0, len(js.oneOff))
+	for _, pending := range js.oneOff {
+		runs = append(runs, pending.OneOffRun)
+	}
+	js.oneOffMu.Unlock()
+
+	slices.SortFunc(runs, func(a, b OneOffRun) int {
+		if c := a.At.Compare(b.At); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return runs
+}
+
+// CancelOneOffRun drops a pending one-off run
+func (js *JobScheduler) CancelOneOffRun(id string) error {
+	js.oneOffMu.Lock()
+	defer js.oneOffMu.Unlock()
+
+	pending, ok := js.oneOff[id]
+	if !ok {
+		return ErrOneOffRunNotFound
+	}
+	pending.timer.Stop()
+	delete(js.oneOff, id)
+	log.Printf("One-off run %s of backup job %s cancelled", id, pending.Job)
+	return nil
+}
+
+// runOneOff makes a one-off run once its time has come
+func (js *JobScheduler) runOneOff(run OneOffRun) {
+	js.oneOffMu.Lock()
+	_, pending := js.oneOff[run.ID]
+	delete(js.oneOff, run.ID)
+	js.oneOffMu.Unlock()
+	if !pending {
+		return
+	}
+
+	js.jobsMu.RLock()
+	executor, ok := js.jobs[run.Job]
+	jobConfig := js.jobConfigs[run.Job]
+	js.jobsMu.RUnlock()
+	if !ok {
+		log.Printf("Skipping one-off run %s: backup job %s is no longer scheduled", run.ID, run.Job)
+		return
+	}
+
+	log.Printf("Starting one-off run %s of backup job %s", run.ID, run.Job)
+	js.runJob(jobConfig, executor, false)
+}
+
+// stopOneOffRuns drops every pending one-off run
+func (js *JobScheduler) stopOneOffRuns() {
+	js.oneOffMu.Lock()
+	defer js.oneOffMu.Unlock()
+
+	for id, pending := range js.oneOff {
+		pending.timer.Stop()
+		delete(js.oneOff, id)
+	}
+}
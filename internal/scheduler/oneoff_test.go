@@ -0,0 +1,110 @@
+package scheduler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+func TestScheduleOnce(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	defer js.Stop()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "orders"), 0755))
+
+	_, err := js.ScheduleOnce("orders", time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+
+	_, err = js.ScheduleOnce("orders", time.Now().Add(-time.Minute))
+	assert.ErrorContains(t, err, "must be in the future")
+
+	finished := make(chan history.Run, 1)
+	js.RegisterRunCallback(func(run history.Run) {
+		finished <- run
+	})
+
+	later, err := js.ScheduleOnce("orders", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	soon, err := js.ScheduleOnce("orders", time.Now().Add(50*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, []OneOffRun{soon, later}, js.OneOffRuns(), "the earliest run comes first")
+
+	js.EnterMaintenance(0, "")
+	select {
+	case run := <-finished:
+		assert.Equal(t, history.StatusSuccess, run.Status, "one-off runs are made during maintenance mode")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the one-off run was not made")
+	}
+	assert.Equal(t, []OneOffRun{later}, js.OneOffRuns(), "a one-off run disappears once made")
+
+	require.NoError(t, js.CancelOneOffRun(later.ID))
+	assert.Empty(t, js.OneOffRuns())
+	assert.ErrorIs(t, js.CancelOneOffRun(later.ID), ErrOneOffRunNotFound)
+}
+
+func TestScheduleOnce_JobRemoved(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	defer js.Stop()
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+
+	var runs int
+	js.RegisterRunCallback(func(run history.Run) {
+		runs++
+	})
+
+	_, err := js.ScheduleOnce("orders", time.Now().Add(50*time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, js.RemoveJob("orders"))
+
+	assert.Eventually(t, func() bool { return len(js.OneOffRuns()) == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, runs, "a job that is no longer scheduled is not run")
+}
+
+func TestScheduleOnce_IDs(t *testing.T) {
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{})
+	defer js.Stop()
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, fileExecutor{}))
+
+	defer func(source io.Reader) { oneOffIDSource = source }(oneOffIDSource)
+	at := time.Now().Add(time.Hour)
+
+	// The second run draws the ID of the first one before a free one
+	oneOffIDSource = bytes.NewReader([]byte{1, 2, 3, 4, 1, 2, 3, 4, 5, 6, 7, 8})
+	first, err := js.ScheduleOnce("orders", at)
+	require.NoError(t, err)
+	assert.Equal(t, "01020304", first.ID)
+	second, err := js.ScheduleOnce("orders", at)
+	require.NoError(t, err)
+	assert.Equal(t, "05060708", second.ID)
+	assert.Len(t, js.OneOffRuns(), 2, "the first run is still pending")
+
+	oneOffIDSource = bytes.NewReader(bytes.Repeat([]byte{1, 2, 3, 4}, oneOffIDAttempts))
+	_, err = js.ScheduleOnce("orders", at)
+	assert.ErrorContains(t, err, "no free run ID")
+
+	oneOffIDSource = iotest.ErrReader(errors.New("entropy exhausted"))
+	_, err = js.ScheduleOnce("orders", at)
+	assert.ErrorContains(t, err, "entropy exhausted")
+	assert.Len(t, js.OneOffRuns(), 2)
+}
@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// PauseJob skips the scheduled runs of a job until the given time, in place
+// of the job's pause_until. Manual and one-off runs are still made. The pause
+// is dropped when the job is removed, and so when a reload changes it.
+func (js *JobScheduler) PauseJob(jobName string, until time.Time) error {
+	if !js.HasJob(jobName) {
+		return ErrJobNotFound
+	}
+	if !until.After(js.clock.Now()) {
+		return fmt.Errorf("pause of job %s must end in the future, %s has passed", jobName, until.Format(time.RFC3339))
+	}
+
+	js.pausesMu.Lock()
+	js.pauses[jobName] = until
+	js.pausesMu.Unlock()
+
+	log.Printf("Backup job %s paused until %s", jobName, until.Format(time.RFC3339))
+	return nil
+}
+
+// ResumeJob lifts the pause of a job, including the one set with its
+// pause_until, until the job is removed or changed by a reload
+func (js *JobScheduler) ResumeJob(jobName string) error {
+	if !js.HasJob(jobName) {
+		return ErrJobNotFound
+	}
+
+	js.pausesMu.Lock()
+	js.pauses[jobName] = time.Time{}
+	js.pausesMu.Unlock()
+
+	log.Printf("Backup job %s resumed", jobName)
+	return nil
+}
+
+// PausedUntil returns when the pause of a scheduled job ends, or false when
+// it is not paused
+func (js *JobScheduler) PausedUntil(jobName string) (time.Time, bool) {
+	jobConfig, ok := js.JobConfig(jobName)
+	if !ok {
+		return time.Time{}, false
+	}
+	return js.pausedUntil(jobConfig)
+}
+
+// pausedUntil returns the end of a job's pause, set through PauseJob or with
+// its pause_until, while it lasts
+func (js *JobScheduler) pausedUntil(jobConfig config.JobConfig) (time.Time, bool) {
+	js.pausesMu.Lock()
+	until, ok := js.pauses[jobConfig.Name]
+	js.pausesMu.Unlock()
+	if !ok {
+		until = jobConfig.PausedUntil()
+	}
+	return until, until.After(js.clock.Now())
+}
+
+// dropPause forgets the pause set through PauseJob or ResumeJob
+func (js *JobScheduler) dropPause(jobName string) {
+	js.pausesMu.Lock()
+	delete(js.pauses, jobName)
+	js.pausesMu.Unlock()
+}
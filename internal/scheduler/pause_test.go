@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+func TestPauseUntil(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	clock := backuptest.NewClock(time.Date(2026, 3, 1, 3, 0, 0, 0, time.Local))
+	js.SetClock(clock)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "orders"), 0755))
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *", PauseUntil: "2026-03-03",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	executor := fileExecutor{dir: filepath.Join(dir, "orders")}
+	require.NoError(t, js.AddJob(job, executor))
+
+	var runs int
+	js.RegisterRunCallback(func(run history.Run) {
+		runs++
+	})
+
+	until, paused := js.PausedUntil("orders")
+	assert.True(t, paused)
+	assert.Equal(t, time.Date(2026, 3, 3, 0, 0, 0, 0, time.Local), until)
+	js.trigger(job, executor)
+	assert.Zero(t, runs, "scheduled runs are skipped while the job is paused")
+
+	_, err := js.RunNow("orders")
+	require.NoError(t, err)
+	assert.Equal(t, 1, runs, "manual runs are made")
+
+	clock.Advance(48 * time.Hour)
+	_, paused = js.PausedUntil("orders")
+	assert.False(t, paused, "the job resumes once pause_until has passed")
+	js.trigger(job, executor)
+	assert.Equal(t, 2, runs)
+}
+
+func TestPauseJob(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	clock := backuptest.NewClock(time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC))
+	js.SetClock(clock)
+
+	assert.ErrorIs(t, js.PauseJob("orders", clock.Now().Add(time.Hour)), ErrJobNotFound)
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *", PauseUntil: "2099-01-01",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+
+	assert.ErrorContains(t, js.PauseJob("orders", clock.Now()), "must end in the future")
+
+	require.NoError(t, js.ResumeJob("orders"))
+	_, paused := js.PausedUntil("orders")
+	assert.False(t, paused, "resuming lifts the pause from the configuration")
+
+	require.NoError(t, js.PauseJob("orders", clock.Now().Add(time.Hour)))
+	until, paused := js.PausedUntil("orders")
+	assert.True(t, paused)
+	assert.Equal(t, clock.Now().Add(time.Hour), until)
+
+	require.NoError(t, js.RemoveJob("orders"))
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+	until, paused = js.PausedUntil("orders")
+	assert.True(t, paused, "a reloaded job takes its pause from the configuration again")
+	assert.Equal(t, 2099, until.Year())
+}
@@ -0,0 +1,110 @@
+package scheduler
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// queuedRun is a run waiting for a concurrency slot
+type queuedRun struct {
+	job      string
+	group    string
+	priority int
+	queuedAt time.Time
+}
+
+// Runs lists what the daemon is doing right now
+type Runs struct {
+	Running []ActiveRun `json:"running"` // Oldest first
+	Queued  []QueuedRun `json:"queued"`  // In the order slots are granted
+}
+
+// ActiveRun is a run in progress
+type ActiveRun struct {
+	Job                 string    `json:"job"`
+	RunID               string    `json:"runId"`
+	StartedAt           time.Time `json:"startedAt"`
+	EstimatedCompletion time.Time `json:"estimatedCompletion,omitzero"` // Zero until the job has enough history
+	Overdue             bool      `json:"overdue"`
+}
+
+// QueuedRun is a run waiting for a concurrency slot. It gets its run ID once
+// it starts.
+type QueuedRun struct {
+	Job      string    `json:"job"`
+	Group    string    `json:"group,omitempty"`
+	Priority int       `json:"priority"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// Runs returns the runs in progress and those queued behind the concurrency
+// limits
+func (js *JobScheduler) Runs() Runs {
+	runs := Runs{Running: []ActiveRun{}, Queued: []QueuedRun{}}
+
+	js.runningMu.Lock()
+	jobNames := make([]string, 0, len(js.running))
+	for jobName := range js.running {
+		jobNames = append(jobNames, jobName)
+	}
+	for _, run := range js.queued {
+		runs.Queued = append(runs.Queued, QueuedRun{
+			Job:      run.job,
+			Group:    run.group,
+			Priority: run.priority,
+			QueuedAt: run.queuedAt,
+		})
+	}
+	js.runningMu.Unlock()
+
+	for _, jobName := range jobNames {
+		estimate, ok := js.RunEstimate(jobName)
+		if !ok {
+			continue
+		}
+		runs.Running = append(runs.Running, ActiveRun{
+			Job:                 jobName,
+			RunID:               estimate.RunID,
+			StartedAt:           estimate.StartedAt,
+			EstimatedCompletion: estimate.EstimatedCompletion,
+			Overdue:             estimate.Overdue,
+		})
+	}
+	slices.SortFunc(runs.Running, func(a, b ActiveRun) int {
+		if c := a.StartedAt.Compare(b.StartedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Job, b.Job)
+	})
+	// The limiter serves higher priorities first and arrival order within one
+	slices.SortStableFunc(runs.Queued, func(a, b QueuedRun) int {
+		return b.Priority - a.Priority
+	})
+	return runs
+}
+
+// enqueueRun tracks a run waiting for its slot until the returned function
+// is called
+func (js *JobScheduler) enqueueRun(jobConfig config.JobConfig) func() {
+	run := &queuedRun{
+		job:      jobConfig.Name,
+		group:    jobConfig.ConcurrencyGroup,
+		priority: jobConfig.Priority,
+		queuedAt: js.clock.Now(),
+	}
+
+	js.runningMu.Lock()
+	js.queued = append(js.queued, run)
+	js.runningMu.Unlock()
+
+	return func() {
+		js.runningMu.Lock()
+		defer js.runningMu.Unlock()
+		if i := slices.Index(js.queued, run); i >= 0 {
+			js.queued = slices.Delete(js.queued, i, i+1)
+		}
+	}
+}
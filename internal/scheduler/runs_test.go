@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// blockingExecutor runs until released
+type blockingExecutor struct {
+	started chan<- struct{}
+	release <-chan struct{}
+}
+
+func (e blockingExecutor) Execute(ctx context.Context) error {
+	e.started <- struct{}{}
+	<-e.release
+	return nil
+}
+
+func TestRuns(t *testing.T) {
+	js := NewJobScheduler(
+		config.StorageConfig{Local: config.LocalConfig{Directory: t.TempDir()}},
+		config.SchedulerConfig{MaxConcurrentJobs: 1},
+	)
+	defer js.Stop()
+
+	assert.Equal(t, Runs{Running: []ActiveRun{}, Queued: []QueuedRun{}}, js.Runs())
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	executor := blockingExecutor{started: started, release: release}
+	for _, job := range []config.JobConfig{
+		{Name: "orders"},
+		{Name: "users", ConcurrencyGroup: "db-server-1"},
+		{Name: "events", Priority: 5},
+	} {
+		job.Schedule = "0 2 * * *"
+		job.RetentionPolicy = config.RetentionPolicy{Type: "count", Value: 1}
+		require.NoError(t, js.AddJob(job, executor))
+	}
+
+	done := make(chan struct{})
+	run := func(jobName string) {
+		js.RunNow(jobName)
+		done <- struct{}{}
+	}
+	go run("orders")
+	<-started
+	go run("users")
+	require.Eventually(t, func() bool { return len(js.Runs().Queued) == 1 }, time.Second, 10*time.Millisecond)
+	go run("events")
+	require.Eventually(t, func() bool { return len(js.Runs().Queued) == 2 }, time.Second, 10*time.Millisecond)
+
+	runs := js.Runs()
+	require.Len(t, runs.Running, 1)
+	assert.Equal(t, "orders", runs.Running[0].Job)
+	assert.NotEmpty(t, runs.Running[0].RunID)
+	assert.False(t, runs.Running[0].StartedAt.IsZero())
+	// Higher priorities are granted a slot first
+	require.Len(t, runs.Queued, 2)
+	assert.Equal(t, "events", runs.Queued[0].Job)
+	assert.Equal(t, 5, runs.Queued[0].Priority)
+	assert.Equal(t, "users", runs.Queued[1].Job)
+	assert.Equal(t, "db-server-1", runs.Queued[1].Group)
+	assert.False(t, runs.Queued[1].QueuedAt.IsZero())
+
+	close(release)
+	for range 3 {
+		<-done
+	}
+	assert.Equal(t, Runs{Running: []ActiveRun{}, Queued: []QueuedRun{}}, js.Runs())
+}
+
+func TestRunJob_RefusesOverlappingRuns(t *testing.T) {
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{})
+	defer js.Stop()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	job := config.JobConfig{Name: "orders", Schedule: "0 2 * * *", RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+	require.NoError(t, js.AddJob(job, blockingExecutor{started: started, release: release}))
+
+	done := make(chan struct{})
+	go func() {
+		js.RunNow("orders")
+		close(done)
+	}()
+	<-started
+
+	running := js.Runs().Running
+	require.Len(t, running, 1)
+
+	_, err := js.RunNow("orders")
+	assert.ErrorIs(t, err, ErrJobRunning)
+	_, err = js.ScheduleOnce("orders", time.Now().Add(10*time.Millisecond))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(js.OneOffRuns()) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, running, js.Runs().Running, "the first run is still tracked")
+
+	close(release)
+	<-done
+	assert.Empty(t, js.Runs().Running)
+}
@@ -2,105 +2,1011 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-co-op/gocron"
+	"github.com/thitiph0n/backmeup/internal/clock"
 	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/redact"
 	"github.com/thitiph0n/backmeup/internal/retention"
+	"github.com/thitiph0n/backmeup/internal/runid"
+	"github.com/thitiph0n/backmeup/internal/storage"
 	"github.com/thitiph0n/backmeup/internal/storage/localfs"
 )
 
+// ErrJobNotFound is returned for operations on a job that is not scheduled
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobExists is returned when adding a job with the name of a scheduled one
+var ErrJobExists = errors.New("a job with the same name is already scheduled")
+
+// ErrJobRunning is returned when starting a job that is already running
+var ErrJobRunning = errors.New("job is already running")
+
+// RunTimeout is the longest a run may take before it is cancelled
+const RunTimeout = 12 * time.Hour
+
 type BackupExecutor interface {
 	Execute(ctx context.Context) error
 }
 
+// ModeExecutor is implemented by the executors of database jobs whose
+// schedules dump with different modes. Runs pass the dump mode of the
+// schedule that started them.
+type ModeExecutor interface {
+	ExecuteMode(ctx context.Context, mode string) error
+}
+
+// SourceDescriber is implemented by executors of database jobs, which tell
+// the server and schema version recorded in the manifests of their backups.
+// The source is nil for executors that do not back up a database.
+type SourceDescriber interface {
+	DescribeSource(ctx context.Context) (*manifest.Source, error)
+}
+
+// Coordinator decides which of several instances sharing the same jobs runs a
+// scheduled run
+type Coordinator interface {
+	ShouldRun(jobName string, scheduledAt time.Time) bool
+}
+
+// RemoteStorage receives the backups staged in the local directory after
+// each successful run
+type RemoteStorage interface {
+	storage.Storage
+	Upload(ctx context.Context, jobName, localDir string) (storage.UploadResult, error)
+}
+
+// ConfiguredStorage is implemented by remote storage with per-job upload
+// settings, such as storage classes, lifecycle transitions and object tags
+type ConfiguredStorage interface {
+	ConfigureJob(ctx context.Context, jobConfig config.JobConfig) error
+}
+
 type JobScheduler struct {
-	scheduler    *gocron.Scheduler
-	jobs         map[string]BackupExecutor
-	jobConfigs   map[string]config.JobConfig
-	retentionMgr *retention.Manager
-	callbacks    []JobStatusCallback
+	scheduler      *gocron.Scheduler
+	jobsMu         sync.RWMutex
+	jobs           map[string]BackupExecutor
+	jobConfigs     map[string]config.JobConfig
+	retentionMgr   *retention.Manager
+	store          storage.Storage
+	localDir       string
+	localLimit     config.LocalConfig // max_size of the local directory and what to do when a backup would exceed it
+	remote         RemoteStorage
+	keepLocal      bool
+	localRetention *retention.Manager
+	cache          *config.CacheConfig
+	cacheRetention *retention.Manager
+	uploadWindow   *dailyWindow
+	destinations   map[string]*destination
+	pendingUploads *pendingUploads
+	quota          config.QuotaConfig
+	quotaMu        sync.Mutex
+	limiter        *limiter
+	budgets        map[string]*groupBudget // Run time budgets by concurrency group
+	cleanups       *cleanups
+	maintenance    maintenance
+	exclusions     *exclusionCalendar
+	coordinator    Coordinator
+	history        *history.Store
+	redactor       *redact.Redactor
+	manifests      *manifest.Store
+	runningMu      sync.Mutex
+	running        map[string]*activeRun
+	inFlight       map[string]bool // Jobs inside runJob, including while they wait for a slot
+	queued         []*queuedRun    // Runs waiting for a concurrency slot, in arrival order
+	shiftedMu      sync.Mutex
+	shifted        map[string]*time.Timer // Runs shifted off excluded dates or deferred for load
+	deferrals      map[string]int         // Times the current run of a job was deferred for load
+	pausesMu       sync.Mutex
+	pauses         map[string]time.Time // Pauses set through the API, a zero time for a job resumed early
+	oneOffMu       sync.Mutex
+	oneOff         map[string]*pendingOneOffRun // Planned single runs by ID
+	callbacks      []JobStatusCallback
+	runCallbacks   []RunCallback
+	clock          clock.Clock
+	local          *localfs.Storage
+	stopCtx        context.Context // Done once Stop is called
+	stop           context.CancelFunc
 }
 
-func NewJobScheduler(storageConfig config.StorageConfig) *JobScheduler {
+func NewJobScheduler(storageConfig config.StorageConfig, schedulerConfig config.SchedulerConfig) *JobScheduler {
 	store := localfs.New(storageConfig.Local)
-	return &JobScheduler{
+	manifests := manifest.NewStore(manifest.DefaultDir(storageConfig.Local.Directory))
+	retentionMgr := retention.NewManager(store)
+	retentionMgr.SetCatalog(manifests)
+	stopCtx, stop := context.WithCancel(context.Background())
+	js := &JobScheduler{
 		scheduler:    gocron.NewScheduler(time.Local),
 		jobs:         make(map[string]BackupExecutor),
 		jobConfigs:   make(map[string]config.JobConfig),
-		retentionMgr: retention.NewManager(store),
+		retentionMgr: retentionMgr,
+		store:        store,
+		localDir:     storageConfig.Local.Directory,
+		localLimit:   storageConfig.Local,
+		manifests:    manifests,
+		quota:        storageConfig.Quota,
+		limiter:      newLimiter(schedulerConfig.MaxConcurrentJobs, schedulerConfig.ConcurrencyGroups),
+		budgets:      newGroupBudgets(schedulerConfig.GroupBudgets),
+		exclusions:   newExclusionCalendar(schedulerConfig.Exclusions),
+		running:      make(map[string]*activeRun),
+		inFlight:     make(map[string]bool),
+		shifted:      make(map[string]*time.Timer),
+		deferrals:    make(map[string]int),
+		pauses:       make(map[string]time.Time),
+		oneOff:       make(map[string]*pendingOneOffRun),
 		callbacks:    make([]JobStatusCallback, 0),
+		clock:        clock.Real,
+		local:        store,
+		stopCtx:      stopCtx,
+		stop:         stop,
 	}
+	js.cleanups = newCleanups(schedulerConfig.Cleanup, stopCtx, js.cleanUp)
+	return js
 }
 
 func (js *JobScheduler) AddJob(jobConfig config.JobConfig, executor BackupExecutor) error {
 	jobName := jobConfig.Name
 
-	job, err := js.scheduler.Cron(jobConfig.Schedule).Do(func() {
-		log.Printf("Running backup job: %s (%s)", jobName, jobConfig.Type)
+	// The name is taken before the cron entries exist, so that concurrent
+	// adds of the same job, such as by discovery and a reload, cannot both
+	// schedule it
+	js.jobsMu.Lock()
+	if _, exists := js.jobs[jobName]; exists {
+		js.jobsMu.Unlock()
+		return fmt.Errorf("failed to schedule job %s: %w", jobName, ErrJobExists)
+	}
+	js.jobs[jobName] = executor
+	js.jobConfigs[jobName] = jobConfig
+	js.jobsMu.Unlock()
+
+	entries := jobConfig.ScheduleEntries()
+	scheduled := make([]*gocron.Job, 0, len(entries))
+	for _, entry := range entries {
+		job, err := js.scheduler.Cron(entry.Cron).Do(func() {
+			js.triggerSchedule(jobConfig, entry, executor)
+		})
+		if err != nil {
+			for _, job := range scheduled {
+				js.scheduler.RemoveByReference(job)
+			}
+			js.jobsMu.Lock()
+			delete(js.jobs, jobName)
+			delete(js.jobConfigs, jobName)
+			js.jobsMu.Unlock()
+			return fmt.Errorf("failed to schedule job %s: %w", jobName, err)
+		}
+		job.Tag(jobName)
+		scheduled = append(scheduled, job)
+	}
+
+	for _, callback := range js.callbacks {
+		callback(jobName, StatusPending, js.clock.Now())
+	}
+
+	return nil
+}
+
+// SetCoordinator makes the scheduler only run jobs that the coordinator
+// assigns to this instance. It must be called before Start.
+func (js *JobScheduler) SetCoordinator(coordinator Coordinator) {
+	js.coordinator = coordinator
+}
+
+// SetRemoteStorage uploads every backup to remote storage after it is written
+// to the local staging directory. Retention and the storage quota then apply
+// to the remote copies, and to the local ones as well when keepLocal is set.
+func (js *JobScheduler) SetRemoteStorage(remote RemoteStorage, keepLocal bool) {
+	if keepLocal {
+		js.localRetention = js.retentionMgr
+	}
+	js.retentionMgr = retention.NewManager(remote)
+	js.retentionMgr.SetClock(js.clock)
+	js.retentionMgr.SetCatalog(js.manifests)
+	js.store = remote
+	js.remote = remote
+	js.keepLocal = keepLocal
+}
+
+// SetCache keeps the newest uploaded backups of each job in the local
+// directory instead of removing them after the upload. The job's local copies
+// are trimmed to cache.Keep, and all local copies to cache.MaxSize, after
+// each upload. It must be called after SetRemoteStorage.
+func (js *JobScheduler) SetCache(cache config.CacheConfig) {
+	js.cache = &cache
+	js.cacheRetention = retention.NewManager(js.local)
+	js.cacheRetention.SetClock(js.clock)
+}
+
+// SetStorageMetrics records the backups removed by retention, the storage
+// quota and the cache as deletions from the backend they are removed from,
+// named backend for the configured storage. It must be called after
+// SetRemoteStorage, SetCache and AddDestination.
+func (js *JobScheduler) SetStorageMetrics(metrics *storage.Metrics, backend string) {
+	js.retentionMgr.SetMetrics(metrics, backend)
+	for _, d := range js.destinations {
+		d.retention.SetMetrics(metrics, d.backend)
+	}
+	if js.localRetention != nil {
+		js.localRetention.SetMetrics(metrics, "local")
+	}
+	if js.cacheRetention != nil {
+		js.cacheRetention.SetMetrics(metrics, "local")
+	}
+}
+
+// SetClock makes the scheduler record runs, report status changes, measure
+// maintenance windows and apply retention with the given clock. Jobs still
+// come due on the system clock. It must be called before Start.
+func (js *JobScheduler) SetClock(c clock.Clock) {
+	js.clock = c
+	js.local.SetClock(c)
+	js.retentionMgr.SetClock(c)
+	if js.localRetention != nil {
+		js.localRetention.SetClock(c)
+	}
+	if js.cacheRetention != nil {
+		js.cacheRetention.SetClock(c)
+	}
+	for _, d := range js.destinations {
+		d.retention.SetClock(c)
+	}
+}
+
+// SetHistory records every run in the history store. It must be called
+// before Start.
+func (js *JobScheduler) SetHistory(store *history.Store) {
+	js.history = store
+}
+
+// SetRedactor masks secrets in the tool output recorded with failed runs. It
+// must be called before Start.
+func (js *JobScheduler) SetRedactor(redactor *redact.Redactor) {
+	js.redactor = redactor
+}
+
+// RemoveJob unschedules a job and drops any pending shifted or deferred run
+// and the pause set through PauseJob. A run that is already in progress is
+// left to finish.
+func (js *JobScheduler) RemoveJob(jobName string) error {
+	js.jobsMu.Lock()
+	_, ok := js.jobs[jobName]
+	delete(js.jobs, jobName)
+	delete(js.jobConfigs, jobName)
+	js.jobsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("job %s is not scheduled", jobName)
+	}
+
+	if err := js.scheduler.RemoveByTag(jobName); err != nil {
+		return fmt.Errorf("failed to unschedule job %s: %w", jobName, err)
+	}
+	js.cancelShiftedRun(jobName)
+	js.shiftedMu.Lock()
+	delete(js.deferrals, jobName)
+	js.shiftedMu.Unlock()
+	js.dropPause(jobName)
+
+	for _, callback := range js.callbacks {
+		callback(jobName, StatusRemoved, js.clock.Now())
+	}
+
+	return nil
+}
+
+// HasJob reports whether a job with the given name is scheduled
+func (js *JobScheduler) HasJob(jobName string) bool {
+	js.jobsMu.RLock()
+	defer js.jobsMu.RUnlock()
+
+	_, ok := js.jobs[jobName]
+	return ok
+}
+
+// JobNames returns the names of all scheduled jobs in sorted order
+func (js *JobScheduler) JobNames() []string {
+	js.jobsMu.RLock()
+	names := make([]string, 0, len(js.jobConfigs))
+	for name := range js.jobConfigs {
+		names = append(names, name)
+	}
+	js.jobsMu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// JobConfig returns the configuration of a scheduled job
+func (js *JobScheduler) JobConfig(jobName string) (config.JobConfig, bool) {
+	js.jobsMu.RLock()
+	defer js.jobsMu.RUnlock()
+
+	jobConfig, ok := js.jobConfigs[jobName]
+	return jobConfig, ok
+}
+
+// RetentionPlan reports what the job's retention policy would remove if it
+// were applied now
+func (js *JobScheduler) RetentionPlan(ctx context.Context, jobName string) (retention.Plan, error) {
+	jobConfig, ok := js.JobConfig(jobName)
+	if !ok {
+		return retention.Plan{}, ErrJobNotFound
+	}
+	return js.retentionMgr.Plan(ctx, jobConfig)
+}
+
+// Prune applies a job's retention policy outside of a run, to the remote
+// backups and to the local copies that are kept. It returns what the policy
+// removed, or with dryRun what it would remove. The job does not need to be
+// scheduled.
+func (js *JobScheduler) Prune(ctx context.Context, jobConfig config.JobConfig, dryRun bool) (retention.Plan, error) {
+	plan, err := js.retentionMgr.Plan(ctx, jobConfig)
+	if err != nil || dryRun {
+		return plan, err
+	}
+
+	if err := js.retentionMgr.ApplyRetentionPolicy(ctx, jobConfig); err != nil {
+		return plan, err
+	}
+	if js.localRetention != nil {
+		if err := js.localRetention.ApplyRetentionPolicy(ctx, jobConfig); err != nil {
+			return plan, fmt.Errorf("local copies: %w", err)
+		}
+	}
+	return plan, nil
+}
+
+// Usage returns the number and total size of a job's backups in storage, or
+// in remote storage when backups are uploaded
+func (js *JobScheduler) Usage(jobName string) (int, int64, error) {
+	entries, err := js.store.List(js.stopCtx, jobName)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	return len(entries), size, nil
+}
+
+// NewestBackup returns the modification time of the job's newest backup in
+// storage, or false when it has none
+func (js *JobScheduler) NewestBackup(jobName string) (time.Time, bool, error) {
+	entries, err := js.store.List(js.stopCtx, jobName)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	var newest time.Time
+	for _, entry := range entries {
+		if entry.ModTime.After(newest) {
+			newest = entry.ModTime
+		}
+	}
+	return newest, !newest.IsZero(), nil
+}
+
+// trigger is called whenever a job comes due. It honours maintenance mode, the
+// job's pause, the exclusion calendar, the load of the job's source and the
+// coordinator before running the job.
+func (js *JobScheduler) trigger(jobConfig config.JobConfig, executor BackupExecutor) {
+	jobName := jobConfig.Name
+	now := js.clock.Now()
+
+	if state := js.Maintenance(); state.Active {
+		log.Printf("Skipping backup job %s: maintenance mode is active", jobName)
+		return
+	}
+
+	if until, paused := js.pausedUntil(jobConfig); paused {
+		log.Printf("Skipping backup job %s: paused until %s", jobName, until.Format(time.RFC3339))
+		return
+	}
+
+	if js.exclusions.Excluded(now) {
+		switch jobConfig.OnExcludedDate {
+		case "run":
+			log.Printf("Running backup job %s on excluded date %s as configured", jobName, now.Format(dateLayout))
+		case "shift":
+			js.shiftRun(jobConfig, executor, now)
+			return
+		default:
+			log.Printf("Skipping backup job %s: %s is an excluded date", jobName, now.Format(dateLayout))
+			return
+		}
+	}
+
+	js.cancelShiftedRun(jobName)
+
+	if js.deferForLoad(jobConfig, executor) || js.deferForBudget(jobConfig, executor) {
+		return
+	}
+
+	if js.coordinator != nil && !js.coordinator.ShouldRun(jobName, now) {
+		return
+	}
+
+	js.runJob(jobConfig, executor, true)
+}
+
+// triggerSchedule starts the run of one of the job's schedules with its dump
+// mode, unless another of them fires at the same minute and takes the run
+func (js *JobScheduler) triggerSchedule(jobConfig config.JobConfig, entry config.ScheduleEntry, executor BackupExecutor) {
+	if len(jobConfig.Schedules) > 1 {
+		if due, ok := jobConfig.ScheduleAt(js.clock.Now()); ok && due != entry {
+			log.Printf("Skipping schedule %s of backup job %s: schedule %s runs at the same time", entry.Cron, jobConfig.Name, due.Cron)
+			return
+		}
+	}
+	js.trigger(jobConfig.ForSchedule(entry), executor)
+}
+
+// shiftRun postpones a run that fell on an excluded date to the same time on
+// the next allowed date. No run is shifted when the job's own schedule fires
+// again by then, and a shifted run is dropped if the schedule fires on an
+// allowed date first.
+func (js *JobScheduler) shiftRun(jobConfig config.JobConfig, executor BackupExecutor, now time.Time) {
+	next, ok := js.exclusions.NextAllowed(now)
+	if !ok {
+		log.Printf("Skipping backup job %s: no allowed date found to shift the run to", jobConfig.Name)
+		return
+	}
+
+	if registered, ok := js.JobConfig(jobConfig.Name); ok {
+		if due := registered.NextRun(now); !due.IsZero() && !due.After(next) {
+			log.Printf("Skipping backup job %s: %s is an excluded date and the job runs again at %s",
+				jobConfig.Name, now.Format(dateLayout), due.Format(time.RFC3339))
+			return
+		}
+	}
+
+	js.shiftedMu.Lock()
+	defer js.shiftedMu.Unlock()
+
+	if _, pending := js.shifted[jobConfig.Name]; pending {
+		log.Printf("Skipping backup job %s on excluded date: a shifted run is already pending", jobConfig.Name)
+		return
+	}
+
+	log.Printf("Backup job %s falls on excluded date %s, shifted to %s",
+		jobConfig.Name, now.Format(dateLayout), next.Format(time.RFC3339))
+
+	js.shifted[jobConfig.Name] = time.AfterFunc(next.Sub(now), func() {
+		js.trigger(jobConfig, executor)
+	})
+}
+
+func (js *JobScheduler) cancelShiftedRun(jobName string) {
+	js.shiftedMu.Lock()
+	defer js.shiftedMu.Unlock()
+
+	if timer, ok := js.shifted[jobName]; ok {
+		timer.Stop()
+		delete(js.shifted, jobName)
+	}
+}
+
+// claimRun marks the job as running, unless a run of it is already in
+// progress. A cron tick, a shifted or deferred run, RunNow and one-off runs
+// may all start the same job at once.
+func (js *JobScheduler) claimRun(jobName string) bool {
+	js.runningMu.Lock()
+	defer js.runningMu.Unlock()
+
+	if js.inFlight[jobName] {
+		return false
+	}
+	js.inFlight[jobName] = true
+	return true
+}
+
+func (js *JobScheduler) releaseRun(jobName string) {
+	js.runningMu.Lock()
+	delete(js.inFlight, jobName)
+	js.runningMu.Unlock()
+}
+
+// RunNow runs a scheduled job right away and waits for it to finish,
+// including the upload and the cleanup after it. It returns the recorded run.
+func (js *JobScheduler) RunNow(jobName string) (history.Run, error) {
+	js.jobsMu.RLock()
+	executor, ok := js.jobs[jobName]
+	jobConfig := js.jobConfigs[jobName]
+	js.jobsMu.RUnlock()
+
+	if !ok {
+		return history.Run{}, ErrJobNotFound
+	}
+	defer js.cleanups.wait(jobName)
+	return js.runJob(jobConfig, executor, false)
+}
+
+// runJob executes a job once its concurrency slot is available and starts
+// the cleanup after a successful run, which applies retention in the
+// background. A run that gave up waiting for its slot is not recorded, nor is
+// a scheduled run deferred because its group used up its budget while it
+// waited.
+func (js *JobScheduler) runJob(jobConfig config.JobConfig, executor BackupExecutor, scheduled bool) (history.Run, error) {
+	jobName := jobConfig.Name
+
+	if !js.claimRun(jobName) {
+		log.Printf("Skipping backup job %s: a run is already in progress", jobName)
+		return history.Run{}, fmt.Errorf("%w: %s", ErrJobRunning, jobName)
+	}
+	defer js.releaseRun(jobName)
 
+	ctx, cancel := context.WithTimeout(context.Background(), RunTimeout)
+	defer cancel()
+
+	waited, ok := js.acquireSlot(ctx, jobConfig)
+	if !ok {
+		return history.Run{}, fmt.Errorf("job %s gave up waiting for a free slot", jobName)
+	}
+	defer js.limiter.Release(jobConfig.ConcurrencyGroup)
+
+	if scheduled && waited && js.deferForBudget(jobConfig, executor) {
 		for _, callback := range js.callbacks {
-			callback(jobName, StatusRunning, time.Now())
+			callback(jobName, StatusPending, js.clock.Now())
 		}
+		return history.Run{}, fmt.Errorf("job %s was deferred, concurrency group %s used up its budget", jobName, jobConfig.ConcurrencyGroup)
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 12*time.Hour)
-		defer cancel()
+	// Retention and the quota give up when the scheduler stops, rather than
+	// holding up shutdown on slow storage
+	cleanupCtx, cancelCleanup := js.untilStopped(ctx)
+	defer cancelCleanup()
 
-		if err := executor.Execute(ctx); err != nil {
-			log.Printf("Error executing backup job %s: %v", jobName, err)
+	// Free space for the new backup before it is written
+	js.enforceQuota(cleanupCtx)
 
-			for _, callback := range js.callbacks {
-				callback(jobName, StatusError, time.Now())
-			}
+	// Backups are timestamped to the second
+	start := js.clock.Now().Truncate(time.Second)
+	id := runid.New(start)
+	ctx, recorder := manifest.WithRecorder(runid.With(ctx, id))
+
+	log.Printf("Running backup job: %s (%s), run %s", jobName, jobConfig.Type, id)
+
+	js.startRun(jobName, id, start)
+	defer js.finishRun(jobName, id)
+	defer js.chargeBudget(jobConfig, id)()
+
+	for _, callback := range js.callbacks {
+		callback(jobName, StatusRunning, js.clock.Now())
+	}
+
+	// A backup refused for lack of local space fails the run like one that
+	// ran out of space while it was written
+	err := js.checkLocalSpace(cleanupCtx, jobConfig)
+	if err == nil {
+		if modes, ok := executor.(ModeExecutor); ok {
+			err = modes.ExecuteMode(ctx, jobConfig.DumpMode())
 		} else {
-			log.Printf("Backup job %s completed successfully", jobName)
+			err = executor.Execute(ctx)
+		}
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = &failure.TimeoutError{Err: err}
+	}
+	// Measured before uploading removes the local copies
+	written := js.runEntries(jobName, start)
+	var size int64
+	for _, entry := range written {
+		size += entry.Size
+	}
 
-			log.Printf("Applying retention policy for job %s: Keep %d %s",
-				jobName, jobConfig.RetentionPolicy.Value, jobConfig.RetentionPolicy.Type)
+	// A partial run still uploads the artifacts it wrote
+	var artifacts *failure.ArtifactsError
+	partial := errors.As(err, &artifacts) && artifacts.Partial()
+	if err != nil && !partial {
+		log.Printf("Error executing backup job %s (run %s): %v", jobName, id, err)
+		js.discardArtifacts(jobName, id, written)
+		run := js.recordRun(jobConfig, id, start, size, err, nil)
 
-			if err := js.retentionMgr.ApplyRetentionPolicy(jobConfig); err != nil {
-				log.Printf("Error applying retention policy for job %s: %v", jobName, err)
-			}
+		for _, callback := range js.callbacks {
+			callback(jobName, StatusError, js.clock.Now())
+		}
+		return run, err
+	}
+
+	if partial {
+		log.Printf("Backup job %s (run %s) completed partially: %v", jobName, id, err)
+	} else {
+		log.Printf("Backup job %s (run %s) completed successfully", jobName, id)
+	}
+	js.writeManifests(ctx, jobConfig, executor, id, written, recorder)
+
+	// Copied while the backups are still staged, before the upload to the
+	// primary storage removes them
+	copies := js.replicate(ctx, jobConfig)
+
+	if js.remote != nil && !js.deferUpload(jobName) {
+		if uploadErr := js.upload(ctx, jobConfig); uploadErr != nil {
+			log.Printf("Error uploading backup job %s (run %s): %v", jobName, id, uploadErr)
+			err = fmt.Errorf("upload failed: %w", uploadErr)
+			run := js.recordRun(jobConfig, id, start, size, err, copies)
 
 			for _, callback := range js.callbacks {
-				callback(jobName, StatusComplete, time.Now())
+				callback(jobName, StatusError, js.clock.Now())
 			}
+			return run, err
 		}
-	})
+		// Everything staged has been sent, including earlier deferred uploads
+		js.removePendingUpload(jobName)
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to schedule job %s: %w", jobName, err)
+	// Retention would count an incomplete backup as a complete one and
+	// rotate out an older backup that has every artifact
+	if partial {
+		log.Printf("Skipping retention policy for job %s after a partial run", jobName)
+		js.enforceQuota(cleanupCtx)
+	} else {
+		js.cleanups.start(jobConfig)
 	}
 
-	job.Tag(jobName)
+	// The backups are complete in the primary storage, so retention still
+	// applies, but the run is partial while a destination lacks them
+	replicated := true
+	if replicationErr := failure.Replication(copies); replicationErr != nil {
+		replicated = false
+		err = errors.Join(err, replicationErr)
+	}
 
-	js.jobs[jobName] = executor
-	js.jobConfigs[jobName] = jobConfig
+	run := js.recordRun(jobConfig, id, start, size, err, copies)
 
+	status := StatusComplete
+	if partial || !replicated {
+		status = StatusPartial
+	}
 	for _, callback := range js.callbacks {
-		callback(jobName, StatusPending, time.Now())
+		callback(jobName, status, js.clock.Now())
+	}
+	return run, err
+}
+
+// cleanUp applies a job's retention policy to its backups, the local copies
+// that are kept and the copies on its destinations, then the storage quota,
+// and prunes what crashed runs left in the local directory
+func (js *JobScheduler) cleanUp(ctx context.Context, jobConfig config.JobConfig) {
+	jobName := jobConfig.Name
+	log.Printf("Applying retention policy for job %s: Keep %d %s",
+		jobName, jobConfig.RetentionPolicy.Value, jobConfig.RetentionPolicy.Type)
+
+	if err := js.retentionMgr.ApplyRetentionPolicy(ctx, jobConfig); err != nil {
+		log.Printf("Error applying retention policy for job %s: %v", jobName, err)
+	}
+	if js.localRetention != nil {
+		if err := js.localRetention.ApplyRetentionPolicy(ctx, jobConfig); err != nil {
+			log.Printf("Error applying retention policy to local copies of job %s: %v", jobName, err)
+		}
+	}
+	js.cleanUpDestinations(ctx, jobConfig)
+	js.enforceQuota(ctx)
+	js.pruneLeftovers(jobName)
+}
+
+// runEntries returns the local backups a run wrote since it started
+func (js *JobScheduler) runEntries(jobName string, start time.Time) []storage.BackupEntry {
+	entries, err := localfs.New(config.LocalConfig{Directory: js.localDir}).List(context.Background(), jobName)
+	if err != nil {
+		return nil
+	}
+	var written []storage.BackupEntry
+	for _, entry := range entries {
+		if !entry.ModTime.Before(start) {
+			written = append(written, entry)
+		}
 	}
+	return written
+}
+
+// discardArtifacts removes what a failed run wrote. The files are likely
+// truncated, yet named like complete backups, and retention would count them.
+func (js *JobScheduler) discardArtifacts(jobName, id string, written []storage.BackupEntry) {
+	for _, entry := range written {
+		if err := js.local.Delete(context.Background(), entry); err != nil {
+			log.Printf("Warning: failed to remove %s left by failed run %s of job %s: %v", entry.Key, id, jobName, err)
+			continue
+		}
+		log.Printf("Removed %s left by failed run %s of job %s", filepath.Base(entry.Key), id, jobName)
+	}
+}
 
+// writeManifests records a manifest for every backup a run wrote, with the
+// versions of the source database when the executor can tell them, what the
+// executor recorded during the run and the format and checksums of the
+// artifact
+func (js *JobScheduler) writeManifests(ctx context.Context, jobConfig config.JobConfig, executor BackupExecutor, id string,
+	written []storage.BackupEntry, recorder *manifest.Recorder) {
+	if len(written) == 0 {
+		return
+	}
+
+	var source *manifest.Source
+	if describer, ok := executor.(SourceDescriber); ok {
+		var err error
+		if source, err = describer.DescribeSource(ctx); err != nil {
+			log.Printf("Warning: failed to read the source versions of job %s: %v", jobConfig.Name, err)
+		}
+	}
+
+	for _, entry := range written {
+		m := manifest.Manifest{
+			Job:         jobConfig.Name,
+			Type:        jobConfig.Type,
+			Backup:      filepath.Base(entry.Key),
+			RunID:       id,
+			CreatedAt:   entry.ModTime,
+			Source:      source,
+			Mode:        jobConfig.DumpMode(),
+			Coordinates: recorder.Coordinates(),
+			Node:        recorder.Node(),
+			Chain:       recorder.Chain(),
+			Tables:      recorder.Tables(),
+		}
+		if format, err := manifest.DetectFormat(entry.Key); err == nil {
+			m.Format = &format
+		} else {
+			log.Printf("Warning: failed to detect the format of %s: %v", entry.Key, err)
+		}
+		if checksums, err := manifest.ComputeChecksums(entry.Key); err == nil {
+			m.Checksums = checksums
+		} else {
+			log.Printf("Warning: failed to checksum %s: %v", entry.Key, err)
+		}
+		if err := js.manifests.Write(m); err != nil {
+			log.Printf("Warning: failed to write the manifest of %s: %v", entry.Key, err)
+		}
+	}
+}
+
+// recordRun adds a finished run to the history, with the outcomes of the
+// copies to its destinations
+func (js *JobScheduler) recordRun(jobConfig config.JobConfig, id string, start time.Time, size int64, runErr error,
+	copies []failure.Artifact) history.Run {
+	run := history.Run{
+		ID:         id,
+		Job:        jobConfig.Name,
+		Type:       jobConfig.Type,
+		Status:     history.StatusSuccess,
+		StartedAt:  start,
+		FinishedAt: js.clock.Now(),
+		Size:       size,
+	}
+	if runErr != nil {
+		run.Status = history.StatusFailed
+		run.Error = runErr.Error()
+		run.ErrorCode = string(failure.CodeOf(runErr))
+		run.ErrorExcerpt = js.redact(failure.Excerpt(runErr))
+	}
+	var artifacts *failure.ArtifactsError
+	if errors.As(runErr, &artifacts) {
+		if artifacts.Partial() {
+			run.Status = history.StatusPartial
+		}
+		for _, artifact := range artifacts.Artifacts {
+			run.Artifacts = append(run.Artifacts, artifactOutcome(artifact))
+		}
+	}
+	var replication *failure.ReplicationError
+	if errors.As(runErr, &replication) {
+		run.Status = history.StatusPartial
+	}
+	for _, c := range copies {
+		run.Destinations = append(run.Destinations, artifactOutcome(c))
+	}
+	for _, callback := range js.runCallbacks {
+		callback(run)
+	}
+	if js.history == nil {
+		return run
+	}
+	if err := js.history.Append(run); err != nil {
+		log.Printf("Warning: failed to record run of job %s: %v", jobConfig.Name, err)
+	}
+	return run
+}
+
+// artifactOutcome is how an artifact, or a copy to a destination, is
+// recorded in the history
+func artifactOutcome(artifact failure.Artifact) history.Artifact {
+	result := history.Artifact{Name: artifact.Name, Status: history.StatusSuccess}
+	if artifact.Err != nil {
+		result.Status = history.StatusFailed
+		result.Error = artifact.Err.Error()
+		result.ErrorCode = string(failure.CodeOf(artifact.Err))
+	}
+	return result
+}
+
+// redact masks secrets in lines of tool output
+func (js *JobScheduler) redact(lines []string) []string {
+	if js.redactor == nil || len(lines) == 0 {
+		return lines
+	}
+	masked := make([]string, len(lines))
+	for i, line := range lines {
+		masked[i] = js.redactor.String(line)
+	}
+	return masked
+}
+
+// upload sends the job's staged backups to remote storage and removes the
+// local copies unless they are kept or cached
+func (js *JobScheduler) upload(ctx context.Context, jobConfig config.JobConfig) error {
+	jobName := jobConfig.Name
+	jobDir := filepath.Join(js.localDir, jobName)
+
+	if configured, ok := js.remote.(ConfiguredStorage); ok {
+		if err := configured.ConfigureJob(ctx, jobConfig); err != nil {
+			log.Printf("Warning: failed to set the storage transitions of job %s: %v", jobName, err)
+		}
+	}
+
+	result, err := js.remote.Upload(runid.WithBackups(ctx, js.stagedRunIDs(jobName)), jobName, jobDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("[Job: %s] Uploaded %d files (%d bytes), copied %d unchanged files, skipped %d already uploaded",
+		jobName, result.Uploaded, result.Bytes, result.Copied, result.Skipped)
+
+	if js.keepLocal {
+		return nil
+	}
+	if js.cache != nil {
+		js.trimCache(ctx, jobConfig)
+		return nil
+	}
+	for _, name := range result.Entries {
+		if err := os.RemoveAll(filepath.Join(jobDir, name)); err != nil {
+			log.Printf("Warning: failed to remove uploaded backup %s: %v", name, err)
+		}
+	}
 	return nil
 }
 
+// stagedRunIDs returns the runs that wrote a job's staged backups, as
+// recorded in their manifests, so that backups uploaded after a deferral are
+// attributed to their own runs
+func (js *JobScheduler) stagedRunIDs(jobName string) map[string]string {
+	entries, err := os.ReadDir(filepath.Join(js.localDir, jobName))
+	if err != nil {
+		return nil
+	}
+	ids := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if m, err := js.manifests.Read(jobName, e.Name()); err == nil && m.RunID != "" {
+			ids[e.Name()] = m.RunID
+		}
+	}
+	return ids
+}
+
+// trimCache removes the local copies of a job's backups beyond the newest
+// cache.Keep, then the oldest local copies of any job beyond cache.MaxSize
+func (js *JobScheduler) trimCache(ctx context.Context, jobConfig config.JobConfig) {
+	cached := jobConfig
+	cached.RetentionPolicy = config.RetentionPolicy{Type: "count", Value: js.cache.Keep}
+	if err := js.cacheRetention.ApplyRetentionPolicy(ctx, cached); err != nil {
+		log.Printf("Error trimming the local cache of job %s: %v", jobConfig.Name, err)
+	}
+
+	if js.cache.MaxSize == "" {
+		return
+	}
+	js.jobsMu.RLock()
+	jobs := make([]config.JobConfig, 0, len(js.jobConfigs))
+	for _, job := range js.jobConfigs {
+		jobs = append(jobs, job)
+	}
+	js.jobsMu.RUnlock()
+
+	if err := js.cacheRetention.EnforceQuota(ctx, jobs, config.QuotaConfig{MaxSize: js.cache.MaxSize}); err != nil {
+		log.Printf("Error enforcing the local cache size: %v", err)
+	}
+}
+
+// enforceQuota removes backups across all jobs while the storage quota is
+// exceeded
+func (js *JobScheduler) enforceQuota(ctx context.Context) {
+	if js.quota.MaxSize == "" {
+		return
+	}
+
+	js.jobsMu.RLock()
+	jobs := make([]config.JobConfig, 0, len(js.jobConfigs))
+	for _, jobConfig := range js.jobConfigs {
+		jobs = append(jobs, jobConfig)
+	}
+	js.jobsMu.RUnlock()
+
+	js.quotaMu.Lock()
+	defer js.quotaMu.Unlock()
+
+	if err := js.retentionMgr.EnforceQuota(ctx, jobs, js.quota); err != nil {
+		log.Printf("Error enforcing storage quota: %v", err)
+	}
+}
+
+// untilStopped returns a context derived from ctx that is also cancelled when
+// the scheduler stops
+func (js *JobScheduler) untilStopped(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(js.stopCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// acquireSlot waits until the global and concurrency group limits allow the
+// job to run. It reports whether the job had to wait, and false if it gave
+// up waiting.
+func (js *JobScheduler) acquireSlot(ctx context.Context, jobConfig config.JobConfig) (waited, ok bool) {
+	if js.limiter.TryAcquire(jobConfig.ConcurrencyGroup) {
+		return false, true
+	}
+
+	log.Printf("Backup job %s is queued behind the concurrency limit", jobConfig.Name)
+	for _, callback := range js.callbacks {
+		callback(jobConfig.Name, StatusQueued, js.clock.Now())
+	}
+
+	dequeue := js.enqueueRun(jobConfig)
+	err := js.limiter.Acquire(ctx, jobConfig.ConcurrencyGroup, jobConfig.Priority)
+	dequeue()
+	if err != nil {
+		log.Printf("Backup job %s gave up waiting for a free slot: %v", jobConfig.Name, err)
+		for _, callback := range js.callbacks {
+			callback(jobConfig.Name, StatusError, js.clock.Now())
+		}
+		return true, false
+	}
+	return true, true
+}
+
 func (js *JobScheduler) Start() {
 	js.scheduler.StartAsync()
+	if js.uploadWindow != nil {
+		go js.watchUploads()
+	}
+
+	js.jobsMu.RLock()
 	log.Printf("Job scheduler started with %d jobs", len(js.jobs))
+	js.jobsMu.RUnlock()
 
 	for _, callback := range js.callbacks {
-		callback("scheduler", StatusRunning, time.Now())
+		callback("scheduler", StatusRunning, js.clock.Now())
 	}
 }
 
 func (js *JobScheduler) Stop() {
 	js.scheduler.Stop()
+	js.stop()
+	js.cleanups.waitAll()
+
+	js.shiftedMu.Lock()
+	for jobName, timer := range js.shifted {
+		timer.Stop()
+		delete(js.shifted, jobName)
+	}
+	js.shiftedMu.Unlock()
+	js.stopOneOffRuns()
+
 	log.Printf("Job scheduler stopped")
 
 	for _, callback := range js.callbacks {
-		callback("scheduler", StatusStopped, time.Now())
+		callback("scheduler", StatusStopped, js.clock.Now())
 	}
 }
 
@@ -109,15 +1015,31 @@ type JobStatusCallback func(jobName string, status string, timestamp time.Time)
 const (
 	StatusRunning  = "RUNNING"
 	StatusPending  = "PENDING"
+	StatusQueued   = "QUEUED"
 	StatusError    = "ERROR"
 	StatusComplete = "COMPLETE"
+	StatusPartial  = "PARTIAL" // Finished with some artifacts missing
 	StatusStopped  = "STOPPED"
+	StatusPaused   = "PAUSED"
+	StatusRemoved  = "REMOVED"
 )
 
+// RunCallback is called with every finished run, whether or not it is kept
+// in a history file
+type RunCallback func(run history.Run)
+
+// RegisterRunCallback registers a function to call when a run finishes
+func (js *JobScheduler) RegisterRunCallback(callback RunCallback) {
+	js.runCallbacks = append(js.runCallbacks, callback)
+}
+
 func (js *JobScheduler) RegisterStatusCallback(callback JobStatusCallback) {
 	js.callbacks = append(js.callbacks, callback)
 
+	js.jobsMu.RLock()
+	defer js.jobsMu.RUnlock()
+
 	for jobName := range js.jobs {
-		callback(jobName, StatusPending, time.Now())
+		callback(jobName, StatusPending, js.clock.Now())
 	}
 }
@@ -0,0 +1,434 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/manifest"
+	"github.com/thitiph0n/backmeup/internal/redact"
+	"github.com/thitiph0n/backmeup/internal/storage"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+// fileExecutor writes a backup file into the job directory, or fails
+type fileExecutor struct {
+	dir string
+	err error
+}
+
+func (e fileExecutor) Execute(ctx context.Context) error {
+	if e.err != nil {
+		return e.err
+	}
+	return os.WriteFile(filepath.Join(e.dir, "backup_"+time.Now().Format("20060102-150405.000000")), []byte("data"), 0644)
+}
+
+func TestRunNow(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "orders"), 0755))
+
+	_, err := js.RunNow("orders")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+
+	var finished []history.Run
+	js.RegisterRunCallback(func(run history.Run) {
+		finished = append(finished, run)
+	})
+
+	run, err := js.RunNow("orders")
+	require.NoError(t, err)
+	assert.Equal(t, "success", run.Status)
+	assert.Equal(t, int64(4), run.Size)
+	assert.Empty(t, run.ErrorCode)
+	assert.NotEmpty(t, run.ID)
+
+	failing := job
+	failing.Name = "users"
+	require.NoError(t, js.AddJob(failing, fileExecutor{err: errors.New("dump failed")}))
+
+	run, err = js.RunNow("users")
+	assert.EqualError(t, err, "dump failed")
+	assert.Equal(t, "failed", run.Status)
+	assert.Equal(t, "dump failed", run.Error)
+	assert.Equal(t, "unknown", run.ErrorCode)
+
+	missing := job
+	missing.Name = "stock"
+	require.NoError(t, js.AddJob(missing, fileExecutor{err: &failure.ToolMissingError{Err: errors.New("pg_dump not found")}}))
+
+	run, err = js.RunNow("stock")
+	assert.Error(t, err)
+	assert.Equal(t, "tool_missing", run.ErrorCode)
+	assert.Equal(t, []history.Run{finished[0], finished[1], run}, finished)
+}
+
+func TestRunNow_ErrorExcerpt(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	js.SetRedactor(redact.New("hunter22"))
+
+	job := config.JobConfig{Name: "orders", Type: "postgres", Schedule: "0 3 * * *"}
+	dumpErr := &failure.OutputError{Err: errors.New("pg_dump failed: exit status 1"), Lines: []string{
+		`pg_dump: error: connection to server at "db" failed: FATAL: password authentication failed`,
+		"pg_dump: detail: tried password hunter22",
+	}}
+	require.NoError(t, js.AddJob(job, fileExecutor{err: dumpErr}))
+
+	run, err := js.RunNow("orders")
+	assert.Error(t, err)
+	assert.Equal(t, "connection", run.ErrorCode)
+	assert.Equal(t, []string{
+		`pg_dump: error: connection to server at "db" failed: FATAL: password authentication failed`,
+		"pg_dump: detail: tried password ***",
+	}, run.ErrorExcerpt)
+}
+
+// truncatedExecutor writes a backup file and then fails, like a dump tool that
+// dies halfway
+type truncatedExecutor struct {
+	dir string
+}
+
+func (e truncatedExecutor) Execute(ctx context.Context) error {
+	if err := (fileExecutor{dir: e.dir}).Execute(ctx); err != nil {
+		return err
+	}
+	return errors.New("pg_dump failed: exit status 1")
+}
+
+func TestRunNow_FailedRunRemovesArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	old := filepath.Join(jobDir, "backup_old")
+	require.NoError(t, os.WriteFile(old, []byte("data"), 0644))
+	require.NoError(t, os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+
+	job := config.JobConfig{Name: "orders", Type: "postgres", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+	require.NoError(t, js.AddJob(job, truncatedExecutor{dir: jobDir}))
+
+	run, err := js.RunNow("orders")
+	assert.Error(t, err)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	assert.Equal(t, int64(4), run.Size, "the size written is still recorded")
+
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "the truncated backup is removed")
+	assert.Equal(t, "backup_old", entries[0].Name())
+}
+
+// partialExecutor writes a backup file for one database and fails on another
+type partialExecutor struct {
+	dir string
+}
+
+func (e partialExecutor) Execute(ctx context.Context) error {
+	if err := (fileExecutor{dir: e.dir}).Execute(ctx); err != nil {
+		return err
+	}
+	return failure.Artifacts("databases", []failure.Artifact{
+		{Name: "orders"},
+		{Name: "users", Err: errors.New("connection refused")},
+	})
+}
+
+func TestRunNow_Partial(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	jobDir := filepath.Join(dir, "shop")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	old := filepath.Join(jobDir, "backup_old")
+	require.NoError(t, os.WriteFile(old, []byte("data"), 0644))
+	require.NoError(t, os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+
+	var statuses []string
+	js.RegisterStatusCallback(func(jobName, status string, timestamp time.Time) {
+		statuses = append(statuses, status)
+	})
+
+	job := config.JobConfig{Name: "shop", Type: "postgres", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+	require.NoError(t, js.AddJob(job, partialExecutor{dir: jobDir}))
+
+	run, err := js.RunNow("shop")
+	assert.EqualError(t, err, "1 of 2 databases failed: users: connection refused")
+	assert.Equal(t, history.StatusPartial, run.Status)
+	assert.Equal(t, "connection", run.ErrorCode)
+	assert.Equal(t, []history.Artifact{
+		{Name: "orders", Status: history.StatusSuccess},
+		{Name: "users", Status: history.StatusFailed, Error: "connection refused", ErrorCode: "connection"},
+	}, run.Artifacts)
+	assert.Equal(t, StatusPartial, statuses[len(statuses)-1])
+
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "retention is skipped after a partial run")
+}
+
+// databaseExecutor is a fileExecutor that describes its source database and
+// records binlog coordinates
+type databaseExecutor struct {
+	fileExecutor
+}
+
+func (e databaseExecutor) Execute(ctx context.Context) error {
+	manifest.RecordCoordinates(ctx, manifest.Coordinates{Database: "orders", Kind: manifest.CoordinatesReplica, LogFile: "binlog.000042", LogPosition: 157})
+	return e.fileExecutor.Execute(ctx)
+}
+
+func (e databaseExecutor) DescribeSource(ctx context.Context) (*manifest.Source, error) {
+	return &manifest.Source{Engine: manifest.EnginePostgres, ServerVersion: "16.2", SchemaVersion: "42"}, nil
+}
+
+func TestRunNow_Manifest(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+
+	job := config.JobConfig{Name: "orders", Type: "postgres", Schedule: "0 3 * * *",
+		PostgresConfig:  &config.PostgresConfig{Mode: config.ModeSchema},
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, databaseExecutor{fileExecutor{dir: jobDir}}))
+
+	run, err := js.RunNow("orders")
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "manifests are kept out of the job directory")
+
+	m, err := manifest.NewStore(manifest.DefaultDir(dir)).Read("orders", entries[0].Name())
+	require.NoError(t, err)
+	assert.Equal(t, run.ID, m.RunID)
+	assert.Equal(t, "postgres", m.Type)
+	assert.Equal(t, "schema", m.Mode)
+	assert.Equal(t, &manifest.Source{Engine: "postgres", ServerVersion: "16.2", SchemaVersion: "42"}, m.Source)
+	assert.Equal(t, []manifest.Coordinates{{Database: "orders", Kind: "replica", LogFile: "binlog.000042", LogPosition: 157}}, m.Coordinates)
+	require.Len(t, m.Checksums, 1)
+	assert.Equal(t, int64(4), m.Checksums[0].Size)
+	assert.NoError(t, manifest.VerifyChecksums(filepath.Join(jobDir, entries[0].Name()), m.Checksums))
+}
+
+// listingRemote is a remote storage that reports every staged backup as
+// uploaded without copying it
+type listingRemote struct {
+	*localfs.Storage
+}
+
+func (r listingRemote) Upload(ctx context.Context, jobName, localDir string) (storage.UploadResult, error) {
+	entries, err := os.ReadDir(localDir)
+	if err != nil {
+		return storage.UploadResult{}, err
+	}
+	var result storage.UploadResult
+	for _, entry := range entries {
+		result.Entries = append(result.Entries, entry.Name())
+	}
+	return result, nil
+}
+
+func TestRunNow_Cache(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	js.SetRemoteStorage(listingRemote{localfs.New(config.LocalConfig{Directory: t.TempDir()})}, false)
+	js.SetCache(config.CacheConfig{Keep: 2})
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+
+	job := config.JobConfig{Name: "orders", Type: "postgres", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: jobDir}))
+
+	for range 3 {
+		_, err := js.RunNow("orders")
+		require.NoError(t, err)
+	}
+
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "only the newest uploaded backups stay cached")
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	for i := range 3 {
+		path := filepath.Join(jobDir, "backup_20250101-00000"+string(rune('0'+i)))
+		require.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+		modTime := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	job := config.JobConfig{Name: "orders", RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1}}
+
+	plan, err := js.Prune(context.Background(), job, true)
+	require.NoError(t, err)
+	assert.Len(t, plan.Remove, 2)
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "a dry run removes nothing")
+
+	plan, err = js.Prune(context.Background(), job, false)
+	require.NoError(t, err)
+	assert.Len(t, plan.Remove, 2)
+	entries, err = os.ReadDir(jobDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "backup_20250101-000002", entries[0].Name())
+}
+
+func TestSetClock(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	clock := backuptest.NewClock(time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC))
+	js.SetClock(clock)
+
+	var times []time.Time
+	js.RegisterStatusCallback(func(jobName, status string, timestamp time.Time) {
+		times = append(times, timestamp)
+	})
+
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "days", Value: 1}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "orders"), 0755))
+
+	run, err := js.RunNow("orders")
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now(), run.StartedAt)
+	assert.Equal(t, clock.Now(), run.FinishedAt)
+	for _, timestamp := range times {
+		assert.Equal(t, clock.Now(), timestamp)
+	}
+
+	state := js.EnterMaintenance(time.Hour, "migration")
+	assert.Equal(t, clock.Now().Add(time.Hour), state.Until)
+	assert.True(t, js.Maintenance().Active)
+	clock.Advance(2 * time.Hour)
+	assert.False(t, js.Maintenance().Active, "maintenance expires on the scheduler's clock")
+}
+
+// modeExecutor writes a backup file and records the dump mode of each run
+type modeExecutor struct {
+	fileExecutor
+	modes *[]string
+}
+
+func (e modeExecutor) ExecuteMode(ctx context.Context, mode string) error {
+	*e.modes = append(*e.modes, mode)
+	return e.Execute(ctx)
+}
+
+func TestSchedules(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	clock := backuptest.NewClock(time.Date(2026, 3, 1, 2, 0, 0, 0, time.Local))
+	js.SetClock(clock)
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+
+	hourly := config.ScheduleEntry{Cron: "0 * * * *", Mode: config.ModeSchema}
+	nightly := config.ScheduleEntry{Cron: "0 2 * * *"}
+	job := config.JobConfig{Name: "orders", Type: "postgres", PostgresConfig: &config.PostgresConfig{},
+		Schedule: hourly.Cron, Schedules: []config.ScheduleEntry{hourly, nightly},
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	var modes []string
+	executor := modeExecutor{fileExecutor: fileExecutor{dir: jobDir}, modes: &modes}
+	require.NoError(t, js.AddJob(job, executor))
+	assert.Len(t, js.DebugState().Jobs, 2, "one cron job per schedule")
+
+	js.triggerSchedule(job, hourly, executor)
+	js.triggerSchedule(job, nightly, executor)
+	clock.Advance(3 * time.Hour)
+	js.triggerSchedule(job, hourly, executor)
+	assert.Equal(t, []string{config.ModeFull, config.ModeSchema}, modes, "the full dump takes the minute both schedules fire")
+
+	_, err := js.RunNow("orders")
+	require.NoError(t, err)
+	assert.Equal(t, config.ModeFull, modes[len(modes)-1], "runs started by hand dump with the job's mode")
+
+	require.NoError(t, js.RemoveJob("orders"))
+	assert.Empty(t, js.DebugState().Jobs)
+
+	job.Schedules = []config.ScheduleEntry{nightly, {Cron: "not a cron"}}
+	assert.Error(t, js.AddJob(job, executor))
+	assert.Empty(t, js.DebugState().Jobs, "a job is scheduled with all of its schedules or not at all")
+}
+
+func TestAddJob_Duplicate(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+
+	job.Schedule = "0 4 * * *"
+	assert.ErrorIs(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}), ErrJobExists)
+	assert.Len(t, js.DebugState().Jobs, 1, "the job keeps its own schedule only")
+	current, _ := js.JobConfig("orders")
+	assert.Equal(t, "0 3 * * *", current.Schedule)
+}
+
+func TestAddJob_Concurrent(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+
+	const adds = 8
+	errs := make(chan error, adds)
+	var wg sync.WaitGroup
+	for range adds {
+		wg.Go(func() {
+			errs <- js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")})
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	added := 0
+	for err := range errs {
+		if err == nil {
+			added++
+		} else {
+			assert.ErrorIs(t, err, ErrJobExists)
+		}
+	}
+	assert.Equal(t, 1, added)
+	assert.Len(t, js.DebugState().Jobs, 1, "the job is scheduled once")
+}
+
+func TestAddJob_InvalidScheduleReleasesName(t *testing.T) {
+	dir := t.TempDir()
+	js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	job := config.JobConfig{Name: "orders", Type: "sqlite", Schedule: "not a cron",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+	require.Error(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+	assert.False(t, js.HasJob("orders"))
+
+	job.Schedule = "0 3 * * *"
+	assert.NoError(t, js.AddJob(job, fileExecutor{dir: filepath.Join(dir, "orders")}))
+}
@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// uploadCheckInterval is how often a scheduler with an upload window checks
+// for pending uploads it may send
+const uploadCheckInterval = time.Minute
+
+// dailyWindow is a daily time range, such as the one in which backups are
+// uploaded. It spans midnight when end is before start.
+type dailyWindow struct {
+	start, end time.Duration // Offsets from midnight in local time
+}
+
+// contains reports whether t falls within the window
+func (w dailyWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
+
+// opened returns when the window last opened at or before t
+func (w dailyWindow) opened(t time.Time) time.Time {
+	opened := w.openOn(t, 0)
+	if opened.After(t) {
+		return w.openOn(t, -1)
+	}
+	return opened
+}
+
+// nextOpen returns when the window opens next after t
+func (w dailyWindow) nextOpen(t time.Time) time.Time {
+	next := w.openOn(t, 0)
+	if !next.After(t) {
+		return w.openOn(t, 1)
+	}
+	return next
+}
+
+// openOn returns when the window opens on the day of t shifted by days
+func (w dailyWindow) openOn(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, t.Location()).Add(w.start)
+}
+
+// pendingUploads is the queue of jobs whose staged backups wait for the
+// upload window. It is saved to a file so that it survives restarts.
+type pendingUploads struct {
+	mu   sync.Mutex
+	path string
+	jobs []string
+}
+
+// pendingUploadsPath is where the queue is saved in the local directory
+func pendingUploadsPath(localDir string) string {
+	return filepath.Join(localDir, ".backmeup", "pending-uploads.json")
+}
+
+// loadPendingUploads reads the queue saved at path, which may not exist yet
+func loadPendingUploads(path string) (*pendingUploads, error) {
+	p := &pendingUploads{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending uploads: %w", err)
+	}
+	if err := json.Unmarshal(data, &p.jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse pending uploads: %w", err)
+	}
+	return p, nil
+}
+
+// add queues a job, once
+func (p *pendingUploads) add(jobName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if slices.Contains(p.jobs, jobName) {
+		return nil
+	}
+	p.jobs = append(p.jobs, jobName)
+	return p.save()
+}
+
+// remove takes a job off the queue
+func (p *pendingUploads) remove(jobName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := slices.Index(p.jobs, jobName)
+	if i < 0 {
+		return nil
+	}
+	p.jobs = slices.Delete(p.jobs, i, i+1)
+	return p.save()
+}
+
+// RenamePendingUpload moves a job queued for the upload window to its new
+// name, so that the staged backups moved along with it are still uploaded.
+// It reports whether the job was queued.
+func RenamePendingUpload(localDir, from, to string) (bool, error) {
+	p, err := loadPendingUploads(pendingUploadsPath(localDir))
+	if err != nil {
+		return false, err
+	}
+	if !slices.Contains(p.jobs, from) {
+		return false, nil
+	}
+	if err := p.remove(from); err != nil {
+		return false, err
+	}
+	return true, p.add(to)
+}
+
+// list returns the queued jobs in the order they were queued
+func (p *pendingUploads) list() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.jobs)
+}
+
+func (p *pendingUploads) save() error {
+	data, err := json.Marshal(p.jobs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return fmt.Errorf("failed to save pending uploads: %w", err)
+	}
+	if err := os.WriteFile(p.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save pending uploads: %w", err)
+	}
+	return nil
+}
+
+// SetUploadWindow only uploads backups within a daily time window such as
+// 01:00-06:00. Runs outside of it leave their backups staged and queue the
+// upload, and the queue is kept in the local directory across restarts. It
+// must be called after SetRemoteStorage and before Start.
+func (js *JobScheduler) SetUploadWindow(window string) error {
+	start, end, err := config.ParseTimeWindow(window)
+	if err != nil {
+		return fmt.Errorf("invalid upload window '%s': %w", window, err)
+	}
+	pending, err := loadPendingUploads(pendingUploadsPath(js.localDir))
+	if err != nil {
+		return err
+	}
+	js.uploadWindow = &dailyWindow{start: start, end: end}
+	js.pendingUploads = pending
+	return nil
+}
+
+// PendingUploads returns the jobs whose backups wait for the upload window
+func (js *JobScheduler) PendingUploads() []string {
+	if js.pendingUploads == nil {
+		return nil
+	}
+	return js.pendingUploads.list()
+}
+
+// deferUpload queues the upload of a job's backups when the upload window is
+// closed. It returns false when the upload should happen now.
+func (js *JobScheduler) deferUpload(jobName string) bool {
+	if js.uploadWindow == nil || js.uploadWindow.contains(js.clock.Now()) {
+		return false
+	}
+	if err := js.pendingUploads.add(jobName); err != nil {
+		log.Printf("Warning: failed to queue the upload of job %s, uploading now: %v", jobName, err)
+		return false
+	}
+	log.Printf("Deferring upload of job %s until the upload window opens", jobName)
+	return true
+}
+
+// watchUploads sends the queued uploads whenever the upload window is open,
+// until the scheduler stops
+func (js *JobScheduler) watchUploads() {
+	ticker := time.NewTicker(uploadCheckInterval)
+	defer ticker.Stop()
+	for {
+		js.sendPendingUploads(js.stopCtx)
+		select {
+		case <-js.stopCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendPendingUploads uploads the queued jobs while the upload window is open,
+// then applies their retention policies to the remote copies. A job whose
+// upload fails stays queued for the next check.
+func (js *JobScheduler) sendPendingUploads(ctx context.Context) {
+	for _, jobName := range js.pendingUploads.list() {
+		if ctx.Err() != nil || !js.uploadWindow.contains(js.clock.Now()) {
+			return
+		}
+
+		js.jobsMu.RLock()
+		jobConfig, ok := js.jobConfigs[jobName]
+		js.jobsMu.RUnlock()
+		if !ok {
+			log.Printf("Warning: dropping the pending upload of job %s, which is no longer scheduled", jobName)
+			js.removePendingUpload(jobName)
+			continue
+		}
+
+		if err := js.upload(ctx, jobConfig); err != nil {
+			log.Printf("Error uploading pending backups of job %s: %v", jobName, err)
+			continue
+		}
+		js.removePendingUpload(jobName)
+
+		if err := js.retentionMgr.ApplyRetentionPolicy(ctx, jobConfig); err != nil {
+			log.Printf("Error applying retention policy for job %s: %v", jobName, err)
+		}
+	}
+}
+
+func (js *JobScheduler) removePendingUpload(jobName string) {
+	if js.pendingUploads == nil {
+		return
+	}
+	if err := js.pendingUploads.remove(jobName); err != nil {
+		log.Printf("Warning: failed to update pending uploads: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+func TestUploadWindowContains(t *testing.T) {
+	night := dailyWindow{start: time.Hour, end: 6 * time.Hour}
+	assert.True(t, night.contains(date(t, "2026-03-01 01:00")))
+	assert.True(t, night.contains(date(t, "2026-03-01 05:59")))
+	assert.False(t, night.contains(date(t, "2026-03-01 06:00")))
+	assert.False(t, night.contains(date(t, "2026-03-01 00:59")))
+
+	overMidnight := dailyWindow{start: 22 * time.Hour, end: 4 * time.Hour}
+	assert.True(t, overMidnight.contains(date(t, "2026-03-01 23:30")))
+	assert.True(t, overMidnight.contains(date(t, "2026-03-02 03:00")))
+	assert.False(t, overMidnight.contains(date(t, "2026-03-02 12:00")))
+}
+
+func TestUploadWindow(t *testing.T) {
+	dir := t.TempDir()
+	remote := listingRemote{localfs.New(config.LocalConfig{Directory: t.TempDir()})}
+	clk := backuptest.NewClock(date(t, "2026-03-01 12:00"))
+	newScheduler := func() *JobScheduler {
+		js := NewJobScheduler(config.StorageConfig{Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+		js.SetRemoteStorage(remote, false)
+		require.NoError(t, js.SetUploadWindow("01:00-06:00"))
+		js.SetClock(clk)
+		return js
+	}
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	job := config.JobConfig{Name: "orders", Type: "postgres", Schedule: "0 3 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 5}}
+
+	js := newScheduler()
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: jobDir}))
+	_, err := js.RunNow("orders")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders"}, js.PendingUploads())
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the backup stays staged outside of the window")
+
+	// The queue survives a restart
+	js = newScheduler()
+	require.NoError(t, js.AddJob(job, fileExecutor{dir: jobDir}))
+	assert.Equal(t, []string{"orders"}, js.PendingUploads())
+
+	js.sendPendingUploads(context.Background())
+	assert.Equal(t, []string{"orders"}, js.PendingUploads(), "nothing is sent before the window opens")
+
+	clk.Set(date(t, "2026-03-02 01:30"))
+	js.sendPendingUploads(context.Background())
+	assert.Empty(t, js.PendingUploads())
+	entries, err = os.ReadDir(jobDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "uploaded backups are removed from staging")
+}
+
+func TestRenamePendingUpload(t *testing.T) {
+	dir := t.TempDir()
+	queued, err := RenamePendingUpload(dir, "old", "new")
+	require.NoError(t, err)
+	assert.False(t, queued)
+
+	pending, err := loadPendingUploads(pendingUploadsPath(dir))
+	require.NoError(t, err)
+	require.NoError(t, pending.add("orders"))
+	require.NoError(t, pending.add("old"))
+
+	queued, err = RenamePendingUpload(dir, "old", "new")
+	require.NoError(t, err)
+	assert.True(t, queued)
+
+	pending, err = loadPendingUploads(pendingUploadsPath(dir))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders", "new"}, pending.list())
+}
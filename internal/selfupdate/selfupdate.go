@@ -0,0 +1,301 @@
+// Package selfupdate replaces the running binary with a release published on
+// GitHub, after verifying its checksum and signature
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRepository is the GitHub repository releases are fetched from
+	DefaultRepository = "thitiph0n/backmeup"
+
+	// ChecksumsAsset lists the SHA-256 of every binary of a release, in the
+	// format written by sha256sum
+	ChecksumsAsset = "checksums.txt"
+
+	// SignatureAsset is the Ed25519 signature of ChecksumsAsset
+	SignatureAsset = "checksums.txt.sig"
+
+	defaultAPIURL = "https://api.github.com"
+	maxBinarySize = 512 << 20
+)
+
+// PublicKey is the base64 Ed25519 key release checksums are signed with, set
+// at build time with -ldflags "-X .../selfupdate.PublicKey=..."
+var PublicKey string
+
+// ErrUnsigned is returned when a release has no signature but the binary was
+// built with a public key
+var ErrUnsigned = errors.New("release is not signed")
+
+// Release is a published release and the download URLs of its assets
+type Release struct {
+	Tag    string
+	Assets map[string]string // Asset name to download URL
+}
+
+// Updater downloads and verifies releases
+type Updater struct {
+	Repository string
+	APIURL     string
+	PublicKey  ed25519.PublicKey // Signatures are not checked when empty
+	client     *http.Client
+}
+
+// New returns an updater for the given repository that verifies signatures
+// with the built-in public key, if any
+func New(repository string) (*Updater, error) {
+	u := &Updater{
+		Repository: repository,
+		APIURL:     defaultAPIURL,
+		client:     &http.Client{Timeout: 5 * time.Minute},
+	}
+	if PublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid built-in release public key")
+		}
+		u.PublicKey = key
+	}
+	return u, nil
+}
+
+// AssetName returns the name of the release binary for a platform
+func AssetName(goos, goarch string) string {
+	name := fmt.Sprintf("backmeup_%s_%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// Release fetches the release with the given tag, or the latest release when
+// tag is empty
+func (u *Updater) Release(ctx context.Context, tag string) (Release, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimSuffix(u.APIURL, "/"), u.Repository)
+	if tag != "" {
+		url = fmt.Sprintf("%s/repos/%s/releases/tags/%s", strings.TrimSuffix(u.APIURL, "/"), u.Repository, tag)
+	}
+
+	body, err := u.get(ctx, url, 1<<20)
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to fetch release: %w", err)
+	}
+
+	var response struct {
+		TagName string `json:"tag_name"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return Release{}, fmt.Errorf("failed to parse release: %w", err)
+	}
+
+	release := Release{Tag: response.TagName, Assets: make(map[string]string, len(response.Assets))}
+	for _, asset := range response.Assets {
+		release.Assets[asset.Name] = asset.URL
+	}
+	return release, nil
+}
+
+// Download fetches the binary of a release for the current platform and
+// verifies it against the signed checksums
+func (u *Updater) Download(ctx context.Context, release Release) ([]byte, error) {
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	binaryURL, ok := release.Assets[name]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no binary for %s/%s", release.Tag, runtime.GOOS, runtime.GOARCH)
+	}
+	checksumsURL, ok := release.Assets[ChecksumsAsset]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s", release.Tag, ChecksumsAsset)
+	}
+
+	checksums, err := u.get(ctx, checksumsURL, 1<<20)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download checksums: %w", err)
+	}
+
+	if len(u.PublicKey) > 0 {
+		signatureURL, ok := release.Assets[SignatureAsset]
+		if !ok {
+			return nil, ErrUnsigned
+		}
+		signature, err := u.get(ctx, signatureURL, 4096)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download signature: %w", err)
+		}
+		if err := verifySignature(u.PublicKey, checksums, signature); err != nil {
+			return nil, err
+		}
+	}
+
+	want, err := findChecksum(checksums, name)
+	if err != nil {
+		return nil, err
+	}
+
+	binary, err := u.get(ctx, binaryURL, maxBinarySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	sum := sha256.Sum256(binary)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+	return binary, nil
+}
+
+// verifySignature checks a raw or base64 encoded Ed25519 signature
+func verifySignature(key ed25519.PublicKey, message, signature []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return fmt.Errorf("invalid release signature")
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(key, message, signature) {
+		return fmt.Errorf("release signature does not match the built-in public key")
+	}
+	return nil
+}
+
+// findChecksum returns the SHA-256 of an asset from a sha256sum listing
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", ChecksumsAsset, name)
+}
+
+func (u *Updater) get(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json, application/octet-stream")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("GET %s returned more than %d bytes", url, limit)
+	}
+	return data, nil
+}
+
+// Replace swaps the executable at path for the new binary. The old binary is
+// renamed aside first, which also works for a running executable on Windows,
+// and restored if the new one cannot be moved into place.
+func Replace(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".backmeup-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to write the new binary next to %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set the mode of the new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+
+	old := path + ".old"
+	os.Remove(old)
+	if err := os.Rename(path, old); err != nil {
+		return fmt.Errorf("failed to move the current binary aside: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Rename(old, path)
+		return fmt.Errorf("failed to install the new binary: %w", err)
+	}
+
+	// A running executable cannot be removed on Windows, it is cleaned up by
+	// the next update instead
+	os.Remove(old)
+	return nil
+}
+
+// Newer reports whether release version a is newer than b. Versions are
+// compared as vMAJOR.MINOR.PATCH, anything else such as a development build is
+// an error.
+func Newer(a, b string) (bool, error) {
+	pa, err := parseVersion(a)
+	if err != nil {
+		return false, err
+	}
+	pb, err := parseVersion(b)
+	if err != nil {
+		return false, err
+	}
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return pa[i] > pb[i], nil
+		}
+	}
+	return false, nil
+}
+
+func parseVersion(v string) ([3]int, error) {
+	var parsed [3]int
+	core, _, _ := strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return parsed, fmt.Errorf("not a release version: %s", v)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("not a release version: %s", v)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
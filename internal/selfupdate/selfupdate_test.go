@@ -0,0 +1,143 @@
+package selfupdate
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// releaseServer serves a GitHub release API response and its assets
+func releaseServer(t *testing.T, assets map[string][]byte) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("GET /repos/owner/repo/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tag_name": "v1.2.0", "assets": [`)
+		first := true
+		for name := range assets {
+			if !first {
+				fmt.Fprint(w, ",")
+			}
+			first = false
+			fmt.Fprintf(w, `{"name": %q, "browser_download_url": "%s/download/%s"}`, name, srv.URL, name)
+		}
+		fmt.Fprint(w, `]}`)
+	})
+	mux.HandleFunc("GET /download/{name}", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := assets[r.PathValue("name")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	})
+	return srv
+}
+
+func checksums(name string, data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return fmt.Appendf(nil, "%s  other_binary\n%s  %s\n", hex.EncodeToString(make([]byte, 32)), hex.EncodeToString(sum[:]), name)
+}
+
+func TestDownload(t *testing.T) {
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	binary := []byte("new binary")
+	sums := checksums(name, binary)
+
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	srv := releaseServer(t, map[string][]byte{
+		name:           binary,
+		ChecksumsAsset: sums,
+		SignatureAsset: ed25519.Sign(private, sums),
+	})
+
+	u, err := New("owner/repo")
+	require.NoError(t, err)
+	u.APIURL = srv.URL
+	u.PublicKey = public
+
+	release, err := u.Release(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.0", release.Tag)
+
+	data, err := u.Download(context.Background(), release)
+	require.NoError(t, err)
+	assert.Equal(t, binary, data)
+
+	// A different key rejects the signature
+	other, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	u.PublicKey = other
+	_, err = u.Download(context.Background(), release)
+	assert.ErrorContains(t, err, "release signature does not match")
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	srv := releaseServer(t, map[string][]byte{
+		name:           []byte("tampered"),
+		ChecksumsAsset: checksums(name, []byte("new binary")),
+	})
+
+	u, err := New("owner/repo")
+	require.NoError(t, err)
+	u.APIURL = srv.URL
+
+	release, err := u.Release(context.Background(), "")
+	require.NoError(t, err)
+	_, err = u.Download(context.Background(), release)
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	// Binaries built with a public key refuse unsigned releases
+	public, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	u.PublicKey = public
+	_, err = u.Download(context.Background(), release)
+	assert.ErrorIs(t, err, ErrUnsigned)
+}
+
+func TestReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backmeup")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0750))
+
+	require.NoError(t, Replace(path, []byte("new")))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary or old binary is left behind")
+}
+
+func TestNewer(t *testing.T) {
+	newer, err := Newer("v1.10.0", "v1.9.3")
+	require.NoError(t, err)
+	assert.True(t, newer)
+
+	newer, err = Newer("v1.2.0", "1.2.0")
+	require.NoError(t, err)
+	assert.False(t, newer)
+
+	_, err = Newer("v1.2.0", "dev")
+	assert.Error(t, err)
+}
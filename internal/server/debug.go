@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// debugState is the body of GET /debug/state
+type debugState struct {
+	Goroutines int                  `json:"goroutines"`
+	Memory     debugMemory          `json:"memory"`
+	Statuses   map[string]string    `json:"statuses"`
+	Scheduler  scheduler.DebugState `json:"scheduler"`
+}
+
+// debugMemory is the part of the runtime memory statistics that shows leaks
+type debugMemory struct {
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapInuse   uint64 `json:"heapInuse"`
+	HeapObjects uint64 `json:"heapObjects"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"numGC"`
+}
+
+// EnableDebug serves the pprof profiles under /debug/pprof and the runtime
+// state under /debug/state to requests bearing the token. It must be called
+// before Start.
+func (s *HTTPServer) EnableDebug(token string) {
+	debug := http.NewServeMux()
+	debug.HandleFunc("/debug/pprof/", pprof.Index)
+	debug.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debug.HandleFunc("GET /debug/state", s.DebugStateHandler)
+	s.mux.Handle("/debug/", requireToken(token, debug))
+}
+
+// DebugStateHandler reports the goroutine count, memory use, job statuses and
+// scheduler internals such as the runs waiting for a concurrency slot
+func (s *HTTPServer) DebugStateHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(debugState{
+		Goroutines: runtime.NumGoroutine(),
+		Memory: debugMemory{
+			HeapAlloc:   mem.HeapAlloc,
+			HeapInuse:   mem.HeapInuse,
+			HeapObjects: mem.HeapObjects,
+			Sys:         mem.Sys,
+			NumGC:       mem.NumGC,
+		},
+		Statuses:  s.statusTracker.GetAllStatuses(),
+		Scheduler: s.scheduler.DebugState(),
+	})
+}
+
+// requireToken rejects requests without the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="backmeup"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	srv := newListingServer(t)
+	assert.Equal(t, http.StatusNotFound, get(srv, "/debug/state").Code, "disabled by default")
+
+	srv.EnableDebug("s3cret")
+	request := func(target, authorization string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		srv.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong", "s3cret", "Basic czNjcmV0"} {
+		w := request("/debug/state", authorization)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, authorization)
+		assert.Equal(t, `Bearer realm="backmeup"`, w.Header().Get("WWW-Authenticate"))
+	}
+	assert.Equal(t, http.StatusUnauthorized, request("/debug/pprof/", "").Code)
+
+	w := request("/debug/state", "Bearer s3cret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var state debugState
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Positive(t, state.Goroutines)
+	assert.Positive(t, state.Memory.HeapAlloc)
+	assert.Equal(t, string(StatusError), state.Statuses["job3"])
+	require.Len(t, state.Scheduler.Jobs, 5)
+	assert.Equal(t, "job1", state.Scheduler.Jobs[0].Name)
+	assert.Empty(t, state.Scheduler.Queue.Waiting)
+
+	w = request("/debug/pprof/goroutine?debug=1", "Bearer s3cret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "goroutine profile")
+}
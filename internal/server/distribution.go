@@ -0,0 +1,74 @@
+package server
+
+import (
+	"slices"
+	"time"
+)
+
+// metricsWindow is how many of a job's last successful runs the window
+// statistics cover
+const metricsWindow = 100
+
+// Upper bounds of the Prometheus histogram buckets. Durations are in seconds
+// and sizes in bytes, from 1 MiB to 1 TiB by factors of four.
+var (
+	durationBounds = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 14400, 28800}
+	sizeBounds     = []float64{1 << 20, 1 << 22, 1 << 24, 1 << 26, 1 << 28, 1 << 30, 1 << 32, 1 << 34, 1 << 36, 1 << 38, 1 << 40}
+)
+
+// WindowStats summarizes a value over a job's last successful runs
+type WindowStats[T ~int64] struct {
+	Runs int `json:"runs"`
+	Min  T   `json:"min"`
+	Max  T   `json:"max"`
+	P50  T   `json:"p50"`
+	P95  T   `json:"p95"`
+}
+
+// newWindowStats computes the statistics of values, with nearest-rank
+// percentiles
+func newWindowStats[T ~int64](values []T) WindowStats[T] {
+	if len(values) == 0 {
+		return WindowStats[T]{}
+	}
+	sorted := slices.Sorted(slices.Values(values))
+	rank := func(p float64) T {
+		i := int(p*float64(len(sorted))+0.999999) - 1
+		return sorted[max(i, 0)]
+	}
+	return WindowStats[T]{Runs: len(sorted), Min: sorted[0], Max: sorted[len(sorted)-1], P50: rank(0.50), P95: rank(0.95)}
+}
+
+// histogram counts the observations of a value per bucket
+type histogram struct {
+	counts []int // Per bound, and a last count for values above every bound
+	sum    float64
+	count  int
+}
+
+// observe returns the histogram with a value added. The counts are copied
+// rather than changed in place, since GetAllJobMetrics hands them out.
+func (h histogram) observe(bounds []float64, v float64) histogram {
+	counts := slices.Clone(h.counts)
+	if counts == nil {
+		counts = make([]int, len(bounds)+1)
+	}
+	i, _ := slices.BinarySearch(bounds, v)
+	counts[i]++
+	return histogram{counts: counts, sum: h.sum + v, count: h.count + 1}
+}
+
+// recentRuns keeps the durations and sizes of a job's last successful runs
+type recentRuns struct {
+	durations []time.Duration
+	sizes     []int64
+}
+
+func (r *recentRuns) add(duration time.Duration, size int64) {
+	r.durations = append(r.durations, duration)
+	r.sizes = append(r.sizes, size)
+	if len(r.durations) > metricsWindow {
+		r.durations = slices.Delete(r.durations, 0, len(r.durations)-metricsWindow)
+		r.sizes = slices.Delete(r.sizes, 0, len(r.sizes)-metricsWindow)
+	}
+}
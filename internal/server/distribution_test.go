@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+func TestNewWindowStats(t *testing.T) {
+	assert.Equal(t, WindowStats[int64]{}, newWindowStats[int64](nil))
+	assert.Equal(t, WindowStats[int64]{Runs: 1, Min: 7, Max: 7, P50: 7, P95: 7}, newWindowStats([]int64{7}))
+
+	values := make([]int64, 0, 20)
+	for i := int64(20); i >= 1; i-- {
+		values = append(values, i)
+	}
+	assert.Equal(t, WindowStats[int64]{Runs: 20, Min: 1, Max: 20, P50: 10, P95: 19}, newWindowStats(values))
+	assert.Equal(t, int64(20), values[0], "values are not sorted in place")
+}
+
+func TestMetricsCollector_Window(t *testing.T) {
+	mc := NewMetricsCollector()
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	run := func(status string, duration time.Duration, size int64) {
+		mc.UpdateJobMetrics(history.Run{Job: "orders", Status: status, StartedAt: start, FinishedAt: start.Add(duration), Size: size}, nil)
+	}
+
+	for i := 1; i <= metricsWindow+10; i++ {
+		run(history.StatusSuccess, time.Duration(i)*time.Second, int64(i)<<20)
+	}
+	run(history.StatusFailed, time.Millisecond, 0)
+
+	m, _ := mc.GetJobMetrics("orders")
+	assert.Equal(t, WindowStats[time.Duration]{Runs: metricsWindow, Min: 11 * time.Second, Max: 110 * time.Second,
+		P50: 60 * time.Second, P95: 105 * time.Second}, m.DurationWindow, "only the last successful runs count")
+	assert.Equal(t, int64(11)<<20, m.SizeWindow.Min)
+	assert.Equal(t, metricsWindow+10, m.durationHistogram.count, "histograms cover every successful run")
+
+	w := httptest.NewRecorder()
+	mc.MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE backmeup_job_run_duration_seconds histogram",
+		`backmeup_job_run_duration_seconds_bucket{backup_job="orders",le="1"} 1`,
+		`backmeup_job_run_duration_seconds_bucket{backup_job="orders",le="60"} 60`,
+		`backmeup_job_run_duration_seconds_bucket{backup_job="orders",le="120"} 110`,
+		`backmeup_job_run_duration_seconds_bucket{backup_job="orders",le="+Inf"} 110`,
+		`backmeup_job_run_duration_seconds_sum{backup_job="orders"} 6105`,
+		`backmeup_job_run_duration_seconds_count{backup_job="orders"} 110`,
+		`backmeup_job_backup_size_bytes_bucket{backup_job="orders",le="1048576"} 1`,
+		`backmeup_job_backup_size_bytes_bucket{backup_job="orders",le="4194304"} 4`,
+		`backmeup_job_recent_run_duration_seconds{backup_job="orders",stat="p95"} 105`,
+		`backmeup_job_recent_backup_size_bytes{backup_job="orders",stat="min"} 11534336`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	mc.UpdateJobMetrics(history.Run{Job: "assets", Status: history.StatusFailed, StartedAt: start, FinishedAt: start}, nil)
+	w = httptest.NewRecorder()
+	mc.MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil))
+	body = w.Body.String()
+	assert.Contains(t, body, `backmeup_job_run_duration_seconds_count{backup_job="assets"} 0`+"\n")
+	assert.False(t, strings.Contains(body, `backmeup_job_recent_run_duration_seconds{backup_job="assets"`),
+		"no window before the first successful run")
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// freshnessResponse is the body returned by GET /jobs/{name}/freshness
+type freshnessResponse struct {
+	Job           string     `json:"job"`
+	Fresh         bool       `json:"fresh"`
+	NewestBackup  *time.Time `json:"newestBackup"` // null when the job has no backup
+	AgeSeconds    int64      `json:"ageSeconds,omitempty"`
+	MaxAgeSeconds int64      `json:"maxAgeSeconds"`
+}
+
+// FreshnessHandler reports the age of the job's newest successful backup. It
+// answers 200 while the backup is younger than the job's max_backup_age, or
+// the max_age query parameter, and 503 otherwise, so that uptime monitors can
+// alert on stale backups from the status code alone.
+func (s *HTTPServer) FreshnessHandler(w http.ResponseWriter, r *http.Request) {
+	jobName := r.PathValue("name")
+	jobConfig, ok := s.scheduler.JobConfig(jobName)
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	maxAge := jobConfig.MaxBackupAge
+	if value := r.URL.Query().Get("max_age"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "max_age must be a positive duration such as 26h")
+			return
+		}
+		maxAge = d
+	}
+	if maxAge == 0 {
+		maxAge = 2 * scheduleInterval(jobConfig)
+	}
+
+	newest, found, err := s.newestBackup(jobName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := freshnessResponse{Job: jobName, MaxAgeSeconds: int64(maxAge.Seconds())}
+	if found {
+		age := time.Since(newest)
+		resp.NewestBackup = &newest
+		resp.AgeSeconds = int64(age.Seconds())
+		resp.Fresh = age <= maxAge
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Fresh {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newestBackup returns when the job's last successful run finished, falling
+// back to the newest backup in storage for runs made before history was
+// recorded
+func (s *HTTPServer) newestBackup(jobName string) (time.Time, bool, error) {
+	if s.history != nil {
+		run, ok, err := s.history.LastSuccess(jobName)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if ok {
+			return run.FinishedAt, true, nil
+		}
+	}
+	return s.scheduler.NewestBackup(jobName)
+}
+
+// scheduleInterval returns the time between the job's next two runs, by any
+// of its schedules, or a day when no schedule can be parsed
+func scheduleInterval(jobConfig config.JobConfig) time.Duration {
+	next := jobConfig.NextRun(time.Now())
+	if next.IsZero() {
+		return 24 * time.Hour
+	}
+	return jobConfig.NextRun(next).Sub(next)
+}
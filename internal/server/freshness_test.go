@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func TestFreshnessHandler(t *testing.T) {
+	dir := t.TempDir()
+	js := scheduler.NewJobScheduler(config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: dir}}, config.SchedulerConfig{})
+	for _, jobConfig := range []config.JobConfig{
+		{Name: "orders", Schedule: "0 2 * * *", MaxBackupAge: 26 * time.Hour},
+		{Name: "users", Schedule: "0 * * * *"},
+	} {
+		require.NoError(t, js.AddJob(jobConfig, nopExecutor{}))
+	}
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+
+	get := func(path string) (int, freshnessResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp freshnessResponse
+		if w.Code != http.StatusNotFound && w.Code != http.StatusBadRequest {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	// Without history the newest backup in storage counts
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	backup := filepath.Join(jobDir, "pg_backup_1.sql")
+	require.NoError(t, os.WriteFile(backup, []byte("dump"), 0644))
+	modTime := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(backup, modTime, modTime))
+
+	code, resp := get("/jobs/orders/freshness")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Fresh)
+	assert.InDelta(t, 7200, resp.AgeSeconds, 5)
+	assert.Equal(t, int64(26*3600), resp.MaxAgeSeconds)
+
+	code, resp = get("/jobs/orders/freshness?max_age=1h")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Fresh)
+
+	// Jobs without backups are stale, the default max age is twice the schedule interval
+	code, resp = get("/jobs/users/freshness")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Nil(t, resp.NewestBackup)
+	assert.Equal(t, int64(2*3600), resp.MaxAgeSeconds)
+
+	// A recorded successful run takes precedence
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	require.NoError(t, err)
+	finished := time.Now().Add(-30 * time.Minute)
+	require.NoError(t, store.Append(history.Run{Job: "users", Status: history.StatusSuccess, StartedAt: finished.Add(-time.Minute), FinishedAt: finished}))
+	require.NoError(t, store.Append(history.Run{Job: "users", Status: history.StatusFailed, StartedAt: time.Now(), FinishedAt: time.Now()}))
+	srv.SetHistory(store)
+
+	code, resp = get("/jobs/users/freshness")
+	assert.Equal(t, http.StatusOK, code)
+	assert.InDelta(t, 1800, resp.AgeSeconds, 5)
+
+	code, _ = get("/jobs/missing/freshness")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/jobs/users/freshness?max_age=soon")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
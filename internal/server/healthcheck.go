@@ -1,11 +1,13 @@
 package server
 
 import (
-	"encoding/json"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
 	"github.com/thitiph0n/backmeup/internal/scheduler"
 )
 
@@ -16,23 +18,29 @@ type JobStatus string
 type JobStatusTracker struct {
 	mu                 sync.RWMutex
 	jobStatuses        map[string]JobStatus
+	destinations       map[string]map[string]JobStatus // Status of the last copy to each destination of a job
 	statusUpdated      time.Time
 	isSchedulerRunning bool
+	maintenance        func() scheduler.MaintenanceState
+	jobConfig          func(jobName string) (config.JobConfig, bool)
 }
 
 // Health statuses for jobs and scheduler
 const (
 	StatusRunning  JobStatus = "RUNNING"
 	StatusPending  JobStatus = "PENDING"
+	StatusQueued   JobStatus = "QUEUED"
 	StatusError    JobStatus = "ERROR"
 	StatusStopped  JobStatus = "STOPPED"
 	StatusComplete JobStatus = "COMPLETE"
+	StatusPartial  JobStatus = "PARTIAL"
 )
 
 // NewJobStatusTracker creates a new job status tracker
 func NewJobStatusTracker() *JobStatusTracker {
 	return &JobStatusTracker{
 		jobStatuses:        make(map[string]JobStatus),
+		destinations:       make(map[string]map[string]JobStatus),
 		statusUpdated:      time.Now(),
 		isSchedulerRunning: false,
 	}
@@ -47,6 +55,37 @@ func (jst *JobStatusTracker) UpdateJobStatus(jobName string, status JobStatus) {
 	jst.statusUpdated = time.Now()
 }
 
+// RemoveJob stops tracking a job that is no longer scheduled
+func (jst *JobStatusTracker) RemoveJob(jobName string) {
+	jst.mu.Lock()
+	defer jst.mu.Unlock()
+
+	delete(jst.jobStatuses, jobName)
+	delete(jst.destinations, jobName)
+	jst.statusUpdated = time.Now()
+}
+
+// UpdateDestinations records the outcome of a run's copies to the job's
+// storage destinations, as COMPLETE or ERROR for each
+func (jst *JobStatusTracker) UpdateDestinations(jobName string, copies []history.Artifact) {
+	if len(copies) == 0 {
+		return
+	}
+	statuses := make(map[string]JobStatus, len(copies))
+	for _, c := range copies {
+		statuses[c.Name] = StatusComplete
+		if c.Status == history.StatusFailed {
+			statuses[c.Name] = StatusError
+		}
+	}
+
+	jst.mu.Lock()
+	defer jst.mu.Unlock()
+
+	jst.destinations[jobName] = statuses
+	jst.statusUpdated = time.Now()
+}
+
 // SetSchedulerRunning sets the running state of the scheduler
 func (jst *JobStatusTracker) SetSchedulerRunning(isRunning bool) {
 	jst.mu.Lock()
@@ -57,29 +96,55 @@ func (jst *JobStatusTracker) SetSchedulerRunning(isRunning bool) {
 
 // GetAllStatuses returns the status of all jobs
 func (jst *JobStatusTracker) GetAllStatuses() map[string]string {
+	result := make(map[string]string)
+	for _, entry := range jst.summary() {
+		result[entry[0]] = entry[1]
+	}
+
 	jst.mu.RLock()
 	defer jst.mu.RUnlock()
 
-	// Create the initial response with scheduler status
-	result := make(map[string]string)
+	// Add all job statuses
+	for job, status := range jst.jobStatuses {
+		result[job] = string(status)
+	}
+	for job, statuses := range jst.destinations {
+		for name, status := range statuses {
+			result[destinationKey(job, name)] = string(status)
+		}
+	}
+
+	return result
+}
+
+// summary returns the scheduler and maintenance mode entries of the health
+// output as key and value pairs
+func (jst *JobStatusTracker) summary() [][2]string {
+	jst.mu.RLock()
+	defer jst.mu.RUnlock()
 
 	// Add scheduler status
+	entries := [][2]string{{"scheduler", string(StatusStopped)}}
 	if jst.isSchedulerRunning {
-		result["scheduler"] = string(StatusRunning)
-	} else {
-		result["scheduler"] = string(StatusStopped)
+		entries[0][1] = string(StatusRunning)
 	}
 
-	// Add all job statuses
-	for job, status := range jst.jobStatuses {
-		result[job] = string(status)
+	// Add maintenance mode, scheduled runs are skipped while it is active
+	if jst.maintenance != nil {
+		if state := jst.maintenance(); state.Active {
+			entries = append(entries, [2]string{"maintenance", "ACTIVE"})
+			if !state.Until.IsZero() {
+				entries = append(entries, [2]string{"maintenance_until", state.Until.Format(time.RFC3339)})
+			}
+		}
 	}
 
-	return result
+	return entries
 }
 
 // isHealthy returns true if the system is healthy
-// A healthy system has a running scheduler and no jobs in error state
+// A healthy system has a running scheduler and no jobs in error state,
+// missing artifacts from a partial run or lacking a copy on a destination
 func (jst *JobStatusTracker) isHealthy() bool {
 	jst.mu.RLock()
 	defer jst.mu.RUnlock()
@@ -89,18 +154,97 @@ func (jst *JobStatusTracker) isHealthy() bool {
 	}
 
 	for _, status := range jst.jobStatuses {
-		if status == StatusError {
+		if status == StatusError || status == StatusPartial {
 			return false
 		}
 	}
+	for _, statuses := range jst.destinations {
+		for _, status := range statuses {
+			if status == StatusError {
+				return false
+			}
+		}
+	}
 
 	return true
 }
 
-// HealthCheckHandler handles health check requests
+// destinationKey is the health output entry of a job's storage destination
+func destinationKey(jobName, destination string) string {
+	return jobName + "@" + destination
+}
+
+// destinationStatuses returns the statuses of a job's destinations as health
+// output entries, sorted by destination
+func (jst *JobStatusTracker) destinationStatuses(jobName string) [][2]string {
+	jst.mu.RLock()
+	defer jst.mu.RUnlock()
+
+	statuses := jst.destinations[jobName]
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([][2]string, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, [2]string{destinationKey(jobName, name), string(statuses[name])})
+	}
+	return entries
+}
+
+// status returns the last reported status of a job, PENDING if it has none
+func (jst *JobStatusTracker) status(jobName string) JobStatus {
+	jst.mu.RLock()
+	defer jst.mu.RUnlock()
+
+	if status, ok := jst.jobStatuses[jobName]; ok {
+		return status
+	}
+	return StatusPending
+}
+
+// jobNames returns the names of all tracked jobs in sorted order
+func (jst *JobStatusTracker) jobNames() []string {
+	jst.mu.RLock()
+	names := make([]string, 0, len(jst.jobStatuses))
+	for name := range jst.jobStatuses {
+		names = append(names, name)
+	}
+	jst.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// HealthCheckHandler handles health check requests. The response code always
+// reflects every job, while the body can be filtered by status, tag and type
+// and paginated with limit and after.
 func (jst *JobStatusTracker) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	// Set content type
-	w.Header().Set("Content-Type", "application/json")
+	q, err := parseListQuery(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	jst.mu.RLock()
+	jobConfig := jst.jobConfig
+	jst.mu.RUnlock()
+
+	page, next := q.page(jst.jobNames(), func(name string) bool {
+		var cfg *config.JobConfig
+		if jobConfig != nil {
+			if c, ok := jobConfig(name); ok {
+				cfg = &c
+			}
+		}
+		return q.matches(jst.status(name), cfg)
+	})
+	setNextLink(w, r, next)
+
+	enc := newStreamEncoder(w, q.yaml, false)
 
 	// Determine HTTP status code based on health status
 	if jst.isHealthy() {
@@ -109,14 +253,17 @@ func (jst *JobStatusTracker) HealthCheckHandler(w http.ResponseWriter, r *http.R
 		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 
-	// Encode job statuses as JSON
-	statuses := jst.GetAllStatuses()
-	if err := json.NewEncoder(w).Encode(statuses); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Failed to encode job statuses",
-		})
+	enc.begin()
+	for _, entry := range jst.summary() {
+		enc.field(entry[0], entry[1])
 	}
+	for _, name := range page {
+		enc.field(name, string(jst.status(name)))
+		for _, entry := range jst.destinationStatuses(name) {
+			enc.field(entry[0], entry[1])
+		}
+	}
+	enc.end()
 }
 
 // RegisterJobStatusUpdate registers a job status update function with a scheduler
@@ -124,8 +271,30 @@ func RegisterJobStatusUpdate(js *scheduler.JobScheduler, jst *JobStatusTracker)
 	// Set scheduler as running
 	jst.SetSchedulerRunning(true)
 
+	// Report the scheduler's maintenance mode in health output
+	jst.mu.Lock()
+	jst.maintenance = js.Maintenance
+	jst.jobConfig = js.JobConfig
+	jst.mu.Unlock()
+
+	// Report the copies to storage destinations next to their jobs
+	js.RegisterRunCallback(func(run history.Run) {
+		jst.UpdateDestinations(run.Job, run.Destinations)
+	})
+
 	// Register callback for job status updates
 	js.RegisterStatusCallback(func(jobName string, status string, timestamp time.Time) {
+		// Scheduler state is tracked separately
+		if jobName == "scheduler" {
+			return
+		}
+
+		// Discovered jobs that went away are dropped from health output
+		if status == scheduler.StatusRemoved {
+			jst.RemoveJob(jobName)
+			return
+		}
+
 		var jobStatus JobStatus
 
 		// Map scheduler status to our status enum
@@ -134,10 +303,14 @@ func RegisterJobStatusUpdate(js *scheduler.JobScheduler, jst *JobStatusTracker)
 			jobStatus = StatusRunning
 		case scheduler.StatusPending:
 			jobStatus = StatusPending
+		case scheduler.StatusQueued:
+			jobStatus = StatusQueued
 		case scheduler.StatusError:
 			jobStatus = StatusError
 		case scheduler.StatusComplete:
 			jobStatus = StatusComplete
+		case scheduler.StatusPartial:
+			jobStatus = StatusPartial
 		default:
 			jobStatus = StatusPending
 		}
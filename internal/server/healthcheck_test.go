@@ -5,8 +5,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
 )
 
 func TestHealthCheckHandler(t *testing.T) {
@@ -120,3 +123,60 @@ func (s *HealthCheckTestSuite) TestUnhealthySystemWithStoppedScheduler() {
 	// Check that scheduler status is included
 	s.Equal(string(StatusStopped), response["scheduler"])
 }
+
+// TestMaintenanceMode tests that an active maintenance mode is reported without failing the health check
+func (s *HealthCheckTestSuite) TestMaintenanceMode() {
+	until := time.Date(2030, 1, 1, 6, 0, 0, 0, time.UTC)
+
+	s.tracker.SetSchedulerRunning(true)
+	s.tracker.maintenance = func() scheduler.MaintenanceState {
+		return scheduler.MaintenanceState{Active: true, Until: until}
+	}
+	s.tracker.UpdateJobStatus("job1", StatusComplete)
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+
+	s.tracker.HealthCheckHandler(w, req)
+
+	s.Equal(http.StatusOK, w.Code)
+
+	var response map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	s.NoError(err)
+
+	s.Equal("ACTIVE", response["maintenance"])
+	s.Equal(until.Format(time.RFC3339), response["maintenance_until"])
+}
+
+// TestDestinations tests that the copies to storage destinations are reported next to their jobs
+func (s *HealthCheckTestSuite) TestDestinations() {
+	s.tracker.SetSchedulerRunning(true)
+	s.tracker.UpdateJobStatus("job1", StatusPartial)
+	s.tracker.UpdateDestinations("job1", []history.Artifact{
+		{Name: "nas", Status: history.StatusSuccess},
+		{Name: "offsite", Status: history.StatusFailed, Error: "connection refused"},
+	})
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	w := httptest.NewRecorder()
+	s.tracker.HealthCheckHandler(w, req)
+
+	s.Equal(http.StatusServiceUnavailable, w.Code)
+	var response map[string]string
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	s.Equal(string(StatusComplete), response["job1@nas"])
+	s.Equal(string(StatusError), response["job1@offsite"])
+
+	// The next copy to every destination makes the system healthy again
+	s.tracker.UpdateJobStatus("job1", StatusComplete)
+	s.tracker.UpdateDestinations("job1", []history.Artifact{
+		{Name: "nas", Status: history.StatusSuccess},
+		{Name: "offsite", Status: history.StatusSuccess},
+	})
+	w = httptest.NewRecorder()
+	s.tracker.HealthCheckHandler(w, req)
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal(map[string]string{"scheduler": "RUNNING", "job1": "COMPLETE", "job1@nas": "COMPLETE", "job1@offsite": "COMPLETE"},
+		s.tracker.GetAllStatuses())
+}
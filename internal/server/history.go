@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// SetHistory serves the run history on GET /history. It must be called
+// before Start.
+func (s *HTTPServer) SetHistory(store *history.Store) {
+	s.history = store
+}
+
+// HistoryHandler exports the run history as CSV or JSON. It accepts the
+// format, since and job query parameters of `backmeup history export`.
+func (s *HTTPServer) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeError(w, http.StatusServiceUnavailable, "run history is not recorded")
+		return
+	}
+
+	values := r.URL.Query()
+	format := values.Get("format")
+	if format == "" {
+		format = history.FormatJSON
+	}
+	if format != history.FormatCSV && format != history.FormatJSON {
+		writeError(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	since := values.Get("since")
+	if since == "" {
+		since = "30d"
+	}
+	from, err := history.ParseSince(since, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	runs, err := s.history.Since(from)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if format == history.FormatCSV {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"backmeup-history-%s.csv\"", time.Now().Format("20060102")))
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	history.Export(w, format, history.Filter(runs, values.Get("job")))
+}
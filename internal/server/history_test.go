@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	js := scheduler.NewJobScheduler(config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{})
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+
+	req := httptest.NewRequest(http.MethodGet, "/history", nil)
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	require.NoError(t, err)
+	now := time.Now().Truncate(time.Second)
+	for _, run := range []history.Run{
+		{Job: "orders", Status: history.StatusSuccess, StartedAt: now.AddDate(0, 0, -40), FinishedAt: now.AddDate(0, 0, -40)},
+		{Job: "orders", Status: history.StatusSuccess, StartedAt: now.Add(-time.Hour), FinishedAt: now, Size: 10},
+		{Job: "users", Status: history.StatusFailed, StartedAt: now.Add(-time.Hour), FinishedAt: now},
+	} {
+		require.NoError(t, store.Append(run))
+	}
+	srv.SetHistory(store)
+
+	req = httptest.NewRequest(http.MethodGet, "/history", nil)
+	w = httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var runs []history.Run
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &runs))
+	assert.Len(t, runs, 2, "runs older than 30 days are not exported by default")
+
+	req = httptest.NewRequest(http.MethodGet, "/history?format=csv&since=90d&job=orders", nil)
+	w = httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), 3)
+
+	req = httptest.NewRequest(http.MethodGet, "/history?since=soon", nil)
+	w = httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
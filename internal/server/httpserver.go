@@ -8,18 +8,28 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/redact"
 	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
 )
 
 // HTTPServer represents the HTTP server for BackMeUp
 type HTTPServer struct {
 	server           *http.Server
+	mux              *http.ServeMux
+	scheduler        *scheduler.JobScheduler
 	statusTracker    *JobStatusTracker
 	metricsCollector *MetricsCollector
+	storageMetrics   *storage.Metrics
+	history          *history.Store
+	buildInfo        buildinfo.Info
+	token            string // Bearer token of the endpoints that change the scheduler state
 }
 
 // NewHTTPServer creates a new HTTP server
-func NewHTTPServer(port int, jobScheduler *scheduler.JobScheduler) *HTTPServer {
+func NewHTTPServer(port int, jobScheduler *scheduler.JobScheduler, storageMetrics *storage.Metrics) *HTTPServer {
 	// Create a new status tracker
 	statusTracker := NewJobStatusTracker()
 
@@ -28,14 +38,22 @@ func NewHTTPServer(port int, jobScheduler *scheduler.JobScheduler) *HTTPServer {
 
 	// Register with the job scheduler to receive status updates
 	RegisterJobStatusUpdate(jobScheduler, statusTracker)
+	jobScheduler.RegisterRunCallback(func(run history.Run) {
+		jobConfig, _ := jobScheduler.JobConfig(run.Job)
+		metricsCollector.UpdateJobMetrics(run, jobConfig.Labels)
+	})
 
 	// Create a new HTTP server
 	mux := http.NewServeMux()
 
 	// Create the server
 	srv := &HTTPServer{
+		scheduler:        jobScheduler,
 		statusTracker:    statusTracker,
 		metricsCollector: metricsCollector,
+		storageMetrics:   storageMetrics,
+		buildInfo:        buildinfo.Get(),
+		mux:              mux,
 		server: &http.Server{
 			Addr:         fmt.Sprintf(":%d", port),
 			Handler:      mux,
@@ -48,10 +66,49 @@ func NewHTTPServer(port int, jobScheduler *scheduler.JobScheduler) *HTTPServer {
 	// Register routes
 	mux.HandleFunc("/health", statusTracker.HealthCheckHandler)
 	mux.HandleFunc("/metrics", metricsCollector.MetricsHandler)
+	mux.HandleFunc("/metrics/storage", srv.StorageMetricsHandler)
+	mux.HandleFunc("GET /maintenance", srv.MaintenanceHandler)
+	mux.Handle("POST /maintenance", srv.requireServerToken(srv.MaintenanceHandler))
+	mux.Handle("DELETE /maintenance", srv.requireServerToken(srv.MaintenanceHandler))
+	mux.HandleFunc("GET /jobs", srv.JobsHandler)
+	mux.HandleFunc("GET /jobs/{name}/retention/plan", srv.RetentionPlanHandler)
+	mux.HandleFunc("GET /jobs/{name}/freshness", srv.FreshnessHandler)
+	mux.Handle("POST /jobs/{name}/pause", srv.requireServerToken(srv.PauseHandler))
+	mux.Handle("DELETE /jobs/{name}/pause", srv.requireServerToken(srv.PauseHandler))
+	mux.Handle("POST /jobs/{name}/runs/scheduled", srv.requireServerToken(srv.ScheduleOneOffHandler))
+	mux.HandleFunc("GET /runs", srv.RunsHandler)
+	mux.HandleFunc("GET /runs/scheduled", srv.OneOffRunsHandler)
+	mux.Handle("DELETE /runs/scheduled/{id}", srv.requireServerToken(srv.CancelOneOffHandler))
+	mux.HandleFunc("GET /history", srv.HistoryHandler)
+	mux.HandleFunc("GET /version", srv.VersionHandler)
 
 	return srv
 }
 
+// SetToken sets the bearer token that the endpoints changing the scheduler
+// state require. Without one, those endpoints are refused. It must be called
+// before Start.
+func (s *HTTPServer) SetToken(token string) {
+	s.token = token
+}
+
+// requireServerToken refuses the request unless it bears the server token
+func (s *HTTPServer) requireServerToken(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusForbidden, "server.token must be configured to use this endpoint")
+			return
+		}
+		requireToken(s.token, next).ServeHTTP(w, r)
+	})
+}
+
+// RedactResponses masks secrets in every response body. It must be called
+// before Start.
+func (s *HTTPServer) RedactResponses(redactor *redact.Redactor) {
+	s.server.Handler = redactor.Handler(s.server.Handler)
+}
+
 // Start starts the HTTP server
 func (s *HTTPServer) Start() error {
 	log.Printf("Starting HTTP server on %s", s.server.Addr)
@@ -74,10 +131,14 @@ func (s *HTTPServer) UpdateJobStatus(jobName string, status string) {
 		jobStatus = StatusRunning
 	case "PENDING":
 		jobStatus = StatusPending
+	case "QUEUED":
+		jobStatus = StatusQueued
 	case "ERROR":
 		jobStatus = StatusError
 	case "COMPLETE":
 		jobStatus = StatusComplete
+	case "PARTIAL":
+		jobStatus = StatusPartial
 	case "STOPPED":
 		jobStatus = StatusStopped
 	default:
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// flushEvery is the number of entries written between flushes of a streamed
+// response
+const flushEvery = 100
+
+// listQuery holds the filters and pagination accepted by /health and /jobs
+type listQuery struct {
+	status  string
+	tag     string
+	jobType string
+	after   string // Only jobs sorted after this name are listed
+	limit   int    // Page size, 0 lists every matching job
+	yaml    bool
+}
+
+func parseListQuery(r *http.Request) (listQuery, error) {
+	values := r.URL.Query()
+
+	q := listQuery{
+		status:  strings.ToUpper(values.Get("status")),
+		tag:     values.Get("tag"),
+		jobType: values.Get("type"),
+		after:   values.Get("after"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return listQuery{}, fmt.Errorf("limit must be a non-negative integer")
+		}
+		q.limit = n
+	}
+
+	switch values.Get("format") {
+	case "", "json":
+		q.yaml = values.Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "yaml")
+	case "yaml":
+		q.yaml = true
+	default:
+		return listQuery{}, fmt.Errorf("format must be json or yaml")
+	}
+
+	return q, nil
+}
+
+// matches applies the query's filters to a job. jobConfig is nil for jobs
+// whose configuration is unknown, which never match a tag or type filter.
+func (q listQuery) matches(status JobStatus, jobConfig *config.JobConfig) bool {
+	if q.status != "" && string(status) != q.status {
+		return false
+	}
+	if q.tag != "" && (jobConfig == nil || !slices.Contains(jobConfig.Tags, q.tag)) {
+		return false
+	}
+	if q.jobType != "" && (jobConfig == nil || jobConfig.Type != q.jobType) {
+		return false
+	}
+	return true
+}
+
+// page returns the sorted names on the requested page and the cursor of the
+// next page, or an empty cursor on the last page
+func (q listQuery) page(names []string, match func(name string) bool) ([]string, string) {
+	start, _ := slices.BinarySearch(names, q.after)
+	if q.after != "" && start < len(names) && names[start] == q.after {
+		start++
+	}
+
+	var page []string
+	for _, name := range names[start:] {
+		if !match(name) {
+			continue
+		}
+		if q.limit > 0 && len(page) == q.limit {
+			return page, page[len(page)-1]
+		}
+		page = append(page, name)
+	}
+	return page, ""
+}
+
+// setNextLink advertises the next page in a Link header
+func setNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	u := *r.URL
+	values := u.Query()
+	values.Set("after", next)
+	u.RawQuery = values.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.RequestURI()))
+}
+
+// streamEncoder writes a JSON or YAML object or list one entry at a time, so
+// large listings are never held in memory as a whole
+type streamEncoder struct {
+	w       *bufio.Writer
+	flusher http.Flusher
+	yaml    bool
+	list    bool
+	entries int
+}
+
+func newStreamEncoder(w http.ResponseWriter, yamlOutput, list bool) *streamEncoder {
+	if yamlOutput {
+		w.Header().Set("Content-Type", "application/yaml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	flusher, _ := w.(http.Flusher)
+	return &streamEncoder{w: bufio.NewWriter(w), flusher: flusher, yaml: yamlOutput, list: list}
+}
+
+// begin opens the JSON object or array
+func (e *streamEncoder) begin() {
+	switch {
+	case e.yaml:
+	case e.list:
+		e.w.WriteString("[")
+	default:
+		e.w.WriteString("{")
+	}
+}
+
+// field writes one key and string value of an object. JSON quoted strings are
+// valid YAML scalars, so both formats share the quoting.
+func (e *streamEncoder) field(key, value string) {
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	if e.yaml {
+		fmt.Fprintf(e.w, "%s: %s\n", k, v)
+	} else {
+		e.separate()
+		fmt.Fprintf(e.w, "%s:%s", k, v)
+	}
+	e.entryWritten()
+}
+
+// item writes one job as a list element
+func (e *streamEncoder) item(job jobSummary) {
+	if e.yaml {
+		data, _ := yaml.Marshal(job)
+		lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		e.w.WriteString("- " + lines[0] + "\n")
+		for _, line := range lines[1:] {
+			e.w.WriteString("  " + line + "\n")
+		}
+	} else {
+		data, _ := json.Marshal(job)
+		e.separate()
+		e.w.Write(data)
+	}
+	e.entryWritten()
+}
+
+// end closes the JSON object or array and flushes the response
+func (e *streamEncoder) end() error {
+	switch {
+	case e.yaml && e.list && e.entries == 0:
+		e.w.WriteString("[]\n")
+	case e.yaml && e.entries == 0:
+		e.w.WriteString("{}\n")
+	case e.yaml:
+	case e.list:
+		e.w.WriteString("]\n")
+	default:
+		e.w.WriteString("}\n")
+	}
+	return e.w.Flush()
+}
+
+func (e *streamEncoder) separate() {
+	if e.entries > 0 {
+		e.w.WriteString(",")
+	}
+}
+
+func (e *streamEncoder) entryWritten() {
+	e.entries++
+	if e.entries%flushEvery == 0 {
+		e.w.Flush()
+		if e.flusher != nil {
+			e.flusher.Flush()
+		}
+	}
+}
+
+// jobSummary is one entry of the /jobs listing
+type jobSummary struct {
+	Name        string                 `json:"name" yaml:"name"`
+	Type        string                 `json:"type" yaml:"type"`
+	Description string                 `json:"description,omitempty" yaml:"description,omitempty"`
+	Schedule    string                 `json:"schedule" yaml:"schedule"`
+	Schedules   []config.ScheduleEntry `json:"schedules,omitempty" yaml:"schedules,omitempty"` // Every schedule of jobs with several
+	Tags        []string               `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Status      string                 `json:"status" yaml:"status"`
+	ErrorCode   string                 `json:"errorCode,omitempty" yaml:"errorCode,omitempty"`     // Class of the last run's failure
+	Run         *runProgress           `json:"run,omitempty" yaml:"run,omitempty"`                 // Only set while the job runs
+	PausedUntil *time.Time             `json:"pausedUntil,omitempty" yaml:"pausedUntil,omitempty"` // Only set while scheduled runs are paused
+}
+
+// runProgress is the progress of a running job, estimated from its history
+type runProgress struct {
+	ID                  string     `json:"id,omitempty" yaml:"id,omitempty"`
+	StartedAt           time.Time  `json:"startedAt" yaml:"startedAt"`
+	ElapsedSeconds      int64      `json:"elapsedSeconds" yaml:"elapsedSeconds"`
+	TypicalSeconds      int64      `json:"typicalSeconds,omitempty" yaml:"typicalSeconds,omitempty"`
+	P95Seconds          int64      `json:"p95Seconds,omitempty" yaml:"p95Seconds,omitempty"`
+	EstimatedCompletion *time.Time `json:"estimatedCompletion,omitempty" yaml:"estimatedCompletion,omitempty"`
+	RemainingSeconds    int64      `json:"remainingSeconds,omitempty" yaml:"remainingSeconds,omitempty"`
+	Overdue             bool       `json:"overdue,omitempty" yaml:"overdue,omitempty"` // Running for longer than the p95 duration
+}
+
+// newRunProgress converts the scheduler's estimate for a running job
+func newRunProgress(estimate scheduler.RunEstimate, now time.Time) *runProgress {
+	progress := &runProgress{
+		ID:             estimate.RunID,
+		StartedAt:      estimate.StartedAt,
+		ElapsedSeconds: int64(now.Sub(estimate.StartedAt).Seconds()),
+		TypicalSeconds: int64(estimate.Typical.Seconds()),
+		P95Seconds:     int64(estimate.P95.Seconds()),
+		Overdue:        estimate.Overdue,
+	}
+	if !estimate.EstimatedCompletion.IsZero() {
+		completion := estimate.EstimatedCompletion
+		progress.EstimatedCompletion = &completion
+		progress.RemainingSeconds = max(int64(completion.Sub(now).Seconds()), 0)
+	}
+	return progress
+}
+
+// JobsHandler streams the scheduled jobs with their current status. It
+// accepts the status, tag and type filters and limit/after pagination.
+func (s *HTTPServer) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, next := q.page(s.scheduler.JobNames(), func(name string) bool {
+		jobConfig, ok := s.scheduler.JobConfig(name)
+		if !ok {
+			return false
+		}
+		return q.matches(s.statusTracker.status(name), &jobConfig)
+	})
+	setNextLink(w, r, next)
+
+	enc := newStreamEncoder(w, q.yaml, true)
+	enc.begin()
+	for _, name := range page {
+		jobConfig, ok := s.scheduler.JobConfig(name)
+		if !ok {
+			continue
+		}
+		summary := jobSummary{
+			Name:        jobConfig.Name,
+			Type:        jobConfig.Type,
+			Description: jobConfig.Description,
+			Schedule:    jobConfig.Schedule,
+			Schedules:   jobConfig.Schedules,
+			Tags:        jobConfig.Tags,
+			Status:      string(s.statusTracker.status(name)),
+		}
+		if metrics, ok := s.metricsCollector.GetJobMetrics(name); ok {
+			summary.ErrorCode = string(metrics.LastErrorCode)
+		}
+		if estimate, ok := s.scheduler.RunEstimate(name); ok {
+			summary.Run = newRunProgress(estimate, time.Now())
+		}
+		if until, paused := s.scheduler.PausedUntil(name); paused {
+			summary.PausedUntil = &until
+		}
+		enc.item(summary)
+	}
+	enc.end()
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func newListingServer(t *testing.T) *HTTPServer {
+	t.Helper()
+
+	js := scheduler.NewJobScheduler(
+		config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: t.TempDir()}},
+		config.SchedulerConfig{},
+	)
+	for i := 1; i <= 5; i++ {
+		job := config.JobConfig{
+			Name:            fmt.Sprintf("job%d", i),
+			Type:            "postgres",
+			Schedule:        "0 2 * * *",
+			RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1},
+		}
+		if i%2 == 1 {
+			job.Tags = []string{"db"}
+		}
+		require.NoError(t, js.AddJob(job, nopExecutor{}))
+	}
+
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+	srv.statusTracker.UpdateJobStatus("job3", StatusError)
+	return srv
+}
+
+func get(srv *HTTPServer, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestJobsHandler_Filters(t *testing.T) {
+	srv := newListingServer(t)
+
+	w := get(srv, "/jobs?tag=db")
+	require.Equal(t, http.StatusOK, w.Code)
+	var jobs []jobSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 3)
+	assert.Equal(t, "job1", jobs[0].Name)
+	assert.Equal(t, []string{"db"}, jobs[0].Tags)
+	assert.Equal(t, "PENDING", jobs[0].Status)
+
+	w = get(srv, "/jobs?status=error")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "job3", jobs[0].Name)
+
+	w = get(srv, "/jobs?type=mysql")
+	assert.Equal(t, "[]\n", w.Body.String())
+}
+
+func TestJobsHandler_ErrorCode(t *testing.T) {
+	srv := newListingServer(t)
+	job, _ := srv.scheduler.JobConfig("job2")
+	require.NoError(t, srv.scheduler.RemoveJob("job2"))
+	require.NoError(t, srv.scheduler.AddJob(job, &backuptest.Executor{Err: &failure.ConnectionError{Err: errors.New("connection refused")}}))
+
+	_, err := srv.scheduler.RunNow("job2")
+	require.Error(t, err)
+
+	w := get(srv, "/jobs?status=error")
+	var jobs []jobSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "job2", jobs[0].Name)
+	assert.Equal(t, "connection", jobs[0].ErrorCode)
+
+	metrics, ok := srv.metricsCollector.GetJobMetrics("job2")
+	require.True(t, ok)
+	assert.Equal(t, 1, metrics.FailedRuns)
+	assert.Equal(t, map[failure.Code]int{failure.CodeConnection: 1}, metrics.FailuresByCode)
+}
+
+func TestJobsHandler_Pagination(t *testing.T) {
+	srv := newListingServer(t)
+
+	var names []string
+	target := "/jobs?limit=2"
+	for pages := 0; target != ""; pages++ {
+		require.Less(t, pages, 5)
+
+		w := get(srv, target)
+		require.Equal(t, http.StatusOK, w.Code)
+		var jobs []jobSummary
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+		for _, job := range jobs {
+			names = append(names, job.Name)
+		}
+
+		target = ""
+		if link := w.Header().Get("Link"); link != "" {
+			target = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+	}
+
+	assert.Equal(t, []string{"job1", "job2", "job3", "job4", "job5"}, names)
+}
+
+func TestJobsHandler_YAML(t *testing.T) {
+	srv := newListingServer(t)
+
+	w := get(srv, "/jobs?format=yaml&limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+
+	var jobs []jobSummary
+	require.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "job2", jobs[1].Name)
+}
+
+func TestJobsHandler_InvalidQuery(t *testing.T) {
+	srv := newListingServer(t)
+
+	assert.Equal(t, http.StatusBadRequest, get(srv, "/jobs?limit=-1").Code)
+	assert.Equal(t, http.StatusBadRequest, get(srv, "/jobs?format=xml").Code)
+}
+
+func TestHealthCheckHandler_Filters(t *testing.T) {
+	srv := newListingServer(t)
+
+	w := get(srv, "/health?status=ERROR")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "the status code reflects every job")
+	var statuses map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &statuses))
+	assert.Equal(t, map[string]string{"scheduler": "RUNNING", "job3": "ERROR"}, statuses)
+
+	w = get(srv, "/health?tag=db&limit=1&after=job1")
+	statuses = nil
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &statuses))
+	assert.Equal(t, map[string]string{"scheduler": "RUNNING", "job3": "ERROR"}, statuses)
+	assert.Equal(t, `</health?after=job3&limit=1&tag=db>; rel="next"`, w.Header().Get("Link"))
+
+	w = get(srv, "/health?format=yaml")
+	var all map[string]string
+	require.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &all))
+	assert.Len(t, all, 6)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// maintenanceRequest is the body accepted by POST /maintenance
+type maintenanceRequest struct {
+	TTL    string `json:"ttl,omitempty"` // Go duration, e.g. "2h"; empty means until lifted
+	Reason string `json:"reason,omitempty"`
+}
+
+// MaintenanceHandler reports (GET), enables (POST) or lifts (DELETE) the
+// global maintenance mode
+func (s *HTTPServer) MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var state scheduler.MaintenanceState
+
+	switch r.Method {
+	case http.MethodGet:
+		state = s.scheduler.Maintenance()
+	case http.MethodPost:
+		var req maintenanceRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+		}
+
+		var ttl time.Duration
+		if req.TTL != "" {
+			parsed, err := time.ParseDuration(req.TTL)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "ttl must be a positive duration such as 30m or 2h")
+				return
+			}
+			ttl = parsed
+		}
+
+		state = s.scheduler.EnterMaintenance(ttl, req.Reason)
+	case http.MethodDelete:
+		s.scheduler.ExitMaintenance()
+		state = s.scheduler.Maintenance()
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	json.NewEncoder(w).Encode(state)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": message,
+	})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+func TestMaintenanceHandler_RequiresToken(t *testing.T) {
+	srv := newListingServer(t)
+	request := func(method, authorization string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/maintenance", strings.NewReader(`{"reason": "storage upgrade"}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		srv.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "Bearer s3cret").Code, "refused without a configured token")
+	assert.False(t, srv.scheduler.Maintenance().Active)
+
+	srv.SetToken("s3cret")
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		w := request(method, "Bearer wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, method)
+		assert.Equal(t, `Bearer realm="backmeup"`, w.Header().Get("WWW-Authenticate"))
+	}
+	assert.False(t, srv.scheduler.Maintenance().Active)
+
+	w := request(http.MethodPost, "Bearer s3cret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var state scheduler.MaintenanceState
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.True(t, state.Active)
+	assert.Equal(t, "storage upgrade", state.Reason)
+
+	w = get(srv, "/maintenance")
+	require.Equal(t, http.StatusOK, w.Code, "reading the state needs no token")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.True(t, state.Active)
+
+	require.Equal(t, http.StatusOK, request(http.MethodDelete, "Bearer s3cret").Code)
+	assert.False(t, srv.scheduler.Maintenance().Active)
+}
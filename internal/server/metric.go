@@ -2,9 +2,13 @@ package server
 
 import (
 	"encoding/json"
+	"maps"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/history"
 )
 
 // JobMetrics stores metrics for a job
@@ -14,29 +18,53 @@ type JobMetrics struct {
 	TotalRuns          int           `json:"totalRuns"`
 	SuccessfulRuns     int           `json:"successfulRuns"`
 	FailedRuns         int           `json:"failedRuns"`
+	PartialRuns        int           `json:"partialRuns"` // Runs that wrote only some of their artifacts
 	LastRunTime        time.Time     `json:"lastRunTime"`
+	LastRunID          string        `json:"lastRunId,omitempty"`
 	TotalBackupSize    int64         `json:"totalBackupSize"`
 	LastBackupSize     int64         `json:"lastBackupSize"`
+	LastErrorCode      failure.Code  `json:"lastErrorCode,omitempty"` // Empty when the last run succeeded
+
+	// Static labels of the job from its configuration
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// FailedRuns broken down by the class of the failure
+	FailuresByCode map[failure.Code]int `json:"failuresByCode,omitempty"`
+
+	// Spread of the durations and sizes over the last successful runs, where
+	// a regression shows long before it moves the average
+	DurationWindow WindowStats[time.Duration] `json:"durationWindow"`
+	SizeWindow     WindowStats[int64]         `json:"sizeWindow"`
+
+	// Histograms of every successful run, for the Prometheus format
+	durationHistogram histogram
+	sizeHistogram     histogram
 }
 
 // MetricsCollector collects metrics for jobs
 type MetricsCollector struct {
 	mu      sync.RWMutex
 	metrics map[string]JobMetrics
+	recent  map[string]*recentRuns
 }
 
 // NewMetricsCollector creates a new metrics collector
 func NewMetricsCollector() *MetricsCollector {
 	return &MetricsCollector{
 		metrics: make(map[string]JobMetrics),
+		recent:  make(map[string]*recentRuns),
 	}
 }
 
-// UpdateJobMetrics updates metrics for a job run
-func (mc *MetricsCollector) UpdateJobMetrics(jobName string, duration time.Duration, success bool, backupSize int64) {
+// UpdateJobMetrics updates metrics for a finished run of a job with the
+// given labels
+func (mc *MetricsCollector) UpdateJobMetrics(run history.Run, labels map[string]string) {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
+	jobName, duration, backupSize := run.Job, run.Duration(), run.Size
+	errorCode := failure.Code(run.ErrorCode)
+
 	// Get existing metrics or create new
 	metrics, exists := mc.metrics[jobName]
 	if !exists {
@@ -47,14 +75,28 @@ func (mc *MetricsCollector) UpdateJobMetrics(jobName string, duration time.Durat
 	metrics.LastRunDuration = duration
 	metrics.TotalRuns++
 	metrics.LastRunTime = time.Now()
+	metrics.LastRunID = run.ID
 	metrics.LastBackupSize = backupSize
 	metrics.TotalBackupSize += backupSize
+	metrics.LastErrorCode = errorCode
+	metrics.Labels = maps.Clone(labels)
 
-	// Update success/failure counts
-	if success {
+	// Update success/failure counts. The breakdown is copied rather than
+	// changed in place, since GetAllJobMetrics hands it out.
+	switch run.Status {
+	case history.StatusSuccess:
 		metrics.SuccessfulRuns++
-	} else {
+		mc.observe(&metrics, jobName, duration, backupSize)
+	case history.StatusPartial:
+		metrics.PartialRuns++
+	default:
 		metrics.FailedRuns++
+		failures := maps.Clone(metrics.FailuresByCode)
+		if failures == nil {
+			failures = make(map[failure.Code]int)
+		}
+		failures[errorCode]++
+		metrics.FailuresByCode = failures
 	}
 
 	// Calculate average run duration
@@ -66,6 +108,21 @@ func (mc *MetricsCollector) UpdateJobMetrics(jobName string, duration time.Durat
 	mc.metrics[jobName] = metrics
 }
 
+// observe adds a successful run to the window and histograms of a job. Failed
+// runs stay out, as they stop early and would hide a slowdown.
+func (mc *MetricsCollector) observe(metrics *JobMetrics, jobName string, duration time.Duration, size int64) {
+	recent, exists := mc.recent[jobName]
+	if !exists {
+		recent = &recentRuns{}
+		mc.recent[jobName] = recent
+	}
+	recent.add(duration, size)
+	metrics.DurationWindow = newWindowStats(recent.durations)
+	metrics.SizeWindow = newWindowStats(recent.sizes)
+	metrics.durationHistogram = metrics.durationHistogram.observe(durationBounds, duration.Seconds())
+	metrics.sizeHistogram = metrics.sizeHistogram.observe(sizeBounds, float64(size))
+}
+
 // GetJobMetrics returns metrics for a specific job
 func (mc *MetricsCollector) GetJobMetrics(jobName string) (JobMetrics, bool) {
 	mc.mu.RLock()
@@ -89,8 +146,14 @@ func (mc *MetricsCollector) GetAllJobMetrics() map[string]JobMetrics {
 	return result
 }
 
-// MetricsHandler handles requests for metrics
+// MetricsHandler handles requests for metrics, in the Prometheus text format
+// for scrapers and as JSON otherwise
 func (mc *MetricsCollector) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsPrometheus(r) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		mc.writePrometheus(w)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 
 	metrics := mc.GetAllJobMetrics()
@@ -101,3 +164,16 @@ func (mc *MetricsCollector) MetricsHandler(w http.ResponseWriter, r *http.Reques
 		})
 	}
 }
+
+// StorageMetricsHandler handles requests for storage backend metrics
+func (s *HTTPServer) StorageMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	metrics := s.storageMetrics.GetAll()
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": "Failed to encode metrics",
+		})
+	}
+}
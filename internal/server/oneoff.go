@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// oneOffRequest is the body accepted by POST /jobs/{name}/runs/scheduled
+type oneOffRequest struct {
+	At time.Time `json:"at"` // RFC 3339
+}
+
+// OneOffRunsHandler lists the pending one-off runs
+func (s *HTTPServer) OneOffRunsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.scheduler.OneOffRuns())
+}
+
+// ScheduleOneOffHandler plans a single run of a job at a given time
+func (s *HTTPServer) ScheduleOneOffHandler(w http.ResponseWriter, r *http.Request) {
+	jobName := r.PathValue("name")
+
+	var req oneOffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "at must be an RFC 3339 time such as 2024-07-01T02:00:00Z")
+		return
+	}
+	if req.At.IsZero() {
+		writeError(w, http.StatusBadRequest, "at is required")
+		return
+	}
+
+	run, err := s.scheduler.ScheduleOnce(jobName, req.At)
+	if errors.Is(err, scheduler.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, "job not found: "+jobName)
+		return
+	}
+	if errors.Is(err, scheduler.ErrOneOffRunPassed) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(run)
+}
+
+// CancelOneOffHandler drops a pending one-off run
+func (s *HTTPServer) CancelOneOffHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	if err := s.scheduler.CancelOneOffRun(id); err != nil {
+		writeError(w, http.StatusNotFound, "one-off run not found: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
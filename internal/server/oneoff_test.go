@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func TestOneOffRunHandlers(t *testing.T) {
+	js := scheduler.NewJobScheduler(
+		config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: t.TempDir()}},
+		config.SchedulerConfig{},
+	)
+	defer js.Stop()
+	require.NoError(t, js.AddJob(config.JobConfig{
+		Name:            "orders",
+		Schedule:        "0 2 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1},
+	}, nopExecutor{}))
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+	srv.SetToken("s3cret")
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		srv.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	at := time.Now().Add(time.Hour).Truncate(time.Second)
+	w := serve(http.MethodPost, "/jobs/orders/runs/scheduled", `{"at": "`+at.Format(time.RFC3339)+`"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var run scheduler.OneOffRun
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &run))
+	assert.Equal(t, "orders", run.Job)
+	assert.True(t, at.Equal(run.At))
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/jobs/users/runs/scheduled", `{"at": "`+at.Format(time.RFC3339)+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/jobs/orders/runs/scheduled", `{"at": "tomorrow"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/jobs/orders/runs/scheduled", `{}`).Code)
+	w = serve(http.MethodPost, "/jobs/orders/runs/scheduled", `{"at": "2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "must be in the future")
+
+	w = serve(http.MethodGet, "/runs/scheduled", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var runs []scheduler.OneOffRun
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &runs))
+	require.Len(t, runs, 1)
+	assert.Equal(t, run.ID, runs[0].ID)
+
+	unauthorized := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(unauthorized, httptest.NewRequest(http.MethodDelete, "/runs/scheduled/"+run.ID, nil))
+	assert.Equal(t, http.StatusUnauthorized, unauthorized.Code)
+	unauthorized = httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(unauthorized, httptest.NewRequest(http.MethodPost, "/jobs/orders/runs/scheduled", strings.NewReader(`{"at": "`+at.Format(time.RFC3339)+`"}`)))
+	assert.Equal(t, http.StatusUnauthorized, unauthorized.Code)
+	assert.Len(t, js.OneOffRuns(), 1, "unchanged without the token")
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/runs/scheduled/"+run.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/runs/scheduled/"+run.ID, "").Code)
+	assert.Equal(t, "[]\n", serve(http.MethodGet, "/runs/scheduled", "").Body.String())
+}
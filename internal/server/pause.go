@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+)
+
+// pauseRequest is the body accepted by POST /jobs/{name}/pause
+type pauseRequest struct {
+	Until string `json:"until"` // A date such as 2024-08-15, or a date and time
+}
+
+// pauseResponse is the body returned by /jobs/{name}/pause
+type pauseResponse struct {
+	Job         string     `json:"job"`
+	Paused      bool       `json:"paused"`
+	PausedUntil *time.Time `json:"pausedUntil,omitempty"`
+}
+
+// PauseHandler pauses the scheduled runs of a job until a given time (POST)
+// or resumes them (DELETE)
+func (s *HTTPServer) PauseHandler(w http.ResponseWriter, r *http.Request) {
+	jobName := r.PathValue("name")
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		var req pauseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Until == "" {
+			writeError(w, http.StatusBadRequest, "until is required, such as 2024-08-15")
+			return
+		}
+		until, parseErr := config.ParsePauseUntil(req.Until)
+		if parseErr != nil {
+			writeError(w, http.StatusBadRequest, parseErr.Error())
+			return
+		}
+		err = s.scheduler.PauseJob(jobName, until)
+	case http.MethodDelete:
+		err = s.scheduler.ResumeJob(jobName)
+	}
+	if errors.Is(err, scheduler.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, "job not found: "+jobName)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response := pauseResponse{Job: jobName}
+	if until, paused := s.scheduler.PausedUntil(jobName); paused {
+		response.Paused = true
+		response.PausedUntil = &until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
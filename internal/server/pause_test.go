@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func TestPauseHandler(t *testing.T) {
+	js := scheduler.NewJobScheduler(
+		config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: t.TempDir()}},
+		config.SchedulerConfig{},
+	)
+	require.NoError(t, js.AddJob(config.JobConfig{
+		Name:            "orders",
+		Schedule:        "0 2 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1},
+	}, nopExecutor{}))
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+	srv.SetToken("s3cret")
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		srv.server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPost, "/jobs/orders/pause", `{"until": "2099-08-15"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response pauseResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Paused)
+	assert.True(t, time.Date(2099, 8, 15, 0, 0, 0, 0, time.Local).Equal(*response.PausedUntil))
+
+	var jobs []jobSummary
+	require.NoError(t, json.Unmarshal(serve(http.MethodGet, "/jobs", "").Body.Bytes(), &jobs))
+	require.Len(t, jobs, 1)
+	require.NotNil(t, jobs[0].PausedUntil)
+	assert.True(t, response.PausedUntil.Equal(*jobs[0].PausedUntil))
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/jobs/users/pause", `{"until": "2099-08-15"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/jobs/orders/pause", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/jobs/orders/pause", `{"until": "soon"}`).Code)
+	w = serve(http.MethodPost, "/jobs/orders/pause", `{"until": "2020-01-01"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "must end in the future")
+
+	w = serve(http.MethodDelete, "/jobs/orders/pause", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"job": "orders", "paused": false}`, w.Body.String())
+
+	unauthorized := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(unauthorized, httptest.NewRequest(http.MethodPost, "/jobs/orders/pause", strings.NewReader(`{"until": "2099-08-15"}`)))
+	assert.Equal(t, http.StatusUnauthorized, unauthorized.Code)
+	_, paused := js.PausedUntil("orders")
+	assert.False(t, paused, "not paused without the token")
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/history"
+)
+
+// prometheusContentType is the version 0.0.4 text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// wantsPrometheus reports whether a request asks for the text format, which
+// Prometheus does through its Accept header
+func wantsPrometheus(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "prometheus"
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text")
+}
+
+// metricFamily is one metric of the text format with a sample per job
+type metricFamily struct {
+	name, kind, help string
+	samples          func(m JobMetrics) []sample
+}
+
+// sample is a value with the labels it adds to the job's. Histograms give
+// their samples the _bucket, _sum and _count suffixes of the family name.
+type sample struct {
+	suffix string
+	labels [][2]string
+	value  float64
+}
+
+func value(v float64) []sample {
+	return []sample{{value: v}}
+}
+
+var metricFamilies = []metricFamily{
+	{"backmeup_job_runs_total", "counter", "Finished runs by status", func(m JobMetrics) []sample {
+		return []sample{
+			{labels: [][2]string{{"status", history.StatusSuccess}}, value: float64(m.SuccessfulRuns)},
+			{labels: [][2]string{{"status", history.StatusFailed}}, value: float64(m.FailedRuns)},
+			{labels: [][2]string{{"status", history.StatusPartial}}, value: float64(m.PartialRuns)},
+		}
+	}},
+	{"backmeup_job_failures_total", "counter", "Failed runs by the class of the failure", func(m JobMetrics) []sample {
+		var samples []sample
+		for _, code := range slices.Sorted(maps.Keys(m.FailuresByCode)) {
+			samples = append(samples, sample{labels: [][2]string{{"code", string(code)}}, value: float64(m.FailuresByCode[code])})
+		}
+		return samples
+	}},
+	{"backmeup_job_last_run_duration_seconds", "gauge", "Duration of the last run", func(m JobMetrics) []sample {
+		return value(m.LastRunDuration.Seconds())
+	}},
+	{"backmeup_job_average_run_duration_seconds", "gauge", "Average duration of the runs", func(m JobMetrics) []sample {
+		return value(m.AverageRunDuration.Seconds())
+	}},
+	{"backmeup_job_last_run_timestamp_seconds", "gauge", "When the last run finished, as a Unix time", func(m JobMetrics) []sample {
+		return value(float64(m.LastRunTime.Unix()))
+	}},
+	{"backmeup_job_last_run_success", "gauge", "Whether the last run succeeded", func(m JobMetrics) []sample {
+		if m.LastErrorCode == "" {
+			return value(1)
+		}
+		return value(0)
+	}},
+	{"backmeup_job_last_backup_size_bytes", "gauge", "Size of the last backup", func(m JobMetrics) []sample {
+		return value(float64(m.LastBackupSize))
+	}},
+	{"backmeup_job_backup_size_bytes_total", "counter", "Size of all the backups written", func(m JobMetrics) []sample {
+		return value(float64(m.TotalBackupSize))
+	}},
+	{"backmeup_job_run_duration_seconds", "histogram", "Duration of the successful runs", func(m JobMetrics) []sample {
+		return histogramSamples(m.durationHistogram, durationBounds)
+	}},
+	{"backmeup_job_backup_size_bytes", "histogram", "Size of the backups of the successful runs", func(m JobMetrics) []sample {
+		return histogramSamples(m.sizeHistogram, sizeBounds)
+	}},
+	{"backmeup_job_recent_run_duration_seconds", "gauge", "Duration over the last successful runs by statistic", func(m JobMetrics) []sample {
+		w := m.DurationWindow
+		return windowSamples(w.Runs, w.Min.Seconds(), w.Max.Seconds(), w.P50.Seconds(), w.P95.Seconds())
+	}},
+	{"backmeup_job_recent_backup_size_bytes", "gauge", "Backup size over the last successful runs by statistic", func(m JobMetrics) []sample {
+		w := m.SizeWindow
+		return windowSamples(w.Runs, float64(w.Min), float64(w.Max), float64(w.P50), float64(w.P95))
+	}},
+}
+
+// histogramSamples returns the cumulative buckets of a histogram with its sum
+// and count
+func histogramSamples(h histogram, bounds []float64) []sample {
+	samples := make([]sample, 0, len(bounds)+3)
+	cumulative := 0
+	for i, bound := range bounds {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		samples = append(samples, sample{suffix: "_bucket", labels: [][2]string{{"le", formatValue(bound)}}, value: float64(cumulative)})
+	}
+	return append(samples,
+		sample{suffix: "_bucket", labels: [][2]string{{"le", "+Inf"}}, value: float64(h.count)},
+		sample{suffix: "_sum", value: h.sum},
+		sample{suffix: "_count", value: float64(h.count)},
+	)
+}
+
+// windowSamples returns the statistics of a window, or nothing before the
+// job's first successful run
+func windowSamples(runs int, minimum, maximum, p50, p95 float64) []sample {
+	if runs == 0 {
+		return nil
+	}
+	return []sample{
+		{labels: [][2]string{{"stat", "min"}}, value: minimum},
+		{labels: [][2]string{{"stat", "max"}}, value: maximum},
+		{labels: [][2]string{{"stat", "p50"}}, value: p50},
+		{labels: [][2]string{{"stat", "p95"}}, value: p95},
+	}
+}
+
+// writePrometheus writes the metrics of every job in the text format, labeled
+// with the job's name as backup_job, since Prometheus sets job to the scrape
+// target, and with its configured labels
+func (mc *MetricsCollector) writePrometheus(w io.Writer) {
+	metrics := mc.GetAllJobMetrics()
+	jobs := slices.Sorted(maps.Keys(metrics))
+
+	for _, family := range metricFamilies {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for _, job := range jobs {
+			m := metrics[job]
+			base := [][2]string{{"backup_job", job}}
+			for _, name := range slices.Sorted(maps.Keys(m.Labels)) {
+				base = append(base, [2]string{name, m.Labels[name]})
+			}
+			for _, s := range family.samples(m) {
+				fmt.Fprintf(w, "%s%s%s %s\n", family.name, s.suffix, formatLabels(append(slices.Clone(base), s.labels...)),
+					formatValue(s.value))
+			}
+		}
+	}
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatLabels(labels [][2]string) string {
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = fmt.Sprintf(`%s="%s"`, l[0], labelEscaper.Replace(l[1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/backuptest"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/failure"
+	"github.com/thitiph0n/backmeup/internal/history"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func TestMetricsHandler_Prometheus(t *testing.T) {
+	mc := NewMetricsCollector()
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	labels := map[string]string{"team": "payments", "env": `prod "eu"`}
+	mc.UpdateJobMetrics(history.Run{Job: "orders", Status: history.StatusSuccess, StartedAt: start, FinishedAt: start.Add(90 * time.Second), Size: 2048}, labels)
+	mc.UpdateJobMetrics(history.Run{Job: "orders", Status: history.StatusFailed, ErrorCode: string(failure.CodeConnection),
+		StartedAt: start, FinishedAt: start.Add(30 * time.Second)}, labels)
+	mc.UpdateJobMetrics(history.Run{Job: "assets", Status: history.StatusSuccess, StartedAt: start, FinishedAt: start.Add(time.Second), Size: 10}, nil)
+	labels["team"] = "changed"
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	mc.MetricsHandler(w, req)
+	assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	orders := `backup_job="orders",env="prod \"eu\"",team="payments"`
+	for _, line := range []string{
+		"# TYPE backmeup_job_runs_total counter",
+		`backmeup_job_runs_total{` + orders + `,status="success"} 1`,
+		`backmeup_job_runs_total{` + orders + `,status="failed"} 1`,
+		`backmeup_job_runs_total{backup_job="assets",status="success"} 1`,
+		`backmeup_job_failures_total{` + orders + `,code="connection"} 1`,
+		`backmeup_job_last_run_duration_seconds{` + orders + `} 30`,
+		`backmeup_job_average_run_duration_seconds{` + orders + `} 60`,
+		`backmeup_job_last_run_success{` + orders + `} 0`,
+		`backmeup_job_last_run_success{backup_job="assets"} 1`,
+		`backmeup_job_backup_size_bytes_total{` + orders + `} 2048`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.Less(t, strings.Index(body, `{backup_job="assets"`), strings.Index(body, `{backup_job="orders"`), "jobs are sorted")
+
+	w = httptest.NewRecorder()
+	mc.MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var metrics map[string]JobMetrics
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &metrics))
+	assert.Equal(t, map[string]string{"team": "payments", "env": `prod "eu"`}, metrics["orders"].Labels)
+
+	w = httptest.NewRecorder()
+	mc.MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics?format=prometheus", nil))
+	assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+}
+
+func TestMetricsHandler_JobLabels(t *testing.T) {
+	js := scheduler.NewJobScheduler(
+		config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: t.TempDir()}},
+		config.SchedulerConfig{},
+	)
+	job := config.JobConfig{
+		Name:            "orders",
+		Type:            "postgres",
+		Schedule:        "0 2 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1},
+		Labels:          map[string]string{"team": "payments"},
+	}
+	require.NoError(t, js.AddJob(job, &backuptest.Executor{Err: errors.New("boom")}))
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+
+	_, err := js.RunNow("orders")
+	require.Error(t, err)
+
+	w := get(srv, "/metrics?format=prometheus")
+	assert.Contains(t, w.Body.String(), `backmeup_job_runs_total{backup_job="orders",team="payments",status="failed"} 1`)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// retentionPlanEntry is a backup listed in a retention plan
+type retentionPlanEntry struct {
+	Name    string    `json:"name"`
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+}
+
+// retentionPlanResponse is the body returned by GET /jobs/{name}/retention/plan
+type retentionPlanResponse struct {
+	Job            string               `json:"job"`
+	PolicyType     string               `json:"policyType"`
+	PolicyValue    int                  `json:"policyValue"`
+	Action         string               `json:"action"` // "delete" or "trash"
+	Total          int                  `json:"total"`
+	Kept           int                  `json:"kept"`
+	Remove         []retentionPlanEntry `json:"remove"`
+	Deferred       int                  `json:"deferred"`
+	Protected      int                  `json:"protected"`
+	PurgeTrash     []retentionPlanEntry `json:"purgeTrash"`
+	ReclaimedBytes int64                `json:"reclaimedBytes"`
+}
+
+// RetentionPlanHandler reports which backups the job's retention policy
+// would remove right now, without removing anything
+func (s *HTTPServer) RetentionPlanHandler(w http.ResponseWriter, r *http.Request) {
+	jobName := r.PathValue("name")
+
+	plan, err := s.scheduler.RetentionPlan(r.Context(), jobName)
+	if errors.Is(err, scheduler.ErrJobNotFound) {
+		writeError(w, http.StatusNotFound, "job not found: "+jobName)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	jobConfig, _ := s.scheduler.JobConfig(jobName)
+
+	action := "delete"
+	if plan.ToTrash {
+		action = "trash"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retentionPlanResponse{
+		Job:            jobName,
+		PolicyType:     jobConfig.RetentionPolicy.Type,
+		PolicyValue:    jobConfig.RetentionPolicy.Value,
+		Action:         action,
+		Total:          plan.Total,
+		Kept:           plan.Total - len(plan.Remove),
+		Remove:         planEntries(plan.Remove),
+		Deferred:       plan.Deferred,
+		Protected:      plan.Protected,
+		PurgeTrash:     planEntries(plan.Purge),
+		ReclaimedBytes: plan.ReclaimedBytes,
+	})
+}
+
+func planEntries(entries []storage.BackupEntry) []retentionPlanEntry {
+	result := make([]retentionPlanEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, retentionPlanEntry{
+			Name:    filepath.Base(entry.Key),
+			ModTime: entry.ModTime,
+			Size:    entry.Size,
+		})
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+type nopExecutor struct{}
+
+func (nopExecutor) Execute(context.Context) error { return nil }
+
+func TestRetentionPlanHandler(t *testing.T) {
+	dir := t.TempDir()
+	jobDir := filepath.Join(dir, "orders")
+	require.NoError(t, os.MkdirAll(jobDir, 0755))
+	for i := 0; i < 4; i++ {
+		path := filepath.Join(jobDir, time.Now().AddDate(0, 0, -i).Format("20060102")+".sql")
+		require.NoError(t, os.WriteFile(path, make([]byte, 100), 0644))
+		modTime := time.Now().AddDate(0, 0, -i)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	js := scheduler.NewJobScheduler(
+		config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: dir}},
+		config.SchedulerConfig{},
+	)
+	require.NoError(t, js.AddJob(config.JobConfig{
+		Name:            "orders",
+		Schedule:        "0 2 * * *",
+		RetentionPolicy: config.RetentionPolicy{Type: "count", Value: 1, MaxDeletions: 2},
+	}, nopExecutor{}))
+
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+
+	req := httptest.NewRequest(http.MethodGet, "/jobs/orders/retention/plan", nil)
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var plan retentionPlanResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &plan))
+	assert.Equal(t, "delete", plan.Action)
+	assert.Equal(t, 4, plan.Total)
+	assert.Equal(t, 2, plan.Kept)
+	assert.Equal(t, 1, plan.Deferred)
+	require.Len(t, plan.Remove, 2)
+	assert.Equal(t, time.Now().AddDate(0, 0, -3).Format("20060102")+".sql", plan.Remove[0].Name, "oldest backups are removed first")
+	assert.Equal(t, int64(200), plan.ReclaimedBytes)
+
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 4, "planning must not remove anything")
+
+	req = httptest.NewRequest(http.MethodGet, "/jobs/missing/retention/plan", nil)
+	w = httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
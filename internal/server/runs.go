@@ -0,0 +1,13 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// RunsHandler lists the runs in progress and those queued for a concurrency
+// slot
+func (s *HTTPServer) RunsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.scheduler.Runs())
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func TestRunsHandler(t *testing.T) {
+	js := scheduler.NewJobScheduler(
+		config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: t.TempDir()}},
+		config.SchedulerConfig{},
+	)
+	defer js.Stop()
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+
+	req := httptest.NewRequest(http.MethodGet, "/runs", nil)
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"running": [], "queued": []}`, w.Body.String())
+
+	var runs scheduler.Runs
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &runs))
+	assert.Empty(t, runs.Running)
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+)
+
+// SetBuildInfo sets what GET /version reports, including the job types and
+// storage backends the binary was built with
+func (s *HTTPServer) SetBuildInfo(info buildinfo.Info) {
+	s.buildInfo = info
+}
+
+// VersionHandler reports the version, commit, build date, Go version and
+// features of the running binary
+func (s *HTTPServer) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.buildInfo)
+}
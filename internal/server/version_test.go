@@ -0,0 +1,36 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/buildinfo"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/scheduler"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+func TestVersionHandler(t *testing.T) {
+	js := scheduler.NewJobScheduler(config.StorageConfig{Type: "local", Local: config.LocalConfig{Directory: t.TempDir()}}, config.SchedulerConfig{})
+	srv := NewHTTPServer(0, js, storage.NewMetrics())
+
+	info := buildinfo.Get()
+	info.JobTypes = []string{"files", "postgres"}
+	info.Storage = []string{"local", "s3"}
+	srv.SetBuildInfo(info)
+
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var got buildinfo.Info
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, info, got)
+	assert.NotEmpty(t, got.GoVersion)
+	assert.Equal(t, "dev", got.Version)
+}
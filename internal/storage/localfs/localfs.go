@@ -1,43 +1,176 @@
 package localfs
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/thitiph0n/backmeup/internal/clock"
 	"github.com/thitiph0n/backmeup/internal/config"
 	"github.com/thitiph0n/backmeup/internal/storage"
 )
 
 var _ storage.Storage = (*Storage)(nil)
 
+const (
+	trashDirName   = ".trash"
+	trashTimestamp = "20060102-150405"
+)
+
 type Storage struct {
 	directory string
+	perms     *Permissions
+	clock     clock.Clock
+}
+
+// Permissions are applied to the files and directories a Storage creates,
+// regardless of the umask. Zero modes keep the defaults, a negative UID or GID
+// keeps the owner.
+type Permissions struct {
+	FileMode fs.FileMode
+	DirMode  fs.FileMode
+	UID      int
+	GID      int
 }
 
 func New(cfg config.LocalConfig) *Storage {
-	return &Storage{directory: cfg.Directory}
+	return &Storage{directory: cfg.Directory, clock: clock.Real}
+}
+
+// SetClock sets the clock that stamps backups moved to the trash
+func (s *Storage) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetPermissions sets the modes and owner of backups created from now on
+func (s *Storage) SetPermissions(perms Permissions) {
+	s.perms = &perms
 }
 
 func (s *Storage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
 	jobDir := filepath.Join(s.directory, jobName)
-	if err := os.MkdirAll(jobDir, 0755); err != nil {
+	if err := s.mkdirAll(jobDir); err != nil {
 		return nil, fmt.Errorf("failed to create job directory: %w", err)
 	}
-	return os.Create(filepath.Join(jobDir, fileName))
+
+	path := filepath.Join(jobDir, fileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.fileMode())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(path, false); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
 }
 
 func (s *Storage) NewDir(jobName, dirName string) (string, error) {
 	dir := filepath.Join(s.directory, jobName, dirName)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := s.mkdirAll(dir); err != nil {
 		return "", fmt.Errorf("failed to create backup directory: %w", err)
 	}
 	return dir, nil
 }
 
-func (s *Storage) List(jobName string) ([]storage.BackupEntry, error) {
+// ApplyPermissions applies the permissions to the contents of backups changed
+// since the given time, covering files that tools wrote into directories
+// created with NewDir
+func (s *Storage) ApplyPermissions(jobName string, since time.Time) error {
+	if s.perms == nil {
+		return nil
+	}
+
+	entries, err := s.List(context.Background(), jobName)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.ModTime.Before(since) {
+			continue
+		}
+		err := filepath.WalkDir(entry.Key, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			return s.apply(path, d.IsDir())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply permissions to %s: %w", entry.Key, err)
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates a directory and its missing parents below the storage
+// directory, applying the permissions to every level from the job directory
+func (s *Storage) mkdirAll(dir string) error {
+	if err := os.MkdirAll(dir, s.dirMode()); err != nil {
+		return err
+	}
+	if s.perms == nil {
+		return nil
+	}
+
+	rel, err := filepath.Rel(s.directory, dir)
+	if err != nil {
+		return err
+	}
+	path := s.directory
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		if err := s.apply(path, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) apply(path string, dir bool) error {
+	if s.perms == nil {
+		return nil
+	}
+
+	mode := s.perms.FileMode
+	if dir {
+		mode = s.perms.DirMode
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if s.perms.UID >= 0 || s.perms.GID >= 0 {
+		if err := os.Lchown(path, s.perms.UID, s.perms.GID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) fileMode() fs.FileMode {
+	if s.perms != nil && s.perms.FileMode != 0 {
+		return s.perms.FileMode
+	}
+	return 0666
+}
+
+func (s *Storage) dirMode() fs.FileMode {
+	if s.perms != nil && s.perms.DirMode != 0 {
+		return s.perms.DirMode
+	}
+	return 0755
+}
+
+func (s *Storage) List(_ context.Context, jobName string) ([]storage.BackupEntry, error) {
 	jobDir := filepath.Join(s.directory, jobName)
 	if _, err := os.Stat(jobDir); os.IsNotExist(err) {
 		return nil, nil
@@ -48,6 +181,9 @@ func (s *Storage) List(jobName string) ([]storage.BackupEntry, error) {
 	}
 	backups := make([]storage.BackupEntry, 0, len(entries))
 	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
 		info, err := e.Info()
 		if err != nil {
 			continue
@@ -55,16 +191,106 @@ func (s *Storage) List(jobName string) ([]storage.BackupEntry, error) {
 		backups = append(backups, storage.BackupEntry{
 			Key:     filepath.Join(jobDir, e.Name()),
 			ModTime: info.ModTime(),
-			Size:    info.Size(),
+			Size:    entrySize(filepath.Join(jobDir, e.Name()), info),
 		})
 	}
 	return backups, nil
 }
 
-func (s *Storage) Delete(entry storage.BackupEntry) error {
+// Delete removes a backup file, or a directory backup with everything in it.
+// It refuses paths that are not inside a job's directory.
+func (s *Storage) Delete(_ context.Context, entry storage.BackupEntry) error {
+	if err := s.checkInside(entry.Key); err != nil {
+		return err
+	}
 	return os.RemoveAll(entry.Key)
 }
 
+// checkInside returns an error unless path is below a job's directory
+func (s *Storage) checkInside(path string) error {
+	if s.directory == "" {
+		return fmt.Errorf("refusing to delete %s without a storage directory", path)
+	}
+	rel, err := filepath.Rel(filepath.Clean(s.directory), filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || !strings.Contains(rel, string(filepath.Separator)) {
+		return fmt.Errorf("refusing to delete %s, it is not inside a job directory of %s", path, s.directory)
+	}
+	return nil
+}
+
+// MoveToTrash moves a backup into the job's .trash directory, prefixing its
+// name with the time it was trashed
+func (s *Storage) MoveToTrash(_ context.Context, jobName string, entry storage.BackupEntry) error {
+	trashDir := filepath.Join(s.directory, jobName, trashDirName)
+	if err := s.mkdirAll(trashDir); err != nil {
+		return fmt.Errorf("failed to create trash directory: %w", err)
+	}
+
+	target := filepath.Join(trashDir, s.clock.Now().Format(trashTimestamp)+"_"+filepath.Base(entry.Key))
+	if err := os.Rename(entry.Key, target); err != nil {
+		return fmt.Errorf("failed to move backup to trash: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) ListTrash(_ context.Context, jobName string) ([]storage.BackupEntry, error) {
+	trashDir := filepath.Join(s.directory, jobName, trashDirName)
+	entries, err := os.ReadDir(trashDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash directory: %w", err)
+	}
+
+	trashed := make([]storage.BackupEntry, 0, len(entries))
+	for _, e := range entries {
+		stamp, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			continue
+		}
+		trashedAt, err := time.ParseInLocation(trashTimestamp, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		trashed = append(trashed, storage.BackupEntry{
+			Key:     filepath.Join(trashDir, e.Name()),
+			ModTime: trashedAt,
+			Size:    entrySize(filepath.Join(trashDir, e.Name()), info),
+		})
+	}
+	return trashed, nil
+}
+
+// entrySize returns the size of a file, or the total size of the files in a
+// directory backup
+func entrySize(path string, info os.FileInfo) int64 {
+	if !info.IsDir() {
+		return info.Size()
+	}
+
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
 func GenerateFileName(prefix, extension string) string {
-	return fmt.Sprintf("%s_%s%s", prefix, time.Now().Format("20060102-150405"), extension)
+	return FileName(prefix, extension, time.Now())
+}
+
+// FileName returns the name of a backup taken at t
+func FileName(prefix, extension string, t time.Time) string {
+	return fmt.Sprintf("%s_%s%s", prefix, t.Format("20060102-150405"), extension)
 }
@@ -1,6 +1,7 @@
 package localfs
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
 )
 
 func newStorage(t *testing.T) (*Storage, string) {
@@ -18,6 +20,11 @@ func newStorage(t *testing.T) (*Storage, string) {
 	return New(config.LocalConfig{Directory: dir}), dir
 }
 
+func TestFileName(t *testing.T) {
+	at := time.Date(2026, 3, 1, 2, 30, 5, 0, time.UTC)
+	assert.Equal(t, "pg_backup_20260301-023005.sql", FileName("pg_backup", ".sql", at))
+}
+
 func TestGenerateFileName(t *testing.T) {
 	tests := []struct {
 		prefix    string
@@ -87,9 +94,38 @@ func TestNewDir_Error(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestSetPermissions(t *testing.T) {
+	s, dir := newStorage(t)
+	s.SetPermissions(Permissions{FileMode: 0640, DirMode: 0750, UID: -1, GID: -1})
+	start := time.Now().Truncate(time.Second)
+
+	w, err := s.NewWriter("myjob", "backup.sql")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	backupDir, err := s.NewDir("myjob", "minio_backup_20240101-120000")
+	require.NoError(t, err)
+	toolFile := filepath.Join(backupDir, "object")
+	require.NoError(t, os.WriteFile(toolFile, []byte("data"), 0644))
+
+	assertMode := func(path string, expected os.FileMode) {
+		t.Helper()
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, info.Mode().Perm(), path)
+	}
+	assertMode(filepath.Join(dir, "myjob"), 0750)
+	assertMode(filepath.Join(dir, "myjob", "backup.sql"), 0640)
+	assertMode(backupDir, 0750)
+	assertMode(toolFile, 0644)
+
+	require.NoError(t, s.ApplyPermissions("myjob", start))
+	assertMode(toolFile, 0640)
+}
+
 func TestList_Empty(t *testing.T) {
 	s, _ := newStorage(t)
-	entries, err := s.List("nonexistent_job")
+	entries, err := s.List(context.Background(), "nonexistent_job")
 	require.NoError(t, err)
 	assert.Nil(t, entries)
 }
@@ -105,7 +141,7 @@ func TestList(t *testing.T) {
 	require.NoError(t, err)
 	w2.Close()
 
-	entries, err := s.List("myjob")
+	entries, err := s.List(context.Background(), "myjob")
 	require.NoError(t, err)
 	assert.Len(t, entries, 2)
 	for _, e := range entries {
@@ -121,11 +157,11 @@ func TestDelete_File(t *testing.T) {
 	require.NoError(t, err)
 	w.Close()
 
-	entries, err := s.List("myjob")
+	entries, err := s.List(context.Background(), "myjob")
 	require.NoError(t, err)
 	require.Len(t, entries, 1)
 
-	require.NoError(t, s.Delete(entries[0]))
+	require.NoError(t, s.Delete(context.Background(), entries[0]))
 
 	_, err = os.Stat(entries[0].Key)
 	assert.True(t, os.IsNotExist(err))
@@ -141,12 +177,151 @@ func TestDelete_Dir(t *testing.T) {
 	require.NoError(t, err)
 	f.Close()
 
-	entries, err := s.List("myjob")
+	entries, err := s.List(context.Background(), "myjob")
 	require.NoError(t, err)
 	require.Len(t, entries, 1)
 
-	require.NoError(t, s.Delete(entries[0]))
+	require.NoError(t, s.Delete(context.Background(), entries[0]))
 
 	_, err = os.Stat(entries[0].Key)
 	assert.True(t, os.IsNotExist(err))
 }
+
+func TestDelete_OutsideJobDirectory(t *testing.T) {
+	s, dir := newStorage(t)
+	outside := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "myjob"), 0755))
+
+	for _, key := range []string{dir, filepath.Join(dir, "myjob"), filepath.Join(dir, "..", "other"), outside} {
+		assert.Error(t, s.Delete(context.Background(), storage.BackupEntry{Key: key}), key)
+	}
+	assert.DirExists(t, filepath.Join(dir, "myjob"))
+	assert.DirExists(t, outside)
+}
+
+func TestMoveToTrash(t *testing.T) {
+	s, dir := newStorage(t)
+
+	w, err := s.NewWriter("myjob", "backup.sql")
+	require.NoError(t, err)
+	w.Close()
+
+	entries, err := s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	before := time.Now().Truncate(time.Second)
+	require.NoError(t, s.MoveToTrash(context.Background(), "myjob", entries[0]))
+
+	entries, err = s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, entries, "trashed backups are not listed")
+
+	trashed, err := s.ListTrash(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	assert.Equal(t, filepath.Join(dir, "myjob", ".trash"), filepath.Dir(trashed[0].Key))
+	assert.True(t, strings.HasSuffix(trashed[0].Key, "_backup.sql"))
+	assert.False(t, trashed[0].ModTime.Before(before), "ModTime is the time the backup was trashed")
+
+	require.NoError(t, s.Delete(context.Background(), trashed[0]))
+	trashed, err = s.ListTrash(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, trashed)
+}
+
+func TestListTrash_Empty(t *testing.T) {
+	s, _ := newStorage(t)
+
+	trashed, err := s.ListTrash(context.Background(), "nonexistent")
+	assert.NoError(t, err)
+	assert.Empty(t, trashed)
+}
+
+func TestRenameJob(t *testing.T) {
+	s, dir := newStorage(t)
+	ctx := context.Background()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "old", ".trash"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old", "a.sql"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "old", ".trash", "20260301-020000_b.sql"), []byte("b"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "new"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "c.sql"), []byte("c"), 0644))
+
+	moved, err := s.RenameJob(ctx, "old", "new")
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	assert.NoDirExists(t, filepath.Join(dir, "old"))
+
+	entries, err := s.List(ctx, "new")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "backups the new name already had are kept")
+	trashed, err := s.ListTrash(ctx, "new")
+	require.NoError(t, err)
+	assert.Len(t, trashed, 1)
+
+	moved, err = s.RenameJob(ctx, "old", "new")
+	require.NoError(t, err)
+	assert.Zero(t, moved, "a job without backups has nothing to move")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "other"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other", "a.sql"), []byte("other"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other", "d.sql"), []byte("d"), 0644))
+	_, err = s.RenameJob(ctx, "other", "new")
+	assert.ErrorContains(t, err, "already exists")
+	assert.FileExists(t, filepath.Join(dir, "other", "d.sql"), "nothing is moved on a conflict")
+}
+
+func TestPruneJob(t *testing.T) {
+	s, dir := newStorage(t)
+	jobDir := filepath.Join(dir, "db")
+	require.NoError(t, os.MkdirAll(filepath.Join(jobDir, ".minio_backup_20260301-020000"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(jobDir, trashDirName), 0755))
+	for name, content := range map[string]string{
+		"db_20260301-020000.sql":                   "backup",
+		"db_20260302-020000.sql.partial":           "half",
+		"recent.partial":                           "still written",
+		".minio_backup_20260301-020000/object.txt": "mirrored",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(jobDir, name), []byte(content), 0644))
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	for _, name := range []string{"db_20260301-020000.sql", "db_20260302-020000.sql.partial", ".minio_backup_20260301-020000", trashDirName} {
+		require.NoError(t, os.Chtimes(filepath.Join(jobDir, name), old, old))
+	}
+
+	report, err := s.PruneJob("db", time.Now().Add(-12*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, PruneReport{Files: 2, Bytes: int64(len("half") + len("mirrored"))}, report)
+
+	entries, err := os.ReadDir(jobDir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{trashDirName, "db_20260301-020000.sql", "recent.partial"}, names,
+		"backups, the trash and recent files are kept")
+
+	report, err = s.PruneJob("missing", time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, report)
+}
+
+func TestRemoveEmptyJobDirs(t *testing.T) {
+	s, dir := newStorage(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "removed", trashDirName), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "scheduled"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "trashed", trashDirName), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "trashed", trashDirName, "old.sql"), []byte("x"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".backmeup"), 0755))
+
+	report, err := s.RemoveEmptyJobDirs([]string{"scheduled"})
+	require.NoError(t, err)
+	assert.Equal(t, PruneReport{Dirs: 1}, report)
+
+	assert.NoDirExists(t, filepath.Join(dir, "removed"))
+	assert.DirExists(t, filepath.Join(dir, "scheduled"))
+	assert.FileExists(t, filepath.Join(dir, "trashed", trashDirName, "old.sql"))
+	assert.DirExists(t, filepath.Join(dir, ".backmeup"))
+}
@@ -0,0 +1,93 @@
+package localfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// partialSuffix marks files that were still being written, such as those
+// rclone and curl leave behind when they are interrupted
+const partialSuffix = ".partial"
+
+// PruneReport counts what a prune removed and the space it reclaimed
+type PruneReport struct {
+	Files int // Stale .partial files and hidden staging directories
+	Dirs  int // Empty job directories
+	Bytes int64
+}
+
+// Add adds the counts of another report
+func (r *PruneReport) Add(other PruneReport) {
+	r.Files += other.Files
+	r.Dirs += other.Dirs
+	r.Bytes += other.Bytes
+}
+
+// PruneJob removes what crashed runs left in a job directory and was last
+// changed before the given time: .partial files and the hidden directories
+// that backups are staged in before they are archived. The trash and the
+// backups themselves are left alone.
+func (s *Storage) PruneJob(jobName string, before time.Time) (PruneReport, error) {
+	var report PruneReport
+	jobDir := filepath.Join(s.directory, jobName)
+	entries, err := os.ReadDir(jobDir)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return report, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		leftover := strings.HasSuffix(name, partialSuffix) && !e.IsDir() ||
+			strings.HasPrefix(name, ".") && name != trashDirName && e.IsDir()
+		if !leftover {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+
+		path := filepath.Join(jobDir, name)
+		size := entrySize(path, info)
+		if err := os.RemoveAll(path); err != nil {
+			return report, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		report.Files++
+		report.Bytes += size
+	}
+	return report, nil
+}
+
+// RemoveEmptyJobDirs removes the job directories that hold nothing but an
+// empty trash, except those of the given jobs. Hidden directories, such as
+// the one of the run history, are not job directories.
+func (s *Storage) RemoveEmptyJobDirs(keep []string) (PruneReport, error) {
+	var report PruneReport
+	entries, err := os.ReadDir(s.directory)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return report, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || slices.Contains(keep, e.Name()) {
+			continue
+		}
+		jobDir := filepath.Join(s.directory, e.Name())
+		// Both fail unless the directory is empty
+		os.Remove(filepath.Join(jobDir, trashDirName))
+		if err := os.Remove(jobDir); err == nil {
+			report.Dirs++
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,69 @@
+package localfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RenameJob moves the backups and trash of a job to the directory of its new
+// name and returns how many backups were moved. Backups the new name already
+// has are kept, and nothing is moved when any name is taken in both.
+func (s *Storage) RenameJob(_ context.Context, from, to string) (int, error) {
+	fromDir, toDir := filepath.Join(s.directory, from), filepath.Join(s.directory, to)
+
+	moves, err := plannedMoves(fromDir, toDir)
+	if err != nil {
+		return 0, err
+	}
+	if len(moves) == 0 {
+		return 0, nil
+	}
+
+	var moved int
+	for src, dst := range moves {
+		if err := s.mkdirAll(filepath.Dir(dst)); err != nil {
+			return moved, fmt.Errorf("failed to create job directory: %w", err)
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return moved, fmt.Errorf("failed to move %s: %w", src, err)
+		}
+		if filepath.Dir(src) == fromDir {
+			moved++
+		}
+	}
+
+	os.Remove(filepath.Join(fromDir, trashDirName))
+	if err := os.Remove(fromDir); err != nil {
+		return moved, fmt.Errorf("failed to remove the directory of job %s: %w", from, err)
+	}
+	return moved, nil
+}
+
+// plannedMoves maps the entries of a job directory and of its trash to their
+// place in the new directory
+func plannedMoves(fromDir, toDir string) (map[string]string, error) {
+	moves := make(map[string]string)
+	for _, dir := range []string{"", trashDirName} {
+		entries, err := os.ReadDir(filepath.Join(fromDir, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory: %w", err)
+		}
+
+		for _, e := range entries {
+			if dir == "" && e.Name() == trashDirName {
+				continue
+			}
+			src, dst := filepath.Join(fromDir, dir, e.Name()), filepath.Join(toDir, dir, e.Name())
+			if _, err := os.Lstat(dst); err == nil {
+				return nil, fmt.Errorf("cannot move %s, %s already exists", src, dst)
+			}
+			moves[src] = dst
+		}
+	}
+	return moves, nil
+}
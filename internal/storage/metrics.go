@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// BackendMetrics describes the data sent to one storage backend
+type BackendMetrics struct {
+	Uploads          int           `json:"uploads"`
+	UploadErrors     int           `json:"uploadErrors"`
+	UploadedBytes    int64         `json:"uploadedBytes"`
+	UploadDuration   time.Duration `json:"uploadDuration"`
+	UploadThroughput float64       `json:"uploadThroughput"` // Bytes per second spent waiting on the backend
+	ErrorRate        float64       `json:"errorRate"`        // Failed uploads as a fraction of all uploads
+	LastError        string        `json:"lastError,omitempty"`
+	LastErrorTime    time.Time     `json:"lastErrorTime,omitzero"`
+
+	// Backups removed by retention and the storage quota
+	Deletions    int   `json:"deletions"`
+	DeleteErrors int   `json:"deleteErrors"`
+	DeletedBytes int64 `json:"deletedBytes"`
+}
+
+// Metrics collects transfer metrics per storage backend. Durations only count
+// the time spent in the backend, not the time waiting for the data to be
+// produced, so a slow dump and a slow destination can be told apart.
+type Metrics struct {
+	mu       sync.RWMutex
+	backends map[string]BackendMetrics
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{backends: make(map[string]BackendMetrics)}
+}
+
+// RecordUpload records one upload to a backend. A nil collector ignores it.
+func (m *Metrics) RecordUpload(backend string, bytes int64, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := m.backends[backend]
+	metrics.Uploads++
+	metrics.UploadedBytes += bytes
+	metrics.UploadDuration += duration
+	if err != nil {
+		metrics.UploadErrors++
+		metrics.LastError = err.Error()
+		metrics.LastErrorTime = time.Now()
+	}
+
+	if metrics.UploadDuration > 0 {
+		metrics.UploadThroughput = float64(metrics.UploadedBytes) / metrics.UploadDuration.Seconds()
+	}
+	metrics.ErrorRate = float64(metrics.UploadErrors) / float64(metrics.Uploads)
+
+	m.backends[backend] = metrics
+}
+
+// RecordDelete records the removal of a backup of the given size from a
+// backend. A nil collector ignores it.
+func (m *Metrics) RecordDelete(backend string, bytes int64, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := m.backends[backend]
+	if err != nil {
+		metrics.DeleteErrors++
+	} else {
+		metrics.Deletions++
+		metrics.DeletedBytes += bytes
+	}
+	m.backends[backend] = metrics
+}
+
+// GetAll returns the metrics of every backend that has seen an upload or a
+// deletion
+func (m *Metrics) GetAll() map[string]BackendMetrics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]BackendMetrics, len(m.backends))
+	for backend, metrics := range m.backends {
+		result[backend] = metrics
+	}
+	return result
+}
+
+// Instrument records every backup written through s as an upload to backend
+func Instrument(s Storage, backend string, m *Metrics) Storage {
+	return &instrumented{Storage: s, backend: backend, metrics: m}
+}
+
+type instrumented struct {
+	Storage
+	backend string
+	metrics *Metrics
+}
+
+func (i *instrumented) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	w, err := i.Storage.NewWriter(jobName, fileName)
+	if err != nil {
+		i.metrics.RecordUpload(i.backend, 0, 0, err)
+		return nil, err
+	}
+	return NewTimedWriter(w, func(bytes int64, elapsed time.Duration, err error) {
+		i.metrics.RecordUpload(i.backend, bytes, elapsed, err)
+	}), nil
+}
+
+// TimedWriter measures the bytes written and the time spent inside Write and
+// Close, and reports them once on Close
+type TimedWriter struct {
+	w       io.WriteCloser
+	bytes   int64
+	elapsed time.Duration
+	err     error
+	done    func(bytes int64, elapsed time.Duration, err error)
+}
+
+// NewTimedWriter wraps w and calls done when it is closed
+func NewTimedWriter(w io.WriteCloser, done func(bytes int64, elapsed time.Duration, err error)) *TimedWriter {
+	return &TimedWriter{w: w, done: done}
+}
+
+func (t *TimedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	t.elapsed += time.Since(start)
+	t.bytes += int64(n)
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return n, err
+}
+
+func (t *TimedWriter) Close() error {
+	start := time.Now()
+	err := t.w.Close()
+	t.elapsed += time.Since(start)
+	if t.err == nil {
+		t.err = err
+	}
+	t.done(t.bytes, t.elapsed, t.err)
+	return err
+}
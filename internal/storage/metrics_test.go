@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStorage struct {
+	Storage
+	writeErr error
+}
+
+type nopWriteCloser struct {
+	err error
+}
+
+func (w nopWriteCloser) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+func (w nopWriteCloser) Close() error { return nil }
+
+func (m *memoryStorage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	return nopWriteCloser{err: m.writeErr}, nil
+}
+
+func TestInstrument_RecordsUploads(t *testing.T) {
+	metrics := NewMetrics()
+	s := Instrument(&memoryStorage{}, "local", metrics)
+
+	for range 2 {
+		w, err := s.NewWriter("myjob", "backup.sql")
+		require.NoError(t, err)
+		_, err = w.Write(make([]byte, 100))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	all := metrics.GetAll()
+	require.Contains(t, all, "local")
+	assert.Equal(t, 2, all["local"].Uploads)
+	assert.Equal(t, int64(200), all["local"].UploadedBytes)
+	assert.Zero(t, all["local"].UploadErrors)
+	assert.Zero(t, all["local"].ErrorRate)
+}
+
+func TestInstrument_RecordsErrors(t *testing.T) {
+	metrics := NewMetrics()
+	s := Instrument(&memoryStorage{writeErr: errors.New("disk full")}, "local", metrics)
+
+	w, err := s.NewWriter("myjob", "backup.sql")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	require.Error(t, err)
+	require.NoError(t, w.Close())
+
+	metrics.RecordUpload("local", 100, time.Second, nil)
+
+	local := metrics.GetAll()["local"]
+	assert.Equal(t, 2, local.Uploads)
+	assert.Equal(t, 1, local.UploadErrors)
+	assert.Equal(t, 0.5, local.ErrorRate)
+	assert.Equal(t, "disk full", local.LastError)
+}
+
+func TestRecordUpload_Throughput(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.RecordUpload("s3", 1000, time.Second, nil)
+	metrics.RecordUpload("s3", 3000, time.Second, nil)
+
+	assert.Equal(t, 2000.0, metrics.GetAll()["s3"].UploadThroughput)
+}
+
+func TestRecordUpload_NilMetrics(t *testing.T) {
+	var metrics *Metrics
+	assert.NotPanics(t, func() { metrics.RecordUpload("s3", 1, time.Second, nil) })
+}
+
+func TestMetrics_RecordDelete(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.RecordDelete("s3", 300, nil)
+	metrics.RecordDelete("s3", 200, nil)
+	metrics.RecordDelete("s3", 100, errors.New("access denied"))
+
+	s3 := metrics.GetAll()["s3"]
+	assert.Equal(t, 2, s3.Deletions)
+	assert.Equal(t, 1, s3.DeleteErrors)
+	assert.Equal(t, int64(500), s3.DeletedBytes)
+	assert.Zero(t, s3.Uploads)
+
+	var none *Metrics
+	none.RecordDelete("s3", 100, nil)
+}
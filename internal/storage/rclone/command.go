@@ -0,0 +1,81 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// errNotFound is returned by runners for a directory or file that does not
+// exist, which rclone reports with exit codes 3 and 4
+var errNotFound = errors.New("not found")
+
+// runner runs rclone commands, the tests replace it with a fake
+type runner interface {
+	// run runs an rclone command with stdin as its input, nil for none,
+	// and returns what it wrote to stdout
+	run(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error)
+}
+
+// execRunner runs the rclone binary
+type execRunner struct {
+	binary string
+	flags  []string // Appended to every command
+}
+
+func (r *execRunner) run(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, r.binary, append(append([]string{}, args...), r.flags...)...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err == nil {
+		return output, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 3 || exitErr.ExitCode() == 4) {
+		err = errNotFound
+	}
+	if line := lastLine(stderr.String()); line != "" {
+		return output, fmt.Errorf("rclone %s failed: %w: %s", args[0], err, line)
+	}
+	return output, fmt.Errorf("rclone %s failed: %w", args[0], err)
+}
+
+// lastLine returns the last line rclone printed, which holds the error
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// object is an entry of rclone lsjson
+type object struct {
+	Path    string    `json:"Path"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	IsDir   bool      `json:"IsDir"`
+}
+
+// listFiles returns every file below a remote path, with paths relative to
+// it. A path that does not exist has no files.
+func listFiles(ctx context.Context, r runner, remotePath string) ([]object, error) {
+	output, err := r.run(ctx, nil, "lsjson", "--recursive", "--files-only", remotePath)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var objects []object
+	if err := json.Unmarshal(output, &objects); err != nil {
+		return nil, fmt.Errorf("failed to read the listing of %s: %w", remotePath, err)
+	}
+	return objects, nil
+}
@@ -0,0 +1,288 @@
+// Package rclone keeps backups on any destination rclone supports, below
+// <remote>/<job>/, by running the rclone binary
+package rclone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const (
+	backendName    = "rclone"
+	trashDirName   = ".trash"
+	trashTimestamp = "20060102-150405"
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+// Storage keeps backups below <remote>/<job>/. Keys are relative to the
+// remote, a backup is either a single file or a directory below
+// <job>/<name>/.
+type Storage struct {
+	remote  string // Remote and path, ending with a colon or a slash
+	rclone  runner
+	metrics *storage.Metrics
+}
+
+// New creates an rclone storage from the configuration. Uploads are recorded
+// in metrics as the "rclone" backend when it is not nil.
+func New(cfg config.RcloneConfig, metrics *storage.Metrics) (*Storage, error) {
+	if !strings.Contains(cfg.Remote, ":") {
+		return nil, fmt.Errorf("invalid rclone remote '%s', use remote:path", cfg.Remote)
+	}
+	r := &execRunner{binary: cfg.Binary}
+	if r.binary == "" {
+		r.binary = "rclone"
+	}
+	if cfg.ConfigFile != "" {
+		r.flags = append(r.flags, "--config", cfg.ConfigFile)
+	}
+	r.flags = append(r.flags, cfg.Flags...)
+
+	s := newStorage(r, cfg.Remote)
+	s.metrics = metrics
+	return s, nil
+}
+
+func newStorage(r runner, remote string) *Storage {
+	if !strings.HasSuffix(remote, ":") && !strings.HasSuffix(remote, "/") {
+		remote += "/"
+	}
+	return &Storage{remote: remote, rclone: r}
+}
+
+// path returns the remote path of a key
+func (s *Storage) path(key string) string {
+	return s.remote + strings.TrimSuffix(key, "/")
+}
+
+func jobDir(jobName string) string {
+	return jobName + "/"
+}
+
+// NewWriter streams a backup to the remote with rclone rcat
+func (s *Storage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	w := &streamWriter{pipe: pw, done: make(chan error, 1)}
+
+	go func() {
+		_, err := s.rclone.run(context.Background(), pr, "rcat", s.path(jobDir(jobName)+fileName))
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return storage.NewTimedWriter(w, func(bytes int64, elapsed time.Duration, err error) {
+		s.metrics.RecordUpload(backendName, bytes, elapsed, err)
+	}), nil
+}
+
+// NewDir is not supported, executors that write directories use the local
+// staging area, which is uploaded with Upload
+func (s *Storage) NewDir(jobName, dirName string) (string, error) {
+	return "", fmt.Errorf("rclone storage cannot create directory %s/%s, stage it locally and upload it", jobName, dirName)
+}
+
+func (s *Storage) List(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	return s.entries(ctx, jobDir(jobName))
+}
+
+// Delete removes a file, or a directory backup with everything in it. A
+// backup that is already gone is fine.
+func (s *Storage) Delete(ctx context.Context, entry storage.BackupEntry) error {
+	command := "deletefile"
+	if strings.HasSuffix(entry.Key, "/") {
+		command = "purge"
+	}
+	if _, err := s.rclone.run(ctx, nil, command, s.path(entry.Key)); err != nil && !errors.Is(err, errNotFound) {
+		return err
+	}
+	return nil
+}
+
+// MoveToTrash moves a backup below <job>/.trash/ on the remote, with the time
+// it was trashed as a prefix
+func (s *Storage) MoveToTrash(ctx context.Context, jobName string, entry storage.BackupEntry) error {
+	target := jobDir(jobName) + trashDirName + "/" + time.Now().Format(trashTimestamp) + "_" + path.Base(entry.Key)
+	if _, err := s.rclone.run(ctx, nil, "moveto", s.path(entry.Key), s.path(target)); err != nil {
+		return fmt.Errorf("failed to move backup to trash: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) ListTrash(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	entries, err := s.entries(ctx, jobDir(jobName)+trashDirName+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := make([]storage.BackupEntry, 0, len(entries))
+	for _, entry := range entries {
+		stamp, _, ok := strings.Cut(path.Base(entry.Key), "_")
+		if !ok {
+			continue
+		}
+		trashedAt, err := time.ParseInLocation(trashTimestamp, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		entry.ModTime = trashedAt
+		trashed = append(trashed, entry)
+	}
+	return trashed, nil
+}
+
+// RenameJob moves the directory of a job, with its trash, to its new name and
+// returns how many backups were moved. Nothing is moved when the new name
+// already has backups.
+func (s *Storage) RenameJob(ctx context.Context, from, to string) (int, error) {
+	existing, err := listFiles(ctx, s.rclone, s.path(jobDir(to)))
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) > 0 {
+		return 0, fmt.Errorf("cannot move the backups of job %s, %s already has backups", from, to)
+	}
+	entries, err := s.List(ctx, from)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	if _, err := s.rclone.run(ctx, nil, "moveto", s.path(jobDir(from)), s.path(jobDir(to))); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// entries lists the backups in a directory from one recursive listing.
+// Directory backups are sized as the sum of their files and dated by the
+// newest one.
+func (s *Storage) entries(ctx context.Context, dir string) ([]storage.BackupEntry, error) {
+	files, err := listFiles(ctx, s.rclone, s.path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*storage.BackupEntry)
+	for _, f := range files {
+		name, _, isDir := strings.Cut(f.Path, "/")
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		key := dir + name
+		if isDir {
+			key += "/"
+		}
+		entry, ok := byName[key]
+		if !ok {
+			entry = &storage.BackupEntry{Key: key}
+			byName[key] = entry
+		}
+		entry.Size += f.Size
+		if f.ModTime.After(entry.ModTime) {
+			entry.ModTime = f.ModTime
+		}
+	}
+
+	entries := make([]storage.BackupEntry, 0, len(byName))
+	for _, entry := range byName {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// Upload copies every backup in a job's local staging directory to the remote
+// with rclone copy, which skips files that are already there unchanged
+func (s *Storage) Upload(ctx context.Context, jobName, localDir string) (storage.UploadResult, error) {
+	var result storage.UploadResult
+
+	entries, err := os.ReadDir(localDir)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to read staging directory: %w", err)
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") {
+			result.Entries = append(result.Entries, e.Name())
+		}
+	}
+	if len(result.Entries) == 0 {
+		return result, nil
+	}
+
+	// Counted from a listing before the copy, rclone's own statistics are
+	// not meant to be parsed
+	remote, err := listFiles(ctx, s.rclone, s.path(jobDir(jobName)))
+	if err != nil {
+		return result, err
+	}
+	uploaded := make(map[string]int64, len(remote))
+	for _, f := range remote {
+		uploaded[f.Path] = f.Size
+	}
+	for _, name := range result.Entries {
+		err := filepath.WalkDir(filepath.Join(localDir, name), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(localDir, p)
+			if err != nil {
+				return err
+			}
+			if size, ok := uploaded[filepath.ToSlash(rel)]; ok && size == info.Size() {
+				result.Skipped++
+				return nil
+			}
+			result.Uploaded++
+			result.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	start := time.Now()
+	_, err = s.rclone.run(ctx, nil, "copy", localDir, s.path(jobDir(jobName)), "--exclude", "/.*", "--exclude", "/.*/**")
+	if err != nil {
+		s.metrics.RecordUpload(backendName, 0, time.Since(start), err)
+		return storage.UploadResult{}, err
+	}
+	s.metrics.RecordUpload(backendName, result.Bytes, time.Since(start), nil)
+	return result, nil
+}
+
+// streamWriter feeds an upload running in the background and reports its
+// result on Close
+type streamWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *streamWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
@@ -0,0 +1,260 @@
+package rclone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// fakeRclone runs the rclone commands the storage uses against a local
+// directory standing in for the remote "fake:"
+type fakeRclone struct {
+	root string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func (f *fakeRclone) local(remotePath string) string {
+	return filepath.Join(f.root, filepath.FromSlash(strings.TrimPrefix(remotePath, "fake:")))
+}
+
+func (f *fakeRclone) run(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	f.commands = append(f.commands, args[0])
+	f.mu.Unlock()
+
+	switch args[0] {
+	case "lsjson":
+		dir := f.local(args[len(args)-1])
+		if _, err := os.Stat(dir); err != nil {
+			return nil, errNotFound
+		}
+		objects := []object{}
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, p)
+			objects = append(objects, object{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(objects)
+	case "rcat":
+		target := f.local(args[1])
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		return nil, os.WriteFile(target, data, 0644)
+	case "copy":
+		src, dst := args[1], f.local(args[2])
+		return nil, filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(src, p)
+			if strings.HasPrefix(rel, ".") && rel != "." {
+				return fs.SkipDir
+			}
+			if d.IsDir() {
+				return os.MkdirAll(filepath.Join(dst, rel), 0755)
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+		})
+	case "deletefile":
+		if err := os.Remove(f.local(args[1])); os.IsNotExist(err) {
+			return nil, errNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case "purge":
+		dir := f.local(args[1])
+		if _, err := os.Stat(dir); err != nil {
+			return nil, errNotFound
+		}
+		return nil, os.RemoveAll(dir)
+	case "moveto":
+		target := f.local(args[2])
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		return nil, os.Rename(f.local(args[1]), target)
+	}
+	return nil, fmt.Errorf("unexpected rclone command %s", args[0])
+}
+
+func newTestStorage(t *testing.T) (*Storage, *fakeRclone) {
+	t.Helper()
+	fake := &fakeRclone{root: t.TempDir()}
+	return newStorage(fake, "fake:backups"), fake
+}
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, os.WriteFile(p, []byte(data), 0644))
+}
+
+func TestUploadListDelete(t *testing.T) {
+	s, _ := newTestStorage(t)
+	metrics := storage.NewMetrics()
+	s.metrics = metrics
+	ctx := context.Background()
+
+	staging := t.TempDir()
+	writeFile(t, filepath.Join(staging, "pg_backup_20250101-000000.sql"), "dump")
+	writeFile(t, filepath.Join(staging, "minio_backup_20250101-000000", "bucket", "a.txt"), "aa")
+	writeFile(t, filepath.Join(staging, "minio_backup_20250101-000000", "bucket", "sub", "b.txt"), "bbb")
+	writeFile(t, filepath.Join(staging, ".partial", "c.txt"), "hidden")
+
+	result, err := s.Upload(ctx, "myjob", staging)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"minio_backup_20250101-000000", "pg_backup_20250101-000000.sql"}, result.Entries)
+	assert.Equal(t, 3, result.Uploaded)
+	assert.Equal(t, int64(9), result.Bytes)
+	assert.Equal(t, int64(9), metrics.GetAll()["rclone"].UploadedBytes)
+
+	result, err = s.Upload(ctx, "myjob", staging)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Skipped, "files already on the remote are counted as skipped")
+	assert.Zero(t, result.Uploaded)
+
+	entries, err := s.List(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "myjob/minio_backup_20250101-000000/", entries[0].Key)
+	assert.Equal(t, int64(5), entries[0].Size)
+	assert.Equal(t, "myjob/pg_backup_20250101-000000.sql", entries[1].Key)
+	assert.Equal(t, int64(4), entries[1].Size)
+	assert.WithinDuration(t, time.Now(), entries[1].ModTime, time.Minute)
+
+	require.NoError(t, s.Delete(ctx, entries[0]))
+	require.NoError(t, s.Delete(ctx, entries[0]), "a backup that is already gone is fine")
+	entries, err = s.List(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "myjob/pg_backup_20250101-000000.sql", entries[0].Key)
+}
+
+func TestListMissingJob(t *testing.T) {
+	s, _ := newTestStorage(t)
+
+	entries, err := s.List(context.Background(), "nothing")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestMoveToTrash(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+
+	staging := t.TempDir()
+	writeFile(t, filepath.Join(staging, "mirror_20250101-000000", "a.txt"), "aa")
+	_, err := s.Upload(ctx, "myjob", staging)
+	require.NoError(t, err)
+
+	entries, err := s.List(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, s.MoveToTrash(ctx, "myjob", entries[0]))
+
+	entries, err = s.List(ctx, "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	trashed, err := s.ListTrash(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	assert.True(t, strings.HasPrefix(trashed[0].Key, "myjob/.trash/"))
+	assert.True(t, strings.HasSuffix(trashed[0].Key, "_mirror_20250101-000000/"))
+	assert.Equal(t, int64(2), trashed[0].Size)
+	assert.WithinDuration(t, time.Now(), trashed[0].ModTime, 2*time.Second)
+
+	require.NoError(t, s.Delete(ctx, trashed[0]))
+	trashed, err = s.ListTrash(ctx, "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, trashed)
+}
+
+func TestNewWriter(t *testing.T) {
+	s, fake := newTestStorage(t)
+
+	w, err := s.NewWriter("myjob", "dump_20250101-000000.sql")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("streamed"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(filepath.Join(fake.root, "backups", "myjob", "dump_20250101-000000.sql"))
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(data))
+	assert.Equal(t, []string{"rcat"}, fake.commands)
+}
+
+func TestRenameJob(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+
+	staging := t.TempDir()
+	writeFile(t, filepath.Join(staging, "a_20250101-000000.sql"), "a")
+	_, err := s.Upload(ctx, "old", staging)
+	require.NoError(t, err)
+
+	moved, err := s.RenameJob(ctx, "old", "new")
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+
+	entries, err := s.List(ctx, "new")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "new/a_20250101-000000.sql", entries[0].Key)
+	entries, err = s.List(ctx, "old")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, err = s.Upload(ctx, "old", staging)
+	require.NoError(t, err)
+	_, err = s.RenameJob(ctx, "old", "new")
+	assert.ErrorContains(t, err, "new already has backups")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(config.RcloneConfig{Remote: "/mnt/backups"}, nil)
+	assert.Error(t, err)
+
+	s, err := New(config.RcloneConfig{Remote: "gdrive:", ConfigFile: "/etc/rclone.conf", Flags: []string{"--transfers=8"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "gdrive:myjob/a.sql", s.path("myjob/a.sql"))
+	r := s.rclone.(*execRunner)
+	assert.Equal(t, "rclone", r.binary)
+	assert.Equal(t, []string{"--config", "/etc/rclone.conf", "--transfers=8"}, r.flags)
+}
@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// b2BackendName is the name of Backblaze B2 uploads in metrics
+const b2BackendName = "b2"
+
+// NewB2 creates a storage in a Backblaze B2 bucket, reached through its S3
+// compatible API with an application key. Uploads are recorded in metrics as
+// the "b2" backend when it is not nil.
+func NewB2(cfg config.B2Config, metrics *storage.Metrics) (*Storage, error) {
+	store, err := newMinioStore(cfg.S3Config())
+	if err != nil {
+		return nil, err
+	}
+	s := newStorage(&b2Store{minioStore: store, hideOnly: cfg.HideOnly}, cfg.Prefix)
+	s.metrics = metrics
+	s.backend = b2BackendName
+	return s, nil
+}
+
+// b2Store removes objects the way B2 bills them. Deleting a file through the
+// S3 API only hides it and every version stays stored, so a removal deletes
+// all versions of the file unless the bucket's lifecycle rules are trusted to
+// do so.
+type b2Store struct {
+	*minioStore
+	hideOnly bool
+}
+
+func (b *b2Store) Remove(ctx context.Context, key string) error {
+	return b.RemoveAll(ctx, []string{key})
+}
+
+// RemoveAll lists the versions below the keys' common prefix once and
+// deletes those of the keys in batches
+func (b *b2Store) RemoveAll(ctx context.Context, keys []string) error {
+	if b.hideOnly {
+		return b.minioStore.RemoveAll(ctx, keys)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefix := keys[0]
+	wanted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+		for !strings.HasPrefix(key, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	var versions []minio.ObjectInfo
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithVersions: true}
+	for object := range b.client.ListObjects(ctx, b.bucket, opts) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list the versions below %s: %w", prefix, object.Err)
+		}
+		if wanted[object.Key] {
+			versions = append(versions, minio.ObjectInfo{Key: object.Key, VersionID: object.VersionID})
+		}
+	}
+	return b.removeObjects(ctx, versions)
+}
+
+// SetTransitions fails, B2 has no storage classes to move backups to
+func (b *b2Store) SetTransitions(ctx context.Context, ruleID, prefix string, transitions []config.TransitionConfig) error {
+	if len(transitions) == 0 {
+		return nil
+	}
+	return fmt.Errorf("b2 storage does not support transitions")
+}
@@ -0,0 +1,245 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// sha256MetaKey is the user metadata key holding an object's SHA-256 checksum
+const sha256MetaKey = "Backmeup-Sha256"
+
+// storageClassHeader sets the storage class of a copy
+const storageClassHeader = "X-Amz-Storage-Class"
+
+// objectInfo is the subset of object attributes the storage works with
+type objectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	SHA256       string // Only set by stat
+	StorageClass string // Empty for the store's default class
+}
+
+// putOptions are the attributes of a stored object
+type putOptions struct {
+	SHA256       string            // Copies keep the source's metadata when empty
+	StorageClass string            // Empty for the store's default class
+	Tags         map[string]string // Copies keep the source's tags when nil
+}
+
+// objectStore is the bucket API used by Storage, implemented by minioStore
+type objectStore interface {
+	Stat(ctx context.Context, key string) (objectInfo, bool, error)
+	List(ctx context.Context, prefix string) ([]objectInfo, error)
+	UploadFile(ctx context.Context, key, path string, opts putOptions) error
+	UploadStream(ctx context.Context, key string, r io.Reader, opts putOptions) error
+	Copy(ctx context.Context, dstKey, srcKey string, opts putOptions) error
+	Download(ctx context.Context, key, path string) error
+	Tags(ctx context.Context, key string) (map[string]string, error)
+	Remove(ctx context.Context, key string) error
+	// RemoveAll removes many objects with batched delete requests
+	RemoveAll(ctx context.Context, keys []string) error
+
+	// SetTransitions replaces the lifecycle rules whose IDs start with
+	// ruleID by one transition rule per entry for objects below prefix
+	SetTransitions(ctx context.Context, ruleID, prefix string, transitions []config.TransitionConfig) error
+}
+
+type minioStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func newMinioStore(cfg config.S3Config) (*minioStore, error) {
+	endpoint := strings.TrimPrefix(strings.TrimPrefix(cfg.Endpoint, "https://"), "http://")
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &minioStore{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (m *minioStore) Stat(ctx context.Context, key string) (objectInfo, bool, error) {
+	info, err := m.client.StatObject(ctx, m.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return objectInfo{}, false, nil
+		}
+		return objectInfo{}, false, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+
+	var sum string
+	for k, v := range info.UserMetadata {
+		if strings.EqualFold(k, sha256MetaKey) {
+			sum = v
+		}
+	}
+
+	return objectInfo{Key: info.Key, Size: info.Size, LastModified: info.LastModified, SHA256: sum, StorageClass: info.StorageClass}, true, nil
+}
+
+func (m *minioStore) List(ctx context.Context, prefix string) ([]objectInfo, error) {
+	var objects []objectInfo
+	for object := range m.client.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, object.Err)
+		}
+		objects = append(objects, objectInfo{Key: object.Key, Size: object.Size, LastModified: object.LastModified, StorageClass: object.StorageClass})
+	}
+	return objects, nil
+}
+
+func (m *minioStore) UploadFile(ctx context.Context, key, path string, opts putOptions) error {
+	_, err := m.client.FPutObject(ctx, m.bucket, key, path, minio.PutObjectOptions{
+		UserMetadata: map[string]string{sha256MetaKey: opts.SHA256},
+		StorageClass: opts.StorageClass,
+		UserTags:     opts.Tags,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
+
+func (m *minioStore) UploadStream(ctx context.Context, key string, r io.Reader, opts putOptions) error {
+	putOpts := minio.PutObjectOptions{StorageClass: opts.StorageClass, UserTags: opts.Tags}
+	if _, err := m.client.PutObject(ctx, m.bucket, key, r, -1, putOpts); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// Copy duplicates an object on the server. ComposeObject is used because
+// CopyObject is limited to 5 GiB. Copies land in the default storage class
+// unless one is given, the storage class header is passed as metadata.
+func (m *minioStore) Copy(ctx context.Context, dstKey, srcKey string, opts putOptions) error {
+	dst := minio.CopyDestOptions{Bucket: m.bucket, Object: dstKey}
+	if opts.SHA256 != "" {
+		dst.UserMetadata = map[string]string{sha256MetaKey: opts.SHA256}
+		dst.ReplaceMetadata = true
+	}
+	if opts.StorageClass != "" {
+		if dst.UserMetadata == nil {
+			dst.UserMetadata = make(map[string]string)
+		}
+		dst.UserMetadata[storageClassHeader] = opts.StorageClass
+	}
+	if opts.Tags != nil {
+		dst.UserTags = opts.Tags
+		dst.ReplaceTags = true
+	}
+
+	if _, err := m.client.ComposeObject(ctx, dst, minio.CopySrcOptions{Bucket: m.bucket, Object: srcKey}); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+func (m *minioStore) Download(ctx context.Context, key, path string) error {
+	if err := m.client.FGetObject(ctx, m.bucket, key, path, minio.GetObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return nil
+}
+
+func (m *minioStore) Tags(ctx context.Context, key string) (map[string]string, error) {
+	t, err := m.client.GetObjectTagging(ctx, m.bucket, key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the tags of %s: %w", key, err)
+	}
+	return t.ToMap(), nil
+}
+
+func (m *minioStore) Remove(ctx context.Context, key string) error {
+	if err := m.client.RemoveObject(ctx, m.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", key, err)
+	}
+	return nil
+}
+
+func (m *minioStore) RemoveAll(ctx context.Context, keys []string) error {
+	objects := make([]minio.ObjectInfo, len(keys))
+	for i, key := range keys {
+		objects[i] = minio.ObjectInfo{Key: key}
+	}
+	return m.removeObjects(ctx, objects)
+}
+
+// removeObjects deletes objects, or versions of objects, with DeleteObjects
+// requests of up to 1000 keys each, and returns the first failure
+func (m *minioStore) removeObjects(ctx context.Context, objects []minio.ObjectInfo) error {
+	ch := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(ch)
+		for _, object := range objects {
+			select {
+			case ch <- object:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var first error
+	for result := range m.client.RemoveObjects(ctx, m.bucket, ch, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil && first == nil {
+			first = fmt.Errorf("failed to remove %s: %w", result.ObjectName, result.Err)
+		}
+	}
+	if first == nil {
+		first = ctx.Err()
+	}
+	return first
+}
+
+// noLifecycle is the error code of a bucket without lifecycle rules
+const noLifecycle = "NoSuchLifecycleConfiguration"
+
+func (m *minioStore) SetTransitions(ctx context.Context, ruleID, prefix string, transitions []config.TransitionConfig) error {
+	current, err := m.client.GetBucketLifecycle(ctx, m.bucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != noLifecycle {
+			return fmt.Errorf("failed to read lifecycle rules of bucket %s: %w", m.bucket, err)
+		}
+		current = lifecycle.NewConfiguration()
+	}
+
+	rules := make([]lifecycle.Rule, 0, len(current.Rules)+len(transitions))
+	for _, rule := range current.Rules {
+		if !strings.HasPrefix(rule.ID, ruleID) {
+			rules = append(rules, rule)
+		}
+	}
+	for i, t := range transitions {
+		rules = append(rules, lifecycle.Rule{
+			ID:         fmt.Sprintf("%s%d", ruleID, i),
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: prefix},
+			Transition: lifecycle.Transition{
+				Days:         lifecycle.ExpirationDays(t.After / (24 * time.Hour)),
+				StorageClass: t.StorageClass,
+			},
+		})
+	}
+	current.Rules = rules
+
+	if err := m.client.SetBucketLifecycle(ctx, m.bucket, current); err != nil {
+		return fmt.Errorf("failed to set lifecycle rules of bucket %s: %w", m.bucket, err)
+	}
+	return nil
+}
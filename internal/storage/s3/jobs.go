@@ -0,0 +1,66 @@
+package s3
+
+import (
+	"context"
+	"slices"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+)
+
+// lifecycleRulePrefix starts the IDs of the lifecycle rules backmeup manages,
+// rules set by anyone else are left alone
+const lifecycleRulePrefix = "backmeup/"
+
+// ConfigureJob records the storage class and tags of a job's uploads and
+// replaces the bucket lifecycle rules that move its backups to cheaper
+// classes when its transitions changed. The class and tags apply even if
+// the rules cannot be set.
+func (s *Storage) ConfigureJob(ctx context.Context, jobConfig config.JobConfig) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	s.jobs[jobConfig.Name] = jobConfig
+	applied, ok := s.transitions[jobConfig.Name]
+	if ok && slices.Equal(applied, jobConfig.Transitions) {
+		return nil
+	}
+	// Nothing to remove for a job that never had transitions
+	if !ok && len(jobConfig.Transitions) == 0 {
+		s.transitions[jobConfig.Name] = nil
+		return nil
+	}
+
+	ruleID := lifecycleRulePrefix + jobConfig.Name + "/"
+	if err := s.store.SetTransitions(ctx, ruleID, s.jobPrefix(jobConfig.Name), jobConfig.Transitions); err != nil {
+		return err
+	}
+	s.transitions[jobConfig.Name] = slices.Clone(jobConfig.Transitions)
+	return nil
+}
+
+// putOptions returns the storage class and tags of a job's uploads. Jobs
+// that were never configured use the storage's default class and are not
+// tagged with a retention class.
+func (s *Storage) putOptions(jobName, runID string) putOptions {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+
+	jobConfig, ok := s.jobs[jobName]
+	if !ok {
+		jobConfig = config.JobConfig{Name: jobName}
+	}
+	opts := putOptions{StorageClass: jobConfig.StorageClass}
+	if opts.StorageClass == "" {
+		opts.StorageClass = s.defaultClass
+	}
+	if s.objectTags != nil {
+		opts.Tags = s.objectTags.Tags(jobConfig, runID)
+	}
+	return opts
+}
+
+// archived reports whether objects of a storage class must be restored
+// before they can be read or copied
+func archived(storageClass string) bool {
+	return storageClass == "GLACIER" || storageClass == "DEEP_ARCHIVE"
+}
@@ -0,0 +1,153 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// Download stores every backup of a job in the bucket in a local directory,
+// dated by their objects so that age based retention still sees their real
+// age. Files already present with the same checksum are skipped and every
+// downloaded file is checked against the checksum recorded on upload.
+func (s *Storage) Download(ctx context.Context, jobName, localDir string) (storage.DownloadResult, error) {
+	var result storage.DownloadResult
+
+	entries, err := s.List(ctx, jobName)
+	if err != nil {
+		return result, err
+	}
+
+	prefix := s.jobPrefix(jobName)
+	for _, entry := range entries {
+		objects := []objectInfo{{Key: entry.Key}}
+		if strings.HasSuffix(entry.Key, "/") {
+			if objects, err = s.store.List(ctx, entry.Key); err != nil {
+				return result, err
+			}
+		}
+		for _, object := range objects {
+			localPath := filepath.Join(localDir, filepath.FromSlash(strings.TrimPrefix(object.Key, prefix)))
+			if err := s.fetchFile(ctx, object.Key, localPath, &result); err != nil {
+				return result, err
+			}
+		}
+
+		// Writing the files of a directory backup changed its time
+		name := strings.TrimSuffix(strings.TrimPrefix(entry.Key, prefix), "/")
+		if err := os.Chtimes(filepath.Join(localDir, name), entry.ModTime, entry.ModTime); err != nil {
+			return result, fmt.Errorf("failed to date %s: %w", name, err)
+		}
+		result.Entries = append(result.Entries, name)
+	}
+	return result, nil
+}
+
+// fetchFile makes sure the local file holds the content of key
+func (s *Storage) fetchFile(ctx context.Context, key, localPath string, result *storage.DownloadResult) error {
+	object, found, err := s.store.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s disappeared from the bucket during the download", key)
+	}
+	if archived(object.StorageClass) {
+		return fmt.Errorf("%s is in storage class %s and must be restored in the bucket before it can be downloaded", key, object.StorageClass)
+	}
+
+	if sum, size, err := fileSHA256(localPath); err == nil && matches(object, sum, size) {
+		result.Skipped++
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", localPath, err)
+	}
+	if err := s.store.Download(ctx, key, localPath); err != nil {
+		return err
+	}
+	sum, size, err := fileSHA256(localPath)
+	if err != nil {
+		return err
+	}
+	if !matches(object, sum, size) {
+		os.Remove(localPath)
+		return fmt.Errorf("downloaded %s does not match the checksum recorded in the bucket", key)
+	}
+	if err := os.Chtimes(localPath, object.LastModified, object.LastModified); err != nil {
+		return fmt.Errorf("failed to date %s: %w", localPath, err)
+	}
+
+	result.Downloaded++
+	result.Bytes += size
+	return nil
+}
+
+// Verify checks every backup in a job's local directory against the bucket
+// and returns how many files it compared. Objects streamed into the bucket
+// carry no checksum and are compared by size.
+func (s *Storage) Verify(ctx context.Context, jobName, localDir string) (int, error) {
+	entries, err := os.ReadDir(localDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	prefix := s.jobPrefix(jobName)
+	var checked int
+	var mismatched []error
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		err := filepath.WalkDir(filepath.Join(localDir, e.Name()), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(localDir, p)
+			if err != nil {
+				return err
+			}
+			key := prefix + filepath.ToSlash(rel)
+
+			sum, size, err := fileSHA256(p)
+			if err != nil {
+				return err
+			}
+			object, found, err := s.store.Stat(ctx, key)
+			if err != nil {
+				return err
+			}
+			checked++
+			switch {
+			case !found:
+				mismatched = append(mismatched, fmt.Errorf("%s is missing from the bucket", key))
+			case !matches(object, sum, size):
+				mismatched = append(mismatched, fmt.Errorf("%s differs from %s", key, p))
+			}
+			return nil
+		})
+		if err != nil {
+			return checked, err
+		}
+	}
+	return checked, errors.Join(mismatched...)
+}
+
+// matches compares a local file with an object, by checksum when the object
+// has one
+func matches(object objectInfo, sum string, size int64) bool {
+	if object.SHA256 != "" {
+		return object.SHA256 == sum
+	}
+	return object.Size == size
+}
@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// RenameJob moves the backups and trash of a job below the prefix of its new
+// name and returns how many backups were moved. Objects keep their storage
+// class, checksum and tags, with the job tag set to the new name, and the
+// lifecycle rules of the old name are removed. Nothing is moved when any
+// object is archived or its name is taken by the new job.
+func (s *Storage) RenameJob(ctx context.Context, from, to string) (int, error) {
+	fromPrefix, toPrefix := s.jobPrefix(from), s.jobPrefix(to)
+
+	objects, err := s.store.List(ctx, fromPrefix)
+	if err != nil {
+		return 0, err
+	}
+	for _, object := range objects {
+		if archived(object.StorageClass) {
+			return 0, fmt.Errorf("%s is in storage class %s and must be restored before it can be moved", object.Key, object.StorageClass)
+		}
+		dstKey := toPrefix + strings.TrimPrefix(object.Key, fromPrefix)
+		dst, found, err := s.store.Stat(ctx, dstKey)
+		if err != nil {
+			return 0, err
+		}
+		if !found {
+			continue
+		}
+		// Copies left by an interrupted rename may be made again
+		src, _, err := s.store.Stat(ctx, object.Key)
+		if err != nil {
+			return 0, err
+		}
+		if src.SHA256 == "" || src.SHA256 != dst.SHA256 || src.Size != dst.Size {
+			return 0, fmt.Errorf("cannot move %s, %s already exists", object.Key, dstKey)
+		}
+	}
+
+	for _, object := range objects {
+		opts := putOptions{StorageClass: object.StorageClass}
+		if s.objectTags != nil {
+			tags, err := s.store.Tags(ctx, object.Key)
+			if err != nil {
+				return 0, err
+			}
+			if jobKey, _, _ := s.objectTags.Keys(); tags[jobKey] == from {
+				tags[jobKey] = to
+				opts.Tags = tags
+			}
+		}
+		if err := s.store.Copy(ctx, toPrefix+strings.TrimPrefix(object.Key, fromPrefix), object.Key, opts); err != nil {
+			return 0, err
+		}
+	}
+	// The old objects are only removed once every copy exists
+	if err := s.store.RemoveAll(ctx, objectKeys(objects)); err != nil {
+		return 0, err
+	}
+
+	var moved int
+	for _, entry := range groupEntries(fromPrefix, objects) {
+		if entry.Key != fromPrefix+trashDirName+"/" {
+			moved++
+		}
+	}
+	s.jobsMu.Lock()
+	delete(s.jobs, from)
+	delete(s.transitions, from)
+	s.jobsMu.Unlock()
+	if err := s.store.SetTransitions(ctx, lifecycleRulePrefix+from+"/", fromPrefix, nil); err != nil {
+		return moved, fmt.Errorf("failed to remove the lifecycle rules of job %s: %w", from, err)
+	}
+	return moved, nil
+}
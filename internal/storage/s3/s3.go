@@ -0,0 +1,241 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const (
+	backendName    = "s3"
+	trashDirName   = ".trash"
+	trashTimestamp = "20060102-150405"
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+// Storage keeps backups in an S3 compatible bucket under <prefix><job>/.
+// A backup is either a single object or every object below <job>/<name>/.
+type Storage struct {
+	store   objectStore
+	prefix  string
+	metrics *storage.Metrics
+	backend string // Name of the backend in metrics
+
+	defaultClass string
+	objectTags   *config.ObjectTagsConfig
+	jobsMu       sync.Mutex
+	jobs         map[string]config.JobConfig          // Settings of each job's uploads
+	transitions  map[string][]config.TransitionConfig // Lifecycle rules last set for each job
+}
+
+// New creates an S3 storage from the configuration. Uploads are recorded in
+// metrics as the "s3" backend when it is not nil.
+func New(cfg config.S3Config, metrics *storage.Metrics) (*Storage, error) {
+	store, err := newMinioStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := newStorage(store, cfg.Prefix)
+	s.metrics = metrics
+	s.defaultClass = cfg.StorageClass
+	s.objectTags = cfg.ObjectTags
+	return s, nil
+}
+
+func newStorage(store objectStore, prefix string) *Storage {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Storage{
+		store:       store,
+		prefix:      prefix,
+		backend:     backendName,
+		jobs:        make(map[string]config.JobConfig),
+		transitions: make(map[string][]config.TransitionConfig),
+	}
+}
+
+func (s *Storage) jobPrefix(jobName string) string {
+	return s.prefix + jobName + "/"
+}
+
+// NewWriter streams a backup straight into the bucket
+func (s *Storage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	w := &streamWriter{pipe: pw, done: make(chan error, 1)}
+
+	go func() {
+		err := s.store.UploadStream(context.Background(), s.jobPrefix(jobName)+fileName, pr, s.putOptions(jobName, ""))
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return storage.NewTimedWriter(w, func(bytes int64, elapsed time.Duration, err error) {
+		s.metrics.RecordUpload(s.backend, bytes, elapsed, err)
+	}), nil
+}
+
+// NewDir is not supported, executors that write directories use the local
+// staging area, which is uploaded with Upload
+func (s *Storage) NewDir(jobName, dirName string) (string, error) {
+	return "", fmt.Errorf("s3 storage cannot create directory %s/%s, stage it locally and upload it", jobName, dirName)
+}
+
+func (s *Storage) List(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	prefix := s.jobPrefix(jobName)
+	objects, err := s.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []objectInfo
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, prefix+trashDirName+"/") {
+			live = append(live, object)
+		}
+	}
+	return groupEntries(prefix, live), nil
+}
+
+// Delete removes a single object, or every object of a directory backup in
+// batches
+func (s *Storage) Delete(ctx context.Context, entry storage.BackupEntry) error {
+	if !strings.HasSuffix(entry.Key, "/") {
+		return s.store.Remove(ctx, entry.Key)
+	}
+
+	objects, err := s.store.List(ctx, entry.Key)
+	if err != nil {
+		return err
+	}
+	return s.store.RemoveAll(ctx, objectKeys(objects))
+}
+
+// MoveToTrash copies a backup below <job>/.trash/ with the time it was
+// trashed as a prefix, then removes the original
+func (s *Storage) MoveToTrash(ctx context.Context, jobName string, entry storage.BackupEntry) error {
+	prefix := s.jobPrefix(jobName)
+	trashPrefix := prefix + trashDirName + "/" + time.Now().Format(trashTimestamp) + "_"
+
+	// Copies keep the storage class of each object
+	var objects []objectInfo
+	if strings.HasSuffix(entry.Key, "/") {
+		var err error
+		if objects, err = s.store.List(ctx, entry.Key); err != nil {
+			return err
+		}
+	} else {
+		object, found, err := s.store.Stat(ctx, entry.Key)
+		if err != nil {
+			return err
+		}
+		if !found {
+			object = objectInfo{Key: entry.Key}
+		}
+		objects = []objectInfo{object}
+	}
+
+	for _, object := range objects {
+		if err := s.store.Copy(ctx, trashPrefix+strings.TrimPrefix(object.Key, prefix), object.Key, putOptions{StorageClass: object.StorageClass}); err != nil {
+			return fmt.Errorf("failed to move backup to trash: %w", err)
+		}
+	}
+	return s.store.RemoveAll(ctx, objectKeys(objects))
+}
+
+func (s *Storage) ListTrash(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	trashPrefix := s.jobPrefix(jobName) + trashDirName + "/"
+	objects, err := s.store.List(ctx, trashPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := groupEntries(trashPrefix, objects)
+	trashed := make([]storage.BackupEntry, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.Key, trashPrefix)
+		stamp, _, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+		trashedAt, err := time.ParseInLocation(trashTimestamp, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		entry.ModTime = trashedAt
+		trashed = append(trashed, entry)
+	}
+	return trashed, nil
+}
+
+// objectKeys returns the keys of objects
+func objectKeys(objects []objectInfo) []string {
+	keys := make([]string, len(objects))
+	for i, object := range objects {
+		keys[i] = object.Key
+	}
+	return keys
+}
+
+// groupEntries turns the objects below prefix into backup entries. Objects in
+// a sub directory form one entry whose key ends with a slash, sized as the
+// sum of its objects and dated by the newest one.
+func groupEntries(prefix string, objects []objectInfo) []storage.BackupEntry {
+	byName := make(map[string]*storage.BackupEntry)
+	for _, object := range objects {
+		rel := strings.TrimPrefix(object.Key, prefix)
+		if rel == "" {
+			continue
+		}
+
+		key := object.Key
+		name := rel
+		if dir, _, nested := strings.Cut(rel, "/"); nested {
+			name = dir + "/"
+			key = prefix + name
+		}
+
+		entry, ok := byName[name]
+		if !ok {
+			entry = &storage.BackupEntry{Key: key}
+			byName[name] = entry
+		}
+		entry.Size += object.Size
+		if object.LastModified.After(entry.ModTime) {
+			entry.ModTime = object.LastModified
+		}
+	}
+
+	entries := make([]storage.BackupEntry, 0, len(byName))
+	for _, entry := range byName {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// streamWriter feeds an upload running in the background and reports its
+// result on Close
+type streamWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *streamWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
@@ -0,0 +1,503 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/runid"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+type memoryObject struct {
+	data    []byte
+	sha256  string
+	class   string
+	tags    map[string]string
+	modTime time.Time
+}
+
+// memoryStore is an in-memory objectStore that counts the data sent to it
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+	uploads int
+	copies  int
+	batches int                                  // RemoveAll calls
+	rules   map[string][]config.TransitionConfig // Transitions by rule ID prefix
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string]memoryObject), rules: make(map[string][]config.TransitionConfig)}
+}
+
+func (m *memoryStore) Stat(_ context.Context, key string) (objectInfo, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	object, ok := m.objects[key]
+	if !ok {
+		return objectInfo{}, false, nil
+	}
+	return objectInfo{Key: key, Size: int64(len(object.data)), LastModified: object.modTime, SHA256: object.sha256, StorageClass: object.class}, true, nil
+}
+
+func (m *memoryStore) List(_ context.Context, prefix string) ([]objectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var objects []objectInfo
+	for key, object := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, objectInfo{Key: key, Size: int64(len(object.data)), LastModified: object.modTime, StorageClass: object.class})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *memoryStore) UploadFile(_ context.Context, key, path string, opts putOptions) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{data: data, sha256: opts.SHA256, class: opts.StorageClass, tags: opts.Tags, modTime: time.Now()}
+	m.uploads++
+	return nil
+}
+
+func (m *memoryStore) UploadStream(_ context.Context, key string, r io.Reader, opts putOptions) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{data: data, class: opts.StorageClass, tags: opts.Tags, modTime: time.Now()}
+	m.uploads++
+	return nil
+}
+
+func (m *memoryStore) Copy(_ context.Context, dstKey, srcKey string, opts putOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	object := m.objects[srcKey]
+	if opts.SHA256 != "" {
+		object.sha256 = opts.SHA256
+	}
+	if opts.Tags != nil {
+		object.tags = opts.Tags
+	}
+	object.class = opts.StorageClass
+	object.modTime = time.Now()
+	m.objects[dstKey] = object
+	m.copies++
+	return nil
+}
+
+func (m *memoryStore) Download(_ context.Context, key, path string) error {
+	m.mu.Lock()
+	object, ok := m.objects[key]
+	m.mu.Unlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+	return os.WriteFile(path, object.data, 0644)
+}
+
+func (m *memoryStore) Tags(_ context.Context, key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.objects[key].tags), nil
+}
+
+func (m *memoryStore) Remove(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memoryStore) RemoveAll(_ context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.objects, key)
+	}
+	m.batches++
+	return nil
+}
+
+func (m *memoryStore) SetTransitions(_ context.Context, ruleID, _ string, transitions []config.TransitionConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules[ruleID] = transitions
+	return nil
+}
+
+func (m *memoryStore) put(key, data string, modTime time.Time) {
+	m.objects[key] = memoryObject{data: []byte(data), modTime: modTime}
+}
+
+func TestList_GroupsDirectories(t *testing.T) {
+	store := newMemoryStore()
+	now := time.Now()
+	store.put("backups/myjob/backup_1.sql", "aaaa", now.Add(-2*time.Hour))
+	store.put("backups/myjob/mirror_1/a.txt", "aa", now.Add(-time.Hour))
+	store.put("backups/myjob/mirror_1/sub/b.txt", "bbb", now)
+	store.put("backups/myjob/.trash/20250101-000000_backup_0.sql", "old", now)
+	store.put("backups/otherjob/backup_1.sql", "x", now)
+	s := newStorage(store, "backups")
+
+	entries, err := s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, "backups/myjob/backup_1.sql", entries[0].Key)
+	assert.Equal(t, int64(4), entries[0].Size)
+
+	assert.Equal(t, "backups/myjob/mirror_1/", entries[1].Key)
+	assert.Equal(t, int64(5), entries[1].Size)
+	assert.True(t, entries[1].ModTime.Equal(now), "a directory is dated by its newest object")
+}
+
+func TestMoveToTrash(t *testing.T) {
+	store := newMemoryStore()
+	store.put("myjob/mirror_1/a.txt", "aa", time.Now())
+	store.put("myjob/mirror_1/b.txt", "bb", time.Now())
+	s := newStorage(store, "")
+
+	entries, err := s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	require.NoError(t, s.MoveToTrash(context.Background(), "myjob", entries[0]))
+
+	entries, err = s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	trashed, err := s.ListTrash(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	assert.Equal(t, int64(4), trashed[0].Size)
+	assert.WithinDuration(t, time.Now(), trashed[0].ModTime, 2*time.Second)
+
+	require.NoError(t, s.Delete(context.Background(), trashed[0]))
+	assert.Empty(t, store.objects)
+}
+
+func TestDelete_DirectoryInOneBatch(t *testing.T) {
+	store := newMemoryStore()
+	store.put("myjob/mirror_1/a.txt", "aa", time.Now())
+	store.put("myjob/mirror_1/sub/b.txt", "bbb", time.Now())
+	store.put("myjob/mirror_2/c.txt", "c", time.Now())
+	s := newStorage(store, "")
+
+	entries, err := s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, int64(5), entries[0].Size)
+
+	require.NoError(t, s.Delete(context.Background(), entries[0]))
+	assert.Equal(t, 1, store.batches)
+	assert.Equal(t, []string{"myjob/mirror_2/c.txt"}, slices.Collect(maps.Keys(store.objects)))
+}
+
+func TestNewWriter(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "")
+
+	w, err := s.NewWriter("myjob", "backup.sql")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("dump"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, "dump", string(store.objects["myjob/backup.sql"].data))
+}
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestUpload_CopiesUnchangedFiles(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "")
+	dir := t.TempDir()
+	now := time.Now()
+
+	writeFile(t, filepath.Join(dir, "mirror_1", "static.txt"), "unchanged", now)
+	writeFile(t, filepath.Join(dir, "mirror_1", "data.txt"), "first", now)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "mirror_1"), now.Add(-time.Hour), now.Add(-time.Hour)))
+
+	result, err := s.Upload(context.Background(), "myjob", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mirror_1"}, result.Entries)
+	assert.Equal(t, 2, result.Uploaded)
+	assert.Equal(t, int64(len("unchanged")+len("first")), result.Bytes)
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "mirror_1")))
+
+	// The next run only changes one file
+	writeFile(t, filepath.Join(dir, "mirror_2", "static.txt"), "unchanged", now)
+	writeFile(t, filepath.Join(dir, "mirror_2", "data.txt"), "second", now)
+
+	result, err = s.Upload(context.Background(), "myjob", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mirror_2"}, result.Entries)
+	assert.Equal(t, 1, result.Uploaded)
+	assert.Equal(t, 1, result.Copied)
+	assert.Equal(t, int64(len("second")), result.Bytes)
+
+	assert.Equal(t, "unchanged", string(store.objects["myjob/mirror_2/static.txt"].data))
+	assert.Equal(t, "second", string(store.objects["myjob/mirror_2/data.txt"].data))
+	assert.NotEmpty(t, store.objects["myjob/mirror_2/static.txt"].sha256, "copies carry the checksum for the next run")
+}
+
+func TestUpload_SkipsAlreadyUploaded(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "")
+	s.metrics = storage.NewMetrics()
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "backup_1.sql"), "dump", time.Now())
+
+	_, err := s.Upload(context.Background(), "myjob", dir)
+	require.NoError(t, err)
+
+	// A retried upload finds the object in place
+	result, err := s.Upload(context.Background(), "myjob", dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Uploaded)
+	assert.Equal(t, 1, result.Skipped)
+	assert.Equal(t, 1, store.uploads)
+
+	uploads := s.metrics.GetAll()["s3"]
+	assert.Equal(t, 1, uploads.Uploads, "skipped files are not uploads")
+	assert.Equal(t, int64(len("dump")), uploads.UploadedBytes)
+}
+
+func TestUpload_ChangedFileIsUploaded(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "")
+	dir := t.TempDir()
+	now := time.Now()
+
+	writeFile(t, filepath.Join(dir, "backup_1.sql"), "dump", now.Add(-time.Hour))
+	writeFile(t, filepath.Join(dir, "backup_2.sql"), "dump", now)
+	writeFile(t, filepath.Join(dir, "backup_3.sql"), "changed", now.Add(time.Minute))
+
+	result, err := s.Upload(context.Background(), "myjob", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backup_1.sql", "backup_2.sql", "backup_3.sql"}, result.Entries)
+	assert.Equal(t, 2, result.Uploaded)
+	assert.Equal(t, 1, result.Copied, "backup_2 matches backup_1")
+}
+
+func TestUpload_MissingDirectory(t *testing.T) {
+	s := newStorage(newMemoryStore(), "")
+
+	result, err := s.Upload(context.Background(), "myjob", filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, result.Entries)
+}
+
+func TestConfigureJob(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "")
+	s.defaultClass = "STANDARD_IA"
+	dir := t.TempDir()
+	now := time.Now()
+
+	job := config.JobConfig{
+		Name:         "myjob",
+		StorageClass: "GLACIER",
+		Transitions:  []config.TransitionConfig{{After: 30 * 24 * time.Hour, StorageClass: "DEEP_ARCHIVE"}},
+	}
+	require.NoError(t, s.ConfigureJob(context.Background(), job))
+	assert.Equal(t, job.Transitions, store.rules["backmeup/myjob/"])
+	require.NoError(t, s.ConfigureJob(context.Background(), config.JobConfig{Name: "otherjob"}))
+	assert.NotContains(t, store.rules, "backmeup/otherjob/", "jobs without transitions leave the lifecycle alone")
+
+	writeFile(t, filepath.Join(dir, "backup_1.sql"), "dump", now.Add(-time.Hour))
+	writeFile(t, filepath.Join(dir, "backup_2.sql"), "dump", now)
+	result, err := s.Upload(context.Background(), "myjob", dir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Uploaded, "archived objects are not copied")
+	assert.Equal(t, "GLACIER", store.objects["myjob/backup_2.sql"].class)
+
+	_, err = s.Upload(context.Background(), "otherjob", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "STANDARD_IA", store.objects["otherjob/backup_1.sql"].class, "jobs without a class use the storage default")
+
+	job.Transitions = nil
+	require.NoError(t, s.ConfigureJob(context.Background(), job))
+	assert.Contains(t, store.rules, "backmeup/myjob/")
+	assert.Empty(t, store.rules["backmeup/myjob/"], "removed transitions remove the job's rules")
+}
+
+func TestUpload_TagsObjects(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "")
+	s.objectTags = &config.ObjectTagsConfig{RunIDKey: "run", Extra: map[string]string{"team": "platform"}}
+	dir := t.TempDir()
+	now := time.Now()
+
+	require.NoError(t, s.ConfigureJob(context.Background(), config.JobConfig{
+		Name:            "myjob",
+		RetentionPolicy: config.RetentionPolicy{Type: "days", Value: 30},
+	}))
+	writeFile(t, filepath.Join(dir, "backup_1.sql"), "dump", now.Add(-time.Hour))
+	writeFile(t, filepath.Join(dir, "backup_2.sql"), "dump", now)
+
+	ctx := runid.WithBackups(context.Background(), map[string]string{
+		"backup_1.sql": "20260301T020000Z-0a1b2c3d",
+		"backup_2.sql": "20260302T020000Z-4e5f6a7b",
+	})
+	result, err := s.Upload(ctx, "myjob", dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Copied)
+
+	assert.Equal(t, map[string]string{
+		"backmeup-job":       "myjob",
+		"backmeup-retention": "days-30",
+		"run":                "20260301T020000Z-0a1b2c3d",
+		"team":               "platform",
+	}, store.objects["myjob/backup_1.sql"].tags)
+	assert.Equal(t, "20260302T020000Z-4e5f6a7b", store.objects["myjob/backup_2.sql"].tags["run"], "copies are tagged with their own run")
+}
+
+func TestDownload(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "backups")
+	source := t.TempDir()
+	old := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+
+	writeFile(t, filepath.Join(source, "backup_1.sql"), "dump", old)
+	writeFile(t, filepath.Join(source, "mirror_1", "bucket", "a.txt"), "a", old)
+	_, err := s.Upload(context.Background(), "myjob", source)
+	require.NoError(t, err)
+	store.put("backups/myjob/streamed.sql", "stream", old)
+	for key, object := range store.objects {
+		object.modTime = old
+		store.objects[key] = object
+	}
+
+	target := t.TempDir()
+	result, err := s.Download(context.Background(), "myjob", target)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"backup_1.sql", "mirror_1", "streamed.sql"}, result.Entries)
+	assert.Equal(t, 3, result.Downloaded)
+	assert.Equal(t, int64(len("dump")+len("a")+len("stream")), result.Bytes)
+
+	data, err := os.ReadFile(filepath.Join(target, "mirror_1", "bucket", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(data))
+	info, err := os.Stat(filepath.Join(target, "mirror_1"))
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(old), "backups keep the age they had in the bucket")
+
+	count, err := s.Verify(context.Background(), "myjob", target)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// A second download finds everything in place
+	result, err = s.Download(context.Background(), "myjob", target)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Downloaded)
+	assert.Equal(t, 3, result.Skipped)
+
+	object := store.objects["backups/myjob/backup_1.sql"]
+	object.data = []byte("corrupt")
+	store.objects["backups/myjob/backup_1.sql"] = object
+	require.NoError(t, os.Remove(filepath.Join(target, "backup_1.sql")))
+	_, err = s.Download(context.Background(), "myjob", target)
+	assert.ErrorContains(t, err, "does not match the checksum")
+	assert.NoFileExists(t, filepath.Join(target, "backup_1.sql"))
+
+	object.class = "GLACIER"
+	store.objects["backups/myjob/backup_1.sql"] = object
+	_, err = s.Download(context.Background(), "myjob", target)
+	assert.ErrorContains(t, err, "must be restored in the bucket")
+}
+
+func TestVerify(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "")
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "backup_1.sql"), "dump", time.Now())
+	_, err := s.Upload(context.Background(), "myjob", dir)
+	require.NoError(t, err)
+	writeFile(t, filepath.Join(dir, "backup_1.sql"), "changed", time.Now())
+	writeFile(t, filepath.Join(dir, "backup_2.sql"), "new", time.Now())
+	writeFile(t, filepath.Join(dir, ".trash", "old.sql"), "trashed", time.Now())
+
+	count, err := s.Verify(context.Background(), "myjob", dir)
+	assert.Equal(t, 2, count)
+	assert.ErrorContains(t, err, "myjob/backup_1.sql differs from")
+	assert.ErrorContains(t, err, "myjob/backup_2.sql is missing from the bucket")
+}
+
+func TestRenameJob(t *testing.T) {
+	store := newMemoryStore()
+	s := newStorage(store, "backups")
+	s.objectTags = &config.ObjectTagsConfig{}
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "backup_1.sql"), "dump", time.Now())
+	writeFile(t, filepath.Join(dir, "mirror_1", "a.txt"), "a", time.Now())
+	require.NoError(t, s.ConfigureJob(ctx, config.JobConfig{Name: "old", StorageClass: "STANDARD_IA",
+		Transitions: []config.TransitionConfig{{After: 30 * 24 * time.Hour, StorageClass: "GLACIER"}}}))
+	_, err := s.Upload(runid.With(ctx, "run-1"), "old", dir)
+	require.NoError(t, err)
+	require.NoError(t, s.MoveToTrash(ctx, "old", storage.BackupEntry{Key: "backups/old/backup_1.sql"}))
+	_, err = s.Upload(runid.With(ctx, "run-1"), "old", dir)
+	require.NoError(t, err)
+
+	moved, err := s.RenameJob(ctx, "old", "new")
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+
+	entries, err := s.List(ctx, "old")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	entries, err = s.List(ctx, "new")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	trashed, err := s.ListTrash(ctx, "new")
+	require.NoError(t, err)
+	assert.Len(t, trashed, 1)
+
+	object := store.objects["backups/new/backup_1.sql"]
+	assert.Equal(t, "STANDARD_IA", object.class)
+	assert.NotEmpty(t, object.sha256)
+	assert.Equal(t, "new", object.tags[config.DefaultJobTagKey])
+	assert.Equal(t, "run-1", object.tags[config.DefaultRunIDTagKey])
+	assert.Empty(t, store.rules["backmeup/old/"], "the old name's lifecycle rules are removed")
+
+	// Archived objects cannot be copied, nothing moves
+	store.put("backups/cold/backup_1.sql", "dump", time.Now())
+	store.put("backups/cold/backup_2.sql", "dump", time.Now())
+	object = store.objects["backups/cold/backup_2.sql"]
+	object.class = "DEEP_ARCHIVE"
+	store.objects["backups/cold/backup_2.sql"] = object
+	_, err = s.RenameJob(ctx, "cold", "warm")
+	assert.ErrorContains(t, err, "must be restored")
+	assert.Contains(t, store.objects, "backups/cold/backup_1.sql")
+}
@@ -0,0 +1,176 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/runid"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// Upload stores every backup in a job's local staging directory in the bucket.
+// Files whose SHA-256 matches the same file of the job's previous backup are
+// copied on the server instead of being uploaded again, and files already
+// uploaded by an earlier attempt are skipped.
+func (s *Storage) Upload(ctx context.Context, jobName, localDir string) (storage.UploadResult, error) {
+	var result storage.UploadResult
+
+	entries, err := os.ReadDir(localDir)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to read staging directory: %w", err)
+	}
+
+	local := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return result, err
+		}
+		local = append(local, info)
+	}
+	sort.Slice(local, func(i, j int) bool {
+		return local[i].ModTime().Before(local[j].ModTime())
+	})
+
+	remote, err := s.List(ctx, jobName)
+	if err != nil {
+		return result, err
+	}
+
+	// The newest remote file and directory backups serve as references
+	var previousFile, previousDir string
+	sort.Slice(remote, func(i, j int) bool {
+		return remote[i].ModTime.Before(remote[j].ModTime)
+	})
+	for _, entry := range remote {
+		if strings.HasSuffix(entry.Key, "/") {
+			previousDir = entry.Key
+		} else {
+			previousFile = entry.Key
+		}
+	}
+
+	prefix := s.jobPrefix(jobName)
+	for _, info := range local {
+		localPath := filepath.Join(localDir, info.Name())
+		opts := s.putOptions(jobName, runid.ForBackup(ctx, info.Name()))
+
+		if !info.IsDir() {
+			key := prefix + info.Name()
+			reference := previousFile
+			if reference == key {
+				reference = ""
+			}
+			if err := s.syncFile(ctx, localPath, key, reference, opts, &result); err != nil {
+				return result, err
+			}
+			previousFile = key
+			result.Entries = append(result.Entries, info.Name())
+			continue
+		}
+
+		dirKey := prefix + info.Name() + "/"
+		reference := previousDir
+		if reference == dirKey {
+			reference = ""
+		}
+		err := filepath.WalkDir(localPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(localPath, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			var referenceKey string
+			if reference != "" {
+				referenceKey = path.Join(reference, rel)
+			}
+			return s.syncFile(ctx, p, dirKey+rel, referenceKey, opts, &result)
+		})
+		if err != nil {
+			return result, err
+		}
+		previousDir = dirKey
+		result.Entries = append(result.Entries, info.Name())
+	}
+
+	return result, nil
+}
+
+// syncFile makes sure key holds the content of the local file, preferring a
+// server side copy of referenceKey when the checksums match. Archived
+// references cannot be copied and are uploaded again.
+func (s *Storage) syncFile(ctx context.Context, localPath, key, referenceKey string, opts putOptions, result *storage.UploadResult) error {
+	sum, size, err := fileSHA256(localPath)
+	if err != nil {
+		return err
+	}
+	opts.SHA256 = sum
+
+	existing, found, err := s.store.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if found && existing.SHA256 == sum {
+		result.Skipped++
+		return nil
+	}
+
+	if referenceKey != "" {
+		reference, found, err := s.store.Stat(ctx, referenceKey)
+		if err != nil {
+			return err
+		}
+		if found && reference.SHA256 == sum && !archived(reference.StorageClass) {
+			if err := s.store.Copy(ctx, key, referenceKey, opts); err != nil {
+				return err
+			}
+			result.Copied++
+			return nil
+		}
+	}
+
+	start := time.Now()
+	if err := s.store.UploadFile(ctx, key, localPath, opts); err != nil {
+		s.metrics.RecordUpload(s.backend, 0, time.Since(start), err)
+		return err
+	}
+	s.metrics.RecordUpload(s.backend, size, time.Since(start), nil)
+	result.Uploaded++
+	result.Bytes += size
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,243 @@
+package split
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const (
+	// DirSuffix is appended to the artifact name to form the directory holding its parts
+	DirSuffix = ".split"
+	// ManifestName is the name of the manifest inside a split directory
+	ManifestName    = "manifest.json"
+	manifestVersion = 1
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+// Manifest describes how an artifact was split and how to put it back together
+type Manifest struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	PartSize  int64     `json:"part_size"`
+	Parts     []Part    `json:"parts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Part is one fixed-size piece of a split artifact
+type Part struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Storage splits every file written through NewWriter into parts of at most
+// partSize bytes, stored with a manifest in a <name>.split directory. Retention
+// sees the directory as a single backup.
+type Storage struct {
+	storage.Storage
+	partSize int64
+}
+
+// New wraps a storage so that written files are split into parts
+func New(inner storage.Storage, partSize int64) *Storage {
+	return &Storage{Storage: inner, partSize: partSize}
+}
+
+func (s *Storage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	dir := fileName + DirSuffix
+	if _, err := s.Storage.NewDir(jobName, dir); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		store:    s.Storage,
+		jobName:  jobName,
+		dir:      dir,
+		partSize: s.partSize,
+		total:    sha256.New(),
+		manifest: Manifest{
+			Version:  manifestVersion,
+			Name:     filepath.Base(fileName),
+			PartSize: s.partSize,
+		},
+	}, nil
+}
+
+// writer streams data into consecutive parts and writes the manifest on Close
+type writer struct {
+	store    storage.Storage
+	jobName  string
+	dir      string
+	partSize int64
+
+	part        io.WriteCloser
+	partHash    hash.Hash
+	partWritten int64
+
+	total    hash.Hash
+	manifest Manifest
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.part == nil {
+			if err := w.openPart(); err != nil {
+				return written, err
+			}
+		}
+
+		chunk := p
+		if remaining := w.partSize - w.partWritten; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		n, err := w.part.Write(chunk)
+		w.partHash.Write(chunk[:n])
+		w.total.Write(chunk[:n])
+		w.partWritten += int64(n)
+		w.manifest.Size += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		if w.partWritten == w.partSize {
+			if err := w.closePart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) openPart() error {
+	name := fmt.Sprintf("part-%05d", len(w.manifest.Parts)+1)
+	part, err := w.store.NewWriter(w.jobName, filepath.Join(w.dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to create part %s: %w", name, err)
+	}
+
+	w.part = part
+	w.partHash = sha256.New()
+	w.partWritten = 0
+	w.manifest.Parts = append(w.manifest.Parts, Part{Name: name})
+	return nil
+}
+
+func (w *writer) closePart() error {
+	current := &w.manifest.Parts[len(w.manifest.Parts)-1]
+	current.Size = w.partWritten
+	current.SHA256 = hex.EncodeToString(w.partHash.Sum(nil))
+
+	err := w.part.Close()
+	w.part = nil
+	if err != nil {
+		return fmt.Errorf("failed to close part %s: %w", current.Name, err)
+	}
+	return nil
+}
+
+// Close finishes the last part and writes the manifest
+func (w *writer) Close() error {
+	if w.part != nil {
+		if err := w.closePart(); err != nil {
+			return err
+		}
+	}
+
+	w.manifest.SHA256 = hex.EncodeToString(w.total.Sum(nil))
+	w.manifest.CreatedAt = time.Now()
+
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	out, err := w.store.NewWriter(w.jobName, filepath.Join(w.dir, ManifestName))
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return out.Close()
+}
+
+// ReadManifest loads the manifest of a split directory
+func ReadManifest(dir string) (Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version != manifestVersion {
+		return Manifest{}, fmt.Errorf("unsupported manifest version: %d", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// Join reassembles the artifact in a split directory into w, verifying every
+// part and the whole artifact against the manifest
+func Join(dir string, w io.Writer) (Manifest, error) {
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	total := sha256.New()
+	var size int64
+	for _, part := range manifest.Parts {
+		if err := copyPart(filepath.Join(dir, part.Name), part, io.MultiWriter(w, total)); err != nil {
+			return manifest, err
+		}
+		size += part.Size
+	}
+
+	if size != manifest.Size {
+		return manifest, fmt.Errorf("reassembled size %d does not match manifest size %d", size, manifest.Size)
+	}
+	if sum := hex.EncodeToString(total.Sum(nil)); sum != manifest.SHA256 {
+		return manifest, fmt.Errorf("checksum mismatch for %s", manifest.Name)
+	}
+	return manifest, nil
+}
+
+func copyPart(path string, part Part, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open part %s: %w", part.Name, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), f)
+	if err != nil {
+		return fmt.Errorf("failed to copy part %s: %w", part.Name, err)
+	}
+	if n != part.Size {
+		return fmt.Errorf("part %s has %d bytes, manifest expects %d", part.Name, n, part.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != part.SHA256 {
+		return fmt.Errorf("checksum mismatch for part %s", part.Name)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package split
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage/localfs"
+)
+
+func TestSplitAndJoin(t *testing.T) {
+	dir := t.TempDir()
+	s := New(localfs.New(config.LocalConfig{Directory: dir}), 1000)
+
+	data := make([]byte, 2500)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	w, err := s.NewWriter("myjob", "backup.sql")
+	require.NoError(t, err)
+	// Write in uneven chunks so writes cross part boundaries
+	for _, chunk := range [][]byte{data[:300], data[300:1700], data[1700:]} {
+		n, err := w.Write(chunk)
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	require.NoError(t, w.Close())
+
+	entries, err := s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "a split artifact is a single backup")
+	assert.Equal(t, "backup.sql.split", filepath.Base(entries[0].Key))
+
+	manifest, err := ReadManifest(entries[0].Key)
+	require.NoError(t, err)
+	assert.Equal(t, "backup.sql", manifest.Name)
+	assert.Equal(t, int64(2500), manifest.Size)
+	require.Len(t, manifest.Parts, 3)
+	assert.Equal(t, int64(1000), manifest.Parts[0].Size)
+	assert.Equal(t, int64(500), manifest.Parts[2].Size)
+
+	var joined bytes.Buffer
+	_, err = Join(entries[0].Key, &joined)
+	require.NoError(t, err)
+	assert.Equal(t, data, joined.Bytes())
+
+	// A corrupted part is detected
+	partPath := filepath.Join(entries[0].Key, manifest.Parts[1].Name)
+	corrupted := append([]byte{}, data[1000:2000]...)
+	corrupted[0] ^= 0xff
+	require.NoError(t, os.WriteFile(partPath, corrupted, 0644))
+
+	_, err = Join(entries[0].Key, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "checksum mismatch for part part-00002")
+}
+
+func TestSplitExactMultiple(t *testing.T) {
+	dir := t.TempDir()
+	s := New(localfs.New(config.LocalConfig{Directory: dir}), 100)
+
+	w, err := s.NewWriter("myjob", "backup.tar.gz")
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 200))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	manifest, err := ReadManifest(filepath.Join(dir, "myjob", "backup.tar.gz.split"))
+	require.NoError(t, err)
+	assert.Len(t, manifest.Parts, 2, "no empty trailing part is created")
+}
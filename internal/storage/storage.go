@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"io"
 	"time"
 )
@@ -11,9 +12,32 @@ type BackupEntry struct {
 	Size    int64
 }
 
+// UploadResult summarizes the upload of a job's staged backups to remote
+// storage
+type UploadResult struct {
+	Entries  []string // Local backups that are now fully stored in the bucket
+	Uploaded int      // Files sent to the bucket
+	Copied   int      // Files identical to the previous backup, copied on the server instead
+	Skipped  int      // Files already present in the bucket with the same checksum
+	Bytes    int64    // Bytes sent to the bucket
+}
+
+// DownloadResult summarizes the download of a job's backups from remote
+// storage
+type DownloadResult struct {
+	Entries    []string // Backups that are now fully stored locally
+	Downloaded int      // Files fetched from the bucket
+	Skipped    int      // Files already present locally with the same checksum
+	Bytes      int64    // Bytes fetched from the bucket
+}
+
 type Storage interface {
 	NewWriter(jobName, fileName string) (io.WriteCloser, error)
 	NewDir(jobName, dirName string) (string, error)
-	List(jobName string) ([]BackupEntry, error)
-	Delete(entry BackupEntry) error
+	List(ctx context.Context, jobName string) ([]BackupEntry, error)
+	Delete(ctx context.Context, entry BackupEntry) error
+	// MoveToTrash takes a backup out of List until it is deleted from the trash
+	MoveToTrash(ctx context.Context, jobName string, entry BackupEntry) error
+	// ListTrash lists trashed backups, ModTime is the time they were trashed
+	ListTrash(ctx context.Context, jobName string) ([]BackupEntry, error)
 }
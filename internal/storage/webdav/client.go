@@ -0,0 +1,235 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resource is the subset of the properties of a file or collection the
+// storage works with
+type resource struct {
+	Path         string // Relative to the base URL, without a trailing slash
+	Collection   bool
+	Size         int64
+	LastModified time.Time
+}
+
+// client speaks the WebDAV methods the storage needs to one server
+type client struct {
+	base     *url.URL // Collection every path is relative to, ending with a slash
+	username string
+	password string
+	http     *http.Client
+}
+
+func newClient(rawURL, username, password string) (*client, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid webdav url: %s", rawURL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	base.RawPath = ""
+	return &client{base: base, username: username, password: password, http: &http.Client{}}, nil
+}
+
+// url returns the absolute URL of a path relative to the base collection
+func (c *client) url(rel string) string {
+	u := *c.base
+	u.Path = c.base.Path + strings.TrimPrefix(rel, "/")
+	return u.String()
+}
+
+func (c *client) do(ctx context.Context, method, target string, body io.Reader, header http.Header) (*http.Response, error) {
+	return c.send(ctx, method, target, body, -1, header)
+}
+
+// send is do with the length of the body, -1 when it is not known
+func (c *client) send(ctx context.Context, method, target string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return c.http.Do(req)
+}
+
+// expect sends a request and fails unless the response has one of the
+// accepted status codes
+func (c *client) expect(ctx context.Context, method, target string, body io.Reader, header http.Header, accepted ...int) error {
+	resp, err := c.do(ctx, method, target, body, header)
+	if err != nil {
+		return fmt.Errorf("webdav %s %s failed: %w", method, target, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	for _, code := range accepted {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("webdav %s %s failed: %s", method, target, resp.Status)
+}
+
+// mkcol creates a collection, one that already exists is fine
+func (c *client) mkcol(ctx context.Context, rel string) error {
+	return c.expect(ctx, "MKCOL", c.url(rel), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+}
+
+// mkcolAll creates a collection and its missing parents
+func (c *client) mkcolAll(ctx context.Context, rel string) error {
+	rel = strings.Trim(rel, "/")
+	for i := range rel {
+		if rel[i] == '/' {
+			if err := c.mkcol(ctx, rel[:i]+"/"); err != nil {
+				return err
+			}
+		}
+	}
+	return c.mkcol(ctx, rel+"/")
+}
+
+// put uploads a file, size is -1 for a stream of unknown length
+func (c *client) put(ctx context.Context, target string, body io.Reader, size int64, header http.Header) error {
+	resp, err := c.send(ctx, http.MethodPut, target, body, size, header)
+	if err != nil {
+		return fmt.Errorf("webdav PUT %s failed: %w", target, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webdav PUT %s failed: %s", target, resp.Status)
+	}
+	return nil
+}
+
+// delete removes a file, or a collection with everything in it. One that is
+// already gone is fine.
+func (c *client) delete(ctx context.Context, rel string) error {
+	return c.expect(ctx, http.MethodDelete, c.url(rel), nil, nil, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+// move renames a file or collection on the server
+func (c *client) move(ctx context.Context, from, to string) error {
+	header := http.Header{"Destination": {c.url(to)}, "Overwrite": {"F"}}
+	return c.expect(ctx, "MOVE", c.url(from), nil, header, http.StatusCreated, http.StatusNoContent)
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// list returns the members of a collection, without the collection itself,
+// and false when it does not exist
+func (c *client) list(ctx context.Context, rel string) ([]resource, bool, error) {
+	rel = strings.Trim(rel, "/") + "/"
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := c.do(ctx, "PROPFIND", c.url(rel), strings.NewReader(propfindBody), header)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list %s: %w", rel, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, false, fmt.Errorf("failed to list %s: %s", rel, resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, false, fmt.Errorf("failed to read the listing of %s: %w", rel, err)
+	}
+
+	self := strings.TrimSuffix(rel, "/")
+	var resources []resource
+	for _, r := range ms.Responses {
+		p, err := c.relative(r.Href)
+		if err != nil || p == self {
+			continue
+		}
+		res := resource{Path: p}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			res.Collection = res.Collection || ps.Prop.ResourceType.Collection != nil
+			if n, err := strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err == nil {
+				res.Size = n
+			}
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				res.LastModified = t
+			}
+		}
+		resources = append(resources, res)
+	}
+	return resources, true, nil
+}
+
+// walk returns every file below a collection
+func (c *client) walk(ctx context.Context, rel string) ([]resource, error) {
+	members, _, err := c.list(ctx, rel)
+	if err != nil {
+		return nil, err
+	}
+	var files []resource
+	for _, m := range members {
+		if !m.Collection {
+			files = append(files, m)
+			continue
+		}
+		nested, err := c.walk(ctx, m.Path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, nested...)
+	}
+	return files, nil
+}
+
+// relative turns an href of a listing into a path relative to the base
+// collection
+func (c *client) relative(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	p := path.Clean("/" + u.Path)
+	base := strings.TrimSuffix(c.base.Path, "/")
+	if p != base && !strings.HasPrefix(p, base+"/") {
+		return "", fmt.Errorf("%s is outside of %s", href, c.base.Path)
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(p, base), "/"), nil
+}
@@ -0,0 +1,183 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+// Upload stores every backup in a job's local staging directory on the
+// server. Files already on the server with the same size, left by an earlier
+// attempt, are skipped. Files larger than the chunk size are sent in chunks
+// when a chunk URL is configured.
+func (s *Storage) Upload(ctx context.Context, jobName, localDir string) (storage.UploadResult, error) {
+	var result storage.UploadResult
+
+	entries, err := os.ReadDir(localDir)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to read staging directory: %w", err)
+	}
+
+	local := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return result, err
+		}
+		local = append(local, info)
+	}
+	if len(local) == 0 {
+		return result, nil
+	}
+	sort.Slice(local, func(i, j int) bool {
+		return local[i].ModTime().Before(local[j].ModTime())
+	})
+
+	if err := s.client.mkcolAll(ctx, jobDir(jobName)); err != nil {
+		return result, err
+	}
+	members, _, err := s.client.list(ctx, jobDir(jobName))
+	if err != nil {
+		return result, err
+	}
+	remote := make(map[string]resource, len(members))
+	for _, m := range members {
+		remote[m.Path] = m
+	}
+
+	for _, info := range local {
+		localPath := filepath.Join(localDir, info.Name())
+		rel := jobDir(jobName) + info.Name()
+
+		if !info.IsDir() {
+			existing, found := remote[rel]
+			if err := s.syncFile(ctx, localPath, rel, info.Size(), found && !existing.Collection && existing.Size == info.Size(), &result); err != nil {
+				return result, err
+			}
+			result.Entries = append(result.Entries, info.Name())
+			continue
+		}
+
+		uploaded := make(map[string]int64)
+		if existing, found := remote[rel]; found && existing.Collection {
+			files, err := s.client.walk(ctx, rel)
+			if err != nil {
+				return result, err
+			}
+			for _, f := range files {
+				uploaded[f.Path] = f.Size
+			}
+		}
+		err := filepath.WalkDir(localPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(localDir, p)
+			if err != nil {
+				return err
+			}
+			target := jobDir(jobName) + filepath.ToSlash(relPath)
+			if d.IsDir() {
+				return s.client.mkcol(ctx, target+"/")
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size, found := uploaded[target]
+			return s.syncFile(ctx, p, target, fi.Size(), found && size == fi.Size(), &result)
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Entries = append(result.Entries, info.Name())
+	}
+
+	return result, nil
+}
+
+// syncFile uploads a local file to rel unless it is already there
+func (s *Storage) syncFile(ctx context.Context, localPath, rel string, size int64, present bool, result *storage.UploadResult) error {
+	if present {
+		result.Skipped++
+		return nil
+	}
+
+	start := time.Now()
+	var err error
+	if s.chunks != nil && size > s.chunkSize {
+		err = s.uploadChunked(ctx, localPath, rel, size)
+	} else {
+		err = s.uploadFile(ctx, localPath, rel, size)
+	}
+	if err != nil {
+		s.metrics.RecordUpload(backendName, 0, time.Since(start), err)
+		return err
+	}
+	s.metrics.RecordUpload(backendName, size, time.Since(start), nil)
+	result.Uploaded++
+	result.Bytes += size
+	return nil
+}
+
+func (s *Storage) uploadFile(ctx context.Context, localPath, rel string, size int64) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer f.Close()
+	return s.client.put(ctx, s.client.url(rel), f, size, nil)
+}
+
+// uploadChunked sends a file in chunks with the Nextcloud chunked upload
+// protocol: the chunks are put into a collection of their own in the uploads
+// collection, which is then moved to the file's place and assembled by the
+// server. A failed upload removes its chunks.
+func (s *Storage) uploadChunked(ctx context.Context, localPath, rel string, size int64) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer f.Close()
+
+	transfer := fmt.Sprintf("backmeup-%d/", time.Now().UnixNano())
+	header := http.Header{
+		"Destination":     {s.client.url(rel)},
+		"Oc-Total-Length": {strconv.FormatInt(size, 10)},
+	}
+	if err := s.chunks.expect(ctx, "MKCOL", s.chunks.url(transfer), nil, header, http.StatusCreated); err != nil {
+		return fmt.Errorf("failed to start the chunked upload of %s: %w", rel, err)
+	}
+
+	err = func() error {
+		for i, offset := 1, int64(0); offset < size; i, offset = i+1, offset+s.chunkSize {
+			n := min(s.chunkSize, size-offset)
+			if err := s.chunks.put(ctx, s.chunks.url(transfer+strconv.Itoa(i)), io.NewSectionReader(f, offset, n), n, header); err != nil {
+				return err
+			}
+		}
+		assemble := http.Header{"Destination": header["Destination"], "Oc-Total-Length": header["Oc-Total-Length"], "Overwrite": {"T"}}
+		return s.chunks.expect(ctx, "MOVE", s.chunks.url(transfer+".file"), nil, assemble, http.StatusCreated, http.StatusNoContent)
+	}()
+	if err != nil {
+		s.chunks.delete(context.WithoutCancel(ctx), transfer)
+		return fmt.Errorf("chunked upload of %s failed: %w", rel, err)
+	}
+	return nil
+}
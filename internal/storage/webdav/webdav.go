@@ -0,0 +1,215 @@
+// Package webdav keeps backups on a WebDAV server, such as Nextcloud or
+// ownCloud, below <url>/<job>/
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+)
+
+const (
+	backendName    = "webdav"
+	trashDirName   = ".trash"
+	trashTimestamp = "20060102-150405"
+
+	defaultChunkSize = 100 << 20
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+// Storage keeps backups in a WebDAV collection under <job>/. A backup is
+// either a single file or a collection below <job>/<name>/.
+type Storage struct {
+	client    *client
+	chunks    *client // Nextcloud uploads collection, nil without chunked uploads
+	chunkSize int64
+	metrics   *storage.Metrics
+}
+
+// New creates a WebDAV storage from the configuration. Uploads are recorded
+// in metrics as the "webdav" backend when it is not nil.
+func New(cfg config.WebDAVConfig, metrics *storage.Metrics) (*Storage, error) {
+	c, err := newClient(cfg.URL, cfg.Username, cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+	s := &Storage{client: c, chunkSize: defaultChunkSize, metrics: metrics}
+
+	if cfg.ChunkURL != "" {
+		if s.chunks, err = newClient(cfg.ChunkURL, cfg.Username, cfg.Password); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ChunkSize != "" {
+		if s.chunkSize, err = config.ParseSize(cfg.ChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func jobDir(jobName string) string {
+	return jobName + "/"
+}
+
+// NewWriter streams a backup straight to the server
+func (s *Storage) NewWriter(jobName, fileName string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	w := &streamWriter{pipe: pw, done: make(chan error, 1)}
+
+	go func() {
+		ctx := context.Background()
+		err := s.client.mkcolAll(ctx, jobDir(jobName))
+		if err == nil {
+			err = s.client.put(ctx, s.client.url(jobDir(jobName)+fileName), pr, -1, nil)
+		}
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return storage.NewTimedWriter(w, func(bytes int64, elapsed time.Duration, err error) {
+		s.metrics.RecordUpload(backendName, bytes, elapsed, err)
+	}), nil
+}
+
+// NewDir is not supported, executors that write directories use the local
+// staging area, which is uploaded with Upload
+func (s *Storage) NewDir(jobName, dirName string) (string, error) {
+	return "", fmt.Errorf("webdav storage cannot create directory %s/%s, stage it locally and upload it", jobName, dirName)
+}
+
+func (s *Storage) List(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	return s.entries(ctx, jobDir(jobName))
+}
+
+// Delete removes a file, or a directory backup with everything in it
+func (s *Storage) Delete(ctx context.Context, entry storage.BackupEntry) error {
+	return s.client.delete(ctx, entry.Key)
+}
+
+// MoveToTrash moves a backup below <job>/.trash/ on the server, with the time
+// it was trashed as a prefix
+func (s *Storage) MoveToTrash(ctx context.Context, jobName string, entry storage.BackupEntry) error {
+	trashDir := jobDir(jobName) + trashDirName + "/"
+	if err := s.client.mkcol(ctx, trashDir); err != nil {
+		return fmt.Errorf("failed to create trash directory: %w", err)
+	}
+
+	target := trashDir + time.Now().Format(trashTimestamp) + "_" + path.Base(entry.Key)
+	if strings.HasSuffix(entry.Key, "/") {
+		target += "/"
+	}
+	if err := s.client.move(ctx, entry.Key, target); err != nil {
+		return fmt.Errorf("failed to move backup to trash: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) ListTrash(ctx context.Context, jobName string) ([]storage.BackupEntry, error) {
+	entries, err := s.entries(ctx, jobDir(jobName)+trashDirName+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := make([]storage.BackupEntry, 0, len(entries))
+	for _, entry := range entries {
+		stamp, _, ok := strings.Cut(path.Base(entry.Key), "_")
+		if !ok {
+			continue
+		}
+		trashedAt, err := time.ParseInLocation(trashTimestamp, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		entry.ModTime = trashedAt
+		trashed = append(trashed, entry)
+	}
+	return trashed, nil
+}
+
+// RenameJob moves the collection of a job, with its trash, to its new name
+// and returns how many backups were moved. Nothing is moved when the new
+// name already has backups.
+func (s *Storage) RenameJob(ctx context.Context, from, to string) (int, error) {
+	existing, found, err := s.client.list(ctx, jobDir(to))
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) > 0 {
+		return 0, fmt.Errorf("cannot move the backups of job %s, %s already has backups", from, to)
+	}
+	entries, err := s.List(ctx, from)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	if found {
+		if err := s.client.delete(ctx, jobDir(to)); err != nil {
+			return 0, err
+		}
+	}
+	if err := s.client.move(ctx, jobDir(from), jobDir(to)); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// entries lists the backups in a collection. Directory backups are sized as
+// the sum of their files and dated by the newest one.
+func (s *Storage) entries(ctx context.Context, dir string) ([]storage.BackupEntry, error) {
+	members, _, err := s.client.list(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]storage.BackupEntry, 0, len(members))
+	for _, m := range members {
+		if strings.HasPrefix(path.Base(m.Path), ".") {
+			continue
+		}
+		if !m.Collection {
+			entries = append(entries, storage.BackupEntry{Key: m.Path, ModTime: m.LastModified, Size: m.Size})
+			continue
+		}
+
+		files, err := s.client.walk(ctx, m.Path)
+		if err != nil {
+			return nil, err
+		}
+		entry := storage.BackupEntry{Key: m.Path + "/", ModTime: m.LastModified}
+		for _, f := range files {
+			entry.Size += f.Size
+			if f.LastModified.After(entry.ModTime) {
+				entry.ModTime = f.LastModified
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// streamWriter feeds an upload running in the background and reports its
+// result on Close
+type streamWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *streamWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
@@ -0,0 +1,270 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thitiph0n/backmeup/internal/config"
+	"github.com/thitiph0n/backmeup/internal/storage"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+// server is a WebDAV server below /dav/files/ that also takes Nextcloud
+// chunked uploads below /dav/uploads/
+type server struct {
+	files *xwebdav.Handler
+
+	mu     sync.Mutex
+	chunks map[string]map[int][]byte // Chunks of each transfer by number
+	puts   int                       // Files put directly
+}
+
+func newServer(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+	s := &server{
+		files:  &xwebdav.Handler{Prefix: "/dav/files", FileSystem: xwebdav.NewMemFS(), LockSystem: xwebdav.NewMemLS()},
+		chunks: make(map[string]map[int][]byte),
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, _ := r.BasicAuth()
+	if user != "backup" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	transfer, chunk, isUpload := strings.Cut(strings.TrimPrefix(r.URL.Path, "/dav/uploads/backup/"), "/")
+	if !strings.HasPrefix(r.URL.Path, "/dav/uploads/") {
+		if r.Method == http.MethodPut {
+			s.mu.Lock()
+			s.puts++
+			s.mu.Unlock()
+		}
+		s.files.ServeHTTP(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == "MKCOL":
+		s.chunks[transfer] = make(map[int][]byte)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && isUpload:
+		n, _ := strconv.Atoi(chunk)
+		data, _ := io.ReadAll(r.Body)
+		s.chunks[transfer][n] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "MOVE" && chunk == ".file":
+		var numbers []int
+		for n := range s.chunks[transfer] {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var file bytes.Buffer
+		for _, n := range numbers {
+			file.Write(s.chunks[transfer][n])
+		}
+		delete(s.chunks, transfer)
+
+		dest, _ := url.Parse(r.Header.Get("Destination"))
+		put := httptest.NewRequest(http.MethodPut, dest.Path, &file)
+		rec := httptest.NewRecorder()
+		s.files.ServeHTTP(rec, put)
+		w.WriteHeader(rec.Code)
+	case r.Method == http.MethodDelete:
+		delete(s.chunks, transfer)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newStorage(t *testing.T, ts *httptest.Server, cfg config.WebDAVConfig) *Storage {
+	t.Helper()
+	cfg.URL = ts.URL + "/dav/files/backmeup"
+	cfg.Username, cfg.Password = "backup", "secret"
+	s, err := New(cfg, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.client.mkcol(context.Background(), ""))
+	return s
+}
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+	require.NoError(t, os.WriteFile(p, []byte(data), 0644))
+}
+
+func TestUploadListDelete(t *testing.T) {
+	srv, ts := newServer(t)
+	s := newStorage(t, ts, config.WebDAVConfig{})
+	ctx := context.Background()
+
+	staging := t.TempDir()
+	writeFile(t, filepath.Join(staging, "pg_backup_20250101-000000.sql"), "dump")
+	writeFile(t, filepath.Join(staging, "minio_backup_20250101-000000", "bucket", "a.txt"), "aa")
+	writeFile(t, filepath.Join(staging, "minio_backup_20250101-000000", "bucket", "sub", "b.txt"), "bbb")
+
+	result, err := s.Upload(ctx, "myjob", staging)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"minio_backup_20250101-000000", "pg_backup_20250101-000000.sql"}, sorted(result.Entries))
+	assert.Equal(t, 3, result.Uploaded)
+	assert.Equal(t, int64(9), result.Bytes)
+
+	result, err = s.Upload(ctx, "myjob", staging)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Skipped, "files already on the server are not sent again")
+	assert.Equal(t, 3, srv.puts)
+
+	entries, err := s.List(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "myjob/minio_backup_20250101-000000/", entries[0].Key)
+	assert.Equal(t, int64(5), entries[0].Size)
+	assert.Equal(t, "myjob/pg_backup_20250101-000000.sql", entries[1].Key)
+	assert.Equal(t, int64(4), entries[1].Size)
+	assert.WithinDuration(t, time.Now(), entries[1].ModTime, time.Minute)
+
+	require.NoError(t, s.Delete(ctx, entries[0]))
+	entries, err = s.List(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "myjob/pg_backup_20250101-000000.sql", entries[0].Key)
+}
+
+func TestListMissingJob(t *testing.T) {
+	_, ts := newServer(t)
+	s := newStorage(t, ts, config.WebDAVConfig{})
+
+	entries, err := s.List(context.Background(), "nothing")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestMoveToTrash(t *testing.T) {
+	_, ts := newServer(t)
+	s := newStorage(t, ts, config.WebDAVConfig{})
+	ctx := context.Background()
+
+	staging := t.TempDir()
+	writeFile(t, filepath.Join(staging, "mirror_20250101-000000", "a.txt"), "aa")
+	_, err := s.Upload(ctx, "myjob", staging)
+	require.NoError(t, err)
+
+	entries, err := s.List(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NoError(t, s.MoveToTrash(ctx, "myjob", entries[0]))
+
+	entries, err = s.List(ctx, "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	trashed, err := s.ListTrash(ctx, "myjob")
+	require.NoError(t, err)
+	require.Len(t, trashed, 1)
+	assert.True(t, strings.HasPrefix(trashed[0].Key, "myjob/.trash/"))
+	assert.Equal(t, int64(2), trashed[0].Size)
+	assert.WithinDuration(t, time.Now(), trashed[0].ModTime, 2*time.Second)
+
+	require.NoError(t, s.Delete(ctx, trashed[0]))
+	trashed, err = s.ListTrash(ctx, "myjob")
+	require.NoError(t, err)
+	assert.Empty(t, trashed)
+}
+
+func TestUpload_Chunked(t *testing.T) {
+	srv, ts := newServer(t)
+	s := newStorage(t, ts, config.WebDAVConfig{ChunkURL: ts.URL + "/dav/uploads/backup", ChunkSize: "1KB"})
+	ctx := context.Background()
+
+	data := strings.Repeat("0123456789", 250)
+	staging := t.TempDir()
+	writeFile(t, filepath.Join(staging, "pg_backup_20250101-000000.sql"), data)
+	writeFile(t, filepath.Join(staging, "small_20250101-000000.sql"), "small")
+
+	result, err := s.Upload(ctx, "myjob", staging)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Uploaded)
+	assert.Equal(t, 1, srv.puts, "only the small file is put directly")
+	assert.Empty(t, srv.chunks, "the chunks are assembled")
+
+	resp, err := s.client.do(ctx, http.MethodGet, s.client.url("myjob/pg_backup_20250101-000000.sql"), nil, nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(got))
+}
+
+func TestNewWriter(t *testing.T) {
+	_, ts := newServer(t)
+	metrics := storage.NewMetrics()
+	s := newStorage(t, ts, config.WebDAVConfig{})
+	s.metrics = metrics
+
+	w, err := s.NewWriter("myjob", "dump_20250101-000000.sql")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("streamed"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	entries, err := s.List(context.Background(), "myjob")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(8), entries[0].Size)
+	assert.Equal(t, int64(8), metrics.GetAll()["webdav"].UploadedBytes)
+}
+
+func TestRenameJob(t *testing.T) {
+	_, ts := newServer(t)
+	s := newStorage(t, ts, config.WebDAVConfig{})
+	ctx := context.Background()
+
+	staging := t.TempDir()
+	writeFile(t, filepath.Join(staging, "a_20250101-000000.sql"), "a")
+	_, err := s.Upload(ctx, "old", staging)
+	require.NoError(t, err)
+
+	moved, err := s.RenameJob(ctx, "old", "new")
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+
+	entries, err := s.List(ctx, "new")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a_20250101-000000.sql", path.Base(entries[0].Key))
+	entries, err = s.List(ctx, "old")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestNew_InvalidURL(t *testing.T) {
+	_, err := New(config.WebDAVConfig{URL: "not a url"}, nil)
+	assert.Error(t, err)
+}
+
+func sorted(names []string) []string {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	return names
+}